@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/sys/unix"
@@ -32,6 +33,8 @@ import (
 var (
 	socketpath  string
 	cloudconfig string
+
+	cloudConfigReloadInterval time.Duration
 )
 
 func init() {
@@ -64,7 +67,7 @@ func main() {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			sigchan := make(chan os.Signal, 1)
 			signal.Notify(sigchan, unix.SIGTERM, unix.SIGINT)
-			err := server.Run(cloudconfig, socketpath, sigchan)
+			err := server.Run(cloudconfig, socketpath, cloudConfigReloadInterval, sigchan)
 			return err
 		},
 	}
@@ -77,6 +80,8 @@ func main() {
 	cmd.PersistentFlags().StringVar(&cloudconfig, "cloud-config", "", "Barbican KMS Plugin cloud config")
 	cmd.MarkPersistentFlagRequired("cloud-config")
 
+	cmd.PersistentFlags().DurationVar(&cloudConfigReloadInterval, "cloud-config-reload-interval", time.Minute, "How often to check --cloud-config for changes, e.g. a rotated Secret, and reauthenticate with the new credentials without a restart. 0 disables it")
+
 	if err := cmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "%s", err.Error())
 		os.Exit(1)
@@ -20,19 +20,84 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder"
+	"k8s.io/cloud-provider-openstack/pkg/util/leaderelection"
+	"k8s.io/cloud-provider-openstack/pkg/util/supportbundle"
 	"k8s.io/component-base/logs"
 	"k8s.io/klog"
 )
 
 var (
 	endpoint    string
+	v0Endpoint  string
 	nodeID      string
 	cloudconfig string
+	osCloud     string
 	cluster     string
+	runMode     string
+	attachMode  string
+	multipath   bool
+
+	cloudConfigReloadInterval time.Duration
+
+	leaderElection = leaderelection.NewConfig()
+
+	topologyKey            string
+	legacyTopologyKey      string
+	legacyTopologyKeyUntil string
+	topologyRegion         bool
+
+	adoptUntaggedVolumes bool
+	strictIdempotency    bool
+	encryptionBoundary   bool
+	validateParameters   bool
+	snapshotBackups      bool
+
+	creatingDeadline   time.Duration
+	staleAttachments   time.Duration
+	ephemeralCleanup   time.Duration
+	kubeconfig         string
+	metricsVolumeTypes []string
+	metricsAddress     string
+	httpEndpoint       string
+	namespaceQuota     string
+
+	metadataHints []string
+
+	growOnStageThreshold string
+	maxVolumesPerNode    int64
+
+	kubeletRegistrationDir    string
+	kubeletRegistrationPath   string
+	registrationHealthAddress string
+
+	placementWebhookURL      string
+	placementWebhookTimeout  time.Duration
+	placementWebhookFailOpen bool
+
+	supportBundleAddress  string
+	supportBundleLogLines int
+	supportBundleOutput   string
+
+	maxConcurrentOperations int
+	debugTLSCertFile        string
+	debugTLSKeyFile         string
+	debugClientCAFile       string
+
+	logFormat           string
+	metadataSearchOrder string
+
+	mountTimeout      time.Duration
+	deviceScanTimeout time.Duration
+	openstackTimeout  time.Duration
 )
 
 func init() {
@@ -69,19 +134,113 @@ func main() {
 		},
 	}
 
+	leaderElection.AddFlags(flag.CommandLine)
 	cmd.Flags().AddGoFlagSet(flag.CommandLine)
 
-	cmd.PersistentFlags().StringVar(&nodeID, "nodeid", "", "node id")
-	cmd.MarkPersistentFlagRequired("nodeid")
+	cmd.PersistentFlags().StringVar(&nodeID, "nodeid", "", "node id, required unless --run-mode is external")
 
-	cmd.PersistentFlags().StringVar(&endpoint, "endpoint", "", "CSI endpoint")
-	cmd.MarkPersistentFlagRequired("endpoint")
+	cmd.PersistentFlags().StringVar(&endpoint, "endpoint", "", "CSI endpoint, required")
+	cmd.PersistentFlags().StringVar(&v0Endpoint, "csi-v0-endpoint", "", "Deprecated CSI endpoint serving the CSI v0 services, for the sidecars and kubelets still pinned to CSI 0.3, e.g. csi-provisioner v0.4.x, while they are upgraded. Disabled when empty")
 
-	cmd.PersistentFlags().StringVar(&cloudconfig, "cloud-config", "", "CSI driver cloud config")
-	cmd.MarkPersistentFlagRequired("cloud-config")
+	cmd.PersistentFlags().StringVar(&cloudconfig, "cloud-config", "", "CSI driver cloud config, required unless --os-cloud is set")
+	cmd.PersistentFlags().StringVar(&osCloud, "os-cloud", "", "Cloud of clouds.yaml and secure.yaml to read the credentials missing in --cloud-config from, as found by the openstack CLI. Defaults to OS_CLOUD")
+	cmd.PersistentFlags().DurationVar(&cloudConfigReloadInterval, "cloud-config-reload-interval", time.Minute, "How often to check --cloud-config and the clouds.yaml and secure.yaml it reads for changes, e.g. a rotated Secret, and reauthenticate with the new credentials without a restart. 0 disables it")
 
 	cmd.PersistentFlags().StringVar(&cluster, "cluster", "", "The identifier of the cluster that the plugin is running in.")
 
+	cmd.PersistentFlags().StringVar(&metadataSearchOrder, "metadata-search-order", "", "Where the node plugin finds the instance it runs on, a comma separated list of configDrive, metadataService and nova, tried in order. nova looks up the instance named after --nodeid in Nova, and is only used when listed. Defaults to the search-order of the [Metadata] section of the cloud config, configDrive,metadataService without one")
+	cmd.PersistentFlags().StringVar(&runMode, "run-mode", cinder.RunModeAll, "Services to run: \"all\" serves the controller and node plugins on an OpenStack instance, \"external\" serves the controller plugin only and never uses the local metadata service")
+
+	cmd.PersistentFlags().StringVar(&attachMode, "attach-mode", cinder.AttachModeNova, "How volumes get to the nodes: \"nova\" attaches them to the node instances with Nova, \"connector\" has the node plugin connect them over iSCSI or Fibre Channel itself, e.g. on Ironic bare-metal nodes. The controller and node plugins must use the same mode")
+	cmd.PersistentFlags().BoolVar(&multipath, "multipath", false, "Connect the volumes through all the paths their backend serves with the multipath device of multipathd. Only used with --attach-mode connector")
+
+	cmd.PersistentFlags().StringVar(&topologyKey, "topology-key", cinder.DefaultTopologyKey, "Topology key the availability zone of nodes and volumes is reported with")
+	cmd.PersistentFlags().StringVar(&legacyTopologyKey, "legacy-topology-key", "", "Previous topology key, which nodes keep reporting next to --topology-key during a migration so that the PersistentVolumes pinned to it stay schedulable")
+	cmd.PersistentFlags().StringVar(&legacyTopologyKeyUntil, "legacy-topology-key-until", "", "Stop reporting --legacy-topology-key at this RFC 3339 time, e.g. 2019-06-01T00:00:00Z. It is reported for as long as it is set when empty")
+	cmd.PersistentFlags().BoolVar(&topologyRegion, "topology-region", false, "Report the region of the cloud config of nodes in their topology, for the controller to create their volumes in that region, one of its extra-region")
+
+	cmd.PersistentFlags().BoolVar(&adoptUntaggedVolumes, "adopt-untagged-volumes", false, "Allow CreateVolume to reuse an existing volume with the requested name but no cluster metadata, for migrating volumes created by older releases")
+	cmd.PersistentFlags().BoolVar(&encryptionBoundary, "encryption-boundary", false, "Mark the snapshots of volumes of encrypted types, and refuse to restore them into unencrypted volume types unless the allowUnencryptedRestore StorageClass parameter is \"true\"")
+	cmd.PersistentFlags().BoolVar(&validateParameters, "validate-parameters", false, "Check the type and availability StorageClass parameters against the volume types and availability zones of the cloud")
+	cmd.PersistentFlags().BoolVar(&snapshotBackups, "snapshot-backups", false, "Allow VolumeSnapshotClasses to take Cinder backups instead of snapshots with the type parameter set to backup")
+	cmd.PersistentFlags().BoolVar(&strictIdempotency, "strict-idempotency", false, "Store the hash of the CreateVolume parameters in the volume metadata, and fail CreateVolume with AlreadyExists when a volume with the requested name was created with other parameters")
+
+	cmd.PersistentFlags().DurationVar(&creatingDeadline, "creating-deadline", 0, "Delete a volume of this cluster still creating after this long and create a new one on the next CreateVolume call. 0 disables it")
+	cmd.PersistentFlags().DurationVar(&staleAttachments, "stale-attachment-interval", 0, "How often the controller plugin checks for volumes of this cluster attached to instances Nova no longer has, e.g. of deleted nodes, and force-detaches them with Cinder, which only administrators may do by default. Only used with --run-mode=external, by the leader with --leader-elect. 0 disables it")
+	cmd.PersistentFlags().DurationVar(&ephemeralCleanup, "ephemeral-cleanup-interval", 0, "How often the controller plugin checks for the ephemeral volumes of this cluster created on instances Nova no longer has, e.g. of deleted nodes, and deletes them, force-detaching them first. Only used with --run-mode=external, by the leader with --leader-elect. 0 disables it")
+	cmd.PersistentFlags().StringVar(&httpEndpoint, "http-endpoint", "", "Address to serve a health check on /healthz for kubelet HTTP probes, e.g. :9808, checking the CSI endpoint and the services of the Probe call. Disabled when empty")
+	cmd.PersistentFlags().StringVar(&metricsAddress, "metrics-address", "", "Address to serve the Prometheus metrics on /metrics, e.g. :9810. Disabled when empty")
+	cmd.PersistentFlags().StringSliceVar(&metricsVolumeTypes, "metrics-volume-types", nil, "Volume types the volume metrics are labelled with, the other types are labelled \"other\" to bound the number of series")
+	cmd.PersistentFlags().StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig for recording events on PVCs with --creating-deadline, for --namespace-quota-configmap, for --leader-elect and for topology-report, the in-cluster config is used when empty")
+	cmd.PersistentFlags().StringVar(&namespaceQuota, "namespace-quota-configmap", "", "<namespace>/<name> of a ConfigMap of GiB limits on the capacity provisioned per namespace, keyed by namespace. Requires the external-provisioner --extra-create-metadata. Disabled when empty")
+
+	cmd.PersistentFlags().StringSliceVar(&metadataHints, "metadata-hints", nil, "Volume metadata keys StorageClasses may set with cinder.csi.openstack.org/<key> parameters, e.g. image_cache")
+
+	cmd.PersistentFlags().StringVar(&growOnStageThreshold, "grow-on-stage-threshold", "64Mi", "Grow the filesystem of a volume on NodeStageVolume when its device is larger by more than this, e.g. after a missed NodeExpandVolume. Disabled when empty")
+	cmd.PersistentFlags().DurationVar(&mountTimeout, "mount-timeout", 0, "How long the node plugin calls wait for a volume to be formatted, mounted or unmounted before failing with DeadlineExceeded, the operation goes on and the retry of the call waits for it. 0 waits as long as the call allows")
+	cmd.PersistentFlags().DurationVar(&deviceScanTimeout, "device-scan-timeout", 0, "How long NodeStageVolume waits for the device of a volume to show up, or to be connected with --attach-mode connector, before failing with DeadlineExceeded, as for --mount-timeout")
+	cmd.PersistentFlags().DurationVar(&openstackTimeout, "openstack-request-timeout", 0, "Fail the OpenStack API requests without a response after this long, e.g. 1m. 0 waits as long as the connection lasts")
+	cmd.PersistentFlags().Int64Var(&maxVolumesPerNode, "max-volumes-per-node", 0, "Maximum number of volumes the node plugin reports a node can have attached, for the scheduler to place pods accordingly. 0 detects it from the disk bus of the node, a negative value reports no maximum")
+
+	cmd.PersistentFlags().StringVar(&kubeletRegistrationDir, "kubelet-registration-dir", "", "Kubelet plugin registration directory, e.g. /var/lib/kubelet/plugins_registry. When set, the node plugin registers itself with kubelet, again whenever its registration socket disappears, instead of relying on the node-driver-registrar sidecar")
+	cmd.PersistentFlags().StringVar(&kubeletRegistrationPath, "kubelet-registration-path", "/var/lib/kubelet/plugins/cinder.csi.openstack.org/csi.sock", "Path of the CSI socket on the node, as passed to kubelet. Only used with --kubelet-registration-dir")
+	cmd.PersistentFlags().StringVar(&registrationHealthAddress, "registration-health-address", "", "Address to serve a health check failing while the node plugin is not registered with kubelet on, e.g. 127.0.0.1:9808. Only used with --kubelet-registration-dir")
+
+	cmd.PersistentFlags().StringVar(&placementWebhookURL, "placement-webhook-url", "", "URL of an optional webhook consulted for volume type and availability zone during CreateVolume")
+	cmd.PersistentFlags().DurationVar(&placementWebhookTimeout, "placement-webhook-timeout", 5*time.Second, "Timeout for placement webhook calls")
+	cmd.PersistentFlags().BoolVar(&placementWebhookFailOpen, "placement-webhook-fail-open", true, "Fall back to the built-in placement when the placement webhook cannot be reached")
+
+	cmd.PersistentFlags().StringVar(&supportBundleAddress, "support-bundle-address", "", "Address to serve the support bundle on, e.g. 127.0.0.1:9809. Disabled when empty")
+	cmd.PersistentFlags().IntVar(&supportBundleLogLines, "support-bundle-log-lines", 1000, "Number of recent log lines kept for the support bundle")
+	cmd.PersistentFlags().StringVar(&debugTLSCertFile, "debug-tls-cert-file", "", "Certificate to serve the support bundle and the other debug endpoints with mutual TLS, which allows serving them on other than a loopback address")
+	cmd.PersistentFlags().StringVar(&debugTLSKeyFile, "debug-tls-key-file", "", "Private key of --debug-tls-cert-file")
+	cmd.PersistentFlags().StringVar(&debugClientCAFile, "debug-client-ca-file", "", "CA the client certificates of the debug endpoints must be signed by. Required with --debug-tls-cert-file")
+
+	cmd.PersistentFlags().StringVar(&logFormat, "log-format", cinder.LogFormatText, "Format of the log lines of the RPCs, \"text\" logs them with the other lines prefixed with their method, request ID and volume, \"json\" writes them to stderr as JSON objects with these fields")
+
+	cmd.PersistentFlags().IntVar(&maxConcurrentOperations, "max-concurrent-operations", 0, "Maximum number of controller operations run at a time, the others are queued and can be listed and cancelled on the debug endpoints. 0 does not limit them")
+
+	supportBundleCmd := &cobra.Command{
+		Use:   "support-bundle",
+		Short: "Download the support bundle of a running driver",
+		Long:  "Download the support bundle served by a running driver on --support-bundle-address into a tar.gz. Secrets are masked.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if supportBundleAddress == "" {
+				klog.Fatalf("--support-bundle-address of the running driver is required")
+			}
+			if err := supportbundle.Download(supportBundleAddress, supportBundleOutput); err != nil {
+				klog.Fatalf("%v", err)
+			}
+			fmt.Printf("Support bundle written to %s\n", supportBundleOutput)
+		},
+	}
+	supportBundleCmd.Flags().StringVar(&supportBundleOutput, "output", "support-bundle.tar.gz", "File to write the support bundle to")
+	cmd.AddCommand(supportBundleCmd)
+
+	topologyReportCmd := &cobra.Command{
+		Use:   "topology-report",
+		Short: "List the PersistentVolumes still pinned to the legacy topology key",
+		Long:  "List the PersistentVolumes of the driver whose node affinity uses --legacy-topology-key. Once there is none left, nodes do not need to report it anymore.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if legacyTopologyKey == "" {
+				klog.Fatalf("--legacy-topology-key is required")
+			}
+			client, err := buildKubeClient(kubeconfig)
+			if err != nil {
+				klog.Fatalf("%v", err)
+			}
+			names, err := cinder.PersistentVolumesWithTopologyKey(client, legacyTopologyKey)
+			if err != nil {
+				klog.Fatalf("%v", err)
+			}
+			for _, name := range names {
+				fmt.Println(name)
+			}
+			fmt.Fprintf(os.Stderr, "%d PersistentVolumes pinned to %s\n", len(names), legacyTopologyKey)
+		},
+	}
+	cmd.AddCommand(topologyReportCmd)
+
 	logs.InitLogs()
 	defer logs.FlushLogs()
 
@@ -94,6 +253,126 @@ func main() {
 }
 
 func handle() {
+	if osCloud == "" {
+		osCloud = os.Getenv("OS_CLOUD")
+	}
+	if endpoint == "" || (cloudconfig == "" && osCloud == "") {
+		klog.Fatalf("--endpoint and --cloud-config or --os-cloud are required")
+	}
+	if nodeID == "" && runMode != cinder.RunModeExternal {
+		klog.Fatalf("--nodeid is required when --run-mode is %q", runMode)
+	}
+
+	if err := cinder.SetLogFormat(logFormat); err != nil {
+		klog.Fatalf("Invalid --log-format: %v", err)
+	}
+
 	d := cinder.NewDriver(nodeID, endpoint, cluster, cloudconfig)
+	d.SetCloud(osCloud)
+	d.SetV0Endpoint(v0Endpoint)
+	d.SetConfigReloadInterval(cloudConfigReloadInterval)
+	if err := d.SetMetadataSearchOrder(metadataSearchOrder); err != nil {
+		klog.Fatalf("Invalid --metadata-search-order: %v", err)
+	}
+	if err := d.SetRunMode(runMode); err != nil {
+		klog.Fatalf("Invalid run mode: %v", err)
+	}
+	if err := d.SetAttachMode(attachMode, multipath); err != nil {
+		klog.Fatalf("Invalid attach mode: %v", err)
+	}
+	var until time.Time
+	if legacyTopologyKeyUntil != "" {
+		var err error
+		until, err = time.Parse(time.RFC3339, legacyTopologyKeyUntil)
+		if err != nil {
+			klog.Fatalf("Invalid --legacy-topology-key-until: %v", err)
+		}
+	}
+	if err := d.SetTopologyKeys(topologyKey, legacyTopologyKey, until); err != nil {
+		klog.Fatalf("Invalid topology keys: %v", err)
+	}
+	d.SetTopologyRegion(topologyRegion)
+	d.SetAdoptUntaggedVolumes(adoptUntaggedVolumes)
+	d.SetStrictIdempotency(strictIdempotency)
+	d.SetEncryptionBoundary(encryptionBoundary)
+	d.SetValidateParameters(validateParameters)
+	d.SetSnapshotBackups(snapshotBackups)
+	if err := d.SetMetadataHints(metadataHints); err != nil {
+		klog.Fatalf("Invalid metadata hints: %v", err)
+	}
+	if growOnStageThreshold != "" {
+		threshold, err := resource.ParseQuantity(growOnStageThreshold)
+		if err != nil || threshold.Sign() < 0 {
+			klog.Fatalf("Invalid --grow-on-stage-threshold %q", growOnStageThreshold)
+		}
+		d.SetGrowOnStage(threshold.Value())
+	}
+	d.SetMaxVolumesPerNode(maxVolumesPerNode)
+	d.SetNodeTimeouts(mountTimeout, deviceScanTimeout)
+	d.SetOpenStackRequestTimeout(openstackTimeout)
+	d.SetCreatingDeadline(creatingDeadline)
+	d.SetStaleAttachmentInterval(staleAttachments)
+	d.SetEphemeralCleanupInterval(ephemeralCleanup)
+	d.SetMetricsVolumeTypes(metricsVolumeTypes)
+	d.SetMetricsAddress(metricsAddress)
+	d.SetHTTPEndpoint(httpEndpoint)
+	if creatingDeadline > 0 {
+		if client, err := buildKubeClient(kubeconfig); err != nil {
+			klog.Warningf("No events will be recorded on PVCs: %v", err)
+		} else {
+			d.SetKubeClient(client)
+		}
+	}
+	if namespaceQuota != "" {
+		client, err := buildKubeClient(kubeconfig)
+		if err != nil {
+			klog.Fatalf("Failed to build the Kubernetes client for the namespace quota: %v", err)
+		}
+		if err := d.SetNamespaceQuota(client, namespaceQuota); err != nil {
+			klog.Fatalf("Invalid namespace quota: %v", err)
+		}
+	}
+	if leaderElection.Enabled {
+		if err := leaderElection.Validate(); err != nil {
+			klog.Fatalf("Invalid leader election: %v", err)
+		}
+		client, err := buildKubeClient(kubeconfig)
+		if err != nil {
+			klog.Fatalf("Failed to build the Kubernetes client for the leader election: %v", err)
+		}
+		d.SetLeaderElection(leaderElection, client)
+	}
+	if kubeletRegistrationDir != "" {
+		d.SetKubeletRegistration(kubeletRegistrationDir, kubeletRegistrationPath, registrationHealthAddress)
+	}
+	d.SetPlacementWebhook(placementWebhookURL, placementWebhookTimeout, placementWebhookFailOpen)
+	if supportBundleAddress != "" {
+		logBuffer := supportbundle.NewLineBuffer(supportBundleLogLines)
+		if err := supportbundle.CaptureStderr(logBuffer); err != nil {
+			klog.Warningf("Support bundle will not include logs: %v", err)
+			logBuffer = nil
+		}
+		d.SetSupportBundle(supportBundleAddress, logBuffer)
+	}
+	if debugTLSCertFile != "" {
+		if err := d.SetDebugTLS(debugTLSCertFile, debugTLSKeyFile, debugClientCAFile); err != nil {
+			klog.Fatalf("Invalid debug TLS: %v", err)
+		}
+	}
+	d.SetMaxConcurrentOperations(maxConcurrentOperations)
 	d.Run()
 }
+
+func buildKubeClient(kubeconfig string) (kubernetes.Interface, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
@@ -17,14 +17,17 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"time"
+
 	"github.com/spf13/pflag"
 	"k8s.io/klog"
 
+	"k8s.io/cloud-provider-openstack/pkg/util/leaderelection"
 	"k8s.io/cloud-provider-openstack/pkg/volume/cinder/provisioner"
 	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
 
-	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -38,6 +41,10 @@ var (
 	id          string
 	cloudconfig string
 	version     string
+
+	cloudConfigReloadInterval time.Duration
+
+	leaderElection = leaderelection.NewConfig()
 )
 
 func main() {
@@ -45,6 +52,9 @@ func main() {
 	pflag.StringVar(&kubeconfig, "kubeconfig", "", "Absolute path to the kubeconfig")
 	pflag.StringVar(&id, "id", "", "Unique provisioner identity")
 	pflag.StringVar(&cloudconfig, "cloud-config", "", "Path to OpenStack config file")
+	pflag.DurationVar(&cloudConfigReloadInterval, "cloud-config-reload-interval", time.Minute, "How often to check --cloud-config for changes, e.g. a rotated Secret, and reauthenticate with the new credentials without a restart. 0 disables it")
+
+	leaderElection.AddFlags(flag.CommandLine)
 
 	// Glog requires this otherwise it complains.
 	flag.CommandLine.Parse(nil)
@@ -96,19 +106,26 @@ func main() {
 
 	// Create the provisioner: it implements the Provisioner interface expected by
 	// the controller
-	cinderProvisioner, err := provisioner.NewCinderProvisioner(clientset, prID, cloudconfig)
+	cinderProvisioner, err := provisioner.NewCinderProvisioner(clientset, prID, cloudconfig, cloudConfigReloadInterval)
 	if err != nil {
 		klog.Fatalf("Error creating Cinder provisioner: %v", err)
 	}
 
 	// Start the provision controller which will dynamically provision cinder
-	// PVs
+	// PVs. With --leader-elect, the replicas elect their leader with a Lease
+	// rather than the Endpoints of the provision controller.
 	pc := controller.NewProvisionController(
 		clientset,
 		provisioner.ProvisionerName,
 		cinderProvisioner,
 		serverVersion.GitVersion,
+		controller.LeaderElection(!leaderElection.Enabled),
 	)
 
-	pc.Run(wait.NeverStop)
+	err = leaderelection.Run(context.Background(), clientset, prID, leaderElection, func(ctx context.Context) {
+		pc.Run(ctx.Done())
+	})
+	if err != nil {
+		klog.Fatalf("Leader election failed: %v", err)
+	}
 }
@@ -21,15 +21,18 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/utils/openstack/clientconfig"
 	"github.com/spf13/pflag"
 
 	"golang.org/x/crypto/ssh/terminal"
 
 	"k8s.io/cloud-provider-openstack/pkg/identity/keystone"
+	"k8s.io/cloud-provider-openstack/pkg/util/skew"
 	kflag "k8s.io/component-base/cli/flag"
 	"k8s.io/klog"
 )
@@ -49,6 +52,24 @@ const respTemplate string = `{
 	}
 }`
 
+// defaultTokenCacheDir returns where the tokens are cached by default, next
+// to the kubectl caches.
+func defaultTokenCacheDir() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".kube", "cache", "client-keystone-auth")
+}
+
+// replaceEmpty is a helper function to replace empty fields with another field
+func replaceEmpty(a string, b string) string {
+	if a == "" {
+		return b
+	}
+	return a
+}
+
 func promptForString(field string, r io.Reader, show bool) (result string, err error) {
 	// We have to print output to Stderr, because Stdout is redirected and not shown to the user.
 	fmt.Fprintf(os.Stderr, "Please enter %s: ", field)
@@ -164,6 +185,11 @@ func main() {
 	var applicationCredentialID string
 	var applicationCredentialName string
 	var applicationCredentialSecret string
+	var cloud string
+	var passcode string
+	var totp bool
+	var tokenCacheDir string
+	var tokenRefreshBefore time.Duration
 
 	pflag.StringVar(&url, "keystone-url", os.Getenv("OS_AUTH_URL"), "URL for the OpenStack Keystone API")
 	pflag.StringVar(&domain, "domain-name", os.Getenv("OS_DOMAIN_NAME"), "Keystone domain name")
@@ -175,8 +201,30 @@ func main() {
 	pflag.StringVar(&applicationCredentialID, "application-credential-id", os.Getenv("OS_APPLICATION_CREDENTIAL_ID"), "Application Credential ID")
 	pflag.StringVar(&applicationCredentialName, "application-credential-name", os.Getenv("OS_APPLICATION_CREDENTIAL_NAME"), "Application Credential Name")
 	pflag.StringVar(&applicationCredentialSecret, "application-credential-secret", os.Getenv("OS_APPLICATION_CREDENTIAL_SECRET"), "Application Credential Secret")
+	pflag.StringVar(&cloud, "os-cloud", os.Getenv("OS_CLOUD"), "Cloud of clouds.yaml to read the credentials not set by the other arguments from")
+	pflag.StringVar(&passcode, "passcode", os.Getenv("OS_PASSCODE"), "TOTP passcode to authenticate with along with the password")
+	pflag.BoolVar(&totp, "totp", false, "Prompt for a TOTP passcode to authenticate with along with the password")
+	pflag.StringVar(&tokenCacheDir, "token-cache-dir", defaultTokenCacheDir(), "Directory the tokens are cached in until they are about to expire, caching is disabled when empty")
+	pflag.DurationVar(&tokenRefreshBefore, "token-refresh-before", 5*time.Minute, "How long before its expiry a new token is requested")
 	kflag.InitFlags()
 
+	// Fill the arguments missing with the ones of the cloud in clouds.yaml
+	if cloud != "" {
+		c, err := clientconfig.GetCloudFromYAML(&clientconfig.ClientOpts{Cloud: cloud})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read cloud %s from clouds.yaml: %s\n", cloud, err)
+			os.Exit(1)
+		}
+		url = replaceEmpty(url, c.AuthInfo.AuthURL)
+		domain = replaceEmpty(domain, c.AuthInfo.UserDomainName)
+		user = replaceEmpty(user, c.AuthInfo.Username)
+		project = replaceEmpty(project, c.AuthInfo.ProjectName)
+		password = replaceEmpty(password, c.AuthInfo.Password)
+		applicationCredentialID = replaceEmpty(applicationCredentialID, c.AuthInfo.ApplicationCredentialID)
+		applicationCredentialName = replaceEmpty(applicationCredentialName, c.AuthInfo.ApplicationCredentialName)
+		applicationCredentialSecret = replaceEmpty(applicationCredentialSecret, c.AuthInfo.ApplicationCredentialSecret)
+	}
+
 	// Generate Gophercloud Auth Options based on input data from stdin
 	// if IsTerminal returns "true", or from env variables otherwise.
 	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
@@ -208,8 +256,36 @@ func main() {
 		}
 	}
 
+	// The tokens are cached by the identity of the user once resolved from
+	// the arguments, the environment variables or the prompt
+	var tokenCache *keystone.TokenCache
+	var tokenCacheKey string
+	if tokenCacheDir != "" {
+		tokenCache = &keystone.TokenCache{Dir: tokenCacheDir, RefreshBefore: tokenRefreshBefore}
+		tokenCacheKey = keystone.TokenCacheKey(options.AuthOptions)
+		if id, expiresAt, ok := tokenCache.Get(tokenCacheKey); ok {
+			out := fmt.Sprintf(respTemplate, id, expiresAt.Add(-tokenRefreshBefore).Format(time.RFC3339Nano))
+			fmt.Println(out)
+			return
+		}
+	}
+
+	if totp && passcode == "" && terminal.IsTerminal(int(os.Stdin.Fd())) {
+		passcode, err = promptForString("TOTP passcode", os.Stdin, true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read data from console: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if totp && passcode == "" {
+		fmt.Fprintf(os.Stderr, "A TOTP passcode is required, with --passcode or OS_PASSCODE when not run from a terminal\n")
+		os.Exit(1)
+	}
+	options.Passcode = passcode
+
 	options.ClientCertPath = clientCertPath
 	options.ClientKeyPath = clientKeyPath
+	options.ClockSkew = skew.NewTracker(skew.DefaultThreshold, nil)
 
 	token, err := keystone.GetToken(options)
 	if err != nil {
@@ -222,6 +298,20 @@ func main() {
 		os.Exit(1)
 	}
 
-	out := fmt.Sprintf(respTemplate, token.ID, token.ExpiresAt.Format(time.RFC3339Nano))
+	// kubectl compares the expiry against the local clock, so convert it
+	// from the Keystone clock.
+	expiresAt := options.ClockSkew.ToLocal(token.ExpiresAt)
+	if tokenCache != nil {
+		if err := tokenCache.Set(tokenCacheKey, token.ID, expiresAt); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to cache the token: %v\n", err)
+		}
+	}
+
+	// Have kubectl run the plugin again a bit before the token expires,
+	// unless the token doesn't last longer than that
+	if !options.ClockSkew.Expired(token.ExpiresAt, tokenRefreshBefore) {
+		expiresAt = expiresAt.Add(-tokenRefreshBefore)
+	}
+	out := fmt.Sprintf(respTemplate, token.ID, expiresAt.Format(time.RFC3339Nano))
 	fmt.Println(out)
 }
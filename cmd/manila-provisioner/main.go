@@ -17,13 +17,16 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"time"
 
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/cloud-provider-openstack/pkg/share/manila"
+	"k8s.io/cloud-provider-openstack/pkg/util/leaderelection"
 	"k8s.io/klog"
 	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
 )
@@ -31,10 +34,14 @@ import (
 var (
 	kubeconfig      = flag.String("kubeconfig", "", "Path to a kube config. Only required if out-of-cluster.")
 	provisionerName = flag.String("provisioner", "externalstorage.k8s.io/manila", "Name of the provisioner. The provisioner will only provision volumes for claims that request a StorageClass with a provisioner field set equal to this name.")
+	resize          = flag.Bool("resize", false, "Extend the shares of the claims resized to request more storage. The StorageClasses need allowVolumeExpansion, and the provisioner the permission to update PersistentVolumes and the status of PersistentVolumeClaims.")
+
+	leaderElection = leaderelection.NewConfig()
 )
 
 func main() {
 	flag.Set("logtostderr", "true")
+	leaderElection.AddFlags(flag.CommandLine)
 
 	// Glog requires this otherwise it complains.
 	flag.Parse()
@@ -71,15 +78,31 @@ func main() {
 		klog.Fatalf("Error getting server version: %v", err)
 	}
 
-	// Start the provision controller which will dynamically provision Manila PVs
+	// Start the provision controller which will dynamically provision Manila
+	// PVs. With --leader-elect, the replicas elect their leader with a Lease
+	// rather than the Endpoints of the provision controller, and the leader
+	// both provisions and resizes.
 	provisioner := controller.NewProvisionController(
 		clientset,
 		*provisionerName,
 		manila.NewProvisioner(clientset),
 		serverVersion.GitVersion,
+		controller.LeaderElection(!leaderElection.Enabled),
 	)
 
-	provisioner.Run(wait.NeverStop)
+	err = leaderelection.Run(context.Background(), clientset, *provisionerName, leaderElection, func(ctx context.Context) {
+		if *resize {
+			factory := informers.NewSharedInformerFactory(clientset, 10*time.Minute)
+			resizer := manila.NewResizer(clientset, *provisionerName, factory)
+			factory.Start(ctx.Done())
+			go resizer.Run(1, ctx.Done())
+		}
+
+		provisioner.Run(ctx.Done())
+	})
+	if err != nil {
+		klog.Fatalf("Leader election failed: %v", err)
+	}
 }
 
 func buildConfig(kubeconfig string) (*rest.Config, error) {
@@ -34,6 +34,7 @@ import (
 	"k8s.io/apiserver/pkg/server/healthz"
 	"k8s.io/cloud-provider"
 	"k8s.io/cloud-provider-openstack/pkg/cloudprovider/providers/openstack"
+	"k8s.io/cloud-provider-openstack/pkg/util/supportbundle"
 	"k8s.io/component-base/cli/flag"
 	"k8s.io/component-base/logs"
 	"k8s.io/klog"
@@ -55,6 +56,17 @@ import (
 
 var version string
 
+var (
+	supportBundleAddress  string
+	supportBundleLogLines int
+	supportBundleOutput   string
+	debugTLSCertFile      string
+	debugTLSKeyFile       string
+	debugClientCAFile     string
+
+	cloudConfigReloadInterval time.Duration
+)
+
 func init() {
 	healthz.DefaultHealthz()
 }
@@ -94,6 +106,22 @@ the cloud specific control loops shipped with Kubernetes.`,
 			verflag.PrintAndExitIfRequested()
 			utilflag.PrintFlags(cmd.Flags())
 
+			if supportBundleAddress != "" {
+				logBuffer := supportbundle.NewLineBuffer(supportBundleLogLines)
+				if err := supportbundle.CaptureStderr(logBuffer); err != nil {
+					klog.Warningf("Support bundle will not include logs: %v", err)
+					logBuffer = nil
+				}
+				openstack.SetSupportBundle(supportBundleAddress, logBuffer)
+			}
+			if debugTLSCertFile != "" {
+				if err := openstack.SetDebugTLS(debugTLSCertFile, debugTLSKeyFile, debugClientCAFile); err != nil {
+					klog.Fatalf("Invalid debug TLS: %v", err)
+				}
+			}
+			openstack.SetCloudConfigReload(s.KubeCloudShared.CloudProvider.CloudConfigFile, cloudConfigReloadInterval)
+			openstack.SetClusterName(s.KubeCloudShared.ClusterName)
+
 			c, err := s.Config(KnownControllers(), ControllersDisabledByDefault.List())
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
@@ -112,6 +140,32 @@ the cloud specific control loops shipped with Kubernetes.`,
 	for _, f := range namedFlagSets.FlagSets {
 		fs.AddFlagSet(f)
 	}
+	fs.StringVar(&supportBundleAddress, "support-bundle-address", "", "Address to serve the support bundle on, e.g. 127.0.0.1:9810. Disabled when empty")
+	fs.IntVar(&supportBundleLogLines, "support-bundle-log-lines", 1000, "Number of recent log lines kept for the support bundle")
+	fs.StringVar(&debugTLSCertFile, "debug-tls-cert-file", "", "Certificate to serve the support bundle with mutual TLS, which allows serving it on other than a loopback address")
+	fs.StringVar(&debugTLSKeyFile, "debug-tls-key-file", "", "Private key of --debug-tls-cert-file")
+	fs.StringVar(&debugClientCAFile, "debug-client-ca-file", "", "CA the client certificates of the support bundle must be signed by. Required with --debug-tls-cert-file")
+	fs.DurationVar(&cloudConfigReloadInterval, "cloud-config-reload-interval", time.Minute, "How often to check --cloud-config and the clouds.yaml and secure.yaml it reads for changes, e.g. a rotated Secret, and reauthenticate with the new credentials without a restart. 0 disables it")
+
+	supportBundleCmd := &cobra.Command{
+		Use:   "support-bundle",
+		Short: "Download the support bundle of a running cloud controller manager",
+		Long:  "Download the support bundle served by a running cloud controller manager on --support-bundle-address into a tar.gz. Secrets are masked.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if supportBundleAddress == "" {
+				fmt.Fprintf(os.Stderr, "--support-bundle-address of the running cloud controller manager is required\n")
+				os.Exit(1)
+			}
+			if err := supportbundle.Download(supportBundleAddress, supportBundleOutput); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Support bundle written to %s\n", supportBundleOutput)
+		},
+	}
+	supportBundleCmd.Flags().StringVar(&supportBundleAddress, "support-bundle-address", "", "Address the running cloud controller manager serves the support bundle on")
+	supportBundleCmd.Flags().StringVar(&supportBundleOutput, "output", "support-bundle.tar.gz", "File to write the support bundle to")
+	command.AddCommand(supportBundleCmd)
 
 	// TODO: once we switch everything over to Cobra commands, we can go back to calling
 	// utilflag.InitFlags() (by removing its pflag.Parse() call). For now, we have to set the
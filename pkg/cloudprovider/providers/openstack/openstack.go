@@ -29,6 +29,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gophercloud/gophercloud"
@@ -46,10 +47,18 @@ import (
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	netutil "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	certutil "k8s.io/client-go/util/cert"
 	cloudprovider "k8s.io/cloud-provider"
 	v1helper "k8s.io/cloud-provider-openstack/pkg/apis/core/v1/helper"
+	"k8s.io/cloud-provider-openstack/pkg/util/httpcontext"
 	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
+	"k8s.io/cloud-provider-openstack/pkg/util/ratelimit"
+	"k8s.io/cloud-provider-openstack/pkg/util/skew"
+	"k8s.io/cloud-provider-openstack/pkg/util/supportbundle"
 	"k8s.io/klog"
 )
 
@@ -97,6 +106,20 @@ type LoadBalancer struct {
 	compute *gophercloud.ServiceClient
 	lb      *gophercloud.ServiceClient
 	opts    LoadBalancerOpts
+	// eventRecorder is nil until the cloud provider is initialized
+	eventRecorder record.EventRecorder
+	// state is shared with the copies bound to the context of a call
+	state *lbState
+}
+
+// lbState is what LoadBalancer learns from one call and uses in the next ones.
+type lbState struct {
+	mu sync.Mutex
+	// nodeSecurityGroupIDs are the security groups of the nodes, found by
+	// EnsureLoadBalancer and UpdateLoadBalancer unless configured
+	nodeSecurityGroupIDs []string
+	// projectID is looked up from the token when not configured
+	projectID string
 }
 
 // LoadBalancerOpts have the options to talk to Neutron LBaaSV2 or Octavia
@@ -107,13 +130,30 @@ type LoadBalancerOpts struct {
 	FloatingNetworkID    string     `gcfg:"floating-network-id"` // If specified, will create floating ip for loadbalancer, or do not create floating ip.
 	LBMethod             string     `gcfg:"lb-method"`           // default to ROUND_ROBIN.
 	LBProvider           string     `gcfg:"lb-provider"`
+	FlavorID             string     `gcfg:"flavor-id"` // Octavia flavor of the load balancers, defaults to the one of the provider
 	CreateMonitor        bool       `gcfg:"create-monitor"`
 	MonitorDelay         MyDuration `gcfg:"monitor-delay"`
 	MonitorTimeout       MyDuration `gcfg:"monitor-timeout"`
 	MonitorMaxRetries    uint       `gcfg:"monitor-max-retries"`
 	ManageSecurityGroups bool       `gcfg:"manage-security-groups"`
 	NodeSecurityGroupIDs []string   // Do not specify, get it automatically when enable manage-security-groups. TODO(FengyunPan): move it into cache
-	InternalLB           bool       `gcfg:"internal-lb"` // default false
+	InternalLB           bool       `gcfg:"internal-lb"`           // default false
+	DryRun               bool       `gcfg:"dry-run"`               // only report the changes EnsureLoadBalancer would make
+	Hibernate            bool       `gcfg:"hibernate"`             // keep the load balancers of deleted services with listeners disabled
+	HibernationRetention MyDuration `gcfg:"hibernation-retention"` // delete hibernated load balancers after this period, 0 keeps them
+	ClusterName          string     `gcfg:"cluster-name"`          // only used when the controller manager does not set its --cluster-name
+	MemberAddressType    string     `gcfg:"member-address-type"`   // node address of the pool members: InternalIP, ExternalIP or network:<name>
+
+	// PortNames are set from the LoadBalancerPortName sections. Do not specify
+	PortNames map[string]*PortNameOpts
+}
+
+// PortNameOpts are the listener settings of the Service ports whose name
+// matches the pattern of a LoadBalancerPortName section
+type PortNameOpts struct {
+	Protocol      string   `gcfg:"protocol"`       // TCP, HTTP or HTTPS. Defaults to TCP
+	ProxyProtocol bool     `gcfg:"proxy-protocol"` // use the PROXY protocol towards the members
+	InsertHeaders []string `gcfg:"insert-header"`  // headers inserted by HTTP listeners, e.g. X-Forwarded-For
 }
 
 // BlockStorageOpts is used to talk to Cinder service
@@ -132,13 +172,22 @@ type NetworkingOpts struct {
 
 // RouterOpts is used for Neutron routes
 type RouterOpts struct {
-	RouterID string `gcfg:"router-id"` // required
+	RouterID string `gcfg:"router-id"` // required unless DiscoverRouters
+	// DiscoverRouters adds the route to a node to the router with an
+	// interface on its subnet, instead of to RouterID
+	DiscoverRouters bool `gcfg:"discover-routers"`
 }
 
 // MetadataOpts is used for configuring how to talk to metadata service or config drive
 type MetadataOpts struct {
 	SearchOrder    string     `gcfg:"search-order"`
 	RequestTimeout MyDuration `gcfg:"request-timeout"`
+	// CacheTTL is how long the metadata of the local instance is cached, 0
+	// to cache it for the lifetime of the process
+	CacheTTL MyDuration `gcfg:"cache-ttl"`
+	// ServerCacheTTL is how long the servers looked up by node name are
+	// cached, 0 not to cache them
+	ServerCacheTTL MyDuration `gcfg:"server-cache-ttl"`
 }
 
 type ServerAttributesExt struct {
@@ -146,6 +195,18 @@ type ServerAttributesExt struct {
 	availabilityzones.ServerAvailabilityZoneExt
 }
 
+// ServerPowerStateExt is the power state of a server, of the Nova extended
+// status extension.
+type ServerPowerStateExt struct {
+	PowerState int `json:"OS-EXT-STS:power_state"`
+}
+
+// ServerStatusExt is a server with its power state.
+type ServerStatusExt struct {
+	servers.Server
+	ServerPowerStateExt
+}
+
 // OpenStack is an implementation of cloud provider Interface for OpenStack.
 type OpenStack struct {
 	provider       *gophercloud.ProviderClient
@@ -157,6 +218,13 @@ type OpenStack struct {
 	networkingOpts NetworkingOpts
 	// InstanceID of the server where this OpenStack object is instantiated.
 	localInstanceID string
+	eventRecorder   record.EventRecorder
+	projectID       string
+	// servers caches the servers looked up by node name, nil without a
+	// server-cache-ttl
+	servers *serverCache
+	// config is the effective cloud config, for the support bundle
+	config Config
 }
 
 // Config is used to read and store information from the cloud configuration file
@@ -176,12 +244,19 @@ type Config struct {
 		UseClouds  bool   `gcfg:"use-clouds"`
 		CloudsFile string `gcfg:"clouds-file,omitempty"`
 		Cloud      string `gcfg:"cloud,omitempty"`
-	}
-	LoadBalancer LoadBalancerOpts
-	BlockStorage BlockStorageOpts
-	Route        RouterOpts
-	Metadata     MetadataOpts
-	Networking   NetworkingOpts
+
+		// Keystone application credential, used instead of the password
+		ApplicationCredentialID     string `gcfg:"application-credential-id"`
+		ApplicationCredentialName   string `gcfg:"application-credential-name"`
+		ApplicationCredentialSecret string `gcfg:"application-credential-secret"`
+	}
+	LoadBalancer         LoadBalancerOpts
+	LoadBalancerPortName map[string]*PortNameOpts
+	BlockStorage         BlockStorageOpts
+	Route                RouterOpts
+	Metadata             MetadataOpts
+	Networking           NetworkingOpts
+	RateLimit            ratelimit.Config
 }
 
 func logcfg(cfg Config) {
@@ -193,6 +268,8 @@ func logcfg(cfg Config) {
 	klog.V(5).Infof("DomainName: %s", cfg.Global.DomainName)
 	klog.V(5).Infof("DomainID: %s", cfg.Global.DomainID)
 	klog.V(5).Infof("TrustID: %s", cfg.Global.TrustID)
+	klog.V(5).Infof("ApplicationCredentialID: %s", cfg.Global.ApplicationCredentialID)
+	klog.V(5).Infof("ApplicationCredentialName: %s", cfg.Global.ApplicationCredentialName)
 	klog.V(5).Infof("Region: %s", cfg.Global.Region)
 	klog.V(5).Infof("CAFile: %s", cfg.Global.CAFile)
 }
@@ -215,7 +292,7 @@ func init() {
 }
 
 func (cfg Config) toAuthOptions() gophercloud.AuthOptions {
-	return gophercloud.AuthOptions{
+	opts := gophercloud.AuthOptions{
 		IdentityEndpoint: cfg.Global.AuthURL,
 		Username:         cfg.Global.Username,
 		UserID:           cfg.Global.UserID,
@@ -225,9 +302,27 @@ func (cfg Config) toAuthOptions() gophercloud.AuthOptions {
 		DomainID:         cfg.Global.DomainID,
 		DomainName:       cfg.Global.DomainName,
 
+		ApplicationCredentialID:     cfg.Global.ApplicationCredentialID,
+		ApplicationCredentialName:   cfg.Global.ApplicationCredentialName,
+		ApplicationCredentialSecret: cfg.Global.ApplicationCredentialSecret,
+
 		// Persistent service, so we need to be able to renew tokens.
 		AllowReauth: true,
 	}
+	if cfg.usesApplicationCredential() {
+		// The project is the one of the application credential, Keystone
+		// refuses a scope along with it
+		opts.TenantID = ""
+		opts.TenantName = ""
+	}
+	return opts
+}
+
+// usesApplicationCredential returns whether to authenticate with an
+// application credential rather than a password.
+func (cfg Config) usesApplicationCredential() bool {
+	return cfg.Global.ApplicationCredentialSecret != "" &&
+		(cfg.Global.ApplicationCredentialID != "" || cfg.Global.ApplicationCredentialName != "")
 }
 
 func (cfg Config) toAuth3Options() tokens3.AuthOptions {
@@ -252,6 +347,9 @@ func configFromEnv() (cfg Config, ok bool) {
 	cfg.Global.Region = os.Getenv("OS_REGION_NAME")
 	cfg.Global.UserID = os.Getenv("OS_USER_ID")
 	cfg.Global.TrustID = os.Getenv("OS_TRUST_ID")
+	cfg.Global.ApplicationCredentialID = os.Getenv("OS_APPLICATION_CREDENTIAL_ID")
+	cfg.Global.ApplicationCredentialName = os.Getenv("OS_APPLICATION_CREDENTIAL_NAME")
+	cfg.Global.ApplicationCredentialSecret = os.Getenv("OS_APPLICATION_CREDENTIAL_SECRET")
 
 	cfg.Global.TenantID = os.Getenv("OS_TENANT_ID")
 	if cfg.Global.TenantID == "" {
@@ -278,6 +376,9 @@ func configFromEnv() (cfg Config, ok bool) {
 			cfg.Global.DomainID != "" || cfg.Global.DomainName != "" ||
 			cfg.Global.Region != "" || cfg.Global.UserID != "" ||
 			cfg.Global.TrustID != "")
+	// An application credential ID is enough, a name needs its user
+	ok = ok || (cfg.Global.AuthURL != "" && cfg.usesApplicationCredential() &&
+		(cfg.Global.ApplicationCredentialID != "" || cfg.Global.UserID != "" || cfg.Global.Username != ""))
 
 	cfg.Metadata.SearchOrder = fmt.Sprintf("%s,%s", metadata.ConfigDriveID, metadata.MetadataID)
 	cfg.BlockStorage.BSVersion = "auto"
@@ -347,6 +448,9 @@ func ReadClouds(cfg *Config) error {
 	cfg.Global.DomainName = replaceEmpty(cfg.Global.DomainName, cloud.AuthInfo.UserDomainName)
 	cfg.Global.Region = replaceEmpty(cfg.Global.Region, cloud.RegionName)
 	cfg.Global.CAFile = replaceEmpty(cfg.Global.CAFile, cloud.CACertFile)
+	cfg.Global.ApplicationCredentialID = replaceEmpty(cfg.Global.ApplicationCredentialID, cloud.AuthInfo.ApplicationCredentialID)
+	cfg.Global.ApplicationCredentialName = replaceEmpty(cfg.Global.ApplicationCredentialName, cloud.AuthInfo.ApplicationCredentialName)
+	cfg.Global.ApplicationCredentialSecret = replaceEmpty(cfg.Global.ApplicationCredentialSecret, cloud.AuthInfo.ApplicationCredentialSecret)
 
 	return nil
 }
@@ -404,11 +508,22 @@ func checkOpenStackOpts(openstackOpts *OpenStack) error {
 			return fmt.Errorf("monitor-max-retries not set in cloud provider config")
 		}
 	}
+	if lbOpts.FlavorID != "" && !lbOpts.UseOctavia {
+		return fmt.Errorf("flavor-id needs use-octavia in cloud provider config")
+	}
+	if err := checkPortNameOpts(lbOpts.PortNames); err != nil {
+		return err
+	}
+	if _, err := parseMemberAddressType(lbOpts.MemberAddressType); err != nil {
+		return err
+	}
 	return checkMetadataSearchOrder(openstackOpts.metadataOpts.SearchOrder)
 }
 
-// NewOpenStack creates a new new instance of the openstack struct from a config struct
-func NewOpenStack(cfg Config) (*OpenStack, error) {
+// NewProviderClient authenticates to Keystone with the [Global] section of
+// cfg. It is shared by the components reading the cloud config of the cloud
+// provider.
+func NewProviderClient(cfg Config) (*gophercloud.ProviderClient, error) {
 	provider, err := openstack.NewClient(cfg.Global.AuthURL)
 	if err != nil {
 		return nil, err
@@ -423,17 +538,40 @@ func NewOpenStack(cfg Config) (*OpenStack, error) {
 		provider.HTTPClient.Transport = netutil.SetOldTransportDefaults(&http.Transport{TLSClientConfig: config})
 
 	}
+	// Track the clock skew to the OpenStack API, starting with Keystone.
+	provider.HTTPClient.Transport = skew.NewTracker(skew.DefaultThreshold, openstackClockSkew).RoundTripper(provider.HTTPClient.Transport)
+	if err := cfg.RateLimit.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid [RateLimit]: %v", err)
+	}
+	provider.HTTPClient.Transport = cfg.RateLimit.RoundTripper(provider.HTTPClient.Transport)
+
+	if err := AuthenticateProvider(provider, cfg); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// AuthenticateProvider authenticates provider with the credentials of the
+// [Global] section of cfg. The clients of provider use the new token from
+// then on, and reauthenticate with these credentials.
+func AuthenticateProvider(provider *gophercloud.ProviderClient, cfg Config) error {
+	if cfg.Global.TrustID != "" && cfg.usesApplicationCredential() {
+		return fmt.Errorf("trust-id cannot be used with an application credential")
+	}
 	if cfg.Global.TrustID != "" {
 		opts := cfg.toAuth3Options()
 		authOptsExt := trusts.AuthOptsExt{
 			TrustID:            cfg.Global.TrustID,
 			AuthOptionsBuilder: &opts,
 		}
-		err = openstack.AuthenticateV3(provider, authOptsExt, gophercloud.EndpointOpts{})
-	} else {
-		err = openstack.Authenticate(provider, cfg.toAuthOptions())
+		return openstack.AuthenticateV3(provider, authOptsExt, gophercloud.EndpointOpts{})
 	}
+	return openstack.Authenticate(provider, cfg.toAuthOptions())
+}
 
+// NewOpenStack creates a new new instance of the openstack struct from a config struct
+func NewOpenStack(cfg Config) (*OpenStack, error) {
+	provider, err := NewProviderClient(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -452,18 +590,57 @@ func NewOpenStack(cfg Config) (*OpenStack, error) {
 		routeOpts:      cfg.Route,
 		metadataOpts:   cfg.Metadata,
 		networkingOpts: cfg.Networking,
+		projectID:      cfg.Global.TenantID,
+		servers:        newServerCache(cfg.Metadata.ServerCacheTTL.Duration),
+		config:         cfg,
 	}
+	os.lbOpts.PortNames = cfg.LoadBalancerPortName
 
 	err = checkOpenStackOpts(&os)
 	if err != nil {
 		return nil, err
 	}
 
+	metadata.SetCacheTTL(cfg.Metadata.CacheTTL.Duration)
+	metadata.SetNovaSource(os.novaMetadata)
+
 	return &os, nil
 }
 
 // Initialize passes a Kubernetes clientBuilder interface to the cloud provider
 func (os *OpenStack) Initialize(clientBuilder cloudprovider.ControllerClientBuilder, stop <-chan struct{}) {
+	clientset := clientBuilder.ClientOrDie("cloud-provider-openstack")
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{
+		Interface: clientset.CoreV1().Events(""),
+	})
+	os.eventRecorder = eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "cloud-provider-openstack"})
+
+	// Abort outstanding OpenStack requests on shutdown
+	os.provider.HTTPClient.Transport = httpcontext.CancelOnStop(stop, os.provider.HTTPClient.Transport)
+
+	if supportBundleAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle(supportbundle.Path, supportbundle.Handler(nil, os.collectSupportBundle))
+		if err := supportbundle.ServeDebug(supportBundleAddress, mux, supportBundleTLS); err != nil {
+			klog.Fatalf("Failed to serve the support bundle: %v", err)
+		}
+	}
+
+	if cloudConfigFile != "" && cloudConfigReloadInterval > 0 {
+		os.watchCloudConfig(stop)
+	}
+
+	if retention := os.lbOpts.HibernationRetention.Duration; retention > 0 {
+		lb, ok := os.LoadBalancer()
+		if !ok {
+			klog.Warningf("Not purging hibernated loadbalancers, the loadbalancer is not available")
+			return
+		}
+		clusterName := hibernationClusterName(os.lbOpts.ClusterName)
+		go wait.Until(func() { lb.(*LbaasV2).runHibernationJanitor(clusterName, retention) }, lbHibernationJanitorInterval, stop)
+	}
 }
 
 // mapNodeNameToServerName maps a k8s NodeName to an OpenStack Server Name
@@ -729,7 +906,8 @@ func (os *OpenStack) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
 
 	klog.V(1).Info("Claiming to support LoadBalancer")
 
-	return &LbaasV2{LoadBalancer{network, compute, lb, os.lbOpts}}, true
+	state := &lbState{nodeSecurityGroupIDs: os.lbOpts.NodeSecurityGroupIDs, projectID: os.projectID}
+	return &LbaasV2{LoadBalancer{network, compute, lb, os.lbOpts, os.eventRecorder, state}}, true
 }
 
 // Zones indicates that we support zones
@@ -766,6 +944,7 @@ func (os *OpenStack) GetZoneByProviderID(ctx context.Context, providerID string)
 	if err != nil {
 		return cloudprovider.Zone{}, err
 	}
+	compute = clientWithContext(ctx, compute)
 
 	var serverWithAttributesExt ServerAttributesExt
 	if err := servers.Get(compute, instanceID).ExtractInto(&serverWithAttributesExt); err != nil {
@@ -788,6 +967,7 @@ func (os *OpenStack) GetZoneByNodeName(ctx context.Context, nodeName types.NodeN
 	if err != nil {
 		return cloudprovider.Zone{}, err
 	}
+	compute = clientWithContext(ctx, compute)
 
 	srv, err := getServerByName(compute, nodeName)
 	if err != nil {
@@ -905,8 +1085,8 @@ func checkMetadataSearchOrder(order string) error {
 	}
 
 	elements := strings.Split(order, ",")
-	if len(elements) > 2 {
-		return errors.New("invalid value in section [Metadata] with key `search-order`. Value cannot contain more than 2 elements")
+	if len(elements) > 3 {
+		return errors.New("invalid value in section [Metadata] with key `search-order`. Value cannot contain more than 3 elements")
 	}
 
 	for _, id := range elements {
@@ -914,9 +1094,10 @@ func checkMetadataSearchOrder(order string) error {
 		switch id {
 		case metadata.ConfigDriveID:
 		case metadata.MetadataID:
+		case metadata.NovaID:
 		default:
 			return fmt.Errorf("invalid element %q found in section [Metadata] with key `search-order`."+
-				"Supported elements include %q and %q", id, metadata.ConfigDriveID, metadata.MetadataID)
+				"Supported elements include %q, %q and %q", id, metadata.ConfigDriveID, metadata.MetadataID, metadata.NovaID)
 		}
 	}
 
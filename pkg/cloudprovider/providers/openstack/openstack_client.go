@@ -17,12 +17,48 @@ limitations under the License.
 package openstack
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
+
+	"k8s.io/cloud-provider-openstack/pkg/util/httpcontext"
 )
 
+// clientWithContext returns a copy of client whose requests are cancelled
+// when ctx is done. The copy shares the token of client and re-authenticates
+// through it, so it can be thrown away after the call.
+func clientWithContext(ctx context.Context, client *gophercloud.ServiceClient) *gophercloud.ServiceClient {
+	if ctx == nil || ctx.Done() == nil || client == nil {
+		return client
+	}
+
+	provider := client.ProviderClient
+	bound := &gophercloud.ProviderClient{
+		IdentityBase:     provider.IdentityBase,
+		IdentityEndpoint: provider.IdentityEndpoint,
+		TokenID:          provider.Token(),
+		EndpointLocator:  provider.EndpointLocator,
+		HTTPClient:       provider.HTTPClient,
+		UserAgent:        provider.UserAgent,
+	}
+	bound.HTTPClient.Transport = httpcontext.RoundTripper(ctx, provider.HTTPClient.Transport)
+	if provider.ReauthFunc != nil {
+		bound.ReauthFunc = func() error {
+			if err := provider.Reauthenticate(bound.TokenID); err != nil {
+				return err
+			}
+			bound.TokenID = provider.Token()
+			return nil
+		}
+	}
+
+	sc := *client
+	sc.ProviderClient = bound
+	return &sc
+}
+
 // NewNetworkV2 creates a ServiceClient that may be used with the neutron v2 API
 func (os *OpenStack) NewNetworkV2() (*gophercloud.ServiceClient, error) {
 	network, err := openstack.NewNetworkV2(os.provider, gophercloud.EndpointOpts{
@@ -0,0 +1,64 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+func TestClientWithContextCancel(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	client := &gophercloud.ServiceClient{
+		ProviderClient: &gophercloud.ProviderClient{TokenID: "token"},
+		Endpoint:       srv.URL + "/",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bound := clientWithContext(ctx, client)
+	if bound.Token() != "token" {
+		t.Errorf("expected bound client to share the token, got %q", bound.Token())
+	}
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := bound.Get(bound.ServiceURL("servers"), nil, nil); err == nil {
+		t.Fatalf("expected cancelled request to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled request took %v to abort", elapsed)
+	}
+
+	if client.HTTPClient.Transport != nil {
+		t.Errorf("binding a context must not change the original client")
+	}
+}
@@ -37,10 +37,19 @@ type Instances struct {
 	compute        *gophercloud.ServiceClient
 	opts           MetadataOpts
 	networkingOpts NetworkingOpts
+	// servers caches the servers looked up by node name, nil not to
+	servers *serverCache
 }
 
 const (
-	instanceShutoff = "SHUTOFF"
+	instanceShutoff          = "SHUTOFF"
+	instanceShelvedOffloaded = "SHELVED_OFFLOADED"
+	instanceDeleted          = "DELETED"
+	instanceSoftDeleted      = "SOFT_DELETED"
+
+	// The Nova power states of the instances whose guest is not running
+	powerStateShutdown = 4
+	powerStateCrashed  = 6
 )
 
 // Instances returns an implementation of Instances for OpenStack.
@@ -59,9 +68,18 @@ func (os *OpenStack) Instances() (cloudprovider.Instances, bool) {
 		compute:        compute,
 		opts:           os.metadataOpts,
 		networkingOpts: os.networkingOpts,
+		servers:        os.servers,
 	}, true
 }
 
+// withContext returns a copy of i whose OpenStack requests are cancelled when
+// ctx is done.
+func (i *Instances) withContext(ctx context.Context) *Instances {
+	bound := *i
+	bound.compute = clientWithContext(ctx, i.compute)
+	return &bound
+}
+
 // CurrentNodeName implements Instances.CurrentNodeName
 // Note this is *not* necessarily the same as hostname.
 func (i *Instances) CurrentNodeName(ctx context.Context, hostname string) (types.NodeName, error) {
@@ -79,9 +97,14 @@ func (i *Instances) AddSSHKeyToAllInstances(ctx context.Context, user string, ke
 
 // NodeAddresses implements Instances.NodeAddresses
 func (i *Instances) NodeAddresses(ctx context.Context, name types.NodeName) ([]v1.NodeAddress, error) {
+	i = i.withContext(ctx)
 	klog.V(4).Infof("NodeAddresses(%v) called", name)
 
-	addrs, err := getAddressesByName(i.compute, name, i.networkingOpts)
+	srv, err := i.servers.getServerByName(i.compute, name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := nodeAddresses(&srv.Server, i.networkingOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -94,6 +117,7 @@ func (i *Instances) NodeAddresses(ctx context.Context, name types.NodeName) ([]v
 // This method will not be called from the node that is requesting this ID. i.e. metadata service
 // and other local methods cannot be used here
 func (i *Instances) NodeAddressesByProviderID(ctx context.Context, providerID string) ([]v1.NodeAddress, error) {
+	i = i.withContext(ctx)
 	instanceID, err := instanceIDFromProviderID(providerID)
 
 	if err != nil {
@@ -116,7 +140,8 @@ func (i *Instances) NodeAddressesByProviderID(ctx context.Context, providerID st
 
 // ExternalID returns the cloud provider ID of the specified instance (deprecated).
 func (i *Instances) ExternalID(ctx context.Context, name types.NodeName) (string, error) {
-	srv, err := getServerByName(i.compute, name)
+	i = i.withContext(ctx)
+	srv, err := i.servers.getServerByName(i.compute, name)
 	if err != nil {
 		if err == ErrNotFound {
 			return "", cloudprovider.InstanceNotFound
@@ -128,40 +153,58 @@ func (i *Instances) ExternalID(ctx context.Context, name types.NodeName) (string
 
 // InstanceExistsByProviderID returns true if the instance with the given provider id still exist.
 // If false is returned with no error, the instance will be immediately deleted by the cloud controller manager.
+// Deleted instances Nova still lists, soft deleted ones included, do not exist.
 func (i *Instances) InstanceExistsByProviderID(ctx context.Context, providerID string) (bool, error) {
-	instanceID, err := instanceIDFromProviderID(providerID)
+	i = i.withContext(ctx)
+	server, err := i.getServerStatus(providerID)
 	if err != nil {
 		return false, err
 	}
+	return server != nil, nil
+}
 
-	_, err = servers.Get(i.compute, instanceID).Extract()
-	if err != nil {
-		if errors.IsNotFound(err) {
-			return false, nil
-		}
+// InstanceShutdownByProviderID returns true if the instances is in safe state to detach volumes: stopped, shelved
+// and offloaded, or with a guest which is not running according to its Nova power state. Deleted instances are
+// not shut down, InstanceExistsByProviderID reports them.
+func (i *Instances) InstanceShutdownByProviderID(ctx context.Context, providerID string) (bool, error) {
+	i = i.withContext(ctx)
+	server, err := i.getServerStatus(providerID)
+	if err != nil || server == nil {
 		return false, err
 	}
-
-	return true, nil
+	return isServerShutdown(server), nil
 }
 
-// InstanceShutdownByProviderID returns true if the instances is in safe state to detach volumes
-func (i *Instances) InstanceShutdownByProviderID(ctx context.Context, providerID string) (bool, error) {
+// getServerStatus returns the server with the given provider id and its power state, nil when it is deleted.
+func (i *Instances) getServerStatus(providerID string) (*ServerStatusExt, error) {
 	instanceID, err := instanceIDFromProviderID(providerID)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	server, err := servers.Get(i.compute, instanceID).Extract()
-	if err != nil {
-		return false, err
+	var server ServerStatusExt
+	if err := servers.Get(i.compute, instanceID).ExtractInto(&server); err != nil {
+		if errors.IsNotFound(err) {
+			i.servers.invalidateID(instanceID)
+			return nil, nil
+		}
+		return nil, err
 	}
+	if server.Status == instanceDeleted || server.Status == instanceSoftDeleted {
+		klog.V(4).Infof("Instance %s is %s", instanceID, server.Status)
+		i.servers.invalidateID(instanceID)
+		return nil, nil
+	}
+	return &server, nil
+}
 
-	// SHUTOFF is the only state where we can detach volumes immediately
-	if server.Status == instanceShutoff {
-		return true, nil
+// isServerShutdown returns whether the guest of a server is not running.
+func isServerShutdown(server *ServerStatusExt) bool {
+	switch server.Status {
+	case instanceShutoff, instanceShelvedOffloaded:
+		return true
 	}
-	return false, nil
+	return server.PowerState == powerStateShutdown || server.PowerState == powerStateCrashed
 }
 
 // InstanceID returns the kubelet's cloud provider ID.
@@ -178,7 +221,8 @@ func (os *OpenStack) InstanceID() (string, error) {
 
 // InstanceID returns the cloud provider ID of the specified instance.
 func (i *Instances) InstanceID(ctx context.Context, name types.NodeName) (string, error) {
-	srv, err := getServerByName(i.compute, name)
+	i = i.withContext(ctx)
+	srv, err := i.servers.getServerByName(i.compute, name)
 	if err != nil {
 		if err == ErrNotFound {
 			return "", cloudprovider.InstanceNotFound
@@ -194,6 +238,7 @@ func (i *Instances) InstanceID(ctx context.Context, name types.NodeName) (string
 // This method will not be called from the node that is requesting this ID. i.e. metadata service
 // and other local methods cannot be used here
 func (i *Instances) InstanceTypeByProviderID(ctx context.Context, providerID string) (string, error) {
+	i = i.withContext(ctx)
 	instanceID, err := instanceIDFromProviderID(providerID)
 
 	if err != nil {
@@ -211,7 +256,8 @@ func (i *Instances) InstanceTypeByProviderID(ctx context.Context, providerID str
 
 // InstanceType returns the type of the specified instance.
 func (i *Instances) InstanceType(ctx context.Context, name types.NodeName) (string, error) {
-	srv, err := getServerByName(i.compute, name)
+	i = i.withContext(ctx)
+	srv, err := i.servers.getServerByName(i.compute, name)
 
 	if err != nil {
 		return "", err
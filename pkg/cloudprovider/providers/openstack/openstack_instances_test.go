@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+func TestInstanceLifecycle(t *testing.T) {
+	// Instances by ID, with their status and power state
+	instances := map[string][2]interface{}{
+		"active":            {"ACTIVE", 1},
+		"stopped":           {"SHUTOFF", 4},
+		"shelved":           {"SHELVED_OFFLOADED", 4},
+		"guest-shutdown":    {"ACTIVE", 4},
+		"crashed":           {"ACTIVE", 6},
+		"paused":            {"PAUSED", 3},
+		"soft-deleted":      {"SOFT_DELETED", 4},
+		"deleted":           {"DELETED", 0},
+		"no-extended-state": {"ACTIVE", nil},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/servers/")
+		state, ok := instances[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if state[1] == nil {
+			fmt.Fprintf(w, `{"server": {"id": "%s", "status": "%s"}}`, id, state[0])
+			return
+		}
+		fmt.Fprintf(w, `{"server": {"id": "%s", "status": "%s", "OS-EXT-STS:power_state": %d}}`, id, state[0], state[1])
+	}))
+	defer srv.Close()
+
+	i := &Instances{compute: &gophercloud.ServiceClient{
+		ProviderClient: &gophercloud.ProviderClient{TokenID: "token"},
+		Endpoint:       srv.URL + "/",
+	}}
+
+	tests := []struct {
+		id       string
+		exists   bool
+		shutdown bool
+	}{
+		{id: "active", exists: true},
+		{id: "stopped", exists: true, shutdown: true},
+		{id: "shelved", exists: true, shutdown: true},
+		{id: "guest-shutdown", exists: true, shutdown: true},
+		{id: "crashed", exists: true, shutdown: true},
+		{id: "paused", exists: true},
+		{id: "soft-deleted"},
+		{id: "deleted"},
+		{id: "gone"},
+		{id: "no-extended-state", exists: true},
+	}
+	for _, test := range tests {
+		providerID := ProviderName + ":///" + test.id
+		exists, err := i.InstanceExistsByProviderID(context.TODO(), providerID)
+		if err != nil || exists != test.exists {
+			t.Errorf("%s: expected exists %v, got %v: %v", test.id, test.exists, exists, err)
+		}
+		shutdown, err := i.InstanceShutdownByProviderID(context.TODO(), providerID)
+		if err != nil || shutdown != test.shutdown {
+			t.Errorf("%s: expected shutdown %v, got %v: %v", test.id, test.shutdown, shutdown, err)
+		}
+	}
+}
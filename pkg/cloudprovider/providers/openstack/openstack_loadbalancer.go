@@ -82,11 +82,42 @@ const (
 	ServiceAnnotationLoadBalancerKeepFloatingIP    = "loadbalancer.openstack.org/keep-floatingip"
 	ServiceAnnotationLoadBalancerProxyEnabled      = "loadbalancer.openstack.org/proxy-protocol"
 	ServiceAnnotationLoadBalancerXForwardedFor     = "loadbalancer.openstack.org/x-forwarded-for"
+	ServiceAnnotationLoadBalancerFlavorID          = "loadbalancer.openstack.org/flavor-id"
+
+	// ServiceAnnotationLoadBalancerEnableHealthMonitor is the annotation used on the service to create health
+	// monitors for its pools, or to delete them when false. Defaults to the create-monitor option of the
+	// LoadBalancer section in cloud config. The other health monitor annotations override the monitor settings.
+	ServiceAnnotationLoadBalancerEnableHealthMonitor     = "loadbalancer.openstack.org/enable-health-monitor"
+	ServiceAnnotationLoadBalancerHealthMonitorType       = "loadbalancer.openstack.org/health-monitor-type"
+	ServiceAnnotationLoadBalancerHealthMonitorURLPath    = "loadbalancer.openstack.org/health-monitor-url-path"
+	ServiceAnnotationLoadBalancerHealthMonitorDelay      = "loadbalancer.openstack.org/health-monitor-delay"
+	ServiceAnnotationLoadBalancerHealthMonitorTimeout    = "loadbalancer.openstack.org/health-monitor-timeout"
+	ServiceAnnotationLoadBalancerHealthMonitorMaxRetries = "loadbalancer.openstack.org/health-monitor-max-retries"
+
+	// ServiceAnnotationLoadBalancerShared is the annotation used on the service to share a load balancer with the
+	// other services of the cluster with the same value. Each service gets its own listeners on it, the load
+	// balancer is deleted with the last of them. Needs use-octavia.
+	ServiceAnnotationLoadBalancerShared = "loadbalancer.openstack.org/shared"
+
+	// ServiceAnnotationLoadBalancerDryRun is the annotation used on the service to only report the changes
+	// EnsureLoadBalancer would make to the load balancer, without applying them. Defaults to the dry-run option
+	// of the LoadBalancer section in cloud config.
+	ServiceAnnotationLoadBalancerDryRun = "loadbalancer.openstack.org/dry-run"
+
+	// ServiceAnnotationLoadBalancerHibernate is the annotation used on the service to keep its load balancer, with
+	// listeners disabled, when the service is deleted. A service with the same name re-enables and reuses it.
+	// Defaults to the hibernate option of the LoadBalancer section in cloud config.
+	ServiceAnnotationLoadBalancerHibernate = "loadbalancer.openstack.org/hibernate"
 
 	// ServiceAnnotationLoadBalancerInternal is the annotation used on the service
 	// to indicate that we want an internal loadbalancer service.
-	// If the value of ServiceAnnotationLoadBalancerInternal is false, it indicates that we want an external loadbalancer service. Default to false.
-	ServiceAnnotationLoadBalancerInternal = "service.beta.kubernetes.io/openstack-internal-load-balancer"
+	// If the value of ServiceAnnotationLoadBalancerInternal is false, it indicates that we want an external loadbalancer service.
+	// Defaults to the internal-lb option of the LoadBalancer section in cloud config.
+	ServiceAnnotationLoadBalancerInternal = "loadbalancer.openstack.org/internal"
+
+	// ServiceAnnotationLoadBalancerInternalLegacy is the former name of ServiceAnnotationLoadBalancerInternal, only
+	// used when ServiceAnnotationLoadBalancerInternal is not set.
+	ServiceAnnotationLoadBalancerInternalLegacy = "service.beta.kubernetes.io/openstack-internal-load-balancer"
 )
 
 // LbaasV2 is a LoadBalancer implementation for Neutron LBaaS v2 API
@@ -94,6 +125,35 @@ type LbaasV2 struct {
 	LoadBalancer
 }
 
+// withContext returns a copy of lbaas whose OpenStack requests are cancelled
+// when ctx is done. The copy shares the state of lbaas.
+func (lbaas *LbaasV2) withContext(ctx context.Context) *LbaasV2 {
+	if lbaas.state == nil {
+		// Only in tests, LoadBalancer always sets it
+		lbaas.state = &lbState{nodeSecurityGroupIDs: lbaas.opts.NodeSecurityGroupIDs}
+	}
+	bound := *lbaas
+	bound.network = clientWithContext(ctx, lbaas.network)
+	bound.compute = clientWithContext(ctx, lbaas.compute)
+	bound.lb = clientWithContext(ctx, lbaas.lb)
+	return &bound
+}
+
+// nodeSecurityGroupIDs returns the security groups of the nodes known so far.
+func (lbaas *LbaasV2) nodeSecurityGroupIDs() []string {
+	lbaas.state.mu.Lock()
+	defer lbaas.state.mu.Unlock()
+	return lbaas.state.nodeSecurityGroupIDs
+}
+
+// setNodeSecurityGroupIDs records the security groups of the nodes for the
+// following calls.
+func (lbaas *LbaasV2) setNodeSecurityGroupIDs(ids []string) {
+	lbaas.state.mu.Lock()
+	defer lbaas.state.mu.Unlock()
+	lbaas.state.nodeSecurityGroupIDs = ids
+}
+
 func networkExtensions(client *gophercloud.ServiceClient) (map[string]bool, error) {
 	seen := make(map[string]bool)
 
@@ -234,10 +294,17 @@ func getListenersByLoadBalancerID(client *gophercloud.ServiceClient, id string)
 	return existingListeners, nil
 }
 
-// get listener for a port or nil if does not exist
-func getListenerForPort(existingListeners []listeners.Listener, port v1.ServicePort) *listeners.Listener {
+// get listener for a port using the given protocol, any protocol over the transport of the port if empty, or nil if does not exist
+func getListenerForPort(existingListeners []listeners.Listener, port v1.ServicePort, protocol listeners.Protocol) *listeners.Listener {
 	for _, l := range existingListeners {
-		if listeners.Protocol(l.Protocol) == toListenersProtocol(port.Protocol) && l.ProtocolPort == int(port.Port) {
+		if l.ProtocolPort != int(port.Port) {
+			continue
+		}
+		// A TCP and a UDP port can share the port number
+		if protocol == "" && (listeners.Protocol(l.Protocol) == listenerProtocolUDP) == (port.Protocol == v1.ProtocolUDP) {
+			return &l
+		}
+		if listeners.Protocol(l.Protocol) == protocol {
 			return &l
 		}
 	}
@@ -430,6 +497,13 @@ func toRuleProtocol(protocol v1.Protocol) rules.RuleProtocol {
 	}
 }
 
+// The UDP protocols of Octavia, Neutron LBaaS only balances TCP
+const (
+	listenerProtocolUDP   = listeners.Protocol("UDP")
+	poolProtocolUDP       = v2pools.Protocol("UDP")
+	monitorTypeUDPConnect = "UDP-CONNECT"
+)
+
 func toListenersProtocol(protocol v1.Protocol) listeners.Protocol {
 	switch protocol {
 	case v1.ProtocolTCP:
@@ -439,46 +513,121 @@ func toListenersProtocol(protocol v1.Protocol) listeners.Protocol {
 	}
 }
 
-func createNodeSecurityGroup(client *gophercloud.ServiceClient, nodeSecurityGroupID string, port int, protocol v1.Protocol, lbSecGroup string) error {
-	v4NodeSecGroupRuleCreateOpts := rules.CreateOpts{
-		Direction:     rules.DirIngress,
-		PortRangeMax:  port,
-		PortRangeMin:  port,
-		Protocol:      toRuleProtocol(protocol),
-		RemoteGroupID: lbSecGroup,
-		SecGroupID:    nodeSecurityGroupID,
-		EtherType:     rules.EtherType4,
-	}
+// nodeSecurityGroupRules returns the rules of a node security group allowing
+// the traffic from the security group of a load balancer to a node port.
+func nodeSecurityGroupRules(nodePort int, protocol v1.Protocol, lbSecGroup string) []rules.CreateOpts {
+	var opts []rules.CreateOpts
+	for _, ethertype := range []rules.RuleEtherType{rules.EtherType4, rules.EtherType6} {
+		opts = append(opts, rules.CreateOpts{
+			Direction:     rules.DirIngress,
+			PortRangeMax:  nodePort,
+			PortRangeMin:  nodePort,
+			Protocol:      toRuleProtocol(protocol),
+			RemoteGroupID: lbSecGroup,
+			EtherType:     ethertype,
+		})
+	}
+	return opts
+}
 
-	v6NodeSecGroupRuleCreateOpts := rules.CreateOpts{
-		Direction:     rules.DirIngress,
-		PortRangeMax:  port,
-		PortRangeMin:  port,
-		Protocol:      toRuleProtocol(protocol),
-		RemoteGroupID: lbSecGroup,
-		SecGroupID:    nodeSecurityGroupID,
-		EtherType:     rules.EtherType6,
-	}
+// lbSecurityGroupRules returns the rules of the security group of a load
+// balancer allowing the traffic from the source ranges to the ports.
+func lbSecurityGroupRules(ports []v1.ServicePort, sourceRanges []string) ([]rules.CreateOpts, error) {
+	var opts []rules.CreateOpts
+	for _, port := range ports {
+		for _, sourceRange := range sourceRanges {
+			ethertype := rules.EtherType4
+			network, _, err := net.ParseCIDR(sourceRange)
 
-	_, err := rules.Create(client, v4NodeSecGroupRuleCreateOpts).Extract()
+			if err != nil {
+				return nil, fmt.Errorf("error parsing source range %s as a CIDR: %v", sourceRange, err)
+			}
 
-	if err != nil {
-		return err
+			if network.To4() == nil {
+				ethertype = rules.EtherType6
+			}
+
+			opts = append(opts, rules.CreateOpts{
+				Direction:      rules.DirIngress,
+				PortRangeMax:   int(port.Port),
+				PortRangeMin:   int(port.Port),
+				Protocol:       toRuleProtocol(port.Protocol),
+				RemoteIPPrefix: sourceRange,
+				EtherType:      ethertype,
+			})
+		}
+	}
+
+	opts = append(opts, rules.CreateOpts{
+		Direction:      rules.DirIngress,
+		PortRangeMax:   4, // ICMP: Code -  Values for ICMP  "Destination Unreachable: Fragmentation Needed and Don't Fragment was Set"
+		PortRangeMin:   3, // ICMP: Type
+		Protocol:       rules.ProtocolICMP,
+		RemoteIPPrefix: "0.0.0.0/0", // The Fragmentation packet can come from anywhere along the path back to the sourceRange - we need to all this from all
+		EtherType:      rules.EtherType4,
+	}, rules.CreateOpts{
+		Direction:      rules.DirIngress,
+		PortRangeMax:   0, // ICMP: Code - Values for ICMP "Packet Too Big"
+		PortRangeMin:   2, // ICMP: Type
+		Protocol:       rules.ProtocolICMP,
+		RemoteIPPrefix: "::/0", // The Fragmentation packet can come from anywhere along the path back to the sourceRange - we need to all this from all
+		EtherType:      rules.EtherType6,
+	})
+	return opts, nil
+}
+
+// ensureNodeSecurityGroupRules ensures the rules of a node security group
+// allowing the traffic from the security group of a load balancer to the
+// node ports. Only the rules with the security group of the load balancer as
+// remote group are considered, those of other Services are left alone. They
+// are all owned by the load balancer, including the untagged ones created by
+// older releases, and deleted when not desired anymore.
+func ensureNodeSecurityGroupRules(client *gophercloud.ServiceClient, nodeSecurityGroupID, lbSecGroupID, lbSecGroupName string, ports []v1.ServicePort, plan *lbPlan) error {
+	var desired []rules.CreateOpts
+	for _, port := range ports {
+		desired = append(desired, nodeSecurityGroupRules(int(port.NodePort), port.Protocol, lbSecGroupID)...)
+	}
+
+	var existing []rules.SecGroupRule
+	if lbSecGroupID != "" {
+		var err error
+		existing, err = getSecurityGroupRules(client, rules.ListOpts{SecGroupID: nodeSecurityGroupID, RemoteGroupID: lbSecGroupID})
+		if err != nil && !cpoerrors.IsNotFound(err) {
+			return fmt.Errorf("error finding rules for remote group id %s in security group id %s: %v", lbSecGroupID, nodeSecurityGroupID, err)
+		}
 	}
+	_, err := reconcileSecGroupRules(client, nodeSecurityGroupID, fmt.Sprintf("node security group %s from %s", nodeSecurityGroupID, lbSecGroupName), existing, desired, lbSecGroupID, plan)
+	return err
+}
 
-	_, err = rules.Create(client, v6NodeSecGroupRuleCreateOpts).Extract()
+// loadBalancerCreateOpts sets the Octavia flavor of a new load balancer.
+type loadBalancerCreateOpts struct {
+	loadbalancers.CreateOpts
+	// FlavorID is not set when ""
+	FlavorID string
+}
 
-	if err != nil {
-		return err
+func (opts loadBalancerCreateOpts) ToLoadBalancerCreateMap() (map[string]interface{}, error) {
+	b, err := opts.CreateOpts.ToLoadBalancerCreateMap()
+	if err != nil || opts.FlavorID == "" {
+		return b, err
 	}
-	return nil
+	b["loadbalancer"].(map[string]interface{})["flavor_id"] = opts.FlavorID
+	return b, nil
 }
 
-func (lbaas *LbaasV2) createLoadBalancer(service *v1.Service, name, clusterName string, internalAnnotation bool, vipPort string) (*loadbalancers.LoadBalancer, error) {
-	createOpts := loadbalancers.CreateOpts{
-		Name:        name,
-		Description: fmt.Sprintf("Kubernetes external service %s/%s from cluster %s", service.Namespace, service.Name, clusterName),
-		Provider:    lbaas.opts.LBProvider,
+func (lbaas *LbaasV2) createLoadBalancer(service *v1.Service, name, clusterName string, internalAnnotation bool, vipPort, flavorID string) (*loadbalancers.LoadBalancer, error) {
+	description := fmt.Sprintf("Kubernetes external service %s/%s from cluster %s", service.Namespace, service.Name, clusterName)
+	if strings.HasPrefix(name, sharedLBNamePrefix) {
+		description = fmt.Sprintf("Kubernetes external services sharing %s from cluster %s", getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerShared, ""), clusterName)
+	}
+	createOpts := loadBalancerCreateOpts{
+		CreateOpts: loadbalancers.CreateOpts{
+			Name:        name,
+			Description: description,
+			Provider:    lbaas.opts.LBProvider,
+		},
+		FlavorID: flavorID,
 	}
 
 	if vipPort != "" {
@@ -501,8 +650,11 @@ func (lbaas *LbaasV2) createLoadBalancer(service *v1.Service, name, clusterName
 
 // GetLoadBalancer returns whether the specified load balancer exists and its status
 func (lbaas *LbaasV2) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
-	name := lbaas.GetLoadBalancerName(ctx, clusterName, service)
-	legacyName := lbaas.GetLoadBalancerLegacyName(ctx, clusterName, service)
+	lbaas = lbaas.withContext(ctx)
+	name, legacyName, err := lbaas.getServiceLoadBalancerName(ctx, clusterName, service)
+	if err != nil {
+		return nil, false, err
+	}
 	loadbalancer, err := getLoadbalancerByName(lbaas.lb, name, legacyName)
 	if err == ErrNotFound {
 		return nil, false, nil
@@ -529,7 +681,7 @@ func (lbaas *LbaasV2) GetLoadBalancer(ctx context.Context, clusterName string, s
 
 // GetLoadBalancerName returns the constructed load balancer name.
 func (lbaas *LbaasV2) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
-	name := fmt.Sprintf("kube_service_%s_%s_%s", clusterName, service.Namespace, service.Name)
+	name := fmt.Sprintf("%s%s_%s_%s", lbNamePrefix, clusterName, service.Namespace, service.Name)
 	return cutString(name)
 }
 
@@ -587,6 +739,15 @@ func getStringFromServiceAnnotation(service *v1.Service, annotationKey string, d
 	return defaultSetting
 }
 
+// isInternalLoadBalancer returns whether the Service asks for an internal load balancer, with the
+// loadbalancer.openstack.org/internal annotation or its legacy name, or the defaultSetting from cloud config.
+func isInternalLoadBalancer(service *v1.Service, defaultSetting bool) (bool, error) {
+	if _, ok := service.Annotations[ServiceAnnotationLoadBalancerInternal]; ok {
+		return getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerInternal, defaultSetting)
+	}
+	return getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerInternalLegacy, defaultSetting)
+}
+
 //getBoolFromServiceAnnotation searches a given v1.Service for a specific annotationKey and either returns the annotation's value or a specified defaultSetting
 func getBoolFromServiceAnnotation(service *v1.Service, annotationKey string, defaultSetting bool) (bool, error) {
 	klog.V(4).Infof("getBoolFromServiceAnnotation(%v, %v, %v)", service, annotationKey, defaultSetting)
@@ -608,12 +769,85 @@ func getBoolFromServiceAnnotation(service *v1.Service, annotationKey string, def
 	return defaultSetting, nil
 }
 
-// getSubnetIDForLB returns subnet-id for a specific node
-func getSubnetIDForLB(compute *gophercloud.ServiceClient, node v1.Node) (string, error) {
-	ipAddress, err := nodeAddressForLB(&node)
+// deletePool deletes a pool of a load balancer along with its monitor and
+// members.
+func (lbaas *LbaasV2) deletePool(loadbalancerID string, pool *v2pools.Pool, detail string, plan *lbPlan) error {
+	monitorID := pool.MonitorID
+	if monitorID != "" && plan.apply(lbChange{Action: lbActionDelete, Resource: lbResourceMonitor, ID: monitorID, Detail: fmt.Sprintf("for pool %s", pool.Name)}) {
+		klog.V(4).Infof("Deleting monitor %s for pool %s", monitorID, pool.ID)
+		err := v2monitors.Delete(lbaas.lb, monitorID).ExtractErr()
+		if err != nil && !cpoerrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting monitor %s for pool %s: %v", monitorID, pool.ID, err)
+		}
+		provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancerID)
+		if err != nil {
+			return fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+		}
+	}
+	members, err := getMembersByPoolID(lbaas.lb, pool.ID)
+	if err != nil && !cpoerrors.IsNotFound(err) {
+		return fmt.Errorf("error getting members for pool %s: %v", pool.ID, err)
+	}
+	for _, member := range members {
+		if !plan.apply(lbChange{Action: lbActionDelete, Resource: lbResourceMember, Name: member.Name, ID: member.ID, Detail: fmt.Sprintf("%s:%d in pool %s", member.Address, member.ProtocolPort, pool.Name)}) {
+			continue
+		}
+		klog.V(4).Infof("Deleting member %s for pool %s address %s", member.ID, pool.ID, member.Address)
+		err := v2pools.DeleteMember(lbaas.lb, pool.ID, member.ID).ExtractErr()
+		if err != nil && !cpoerrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting member %s for pool %s address %s: %v", member.ID, pool.ID, member.Address, err)
+		}
+		provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancerID)
+		if err != nil {
+			return fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+		}
+	}
+	if !plan.apply(lbChange{Action: lbActionDelete, Resource: lbResourcePool, Name: pool.Name, ID: pool.ID, Detail: detail}) {
+		return nil
+	}
+	klog.V(4).Infof("Deleting pool %s %s", pool.ID, detail)
+	err = v2pools.Delete(lbaas.lb, pool.ID).ExtractErr()
+	if err != nil && !cpoerrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting pool %s: %v", pool.ID, err)
+	}
+	provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancerID)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
 	}
+	return nil
+}
+
+// deleteListener deletes a listener of a load balancer along with its pool.
+func (lbaas *LbaasV2) deleteListener(loadbalancerID string, listener listeners.Listener, plan *lbPlan) error {
+	pool, err := getPoolByListenerID(lbaas.lb, loadbalancerID, listener.ID)
+	if err != nil && err != ErrNotFound {
+		return fmt.Errorf("error getting pool for listener %s: %v", listener.ID, err)
+	}
+	if pool != nil {
+		if err := lbaas.deletePool(loadbalancerID, pool, fmt.Sprintf("for listener %s", listener.Name), plan); err != nil {
+			return err
+		}
+	}
+	if !plan.apply(lbChange{Action: lbActionDelete, Resource: lbResourceListener, Name: listener.Name, ID: listener.ID, Detail: fmt.Sprintf("%s port %d", listener.Protocol, listener.ProtocolPort)}) {
+		return nil
+	}
+	err = listeners.Delete(lbaas.lb, listener.ID).ExtractErr()
+	if err != nil && !cpoerrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting listener %s: %v", listener.ID, err)
+	}
+	provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancerID)
+	if err != nil {
+		return fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+	}
+	klog.V(2).Infof("Deleted listener: %s", listener.ID)
+	return nil
+}
+
+// getSubnetIDForLB returns subnet-id for a specific node, the one of its
+// pool member address
+func getSubnetIDForLB(compute *gophercloud.ServiceClient, member poolMember) (string, error) {
+	ipAddress := member.addr
+	node := member.node
 
 	instanceID := node.Spec.ProviderID
 	if ind := strings.LastIndex(instanceID, "/"); ind >= 0 {
@@ -814,20 +1048,54 @@ func getFloatingNetworkIDForLB(client *gophercloud.ServiceClient) (string, error
 // each region.
 
 // EnsureLoadBalancer creates a new load balancer or updates the existing one.
-func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string, apiService *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string, apiService *v1.Service, nodes []*v1.Node) (_ *v1.LoadBalancerStatus, err error) {
+	lbaas = lbaas.withContext(ctx)
 	serviceName := fmt.Sprintf("%s/%s", apiService.Namespace, apiService.Name)
+	end := operationHistory.Begin("EnsureLoadBalancer", serviceName)
+	defer func() { end(err) }()
 
 	klog.V(4).Infof("EnsureLoadBalancer(%s, %s)", clusterName, serviceName)
 
+	dryRun, err := lbaas.isDryRun(apiService)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := newLBPlan(serviceName, dryRun)
+	status, err := lbaas.ensureLoadBalancer(ctx, clusterName, apiService, nodes, plan)
+	if !dryRun {
+		return status, err
+	}
+
+	lbaas.reportPlan("EnsureLoadBalancer", apiService, plan, err)
+	if err != nil {
+		return nil, err
+	}
+	// Keep the Service status untouched
+	return &apiService.Status.LoadBalancer, nil
+}
+
+// ensureLoadBalancer reconciles the load balancer of the Service, passing every mutation through plan.
+func (lbaas *LbaasV2) ensureLoadBalancer(ctx context.Context, clusterName string, apiService *v1.Service, nodes []*v1.Node, plan *lbPlan) (*v1.LoadBalancerStatus, error) {
+	serviceName := fmt.Sprintf("%s/%s", apiService.Namespace, apiService.Name)
+
 	if len(nodes) == 0 {
 		return nil, fmt.Errorf("there are no available nodes for LoadBalancer service %s", serviceName)
 	}
 
+	poolMembers, err := lbaas.getPoolMembers(apiService, nodes)
+	if err != nil {
+		return nil, err
+	}
+	if len(poolMembers) == 0 {
+		return nil, fmt.Errorf("none of the nodes has an address for LoadBalancer service %s", serviceName)
+	}
+
 	lbaas.opts.SubnetID = getStringFromServiceAnnotation(apiService, ServiceAnnotationLoadBalancerSubnetID, lbaas.opts.SubnetID)
 	if len(lbaas.opts.SubnetID) == 0 {
 		// Get SubnetID automatically.
 		// The LB needs to be configured with instance addresses on the same subnet, so get SubnetID by one node.
-		subnetID, err := getSubnetIDForLB(lbaas.compute, *nodes[0])
+		subnetID, err := getSubnetIDForLB(lbaas.compute, poolMembers[0])
 		if err != nil {
 			klog.Warningf("Failed to find subnet-id for loadbalancer service %s/%s: %v", apiService.Namespace, apiService.Name, err)
 			return nil, fmt.Errorf("no subnet-id for service %s/%s : subnet-id not set in cloud provider config, "+
@@ -850,7 +1118,7 @@ func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string
 		}
 	}
 
-	internalAnnotation, err := getBoolFromServiceAnnotation(apiService, ServiceAnnotationLoadBalancerInternal, lbaas.opts.InternalLB)
+	internalAnnotation, err := isInternalLoadBalancer(apiService, lbaas.opts.InternalLB)
 	if err != nil {
 		return nil, err
 	}
@@ -865,10 +1133,16 @@ func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string
 		}
 	}
 
-	// Check for TCP protocol on each port
+	// Check for TCP or UDP protocol on each port, a Service can mix both
 	for _, port := range ports {
-		if port.Protocol != v1.ProtocolTCP {
-			return nil, fmt.Errorf("only TCP LoadBalancer is supported for openstack load balancers")
+		switch port.Protocol {
+		case v1.ProtocolTCP:
+		case v1.ProtocolUDP:
+			if !lbaas.opts.UseOctavia {
+				return nil, fmt.Errorf("UDP LoadBalancer needs use-octavia in cloud provider config")
+			}
+		default:
+			return nil, fmt.Errorf("only TCP and UDP LoadBalancer are supported for openstack load balancers")
 		}
 	}
 
@@ -893,28 +1167,54 @@ func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string
 	}
 
 	// Use more meaningful name for the load balancer but still need to check the legacy name for backward compatibility.
+	// The listeners, pools and members of the Service are named after name, also on a shared load balancer.
 	name := lbaas.GetLoadBalancerName(ctx, clusterName, apiService)
-	legacyName := lbaas.GetLoadBalancerLegacyName(ctx, clusterName, apiService)
-	loadbalancer, err := getLoadbalancerByName(lbaas.lb, name, legacyName)
+	lbName, legacyName, err := lbaas.getServiceLoadBalancerName(ctx, clusterName, apiService)
+	if err != nil {
+		return nil, err
+	}
+	loadbalancer, err := getLoadbalancerByName(lbaas.lb, lbName, legacyName)
 	if err != nil {
 		if err != ErrNotFound {
 			return nil, fmt.Errorf("error getting loadbalancer for Service %s: %v", serviceName, err)
 		}
 
-		klog.V(2).Infof("Creating loadbalancer %s", name)
+		if err := lbaas.checkLoadBalancerQuota(apiService, len(ports)); err != nil {
+			return nil, err
+		}
 
 		portID := getStringFromServiceAnnotation(apiService, ServiceAnnotationLoadBalancerPortID, "")
-		loadbalancer, err = lbaas.createLoadBalancer(apiService, name, clusterName, internalAnnotation, portID)
-		if err != nil {
-			return nil, fmt.Errorf("error creating loadbalancer %s: %v", name, err)
+		flavorID := getStringFromServiceAnnotation(apiService, ServiceAnnotationLoadBalancerFlavorID, lbaas.opts.FlavorID)
+		if flavorID != "" && !lbaas.opts.UseOctavia {
+			return nil, fmt.Errorf("%s needs use-octavia in cloud provider config", ServiceAnnotationLoadBalancerFlavorID)
+		}
+		if plan.apply(lbChange{Action: lbActionCreate, Resource: lbResourceLoadBalancer, Name: lbName, Detail: fmt.Sprintf("subnet %s", lbaas.opts.SubnetID)}) {
+			klog.V(2).Infof("Creating loadbalancer %s", lbName)
+
+			loadbalancer, err = lbaas.createLoadBalancer(apiService, lbName, clusterName, internalAnnotation, portID, flavorID)
+			if err != nil {
+				return nil, fmt.Errorf("error creating loadbalancer %s: %v", lbName, err)
+			}
+		} else {
+			loadbalancer = &loadbalancers.LoadBalancer{Name: lbName, VipPortID: portID}
 		}
 	} else {
 		klog.V(2).Infof("LoadBalancer %s already exists", loadbalancer.Name)
 	}
 
-	provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+	// Objects only planned in dry-run mode have no ID and nothing to look up
+	if loadbalancer.ID != "" {
+		provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+		}
+
+		// The load balancer may have been kept when an earlier Service with the same name got deleted
+		if loadbalancer.VipPortID != "" {
+			if err := lbaas.resumeLoadBalancer(loadbalancer, plan); err != nil {
+				return nil, fmt.Errorf("failed to resume hibernated loadbalancer %s: %v", loadbalancer.ID, err)
+			}
+		}
 	}
 
 	lbmethod := v2pools.LBMethod(lbaas.opts.LBMethod)
@@ -922,12 +1222,41 @@ func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string
 		lbmethod = v2pools.LBMethodRoundRobin
 	}
 
-	oldListeners, err := getListenersByLoadBalancerID(lbaas.lb, loadbalancer.ID)
+	monitored, err := lbaas.isHealthMonitorEnabled(apiService)
 	if err != nil {
-		return nil, fmt.Errorf("error getting LB %s listeners: %v", loadbalancer.Name, err)
+		return nil, err
+	}
+
+	var oldListeners []listeners.Listener
+	if loadbalancer.ID != "" {
+		oldListeners, err = getListenersByLoadBalancerID(lbaas.lb, loadbalancer.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting LB %s listeners: %v", loadbalancer.Name, err)
+		}
+	}
+	shared := isSharedLoadBalancer(loadbalancer)
+	if shared {
+		// Only the listeners of the Service are reconciled, those of the
+		// other Services sharing the load balancer are left alone
+		var others []listeners.Listener
+		oldListeners, others = splitListeners(oldListeners, name)
+		if err := checkSharedListenerPorts(apiService, others); err != nil {
+			return nil, err
+		}
 	}
 	for portIndex, port := range ports {
-		listener := getListenerForPort(oldListeners, port)
+		settings, err := lbaas.portListenerSettings(apiService, port)
+		if err != nil {
+			return nil, err
+		}
+		listener := getListenerForPort(oldListeners, port, settings.protocol)
+		if listener == nil {
+			// The port cannot get a second listener, keep the existing one
+			if existing := getListenerForPort(oldListeners, port, ""); existing != nil {
+				klog.Warningf("Listener %s of port %d uses protocol %s instead of %s, recreate Service %s to change it", existing.ID, port.Port, existing.Protocol, settings.protocol, serviceName)
+				listener = existing
+			}
+		}
 		climit := getStringFromServiceAnnotation(apiService, ServiceAnnotationLoadBalancerConnLimit, "-1")
 		connLimit := -1
 		tmp, err := strconv.Atoi(climit)
@@ -937,55 +1266,50 @@ func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string
 			connLimit = tmp
 		}
 
-		keepClientIP := false
 		if listener == nil {
-			listenerProtocol := listeners.Protocol(port.Protocol)
-			keepClientIP, err = getBoolFromServiceAnnotation(apiService, ServiceAnnotationLoadBalancerXForwardedFor, false)
-			if err != nil {
-				return nil, err
-			}
-			if keepClientIP {
-				listenerProtocol = listeners.ProtocolHTTP
-			}
-
+			listenerProtocol := settings.protocol
 			listenerCreateOpt := listeners.CreateOpts{
 				Name:           cutString(fmt.Sprintf("listener_%d_%s", portIndex, name)),
 				Protocol:       listenerProtocol,
 				ProtocolPort:   int(port.Port),
 				ConnLimit:      &connLimit,
 				LoadbalancerID: loadbalancer.ID,
-			}
-			if keepClientIP {
-				listenerCreateOpt.InsertHeaders = map[string]string{"X-Forwarded-For": "true"}
+				InsertHeaders:  settings.insertHeaders,
 			}
 
-			klog.V(4).Infof("Creating listener for port %d using protocol: %s", int(port.Port), listenerProtocol)
+			if plan.apply(lbChange{Action: lbActionCreate, Resource: lbResourceListener, Name: listenerCreateOpt.Name, Detail: fmt.Sprintf("%s port %d, connection limit %d", listenerProtocol, int(port.Port), connLimit)}) {
+				klog.V(4).Infof("Creating listener for port %d using protocol: %s", int(port.Port), listenerProtocol)
 
-			listener, err = listeners.Create(lbaas.lb, listenerCreateOpt).Extract()
-			if err != nil {
-				// Unknown error, retry later
-				return nil, fmt.Errorf("error creating LB listener: %v", err)
-			}
-			provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
-			if err != nil {
-				return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+				listener, err = listeners.Create(lbaas.lb, listenerCreateOpt).Extract()
+				if err != nil {
+					// Unknown error, retry later
+					return nil, fmt.Errorf("error creating LB listener: %v", err)
+				}
+				provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
+				if err != nil {
+					return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+				}
+			} else {
+				listener = &listeners.Listener{Name: listenerCreateOpt.Name}
 			}
 		} else {
 			if connLimit != listener.ConnLimit {
-				klog.V(4).Infof("Updating listener connection limit from %d to %d", listener.ConnLimit, connLimit)
+				if plan.apply(lbChange{Action: lbActionUpdate, Resource: lbResourceListener, Name: listener.Name, ID: listener.ID, Detail: fmt.Sprintf("connection limit %d -> %d", listener.ConnLimit, connLimit)}) {
+					klog.V(4).Infof("Updating listener connection limit from %d to %d", listener.ConnLimit, connLimit)
 
-				updateOpts := listeners.UpdateOpts{
-					ConnLimit: &connLimit,
-				}
+					updateOpts := listeners.UpdateOpts{
+						ConnLimit: &connLimit,
+					}
 
-				_, err := listeners.Update(lbaas.lb, listener.ID, updateOpts).Extract()
-				if err != nil {
-					return nil, fmt.Errorf("error updating LB listener: %v", err)
-				}
+					_, err := listeners.Update(lbaas.lb, listener.ID, updateOpts).Extract()
+					if err != nil {
+						return nil, fmt.Errorf("error updating LB listener: %v", err)
+					}
 
-				provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
-				if err != nil {
-					return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+					provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
+					if err != nil {
+						return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+					}
 				}
 			}
 		}
@@ -996,27 +1320,24 @@ func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string
 		// Pop valid listeners.
 		oldListeners = popListener(oldListeners, listener.ID)
 
-		pool, err := getPoolByListenerID(lbaas.lb, loadbalancer.ID, listener.ID)
-		if err != nil && err != ErrNotFound {
-			return nil, fmt.Errorf("error getting pool for listener %s: %v", listener.ID, err)
+		var pool *v2pools.Pool
+		if listener.ID != "" {
+			pool, err = getPoolByListenerID(lbaas.lb, loadbalancer.ID, listener.ID)
+			if err != nil && err != ErrNotFound {
+				return nil, fmt.Errorf("error getting pool for listener %s: %v", listener.ID, err)
+			}
 		}
-		if pool == nil {
-			// By default, use TCP as the pool protocol.
-			poolProto := v2pools.ProtocolTCP
-
-			useProxyProtocol, err := getBoolFromServiceAnnotation(apiService, ServiceAnnotationLoadBalancerProxyEnabled, false)
-			if err != nil {
+		// The protocol of a pool cannot be updated, e.g. when the
+		// proxy-protocol annotation changes. Unless the listener was kept
+		// with its former protocol, the pool is replaced.
+		if pool != nil && pool.Protocol != string(settings.poolProtocol) && listeners.Protocol(listener.Protocol) == settings.protocol {
+			if err := lbaas.deletePool(loadbalancer.ID, pool, fmt.Sprintf("%s for listener %s, replaced by %s", pool.Protocol, listener.Name, settings.poolProtocol), plan); err != nil {
 				return nil, err
 			}
-			if useProxyProtocol && keepClientIP {
-				return nil, fmt.Errorf("annotation %s and %s cannot be used together", ServiceAnnotationLoadBalancerProxyEnabled, ServiceAnnotationLoadBalancerXForwardedFor)
-			}
-			if useProxyProtocol {
-				poolProto = v2pools.ProtocolPROXY
-			} else if keepClientIP {
-				poolProto = v2pools.ProtocolHTTP
-			}
-
+			pool = nil
+		}
+		if pool == nil {
+			poolProto := settings.poolProtocol
 			createOpt := v2pools.CreateOpts{
 				Name:        cutString(fmt.Sprintf("pool_%d_%s", portIndex, name)),
 				Protocol:    poolProto,
@@ -1025,52 +1346,59 @@ func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string
 				Persistence: persistence,
 			}
 
-			klog.V(4).Infof("Creating pool for listener %s using protocol %s", listener.ID, poolProto)
+			if plan.apply(lbChange{Action: lbActionCreate, Resource: lbResourcePool, Name: createOpt.Name, Detail: fmt.Sprintf("%s %s for listener %s", poolProto, lbmethod, listener.Name)}) {
+				klog.V(4).Infof("Creating pool for listener %s using protocol %s", listener.ID, poolProto)
 
-			pool, err = v2pools.Create(lbaas.lb, createOpt).Extract()
-			if err != nil {
-				return nil, fmt.Errorf("error creating pool for listener %s: %v", listener.ID, err)
-			}
-			provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
-			if err != nil {
-				return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+				pool, err = v2pools.Create(lbaas.lb, createOpt).Extract()
+				if err != nil {
+					return nil, fmt.Errorf("error creating pool for listener %s: %v", listener.ID, err)
+				}
+				provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
+				if err != nil {
+					return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+				}
+			} else {
+				pool = &v2pools.Pool{Name: createOpt.Name}
 			}
-
 		}
 
 		klog.V(4).Infof("Pool created for listener %s: %s", listener.ID, pool.ID)
 
-		members, err := getMembersByPoolID(lbaas.lb, pool.ID)
-		if err != nil && !cpoerrors.IsNotFound(err) {
-			return nil, fmt.Errorf("error getting pool members %s: %v", pool.ID, err)
-		}
-		for _, node := range nodes {
-			addr, err := nodeAddressForLB(node)
-			if err != nil {
-				if err == ErrNotFound {
-					// Node failure, do not create member
-					klog.Warningf("Failed to create LB pool member for node %s: %v", node.Name, err)
-					continue
-				} else {
-					return nil, fmt.Errorf("error getting address for node %s: %v", node.Name, err)
-				}
+		var members []v2pools.Member
+		if pool.ID != "" {
+			members, err = getMembersByPoolID(lbaas.lb, pool.ID)
+			if err != nil && !cpoerrors.IsNotFound(err) {
+				return nil, fmt.Errorf("error getting pool members %s: %v", pool.ID, err)
 			}
+		}
+		for _, member := range poolMembers {
+			node, addr := member.node, member.addr
 
 			if !memberExists(members, addr, int(port.NodePort)) {
-				klog.V(4).Infof("Creating member for pool %s", pool.ID)
-				_, err := v2pools.CreateMember(lbaas.lb, pool.ID, v2pools.CreateMemberOpts{
-					Name:         cutString(fmt.Sprintf("member_%d_%s_%s", portIndex, node.Name, name)),
-					ProtocolPort: int(port.NodePort),
-					Address:      addr,
-					SubnetID:     lbaas.opts.SubnetID,
-				}).Extract()
-				if err != nil {
-					return nil, fmt.Errorf("error creating LB pool member for node: %s, %v", node.Name, err)
-				}
+				memberName := cutString(fmt.Sprintf("member_%d_%s_%s", portIndex, node.Name, name))
+				if plan.apply(lbChange{Action: lbActionCreate, Resource: lbResourceMember, Name: memberName, Detail: fmt.Sprintf("%s:%d in pool %s", addr, int(port.NodePort), pool.Name)}) {
+					klog.V(4).Infof("Creating member for pool %s", pool.ID)
+					monitorPort, err := lbaas.memberMonitorPort(apiService, port)
+					if err != nil {
+						return nil, err
+					}
+					_, err = v2pools.CreateMember(lbaas.lb, pool.ID, memberCreateOpts{
+						CreateMemberOpts: v2pools.CreateMemberOpts{
+							Name:         memberName,
+							ProtocolPort: int(port.NodePort),
+							Address:      addr,
+							SubnetID:     lbaas.opts.SubnetID,
+						},
+						MonitorPort: monitorPort,
+					}).Extract()
+					if err != nil {
+						return nil, fmt.Errorf("error creating LB pool member for node: %s, %v", node.Name, err)
+					}
 
-				provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
-				if err != nil {
-					return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+					provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
+					if err != nil {
+						return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+					}
 				}
 			} else {
 				// After all members have been processed, remaining members are deleted as obsolete.
@@ -1082,6 +1410,9 @@ func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string
 
 		// Delete obsolete members for this pool
 		for _, member := range members {
+			if !plan.apply(lbChange{Action: lbActionDelete, Resource: lbResourceMember, Name: member.Name, ID: member.ID, Detail: fmt.Sprintf("%s:%d in pool %s", member.Address, member.ProtocolPort, pool.Name)}) {
+				continue
+			}
 			klog.V(4).Infof("Deleting obsolete member %s for pool %s address %s", member.ID, pool.ID, member.Address)
 			err := v2pools.DeleteMember(lbaas.lb, pool.ID, member.ID).ExtractErr()
 			if err != nil && !cpoerrors.IsNotFound(err) {
@@ -1094,25 +1425,22 @@ func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string
 		}
 
 		monitorID := pool.MonitorID
-		if monitorID == "" && lbaas.opts.CreateMonitor {
-			klog.V(4).Infof("Creating monitor for pool %s", pool.ID)
-			monitor, err := v2monitors.Create(lbaas.lb, v2monitors.CreateOpts{
-				Name:       cutString(fmt.Sprintf("monitor_%d_%s)", portIndex, name)),
-				PoolID:     pool.ID,
-				Type:       string(port.Protocol),
-				Delay:      int(lbaas.opts.MonitorDelay.Duration.Seconds()),
-				Timeout:    int(lbaas.opts.MonitorTimeout.Duration.Seconds()),
-				MaxRetries: int(lbaas.opts.MonitorMaxRetries),
-			}).Extract()
+		if monitored {
+			hc, err := lbaas.serviceHealthCheck(apiService, port)
 			if err != nil {
-				return nil, fmt.Errorf("error creating LB pool healthmonitor: %v", err)
+				return nil, err
 			}
-			provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
+			monitorName := cutString(fmt.Sprintf("monitor_%d_%s)", portIndex, name))
+			monitorID, err = lbaas.ensurePoolHealthCheck(loadbalancer.ID, pool, hc, monitorName, plan)
 			if err != nil {
-				return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+				return nil, err
+			}
+		} else if _, ok := apiService.Annotations[ServiceAnnotationLoadBalancerEnableHealthMonitor]; ok && monitorID != "" {
+			if err := lbaas.deletePoolHealthCheck(loadbalancer.ID, pool, plan); err != nil {
+				return nil, err
 			}
-			monitorID = monitor.ID
-		} else if lbaas.opts.CreateMonitor == false {
+			monitorID = ""
+		} else {
 			klog.V(4).Infof("Do not create monitor for pool %s when create-monitor is false", pool.ID)
 		}
 
@@ -1124,70 +1452,28 @@ func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string
 	// All remaining listeners are obsolete, delete
 	for _, listener := range oldListeners {
 		klog.V(4).Infof("Deleting obsolete listener %s:", listener.ID)
-		// get pool for listener
-		pool, err := getPoolByListenerID(lbaas.lb, loadbalancer.ID, listener.ID)
-		if err != nil && err != ErrNotFound {
-			return nil, fmt.Errorf("error getting pool for obsolete listener %s: %v", listener.ID, err)
-		}
-		if pool != nil {
-			// get and delete monitor
-			monitorID := pool.MonitorID
-			if monitorID != "" {
-				klog.V(4).Infof("Deleting obsolete monitor %s for pool %s", monitorID, pool.ID)
-				err = v2monitors.Delete(lbaas.lb, monitorID).ExtractErr()
-				if err != nil && !cpoerrors.IsNotFound(err) {
-					return nil, fmt.Errorf("error deleting obsolete monitor %s for pool %s: %v", monitorID, pool.ID, err)
-				}
-				provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
-				if err != nil {
-					return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
-				}
-			}
-			// get and delete pool members
-			members, err := getMembersByPoolID(lbaas.lb, pool.ID)
-			if err != nil && !cpoerrors.IsNotFound(err) {
-				return nil, fmt.Errorf("error getting members for pool %s: %v", pool.ID, err)
-			}
-			if members != nil {
-				for _, member := range members {
-					klog.V(4).Infof("Deleting obsolete member %s for pool %s address %s", member.ID, pool.ID, member.Address)
-					err := v2pools.DeleteMember(lbaas.lb, pool.ID, member.ID).ExtractErr()
-					if err != nil && !cpoerrors.IsNotFound(err) {
-						return nil, fmt.Errorf("error deleting obsolete member %s for pool %s address %s: %v", member.ID, pool.ID, member.Address, err)
-					}
-					provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
-					if err != nil {
-						return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
-					}
-				}
-			}
-			klog.V(4).Infof("Deleting obsolete pool %s for listener %s", pool.ID, listener.ID)
-			// delete pool
-			err = v2pools.Delete(lbaas.lb, pool.ID).ExtractErr()
-			if err != nil && !cpoerrors.IsNotFound(err) {
-				return nil, fmt.Errorf("error deleting obsolete pool %s for listener %s: %v", pool.ID, listener.ID, err)
-			}
-			provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
-			if err != nil {
-				return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
-			}
-		}
-		// delete listener
-		err = listeners.Delete(lbaas.lb, listener.ID).ExtractErr()
-		if err != nil && !cpoerrors.IsNotFound(err) {
-			return nil, fmt.Errorf("error deleteting obsolete listener: %v", err)
-		}
-		provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancer.ID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+		if err := lbaas.deleteListener(loadbalancer.ID, listener, plan); err != nil {
+			return nil, err
 		}
-		klog.V(2).Infof("Deleted obsolete listener: %s", listener.ID)
 	}
 
 	portID := loadbalancer.VipPortID
-	floatIP, err := getFloatingIPByPortID(lbaas.network, portID)
-	if err != nil && err != ErrNotFound {
-		return nil, fmt.Errorf("error getting floating ip for port %s: %v", portID, err)
+	var floatIP *floatingips.FloatingIP
+	if portID != "" {
+		floatIP, err = getFloatingIPByPortID(lbaas.network, portID)
+		if err != nil && err != ErrNotFound {
+			return nil, fmt.Errorf("error getting floating ip for port %s: %v", portID, err)
+		}
+	}
+	// An internal Service keeps no floating IP, e.g. after being switched from external. The floating IP of a
+	// shared load balancer is kept for the other Services.
+	if floatIP != nil && internalAnnotation && shared {
+		klog.Warningf("Internal Service %s shares loadbalancer %s with floating IP %s", serviceName, loadbalancer.Name, floatIP.FloatingIP)
+	} else if floatIP != nil && internalAnnotation {
+		if err := lbaas.releaseFloatingIP(apiService, floatIP, plan); err != nil {
+			return nil, err
+		}
+		floatIP = nil
 	}
 	if floatIP == nil && floatingPool != "" && !internalAnnotation {
 		loadBalancerIP := apiService.Spec.LoadBalancerIP
@@ -1199,14 +1485,15 @@ func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string
 				klog.V(4).Infof("could not find floating ip %s from project: %v", floatingip, err)
 			} else {
 				if len(floatingip.PortID) == 0 {
-					floatUpdateOpts := floatingips.UpdateOpts{
-						PortID: &portID,
-					}
-					floatIP, err = floatingips.Update(lbaas.network, floatingip.ID, floatUpdateOpts).Extract()
-					if err != nil {
-						return nil, fmt.Errorf("error updating LB floatingip %+v: %v", floatUpdateOpts, err)
-					} else {
-						needCreate = false
+					needCreate = false
+					if plan.apply(lbChange{Action: lbActionUpdate, Resource: lbResourceFloatingIP, Name: floatingip.FloatingIP, ID: floatingip.ID, Detail: fmt.Sprintf("associate with port %s of loadbalancer %s", portID, loadbalancer.Name)}) {
+						floatUpdateOpts := floatingips.UpdateOpts{
+							PortID: &portID,
+						}
+						floatIP, err = floatingips.Update(lbaas.network, floatingip.ID, floatUpdateOpts).Extract()
+						if err != nil {
+							return nil, fmt.Errorf("error updating LB floatingip %+v: %v", floatUpdateOpts, err)
+						}
 					}
 				} else {
 					return nil, fmt.Errorf("floatingip is attached already to another port")
@@ -1214,7 +1501,6 @@ func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string
 			}
 		}
 		if needCreate {
-			klog.V(4).Infof("Creating floating ip for loadbalancer %s port %s", loadbalancer.ID, portID)
 			floatIPOpts := floatingips.CreateOpts{
 				FloatingNetworkID: floatingPool,
 				PortID:            portID,
@@ -1243,9 +1529,12 @@ func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string
 				floatIPOpts.FloatingIP = loadBalancerIP
 			}
 
-			floatIP, err = floatingips.Create(lbaas.network, floatIPOpts).Extract()
-			if err != nil {
-				return nil, fmt.Errorf("error creating LB floatingip %+v: %v", floatIPOpts, err)
+			if plan.apply(lbChange{Action: lbActionCreate, Resource: lbResourceFloatingIP, Name: floatIPOpts.FloatingIP, Detail: fmt.Sprintf("on network %s for loadbalancer %s", floatingPool, loadbalancer.Name)}) {
+				klog.V(4).Infof("Creating floating ip for loadbalancer %s port %s", loadbalancer.ID, portID)
+				floatIP, err = floatingips.Create(lbaas.network, floatIPOpts).Extract()
+				if err != nil {
+					return nil, fmt.Errorf("error creating LB floatingip %+v: %v", floatIPOpts, err)
+				}
 			}
 		}
 	}
@@ -1259,10 +1548,12 @@ func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string
 	}
 
 	if lbaas.opts.ManageSecurityGroups {
-		err := lbaas.ensureSecurityGroup(clusterName, apiService, nodes, loadbalancer)
+		err := lbaas.ensureSecurityGroup(clusterName, apiService, nodes, loadbalancer, plan)
 		if err != nil {
-			// cleanup what was created so far
-			_ = lbaas.EnsureLoadBalancerDeleted(ctx, clusterName, apiService)
+			if !plan.DryRun {
+				// cleanup what was created so far
+				_ = lbaas.EnsureLoadBalancerDeleted(ctx, clusterName, apiService)
+			}
 			return status, err
 		}
 	}
@@ -1295,16 +1586,18 @@ func (lbaas *LbaasV2) getSubnet(subnet string) (*subnets.Subnet, error) {
 
 // ensureSecurityGroup ensures security group exist for specific loadbalancer service.
 // Creating security group for specific loadbalancer service when it does not exist.
-func (lbaas *LbaasV2) ensureSecurityGroup(clusterName string, apiService *v1.Service, nodes []*v1.Node, loadbalancer *loadbalancers.LoadBalancer) error {
+func (lbaas *LbaasV2) ensureSecurityGroup(clusterName string, apiService *v1.Service, nodes []*v1.Node, loadbalancer *loadbalancers.LoadBalancer, plan *lbPlan) error {
 	// find node-security-group for service
-	var err error
-	if len(lbaas.opts.NodeSecurityGroupIDs) == 0 && !lbaas.opts.UseOctavia {
-		lbaas.opts.NodeSecurityGroupIDs, err = getNodeSecurityGroupIDForLB(lbaas.compute, lbaas.network, nodes)
+	nodeSecurityGroupIDs := lbaas.nodeSecurityGroupIDs()
+	if len(nodeSecurityGroupIDs) == 0 && !lbaas.opts.UseOctavia {
+		var err error
+		nodeSecurityGroupIDs, err = getNodeSecurityGroupIDForLB(lbaas.compute, lbaas.network, nodes)
 		if err != nil {
 			return fmt.Errorf("failed to find node-security-group for loadbalancer service %s/%s: %v", apiService.Namespace, apiService.Name, err)
 		}
+		lbaas.setNodeSecurityGroupIDs(nodeSecurityGroupIDs)
 
-		klog.V(4).Infof("find node-security-group %v for loadbalancer service %s/%s", lbaas.opts.NodeSecurityGroupIDs, apiService.Namespace, apiService.Name)
+		klog.V(4).Infof("find node-security-group %v for loadbalancer service %s/%s", nodeSecurityGroupIDs, apiService.Namespace, apiService.Name)
 	}
 
 	// get service ports
@@ -1337,95 +1630,37 @@ func (lbaas *LbaasV2) ensureSecurityGroup(clusterName string, apiService *v1.Ser
 			Description: fmt.Sprintf("Security Group for %s/%s Service LoadBalancer in cluster %s", apiService.Namespace, apiService.Name, clusterName),
 		}
 
-		lbSecGroup, err := groups.Create(lbaas.network, lbSecGroupCreateOpts).Extract()
-		if err != nil {
-			return fmt.Errorf("failed to create Security Group for loadbalancer service %s/%s: %v", apiService.Namespace, apiService.Name, err)
-		}
-		lbSecGroupID = lbSecGroup.ID
-
-		if !lbaas.opts.UseOctavia {
-			//add rule in security group
-			for _, port := range ports {
-				for _, sourceRange := range sourceRanges.StringSlice() {
-					ethertype := rules.EtherType4
-					network, _, err := net.ParseCIDR(sourceRange)
-
-					if err != nil {
-						return fmt.Errorf("error parsing source range %s as a CIDR: %v", sourceRange, err)
-					}
-
-					if network.To4() == nil {
-						ethertype = rules.EtherType6
-					}
-
-					lbSecGroupRuleCreateOpts := rules.CreateOpts{
-						Direction:      rules.DirIngress,
-						PortRangeMax:   int(port.Port),
-						PortRangeMin:   int(port.Port),
-						Protocol:       toRuleProtocol(port.Protocol),
-						RemoteIPPrefix: sourceRange,
-						SecGroupID:     lbSecGroup.ID,
-						EtherType:      ethertype,
-					}
-
-					_, err = rules.Create(lbaas.network, lbSecGroupRuleCreateOpts).Extract()
-
-					if err != nil {
-						return fmt.Errorf("error occurred creating rule for SecGroup %s: %v", lbSecGroup.ID, err)
-					}
-				}
-			}
-
-			lbSecGroupRuleCreateOpts := rules.CreateOpts{
-				Direction:      rules.DirIngress,
-				PortRangeMax:   4, // ICMP: Code -  Values for ICMP  "Destination Unreachable: Fragmentation Needed and Don't Fragment was Set"
-				PortRangeMin:   3, // ICMP: Type
-				Protocol:       rules.ProtocolICMP,
-				RemoteIPPrefix: "0.0.0.0/0", // The Fragmentation packet can come from anywhere along the path back to the sourceRange - we need to all this from all
-				SecGroupID:     lbSecGroup.ID,
-				EtherType:      rules.EtherType4,
-			}
-
-			_, err = rules.Create(lbaas.network, lbSecGroupRuleCreateOpts).Extract()
-
-			if err != nil {
-				return fmt.Errorf("error occurred creating rule for SecGroup %s: %v", lbSecGroup.ID, err)
-			}
-
-			lbSecGroupRuleCreateOpts = rules.CreateOpts{
-				Direction:      rules.DirIngress,
-				PortRangeMax:   0, // ICMP: Code - Values for ICMP "Packet Too Big"
-				PortRangeMin:   2, // ICMP: Type
-				Protocol:       rules.ProtocolICMP,
-				RemoteIPPrefix: "::/0", // The Fragmentation packet can come from anywhere along the path back to the sourceRange - we need to all this from all
-				SecGroupID:     lbSecGroup.ID,
-				EtherType:      rules.EtherType6,
-			}
-
-			_, err = rules.Create(lbaas.network, lbSecGroupRuleCreateOpts).Extract()
+		if plan.apply(lbChange{Action: lbActionCreate, Resource: lbResourceSecurityGroup, Name: lbSecGroupName}) {
+			lbSecGroup, err := groups.Create(lbaas.network, lbSecGroupCreateOpts).Extract()
 			if err != nil {
-				return fmt.Errorf("error occurred creating rule for SecGroup %s: %v", lbSecGroup.ID, err)
+				return fmt.Errorf("failed to create Security Group for loadbalancer service %s/%s: %v", apiService.Namespace, apiService.Name, err)
 			}
+			lbSecGroupID = lbSecGroup.ID
+		}
 
+		if !lbaas.opts.UseOctavia {
 			// get security groups of port
 			portID := loadbalancer.VipPortID
-			port, err := getPortByID(lbaas.network, portID)
-			if err != nil {
-				return err
+			port := &neutronports.Port{ID: portID}
+			if portID != "" {
+				port, err = getPortByID(lbaas.network, portID)
+				if err != nil {
+					return err
+				}
 			}
 
 			// ensure the vip port has the security groups
 			found := false
 			for _, portSecurityGroups := range port.SecurityGroups {
-				if portSecurityGroups == lbSecGroup.ID {
+				if portSecurityGroups == lbSecGroupID {
 					found = true
 					break
 				}
 			}
 
 			// update loadbalancer vip port
-			if !found {
-				port.SecurityGroups = append(port.SecurityGroups, lbSecGroup.ID)
+			if !found && plan.apply(lbChange{Action: lbActionUpdate, Resource: lbResourcePort, ID: portID, Detail: fmt.Sprintf("add security group %s to the VIP port of loadbalancer %s", lbSecGroupName, loadbalancer.Name)}) {
+				port.SecurityGroups = append(port.SecurityGroups, lbSecGroupID)
 				updateOpts := neutronports.UpdateOpts{SecurityGroups: &port.SecurityGroups}
 				res := neutronports.Update(lbaas.network, portID, updateOpts)
 				if res.Err != nil {
@@ -1436,92 +1671,122 @@ func (lbaas *LbaasV2) ensureSecurityGroup(clusterName string, apiService *v1.Ser
 		}
 	}
 
-	// ensure rules for node security group
-	for _, port := range ports {
-		// If Octavia is used, the VIP port security group is already taken good care of, we only need to allow ingress
-		// traffic from Octavia amphorae to the node port on the worker nodes.
-		if lbaas.opts.UseOctavia {
-			subnet, err := subnets.Get(lbaas.network, lbaas.opts.SubnetID).Extract()
-			if err != nil {
-				return fmt.Errorf("failed to find subnet %s from openstack: %v", lbaas.opts.SubnetID, err)
-			}
-
-			sgListopts := rules.ListOpts{
-				Direction:      string(rules.DirIngress),
-				Protocol:       string(port.Protocol),
-				PortRangeMax:   int(port.NodePort),
-				PortRangeMin:   int(port.NodePort),
-				RemoteIPPrefix: subnet.CIDR,
-				SecGroupID:     lbSecGroupID,
-			}
-			sgRules, err := getSecurityGroupRules(lbaas.network, sgListopts)
+	// ensure the rules of the security group for LB
+	if !lbaas.opts.UseOctavia {
+		desired, err := lbSecurityGroupRules(ports, sourceRanges.StringSlice())
+		if err != nil {
+			return err
+		}
+		var existing []rules.SecGroupRule
+		if lbSecGroupID != "" {
+			existing, err = getSecurityGroupRules(lbaas.network, rules.ListOpts{SecGroupID: lbSecGroupID})
 			if err != nil && !cpoerrors.IsNotFound(err) {
-				return fmt.Errorf("failed to find security group rules in %s: %v", lbSecGroupID, err)
-			}
-			if len(sgRules) != 0 {
-				continue
+				return fmt.Errorf("failed to find the rules of security group %s: %v", lbSecGroupID, err)
 			}
+		}
+		if _, err := reconcileSecGroupRules(lbaas.network, lbSecGroupID, lbSecGroupName, existing, desired, "", plan); err != nil {
+			return fmt.Errorf("failed to ensure the rules of security group %s for loadbalancer service %s/%s: %v", lbSecGroupName, apiService.Namespace, apiService.Name, err)
+		}
+	}
 
-			// The Octavia amphorae and worker nodes are supposed to be in the same subnet. We allow the ingress traffic
-			// from the amphorae to the specific node port on the nodes.
-			sgRuleCreateOpts := rules.CreateOpts{
-				Direction:      rules.DirIngress,
-				PortRangeMax:   int(port.NodePort),
-				PortRangeMin:   int(port.NodePort),
-				Protocol:       toRuleProtocol(port.Protocol),
-				RemoteIPPrefix: subnet.CIDR,
-				SecGroupID:     lbSecGroupID,
-				EtherType:      rules.EtherType4,
-			}
-			if _, err = rules.Create(lbaas.network, sgRuleCreateOpts).Extract(); err != nil {
-				return fmt.Errorf("failed to create rule for security group %s: %v", lbSecGroupID, err)
-			}
+	// If Octavia is used, the VIP port security group is already taken good care of, we only need to allow ingress
+	// traffic from Octavia amphorae to the node ports on the worker nodes.
+	if lbaas.opts.UseOctavia {
+		nodePorts := append([]v1.ServicePort{}, ports...)
+		// The amphorae also reach the health check node port of the members
+		monitorPort, err := lbaas.memberMonitorPort(apiService, ports[0])
+		if err != nil {
+			return err
+		}
+		if monitorPort != 0 {
+			nodePorts = append(nodePorts, v1.ServicePort{Protocol: v1.ProtocolTCP, NodePort: int32(monitorPort)})
+		}
+		return lbaas.ensureOctaviaNodePortRules(lbSecGroupID, lbSecGroupName, nodePorts, nodes, plan)
+	}
 
-			if err := applyNodeSecurityGroupIDForLB(lbaas.compute, lbaas.network, nodes, lbSecGroupID); err != nil {
-				return err
-			}
-		} else {
-			for _, nodeSecurityGroupID := range lbaas.opts.NodeSecurityGroupIDs {
-				opts := rules.ListOpts{
-					Direction:     string(rules.DirIngress),
-					SecGroupID:    nodeSecurityGroupID,
-					RemoteGroupID: lbSecGroupID,
-					PortRangeMax:  int(port.NodePort),
-					PortRangeMin:  int(port.NodePort),
-					Protocol:      string(port.Protocol),
-				}
-				secGroupRules, err := getSecurityGroupRules(lbaas.network, opts)
-				if err != nil && !cpoerrors.IsNotFound(err) {
-					msg := fmt.Sprintf("Error finding rules for remote group id %s in security group id %s: %v", lbSecGroupID, nodeSecurityGroupID, err)
-					return fmt.Errorf(msg)
-				}
-				if len(secGroupRules) != 0 {
-					// Do not add rule when find rules for remote group in the Node Security Group
-					continue
-				}
+	// ensure rules for node security group
+	for _, nodeSecurityGroupID := range nodeSecurityGroupIDs {
+		if err := ensureNodeSecurityGroupRules(lbaas.network, nodeSecurityGroupID, lbSecGroupID, lbSecGroupName, ports, plan); err != nil {
+			return fmt.Errorf("error occurred creating security group for loadbalancer service %s/%s: %v", apiService.Namespace, apiService.Name, err)
+		}
+	}
 
-				// Add the rules in the Node Security Group
-				err = createNodeSecurityGroup(lbaas.network, nodeSecurityGroupID, int(port.NodePort), port.Protocol, lbSecGroupID)
-				if err != nil {
-					return fmt.Errorf("error occurred creating security group for loadbalancer service %s/%s: %v", apiService.Namespace, apiService.Name, err)
-				}
-			}
+	return nil
+}
+
+// ensureOctaviaNodePortRules allows the ingress traffic from the Octavia
+// amphorae to the node ports on the worker nodes.
+func (lbaas *LbaasV2) ensureOctaviaNodePortRules(lbSecGroupID, lbSecGroupName string, nodePorts []v1.ServicePort, nodes []*v1.Node, plan *lbPlan) error {
+	subnet, err := subnets.Get(lbaas.network, lbaas.opts.SubnetID).Extract()
+	if err != nil {
+		return fmt.Errorf("failed to find subnet %s from openstack: %v", lbaas.opts.SubnetID, err)
+	}
+
+	// The Octavia amphorae and worker nodes are supposed to be in the same subnet. We allow the ingress traffic
+	// from the amphorae to the specific node ports on the nodes.
+	var desired []rules.CreateOpts
+	for _, port := range nodePorts {
+		desired = append(desired, rules.CreateOpts{
+			Direction:      rules.DirIngress,
+			PortRangeMax:   int(port.NodePort),
+			PortRangeMin:   int(port.NodePort),
+			Protocol:       toRuleProtocol(port.Protocol),
+			RemoteIPPrefix: subnet.CIDR,
+			EtherType:      rules.EtherType4,
+		})
+	}
+
+	var existing []rules.SecGroupRule
+	if lbSecGroupID != "" {
+		existing, err = getSecurityGroupRules(lbaas.network, rules.ListOpts{SecGroupID: lbSecGroupID})
+		if err != nil && !cpoerrors.IsNotFound(err) {
+			return fmt.Errorf("failed to find security group rules in %s: %v", lbSecGroupID, err)
 		}
 	}
+	changed, err := reconcileSecGroupRules(lbaas.network, lbSecGroupID, lbSecGroupName, existing, desired, "", plan)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
 
+	if plan.apply(lbChange{Action: lbActionUpdate, Resource: lbResourcePort, Detail: fmt.Sprintf("add security group %s to the ports of %d nodes", lbSecGroupName, len(nodes))}) {
+		if err := applyNodeSecurityGroupIDForLB(lbaas.compute, lbaas.network, nodes, lbSecGroupID); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // UpdateLoadBalancer updates hosts under the specified load balancer.
-func (lbaas *LbaasV2) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
+func (lbaas *LbaasV2) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (err error) {
+	lbaas = lbaas.withContext(ctx)
 	serviceName := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+	end := operationHistory.Begin("UpdateLoadBalancer", serviceName)
+	defer func() { end(err) }()
 	klog.V(4).Infof("UpdateLoadBalancer(%v, %s, %v)", clusterName, serviceName, nodes)
 
+	dryRun, err := lbaas.isDryRun(service)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		// The member updates are a subset of the full reconcile, report all of it
+		_, err := lbaas.EnsureLoadBalancer(ctx, clusterName, service, nodes)
+		return err
+	}
+
+	poolMembers, err := lbaas.getPoolMembers(service, nodes)
+	if err != nil {
+		return err
+	}
+
 	lbaas.opts.SubnetID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerSubnetID, lbaas.opts.SubnetID)
-	if len(lbaas.opts.SubnetID) == 0 && len(nodes) > 0 {
+	if len(lbaas.opts.SubnetID) == 0 && len(poolMembers) > 0 {
 		// Get SubnetID automatically.
 		// The LB needs to be configured with instance addresses on the same subnet, so get SubnetID by one node.
-		subnetID, err := getSubnetIDForLB(lbaas.compute, *nodes[0])
+		subnetID, err := getSubnetIDForLB(lbaas.compute, poolMembers[0])
 		if err != nil {
 			klog.Warningf("Failed to find subnet-id for loadbalancer service %s/%s: %v", service.Namespace, service.Name, err)
 			return fmt.Errorf("no subnet-id for service %s/%s : subnet-id not set in cloud provider config, "+
@@ -1535,8 +1800,10 @@ func (lbaas *LbaasV2) UpdateLoadBalancer(ctx context.Context, clusterName string
 		return fmt.Errorf("no ports provided to openstack load balancer")
 	}
 
-	name := lbaas.GetLoadBalancerName(ctx, clusterName, service)
-	legacyName := lbaas.GetLoadBalancerName(ctx, clusterName, service)
+	name, legacyName, err := lbaas.getServiceLoadBalancerName(ctx, clusterName, service)
+	if err != nil {
+		return err
+	}
 	loadbalancer, err := getLoadbalancerByName(lbaas.lb, name, legacyName)
 	if err != nil {
 		return err
@@ -1572,23 +1839,30 @@ func (lbaas *LbaasV2) UpdateLoadBalancer(ctx context.Context, clusterName string
 		lbPools[listenerID] = *pool
 	}
 
-	// Compose Set of member (addresses) that _should_ exist
+	// Compose Set of member (addresses) that _should_ exist. Changing the
+	// member address type replaces the members of the old addresses.
 	addrs := make(map[string]*v1.Node)
-	for _, node := range nodes {
-		addr, err := nodeAddressForLB(node)
-		if err != nil {
-			return err
-		}
-		addrs[addr] = node
+	for _, member := range poolMembers {
+		addrs[member.addr] = member.node
 	}
 
 	// Check for adding/removing members associated with each port
 	for portIndex, port := range ports {
+		settings, _, err := getListenerSettings(service, port, lbaas.opts.PortNames)
+		if err != nil {
+			return err
+		}
+
 		// Get listener associated with this port
 		listener, ok := lbListeners[portKey{
-			Protocol: toListenersProtocol(port.Protocol),
+			Protocol: settings.protocol,
 			Port:     int(port.Port),
 		}]
+		if !ok {
+			if existing := getListenerForPort(allListeners, port, ""); existing != nil {
+				listener, ok = *existing, true
+			}
+		}
 		if !ok {
 			return fmt.Errorf("loadbalancer %s does not contain required listener for port %d and protocol %s", loadbalancer.ID, port.Port, port.Protocol)
 		}
@@ -1615,11 +1889,18 @@ func (lbaas *LbaasV2) UpdateLoadBalancer(ctx context.Context, clusterName string
 				// Already exists, do not create member
 				continue
 			}
-			_, err := v2pools.CreateMember(lbaas.lb, pool.ID, v2pools.CreateMemberOpts{
-				Name:         cutString(fmt.Sprintf("member_%d_%s_%s_", portIndex, node.Name, loadbalancer.Name)),
-				Address:      addr,
-				ProtocolPort: int(port.NodePort),
-				SubnetID:     lbaas.opts.SubnetID,
+			monitorPort, err := lbaas.memberMonitorPort(service, port)
+			if err != nil {
+				return err
+			}
+			_, err = v2pools.CreateMember(lbaas.lb, pool.ID, memberCreateOpts{
+				CreateMemberOpts: v2pools.CreateMemberOpts{
+					Name:         cutString(fmt.Sprintf("member_%d_%s_%s_", portIndex, node.Name, loadbalancer.Name)),
+					Address:      addr,
+					ProtocolPort: int(port.NodePort),
+					SubnetID:     lbaas.opts.SubnetID,
+				},
+				MonitorPort: monitorPort,
 			}).Extract()
 			if err != nil {
 				return err
@@ -1659,17 +1940,17 @@ func (lbaas *LbaasV2) UpdateLoadBalancer(ctx context.Context, clusterName string
 
 // updateSecurityGroup updating security group for specific loadbalancer service.
 func (lbaas *LbaasV2) updateSecurityGroup(clusterName string, apiService *v1.Service, nodes []*v1.Node, loadbalancer *loadbalancers.LoadBalancer) error {
-	originalNodeSecurityGroupIDs := lbaas.opts.NodeSecurityGroupIDs
+	originalNodeSecurityGroupIDs := lbaas.nodeSecurityGroupIDs()
 
-	var err error
-	lbaas.opts.NodeSecurityGroupIDs, err = getNodeSecurityGroupIDForLB(lbaas.compute, lbaas.network, nodes)
+	nodeSecurityGroupIDs, err := getNodeSecurityGroupIDForLB(lbaas.compute, lbaas.network, nodes)
 	if err != nil {
 		return fmt.Errorf("failed to find node-security-group for loadbalancer service %s/%s: %v", apiService.Namespace, apiService.Name, err)
 	}
-	klog.V(4).Infof("find node-security-group %v for loadbalancer service %s/%s", lbaas.opts.NodeSecurityGroupIDs, apiService.Namespace, apiService.Name)
+	lbaas.setNodeSecurityGroupIDs(nodeSecurityGroupIDs)
+	klog.V(4).Infof("find node-security-group %v for loadbalancer service %s/%s", nodeSecurityGroupIDs, apiService.Namespace, apiService.Name)
 
 	original := sets.NewString(originalNodeSecurityGroupIDs...)
-	current := sets.NewString(lbaas.opts.NodeSecurityGroupIDs...)
+	current := sets.NewString(nodeSecurityGroupIDs...)
 	removals := original.Difference(current)
 
 	// Generate Name
@@ -1684,53 +1965,16 @@ func (lbaas *LbaasV2) updateSecurityGroup(clusterName string, apiService *v1.Ser
 		return fmt.Errorf("no ports provided to openstack load balancer")
 	}
 
-	for _, port := range ports {
-		for removal := range removals {
-			// Delete the rules in the Node Security Group
-			opts := rules.ListOpts{
-				Direction:     string(rules.DirIngress),
-				SecGroupID:    removal,
-				RemoteGroupID: lbSecGroupID,
-				PortRangeMax:  int(port.NodePort),
-				PortRangeMin:  int(port.NodePort),
-				Protocol:      string(port.Protocol),
-			}
-			secGroupRules, err := getSecurityGroupRules(lbaas.network, opts)
-			if err != nil && !cpoerrors.IsNotFound(err) {
-				return fmt.Errorf("error finding rules for remote group id %s in security group id %s: %v", lbSecGroupID, removal, err)
-			}
-
-			for _, rule := range secGroupRules {
-				res := rules.Delete(lbaas.network, rule.ID)
-				if res.Err != nil && !cpoerrors.IsNotFound(res.Err) {
-					return fmt.Errorf("error occurred deleting security group rule: %s: %v", rule.ID, res.Err)
-				}
-			}
+	plan := newLBPlan(fmt.Sprintf("%s/%s", apiService.Namespace, apiService.Name), false)
+	for removal := range removals {
+		// Delete the rules in the Node Security Group
+		if err := ensureNodeSecurityGroupRules(lbaas.network, removal, lbSecGroupID, lbSecGroupName, nil, plan); err != nil {
+			return fmt.Errorf("error occurred deleting the rules of security group %s for loadbalancer service %s/%s: %v", removal, apiService.Namespace, apiService.Name, err)
 		}
-
-		for _, nodeSecurityGroupID := range lbaas.opts.NodeSecurityGroupIDs {
-			opts := rules.ListOpts{
-				Direction:     string(rules.DirIngress),
-				SecGroupID:    nodeSecurityGroupID,
-				RemoteGroupID: lbSecGroupID,
-				PortRangeMax:  int(port.NodePort),
-				PortRangeMin:  int(port.NodePort),
-				Protocol:      string(port.Protocol),
-			}
-			secGroupRules, err := getSecurityGroupRules(lbaas.network, opts)
-			if err != nil && !cpoerrors.IsNotFound(err) {
-				return fmt.Errorf("error finding rules for remote group id %s in security group id %s: %v", lbSecGroupID, nodeSecurityGroupID, err)
-			}
-			if len(secGroupRules) != 0 {
-				// Do not add rule when find rules for remote group in the Node Security Group
-				continue
-			}
-
-			// Add the rules in the Node Security Group
-			err = createNodeSecurityGroup(lbaas.network, nodeSecurityGroupID, int(port.NodePort), port.Protocol, lbSecGroupID)
-			if err != nil {
-				return fmt.Errorf("error occurred creating security group for loadbalancer service %s/%s: %v", apiService.Namespace, apiService.Name, err)
-			}
+	}
+	for _, nodeSecurityGroupID := range nodeSecurityGroupIDs {
+		if err := ensureNodeSecurityGroupRules(lbaas.network, nodeSecurityGroupID, lbSecGroupID, lbSecGroupName, ports, plan); err != nil {
+			return fmt.Errorf("error occurred creating security group for loadbalancer service %s/%s: %v", apiService.Namespace, apiService.Name, err)
 		}
 	}
 
@@ -1738,12 +1982,34 @@ func (lbaas *LbaasV2) updateSecurityGroup(clusterName string, apiService *v1.Ser
 }
 
 // EnsureLoadBalancerDeleted deletes the specified load balancer
-func (lbaas *LbaasV2) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
+func (lbaas *LbaasV2) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) (err error) {
+	lbaas = lbaas.withContext(ctx)
 	serviceName := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+	end := operationHistory.Begin("EnsureLoadBalancerDeleted", serviceName)
+	defer func() { end(err) }()
 	klog.V(4).Infof("EnsureLoadBalancerDeleted(%s, %s)", clusterName, serviceName)
 
-	name := lbaas.GetLoadBalancerName(ctx, clusterName, service)
-	legacyName := lbaas.GetLoadBalancerLegacyName(ctx, clusterName, service)
+	dryRun, err := lbaas.isDryRun(service)
+	if err != nil {
+		return err
+	}
+
+	plan := newLBPlan(serviceName, dryRun)
+	err = lbaas.ensureLoadBalancerDeleted(ctx, clusterName, service, plan)
+	if dryRun {
+		lbaas.reportPlan("EnsureLoadBalancerDeleted", service, plan, err)
+	}
+	return err
+}
+
+// ensureLoadBalancerDeleted deletes or hibernates the load balancer of the Service, passing every mutation through
+// plan.
+func (lbaas *LbaasV2) ensureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service, plan *lbPlan) error {
+	serviceName := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+	name, legacyName, err := lbaas.getServiceLoadBalancerName(ctx, clusterName, service)
+	if err != nil {
+		return err
+	}
 	loadbalancer, err := getLoadbalancerByName(lbaas.lb, name, legacyName)
 	if err != nil && err != ErrNotFound {
 		return err
@@ -1752,29 +2018,113 @@ func (lbaas *LbaasV2) EnsureLoadBalancerDeleted(ctx context.Context, clusterName
 		return nil
 	}
 
+	shared := isSharedLoadBalancer(loadbalancer)
+	if shared {
+		// The load balancer is deleted along with the listeners of the last Service sharing it
+		remaining, err := lbaas.deleteSharedListeners(loadbalancer, lbaas.GetLoadBalancerName(ctx, clusterName, service), plan)
+		if err != nil {
+			return err
+		}
+		if remaining > 0 {
+			klog.V(2).Infof("Keeping loadbalancer %s for the %d listeners of other Services", loadbalancer.Name, remaining)
+			if lbaas.opts.ManageSecurityGroups {
+				if err := lbaas.EnsureSecurityGroupDeleted(clusterName, service, plan); err != nil {
+					return fmt.Errorf("failed to delete Security Group for loadbalancer service %s: %v", serviceName, err)
+				}
+			}
+			return nil
+		}
+	}
+
 	keepFloatingAnnotation, err := getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerKeepFloatingIP, false)
 	if err != nil {
 		return err
 	}
 
-	if !keepFloatingAnnotation {
+	hibernate, err := getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHibernate, lbaas.opts.Hibernate)
+	if err != nil {
+		return err
+	}
+	if hibernate && shared {
+		klog.Warningf("Shared loadbalancer %s cannot be hibernated, deleting it", loadbalancer.ID)
+	} else if hibernate {
 		if loadbalancer.VipPortID != "" {
-			portID := loadbalancer.VipPortID
-			floatingIP, err := getFloatingIPByPortID(lbaas.network, portID)
-			if err != nil && err != ErrNotFound {
-				return err
-			}
+			return lbaas.hibernateLoadBalancer(clusterName, service, loadbalancer, keepFloatingAnnotation, plan)
+		}
+		klog.Warningf("Loadbalancer %s has no VIP port and cannot be hibernated, deleting it", loadbalancer.ID)
+	}
 
-			if floatingIP != nil {
-				err = floatingips.Delete(lbaas.network, floatingIP.ID).ExtractErr()
-				if err != nil && !cpoerrors.IsNotFound(err) {
-					return err
-				}
+	if !keepFloatingAnnotation && loadbalancer.VipPortID != "" {
+		if err := deleteFloatingIPForPort(lbaas.network, loadbalancer.VipPortID, plan); err != nil {
+			return err
+		}
+	}
+
+	if err := lbaas.deleteLoadBalancer(loadbalancer, plan); err != nil {
+		return err
+	}
+
+	// Delete the Security Group
+	if lbaas.opts.ManageSecurityGroups {
+		err := lbaas.EnsureSecurityGroupDeleted(clusterName, service, plan)
+		if err != nil {
+			return fmt.Errorf("failed to delete Security Group for loadbalancer service %s: %v", serviceName, err)
+		}
+	}
+
+	return nil
+}
+
+// releaseFloatingIP deletes the floating ip of the load balancer of an internal Service, or only disassociates it
+// from the VIP port with the keep-floatingip annotation.
+func (lbaas *LbaasV2) releaseFloatingIP(service *v1.Service, floatIP *floatingips.FloatingIP, plan *lbPlan) error {
+	keepFloatingAnnotation, err := getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerKeepFloatingIP, false)
+	if err != nil {
+		return err
+	}
+
+	if keepFloatingAnnotation {
+		if plan.apply(lbChange{Action: lbActionUpdate, Resource: lbResourceFloatingIP, Name: floatIP.FloatingIP, ID: floatIP.ID, Detail: fmt.Sprintf("disassociate from port %s", floatIP.PortID)}) {
+			klog.V(4).Infof("Disassociating floating ip %s from port %s", floatIP.FloatingIP, floatIP.PortID)
+			// An empty port ID disassociates the floating ip
+			if _, err := floatingips.Update(lbaas.network, floatIP.ID, floatingips.UpdateOpts{PortID: new(string)}).Extract(); err != nil {
+				return fmt.Errorf("error disassociating floating ip %s: %v", floatIP.FloatingIP, err)
 			}
 		}
+		return nil
+	}
+
+	if plan.apply(lbChange{Action: lbActionDelete, Resource: lbResourceFloatingIP, Name: floatIP.FloatingIP, ID: floatIP.ID, Detail: fmt.Sprintf("of port %s", floatIP.PortID)}) {
+		klog.V(4).Infof("Deleting floating ip %s of port %s", floatIP.FloatingIP, floatIP.PortID)
+		if err := floatingips.Delete(lbaas.network, floatIP.ID).ExtractErr(); err != nil && !cpoerrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting floating ip %s: %v", floatIP.FloatingIP, err)
+		}
+	}
+	return nil
+}
+
+// deleteFloatingIPForPort deletes the floating ip associated with the port, if any.
+func deleteFloatingIPForPort(client *gophercloud.ServiceClient, portID string, plan *lbPlan) error {
+	floatingIP, err := getFloatingIPByPortID(client, portID)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+
+	if floatingIP != nil && plan.apply(lbChange{Action: lbActionDelete, Resource: lbResourceFloatingIP, Name: floatingIP.FloatingIP, ID: floatingIP.ID, Detail: fmt.Sprintf("of port %s", portID)}) {
+		err = floatingips.Delete(client, floatingIP.ID).ExtractErr()
+		if err != nil && !cpoerrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteLoadBalancer deletes the loadbalancer and all its sub-resources.
+func (lbaas *LbaasV2) deleteLoadBalancer(loadbalancer *loadbalancers.LoadBalancer, plan *lbPlan) error {
+	if !plan.apply(lbChange{Action: lbActionDelete, Resource: lbResourceLoadBalancer, Name: loadbalancer.Name, ID: loadbalancer.ID, Detail: "with its listeners, pools, members and monitors"}) {
+		return nil
 	}
 
-	// delete the loadbalancer and all its sub-resources.
 	if lbaas.opts.UseOctavia {
 		deleteOpts := loadbalancers.DeleteOpts{Cascade: true}
 		if err := loadbalancers.Delete(lbaas.lb, loadbalancer.ID, deleteOpts).ExtractErr(); err != nil {
@@ -1869,19 +2219,11 @@ func (lbaas *LbaasV2) EnsureLoadBalancerDeleted(ctx context.Context, clusterName
 		}
 	}
 
-	// Delete the Security Group
-	if lbaas.opts.ManageSecurityGroups {
-		err := lbaas.EnsureSecurityGroupDeleted(clusterName, service)
-		if err != nil {
-			return fmt.Errorf("failed to delete Security Group for loadbalancer service %s: %v", serviceName, err)
-		}
-	}
-
 	return nil
 }
 
 // EnsureSecurityGroupDeleted deleting security group for specific loadbalancer service.
-func (lbaas *LbaasV2) EnsureSecurityGroupDeleted(clusterName string, service *v1.Service) error {
+func (lbaas *LbaasV2) EnsureSecurityGroupDeleted(clusterName string, service *v1.Service, plan *lbPlan) error {
 	// Generate Name
 	lbSecGroupName := getSecurityGroupName(service)
 	lbSecGroupID, err := groups.IDFromName(lbaas.network, lbSecGroupName)
@@ -1893,6 +2235,10 @@ func (lbaas *LbaasV2) EnsureSecurityGroupDeleted(clusterName string, service *v1
 		return fmt.Errorf("error occurred finding security group: %s: %v", lbSecGroupName, err)
 	}
 
+	if !plan.apply(lbChange{Action: lbActionDelete, Resource: lbResourceSecurityGroup, Name: lbSecGroupName, ID: lbSecGroupID, Detail: "with its rules in the node security groups"}) {
+		return nil
+	}
+
 	if lbaas.opts.UseOctavia {
 		// Disassociate the security group from the neutron ports on the nodes.
 		if err := disassociateSecurityGroupForLB(lbaas.network, lbSecGroupID); err != nil {
@@ -1905,15 +2251,16 @@ func (lbaas *LbaasV2) EnsureSecurityGroupDeleted(clusterName string, service *v1
 		return lbSecGroup.Err
 	}
 
-	if len(lbaas.opts.NodeSecurityGroupIDs) == 0 {
+	nodeSecurityGroupIDs := lbaas.nodeSecurityGroupIDs()
+	if len(nodeSecurityGroupIDs) == 0 {
 		// Just happen when nodes have not Security Group, or should not happen
-		// UpdateLoadBalancer and EnsureLoadBalancer can set lbaas.opts.NodeSecurityGroupIDs when it is empty
-		// And service controller call UpdateLoadBalancer to set lbaas.opts.NodeSecurityGroupIDs when controller manager service is restarted.
+		// UpdateLoadBalancer and EnsureLoadBalancer can set the node security groups when they are empty
+		// And service controller call UpdateLoadBalancer to set them when controller manager service is restarted.
 		klog.Warningf("Can not find node-security-group from all the nodes of this cluster when delete loadbalancer service %s/%s",
 			service.Namespace, service.Name)
 	} else {
 		// Delete the rules in the Node Security Group
-		for _, nodeSecurityGroupID := range lbaas.opts.NodeSecurityGroupIDs {
+		for _, nodeSecurityGroupID := range nodeSecurityGroupIDs {
 			opts := rules.ListOpts{
 				SecGroupID:    nodeSecurityGroupID,
 				RemoteGroupID: lbSecGroupID,
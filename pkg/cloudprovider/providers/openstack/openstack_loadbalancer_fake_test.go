@@ -0,0 +1,379 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// fakeLBaaSSingular are the keys of a single resource in the requests and
+// responses, by collection.
+var fakeLBaaSSingular = map[string]string{
+	"ports":                "port",
+	"floatingips":          "floatingip",
+	"security-groups":      "security_group",
+	"security-group-rules": "security_group_rule",
+	"lbaas/loadbalancers":  "loadbalancer",
+	"lbaas/listeners":      "listener",
+	"lbaas/pools":          "pool",
+	"lbaas/healthmonitors": "healthmonitor",
+	"lbaas/quotas":         "quota",
+	"servers":              "server",
+}
+
+var fakeLBaaSTagPath = regexp.MustCompile(`^ports/([^/]+)/tags/(.+)$`)
+
+// fakeLBaaS serves the Neutron and Octavia resources of load balancers, and
+// the Nova servers of the nodes, on one endpoint, and records the changes
+// made to them. A resource is a JSON object with an "id", the lists are
+// filtered on the fields given in the query, as regular expressions when
+// they start with "^" like the Nova server names. The Octavia quota of a
+// project is the "lbaas/quotas" resource with the project ID.
+type fakeLBaaS struct {
+	t  *testing.T
+	mu sync.Mutex
+	// resources are the resources by collection, e.g. "ports" or
+	// "lbaas/pools/pool-1/members", and ID
+	resources map[string]map[string]map[string]interface{}
+	nextID    int
+	// changes are the changes made, in order
+	changes []string
+	// forbidden are the paths answered with 403 Forbidden
+	forbidden map[string]bool
+}
+
+// newFakeLBaaS returns the fake with its server, and a client of both
+// Neutron and Octavia.
+func newFakeLBaaS(t *testing.T) (*fakeLBaaS, *httptest.Server, *gophercloud.ServiceClient) {
+	f := &fakeLBaaS{t: t, resources: make(map[string]map[string]map[string]interface{})}
+	srv := httptest.NewServer(f)
+	return f, srv, newPagedClient(srv)
+}
+
+func fakeLBaaSResourceKey(collection string) string {
+	if strings.HasSuffix(collection, "/members") {
+		return "member"
+	}
+	return fakeLBaaSSingular[collection]
+}
+
+// add adds a resource, given as any value marshalling to a JSON object.
+func (f *fakeLBaaS) add(collection string, resource interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := json.Marshal(resource)
+	if err != nil {
+		f.t.Fatalf("failed to marshal %v: %v", resource, err)
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(data, &res); err != nil {
+		f.t.Fatalf("failed to unmarshal %s: %v", data, err)
+	}
+	f.store(collection, res)
+}
+
+// get returns a resource, nil if it does not exist.
+func (f *fakeLBaaS) get(collection, id string) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.resources[collection][id]
+}
+
+// ids returns the sorted IDs of the resources of a collection.
+func (f *fakeLBaaS) ids(collection string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sortedIDs(collection)
+}
+
+// forbid answers the requests of a path with 403 Forbidden, like Octavia
+// does when the policy does not allow them.
+func (f *fakeLBaaS) forbid(p string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.forbidden == nil {
+		f.forbidden = make(map[string]bool)
+	}
+	f.forbidden[p] = true
+}
+
+// takeChanges returns the changes made since the last call.
+func (f *fakeLBaaS) takeChanges() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	changes := f.changes
+	f.changes = nil
+	return changes
+}
+
+func (f *fakeLBaaS) sortedIDs(collection string) []string {
+	var ids []string
+	for id := range f.resources[collection] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// store stores a resource, filling in what Neutron and Octavia derive from
+// the fields given on creation.
+func (f *fakeLBaaS) store(collection string, res map[string]interface{}) {
+	if _, ok := res["id"]; !ok {
+		f.nextID++
+		res["id"] = fmt.Sprintf("%s-%d", fakeLBaaSResourceKey(collection), f.nextID)
+	}
+	id := fmt.Sprint(res["id"])
+
+	switch collection {
+	case "lbaas/loadbalancers":
+		if _, ok := res["provisioning_status"]; !ok {
+			res["provisioning_status"] = "ACTIVE"
+		}
+		if _, ok := res["admin_state_up"]; !ok {
+			res["admin_state_up"] = true
+		}
+	case "lbaas/listeners":
+		if _, ok := res["admin_state_up"]; !ok {
+			res["admin_state_up"] = true
+		}
+		if lbID, ok := res["loadbalancer_id"]; ok {
+			res["loadbalancers"] = []interface{}{map[string]interface{}{"id": lbID}}
+		}
+	case "lbaas/pools":
+		if listenerID, ok := res["listener_id"]; ok {
+			res["listeners"] = []interface{}{map[string]interface{}{"id": listenerID}}
+			if listener := f.resources["lbaas/listeners"][fmt.Sprint(listenerID)]; listener != nil {
+				listener["default_pool_id"] = id
+				if _, ok := res["loadbalancer_id"]; !ok {
+					res["loadbalancer_id"] = listener["loadbalancer_id"]
+				}
+			}
+		}
+		if lbID, ok := res["loadbalancer_id"]; ok {
+			res["loadbalancers"] = []interface{}{map[string]interface{}{"id": lbID}}
+		}
+	}
+
+	if f.resources[collection] == nil {
+		f.resources[collection] = make(map[string]map[string]interface{})
+	}
+	f.resources[collection][id] = res
+}
+
+// remove removes a resource, and the ones Octavia deletes with it.
+func (f *fakeLBaaS) remove(collection, id string, cascade bool) {
+	delete(f.resources[collection], id)
+	switch collection {
+	case "lbaas/loadbalancers":
+		if !cascade {
+			return
+		}
+		for _, c := range []string{"lbaas/listeners", "lbaas/pools"} {
+			for resID, res := range f.resources[c] {
+				if fmt.Sprint(res["loadbalancer_id"]) == id {
+					f.remove(c, resID, true)
+				}
+			}
+		}
+	case "lbaas/pools":
+		delete(f.resources, "lbaas/pools/"+id+"/members")
+		for _, listener := range f.resources["lbaas/listeners"] {
+			if listener["default_pool_id"] == id {
+				listener["default_pool_id"] = nil
+			}
+		}
+	}
+}
+
+func (f *fakeLBaaS) record(action, kind string, res map[string]interface{}) {
+	name, _ := res["name"].(string)
+	if name == "" {
+		name = fmt.Sprint(res["id"])
+	}
+	f.changes = append(f.changes, fmt.Sprintf("%s %s %s", action, kind, name))
+}
+
+// fakeLBaaSMatches returns whether the resource has the values of the query.
+func fakeLBaaSMatches(res map[string]interface{}, query map[string][]string) bool {
+	for key, values := range query {
+		switch key {
+		case "limit", "marker", "fields":
+			continue
+		case "tags", "tags-any":
+			resTags, _ := res["tags"].([]interface{})
+			var tags []string
+			for _, tag := range resTags {
+				tags = append(tags, fmt.Sprint(tag))
+			}
+			found := 0
+			wanted := strings.Split(values[0], ",")
+			for _, tag := range wanted {
+				if hasTag(tags, tag) {
+					found++
+				}
+			}
+			if (key == "tags" && found < len(wanted)) || found == 0 {
+				return false
+			}
+			continue
+		}
+		value, ok := res[key]
+		if !ok {
+			return false
+		}
+		if strings.HasPrefix(values[0], "^") {
+			if matched, _ := regexp.MatchString(values[0], fmt.Sprint(value)); !matched {
+				return false
+			}
+		} else if fmt.Sprint(value) != values[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeLBaaS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	p := strings.Trim(r.URL.Path, "/")
+	if p == "servers/detail" {
+		p = "servers"
+	}
+	if f.forbidden[p] {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, `{"faultcode": "Client", "faultstring": "Policy does not allow this request to be performed."}`)
+		return
+	}
+
+	if m := fakeLBaaSTagPath.FindStringSubmatch(p); m != nil {
+		port := f.resources["ports"][m[1]]
+		if port == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		tags, _ := port["tags"].([]interface{})
+		switch r.Method {
+		case http.MethodPut:
+			port["tags"] = append(tags, m[2])
+			f.changes = append(f.changes, fmt.Sprintf("tag port %s %s", m[1], m[2]))
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			var kept []interface{}
+			for _, tag := range tags {
+				if tag != m[2] {
+					kept = append(kept, tag)
+				}
+			}
+			if len(kept) == len(tags) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			port["tags"] = kept
+			f.changes = append(f.changes, fmt.Sprintf("untag port %s %s", m[1], m[2]))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			f.unexpected(w, r)
+		}
+		return
+	}
+
+	collection, id := p, ""
+	if fakeLBaaSResourceKey(collection) == "" {
+		collection, id = path.Dir(p), path.Base(p)
+	}
+	key := fakeLBaaSResourceKey(collection)
+	if key == "" {
+		f.unexpected(w, r)
+		return
+	}
+
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			list := []interface{}{}
+			for _, resID := range f.sortedIDs(collection) {
+				if res := f.resources[collection][resID]; fakeLBaaSMatches(res, r.URL.Query()) {
+					list = append(list, res)
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{path.Base(strings.Replace(collection, "-", "_", -1)): list})
+		case http.MethodPost:
+			var body map[string]map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body[key] == nil {
+				f.t.Errorf("invalid request %s %s: %v", r.Method, r.URL, err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			res := body[key]
+			f.store(collection, res)
+			f.record("create", key, res)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]interface{}{key: res})
+		default:
+			f.unexpected(w, r)
+		}
+		return
+	}
+
+	res := f.resources[collection][id]
+	if res == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"NeutronError": {"type": "NotFound", "message": "%s %s not found"}}`, key, id)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{key: res})
+	case http.MethodPut:
+		var body map[string]map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			f.t.Errorf("invalid request %s %s: %v", r.Method, r.URL, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for k, v := range body[key] {
+			res[k] = v
+		}
+		f.record("update", key, res)
+		json.NewEncoder(w).Encode(map[string]interface{}{key: res})
+	case http.MethodDelete:
+		f.remove(collection, id, r.URL.Query().Get("cascade") == "true")
+		f.record("delete", key, res)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.unexpected(w, r)
+	}
+}
+
+func (f *fakeLBaaS) unexpected(w http.ResponseWriter, r *http.Request) {
+	f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+	w.WriteHeader(http.StatusNotFound)
+}
@@ -0,0 +1,365 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	v2monitors "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/monitors"
+	v2pools "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	"github.com/gophercloud/gophercloud/pagination"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+// healthCheckPath is the path kube-proxy serves the health check of a Service
+// with the Local external traffic policy on, on its health check node port.
+const healthCheckPath = "/healthz"
+
+// healthCheck is how the members of a pool are checked by its monitor.
+type healthCheck struct {
+	monitorType string
+	// urlPath is requested by HTTP and HTTPS monitors
+	urlPath string
+	// port is the monitor port of the members, 0 for their protocol port
+	port int
+	// delay and timeout are in seconds. Unlike the type, they are updated
+	// in place, as is the URL path.
+	delay      int
+	timeout    int
+	maxRetries int
+}
+
+// isHealthMonitorEnabled returns whether the pools of a Service get a health
+// monitor, with the enable-health-monitor annotation or create-monitor.
+func (lbaas *LbaasV2) isHealthMonitorEnabled(service *v1.Service) (bool, error) {
+	return getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerEnableHealthMonitor, lbaas.opts.CreateMonitor)
+}
+
+// serviceHealthCheck returns the health check of the pool of a Service port.
+// With the Local external traffic policy only the nodes running an endpoint
+// of the Service pass the health check kube-proxy serves on the health check
+// node port. Setting the port the members are checked on needs Octavia.
+// Otherwise UDP ports are checked with UDP-CONNECT. The health monitor
+// annotations of the Service override the type of the TCP ports, and the
+// timing of the cloud config.
+func (lbaas *LbaasV2) serviceHealthCheck(service *v1.Service, port v1.ServicePort) (healthCheck, error) {
+	hc, err := getHealthCheckTiming(service, lbaas.opts)
+	if err != nil {
+		return hc, err
+	}
+
+	monitorType := strings.ToUpper(getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorType, ""))
+	switch {
+	case monitorType != "" && port.Protocol == v1.ProtocolTCP:
+		switch monitorType {
+		case v2monitors.TypeTCP:
+		case v2monitors.TypeHTTP, v2monitors.TypeHTTPS:
+			hc.urlPath = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorURLPath, "/")
+		default:
+			return hc, fmt.Errorf("unsupported %s annotation: %v, specify TCP, HTTP or HTTPS", ServiceAnnotationLoadBalancerHealthMonitorType, monitorType)
+		}
+		hc.monitorType = monitorType
+	case lbaas.opts.UseOctavia && service.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyTypeLocal && service.Spec.HealthCheckNodePort != 0:
+		hc.monitorType = v2monitors.TypeHTTP
+		hc.urlPath = healthCheckPath
+		hc.port = int(service.Spec.HealthCheckNodePort)
+	case port.Protocol == v1.ProtocolUDP:
+		hc.monitorType = monitorTypeUDPConnect
+	default:
+		hc.monitorType = string(port.Protocol)
+	}
+	return hc, nil
+}
+
+// getHealthCheckTiming returns a health check with the delay, timeout and
+// max retries of the annotations of a Service, or of the cloud config.
+func getHealthCheckTiming(service *v1.Service, opts LoadBalancerOpts) (healthCheck, error) {
+	hc := healthCheck{
+		delay:      int(opts.MonitorDelay.Duration.Seconds()),
+		timeout:    int(opts.MonitorTimeout.Duration.Seconds()),
+		maxRetries: int(opts.MonitorMaxRetries),
+	}
+	for _, d := range []struct {
+		annotation string
+		seconds    *int
+	}{
+		{ServiceAnnotationLoadBalancerHealthMonitorDelay, &hc.delay},
+		{ServiceAnnotationLoadBalancerHealthMonitorTimeout, &hc.timeout},
+	} {
+		value, ok := service.Annotations[d.annotation]
+		if !ok {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration < time.Second {
+			return hc, fmt.Errorf("invalid %s annotation: %v, specify a duration of at least 1s, e.g. 5s", d.annotation, value)
+		}
+		*d.seconds = int(duration.Seconds())
+	}
+	if value, ok := service.Annotations[ServiceAnnotationLoadBalancerHealthMonitorMaxRetries]; ok {
+		maxRetries, err := strconv.Atoi(value)
+		if err != nil || maxRetries < 1 || maxRetries > 10 {
+			return hc, fmt.Errorf("invalid %s annotation: %v, specify a number between 1 and 10", ServiceAnnotationLoadBalancerHealthMonitorMaxRetries, value)
+		}
+		hc.maxRetries = maxRetries
+	}
+	if hc.delay == 0 || hc.timeout == 0 || hc.maxRetries == 0 {
+		return hc, fmt.Errorf("the health monitor delay, timeout and max retries should be set, with the %s, %s and %s annotations or in cloud provider config",
+			ServiceAnnotationLoadBalancerHealthMonitorDelay, ServiceAnnotationLoadBalancerHealthMonitorTimeout, ServiceAnnotationLoadBalancerHealthMonitorMaxRetries)
+	}
+	return hc, nil
+}
+
+// memberMonitorPort returns the monitor port of the members of a Service
+// port, 0 when they are checked on their protocol port or not checked.
+func (lbaas *LbaasV2) memberMonitorPort(service *v1.Service, port v1.ServicePort) (int, error) {
+	monitored, err := lbaas.isHealthMonitorEnabled(service)
+	if err != nil || !monitored {
+		return 0, err
+	}
+	hc, err := lbaas.serviceHealthCheck(service, port)
+	if err != nil {
+		return 0, err
+	}
+	return hc.port, nil
+}
+
+func (hc healthCheck) matches(monitor *v2monitors.Monitor) bool {
+	return monitor.Type == hc.monitorType
+}
+
+// needsUpdate returns whether the settings of monitor that can be updated
+// differ from the health check.
+func (hc healthCheck) needsUpdate(monitor *v2monitors.Monitor) bool {
+	return monitor.Delay != hc.delay || monitor.Timeout != hc.timeout || monitor.MaxRetries != hc.maxRetries ||
+		(hc.urlPath != "" && monitor.URLPath != hc.urlPath)
+}
+
+// memberCreateOpts sets the monitor_port of a new member.
+type memberCreateOpts struct {
+	v2pools.CreateMemberOpts
+	// MonitorPort is not set when 0
+	MonitorPort int
+}
+
+func (opts memberCreateOpts) ToMemberCreateMap() (map[string]interface{}, error) {
+	b, err := opts.CreateMemberOpts.ToMemberCreateMap()
+	if err != nil || opts.MonitorPort == 0 {
+		return b, err
+	}
+	b["member"].(map[string]interface{})["monitor_port"] = opts.MonitorPort
+	return b, nil
+}
+
+// memberMonitorPortUpdate changes the monitor_port of a member, 0 resets it
+// to the protocol port.
+type memberMonitorPortUpdate struct {
+	monitorPort int
+}
+
+func (opts memberMonitorPortUpdate) ToMemberUpdateMap() (map[string]interface{}, error) {
+	var port interface{}
+	if opts.monitorPort != 0 {
+		port = opts.monitorPort
+	}
+	return map[string]interface{}{"member": map[string]interface{}{"monitor_port": port}}, nil
+}
+
+// getMemberMonitorPorts returns the monitor_port of the members of a pool by
+// member ID, 0 when it is not set.
+func getMemberMonitorPorts(client *gophercloud.ServiceClient, poolID string) (map[string]int, error) {
+	ports := make(map[string]int)
+	err := v2pools.ListMembers(client, poolID, v2pools.ListMembersOpts{}).EachPage(func(page pagination.Page) (bool, error) {
+		var members []struct {
+			ID          string `json:"id"`
+			MonitorPort *int   `json:"monitor_port"`
+		}
+		if err := page.(v2pools.MemberPage).ExtractIntoSlicePtr(&members, "members"); err != nil {
+			return false, err
+		}
+		for _, member := range members {
+			ports[member.ID] = 0
+			if member.MonitorPort != nil {
+				ports[member.ID] = *member.MonitorPort
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ports, nil
+}
+
+// ensurePoolHealthCheck ensures the pool has a monitor doing the health check
+// and returns its ID. A monitor of another type is replaced and the monitor
+// port of the members changed in place, without touching the pool. The steps
+// are ordered so that members serving the Service keep passing the check that
+// is in place: the port members are checked on is switched while the monitor
+// checks with TCP, which the health check node port of every node accepts.
+func (lbaas *LbaasV2) ensurePoolHealthCheck(loadbalancerID string, pool *v2pools.Pool, hc healthCheck, monitorName string, plan *lbPlan) (string, error) {
+	monitorID := pool.MonitorID
+	var monitor *v2monitors.Monitor
+	if monitorID != "" {
+		var err error
+		monitor, err = v2monitors.Get(lbaas.lb, monitorID).Extract()
+		if err != nil && !cpoerrors.IsNotFound(err) {
+			return "", fmt.Errorf("error getting monitor %s for pool %s: %v", monitorID, pool.ID, err)
+		}
+		if monitor == nil {
+			monitorID = ""
+		}
+	}
+
+	// Switching to a health check port, the members are checked on it first
+	if hc.port != 0 {
+		if err := lbaas.ensureMemberMonitorPorts(loadbalancerID, pool, hc.port, plan); err != nil {
+			return "", err
+		}
+	}
+
+	if monitor != nil && !hc.matches(monitor) {
+		// The type of a monitor cannot be updated
+		if plan.apply(lbChange{Action: lbActionDelete, Resource: lbResourceMonitor, Name: monitor.Name, ID: monitorID, Detail: fmt.Sprintf("%s for pool %s, replaced by %s", monitor.Type, pool.Name, hc.monitorType)}) {
+			klog.V(4).Infof("Replacing %s monitor %s for pool %s by %s", monitor.Type, monitorID, pool.ID, hc.monitorType)
+			err := v2monitors.Delete(lbaas.lb, monitorID).ExtractErr()
+			if err != nil && !cpoerrors.IsNotFound(err) {
+				return "", fmt.Errorf("error deleting monitor %s for pool %s: %v", monitorID, pool.ID, err)
+			}
+			provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancerID)
+			if err != nil {
+				return "", fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+			}
+		}
+		monitorID = ""
+	}
+
+	if monitorID != "" && hc.needsUpdate(monitor) {
+		detail := fmt.Sprintf("delay %ds -> %ds, timeout %ds -> %ds, max retries %d -> %d", monitor.Delay, hc.delay, monitor.Timeout, hc.timeout, monitor.MaxRetries, hc.maxRetries)
+		if hc.urlPath != "" {
+			detail += fmt.Sprintf(", URL path %s -> %s", monitor.URLPath, hc.urlPath)
+		}
+		if plan.apply(lbChange{Action: lbActionUpdate, Resource: lbResourceMonitor, Name: monitor.Name, ID: monitorID, Detail: detail}) {
+			klog.V(4).Infof("Updating monitor %s for pool %s: %s", monitorID, pool.ID, detail)
+			updateOpts := v2monitors.UpdateOpts{
+				Delay:      hc.delay,
+				Timeout:    hc.timeout,
+				MaxRetries: hc.maxRetries,
+				URLPath:    hc.urlPath,
+			}
+			if _, err := v2monitors.Update(lbaas.lb, monitorID, updateOpts).Extract(); err != nil {
+				return "", fmt.Errorf("error updating monitor %s for pool %s: %v", monitorID, pool.ID, err)
+			}
+			provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancerID)
+			if err != nil {
+				return "", fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+			}
+		}
+	}
+
+	if monitorID == "" {
+		monitorCreateOpts := v2monitors.CreateOpts{
+			Name:       monitorName,
+			PoolID:     pool.ID,
+			Type:       hc.monitorType,
+			Delay:      hc.delay,
+			Timeout:    hc.timeout,
+			MaxRetries: hc.maxRetries,
+		}
+		if hc.urlPath != "" {
+			monitorCreateOpts.HTTPMethod = "GET"
+			monitorCreateOpts.URLPath = hc.urlPath
+			monitorCreateOpts.ExpectedCodes = "200"
+		}
+		if plan.apply(lbChange{Action: lbActionCreate, Resource: lbResourceMonitor, Name: monitorCreateOpts.Name, Detail: fmt.Sprintf("%s delay %ds, timeout %ds, max retries %d for pool %s", monitorCreateOpts.Type, monitorCreateOpts.Delay, monitorCreateOpts.Timeout, monitorCreateOpts.MaxRetries, pool.Name)}) {
+			klog.V(4).Infof("Creating monitor for pool %s", pool.ID)
+			monitor, err := v2monitors.Create(lbaas.lb, monitorCreateOpts).Extract()
+			if err != nil {
+				return "", fmt.Errorf("error creating LB pool healthmonitor: %v", err)
+			}
+			provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancerID)
+			if err != nil {
+				return "", fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+			}
+			monitorID = monitor.ID
+		}
+	}
+
+	// Switching back to the protocol port, the TCP monitor is in place first
+	if hc.port == 0 {
+		if err := lbaas.ensureMemberMonitorPorts(loadbalancerID, pool, 0, plan); err != nil {
+			return "", err
+		}
+	}
+	return monitorID, nil
+}
+
+// deletePoolHealthCheck deletes the monitor of a pool whose Service disables
+// the health monitor, and resets the monitor port of its members.
+func (lbaas *LbaasV2) deletePoolHealthCheck(loadbalancerID string, pool *v2pools.Pool, plan *lbPlan) error {
+	if plan.apply(lbChange{Action: lbActionDelete, Resource: lbResourceMonitor, ID: pool.MonitorID, Detail: fmt.Sprintf("for pool %s, health monitor disabled", pool.Name)}) {
+		klog.V(4).Infof("Deleting monitor %s for pool %s", pool.MonitorID, pool.ID)
+		err := v2monitors.Delete(lbaas.lb, pool.MonitorID).ExtractErr()
+		if err != nil && !cpoerrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting monitor %s for pool %s: %v", pool.MonitorID, pool.ID, err)
+		}
+		provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancerID)
+		if err != nil {
+			return fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+		}
+	}
+	return lbaas.ensureMemberMonitorPorts(loadbalancerID, pool, 0, plan)
+}
+
+// ensureMemberMonitorPorts sets the monitor port of all the members of a pool.
+func (lbaas *LbaasV2) ensureMemberMonitorPorts(loadbalancerID string, pool *v2pools.Pool, monitorPort int, plan *lbPlan) error {
+	// Objects only planned in dry-run mode have no ID and no members yet
+	if pool.ID == "" {
+		return nil
+	}
+	ports, err := getMemberMonitorPorts(lbaas.lb, pool.ID)
+	if err != nil && !cpoerrors.IsNotFound(err) {
+		return fmt.Errorf("error getting pool members %s: %v", pool.ID, err)
+	}
+	for _, memberID := range sets.StringKeySet(ports).List() {
+		port := ports[memberID]
+		if port == monitorPort {
+			continue
+		}
+		if !plan.apply(lbChange{Action: lbActionUpdate, Resource: lbResourceMember, ID: memberID, Detail: fmt.Sprintf("monitor port %d -> %d in pool %s", port, monitorPort, pool.Name)}) {
+			continue
+		}
+		klog.V(4).Infof("Updating monitor port of member %s for pool %s from %d to %d", memberID, pool.ID, port, monitorPort)
+		_, err := v2pools.UpdateMember(lbaas.lb, pool.ID, memberID, memberMonitorPortUpdate{monitorPort: monitorPort}).Extract()
+		if err != nil {
+			return fmt.Errorf("error updating monitor port of member %s for pool %s: %v", memberID, pool.ID, err)
+		}
+		provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(lbaas.lb, loadbalancerID)
+		if err != nil {
+			return fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+		}
+	}
+	return nil
+}
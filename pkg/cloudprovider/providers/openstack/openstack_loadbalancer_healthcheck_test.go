@@ -0,0 +1,401 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	v2pools "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeOctavia serves a load balancer with a pool and its members and
+// monitor, and records the changes made to them.
+type fakeOctavia struct {
+	mu      sync.Mutex
+	monitor map[string]interface{}
+	// members are the monitor ports by member ID, 0 when not set
+	members map[string]int
+	// changes are the changes made, in order
+	changes []string
+	// unhealthy records the changes after which no member would pass the
+	// health check in place
+	unhealthy []string
+}
+
+func (o *fakeOctavia) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case r.Method == "GET" && path == "/lbaas/loadbalancers/lb-id":
+		fmt.Fprint(w, `{"loadbalancer": {"id": "lb-id", "provisioning_status": "ACTIVE"}}`)
+
+	case r.Method == "GET" && path == "/lbaas/pools/pool-id/members":
+		var members []map[string]interface{}
+		for id, port := range o.members {
+			member := map[string]interface{}{"id": id, "protocol_port": 30080, "monitor_port": nil}
+			if port != 0 {
+				member["monitor_port"] = port
+			}
+			members = append(members, member)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"members": members})
+
+	case r.Method == "PUT" && strings.HasPrefix(path, "/lbaas/pools/pool-id/members/"):
+		id := strings.TrimPrefix(path, "/lbaas/pools/pool-id/members/")
+		var body struct {
+			Member struct {
+				MonitorPort *int `json:"monitor_port"`
+			} `json:"member"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		o.members[id] = 0
+		if body.Member.MonitorPort != nil {
+			o.members[id] = *body.Member.MonitorPort
+		}
+		o.record(fmt.Sprintf("update member %s monitor port %d", id, o.members[id]))
+		fmt.Fprintf(w, `{"member": {"id": "%s"}}`, id)
+
+	case r.Method == "GET" && path == "/lbaas/healthmonitors/"+fmt.Sprint(o.monitor["id"]):
+		json.NewEncoder(w).Encode(map[string]interface{}{"healthmonitor": o.monitor})
+
+	case r.Method == "DELETE" && path == "/lbaas/healthmonitors/"+fmt.Sprint(o.monitor["id"]):
+		o.record(fmt.Sprintf("delete %s monitor", o.monitor["type"]))
+		o.monitor = nil
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "PUT" && path == "/lbaas/healthmonitors/"+fmt.Sprint(o.monitor["id"]):
+		var body struct {
+			Monitor map[string]interface{} `json:"healthmonitor"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		for k, v := range body.Monitor {
+			o.monitor[k] = v
+		}
+		o.record(fmt.Sprintf("update %s monitor", o.monitor["type"]))
+		json.NewEncoder(w).Encode(map[string]interface{}{"healthmonitor": o.monitor})
+
+	case r.Method == "POST" && path == "/lbaas/healthmonitors":
+		var body struct {
+			Monitor map[string]interface{} `json:"healthmonitor"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		o.monitor = body.Monitor
+		o.monitor["id"] = fmt.Sprintf("monitor-%d", len(o.changes))
+		o.record(fmt.Sprintf("create %s monitor", o.monitor["type"]))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"healthmonitor": o.monitor})
+
+	default:
+		o.record(fmt.Sprintf("unexpected %s %s", r.Method, path))
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// record records a change, and whether a member still passes the health
+// check afterwards. A TCP monitor passes on the node port and the health
+// check node port of any node, an HTTP one on the health check node port of
+// member-1 only, which runs the endpoint. Without a monitor all the members
+// are used.
+func (o *fakeOctavia) record(change string) {
+	o.changes = append(o.changes, change)
+	if o.monitor == nil || o.monitor["type"] == "TCP" {
+		return
+	}
+	if o.monitor["type"] != "HTTP" || o.members["member-1"] != 30256 {
+		o.unhealthy = append(o.unhealthy, change)
+	}
+}
+
+// testMonitorOpts are LoadBalancerOpts with the timing of the monitors of
+// the tests, 5s delay, 3s timeout and 1 retry.
+func testMonitorOpts(useOctavia bool) LoadBalancerOpts {
+	return LoadBalancerOpts{
+		UseOctavia:        useOctavia,
+		CreateMonitor:     true,
+		MonitorDelay:      MyDuration{5 * time.Second},
+		MonitorTimeout:    MyDuration{3 * time.Second},
+		MonitorMaxRetries: 1,
+	}
+}
+
+func TestServiceHealthCheck(t *testing.T) {
+	port := v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}
+	local := &v1.Service{Spec: v1.ServiceSpec{
+		ExternalTrafficPolicy: v1.ServiceExternalTrafficPolicyTypeLocal,
+		HealthCheckNodePort:   30256,
+	}}
+	cluster := &v1.Service{Spec: v1.ServiceSpec{ExternalTrafficPolicy: v1.ServiceExternalTrafficPolicyTypeCluster}}
+	udp := v1.ServicePort{Protocol: v1.ProtocolUDP, Port: 53, NodePort: 30053}
+	octavia := &LbaasV2{LoadBalancer{opts: testMonitorOpts(true)}}
+	// Neutron LBaaS cannot check another port than the member one
+	neutron := &LbaasV2{LoadBalancer{opts: testMonitorOpts(false)}}
+
+	tests := []struct {
+		name    string
+		lbaas   *LbaasV2
+		service *v1.Service
+		port    v1.ServicePort
+		want    healthCheck
+	}{
+		{"Local", octavia, local, port, healthCheck{monitorType: "HTTP", urlPath: "/healthz", port: 30256}},
+		{"Cluster", octavia, cluster, port, healthCheck{monitorType: "TCP"}},
+		{"UDP port", octavia, cluster, udp, healthCheck{monitorType: "UDP-CONNECT"}},
+		{"Local without Octavia", neutron, local, port, healthCheck{monitorType: "TCP"}},
+	}
+	for _, test := range tests {
+		test.want.delay, test.want.timeout, test.want.maxRetries = 5, 3, 1
+		hc, err := test.lbaas.serviceHealthCheck(test.service, test.port)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		} else if hc != test.want {
+			t.Errorf("%s: expected health check %+v, got %+v", test.name, test.want, hc)
+		}
+	}
+
+	octavia.opts.CreateMonitor = false
+	if monitorPort, err := octavia.memberMonitorPort(local, port); err != nil || monitorPort != 0 {
+		t.Errorf("members should not get a monitor port without monitors, got %d: %v", monitorPort, err)
+	}
+}
+
+func TestServiceHealthCheckAnnotations(t *testing.T) {
+	port := v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}
+	lbaas := &LbaasV2{LoadBalancer{opts: testMonitorOpts(true)}}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        healthCheck
+		wantErr     bool
+	}{
+		{
+			name: "HTTP with a URL path and timing",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerHealthMonitorType:       "http",
+				ServiceAnnotationLoadBalancerHealthMonitorURLPath:    "/ready",
+				ServiceAnnotationLoadBalancerHealthMonitorDelay:      "10s",
+				ServiceAnnotationLoadBalancerHealthMonitorTimeout:    "2s",
+				ServiceAnnotationLoadBalancerHealthMonitorMaxRetries: "4",
+			},
+			want: healthCheck{monitorType: "HTTP", urlPath: "/ready", delay: 10, timeout: 2, maxRetries: 4},
+		},
+		{
+			name:        "HTTPS on the root",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerHealthMonitorType: "HTTPS"},
+			want:        healthCheck{monitorType: "HTTPS", urlPath: "/", delay: 5, timeout: 3, maxRetries: 1},
+		},
+		{
+			name:        "unsupported type",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerHealthMonitorType: "PING"},
+			wantErr:     true,
+		},
+		{
+			name:        "delay without unit",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerHealthMonitorDelay: "10"},
+			wantErr:     true,
+		},
+		{
+			name:        "too many retries",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerHealthMonitorMaxRetries: "11"},
+			wantErr:     true,
+		},
+	}
+	for _, test := range tests {
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations}}
+		hc, err := lbaas.serviceHealthCheck(service, port)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, got health check %+v", test.name, hc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		} else if hc != test.want {
+			t.Errorf("%s: expected health check %+v, got %+v", test.name, test.want, hc)
+		}
+	}
+
+	// The timing is required when the cloud config enables no monitor
+	lbaas.opts = LoadBalancerOpts{UseOctavia: true}
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{ServiceAnnotationLoadBalancerEnableHealthMonitor: "true"}}}
+	if monitored, err := lbaas.isHealthMonitorEnabled(service); err != nil || !monitored {
+		t.Errorf("the annotation should enable the health monitor: %v", err)
+	}
+	if _, err := lbaas.serviceHealthCheck(service, port); err == nil {
+		t.Errorf("expected an error without the health monitor timing")
+	}
+}
+
+func TestEnsurePoolHealthCheckTransition(t *testing.T) {
+	octavia := &fakeOctavia{
+		monitor: map[string]interface{}{"id": "monitor-tcp", "type": "TCP", "delay": 5, "timeout": 3, "max_retries": 1},
+		members: map[string]int{"member-1": 0, "member-2": 0},
+	}
+	srv := httptest.NewServer(octavia)
+	defer srv.Close()
+
+	lbaas := &LbaasV2{LoadBalancer{
+		lb: &gophercloud.ServiceClient{
+			ProviderClient: &gophercloud.ProviderClient{TokenID: "token"},
+			Endpoint:       srv.URL + "/",
+		},
+		opts: testMonitorOpts(true),
+	}}
+	port := v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}
+	service := &v1.Service{Spec: v1.ServiceSpec{
+		ExternalTrafficPolicy: v1.ServiceExternalTrafficPolicyTypeLocal,
+		HealthCheckNodePort:   30256,
+		Ports:                 []v1.ServicePort{port},
+	}}
+
+	ensure := func(monitorID string) string {
+		pool := &v2pools.Pool{ID: "pool-id", Name: "pool_0_test", MonitorID: monitorID}
+		hc, err := lbaas.serviceHealthCheck(service, port)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		monitorID, err = lbaas.ensurePoolHealthCheck("lb-id", pool, hc, "monitor_0_test", newLBPlan("default/test", false))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return monitorID
+	}
+	checkChanges := func(transition string, expected []string) {
+		octavia.mu.Lock()
+		defer octavia.mu.Unlock()
+		// Any pool or member deletion shows up as unexpected
+		changes := octavia.changes
+		if !reflect.DeepEqual(changes, expected) {
+			t.Errorf("%s: expected changes %v, got %v", transition, expected, changes)
+		}
+		if len(octavia.unhealthy) != 0 {
+			t.Errorf("%s: no member passed the health check after %v", transition, octavia.unhealthy)
+		}
+		octavia.changes = nil
+	}
+
+	// Cluster to Local, the pool is kept and the members are updated before
+	// the monitor gets replaced
+	monitorID := ensure("monitor-tcp")
+	checkChanges("Cluster to Local", []string{
+		"update member member-1 monitor port 30256",
+		"update member member-2 monitor port 30256",
+		"delete TCP monitor",
+		"create HTTP monitor",
+	})
+	if octavia.monitor["url_path"] != "/healthz" || octavia.monitor["http_method"] != "GET" {
+		t.Errorf("unexpected HTTP monitor: %v", octavia.monitor)
+	}
+
+	// Nothing to do once in place
+	monitorID = ensure(monitorID)
+	checkChanges("Local", nil)
+
+	// Local to Cluster, the monitor is replaced before the members are
+	// updated
+	service.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeCluster
+	service.Spec.HealthCheckNodePort = 0
+	monitorID = ensure(monitorID)
+	checkChanges("Local to Cluster", []string{
+		"delete HTTP monitor",
+		"create TCP monitor",
+		"update member member-1 monitor port 0",
+		"update member member-2 monitor port 0",
+	})
+
+	monitorID = ensure(monitorID)
+	checkChanges("Cluster", nil)
+
+	// The timing of the monitor is updated in place
+	service.Annotations = map[string]string{ServiceAnnotationLoadBalancerHealthMonitorDelay: "30s"}
+	monitorID = ensure(monitorID)
+	checkChanges("new delay", []string{"update TCP monitor"})
+	if octavia.monitor["delay"] != float64(30) || octavia.monitor["timeout"] != float64(3) {
+		t.Errorf("unexpected updated monitor: %v", octavia.monitor)
+	}
+
+	ensure(monitorID)
+	checkChanges("new delay in place", nil)
+}
+
+func TestDeletePoolHealthCheck(t *testing.T) {
+	octavia := &fakeOctavia{
+		monitor: map[string]interface{}{"id": "monitor-http", "type": "HTTP", "url_path": "/healthz"},
+		members: map[string]int{"member-1": 30256, "member-2": 30256},
+	}
+	srv := httptest.NewServer(octavia)
+	defer srv.Close()
+
+	lbaas := &LbaasV2{LoadBalancer{
+		lb: &gophercloud.ServiceClient{
+			ProviderClient: &gophercloud.ProviderClient{TokenID: "token"},
+			Endpoint:       srv.URL + "/",
+		},
+		opts: testMonitorOpts(true),
+	}}
+	pool := &v2pools.Pool{ID: "pool-id", Name: "pool_0_test", MonitorID: "monitor-http"}
+	if err := lbaas.deletePoolHealthCheck("lb-id", pool, newLBPlan("default/test", false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The members are checked on their port again once the monitor is gone
+	expected := []string{
+		"delete HTTP monitor",
+		"update member member-1 monitor port 0",
+		"update member member-2 monitor port 0",
+	}
+	if changes := octavia.changes; !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+}
+
+func TestMemberCreateOpts(t *testing.T) {
+	opts := memberCreateOpts{
+		CreateMemberOpts: v2pools.CreateMemberOpts{Address: "10.0.0.1", ProtocolPort: 30080},
+		MonitorPort:      30256,
+	}
+	b, err := opts.ToMemberCreateMap()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if port := b["member"].(map[string]interface{})["monitor_port"]; port != 30256 {
+		t.Errorf("expected the monitor port to be set, got %v", port)
+	}
+
+	opts.MonitorPort = 0
+	b, err = opts.ToMemberCreateMap()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := b["member"].(map[string]interface{})["monitor_port"]; ok {
+		t.Errorf("the monitor port should not be set, got %v", b)
+	}
+}
@@ -0,0 +1,324 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	neutrontags "github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/groups"
+	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"k8s.io/api/core/v1"
+	"k8s.io/klog"
+
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+// A hibernated load balancer is marked with tags on its VIP port, which is
+// kept together with the VIP address and the floating IP.
+const (
+	lbHibernatedTag               = "kube-lb-hibernated"
+	lbHibernatedAtTagPrefix       = "kube-lb-hibernated-at="
+	lbHibernatedClusterTagPrefix  = "kube-lb-hibernated-cluster="
+	lbHibernatedKeepFloatingIPTag = "kube-lb-keep-floatingip"
+
+	// lbHibernationJanitorInterval is how often hibernated load balancers
+	// are checked for expiry.
+	lbHibernationJanitorInterval = time.Hour
+
+	// lbNamePrefix is the prefix of the names given by GetLoadBalancerName,
+	// followed by the cluster name. Only those load balancers are purged by
+	// the janitor.
+	lbNamePrefix = "kube_service_"
+)
+
+// hibernatedAt returns when the load balancer owning the VIP port with the
+// given tags was hibernated.
+func hibernatedAt(tags []string) (time.Time, bool) {
+	hibernated := false
+	var at time.Time
+	for _, tag := range tags {
+		if tag == lbHibernatedTag {
+			hibernated = true
+		}
+		if strings.HasPrefix(tag, lbHibernatedAtTagPrefix) {
+			t, err := time.Parse(time.RFC3339, strings.TrimPrefix(tag, lbHibernatedAtTagPrefix))
+			if err != nil {
+				klog.Warningf("Ignoring invalid hibernation tag %q: %v", tag, err)
+				continue
+			}
+			at = t
+		}
+	}
+	return at, hibernated
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// setListenersAdminState enables or disables all the listeners of the load balancer.
+func setListenersAdminState(client *gophercloud.ServiceClient, loadbalancer *loadbalancers.LoadBalancer, up bool) error {
+	lbListeners, err := getListenersByLoadBalancerID(client, loadbalancer.ID)
+	if err != nil {
+		return fmt.Errorf("error getting LB %s listeners: %v", loadbalancer.Name, err)
+	}
+
+	for _, listener := range lbListeners {
+		if listener.AdminStateUp == up {
+			continue
+		}
+
+		klog.V(4).Infof("Setting admin state of listener %s to %v", listener.ID, up)
+		_, err := listeners.Update(client, listener.ID, listeners.UpdateOpts{AdminStateUp: &up}).Extract()
+		if err != nil {
+			return fmt.Errorf("error updating LB listener %s: %v", listener.ID, err)
+		}
+
+		provisioningStatus, err := waitLoadbalancerActiveProvisioningStatus(client, loadbalancer.ID)
+		if err != nil {
+			return fmt.Errorf("failed to loadbalance ACTIVE provisioning status %v: %v", provisioningStatus, err)
+		}
+	}
+	return nil
+}
+
+// hibernateLoadBalancer disables the listeners of the load balancer of a
+// deleted Service and marks it as hibernated instead of deleting it. The
+// security group of the Service is deleted since it is bound to the Service
+// UID, a resumed load balancer gets a new one.
+func (lbaas *LbaasV2) hibernateLoadBalancer(clusterName string, service *v1.Service, loadbalancer *loadbalancers.LoadBalancer, keepFloatingIP bool, plan *lbPlan) error {
+	serviceName := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+
+	port, err := getPortByID(lbaas.network, loadbalancer.VipPortID)
+	if err != nil {
+		return fmt.Errorf("failed to get VIP port %s of loadbalancer %s: %v", loadbalancer.VipPortID, loadbalancer.ID, err)
+	}
+	if _, hibernated := hibernatedAt(port.Tags); hibernated {
+		klog.V(4).Infof("Loadbalancer %s is already hibernated", loadbalancer.ID)
+		return nil
+	}
+
+	if !plan.apply(lbChange{Action: lbActionUpdate, Resource: lbResourceLoadBalancer, Name: loadbalancer.Name, ID: loadbalancer.ID, Detail: "hibernate, disabling its listeners"}) {
+		return nil
+	}
+
+	klog.V(2).Infof("Hibernating loadbalancer %s of Service %s", loadbalancer.ID, serviceName)
+
+	if err := setListenersAdminState(lbaas.lb, loadbalancer, false); err != nil {
+		return err
+	}
+
+	if lbaas.opts.ManageSecurityGroups {
+		if !lbaas.opts.UseOctavia {
+			if err := lbaas.detachSecurityGroupFromPort(service, port); err != nil {
+				return err
+			}
+		}
+		if err := lbaas.EnsureSecurityGroupDeleted(clusterName, service, plan); err != nil {
+			return fmt.Errorf("failed to delete Security Group for loadbalancer service %s: %v", serviceName, err)
+		}
+	}
+
+	// Tag last, an interrupted hibernation is retried on the next deletion attempt
+	tags := []string{lbHibernatedAtTagPrefix + time.Now().UTC().Format(time.RFC3339), lbHibernatedClusterTagPrefix + clusterName}
+	if keepFloatingIP {
+		tags = append(tags, lbHibernatedKeepFloatingIPTag)
+	}
+	tags = append(tags, lbHibernatedTag)
+	for _, tag := range tags {
+		if err := neutrontags.Add(lbaas.network, "ports", port.ID, tag).ExtractErr(); err != nil {
+			return fmt.Errorf("failed to add tag %s to port %s: %v", tag, port.ID, err)
+		}
+	}
+
+	klog.V(2).Infof("Hibernated loadbalancer %s of Service %s", loadbalancer.ID, serviceName)
+	return nil
+}
+
+// detachSecurityGroupFromPort removes the security group of the Service from
+// the VIP port.
+func (lbaas *LbaasV2) detachSecurityGroupFromPort(service *v1.Service, port *neutronports.Port) error {
+	lbSecGroupName := getSecurityGroupName(service)
+	lbSecGroupID, err := groups.IDFromName(lbaas.network, lbSecGroupName)
+	if err != nil {
+		if isSecurityGroupNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("error occurred finding security group: %s: %v", lbSecGroupName, err)
+	}
+
+	var securityGroups []string
+	for _, sg := range port.SecurityGroups {
+		if sg != lbSecGroupID {
+			securityGroups = append(securityGroups, sg)
+		}
+	}
+	if len(securityGroups) == len(port.SecurityGroups) {
+		return nil
+	}
+
+	updateOpts := neutronports.UpdateOpts{SecurityGroups: &securityGroups}
+	if res := neutronports.Update(lbaas.network, port.ID, updateOpts); res.Err != nil {
+		return fmt.Errorf("failed to remove security group %s from port %s: %v", lbSecGroupID, port.ID, res.Err)
+	}
+	return nil
+}
+
+// resumeLoadBalancer re-enables the listeners of a hibernated load balancer
+// and removes the hibernation tags. The rest of the reconcile brings the load
+// balancer in line with the Service.
+func (lbaas *LbaasV2) resumeLoadBalancer(loadbalancer *loadbalancers.LoadBalancer, plan *lbPlan) error {
+	port, err := getPortByID(lbaas.network, loadbalancer.VipPortID)
+	if err != nil {
+		return fmt.Errorf("failed to get VIP port %s of loadbalancer %s: %v", loadbalancer.VipPortID, loadbalancer.ID, err)
+	}
+
+	at, hibernated := hibernatedAt(port.Tags)
+	if !hibernated {
+		return nil
+	}
+
+	if !plan.apply(lbChange{Action: lbActionUpdate, Resource: lbResourceLoadBalancer, Name: loadbalancer.Name, ID: loadbalancer.ID, Detail: fmt.Sprintf("resume from hibernation since %s", at.Format(time.RFC3339))}) {
+		return nil
+	}
+
+	klog.V(2).Infof("Resuming loadbalancer %s hibernated since %v", loadbalancer.ID, at)
+
+	if err := setListenersAdminState(lbaas.lb, loadbalancer, true); err != nil {
+		return err
+	}
+
+	// Remove the marker tag last so the resume is retried until it succeeds
+	var tags []string
+	for _, tag := range port.Tags {
+		if strings.HasPrefix(tag, lbHibernatedAtTagPrefix) || strings.HasPrefix(tag, lbHibernatedClusterTagPrefix) || tag == lbHibernatedKeepFloatingIPTag {
+			tags = append(tags, tag)
+		}
+	}
+	tags = append(tags, lbHibernatedTag)
+	for _, tag := range tags {
+		err := neutrontags.Delete(lbaas.network, "ports", port.ID, tag).ExtractErr()
+		if err != nil && !cpoerrors.IsNotFound(err) {
+			return fmt.Errorf("failed to remove tag %s from port %s: %v", tag, port.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// purgeHibernatedLoadBalancers deletes the load balancers of the cluster
+// hibernated for longer than retention, with their floating IPs unless the
+// Service asked to keep them. Load balancers hibernated by other clusters
+// sharing the project are left alone.
+func (lbaas *LbaasV2) purgeHibernatedLoadBalancers(clusterName string, retention time.Duration) error {
+	clusterTag := lbHibernatedClusterTagPrefix + clusterName
+	ports, err := getPorts(lbaas.network, neutronports.ListOpts{Tags: lbHibernatedTag + "," + clusterTag})
+	if err != nil {
+		return fmt.Errorf("failed to list hibernated loadbalancer ports: %v", err)
+	}
+
+	var errs []string
+	for _, port := range ports {
+		// Neutron without tag filtering returns all the ports
+		if !hasTag(port.Tags, lbHibernatedTag) || !hasTag(port.Tags, clusterTag) {
+			continue
+		}
+		at, _ := hibernatedAt(port.Tags)
+		if at.IsZero() || time.Since(at) < retention {
+			continue
+		}
+
+		lbs, err := getLoadBalancers(lbaas.lb, loadbalancers.ListOpts{VipPortID: port.ID})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("failed to find loadbalancer of port %s: %v", port.ID, err))
+			continue
+		}
+		if len(lbs) != 1 || !strings.HasPrefix(lbs[0].Name, lbNamePrefix+clusterName+"_") {
+			klog.Warningf("Port %s is tagged as hibernated but does not belong to a loadbalancer of cluster %s, skipping", port.ID, clusterName)
+			continue
+		}
+		loadbalancer := &lbs[0]
+
+		klog.V(2).Infof("Deleting loadbalancer %s hibernated since %v", loadbalancer.ID, at)
+
+		plan := newLBPlan(loadbalancer.Name, lbaas.opts.DryRun)
+		if !hasTag(port.Tags, lbHibernatedKeepFloatingIPTag) {
+			if err := deleteFloatingIPForPort(lbaas.network, port.ID, plan); err != nil {
+				errs = append(errs, fmt.Sprintf("failed to delete floating ip of loadbalancer %s: %v", loadbalancer.ID, err))
+				continue
+			}
+		}
+		if err := lbaas.deleteLoadBalancer(loadbalancer, plan); err != nil {
+			errs = append(errs, err.Error())
+		}
+		if plan.DryRun {
+			klog.Infof("Dry-run of the purge of hibernated loadbalancer %s: %s", loadbalancer.Name, plan.report())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to purge hibernated loadbalancers: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// lbClusterName is the --cluster-name of the controller manager, the name
+// given to the load balancer calls.
+var lbClusterName string
+
+// SetClusterName gives the cloud provider the --cluster-name of the
+// controller manager. Only the load balancers hibernated by that cluster are
+// purged once hibernation-retention has passed.
+func SetClusterName(name string) {
+	lbClusterName = name
+}
+
+// hibernationClusterName returns the cluster whose hibernated load balancers
+// the janitor purges: the one set by SetClusterName, or the configured one
+// when the controller manager did not set it.
+func hibernationClusterName(configured string) string {
+	if lbClusterName == "" {
+		if configured == "" {
+			return "kubernetes"
+		}
+		return configured
+	}
+	if configured != "" && configured != lbClusterName {
+		klog.Warningf("Ignoring cluster-name %q of the cloud config, purging the hibernated loadbalancers of cluster %q given by --cluster-name", configured, lbClusterName)
+	}
+	return lbClusterName
+}
+
+// runHibernationJanitor periodically purges the expired hibernated load
+// balancers of the cluster.
+func (lbaas *LbaasV2) runHibernationJanitor(clusterName string, retention time.Duration) {
+	klog.V(4).Infof("Purging loadbalancers of cluster %s hibernated for more than %v", clusterName, retention)
+	if err := lbaas.purgeHibernatedLoadBalancers(clusterName, retention); err != nil {
+		klog.Errorf("%v", err)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHibernatedAt(t *testing.T) {
+	at := time.Date(2019, 3, 1, 20, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		tags       []string
+		hibernated bool
+		at         time.Time
+	}{
+		{name: "no tags"},
+		{name: "unrelated tags", tags: []string{"foo", "sg-id"}},
+		{
+			name:       "hibernated",
+			tags:       []string{"foo", lbHibernatedAtTagPrefix + at.Format(time.RFC3339), lbHibernatedTag},
+			hibernated: true,
+			at:         at,
+		},
+		{
+			// Interrupted resume, the marker is removed last
+			name: "timestamp only",
+			tags: []string{lbHibernatedAtTagPrefix + at.Format(time.RFC3339)},
+			at:   at,
+		},
+		{
+			name:       "invalid timestamp",
+			tags:       []string{lbHibernatedAtTagPrefix + "yesterday", lbHibernatedTag},
+			hibernated: true,
+		},
+	}
+
+	for _, test := range tests {
+		gotAt, hibernated := hibernatedAt(test.tags)
+		if hibernated != test.hibernated {
+			t.Errorf("%s: expected hibernated %v, got %v", test.name, test.hibernated, hibernated)
+		}
+		if !gotAt.Equal(test.at) {
+			t.Errorf("%s: expected hibernation time %v, got %v", test.name, test.at, gotAt)
+		}
+	}
+}
+
+func TestHibernateAndResumeLoadBalancer(t *testing.T) {
+	fake, srv, client := newFakeLBaaS(t)
+	defer srv.Close()
+
+	fake.add("ports", map[string]interface{}{"id": "vip", "tags": []string{"foo"}})
+	fake.add("lbaas/loadbalancers", map[string]interface{}{"id": "lb", "name": "kube_service_kubernetes_default_web", "vip_port_id": "vip"})
+	fake.add("lbaas/listeners", map[string]interface{}{"id": "listener", "loadbalancer_id": "lb"})
+
+	lbaas := &LbaasV2{LoadBalancer{network: client, lb: client, opts: LoadBalancerOpts{UseOctavia: true}}}
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}}
+	loadbalancer := &loadbalancers.LoadBalancer{ID: "lb", Name: "kube_service_kubernetes_default_web", VipPortID: "vip"}
+
+	if err := lbaas.hibernateLoadBalancer("kubernetes", service, loadbalancer, true, newLBPlan("default/web", false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if up := fake.get("lbaas/listeners", "listener")["admin_state_up"]; up != false {
+		t.Errorf("expected the listener to be disabled, got admin state %v", up)
+	}
+	var tags []string
+	for _, tag := range fake.get("ports", "vip")["tags"].([]interface{}) {
+		tags = append(tags, tag.(string))
+	}
+	if len(tags) != 5 || !hasTag(tags, lbHibernatedClusterTagPrefix+"kubernetes") || !hasTag(tags, lbHibernatedKeepFloatingIPTag) || tags[4] != lbHibernatedTag {
+		t.Errorf("unexpected tags of the hibernated VIP port: %v", tags)
+	}
+	if _, hibernated := hibernatedAt(tags); !hibernated {
+		t.Errorf("expected the VIP port to be tagged as hibernated: %v", tags)
+	}
+	fake.takeChanges()
+
+	// Nothing is changed in dry-run mode
+	if err := lbaas.resumeLoadBalancer(loadbalancer, newLBPlan("default/web", true)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changes := fake.takeChanges(); len(changes) != 0 {
+		t.Errorf("expected no changes in dry-run mode, got %v", changes)
+	}
+
+	if err := lbaas.resumeLoadBalancer(loadbalancer, newLBPlan("default/web", false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if up := fake.get("lbaas/listeners", "listener")["admin_state_up"]; up != true {
+		t.Errorf("expected the listener to be enabled, got admin state %v", up)
+	}
+	if tags := fake.get("ports", "vip")["tags"]; !reflect.DeepEqual(tags, []interface{}{"foo"}) {
+		t.Errorf("expected the hibernation tags to be removed, got %v", tags)
+	}
+	// The marker tag is removed last
+	changes := fake.takeChanges()
+	if len(changes) != 5 || changes[0] != "update listener listener" || changes[4] != "untag port vip "+lbHibernatedTag {
+		t.Errorf("unexpected changes resuming the loadbalancer: %v", changes)
+	}
+}
+
+func TestPurgeHibernatedLoadBalancers(t *testing.T) {
+	fake, srv, client := newFakeLBaaS(t)
+	defer srv.Close()
+
+	expired := lbHibernatedAtTagPrefix + time.Now().Add(-48*time.Hour).UTC().Format(time.RFC3339)
+	recent := lbHibernatedAtTagPrefix + time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	own := lbHibernatedClusterTagPrefix + "kubernetes"
+	hibernated := []struct {
+		name string
+		tags []string
+	}{
+		{name: "kube_service_kubernetes_default_expired", tags: []string{expired, own, lbHibernatedTag}},
+		{name: "kube_service_kubernetes_default_keep", tags: []string{expired, own, lbHibernatedKeepFloatingIPTag, lbHibernatedTag}},
+		{name: "kube_service_kubernetes_default_recent", tags: []string{recent, own, lbHibernatedTag}},
+		// Hibernated by another cluster in the same project
+		{name: "kube_service_other_default_expired", tags: []string{expired, lbHibernatedClusterTagPrefix + "other", lbHibernatedTag}},
+		// Hibernated before the cluster was tagged
+		{name: "kube_service_kubernetes_default_legacy", tags: []string{expired, lbHibernatedTag}},
+		// Tagged by hand
+		{name: "kube_service_other_default_web", tags: []string{expired, own, lbHibernatedTag}},
+		{name: "manual", tags: []string{expired, own, lbHibernatedTag}},
+	}
+	for i, lb := range hibernated {
+		port := fmt.Sprintf("port-%d", i)
+		fake.add("ports", map[string]interface{}{"id": port, "tags": lb.tags})
+		fake.add("lbaas/loadbalancers", map[string]interface{}{"id": lb.name, "name": lb.name, "vip_port_id": port})
+		fake.add("floatingips", map[string]interface{}{"id": fmt.Sprintf("fip-%d", i), "port_id": port})
+	}
+
+	lbaas := &LbaasV2{LoadBalancer{network: client, lb: client, opts: LoadBalancerOpts{UseOctavia: true}}}
+	if err := lbaas.purgeHibernatedLoadBalancers("kubernetes", 24*time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"delete floatingip fip-0",
+		"delete loadbalancer kube_service_kubernetes_default_expired",
+		"delete loadbalancer kube_service_kubernetes_default_keep",
+	}
+	if changes := fake.takeChanges(); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+	if fips := fake.ids("floatingips"); len(fips) != len(hibernated)-1 {
+		t.Errorf("unexpected remaining floating ips %v", fips)
+	}
+}
+
+func TestHibernationClusterName(t *testing.T) {
+	defer SetClusterName("")
+
+	tests := []struct {
+		flag       string
+		configured string
+		expected   string
+	}{
+		{flag: "", configured: "", expected: "kubernetes"},
+		{flag: "", configured: "configured", expected: "configured"},
+		{flag: "prod", configured: "", expected: "prod"},
+		{flag: "prod", configured: "kubernetes", expected: "prod"},
+		{flag: "prod", configured: "prod", expected: "prod"},
+	}
+	for _, test := range tests {
+		SetClusterName(test.flag)
+		if name := hibernationClusterName(test.configured); name != test.expected {
+			t.Errorf("--cluster-name %q, cluster-name %q: expected %q, got %q", test.flag, test.configured, test.expected, name)
+		}
+	}
+}
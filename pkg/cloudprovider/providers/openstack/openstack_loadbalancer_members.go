@@ -0,0 +1,153 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+const (
+	// ServiceAnnotationLoadBalancerMemberAddressType is the annotation used on the service to select the node
+	// address used for the pool members: InternalIP, ExternalIP or network:<name> for the address of the node on
+	// the named network. Nodes without such an address are skipped. Defaults to the member-address-type option of
+	// the LoadBalancer section in cloud config.
+	ServiceAnnotationLoadBalancerMemberAddressType = "loadbalancer.openstack.org/member-address-type"
+
+	memberAddressNetworkPrefix = "network:"
+)
+
+// memberAddressType selects the node address used for the pool members. The
+// zero value uses the InternalIP, or the ExternalIP of nodes without one.
+type memberAddressType struct {
+	addressType v1.NodeAddressType
+	network     string
+}
+
+func (t memberAddressType) String() string {
+	if t.network != "" {
+		return memberAddressNetworkPrefix + t.network
+	}
+	return string(t.addressType)
+}
+
+// parseMemberAddressType parses the member-address-type option or annotation.
+func parseMemberAddressType(value string) (memberAddressType, error) {
+	switch {
+	case value == "":
+		return memberAddressType{}, nil
+	case value == string(v1.NodeInternalIP), value == string(v1.NodeExternalIP):
+		return memberAddressType{addressType: v1.NodeAddressType(value)}, nil
+	case strings.HasPrefix(value, memberAddressNetworkPrefix) && len(value) > len(memberAddressNetworkPrefix):
+		return memberAddressType{network: strings.TrimPrefix(value, memberAddressNetworkPrefix)}, nil
+	}
+	return memberAddressType{}, fmt.Errorf("unknown member address type %q, specify %s, %s or %s<name>", value, v1.NodeInternalIP, v1.NodeExternalIP, memberAddressNetworkPrefix)
+}
+
+// poolMember is a node and the address it is added to the pools with.
+type poolMember struct {
+	node *v1.Node
+	addr string
+}
+
+// getMemberAddressType returns the member address type of a Service.
+func (lbaas *LbaasV2) getMemberAddressType(service *v1.Service) (memberAddressType, error) {
+	value := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerMemberAddressType, lbaas.opts.MemberAddressType)
+	addrType, err := parseMemberAddressType(value)
+	if err != nil {
+		return addrType, fmt.Errorf("invalid %s for Service %s/%s: %v", ServiceAnnotationLoadBalancerMemberAddressType, service.Namespace, service.Name, err)
+	}
+	return addrType, nil
+}
+
+// getPoolMembers returns the pool members of a Service for the nodes. With a
+// member address type, the nodes without an address of that type are left
+// out, recording an event on the Service.
+func (lbaas *LbaasV2) getPoolMembers(service *v1.Service, nodes []*v1.Node) ([]poolMember, error) {
+	addrType, err := lbaas.getMemberAddressType(service)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]poolMember, 0, len(nodes))
+	for _, node := range nodes {
+		var addr string
+		switch {
+		case addrType.network != "":
+			addr, err = lbaas.nodeNetworkAddress(node, addrType.network)
+		case addrType.addressType != "":
+			addr, err = nodeAddressOfType(node, addrType.addressType)
+		default:
+			addr, err = nodeAddressForLB(node)
+			if err != nil {
+				return nil, fmt.Errorf("error getting address for node %s: %v", node.Name, err)
+			}
+		}
+		if err == ErrNoAddressFound {
+			msg := fmt.Sprintf("Node %s has no %s address, it is not added to the load balancer", node.Name, addrType)
+			klog.Warningf("Service %s/%s: %s", service.Namespace, service.Name, msg)
+			if lbaas.eventRecorder != nil {
+				lbaas.eventRecorder.Event(service, v1.EventTypeWarning, "MemberAddressNotFound", msg)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting %s address for node %s: %v", addrType, node.Name, err)
+		}
+		members = append(members, poolMember{node: node, addr: addr})
+	}
+	return members, nil
+}
+
+// nodeAddressOfType returns the first address of a node of the given type.
+func nodeAddressOfType(node *v1.Node, addrType v1.NodeAddressType) (string, error) {
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == addrType {
+			return addr.Address, nil
+		}
+	}
+	return "", ErrNoAddressFound
+}
+
+// nodeNetworkAddress returns the fixed address of a node on the named
+// network. The node addresses do not record their network, so the server is
+// looked up in Nova.
+func (lbaas *LbaasV2) nodeNetworkAddress(node *v1.Node, network string) (string, error) {
+	srv, err := getServerByName(lbaas.compute, types.NodeName(node.Name))
+	if err != nil {
+		return "", err
+	}
+
+	var addresses map[string][]struct {
+		IPType string `mapstructure:"OS-EXT-IPS:type"`
+		Addr   string
+	}
+	if err := mapstructure.Decode(srv.Addresses, &addresses); err != nil {
+		return "", err
+	}
+	for _, addr := range addresses[network] {
+		if addr.IPType != "floating" {
+			return addr.Addr, nil
+		}
+	}
+	return "", ErrNoAddressFound
+}
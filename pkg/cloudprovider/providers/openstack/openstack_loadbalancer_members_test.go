@@ -0,0 +1,215 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestParseMemberAddressType(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected memberAddressType
+		err      bool
+	}{
+		{value: "", expected: memberAddressType{}},
+		{value: "InternalIP", expected: memberAddressType{addressType: v1.NodeInternalIP}},
+		{value: "ExternalIP", expected: memberAddressType{addressType: v1.NodeExternalIP}},
+		{value: "network:provider", expected: memberAddressType{network: "provider"}},
+		{value: "network:", err: true},
+		{value: "Hostname", err: true},
+		{value: "internalip", err: true},
+	}
+
+	for _, test := range tests {
+		addrType, err := parseMemberAddressType(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.value)
+			}
+			continue
+		}
+		if err != nil || addrType != test.expected {
+			t.Errorf("%q: expected %+v, got %+v %v", test.value, test.expected, addrType, err)
+		}
+	}
+}
+
+func newMemberNode(name string, addrs ...v1.NodeAddress) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     v1.NodeStatus{Addresses: addrs},
+	}
+}
+
+func memberAddrs(members []poolMember) []string {
+	var addrs []string
+	for _, member := range members {
+		addrs = append(addrs, member.node.Name+"="+member.addr)
+	}
+	return addrs
+}
+
+func TestGetPoolMembers(t *testing.T) {
+	nodes := []*v1.Node{
+		newMemberNode("both",
+			v1.NodeAddress{Type: v1.NodeExternalIP, Address: "203.0.113.1"},
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.1"}),
+		newMemberNode("internal",
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.2"}),
+		newMemberNode("external",
+			v1.NodeAddress{Type: v1.NodeExternalIP, Address: "203.0.113.3"}),
+	}
+
+	tests := []struct {
+		name       string
+		option     string
+		annotation string
+		expected   []string
+		skipped    []string
+	}{
+		{
+			name:     "default prefers the InternalIP",
+			expected: []string{"both=10.0.0.1", "internal=10.0.0.2", "external=203.0.113.3"},
+		},
+		{
+			name:     "InternalIP option",
+			option:   "InternalIP",
+			expected: []string{"both=10.0.0.1", "internal=10.0.0.2"},
+			skipped:  []string{"external"},
+		},
+		{
+			name:       "ExternalIP annotation overrides the option",
+			option:     "InternalIP",
+			annotation: "ExternalIP",
+			expected:   []string{"both=203.0.113.1", "external=203.0.113.3"},
+			skipped:    []string{"internal"},
+		},
+	}
+
+	for _, test := range tests {
+		recorder := record.NewFakeRecorder(len(nodes))
+		lbaas := &LbaasV2{LoadBalancer{
+			opts:          LoadBalancerOpts{MemberAddressType: test.option},
+			eventRecorder: recorder,
+		}}
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+		if test.annotation != "" {
+			service.Annotations = map[string]string{ServiceAnnotationLoadBalancerMemberAddressType: test.annotation}
+		}
+
+		members, err := lbaas.getPoolMembers(service, nodes)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if addrs := memberAddrs(members); !reflect.DeepEqual(addrs, test.expected) {
+			t.Errorf("%s: expected members %v, got %v", test.name, test.expected, addrs)
+		}
+
+		close(recorder.Events)
+		var skipped []string
+		for event := range recorder.Events {
+			if !strings.Contains(event, "MemberAddressNotFound") {
+				t.Errorf("%s: unexpected event: %s", test.name, event)
+			}
+			skipped = append(skipped, strings.Fields(event)[3])
+		}
+		if !reflect.DeepEqual(skipped, test.skipped) {
+			t.Errorf("%s: expected events for %v, got %v", test.name, test.skipped, skipped)
+		}
+	}
+}
+
+func TestGetPoolMembersErrors(t *testing.T) {
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+
+	// Without a member address type a node without an address is an error
+	lbaas := &LbaasV2{LoadBalancer{}}
+	if _, err := lbaas.getPoolMembers(service, []*v1.Node{newMemberNode("none")}); err == nil {
+		t.Errorf("expected an error for a node without addresses")
+	}
+
+	service.Annotations = map[string]string{ServiceAnnotationLoadBalancerMemberAddressType: "Hostname"}
+	if _, err := lbaas.getPoolMembers(service, nil); err == nil {
+		t.Errorf("expected an error for an invalid annotation")
+	}
+}
+
+func TestGetPoolMembersNetwork(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.Trim(r.URL.Query().Get("name"), "^$")
+		addresses := map[string]string{
+			"node-1": `{"private": [{"addr": "10.0.0.1", "OS-EXT-IPS:type": "fixed"}],
+				"provider": [{"addr": "192.0.2.1", "OS-EXT-IPS:type": "fixed"}]}`,
+			"node-2": `{"private": [{"addr": "10.0.0.2", "OS-EXT-IPS:type": "fixed"},
+				{"addr": "203.0.113.2", "OS-EXT-IPS:type": "floating"}]}`,
+		}[name]
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"servers": [{"id": "%s-id", "name": "%s", "addresses": %s}]}`, name, name, addresses)
+	}))
+	defer srv.Close()
+
+	recorder := record.NewFakeRecorder(2)
+	lbaas := &LbaasV2{LoadBalancer{
+		compute: &gophercloud.ServiceClient{
+			ProviderClient: &gophercloud.ProviderClient{TokenID: "token"},
+			Endpoint:       srv.URL + "/",
+		},
+		opts:          LoadBalancerOpts{MemberAddressType: "network:provider"},
+		eventRecorder: recorder,
+	}}
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	nodes := []*v1.Node{newMemberNode("node-1"), newMemberNode("node-2")}
+
+	members, err := lbaas.getPoolMembers(service, nodes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if addrs := memberAddrs(members); !reflect.DeepEqual(addrs, []string{"node-1=192.0.2.1"}) {
+		t.Errorf("expected the provider address of node-1 only, got %v", addrs)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "node-2 has no network:provider address") {
+			t.Errorf("unexpected event: %s", event)
+		}
+	default:
+		t.Errorf("expected an event for node-2")
+	}
+
+	// Switching the Service to the private network selects other addresses,
+	// so the members of the provider network get replaced
+	service.Annotations = map[string]string{ServiceAnnotationLoadBalancerMemberAddressType: "network:private"}
+	members, err = lbaas.getPoolMembers(service, nodes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if addrs := memberAddrs(members); !reflect.DeepEqual(addrs, []string{"node-1=10.0.0.1", "node-2=10.0.0.2"}) {
+		t.Errorf("expected the private addresses, got %v", addrs)
+	}
+}
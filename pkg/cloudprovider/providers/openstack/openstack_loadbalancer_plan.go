@@ -0,0 +1,154 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	lbActionCreate = "create"
+	lbActionUpdate = "update"
+	lbActionDelete = "delete"
+
+	lbResourceLoadBalancer      = "loadbalancer"
+	lbResourceListener          = "listener"
+	lbResourcePool              = "pool"
+	lbResourceMember            = "member"
+	lbResourceMonitor           = "monitor"
+	lbResourceFloatingIP        = "floatingip"
+	lbResourceSecurityGroup     = "securitygroup"
+	lbResourceSecurityGroupRule = "securitygrouprule"
+	lbResourcePort              = "port"
+
+	// Event messages are truncated by the API server, only list the first changes.
+	lbPlanEventMaxChanges = 10
+)
+
+// lbChange is a single mutation of an OpenStack object done while reconciling
+// a LoadBalancer Service.
+type lbChange struct {
+	Action   string `json:"action"`
+	Resource string `json:"resource"`
+	Name     string `json:"name,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+func (c lbChange) String() string {
+	s := fmt.Sprintf("%s %s", c.Action, c.Resource)
+	if c.Name != "" {
+		s += " " + c.Name
+	}
+	if c.ID != "" {
+		s += fmt.Sprintf(" (%s)", c.ID)
+	}
+	if c.Detail != "" {
+		s += ": " + c.Detail
+	}
+	return s
+}
+
+// lbPlan collects the changes of a reconcile. In dry-run mode the changes
+// are only recorded, objects that would have been created are represented by
+// placeholders with an empty ID so the rest of the reconcile treats them as
+// having no children yet.
+type lbPlan struct {
+	Service string     `json:"service"`
+	DryRun  bool       `json:"dryRun"`
+	Changes []lbChange `json:"changes"`
+	Error   string     `json:"error,omitempty"`
+}
+
+func newLBPlan(service string, dryRun bool) *lbPlan {
+	return &lbPlan{
+		Service: service,
+		DryRun:  dryRun,
+		Changes: []lbChange{},
+	}
+}
+
+// apply records the change and reports whether it has to be carried out.
+func (p *lbPlan) apply(c lbChange) bool {
+	p.Changes = append(p.Changes, c)
+	if p.DryRun {
+		klog.V(4).Infof("Dry-run for Service %s, skipping: %s", p.Service, c)
+	}
+	return !p.DryRun
+}
+
+// report returns the plan as JSON.
+func (p *lbPlan) report() string {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Sprintf("failed to marshal plan: %v", err)
+	}
+	return string(b)
+}
+
+// summary returns a short human readable description of the plan.
+func (p *lbPlan) summary() string {
+	var s string
+	switch len(p.Changes) {
+	case 0:
+		s = "no changes"
+	case 1:
+		s = "1 change"
+	default:
+		s = fmt.Sprintf("%d changes", len(p.Changes))
+	}
+
+	changes := make([]string, 0, lbPlanEventMaxChanges)
+	for i, c := range p.Changes {
+		if i == lbPlanEventMaxChanges {
+			changes = append(changes, "...")
+			break
+		}
+		changes = append(changes, c.String())
+	}
+	if len(changes) > 0 {
+		s += ": " + strings.Join(changes, "; ")
+	}
+	if p.Error != "" {
+		s += fmt.Sprintf(" (incomplete: %s)", p.Error)
+	}
+	return s
+}
+
+// isDryRun reports whether changes to the load balancer of the Service must
+// only be reported.
+func (lbaas *LbaasV2) isDryRun(service *v1.Service) (bool, error) {
+	return getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerDryRun, lbaas.opts.DryRun)
+}
+
+// reportPlan logs the plan of the operation and records it as an event on the Service.
+func (lbaas *LbaasV2) reportPlan(operation string, service *v1.Service, plan *lbPlan, err error) {
+	if err != nil {
+		plan.Error = err.Error()
+	}
+
+	klog.Infof("Dry-run of %s for Service %s: %s", operation, plan.Service, plan.report())
+
+	if lbaas.eventRecorder != nil {
+		lbaas.eventRecorder.Eventf(service, v1.EventTypeNormal, "LoadBalancerDryRun", "Planned load balancer changes: %s", plan.summary())
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestLBPlanApply(t *testing.T) {
+	change := lbChange{Action: lbActionCreate, Resource: lbResourceListener, Name: "listener_0_test"}
+
+	plan := newLBPlan("default/test", false)
+	if !plan.apply(change) {
+		t.Errorf("changes should be applied when not in dry-run mode")
+	}
+
+	dryRunPlan := newLBPlan("default/test", true)
+	if dryRunPlan.apply(change) {
+		t.Errorf("changes should not be applied in dry-run mode")
+	}
+
+	for _, p := range []*lbPlan{plan, dryRunPlan} {
+		if len(p.Changes) != 1 || p.Changes[0] != change {
+			t.Errorf("expected change %v to be recorded, got %v", change, p.Changes)
+		}
+	}
+}
+
+func TestLBPlanReport(t *testing.T) {
+	plan := newLBPlan("default/test", true)
+	if plan.summary() != "no changes" {
+		t.Errorf("unexpected summary for empty plan: %q", plan.summary())
+	}
+
+	for i := 0; i < lbPlanEventMaxChanges+2; i++ {
+		plan.apply(lbChange{Action: lbActionCreate, Resource: lbResourceMember, Name: fmt.Sprintf("member_%d", i)})
+	}
+	plan.Error = "timeout"
+
+	summary := plan.summary()
+	if !strings.HasPrefix(summary, fmt.Sprintf("%d changes: create member member_0;", lbPlanEventMaxChanges+2)) {
+		t.Errorf("unexpected summary: %q", summary)
+	}
+	if strings.Contains(summary, fmt.Sprintf("member_%d", lbPlanEventMaxChanges)) {
+		t.Errorf("summary should be truncated after %d changes: %q", lbPlanEventMaxChanges, summary)
+	}
+	if !strings.HasSuffix(summary, "(incomplete: timeout)") {
+		t.Errorf("summary should mention the error: %q", summary)
+	}
+
+	var decoded lbPlan
+	if err := json.Unmarshal([]byte(plan.report()), &decoded); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	if decoded.Service != "default/test" || !decoded.DryRun || len(decoded.Changes) != lbPlanEventMaxChanges+2 {
+		t.Errorf("unexpected decoded report: %+v", decoded)
+	}
+}
+
+func TestLBDryRun(t *testing.T) {
+	recorder := record.NewFakeRecorder(1)
+	lbaas := &LbaasV2{LoadBalancer{opts: LoadBalancerOpts{DryRun: false}, eventRecorder: recorder}}
+
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	if dryRun, err := lbaas.isDryRun(service); err != nil || dryRun {
+		t.Errorf("expected dry-run to be disabled, got %v, %v", dryRun, err)
+	}
+
+	service.Annotations = map[string]string{ServiceAnnotationLoadBalancerDryRun: "true"}
+	if dryRun, err := lbaas.isDryRun(service); err != nil || !dryRun {
+		t.Errorf("expected dry-run to be enabled by annotation, got %v, %v", dryRun, err)
+	}
+
+	service.Annotations[ServiceAnnotationLoadBalancerDryRun] = "maybe"
+	if _, err := lbaas.isDryRun(service); err == nil {
+		t.Errorf("expected error for invalid annotation value")
+	}
+
+	plan := newLBPlan("default/test", true)
+	plan.apply(lbChange{Action: lbActionDelete, Resource: lbResourceMonitor, ID: "monitor-id"})
+	lbaas.reportPlan("EnsureLoadBalancer", service, plan, nil)
+
+	event := <-recorder.Events
+	if !strings.Contains(event, "LoadBalancerDryRun") || !strings.Contains(event, "delete monitor (monitor-id)") {
+		t.Errorf("unexpected event: %q", event)
+	}
+}
+
+func TestEnsureLoadBalancerDeletedDryRun(t *testing.T) {
+	fake, srv, client := newFakeLBaaS(t)
+	defer srv.Close()
+
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "uid"}}
+	sgName := getSecurityGroupName(service)
+	fake.add("lbaas/loadbalancers", map[string]interface{}{"id": "lb", "name": "kube_service_kubernetes_default_web", "vip_port_id": "vip"})
+	fake.add("lbaas/listeners", map[string]interface{}{"id": "listener", "name": "listener_0_kube_service_kubernetes_default_web", "loadbalancer_id": "lb"})
+	fake.add("floatingips", map[string]interface{}{"id": "fip", "port_id": "vip"})
+	fake.add("security-groups", map[string]interface{}{"id": "sg", "name": sgName})
+	fake.add("ports", map[string]interface{}{"id": "node-port", "security_groups": []string{"node-sg", "sg"}, "tags": []string{"sg"}})
+	fake.add("security-group-rules", map[string]interface{}{"id": "rule", "security_group_id": "node-sg", "remote_group_id": "sg"})
+
+	lbaas := &LbaasV2{LoadBalancer{network: client, lb: client, opts: LoadBalancerOpts{
+		UseOctavia:           true,
+		ManageSecurityGroups: true,
+		NodeSecurityGroupIDs: []string{"node-sg"},
+		DryRun:               true,
+	}}}
+
+	if err := lbaas.EnsureLoadBalancerDeleted(context.TODO(), "kubernetes", service); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changes := fake.takeChanges(); len(changes) != 0 {
+		t.Errorf("expected no changes in dry-run mode, got %v", changes)
+	}
+
+	// The annotation overrides the configuration
+	service.Annotations = map[string]string{ServiceAnnotationLoadBalancerDryRun: "false"}
+	if err := lbaas.EnsureLoadBalancerDeleted(context.TODO(), "kubernetes", service); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		"delete floatingip fip",
+		"delete loadbalancer kube_service_kubernetes_default_web",
+		"update port node-port",
+		"untag port node-port sg",
+		"delete security_group " + sgName,
+		"delete security_group_rule rule",
+	}
+	if changes := fake.takeChanges(); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+}
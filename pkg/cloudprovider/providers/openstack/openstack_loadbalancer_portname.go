@@ -0,0 +1,202 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	v2pools "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	"k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// listenerSettings are the listener and pool protocols of a Service port.
+type listenerSettings struct {
+	protocol      listeners.Protocol
+	poolProtocol  v2pools.Protocol
+	insertHeaders map[string]string
+}
+
+// checkPortNameOpts validates the LoadBalancerPortName sections.
+func checkPortNameOpts(portNames map[string]*PortNameOpts) error {
+	for pattern, opts := range portNames {
+		for _, name := range []string{"", pattern} {
+			if _, err := path.Match(pattern, name); err != nil {
+				return fmt.Errorf("invalid LoadBalancerPortName pattern %q: %v", pattern, err)
+			}
+		}
+		switch listeners.Protocol(strings.ToUpper(opts.Protocol)) {
+		case "", listeners.ProtocolTCP, listeners.ProtocolHTTPS:
+			if len(opts.InsertHeaders) > 0 {
+				return fmt.Errorf("LoadBalancerPortName %q: insert-header needs protocol HTTP", pattern)
+			}
+		case listeners.ProtocolHTTP:
+		default:
+			return fmt.Errorf("LoadBalancerPortName %q: unsupported protocol %q, specify TCP, HTTP or HTTPS", pattern, opts.Protocol)
+		}
+		if opts.ProxyProtocol && len(opts.InsertHeaders) > 0 {
+			return fmt.Errorf("LoadBalancerPortName %q: proxy-protocol and insert-header cannot be used together", pattern)
+		}
+	}
+	return nil
+}
+
+// patternSpecificity is the number of characters a port name matching
+// pattern has to have literally, a character class counting as one.
+func patternSpecificity(pattern string) int {
+	n := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?':
+		case '[':
+			for i < len(pattern) && pattern[i] != ']' {
+				i++
+			}
+			n++
+		case '\\':
+			i++
+			n++
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+// matchPortName returns the pattern of portNames that applies to the port
+// name, "" if none matches. A pattern equal to the name comes first, then
+// the matching pattern with most literal characters. When several patterns
+// match equally, the first one in lexical order is used and all of them are
+// returned as ambiguous.
+func matchPortName(portNames map[string]*PortNameOpts, name string) (string, []string) {
+	if name == "" {
+		return "", nil
+	}
+	if _, ok := portNames[name]; ok {
+		return name, nil
+	}
+
+	var best []string
+	bestSpecificity := -1
+	for pattern := range portNames {
+		if ok, _ := path.Match(pattern, name); !ok {
+			continue
+		}
+		specificity := patternSpecificity(pattern)
+		switch {
+		case specificity > bestSpecificity:
+			best = []string{pattern}
+			bestSpecificity = specificity
+		case specificity == bestSpecificity:
+			best = append(best, pattern)
+		}
+	}
+	if len(best) == 0 {
+		return "", nil
+	}
+
+	sort.Strings(best)
+	if len(best) > 1 {
+		return best[0], best
+	}
+	return best[0], nil
+}
+
+// getListenerSettings returns the listener settings of a Service port. UDP
+// ports always get UDP listeners and pools. The x-forwarded-for and
+// proxy-protocol annotations take precedence, the
+// LoadBalancerPortName sections only apply when neither is set. When the
+// port name matches several patterns equally, they are returned as well.
+func getListenerSettings(service *v1.Service, port v1.ServicePort, portNames map[string]*PortNameOpts) (listenerSettings, []string, error) {
+	settings := listenerSettings{
+		protocol:     toListenersProtocol(port.Protocol),
+		poolProtocol: v2pools.ProtocolTCP,
+	}
+
+	// UDP listeners only forward datagrams, nothing else applies to them
+	if port.Protocol == v1.ProtocolUDP {
+		settings.poolProtocol = poolProtocolUDP
+		return settings, nil, nil
+	}
+
+	_, hasXForwardedFor := service.Annotations[ServiceAnnotationLoadBalancerXForwardedFor]
+	_, hasProxyProtocol := service.Annotations[ServiceAnnotationLoadBalancerProxyEnabled]
+	if hasXForwardedFor || hasProxyProtocol || port.Protocol != v1.ProtocolTCP {
+		keepClientIP, err := getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerXForwardedFor, false)
+		if err != nil {
+			return settings, nil, err
+		}
+		useProxyProtocol, err := getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerProxyEnabled, false)
+		if err != nil {
+			return settings, nil, err
+		}
+		if useProxyProtocol && keepClientIP {
+			return settings, nil, fmt.Errorf("annotation %s and %s cannot be used together", ServiceAnnotationLoadBalancerProxyEnabled, ServiceAnnotationLoadBalancerXForwardedFor)
+		}
+		if useProxyProtocol {
+			settings.poolProtocol = v2pools.ProtocolPROXY
+		} else if keepClientIP {
+			settings.protocol = listeners.ProtocolHTTP
+			settings.poolProtocol = v2pools.ProtocolHTTP
+			settings.insertHeaders = map[string]string{"X-Forwarded-For": "true"}
+		}
+		return settings, nil, nil
+	}
+
+	pattern, ambiguous := matchPortName(portNames, port.Name)
+	if pattern == "" {
+		return settings, nil, nil
+	}
+	opts := portNames[pattern]
+	klog.V(4).Infof("Port %s of Service %s/%s matches LoadBalancerPortName %q", port.Name, service.Namespace, service.Name, pattern)
+
+	if opts.Protocol != "" {
+		settings.protocol = listeners.Protocol(strings.ToUpper(opts.Protocol))
+		settings.poolProtocol = v2pools.Protocol(settings.protocol)
+	}
+	if opts.ProxyProtocol {
+		settings.poolProtocol = v2pools.ProtocolPROXY
+	}
+	if len(opts.InsertHeaders) > 0 {
+		settings.insertHeaders = make(map[string]string, len(opts.InsertHeaders))
+		for _, header := range opts.InsertHeaders {
+			settings.insertHeaders[header] = "true"
+		}
+	}
+	return settings, ambiguous, nil
+}
+
+// portListenerSettings returns the listener settings of a Service port and
+// records an event when its name matches several patterns equally.
+func (lbaas *LbaasV2) portListenerSettings(service *v1.Service, port v1.ServicePort) (listenerSettings, error) {
+	settings, ambiguous, err := getListenerSettings(service, port, lbaas.opts.PortNames)
+	if err != nil {
+		return settings, err
+	}
+	if len(ambiguous) > 0 {
+		msg := fmt.Sprintf("Port %s matches the LoadBalancerPortName patterns %s equally, using %q", port.Name, strings.Join(ambiguous, ", "), ambiguous[0])
+		klog.Warningf("Service %s/%s: %s", service.Namespace, service.Name, msg)
+		if lbaas.eventRecorder != nil {
+			lbaas.eventRecorder.Event(service, v1.EventTypeWarning, "AmbiguousPortName", msg)
+		}
+	}
+	return settings, nil
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	v2pools "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestReadConfigPortNames(t *testing.T) {
+	cfg, err := ReadConfig(strings.NewReader(`
+ [Global]
+ auth-url = http://auth.url
+ user-id = user
+ [LoadBalancerPortName "https"]
+ protocol = HTTPS
+ [LoadBalancerPortName "*-proxy"]
+ proxy-protocol = true
+ [LoadBalancerPortName "http*"]
+ protocol = HTTP
+ insert-header = X-Forwarded-For
+ insert-header = X-Forwarded-Port
+ `))
+	if err != nil {
+		t.Fatalf("Should succeed when a valid config is provided: %s", err)
+	}
+
+	expected := map[string]*PortNameOpts{
+		"https":   {Protocol: "HTTPS"},
+		"*-proxy": {ProxyProtocol: true},
+		"http*":   {Protocol: "HTTP", InsertHeaders: []string{"X-Forwarded-For", "X-Forwarded-Port"}},
+	}
+	if !reflect.DeepEqual(cfg.LoadBalancerPortName, expected) {
+		t.Errorf("unexpected port names: %+v", cfg.LoadBalancerPortName)
+	}
+	if err := checkPortNameOpts(cfg.LoadBalancerPortName); err != nil {
+		t.Errorf("valid port names should pass the checks: %v", err)
+	}
+}
+
+func TestCheckPortNameOpts(t *testing.T) {
+	tests := []struct {
+		name string
+		opts PortNameOpts
+	}{
+		{name: "[", opts: PortNameOpts{}},
+		{name: "udp", opts: PortNameOpts{Protocol: "UDP"}},
+		{name: "terminated", opts: PortNameOpts{Protocol: "TERMINATED_HTTPS"}},
+		{name: "tcp", opts: PortNameOpts{InsertHeaders: []string{"X-Forwarded-For"}}},
+		{name: "https", opts: PortNameOpts{Protocol: "HTTPS", InsertHeaders: []string{"X-Forwarded-For"}}},
+		{name: "http", opts: PortNameOpts{Protocol: "HTTP", ProxyProtocol: true, InsertHeaders: []string{"X-Forwarded-For"}}},
+	}
+
+	for _, test := range tests {
+		opts := test.opts
+		if err := checkPortNameOpts(map[string]*PortNameOpts{test.name: &opts}); err == nil {
+			t.Errorf("expected an error for %q: %+v", test.name, test.opts)
+		}
+	}
+}
+
+func TestMatchPortName(t *testing.T) {
+	portNames := map[string]*PortNameOpts{
+		"https":     {},
+		"http*":     {},
+		"*-proxy":   {},
+		"web-*":     {},
+		"web-proxy": {},
+		"a*":        {},
+		"*b":        {},
+		"[ab]-?":    {},
+	}
+
+	tests := []struct {
+		name      string
+		pattern   string
+		ambiguous []string
+	}{
+		// Without wildcards first, even when other patterns are longer
+		{name: "https", pattern: "https"},
+		{name: "web-proxy", pattern: "web-proxy"},
+		// Then the most literal characters
+		{name: "http-alt", pattern: "http*"},
+		{name: "api-proxy", pattern: "*-proxy"},
+		{name: "web-8080", pattern: "web-*"},
+		{name: "web--proxy", pattern: "*-proxy"},
+		// A character class counts as one literal character
+		{name: "a-1", pattern: "[ab]-?"},
+		// Equal ones are ambiguous, the first in lexical order is used
+		{name: "ab", pattern: "*b", ambiguous: []string{"*b", "a*"}},
+		// No match
+		{name: "metrics", pattern: ""},
+		{name: "", pattern: ""},
+	}
+
+	for _, test := range tests {
+		pattern, ambiguous := matchPortName(portNames, test.name)
+		if pattern != test.pattern || !reflect.DeepEqual(ambiguous, test.ambiguous) {
+			t.Errorf("%q: expected %q %v, got %q %v", test.name, test.pattern, test.ambiguous, pattern, ambiguous)
+		}
+	}
+}
+
+func TestGetListenerSettings(t *testing.T) {
+	portNames := map[string]*PortNameOpts{
+		"https":   {Protocol: "https"},
+		"*-proxy": {ProxyProtocol: true},
+		"http":    {Protocol: "HTTP", InsertHeaders: []string{"X-Forwarded-For", "X-Forwarded-Proto"}},
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		port        string
+		expected    listenerSettings
+	}{
+		{
+			name:     "no match",
+			port:     "metrics",
+			expected: listenerSettings{protocol: listeners.ProtocolTCP, poolProtocol: v2pools.ProtocolTCP},
+		},
+		{
+			name:     "https",
+			port:     "https",
+			expected: listenerSettings{protocol: listeners.ProtocolHTTPS, poolProtocol: v2pools.ProtocolHTTPS},
+		},
+		{
+			name:     "proxy protocol",
+			port:     "web-proxy",
+			expected: listenerSettings{protocol: listeners.ProtocolTCP, poolProtocol: v2pools.ProtocolPROXY},
+		},
+		{
+			name: "insert headers",
+			port: "http",
+			expected: listenerSettings{
+				protocol:      listeners.ProtocolHTTP,
+				poolProtocol:  v2pools.ProtocolHTTP,
+				insertHeaders: map[string]string{"X-Forwarded-For": "true", "X-Forwarded-Proto": "true"},
+			},
+		},
+		{
+			name:        "annotation overrides the port name",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerXForwardedFor: "true"},
+			port:        "web-proxy",
+			expected: listenerSettings{
+				protocol:      listeners.ProtocolHTTP,
+				poolProtocol:  v2pools.ProtocolHTTP,
+				insertHeaders: map[string]string{"X-Forwarded-For": "true"},
+			},
+		},
+		{
+			name:        "annotation set to false overrides the port name",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerProxyEnabled: "false"},
+			port:        "web-proxy",
+			expected:    listenerSettings{protocol: listeners.ProtocolTCP, poolProtocol: v2pools.ProtocolTCP},
+		},
+	}
+
+	for _, test := range tests {
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Annotations: test.annotations}}
+		port := v1.ServicePort{Name: test.port, Protocol: v1.ProtocolTCP, Port: 443}
+		settings, ambiguous, err := getListenerSettings(service, port, portNames)
+		if err != nil || ambiguous != nil {
+			t.Errorf("%s: unexpected error %v or ambiguous patterns %v", test.name, err, ambiguous)
+			continue
+		}
+		if !reflect.DeepEqual(settings, test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, settings)
+		}
+	}
+
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Annotations: map[string]string{
+		ServiceAnnotationLoadBalancerXForwardedFor: "true",
+		ServiceAnnotationLoadBalancerProxyEnabled:  "true",
+	}}}
+	if _, _, err := getListenerSettings(service, v1.ServicePort{Name: "http", Protocol: v1.ProtocolTCP}, portNames); err == nil {
+		t.Errorf("expected an error when both annotations are set")
+	}
+
+	// Neither the annotations nor the port names apply to UDP ports
+	settings, _, err := getListenerSettings(service, v1.ServicePort{Name: "web-proxy", Protocol: v1.ProtocolUDP, Port: 53}, portNames)
+	if expected := (listenerSettings{protocol: listenerProtocolUDP, poolProtocol: poolProtocolUDP}); err != nil || !reflect.DeepEqual(settings, expected) {
+		t.Errorf("UDP port: expected %+v, got %+v and error %v", expected, settings, err)
+	}
+}
+
+func TestPortListenerSettingsAmbiguous(t *testing.T) {
+	recorder := record.NewFakeRecorder(1)
+	lbaas := &LbaasV2{LoadBalancer{
+		opts: LoadBalancerOpts{PortNames: map[string]*PortNameOpts{
+			"web-*": {Protocol: "HTTP"},
+			"*-api": {ProxyProtocol: true},
+		}},
+		eventRecorder: recorder,
+	}}
+
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	settings, err := lbaas.portListenerSettings(service, v1.ServicePort{Name: "web-api", Protocol: v1.ProtocolTCP})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if settings.poolProtocol != v2pools.ProtocolPROXY {
+		t.Errorf("expected the first pattern in lexical order to be used, got %+v", settings)
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "AmbiguousPortName") || !strings.Contains(event, "*-api, web-*") {
+			t.Errorf("unexpected event: %s", event)
+		}
+	default:
+		t.Errorf("expected an event for the ambiguous port name")
+	}
+}
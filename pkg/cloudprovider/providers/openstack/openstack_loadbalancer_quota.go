@@ -0,0 +1,184 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	tokens3 "github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	v2monitors "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/monitors"
+	v2pools "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	"k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// lbQuota is the Octavia quota of a project, a negative limit means unlimited.
+type lbQuota struct {
+	LoadBalancer  int `json:"load_balancer"`
+	Listener      int `json:"listener"`
+	Pool          int `json:"pool"`
+	HealthMonitor int `json:"health_monitor"`
+}
+
+// lbQuotaCheck is the usage, planned objects and limit of one quota.
+type lbQuotaCheck struct {
+	resource string
+	limit    int
+	used     int
+	planned  int
+}
+
+func (c lbQuotaCheck) exceeded() bool {
+	return c.limit >= 0 && c.used+c.planned > c.limit
+}
+
+func getLoadBalancerQuota(client *gophercloud.ServiceClient, projectID string) (*lbQuota, error) {
+	var body struct {
+		Quota lbQuota `json:"quota"`
+	}
+	if _, err := client.Get(client.ServiceURL("lbaas", "quotas", projectID), &body, nil); err != nil {
+		return nil, err
+	}
+	return &body.Quota, nil
+}
+
+// getProjectID returns the project the cloud provider is authenticated in,
+// from the cloud config or else from the token.
+func (lbaas *LbaasV2) getProjectID() (string, error) {
+	lbaas.state.mu.Lock()
+	projectID := lbaas.state.projectID
+	lbaas.state.mu.Unlock()
+	if projectID != "" {
+		return projectID, nil
+	}
+
+	identity, err := openstack.NewIdentityV3(lbaas.lb.ProviderClient, gophercloud.EndpointOpts{})
+	if err != nil {
+		return "", err
+	}
+	project, err := tokens3.Get(identity, lbaas.lb.ProviderClient.Token()).ExtractProject()
+	if err != nil {
+		return "", err
+	}
+	if project == nil {
+		return "", fmt.Errorf("token is not scoped to a project")
+	}
+	lbaas.state.mu.Lock()
+	lbaas.state.projectID = project.ID
+	lbaas.state.mu.Unlock()
+	return project.ID, nil
+}
+
+// getLoadBalancerQuotaChecks returns the quota checks for creating the
+// load balancer of a Service with the given number of ports, monitored or not.
+func (lbaas *LbaasV2) getLoadBalancerQuotaChecks(projectID string, ports int, monitored bool) ([]lbQuotaCheck, error) {
+	quota, err := getLoadBalancerQuota(lbaas.lb, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	lbs, err := getLoadBalancers(lbaas.lb, loadbalancers.ListOpts{ProjectID: projectID})
+	if err != nil {
+		return nil, err
+	}
+	listenerPages, err := listeners.List(lbaas.lb, listeners.ListOpts{ProjectID: projectID}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allListeners, err := listeners.ExtractListeners(listenerPages)
+	if err != nil {
+		return nil, err
+	}
+	poolPages, err := v2pools.List(lbaas.lb, v2pools.ListOpts{ProjectID: projectID}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allPools, err := v2pools.ExtractPools(poolPages)
+	if err != nil {
+		return nil, err
+	}
+	monitorPages, err := v2monitors.List(lbaas.lb, v2monitors.ListOpts{ProjectID: projectID}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allMonitors, err := v2monitors.ExtractMonitors(monitorPages)
+	if err != nil {
+		return nil, err
+	}
+
+	monitors := 0
+	if monitored {
+		monitors = ports
+	}
+
+	checks := []lbQuotaCheck{
+		{resource: "loadbalancer", limit: quota.LoadBalancer, used: len(lbs), planned: 1},
+		{resource: "listener", limit: quota.Listener, used: len(allListeners), planned: ports},
+		{resource: "pool", limit: quota.Pool, used: len(allPools), planned: ports},
+		{resource: "healthmonitor", limit: quota.HealthMonitor, used: len(allMonitors), planned: monitors},
+	}
+	for _, c := range checks {
+		openstackLoadBalancerQuota.WithLabelValues(c.resource).Set(float64(c.limit))
+		openstackLoadBalancerQuotaUsage.WithLabelValues(c.resource).Set(float64(c.used))
+	}
+	return checks, nil
+}
+
+// checkLoadBalancerQuota fails when creating the load balancer of the
+// Service would exceed the Octavia quota. Nothing is checked when the quota
+// cannot be read, for example because the policy does not allow it.
+func (lbaas *LbaasV2) checkLoadBalancerQuota(service *v1.Service, ports int) error {
+	if !lbaas.opts.UseOctavia {
+		return nil
+	}
+
+	projectID, err := lbaas.getProjectID()
+	if err != nil {
+		klog.V(3).Infof("Skipping loadbalancer quota check, failed to get project ID: %v", err)
+		return nil
+	}
+	monitored, err := lbaas.isHealthMonitorEnabled(service)
+	if err != nil {
+		return err
+	}
+	checks, err := lbaas.getLoadBalancerQuotaChecks(projectID, ports, monitored)
+	if err != nil {
+		klog.V(3).Infof("Skipping loadbalancer quota check, failed to get quota of project %s: %v", projectID, err)
+		return nil
+	}
+
+	var exceeded []string
+	for _, c := range checks {
+		if c.exceeded() {
+			exceeded = append(exceeded, fmt.Sprintf("%s (limit %d, used %d, needed %d)", c.resource, c.limit, c.used, c.planned))
+		}
+	}
+	if len(exceeded) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("Octavia quota exhausted in project %s: %s", projectID, strings.Join(exceeded, ", "))
+	if lbaas.eventRecorder != nil {
+		lbaas.eventRecorder.Event(service, v1.EventTypeWarning, "LoadBalancerQuotaExceeded", msg)
+	}
+	return fmt.Errorf("%s", msg)
+}
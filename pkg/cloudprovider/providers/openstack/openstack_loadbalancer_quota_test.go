@@ -0,0 +1,154 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestLBQuotaCheckExceeded(t *testing.T) {
+	tests := []struct {
+		check    lbQuotaCheck
+		exceeded bool
+	}{
+		{check: lbQuotaCheck{resource: "loadbalancer", limit: -1, used: 100, planned: 1}, exceeded: false},
+		{check: lbQuotaCheck{resource: "loadbalancer", limit: 10, used: 9, planned: 1}, exceeded: false},
+		{check: lbQuotaCheck{resource: "loadbalancer", limit: 10, used: 10, planned: 1}, exceeded: true},
+		{check: lbQuotaCheck{resource: "listener", limit: 10, used: 8, planned: 3}, exceeded: true},
+		{check: lbQuotaCheck{resource: "healthmonitor", limit: 0, used: 0, planned: 0}, exceeded: false},
+		{check: lbQuotaCheck{resource: "healthmonitor", limit: 0, used: 0, planned: 1}, exceeded: true},
+	}
+
+	for _, test := range tests {
+		if test.check.exceeded() != test.exceeded {
+			t.Errorf("expected exceeded %v for %+v", test.exceeded, test.check)
+		}
+	}
+}
+
+func TestLBQuotaCheckSkippedWithoutOctavia(t *testing.T) {
+	lbaas := &LbaasV2{LoadBalancer{opts: LoadBalancerOpts{UseOctavia: false}}}
+	if err := lbaas.checkLoadBalancerQuota(&v1.Service{}, 1); err != nil {
+		t.Errorf("expected no quota check without Octavia, got %v", err)
+	}
+}
+
+func TestGetLoadBalancerQuotaChecks(t *testing.T) {
+	fake, srv, client := newFakeLBaaS(t)
+	defer srv.Close()
+
+	fake.add("lbaas/quotas", map[string]interface{}{"id": "project", "load_balancer": 10, "listener": -1, "pool": 5, "health_monitor": 2})
+	fake.add("lbaas/loadbalancers", map[string]interface{}{"id": "lb-1", "project_id": "project"})
+	fake.add("lbaas/loadbalancers", map[string]interface{}{"id": "lb-2", "project_id": "other"})
+	fake.add("lbaas/listeners", map[string]interface{}{"id": "listener-1", "loadbalancer_id": "lb-1", "project_id": "project"})
+	fake.add("lbaas/listeners", map[string]interface{}{"id": "listener-2", "loadbalancer_id": "lb-1", "project_id": "project"})
+	fake.add("lbaas/pools", map[string]interface{}{"id": "pool-1", "listener_id": "listener-1", "project_id": "project"})
+	fake.add("lbaas/healthmonitors", map[string]interface{}{"id": "monitor-1", "project_id": "project"})
+
+	lbaas := &LbaasV2{LoadBalancer{lb: client, opts: LoadBalancerOpts{UseOctavia: true}}}
+	checks, err := lbaas.getLoadBalancerQuotaChecks("project", 2, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []lbQuotaCheck{
+		{resource: "loadbalancer", limit: 10, used: 1, planned: 1},
+		{resource: "listener", limit: -1, used: 2, planned: 2},
+		{resource: "pool", limit: 5, used: 1, planned: 2},
+		{resource: "healthmonitor", limit: 2, used: 1, planned: 2},
+	}
+	if !reflect.DeepEqual(checks, expected) {
+		t.Errorf("expected checks %+v, got %+v", expected, checks)
+	}
+
+	checks, err = lbaas.getLoadBalancerQuotaChecks("project", 2, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if checks[3].planned != 0 {
+		t.Errorf("expected no planned health monitors without monitoring, got %+v", checks[3])
+	}
+}
+
+func TestEnsureLoadBalancerQuotaExceeded(t *testing.T) {
+	fake, srv, client := newFakeLBaaS(t)
+	defer srv.Close()
+
+	fake.add("lbaas/quotas", map[string]interface{}{"id": "project", "load_balancer": 1, "listener": -1, "pool": -1, "health_monitor": -1})
+	fake.add("lbaas/loadbalancers", map[string]interface{}{"id": "lb-1", "name": "other", "project_id": "project"})
+
+	recorder := record.NewFakeRecorder(1)
+	lbaas := &LbaasV2{LoadBalancer{network: client, compute: client, lb: client, eventRecorder: recorder,
+		opts:  LoadBalancerOpts{UseOctavia: true, SubnetID: "subnet", FloatingNetworkID: "public"},
+		state: &lbState{projectID: "project"},
+	}}
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "uid"},
+		Spec: v1.ServiceSpec{
+			Ports:           []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}},
+			SessionAffinity: v1.ServiceAffinityNone,
+		},
+	}
+	nodes := []*v1.Node{{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}}},
+	}}
+
+	_, err := lbaas.EnsureLoadBalancer(context.TODO(), "kubernetes", service, nodes)
+	if err == nil || !strings.Contains(err.Error(), "loadbalancer (limit 1, used 1, needed 1)") {
+		t.Errorf("expected the loadbalancer quota to be exhausted, got %v", err)
+	}
+	if changes := fake.takeChanges(); len(changes) != 0 {
+		t.Errorf("expected nothing to be created, got %v", changes)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "LoadBalancerQuotaExceeded") || !strings.Contains(event, "loadbalancer (limit 1") {
+			t.Errorf("unexpected event: %q", event)
+		}
+	default:
+		t.Errorf("expected an event naming the exhausted quota")
+	}
+}
+
+func TestLBQuotaCheckSkippedWhenForbidden(t *testing.T) {
+	fake, srv, client := newFakeLBaaS(t)
+	defer srv.Close()
+
+	fake.add("lbaas/loadbalancers", map[string]interface{}{"id": "lb-1", "project_id": "project"})
+	fake.forbid("lbaas/quotas/project")
+
+	recorder := record.NewFakeRecorder(1)
+	lbaas := &LbaasV2{LoadBalancer{lb: client, eventRecorder: recorder,
+		opts:  LoadBalancerOpts{UseOctavia: true},
+		state: &lbState{projectID: "project"},
+	}}
+	if err := lbaas.checkLoadBalancerQuota(&v1.Service{}, 1); err != nil {
+		t.Errorf("expected the quota check to be skipped, got %v", err)
+	}
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("unexpected event: %q", event)
+	default:
+	}
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/rules"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+// managedRuleDescription tags the description of the security group rules
+// created by the provider. Only the tagged rules, and those from the security
+// group of the load balancer in a node security group, are ever deleted by a
+// reconcile, the rules added by hand are left alone.
+const managedRuleDescription = "kubernetes-cloud-provider-openstack"
+
+// secGroupRuleKey is the normalized form of a security group rule, two rules
+// with the same key let the same traffic through.
+type secGroupRuleKey struct {
+	direction      string
+	etherType      string
+	protocol       string
+	portRangeMin   int
+	portRangeMax   int
+	remoteIPPrefix string
+	remoteGroupID  string
+}
+
+func (k secGroupRuleKey) String() string {
+	s := fmt.Sprintf("%s %s", k.direction, k.etherType)
+	if k.protocol == "" {
+		s += " any"
+	} else {
+		s += " " + k.protocol
+	}
+	switch {
+	case isICMPProtocol(k.protocol) && k.portRangeMin != 0:
+		s += fmt.Sprintf(" type %d", k.portRangeMin)
+		if k.portRangeMax != 0 {
+			s += fmt.Sprintf(" code %d", k.portRangeMax)
+		}
+	case k.portRangeMin != 0 && k.portRangeMin == k.portRangeMax:
+		s += fmt.Sprintf(" port %d", k.portRangeMin)
+	case k.portRangeMin != 0:
+		s += fmt.Sprintf(" ports %d-%d", k.portRangeMin, k.portRangeMax)
+	}
+	if k.remoteGroupID != "" {
+		return s + " from group " + k.remoteGroupID
+	}
+	return s + " from " + k.remoteIPPrefix
+}
+
+func isICMPProtocol(protocol string) bool {
+	return protocol == string(rules.ProtocolICMP) || protocol == "ipv6-icmp"
+}
+
+// normalizeRuleProtocol returns the name Neutron stores for protocol. The
+// IANA numbers and the ICMPv6 aliases are accepted by the API as well.
+func normalizeRuleProtocol(protocol, etherType string) string {
+	protocol = strings.ToLower(protocol)
+	switch protocol {
+	case "", "any":
+		return ""
+	case "6":
+		return string(rules.ProtocolTCP)
+	case "17":
+		return string(rules.ProtocolUDP)
+	case "58", "icmpv6", "ipv6-icmp":
+		return "ipv6-icmp"
+	case "1", "icmp":
+		// Neutron matches ICMPv6 for an IPv6 rule of the ICMP protocol
+		if etherType == string(rules.EtherType6) {
+			return "ipv6-icmp"
+		}
+		return string(rules.ProtocolICMP)
+	}
+	return protocol
+}
+
+// normalizeRemoteIPPrefix returns the canonical CIDR of prefix, an empty
+// prefix being the whole address family of etherType.
+func normalizeRemoteIPPrefix(prefix, etherType string) string {
+	if prefix == "" {
+		if etherType == string(rules.EtherType6) {
+			return "::/0"
+		}
+		return "0.0.0.0/0"
+	}
+	if !strings.Contains(prefix, "/") {
+		if ip := net.ParseIP(prefix); ip != nil {
+			if ip.To4() != nil {
+				return ip.String() + "/32"
+			}
+			return ip.String() + "/128"
+		}
+		return prefix
+	}
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return prefix
+	}
+	return network.String()
+}
+
+// newSecGroupRuleKey returns the normalized form of a security group rule
+// with the defaults of Neutron filled in.
+func newSecGroupRuleKey(rule rules.SecGroupRule) secGroupRuleKey {
+	k := secGroupRuleKey{
+		direction:     strings.ToLower(rule.Direction),
+		etherType:     rule.EtherType,
+		portRangeMin:  rule.PortRangeMin,
+		portRangeMax:  rule.PortRangeMax,
+		remoteGroupID: rule.RemoteGroupID,
+	}
+	if k.etherType == "" {
+		k.etherType = string(rules.EtherType4)
+		if ip, _, err := net.ParseCIDR(rule.RemoteIPPrefix); err == nil && ip.To4() == nil {
+			k.etherType = string(rules.EtherType6)
+		}
+	}
+	k.protocol = normalizeRuleProtocol(rule.Protocol, k.etherType)
+
+	switch {
+	case k.protocol == "":
+		// Without a protocol the rule applies to all the ports
+		k.portRangeMin, k.portRangeMax = 0, 0
+	case isICMPProtocol(k.protocol):
+		// The range is the ICMP type and code, there is no code without a
+		// type
+		if k.portRangeMin == 0 {
+			k.portRangeMax = 0
+		}
+	default:
+		if k.portRangeMin == 0 {
+			k.portRangeMin = k.portRangeMax
+		}
+		if k.portRangeMax == 0 {
+			k.portRangeMax = k.portRangeMin
+		}
+		if k.portRangeMin <= 1 && k.portRangeMax == 65535 {
+			k.portRangeMin, k.portRangeMax = 0, 0
+		}
+	}
+
+	// A rule matches either a remote group or a remote prefix
+	if k.remoteGroupID == "" {
+		k.remoteIPPrefix = normalizeRemoteIPPrefix(rule.RemoteIPPrefix, k.etherType)
+	}
+	return k
+}
+
+// secGroupRuleKeyFromOpts returns the normalized form of the rule created
+// with opts.
+func secGroupRuleKeyFromOpts(opts rules.CreateOpts) secGroupRuleKey {
+	return newSecGroupRuleKey(rules.SecGroupRule{
+		Direction:      string(opts.Direction),
+		EtherType:      string(opts.EtherType),
+		Protocol:       string(opts.Protocol),
+		PortRangeMin:   opts.PortRangeMin,
+		PortRangeMax:   opts.PortRangeMax,
+		RemoteIPPrefix: opts.RemoteIPPrefix,
+		RemoteGroupID:  opts.RemoteGroupID,
+	})
+}
+
+// isManagedSecGroupRule returns whether rule was created by the provider. The
+// rules from ownerGroupID, the security group of a load balancer, are managed
+// as well when it is not "": they were created without a description before
+// the rules were tagged.
+func isManagedSecGroupRule(rule rules.SecGroupRule, ownerGroupID string) bool {
+	return rule.Description == managedRuleDescription || (ownerGroupID != "" && rule.RemoteGroupID == ownerGroupID)
+}
+
+// diffSecGroupRules compares the desired rules to the existing ones on their
+// normalized form. It returns the desired rules missing, and the managed
+// rules that are not desired anymore or duplicate another rule, both sorted
+// by key so that the changes are reported in the same order every time.
+func diffSecGroupRules(desired []rules.CreateOpts, existing []rules.SecGroupRule, ownerGroupID string) ([]rules.CreateOpts, []rules.SecGroupRule) {
+	want := make(map[secGroupRuleKey]bool, len(desired))
+	for _, opts := range desired {
+		want[secGroupRuleKeyFromOpts(opts)] = true
+	}
+
+	// Keep the unmanaged rules first, a managed duplicate of a rule added by
+	// hand is not needed
+	sorted := make([]rules.SecGroupRule, len(existing))
+	copy(sorted, existing)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return !isManagedSecGroupRule(sorted[i], ownerGroupID) && isManagedSecGroupRule(sorted[j], ownerGroupID)
+	})
+	have := make(map[secGroupRuleKey]bool, len(existing))
+	var remove []rules.SecGroupRule
+	for _, rule := range sorted {
+		k := newSecGroupRuleKey(rule)
+		if isManagedSecGroupRule(rule, ownerGroupID) && (!want[k] || have[k]) {
+			remove = append(remove, rule)
+			continue
+		}
+		have[k] = true
+	}
+
+	var create []rules.CreateOpts
+	for _, opts := range desired {
+		k := secGroupRuleKeyFromOpts(opts)
+		if have[k] {
+			continue
+		}
+		// Only create the first of duplicate desired rules
+		have[k] = true
+		create = append(create, opts)
+	}
+
+	sort.SliceStable(create, func(i, j int) bool {
+		return secGroupRuleKeyFromOpts(create[i]).String() < secGroupRuleKeyFromOpts(create[j]).String()
+	})
+	sort.SliceStable(remove, func(i, j int) bool {
+		ki, kj := newSecGroupRuleKey(remove[i]).String(), newSecGroupRuleKey(remove[j]).String()
+		if ki != kj {
+			return ki < kj
+		}
+		return remove[i].ID < remove[j].ID
+	})
+	return create, remove
+}
+
+// reconcileSecGroupRules makes the existing rules of a security group the
+// desired ones. It creates the missing rules, tagged as managed, and deletes
+// the managed rules that are not desired anymore, leaving the rules that only
+// differ in their representation in place. The rules from ownerGroupID are
+// managed too, see isManagedSecGroupRule. It returns whether any change was
+// made.
+func reconcileSecGroupRules(client *gophercloud.ServiceClient, secGroupID, secGroupName string, existing []rules.SecGroupRule, desired []rules.CreateOpts, ownerGroupID string, plan *lbPlan) (bool, error) {
+	create, remove := diffSecGroupRules(desired, existing, ownerGroupID)
+	for _, rule := range remove {
+		if !plan.apply(lbChange{Action: lbActionDelete, Resource: lbResourceSecurityGroupRule, ID: rule.ID, Detail: fmt.Sprintf("%s in %s", newSecGroupRuleKey(rule), secGroupName)}) {
+			continue
+		}
+		if err := rules.Delete(client, rule.ID).ExtractErr(); err != nil && !cpoerrors.IsNotFound(err) {
+			return false, fmt.Errorf("failed to delete rule %s of security group %s: %v", rule.ID, secGroupID, err)
+		}
+	}
+	for _, opts := range create {
+		if !plan.apply(lbChange{Action: lbActionCreate, Resource: lbResourceSecurityGroupRule, Detail: fmt.Sprintf("%s in %s", secGroupRuleKeyFromOpts(opts), secGroupName)}) {
+			continue
+		}
+		opts.SecGroupID = secGroupID
+		opts.Description = managedRuleDescription
+		if _, err := rules.Create(client, opts).Extract(); err != nil {
+			return false, fmt.Errorf("failed to create rule for security group %s: %v", secGroupID, err)
+		}
+	}
+	return len(create) > 0 || len(remove) > 0, nil
+}
@@ -0,0 +1,432 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/rules"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSecGroupRuleKeyEquality(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  rules.SecGroupRule
+		equal bool
+	}{
+		{
+			name:  "empty remote prefix is the whole IPv4 space",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteIPPrefix: "0.0.0.0/0"},
+			equal: true,
+		},
+		{
+			name:  "empty remote prefix is the whole IPv6 space",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv6", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv6", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteIPPrefix: "::/0"},
+			equal: true,
+		},
+		{
+			name:  "empty remote prefix of IPv6 is not the whole IPv4 space",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv6", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv6", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteIPPrefix: "0.0.0.0/0"},
+			equal: false,
+		},
+		{
+			name:  "host bits of the remote prefix",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteIPPrefix: "10.0.0.1/8"},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteIPPrefix: "10.0.0.0/8"},
+			equal: true,
+		},
+		{
+			name:  "remote address without prefix length",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteIPPrefix: "192.0.2.1"},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteIPPrefix: "192.0.2.1/32"},
+			equal: true,
+		},
+		{
+			name:  "IPv6 remote prefix notation",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv6", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteIPPrefix: "2001:DB8:0:0::/32"},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv6", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteIPPrefix: "2001:db8::/32"},
+			equal: true,
+		},
+		{
+			name:  "different remote prefixes",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteIPPrefix: "10.0.0.0/8"},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteIPPrefix: "10.0.0.0/16"},
+			equal: false,
+		},
+		{
+			name:  "ethertype defaults to IPv4",
+			a:     rules.SecGroupRule{Direction: "ingress", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80},
+			equal: true,
+		},
+		{
+			name:  "ethertype defaults to the family of the remote prefix",
+			a:     rules.SecGroupRule{Direction: "ingress", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteIPPrefix: "2001:db8::/32"},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv6", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteIPPrefix: "2001:db8::/32"},
+			equal: true,
+		},
+		{
+			name:  "different ethertypes",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteGroupID: "lb"},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv6", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteGroupID: "lb"},
+			equal: false,
+		},
+		{
+			name:  "protocol case",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "TCP", PortRangeMin: 80, PortRangeMax: 80},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80},
+			equal: true,
+		},
+		{
+			name:  "protocol number",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "17", PortRangeMin: 53, PortRangeMax: 53},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "udp", PortRangeMin: 53, PortRangeMax: 53},
+			equal: true,
+		},
+		{
+			name:  "different protocols",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 53, PortRangeMax: 53},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "udp", PortRangeMin: 53, PortRangeMax: 53},
+			equal: false,
+		},
+		{
+			name:  "ports are ignored without a protocol",
+			a:     rules.SecGroupRule{Direction: "egress", EtherType: "IPv4", PortRangeMin: 80, PortRangeMax: 80},
+			b:     rules.SecGroupRule{Direction: "egress", EtherType: "IPv4"},
+			equal: true,
+		},
+		{
+			name:  "full port range is all the ports",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 1, PortRangeMax: 65535},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp"},
+			equal: true,
+		},
+		{
+			name:  "single port with one bound of the range",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80},
+			equal: true,
+		},
+		{
+			name:  "port is not a port range",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 81},
+			equal: false,
+		},
+		{
+			name:  "ICMP type and code",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "icmp", PortRangeMin: 3, PortRangeMax: 4},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "1", PortRangeMin: 3, PortRangeMax: 4, RemoteIPPrefix: "0.0.0.0/0"},
+			equal: true,
+		},
+		{
+			name:  "ICMP code is not a port range",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "icmp", PortRangeMin: 3, PortRangeMax: 4},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "icmp", PortRangeMin: 3, PortRangeMax: 3},
+			equal: false,
+		},
+		{
+			name:  "ICMP type without code is any code",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "icmp", PortRangeMin: 3},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "icmp", PortRangeMin: 3, PortRangeMax: 4},
+			equal: false,
+		},
+		{
+			name:  "ICMP code without type",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "icmp", PortRangeMax: 4},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "icmp"},
+			equal: true,
+		},
+		{
+			name:  "ICMP of IPv6 is ICMPv6",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv6", Protocol: "icmp", PortRangeMin: 2},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv6", Protocol: "ipv6-icmp", PortRangeMin: 2, RemoteIPPrefix: "::/0"},
+			equal: true,
+		},
+		{
+			name:  "ICMPv6 aliases",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv6", Protocol: "icmpv6", PortRangeMin: 2},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv6", Protocol: "58", PortRangeMin: 2},
+			equal: true,
+		},
+		{
+			name:  "remote group instead of remote prefix",
+			a:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 30080, PortRangeMax: 30080, RemoteGroupID: "lb"},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 30080, PortRangeMax: 30080},
+			equal: false,
+		},
+		{
+			name:  "direction case",
+			a:     rules.SecGroupRule{Direction: "INGRESS", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80},
+			equal: true,
+		},
+		{
+			name:  "different directions",
+			a:     rules.SecGroupRule{Direction: "egress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80},
+			b:     rules.SecGroupRule{Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80},
+			equal: false,
+		},
+		{
+			name:  "description and IDs are not compared",
+			a:     rules.SecGroupRule{ID: "1", SecGroupID: "sg", Description: managedRuleDescription, Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80},
+			b:     rules.SecGroupRule{ID: "2", Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80},
+			equal: true,
+		},
+	}
+
+	for _, test := range tests {
+		a, b := newSecGroupRuleKey(test.a), newSecGroupRuleKey(test.b)
+		if (a == b) != test.equal {
+			t.Errorf("%s: expected equal %t, got %q and %q", test.name, test.equal, a, b)
+		}
+	}
+}
+
+func TestDiffSecGroupRules(t *testing.T) {
+	desired, err := lbSecurityGroupRules([]v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 443}, {Protocol: v1.ProtocolTCP, Port: 80}}, []string{"10.0.0.0/8", "0.0.0.0/0"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Neutron returns the rules normalized, without a remote prefix for the
+	// whole address space
+	var existing []rules.SecGroupRule
+	for i, opts := range desired {
+		rule := rules.SecGroupRule{
+			ID:             fmt.Sprintf("rule-%d", i),
+			Description:    managedRuleDescription,
+			Direction:      string(opts.Direction),
+			EtherType:      string(opts.EtherType),
+			Protocol:       string(opts.Protocol),
+			PortRangeMin:   opts.PortRangeMin,
+			PortRangeMax:   opts.PortRangeMax,
+			RemoteIPPrefix: opts.RemoteIPPrefix,
+		}
+		if rule.RemoteIPPrefix == "0.0.0.0/0" || rule.RemoteIPPrefix == "::/0" {
+			rule.RemoteIPPrefix = ""
+		}
+		existing = append(existing, rule)
+	}
+	// The default egress rules of Neutron and a rule added by hand
+	existing = append(existing,
+		rules.SecGroupRule{ID: "egress-4", Direction: "egress", EtherType: "IPv4"},
+		rules.SecGroupRule{ID: "egress-6", Direction: "egress", EtherType: "IPv6"},
+		rules.SecGroupRule{ID: "manual", Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 22, PortRangeMax: 22},
+	)
+
+	create, remove := diffSecGroupRules(desired, existing, "")
+	if len(create) != 0 || len(remove) != 0 {
+		t.Errorf("expected no changes, got create %v and remove %v", create, remove)
+	}
+
+	// Removing a port and a source range only removes their managed rules,
+	// adding a source range only adds its rules
+	desired, err = lbSecurityGroupRules([]v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 443}}, []string{"0.0.0.0/0", "192.0.2.0/24"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	create, remove = diffSecGroupRules(desired, existing, "")
+	var created, removed []string
+	for _, opts := range create {
+		created = append(created, secGroupRuleKeyFromOpts(opts).String())
+	}
+	for _, rule := range remove {
+		removed = append(removed, rule.ID)
+	}
+	if strings.Join(created, ",") != "ingress IPv4 tcp port 443 from 192.0.2.0/24" {
+		t.Errorf("unexpected rules created: %v", created)
+	}
+	// Sorted by key
+	if strings.Join(removed, ",") != "rule-0,rule-3,rule-2" {
+		t.Errorf("unexpected rules removed: %v", removed)
+	}
+
+	// Duplicates of managed rules are removed, also when the rule exists
+	// unmanaged
+	existing = []rules.SecGroupRule{
+		{ID: "b", Description: managedRuleDescription, Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 443, PortRangeMax: 443},
+		{ID: "a", Description: managedRuleDescription, Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 443, PortRangeMax: 443, RemoteIPPrefix: "0.0.0.0/0"},
+		{ID: "manual", Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 443, PortRangeMax: 443, RemoteIPPrefix: "192.0.2.0/24"},
+		{ID: "c", Description: managedRuleDescription, Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 443, PortRangeMax: 443, RemoteIPPrefix: "192.0.2.0/24"},
+	}
+	create, remove = diffSecGroupRules(desired[:2], existing, "")
+	removed = nil
+	for _, rule := range remove {
+		removed = append(removed, rule.ID)
+	}
+	if len(create) != 0 || strings.Join(removed, ",") != "a,c" {
+		t.Errorf("expected the duplicates a and c to be removed, got create %v and remove %v", create, removed)
+	}
+}
+
+func TestReconcileSecGroupRules(t *testing.T) {
+	var requests []string
+	var description string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPost:
+			var body struct {
+				Rule map[string]interface{} `json:"security_group_rule"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("failed to decode the request: %v", err)
+			}
+			description, _ = body.Rule["description"].(string)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"security_group_rule": {"id": "new"}}`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	existing := []rules.SecGroupRule{
+		{ID: "manual", Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 80, PortRangeMax: 80, RemoteGroupID: "lb"},
+		{ID: "stale", Description: managedRuleDescription, Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 30081, PortRangeMax: 30081, RemoteGroupID: "lb"},
+		{ID: "kept", Description: managedRuleDescription, Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 30080, PortRangeMax: 30080, RemoteGroupID: "lb"},
+	}
+	desired := nodeSecurityGroupRules(30080, v1.ProtocolTCP, "lb")
+
+	plan := newLBPlan("default/svc", true)
+	changed, err := reconcileSecGroupRules(newPagedClient(srv), "node", "node security group", existing, desired, "", plan)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !changed || len(requests) != 0 || len(plan.Changes) != 2 {
+		t.Errorf("expected 2 changes to be planned without requests, got %v and requests %v", plan.Changes, requests)
+	}
+
+	plan = newLBPlan("default/svc", false)
+	if _, err := reconcileSecGroupRules(newPagedClient(srv), "node", "node security group", existing, desired, "", plan); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(requests, ",") != "DELETE /security-group-rules/stale,POST /security-group-rules" {
+		t.Errorf("unexpected requests %v", requests)
+	}
+	if description != managedRuleDescription {
+		t.Errorf("expected the rule to be created with the description %q, got %q", managedRuleDescription, description)
+	}
+}
+
+func TestEnsureNodeSecurityGroupRulesLegacy(t *testing.T) {
+	fake, srv, client := newFakeLBaaS(t)
+	defer srv.Close()
+
+	// The rules created before the rules were tagged have no description,
+	// only those from the security group of the load balancer are its own
+	for _, rule := range []rules.SecGroupRule{
+		{ID: "kept-4", SecGroupID: "node", Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 30080, PortRangeMax: 30080, RemoteGroupID: "lb"},
+		{ID: "kept-6", SecGroupID: "node", Direction: "ingress", EtherType: "IPv6", Protocol: "tcp", PortRangeMin: 30080, PortRangeMax: 30080, RemoteGroupID: "lb"},
+		{ID: "stale-4", SecGroupID: "node", Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 30081, PortRangeMax: 30081, RemoteGroupID: "lb"},
+		{ID: "stale-6", SecGroupID: "node", Direction: "ingress", EtherType: "IPv6", Protocol: "tcp", PortRangeMin: 30081, PortRangeMax: 30081, RemoteGroupID: "lb"},
+		{ID: "other-lb", SecGroupID: "node", Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 30081, PortRangeMax: 30081, RemoteGroupID: "other"},
+		{ID: "manual", SecGroupID: "node", Direction: "ingress", EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 22, PortRangeMax: 22},
+	} {
+		fake.add("security-group-rules", rule)
+	}
+	ports := []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}}
+
+	if err := ensureNodeSecurityGroupRules(client, "node", "lb", "lb-sg", ports, newLBPlan("default/svc", false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changes := strings.Join(fake.takeChanges(), ","); changes != "delete security_group_rule stale-4,delete security_group_rule stale-6" {
+		t.Errorf("expected the stale legacy rules to be deleted, got %v", changes)
+	}
+
+	// Without ports, e.g. when the node security group is not configured
+	// anymore, all the rules of the load balancer are deleted
+	if err := ensureNodeSecurityGroupRules(client, "node", "lb", "lb-sg", nil, newLBPlan("default/svc", false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ids := strings.Join(fake.ids("security-group-rules"), ","); ids != "manual,other-lb" {
+		t.Errorf("expected only the rules of others to be left, got %v", ids)
+	}
+}
+
+func TestEnsureLoadBalancerDeletedNodeSecurityGroupRules(t *testing.T) {
+	fake, srv, client := newFakeLBaaS(t)
+	defer srv.Close()
+
+	fake.add("servers", map[string]interface{}{"id": "server-1", "name": "node-1"})
+	fake.add("ports", map[string]interface{}{"id": "node-port", "device_id": "server-1", "security_groups": []string{"node-sg"}})
+	fake.add("ports", map[string]interface{}{"id": "vip"})
+
+	// Without node-security-group the node security groups found by
+	// EnsureLoadBalancer are used by the following calls
+	lbaas := &LbaasV2{LoadBalancer{network: client, compute: client, lb: client, opts: LoadBalancerOpts{
+		SubnetID:             "subnet",
+		FloatingNetworkID:    "public",
+		ManageSecurityGroups: true,
+	}}}
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "uid", Annotations: map[string]string{
+			ServiceAnnotationLoadBalancerInternal: "true",
+			ServiceAnnotationLoadBalancerPortID:   "vip",
+		}},
+		Spec: v1.ServiceSpec{
+			Ports:           []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}},
+			SessionAffinity: v1.ServiceAffinityNone,
+		},
+	}
+	nodes := []*v1.Node{{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}}},
+	}}
+	nodeRules := func() []string {
+		var ids []string
+		for _, id := range fake.ids("security-group-rules") {
+			if fake.get("security-group-rules", id)["security_group_id"] == "node-sg" {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+
+	if _, err := lbaas.EnsureLoadBalancer(context.TODO(), "kubernetes", service, nodes); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(nodeRules()) == 0 {
+		t.Fatalf("expected rules in the node security group")
+	}
+
+	if err := lbaas.EnsureLoadBalancerDeleted(context.TODO(), "kubernetes", service); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rules := nodeRules(); len(rules) != 0 {
+		t.Errorf("expected the rules of the node security group to be deleted, got %v", rules)
+	}
+	if sgs := fake.ids("security-groups"); len(sgs) != 0 {
+		t.Errorf("expected the security group of the loadbalancer to be deleted, got %v", sgs)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// sharedLBNamePrefix is the prefix of the names of the load balancers shared
+// by several Services. Unlike lbNamePrefix, they are never hibernated nor
+// purged by the janitor.
+const sharedLBNamePrefix = "kube_shared_"
+
+// getServiceLoadBalancerName returns the name of the load balancer of a
+// Service, and its legacy name. The load balancer shared by the Services with
+// the same shared annotation has no legacy name.
+func (lbaas *LbaasV2) getServiceLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) (string, string, error) {
+	shared := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerShared, "")
+	if shared == "" {
+		return lbaas.GetLoadBalancerName(ctx, clusterName, service), lbaas.GetLoadBalancerLegacyName(ctx, clusterName, service), nil
+	}
+	if !lbaas.opts.UseOctavia {
+		return "", "", fmt.Errorf("%s needs use-octavia in cloud provider config", ServiceAnnotationLoadBalancerShared)
+	}
+	return cutString(fmt.Sprintf("%s%s_%s", sharedLBNamePrefix, clusterName, shared)), "", nil
+}
+
+// isSharedLoadBalancer returns whether loadbalancer is shared by several
+// Services.
+func isSharedLoadBalancer(loadbalancer *loadbalancers.LoadBalancer) bool {
+	return strings.HasPrefix(loadbalancer.Name, sharedLBNamePrefix)
+}
+
+// isListenerOf returns whether a listener was created for the Service whose
+// objects are named after name, as listener_<port index>_<name>.
+func isListenerOf(listener listeners.Listener, name string) bool {
+	rest := strings.TrimPrefix(listener.Name, "listener_")
+	i := strings.IndexByte(rest, '_')
+	if rest == listener.Name || i <= 0 {
+		return false
+	}
+	if _, err := strconv.Atoi(rest[:i]); err != nil {
+		return false
+	}
+	return listener.Name == cutString(fmt.Sprintf("listener_%s_%s", rest[:i], name))
+}
+
+// splitListeners splits the listeners of a shared load balancer into those of
+// the Service whose objects are named after name and those of the others.
+func splitListeners(all []listeners.Listener, name string) (own, others []listeners.Listener) {
+	for _, listener := range all {
+		if isListenerOf(listener, name) {
+			own = append(own, listener)
+		} else {
+			others = append(others, listener)
+		}
+	}
+	return own, others
+}
+
+// checkSharedListenerPorts returns an error when a port of a Service already
+// has a listener of another Service on the shared load balancer.
+func checkSharedListenerPorts(service *v1.Service, others []listeners.Listener) error {
+	for _, port := range service.Spec.Ports {
+		if listener := getListenerForPort(others, port, ""); listener != nil {
+			return fmt.Errorf("port %d of Service %s/%s is used by listener %s of another Service on the shared loadbalancer", port.Port, service.Namespace, service.Name, listener.Name)
+		}
+	}
+	return nil
+}
+
+// deleteSharedListeners deletes the listeners of a Service from the shared
+// load balancer and returns how many listeners of other Services are left.
+func (lbaas *LbaasV2) deleteSharedListeners(loadbalancer *loadbalancers.LoadBalancer, name string, plan *lbPlan) (int, error) {
+	all, err := getListenersByLoadBalancerID(lbaas.lb, loadbalancer.ID)
+	if err != nil {
+		return 0, fmt.Errorf("error getting LB %s listeners: %v", loadbalancer.Name, err)
+	}
+	own, others := splitListeners(all, name)
+	for _, listener := range own {
+		klog.V(4).Infof("Deleting listener %s of shared loadbalancer %s", listener.ID, loadbalancer.Name)
+		if err := lbaas.deleteListener(loadbalancer.ID, listener, plan); err != nil {
+			return 0, err
+		}
+	}
+	return len(others), nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetServiceLoadBalancerName(t *testing.T) {
+	lbaas := &LbaasV2{LoadBalancer{opts: LoadBalancerOpts{UseOctavia: true}}}
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}}
+
+	name, legacyName, err := lbaas.getServiceLoadBalancerName(context.TODO(), "kubernetes", service)
+	if err != nil || name != "kube_service_kubernetes_default_web" || legacyName == "" {
+		t.Errorf("unexpected name %q and legacy name %q of a Service load balancer: %v", name, legacyName, err)
+	}
+
+	service.Annotations = map[string]string{ServiceAnnotationLoadBalancerShared: "frontend"}
+	name, legacyName, err = lbaas.getServiceLoadBalancerName(context.TODO(), "kubernetes", service)
+	if err != nil || name != "kube_shared_kubernetes_frontend" || legacyName != "" {
+		t.Errorf("unexpected name %q and legacy name %q of a shared load balancer: %v", name, legacyName, err)
+	}
+
+	lbaas.opts.UseOctavia = false
+	if _, _, err := lbaas.getServiceLoadBalancerName(context.TODO(), "kubernetes", service); err == nil {
+		t.Errorf("expected an error sharing a load balancer without Octavia")
+	}
+}
+
+func TestSplitListeners(t *testing.T) {
+	all := []listeners.Listener{
+		{ID: "1", Name: "listener_0_kube_service_kubernetes_default_web", Protocol: "TCP", ProtocolPort: 80},
+		{ID: "2", Name: "listener_1_kube_service_kubernetes_default_web", Protocol: "TCP", ProtocolPort: 443},
+		// The name of the Service starts with the one of the other
+		{ID: "3", Name: "listener_0_kube_service_kubernetes_default_web-api", Protocol: "TCP", ProtocolPort: 8080},
+		{ID: "4", Name: "listener_x_kube_service_kubernetes_default_web", Protocol: "TCP", ProtocolPort: 8443},
+		{ID: "5", Name: "manual", Protocol: "UDP", ProtocolPort: 53},
+	}
+	own, others := splitListeners(all, "kube_service_kubernetes_default_web")
+	if len(own) != 2 || own[0].ID != "1" || own[1].ID != "2" {
+		t.Errorf("unexpected listeners of the Service: %v", own)
+	}
+	if len(others) != 3 {
+		t.Errorf("unexpected listeners of the other Services: %v", others)
+	}
+
+	service := &v1.Service{Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 53}}}}
+	if err := checkSharedListenerPorts(service, others); err != nil {
+		t.Errorf("a TCP port should not conflict with a UDP listener: %v", err)
+	}
+	service.Spec.Ports = append(service.Spec.Ports, v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 8080})
+	if err := checkSharedListenerPorts(service, others); err == nil {
+		t.Errorf("expected an error for a port used by another Service")
+	}
+}
+
+func TestEnsureSharedLoadBalancerDeleted(t *testing.T) {
+	fake, srv, client := newFakeLBaaS(t)
+	defer srv.Close()
+
+	fake.add("lbaas/loadbalancers", map[string]interface{}{"id": "lb", "name": "kube_shared_kubernetes_frontend", "vip_port_id": "vip"})
+	fake.add("floatingips", map[string]interface{}{"id": "fip", "port_id": "vip"})
+	for _, name := range []string{"web", "api"} {
+		fake.add("lbaas/listeners", map[string]interface{}{"id": "listener-" + name, "name": "listener_0_kube_service_kubernetes_default_" + name, "loadbalancer_id": "lb"})
+		fake.add("lbaas/pools", map[string]interface{}{"id": "pool-" + name, "name": "pool_0_kube_service_kubernetes_default_" + name, "listener_id": "listener-" + name})
+		fake.add("lbaas/pools/pool-"+name+"/members", map[string]interface{}{"id": "member-" + name, "address": "10.0.0.1", "protocol_port": 30080})
+	}
+
+	lbaas := &LbaasV2{LoadBalancer{network: client, lb: client, opts: LoadBalancerOpts{UseOctavia: true}}}
+	newService := func(name string, dryRun bool) *v1.Service {
+		return &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Annotations: map[string]string{
+			ServiceAnnotationLoadBalancerShared: "frontend",
+			ServiceAnnotationLoadBalancerDryRun: fmt.Sprint(dryRun),
+		}}}
+	}
+
+	// Only the listener of the Service is deleted, in dry-run mode not even that
+	if err := lbaas.EnsureLoadBalancerDeleted(context.TODO(), "kubernetes", newService("web", true)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changes := fake.takeChanges(); len(changes) != 0 {
+		t.Errorf("expected no changes in dry-run mode, got %v", changes)
+	}
+	if err := lbaas.EnsureLoadBalancerDeleted(context.TODO(), "kubernetes", newService("web", false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		"delete member member-web",
+		"delete pool pool_0_kube_service_kubernetes_default_web",
+		"delete listener listener_0_kube_service_kubernetes_default_web",
+	}
+	if changes := fake.takeChanges(); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+	if remaining := fake.ids("lbaas/listeners"); !reflect.DeepEqual(remaining, []string{"listener-api"}) {
+		t.Errorf("expected the listener of the other Service to be kept, got %v", remaining)
+	}
+	if pools := fake.ids("lbaas/pools"); !reflect.DeepEqual(pools, []string{"pool-api"}) {
+		t.Errorf("expected the pool of the other Service to be kept, got %v", pools)
+	}
+	if members := fake.ids("lbaas/pools/pool-api/members"); len(members) != 1 {
+		t.Errorf("expected the members of the other Service to be kept, got %v", members)
+	}
+
+	// The load balancer is deleted along with the last Service
+	if err := lbaas.EnsureLoadBalancerDeleted(context.TODO(), "kubernetes", newService("api", false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = []string{
+		"delete member member-api",
+		"delete pool pool_0_kube_service_kubernetes_default_api",
+		"delete listener listener_0_kube_service_kubernetes_default_api",
+		"delete floatingip fip",
+		"delete loadbalancer kube_shared_kubernetes_frontend",
+	}
+	if changes := fake.takeChanges(); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+}
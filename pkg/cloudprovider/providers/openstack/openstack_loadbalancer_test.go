@@ -0,0 +1,174 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLoadBalancerCreateOpts(t *testing.T) {
+	opts := loadBalancerCreateOpts{
+		CreateOpts: loadbalancers.CreateOpts{Name: "kube_service_cluster_default_nginx", VipSubnetID: "6261548e-ffde-4bc7-bd22-59c83578c5ef"},
+		FlavorID:   "a7ae5d5a-d855-4f9a-b187-af66b53f4d04",
+	}
+	b, err := opts.ToLoadBalancerCreateMap()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if flavor := b["loadbalancer"].(map[string]interface{})["flavor_id"]; flavor != opts.FlavorID {
+		t.Errorf("expected the flavor to be set, got %v", flavor)
+	}
+
+	opts.FlavorID = ""
+	b, err = opts.ToLoadBalancerCreateMap()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := b["loadbalancer"].(map[string]interface{})["flavor_id"]; ok {
+		t.Errorf("the flavor should not be set, got %v", b)
+	}
+}
+
+func TestGetListenerForPort(t *testing.T) {
+	existing := []listeners.Listener{
+		{ID: "dns-tcp", Protocol: "TCP", ProtocolPort: 53},
+		{ID: "dns-udp", Protocol: "UDP", ProtocolPort: 53},
+		{ID: "web", Protocol: "HTTP", ProtocolPort: 80},
+	}
+	tests := []struct {
+		port     v1.ServicePort
+		protocol listeners.Protocol
+		expected string
+	}{
+		{port: v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 53}, protocol: listeners.ProtocolTCP, expected: "dns-tcp"},
+		{port: v1.ServicePort{Protocol: v1.ProtocolUDP, Port: 53}, protocol: listenerProtocolUDP, expected: "dns-udp"},
+		{port: v1.ServicePort{Protocol: v1.ProtocolUDP, Port: 53}, expected: "dns-udp"},
+		{port: v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80}, expected: "web"},
+		// A UDP port never reuses a TCP listener
+		{port: v1.ServicePort{Protocol: v1.ProtocolUDP, Port: 80}},
+		{port: v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 443}},
+	}
+
+	for _, test := range tests {
+		var got string
+		if l := getListenerForPort(existing, test.port, test.protocol); l != nil {
+			got = l.ID
+		}
+		if got != test.expected {
+			t.Errorf("listener for %s port %d and protocol %q: expected %q, got %q", test.port.Protocol, test.port.Port, test.protocol, test.expected, got)
+		}
+	}
+}
+
+func TestIsInternalLoadBalancer(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{name: "default", expected: true},
+		{name: "annotation", annotations: map[string]string{ServiceAnnotationLoadBalancerInternal: "false"}, expected: false},
+		{name: "legacy annotation", annotations: map[string]string{ServiceAnnotationLoadBalancerInternalLegacy: "false"}, expected: false},
+		{
+			name: "annotation over the legacy one",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerInternal:       "true",
+				ServiceAnnotationLoadBalancerInternalLegacy: "false",
+			},
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Annotations: test.annotations}}
+		internal, err := isInternalLoadBalancer(service, true)
+		if err != nil || internal != test.expected {
+			t.Errorf("%s: expected %v, got %v and error %v", test.name, test.expected, internal, err)
+		}
+	}
+
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{ServiceAnnotationLoadBalancerInternal: "yes"}}}
+	if _, err := isInternalLoadBalancer(service, false); err == nil {
+		t.Errorf("expected an error for an invalid annotation")
+	}
+}
+
+func TestEnsureLoadBalancerReplacesPool(t *testing.T) {
+	fake, srv, client := newFakeLBaaS(t)
+	defer srv.Close()
+
+	fake.add("lbaas/loadbalancers", map[string]interface{}{"id": "lb", "name": "kube_service_kubernetes_default_web", "vip_address": "10.0.0.100"})
+	fake.add("lbaas/listeners", map[string]interface{}{"id": "listener-web", "name": "listener_0_kube_service_kubernetes_default_web", "loadbalancer_id": "lb", "protocol": "TCP", "protocol_port": 80, "connection_limit": -1})
+	fake.add("lbaas/pools", map[string]interface{}{"id": "pool-web", "name": "pool_0_kube_service_kubernetes_default_web", "listener_id": "listener-web", "protocol": "TCP", "lb_algorithm": "ROUND_ROBIN"})
+	fake.add("lbaas/pools/pool-web/members", map[string]interface{}{"id": "member-web", "address": "10.0.0.1", "protocol_port": 30080})
+
+	lbaas := &LbaasV2{LoadBalancer{network: client, lb: client, opts: LoadBalancerOpts{UseOctavia: true, SubnetID: "subnet", FloatingNetworkID: "public"}}}
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Annotations: map[string]string{
+			ServiceAnnotationLoadBalancerInternal:     "true",
+			ServiceAnnotationLoadBalancerProxyEnabled: "true",
+		}},
+		Spec: v1.ServiceSpec{
+			Ports:           []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}},
+			SessionAffinity: v1.ServiceAffinityNone,
+		},
+	}
+	nodes := []*v1.Node{{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}}},
+	}}
+
+	// The protocol of a pool cannot be updated, the TCP pool is replaced by a
+	// PROXY one with the same members
+	if _, err := lbaas.EnsureLoadBalancer(context.TODO(), "kubernetes", service, nodes); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		"delete member member-web",
+		"delete pool pool_0_kube_service_kubernetes_default_web",
+		"create pool pool_0_kube_service_kubernetes_default_web",
+		"create member member_0_node-1_kube_service_kubernetes_default_web",
+	}
+	if changes := fake.takeChanges(); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+	pools := fake.ids("lbaas/pools")
+	if len(pools) != 1 || pools[0] == "pool-web" {
+		t.Fatalf("expected the pool to be replaced, got %v", pools)
+	}
+	if pool := fake.get("lbaas/pools", pools[0]); pool["protocol"] != "PROXY" {
+		t.Errorf("expected a PROXY pool, got %v", pool["protocol"])
+	}
+	if listener := fake.get("lbaas/listeners", "listener-web"); listener["default_pool_id"] != pools[0] {
+		t.Errorf("expected the listener to use the new pool, got %v", listener["default_pool_id"])
+	}
+
+	// The new pool is kept
+	if _, err := lbaas.EnsureLoadBalancer(context.TODO(), "kubernetes", service, nodes); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changes := fake.takeChanges(); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
@@ -25,6 +25,9 @@ const (
 	openstackSubsystem         = "openstack"
 	openstackOperationKey      = "cloudprovider_openstack_api_request_duration_seconds"
 	openstackOperationErrorKey = "cloudprovider_openstack_api_request_errors"
+	openstackClockSkewKey      = "cloudprovider_openstack_clock_skew_seconds"
+	openstackLBQuotaKey        = "cloudprovider_openstack_loadbalancer_quota"
+	openstackLBQuotaUsageKey   = "cloudprovider_openstack_loadbalancer_quota_usage"
 )
 
 var (
@@ -45,6 +48,32 @@ var (
 		},
 		[]string{"request"},
 	)
+
+	openstackClockSkew = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: openstackSubsystem,
+			Name:      openstackClockSkewKey,
+			Help:      "Difference between the local clock and the openstack api server clock, positive when the local clock is ahead",
+		},
+	)
+
+	openstackLoadBalancerQuota = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: openstackSubsystem,
+			Name:      openstackLBQuotaKey,
+			Help:      "Octavia quota of the project per resource, -1 when unlimited",
+		},
+		[]string{"resource"},
+	)
+
+	openstackLoadBalancerQuotaUsage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: openstackSubsystem,
+			Name:      openstackLBQuotaUsageKey,
+			Help:      "Number of Octavia objects of the project per resource",
+		},
+		[]string{"resource"},
+	)
 )
 
 func RegisterMetrics() {
@@ -54,4 +83,13 @@ func RegisterMetrics() {
 	if err := prometheus.Register(openstackAPIRequestErrors); err != nil {
 		klog.V(5).Infof("unable to register for error metrics")
 	}
+	if err := prometheus.Register(openstackClockSkew); err != nil {
+		klog.V(5).Infof("unable to register for clock skew metrics")
+	}
+	if err := prometheus.Register(openstackLoadBalancerQuota); err != nil {
+		klog.V(5).Infof("unable to register for loadbalancer quota metrics")
+	}
+	if err := prometheus.Register(openstackLoadBalancerQuotaUsage); err != nil {
+		klog.V(5).Infof("unable to register for loadbalancer quota usage metrics")
+	}
 }
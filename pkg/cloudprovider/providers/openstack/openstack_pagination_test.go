@@ -0,0 +1,101 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+)
+
+// newPagedServer serves the JSON objects of a collection in two pages, the
+// second one only when requested with the marker of the next link of the
+// first one, as the OpenStack APIs do past their max limit. It returns the
+// server and the number of pages served.
+func newPagedServer(t *testing.T, path, collection string, first, second []string) (*httptest.Server, *int) {
+	pages := 0
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		pages++
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("marker") == "page-2" {
+			fmt.Fprintf(w, `{"%s": [%s]}`, collection, strings.Join(second, ","))
+			return
+		}
+		fmt.Fprintf(w, `{"%s": [%s], "%s_links": [{"rel": "next", "href": "%s%s?marker=page-2"}]}`, collection, strings.Join(first, ","), collection, srv.URL, path)
+	}))
+	return srv, &pages
+}
+
+func newPagedClient(srv *httptest.Server) *gophercloud.ServiceClient {
+	return &gophercloud.ServiceClient{
+		ProviderClient: &gophercloud.ProviderClient{TokenID: "token"},
+		Endpoint:       srv.URL + "/",
+	}
+}
+
+func TestForeachServerPagination(t *testing.T) {
+	srv, pages := newPagedServer(t, "/servers/detail", "servers",
+		[]string{`{"id": "1", "name": "node-1"}`, `{"id": "2", "name": "node-2"}`},
+		[]string{`{"id": "3", "name": "node-3"}`})
+	defer srv.Close()
+
+	var names []string
+	err := foreachServer(newPagedClient(srv), servers.ListOpts{}, func(server *servers.Server) (bool, error) {
+		names = append(names, server.Name)
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(names) != 3 || names[2] != "node-3" {
+		t.Errorf("expected the servers of both pages, got %v", names)
+	}
+	if *pages != 2 {
+		t.Errorf("expected 2 pages to be fetched, got %d", *pages)
+	}
+}
+
+func TestGetPoolByListenerIDPagination(t *testing.T) {
+	// The pool of the listener is on the second page of the pools of the
+	// load balancer
+	srv, pages := newPagedServer(t, "/lbaas/pools", "pools",
+		[]string{`{"id": "pool-1", "listeners": [{"id": "listener-1"}]}`},
+		[]string{`{"id": "pool-2", "listeners": [{"id": "listener-2"}]}`})
+	defer srv.Close()
+
+	pool, err := getPoolByListenerID(newPagedClient(srv), "lb-id", "listener-2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pool.ID != "pool-2" {
+		t.Errorf("expected the pool of the second page, got %+v", pool)
+	}
+	if *pages != 2 {
+		t.Errorf("expected 2 pages to be fetched, got %d", *pages)
+	}
+}
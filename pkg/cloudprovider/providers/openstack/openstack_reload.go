@@ -0,0 +1,77 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"k8s.io/cloud-provider-openstack/pkg/util/configwatch"
+	"k8s.io/klog"
+)
+
+var (
+	cloudConfigFile           string
+	cloudConfigReloadInterval time.Duration
+)
+
+// SetCloudConfigReload makes the cloud provider check the cloud config at
+// path, and the clouds.yaml and secure.yaml it reads, for changes every
+// interval once initialized, and reauthenticate with the new credentials. 0
+// disables it.
+func SetCloudConfigReload(path string, interval time.Duration) {
+	cloudConfigFile = path
+	cloudConfigReloadInterval = interval
+}
+
+// cloudConfigPaths returns the files the credentials are read from.
+func cloudConfigPaths() []string {
+	return []string{cloudConfigFile, os.Getenv("OS_CLIENT_CONFIG_FILE"), os.Getenv("OS_CLIENT_SECURE_FILE")}
+}
+
+// watchCloudConfig reloads the credentials of the cloud config when it
+// changes, until stop is closed.
+func (os *OpenStack) watchCloudConfig(stop <-chan struct{}) {
+	configwatch.Watch(cloudConfigReloadInterval, stop, cloudConfigPaths(), os.reloadCredentials)
+}
+
+// reloadCredentials reads the cloud config again and reauthenticates the
+// client of the cloud provider with the credentials of its [Global] section.
+// All the service clients share the client, so the operations in progress go
+// on with a token of the new credentials too, e.g. when changing the password
+// revoked the tokens of the previous one. The other settings are only read at
+// startup.
+func (os *OpenStack) reloadCredentials() error {
+	data, err := ioutil.ReadFile(cloudConfigFile)
+	if err != nil {
+		return err
+	}
+	cfg, err := ReadConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if cfg.Global.AuthURL != os.config.Global.AuthURL || cfg.Global.Region != os.config.Global.Region || cfg.Global.CAFile != os.config.Global.CAFile {
+		klog.Warningf("Only the credentials of %s are reloaded, restart the cloud controller manager to use the new auth-url, region or ca-file", cloudConfigFile)
+	}
+	if err := AuthenticateProvider(os.provider, cfg); err != nil {
+		return err
+	}
+	klog.Infof("Reloaded the OpenStack credentials of %s", cloudConfigFile)
+	return nil
+}
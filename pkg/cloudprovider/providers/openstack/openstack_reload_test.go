@@ -0,0 +1,109 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A rotated password reauthenticates the client shared by the service
+// clients, a wrong one keeps the previous token.
+func TestReloadCredentials(t *testing.T) {
+	keystone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Auth struct {
+				Identity struct {
+					Password struct {
+						User struct {
+							Password string `json:"password"`
+						} `json:"user"`
+					} `json:"password"`
+				} `json:"identity"`
+			} `json:"auth"`
+		}
+		if r.URL.Path != "/v3/auth/tokens" || json.NewDecoder(r.Body).Decode(&body) != nil {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		password := body.Auth.Identity.Password.User.Password
+		if password == "wrong" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-Subject-Token", "token-"+password)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": {"expires_at": "2030-01-01T00:00:00.000000Z", "catalog": []}}`)
+	}))
+	defer keystone.Close()
+
+	dir, err := ioutil.TempDir("", "cloud-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cloud.conf")
+	writeConfig := func(password string) Config {
+		content := "[Global]\nauth-url=" + keystone.URL + "/v3\nusername=user\npassword=" + password + "\ndomain-name=Default\n"
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		cfg, err := ReadConfig(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+
+	cfg := writeConfig("old")
+	provider, err := NewProviderClient(cfg)
+	if err != nil {
+		t.Fatalf("failed to authenticate: %v", err)
+	}
+	savedFile, savedInterval := cloudConfigFile, cloudConfigReloadInterval
+	defer SetCloudConfigReload(savedFile, savedInterval)
+	SetCloudConfigReload(file, 0)
+	cloud := &OpenStack{provider: provider, config: cfg}
+
+	writeConfig("wrong")
+	if err := cloud.reloadCredentials(); err == nil {
+		t.Errorf("expected wrong credentials to fail")
+	}
+	if token := provider.Token(); token != "token-old" {
+		t.Errorf("expected the previous token to be kept, got %q", token)
+	}
+
+	writeConfig("new")
+	if err := cloud.reloadCredentials(); err != nil {
+		t.Fatalf("failed to reload the credentials: %v", err)
+	}
+	if token := provider.Token(); token != "token-new" {
+		t.Errorf("expected a token of the new credentials, got %q", token)
+	}
+}
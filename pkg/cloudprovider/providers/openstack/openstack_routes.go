@@ -19,19 +19,32 @@ package openstack
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
+	"sync"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
 	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+	"github.com/gophercloud/gophercloud/pagination"
 
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog"
 )
 
-var errNoRouterID = errors.New("router-id not set in cloud provider config")
+var errNoRouterID = errors.New("router-id or discover-routers not set in cloud provider config")
+
+// routerInterfaceOwners are the device owners of the ports of the router
+// interfaces, whose device ID is the router ID.
+var routerInterfaceOwners = []string{
+	"network:router_interface",
+	"network:router_interface_distributed",
+	"network:ha_router_replicated_interface",
+}
 
 // Routes implements the cloudprovider.Routes for OpenStack clouds
 type Routes struct {
@@ -39,11 +52,34 @@ type Routes struct {
 	network        *gophercloud.ServiceClient
 	opts           RouterOpts
 	networkingOpts NetworkingOpts
+	// discovered are the routers discovered with DiscoverRouters, listed
+	// even once they route to no node, so that their stale routes are
+	// deleted
+	discovered *discoveredRouters
+}
+
+// discoveredRouters is the set of the IDs of the routers discovered so far.
+type discoveredRouters struct {
+	mu  sync.Mutex
+	ids sets.String
+}
+
+func (d *discoveredRouters) add(ids ...string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ids.Insert(ids...)
+	return d.ids.List()
+}
+
+// routerSubnet is a subnet a router has an interface on.
+type routerSubnet struct {
+	routerID string
+	cidr     *net.IPNet
 }
 
 // NewRoutes creates a new instance of Routes
 func NewRoutes(compute *gophercloud.ServiceClient, network *gophercloud.ServiceClient, opts RouterOpts, networkingOpts NetworkingOpts) (cloudprovider.Routes, error) {
-	if opts.RouterID == "" {
+	if opts.RouterID == "" && !opts.DiscoverRouters {
 		return nil, errNoRouterID
 	}
 
@@ -52,11 +88,111 @@ func NewRoutes(compute *gophercloud.ServiceClient, network *gophercloud.ServiceC
 		network:        network,
 		opts:           opts,
 		networkingOpts: networkingOpts,
+		discovered:     &discoveredRouters{ids: sets.NewString()},
 	}, nil
 }
 
+// getRouterSubnets returns the subnets the routers of the project have an
+// interface on.
+func getRouterSubnets(network *gophercloud.ServiceClient) ([]routerSubnet, error) {
+	cidrs := make(map[string]*net.IPNet)
+	err := subnets.List(network, subnets.ListOpts{}).EachPage(func(page pagination.Page) (bool, error) {
+		list, err := subnets.ExtractSubnets(page)
+		if err != nil {
+			return false, err
+		}
+		for _, subnet := range list {
+			if _, cidr, err := net.ParseCIDR(subnet.CIDR); err == nil {
+				cidrs[subnet.ID] = cidr
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subnets: %v", err)
+	}
+
+	var routerSubnets []routerSubnet
+	for _, owner := range routerInterfaceOwners {
+		ports, err := getPorts(network, neutronports.ListOpts{DeviceOwner: owner})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s ports: %v", owner, err)
+		}
+		for _, port := range ports {
+			for _, fixedIP := range port.FixedIPs {
+				if cidr, ok := cidrs[fixedIP.SubnetID]; ok {
+					routerSubnets = append(routerSubnets, routerSubnet{routerID: port.DeviceID, cidr: cidr})
+				}
+			}
+		}
+	}
+	return routerSubnets, nil
+}
+
+// routerForAddress returns the ID of the router with an interface on the
+// subnet of addr, "" when there is none.
+func routerForAddress(routerSubnets []routerSubnet, addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	for _, subnet := range routerSubnets {
+		if subnet.cidr.Contains(ip) {
+			return subnet.routerID
+		}
+	}
+	return ""
+}
+
+// getRouterID returns the ID of the router of the routes to nextHop, the
+// configured one unless routers are discovered.
+func (r *Routes) getRouterID(nextHop string) (string, error) {
+	if !r.opts.DiscoverRouters {
+		return r.opts.RouterID, nil
+	}
+	routerSubnets, err := getRouterSubnets(r.network)
+	if err != nil {
+		return "", err
+	}
+	routerID := routerForAddress(routerSubnets, nextHop)
+	if routerID == "" {
+		return "", fmt.Errorf("no router has an interface on the subnet of %s", nextHop)
+	}
+	r.discovered.add(routerID)
+	return routerID, nil
+}
+
+// getRouterIDs returns the IDs of the routers of the routes to the nodes with
+// the given addresses, along with the ones discovered before.
+func (r *Routes) getRouterIDs(addrs []string) ([]string, error) {
+	if !r.opts.DiscoverRouters {
+		return []string{r.opts.RouterID}, nil
+	}
+	routerSubnets, err := getRouterSubnets(r.network)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, addr := range addrs {
+		if routerID := routerForAddress(routerSubnets, addr); routerID != "" {
+			ids = append(ids, routerID)
+		}
+	}
+	return r.discovered.add(ids...), nil
+}
+
+// withContext returns a copy of r whose OpenStack requests are cancelled when
+// ctx is done.
+func (r *Routes) withContext(ctx context.Context) *Routes {
+	bound := *r
+	bound.compute = clientWithContext(ctx, r.compute)
+	bound.network = clientWithContext(ctx, r.network)
+	return &bound
+}
+
 // ListRoutes lists all managed routes that belong to the specified clusterName
 func (r *Routes) ListRoutes(ctx context.Context, clusterName string) ([]*cloudprovider.Route, error) {
+	r = r.withContext(ctx)
 	klog.V(4).Infof("ListRoutes(%v)", clusterName)
 
 	nodeNamesByAddr := make(map[string]types.NodeName)
@@ -77,24 +213,37 @@ func (r *Routes) ListRoutes(ctx context.Context, clusterName string) ([]*cloudpr
 		return nil, err
 	}
 
-	router, err := routers.Get(r.network, r.opts.RouterID).Extract()
+	addrs := make([]string, 0, len(nodeNamesByAddr))
+	for addr := range nodeNamesByAddr {
+		addrs = append(addrs, addr)
+	}
+	routerIDs, err := r.getRouterIDs(addrs)
 	if err != nil {
 		return nil, err
 	}
 
+	// The routes to removed nodes are blackholes, deleted by the route
+	// controller
 	var routes []*cloudprovider.Route
-	for _, item := range router.Routes {
-		nodeName, foundNode := nodeNamesByAddr[item.NextHop]
-		if !foundNode {
-			nodeName = types.NodeName(item.NextHop)
+	for _, routerID := range routerIDs {
+		router, err := routers.Get(r.network, routerID).Extract()
+		if err != nil {
+			return nil, err
 		}
-		route := cloudprovider.Route{
-			Name:            item.DestinationCIDR,
-			TargetNode:      nodeName, //contains the nexthop address if node was not found
-			Blackhole:       !foundNode,
-			DestinationCIDR: item.DestinationCIDR,
+
+		for _, item := range router.Routes {
+			nodeName, foundNode := nodeNamesByAddr[item.NextHop]
+			if !foundNode {
+				nodeName = types.NodeName(item.NextHop)
+			}
+			route := cloudprovider.Route{
+				Name:            item.DestinationCIDR,
+				TargetNode:      nodeName, //contains the nexthop address if node was not found
+				Blackhole:       !foundNode,
+				DestinationCIDR: item.DestinationCIDR,
+			}
+			routes = append(routes, &route)
 		}
-		routes = append(routes, &route)
 	}
 
 	return routes, nil
@@ -147,7 +296,10 @@ func updateAllowedAddressPairs(network *gophercloud.ServiceClient, port *neutron
 }
 
 // CreateRoute creates the described managed route
-func (r *Routes) CreateRoute(ctx context.Context, clusterName string, nameHint string, route *cloudprovider.Route) error {
+func (r *Routes) CreateRoute(ctx context.Context, clusterName string, nameHint string, route *cloudprovider.Route) (err error) {
+	r = r.withContext(ctx)
+	end := operationHistory.Begin("CreateRoute", route.DestinationCIDR)
+	defer func() { end(err) }()
 	klog.V(4).Infof("CreateRoute(%v, %v, %v)", clusterName, nameHint, route)
 
 	onFailure := newCaller()
@@ -162,7 +314,11 @@ func (r *Routes) CreateRoute(ctx context.Context, clusterName string, nameHint s
 
 	klog.V(4).Infof("Using nexthop %v for node %v", addr, route.TargetNode)
 
-	router, err := routers.Get(r.network, r.opts.RouterID).Extract()
+	routerID, err := r.getRouterID(addr)
+	if err != nil {
+		return err
+	}
+	router, err := routers.Get(r.network, routerID).Extract()
 	if err != nil {
 		return err
 	}
@@ -223,7 +379,10 @@ func (r *Routes) CreateRoute(ctx context.Context, clusterName string, nameHint s
 }
 
 // DeleteRoute deletes the specified managed route
-func (r *Routes) DeleteRoute(ctx context.Context, clusterName string, route *cloudprovider.Route) error {
+func (r *Routes) DeleteRoute(ctx context.Context, clusterName string, route *cloudprovider.Route) (err error) {
+	r = r.withContext(ctx)
+	end := operationHistory.Begin("DeleteRoute", route.DestinationCIDR)
+	defer func() { end(err) }()
 	klog.V(4).Infof("DeleteRoute(%v, %v)", clusterName, route)
 
 	onFailure := newCaller()
@@ -233,15 +392,21 @@ func (r *Routes) DeleteRoute(ctx context.Context, clusterName string, route *clo
 	var addr string
 
 	// Blackhole routes are orphaned and have no counterpart in OpenStack
+	nextHop := string(route.TargetNode)
 	if !route.Blackhole {
 		var err error
 		addr, err = getAddressByName(r.compute, route.TargetNode, isCIDRv6, r.networkingOpts)
 		if err != nil {
 			return err
 		}
+		nextHop = addr
 	}
 
-	router, err := routers.Get(r.network, r.opts.RouterID).Extract()
+	routerID, err := r.getRouterID(nextHop)
+	if err != nil {
+		return err
+	}
+	router, err := routers.Get(r.network, routerID).Extract()
 	if err != nil {
 		return err
 	}
@@ -18,9 +18,14 @@ package openstack
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 
+	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
 	"k8s.io/apimachinery/pkg/types"
@@ -85,6 +90,74 @@ func TestRoutes(t *testing.T) {
 	}
 }
 
+func TestRouterDiscovery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/subnets":
+			fmt.Fprint(w, `{"subnets": [
+				{"id": "subnet-a", "cidr": "10.0.0.0/24"},
+				{"id": "subnet-b", "cidr": "10.0.1.0/24"},
+				{"id": "subnet-c", "cidr": "10.0.2.0/24"}
+			]}`)
+		case r.URL.Path == "/ports" && r.URL.Query().Get("device_owner") == "network:router_interface":
+			fmt.Fprint(w, `{"ports": [
+				{"id": "port-a", "device_id": "router-a", "fixed_ips": [{"subnet_id": "subnet-a", "ip_address": "10.0.0.1"}]}
+			]}`)
+		case r.URL.Path == "/ports" && r.URL.Query().Get("device_owner") == "network:router_interface_distributed":
+			fmt.Fprint(w, `{"ports": [
+				{"id": "port-b", "device_id": "router-b", "fixed_ips": [{"subnet_id": "subnet-b", "ip_address": "10.0.1.1"}]}
+			]}`)
+		case r.URL.Path == "/ports":
+			fmt.Fprint(w, `{"ports": []}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	network := &gophercloud.ServiceClient{
+		ProviderClient: &gophercloud.ProviderClient{TokenID: "token"},
+		Endpoint:       srv.URL + "/",
+	}
+	if _, err := NewRoutes(nil, network, RouterOpts{}, NetworkingOpts{}); err != errNoRouterID {
+		t.Errorf("expected %v without router, got %v", errNoRouterID, err)
+	}
+	cr, err := NewRoutes(nil, network, RouterOpts{DiscoverRouters: true}, NetworkingOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := cr.(*Routes)
+
+	routerSubnets, err := getRouterSubnets(network)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for addr, expected := range map[string]string{
+		"10.0.0.10":  "router-a",
+		"10.0.1.10":  "router-b",
+		"10.0.2.10":  "",
+		"not-an-ip":  "",
+		"10.0.10.10": "",
+	} {
+		if routerID := routerForAddress(routerSubnets, addr); routerID != expected {
+			t.Errorf("expected router %q for %s, got %q", expected, addr, routerID)
+		}
+	}
+
+	if routerID, err := r.getRouterID("10.0.1.10"); err != nil || routerID != "router-b" {
+		t.Errorf("expected router-b, got %q: %v", routerID, err)
+	}
+	if _, err := r.getRouterID("10.0.2.10"); err == nil {
+		t.Errorf("expected an error for a subnet without router")
+	}
+	// router-b is still listed without nodes on its subnet
+	ids, err := r.getRouterIDs([]string{"10.0.0.10", "10.0.0.11"})
+	if err != nil || !reflect.DeepEqual(ids, []string{"router-a", "router-b"}) {
+		t.Errorf("expected routers router-a and router-b, got %v: %v", ids, err)
+	}
+}
+
 func getServers(os *OpenStack) []servers.Server {
 	c, err := os.NewComputeV2()
 	opts := servers.ListOpts{
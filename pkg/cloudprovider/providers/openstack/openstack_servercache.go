@@ -0,0 +1,127 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+
+	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
+)
+
+// serverCache caches the servers the Instances calls made on every node
+// status update look up by node name, for server-cache-ttl.
+type serverCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	servers map[types.NodeName]cachedServer
+}
+
+type cachedServer struct {
+	server   ServerAttributesExt
+	cachedAt time.Time
+}
+
+// newServerCache returns a cache of the servers for ttl, nil for a zero ttl.
+func newServerCache(ttl time.Duration) *serverCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &serverCache{ttl: ttl, now: time.Now, servers: map[types.NodeName]cachedServer{}}
+}
+
+// getServerByName returns the server of the node name, the one cached less
+// than ttl ago if any. Without a cache, the server is always looked up.
+func (c *serverCache) getServerByName(client *gophercloud.ServiceClient, name types.NodeName) (*ServerAttributesExt, error) {
+	if c == nil {
+		return getServerByName(client, name)
+	}
+
+	c.mu.Lock()
+	cached, ok := c.servers[name]
+	c.mu.Unlock()
+	if ok && c.now().Sub(cached.cachedAt) < c.ttl {
+		srv := cached.server
+		return &srv, nil
+	}
+
+	srv, err := getServerByName(client, name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		delete(c.servers, name)
+		return nil, err
+	}
+	c.servers[name] = cachedServer{server: *srv, cachedAt: c.now()}
+	return srv, nil
+}
+
+// invalidateID drops the server instanceID from the cache, e.g. once it is
+// deleted.
+func (c *serverCache) invalidateID(instanceID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, cached := range c.servers {
+		if cached.server.ID == instanceID {
+			delete(c.servers, name)
+		}
+	}
+}
+
+// novaMetadata returns the metadata of the local instance from Nova, the
+// server named after the host name, for the NovaID search order element.
+func (os *OpenStack) novaMetadata() (*metadata.Metadata, error) {
+	compute, err := os.NewComputeV2()
+	if err != nil {
+		return nil, err
+	}
+	name, err := hostname()
+	if err != nil {
+		return nil, err
+	}
+	srv, err := getServerByName(compute, types.NodeName(name))
+	if err != nil {
+		return nil, err
+	}
+	klog.V(4).Infof("Got the metadata of %s from Nova: %s", name, srv.ID)
+	return &metadata.Metadata{
+		UUID:             srv.ID,
+		Name:             srv.Name,
+		AvailabilityZone: srv.AvailabilityZone,
+	}, nil
+}
+
+// hostname returns the short host name, the server name of the instances
+// whose host name was not changed.
+func hostname() (string, error) {
+	name, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	return strings.SplitN(name, ".", 2)[0], nil
+}
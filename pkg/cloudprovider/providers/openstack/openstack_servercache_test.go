@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// newServersServer serves node-1 as the only server, and returns the number
+// of lists it served.
+func newServersServer(t *testing.T) (*httptest.Server, *int) {
+	lists := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/servers/detail" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		lists++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"servers": [{"id": "1", "name": "node-1"}]}`)
+	}))
+	return srv, &lists
+}
+
+func TestServerCache(t *testing.T) {
+	srv, lists := newServersServer(t)
+	defer srv.Close()
+	client := newPagedClient(srv)
+
+	now := time.Now()
+	cache := newServerCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		server, err := cache.getServerByName(client, types.NodeName("node-1"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if server.ID != "1" {
+			t.Errorf("expected server 1, got %q", server.ID)
+		}
+	}
+	if *lists != 1 {
+		t.Errorf("expected the server to be listed once, got %d", *lists)
+	}
+
+	// Expired
+	now = now.Add(time.Minute)
+	if _, err := cache.getServerByName(client, types.NodeName("node-1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *lists != 2 {
+		t.Errorf("expected the expired server to be listed again, got %d lists", *lists)
+	}
+
+	// Deleted
+	cache.invalidateID("1")
+	if _, err := cache.getServerByName(client, types.NodeName("node-1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *lists != 3 {
+		t.Errorf("expected the invalidated server to be listed again, got %d lists", *lists)
+	}
+}
+
+func TestServerCacheDisabled(t *testing.T) {
+	srv, lists := newServersServer(t)
+	defer srv.Close()
+	client := newPagedClient(srv)
+
+	cache := newServerCache(0)
+	if cache != nil {
+		t.Fatalf("expected no cache without a ttl")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := cache.getServerByName(client, types.NodeName("node-1")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if *lists != 2 {
+		t.Errorf("expected the server to be listed on each call, got %d lists", *lists)
+	}
+	cache.invalidateID("1")
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sort"
+
+	"k8s.io/cloud-provider-openstack/pkg/util/supportbundle"
+)
+
+// operationHistoryLength is the number of finished load balancer and route
+// operations kept for the support bundle.
+const operationHistoryLength = 200
+
+var (
+	// operationHistory records the load balancer and route operations.
+	operationHistory = supportbundle.NewHistory(operationHistoryLength)
+
+	supportBundleAddress string
+	supportBundleLogs    *supportbundle.LineBuffer
+	// supportBundleTLS is nil when the support bundle is only served on loopback
+	supportBundleTLS *tls.Config
+)
+
+// SetSupportBundle serves the support bundle on address once the cloud
+// provider is initialized, including the lines kept in logs. An empty
+// address disables it.
+func SetSupportBundle(address string, logs *supportbundle.LineBuffer) {
+	supportBundleAddress = address
+	supportBundleLogs = logs
+}
+
+// SetDebugTLS serves the support bundle with mutual TLS, which allows
+// serving it on other than a loopback address.
+func SetDebugTLS(certFile, keyFile, clientCAFile string) error {
+	tlsConfig, err := supportbundle.MutualTLSConfig(certFile, keyFile, clientCAFile)
+	if err != nil {
+		return err
+	}
+	supportBundleTLS = tlsConfig
+	return nil
+}
+
+// supportBundleCapabilities is what was detected of the OpenStack cloud.
+type supportBundleCapabilities struct {
+	Region            string   `json:"region"`
+	LoadBalancer      string   `json:"loadBalancer"`
+	BlockStorage      string   `json:"blockStorage,omitempty"`
+	ComputeEndpoint   string   `json:"computeEndpoint,omitempty"`
+	NetworkEndpoint   string   `json:"networkEndpoint,omitempty"`
+	NetworkExtensions []string `json:"networkExtensions,omitempty"`
+	Errors            []string `json:"errors,omitempty"`
+}
+
+// collectSupportBundle adds the cloud provider state to the bundle.
+func (os *OpenStack) collectSupportBundle(b *supportbundle.Bundle) {
+	// The secrets are registered so they are masked wherever they appear,
+	// e.g. in logs, not only in the config.
+	b.AddSecret(os.config.Global.Password)
+	b.AddSecret(os.config.Global.TrustID)
+	b.AddSecret(os.provider.Token())
+	b.AddJSON("config/effective.json", os.config)
+
+	b.AddJSON("features.json", map[string]interface{}{
+		"loadBalancer": os.lbOpts,
+		"blockStorage": os.bsOpts,
+		"networking":   os.networkingOpts,
+		"metadata":     os.metadataOpts,
+	})
+
+	caps := supportBundleCapabilities{
+		Region:       os.region,
+		LoadBalancer: "neutron-lbaas-v2",
+	}
+	if os.lbOpts.UseOctavia {
+		caps.LoadBalancer = "octavia-v2"
+	}
+	if compute, err := os.NewComputeV2(); err != nil {
+		caps.Errors = append(caps.Errors, err.Error())
+	} else {
+		caps.ComputeEndpoint = compute.Endpoint
+	}
+	if network, err := os.NewNetworkV2(); err != nil {
+		caps.Errors = append(caps.Errors, err.Error())
+	} else {
+		caps.NetworkEndpoint = network.Endpoint
+		exts, err := networkExtensions(network)
+		if err != nil {
+			caps.Errors = append(caps.Errors, fmt.Sprintf("failed to list network extensions: %v", err))
+		}
+		for ext := range exts {
+			caps.NetworkExtensions = append(caps.NetworkExtensions, ext)
+		}
+		sort.Strings(caps.NetworkExtensions)
+	}
+	if volumes, err := os.volumeService(""); err != nil {
+		caps.Errors = append(caps.Errors, err.Error())
+	} else {
+		caps.BlockStorage = fmt.Sprintf("%T", volumes)
+	}
+	b.AddJSON("capabilities.json", caps)
+
+	b.AddJSON("operations.json", operationHistory.Operations())
+	b.AddJSON("inflight.json", operationHistory.InFlight())
+
+	if supportBundleLogs != nil {
+		b.AddLines("logs.txt", supportBundleLogs.Lines())
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+
+	"k8s.io/cloud-provider-openstack/pkg/util/supportbundle"
+)
+
+func TestCollectSupportBundle(t *testing.T) {
+	const (
+		password = "Zq9xvX2-mT"
+		trustID  = "7f1e0c2d9a"
+		token    = "a1b2c3d4e5f6"
+	)
+
+	var cfg Config
+	cfg.Global.Username = "ccm"
+	cfg.Global.Password = password
+	cfg.Global.TrustID = trustID
+	cfg.Global.Region = "RegionOne"
+	cfg.LoadBalancer.UseOctavia = true
+	cfg.BlockStorage.BSVersion = "v3"
+
+	os := &OpenStack{
+		provider: &gophercloud.ProviderClient{
+			TokenID: token,
+			EndpointLocator: func(gophercloud.EndpointOpts) (string, error) {
+				return "", errors.New("no service catalog")
+			},
+		},
+		region: cfg.Global.Region,
+		lbOpts: cfg.LoadBalancer,
+		bsOpts: cfg.BlockStorage,
+		config: cfg,
+	}
+
+	logs := supportbundle.NewLineBuffer(10)
+	fmt.Fprintf(logs, "I0301 12:00:00 authenticated as ccm with %s, got %s\n", password, token)
+	SetSupportBundle("", logs)
+	defer SetSupportBundle("", nil)
+
+	operationHistory.Begin("EnsureLoadBalancer", "default/test")(errors.New("quota exceeded"))
+
+	b := supportbundle.New(nil)
+	os.collectSupportBundle(b)
+	var out bytes.Buffer
+	if err := b.Write(&out); err != nil {
+		t.Fatalf("failed to write bundle: %v", err)
+	}
+
+	gz, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("bundle is not gzipped: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", hdr.Name, err)
+		}
+		files[hdr.Name] = string(content)
+	}
+
+	for _, name := range []string{"config/effective.json", "features.json", "capabilities.json", "operations.json", "inflight.json", "logs.txt"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("expected %s in the bundle", name)
+		}
+	}
+	for name, content := range files {
+		for _, secret := range []string{password, trustID, token} {
+			if strings.Contains(content, secret) {
+				t.Errorf("secret %q found in %s:\n%s", secret, name, content)
+			}
+		}
+	}
+
+	if !strings.Contains(files["config/effective.json"], `"Username": "ccm"`) {
+		t.Errorf("non secret settings should be kept:\n%s", files["config/effective.json"])
+	}
+	if !strings.Contains(files["capabilities.json"], "octavia-v2") || !strings.Contains(files["capabilities.json"], "no service catalog") {
+		t.Errorf("unexpected capabilities:\n%s", files["capabilities.json"])
+	}
+	if !strings.Contains(files["operations.json"], "quota exceeded") {
+		t.Errorf("unexpected operations:\n%s", files["operations.json"])
+	}
+}
@@ -313,6 +313,59 @@ func TestToAuthOptions(t *testing.T) {
 	}
 }
 
+func TestToAuthOptionsApplicationCredential(t *testing.T) {
+	cfg := Config{}
+	cfg.Global.AuthURL = "http://auth.url"
+	cfg.Global.TenantID = "c869168a828847f39f7f06edd7305637"
+	cfg.Global.ApplicationCredentialID = "appcred"
+	cfg.Global.ApplicationCredentialSecret = "secret"
+
+	ao := cfg.toAuthOptions()
+
+	if ao.ApplicationCredentialID != cfg.Global.ApplicationCredentialID {
+		t.Errorf("ApplicationCredentialID %s != %s", ao.ApplicationCredentialID, cfg.Global.ApplicationCredentialID)
+	}
+	if ao.ApplicationCredentialSecret != cfg.Global.ApplicationCredentialSecret {
+		t.Errorf("ApplicationCredentialSecret %s != %s", ao.ApplicationCredentialSecret, cfg.Global.ApplicationCredentialSecret)
+	}
+	// The application credential is scoped already
+	if ao.TenantID != "" {
+		t.Errorf("TenantID %s should be empty", ao.TenantID)
+	}
+}
+
+func TestConfigFromEnvApplicationCredential(t *testing.T) {
+	env := clearEnviron(t)
+	defer resetEnviron(t, env)
+
+	os.Setenv("OS_AUTH_URL", "http://auth.url")
+	defer os.Unsetenv("OS_AUTH_URL")
+	os.Setenv("OS_APPLICATION_CREDENTIAL_ID", "appcred")
+	defer os.Unsetenv("OS_APPLICATION_CREDENTIAL_ID")
+	os.Setenv("OS_APPLICATION_CREDENTIAL_SECRET", "secret")
+	defer os.Unsetenv("OS_APPLICATION_CREDENTIAL_SECRET")
+
+	cfg, ok := configFromEnv()
+	if !ok {
+		t.Fatalf("An application credential without a password should be enough")
+	}
+	if cfg.Global.ApplicationCredentialID != "appcred" || cfg.Global.ApplicationCredentialSecret != "secret" {
+		t.Errorf("incorrect application credential: %s %s", cfg.Global.ApplicationCredentialID, cfg.Global.ApplicationCredentialSecret)
+	}
+}
+
+func TestNewOpenStackTrustWithApplicationCredential(t *testing.T) {
+	cfg := Config{}
+	cfg.Global.AuthURL = "http://auth.url"
+	cfg.Global.TrustID = "mytrust"
+	cfg.Global.ApplicationCredentialID = "appcred"
+	cfg.Global.ApplicationCredentialSecret = "secret"
+
+	if _, err := NewOpenStack(cfg); err == nil {
+		t.Errorf("NewOpenStack should refuse a trust along with an application credential")
+	}
+}
+
 func TestCheckOpenStackOpts(t *testing.T) {
 	delay := MyDuration{60 * time.Second}
 	timeout := MyDuration{30 * time.Second}
@@ -398,10 +451,10 @@ func TestCheckOpenStackOpts(t *testing.T) {
 			openstackOpts: &OpenStack{
 				provider: nil,
 				metadataOpts: MetadataOpts{
-					SearchOrder: "value1,value2,value3",
+					SearchOrder: "value1,value2,value3,value4",
 				},
 			},
-			expectedError: fmt.Errorf("invalid value in section [Metadata] with key `search-order`. Value cannot contain more than 2 elements"),
+			expectedError: fmt.Errorf("invalid value in section [Metadata] with key `search-order`. Value cannot contain more than 3 elements"),
 		},
 		{
 			name: "test6",
@@ -412,7 +465,7 @@ func TestCheckOpenStackOpts(t *testing.T) {
 				},
 			},
 			expectedError: fmt.Errorf("invalid element %q found in section [Metadata] with key `search-order`."+
-				"Supported elements include %q and %q", "value1", metadata.ConfigDriveID, metadata.MetadataID),
+				"Supported elements include %q, %q and %q", "value1", metadata.ConfigDriveID, metadata.MetadataID, metadata.NovaID),
 		},
 		{
 			name: "test7",
@@ -454,6 +507,20 @@ func TestCheckOpenStackOpts(t *testing.T) {
 			},
 			expectedError: fmt.Errorf("monitor-timeout not set in cloud provider config"),
 		},
+		{
+			name: "test9",
+			openstackOpts: &OpenStack{
+				provider: nil,
+				lbOpts: LoadBalancerOpts{
+					LBVersion: "v2",
+					FlavorID:  "a7ae5d5a-d855-4f9a-b187-af66b53f4d04",
+				},
+				metadataOpts: MetadataOpts{
+					SearchOrder: metadata.ConfigDriveID,
+				},
+			},
+			expectedError: fmt.Errorf("flavor-id needs use-octavia in cloud provider config"),
+		},
 	}
 
 	for _, testcase := range tests {
@@ -495,17 +495,22 @@ func (os *OpenStack) GetDevicePathBySerialID(volumeID string) string {
 		fmt.Sprintf("wwn-0x%s", strings.Replace(volumeID, "-", "", -1)),
 	}
 
-	files, _ := ioutil.ReadDir("/dev/disk/by-id/")
+	files, _ := ioutil.ReadDir(diskByIDDir)
 
 	for _, f := range files {
 		for _, c := range candidateDeviceNodes {
 			if c == f.Name() {
-				klog.V(4).Infof("Found disk attached as %q; full devicepath: %s\n", f.Name(), path.Join("/dev/disk/by-id/", f.Name()))
-				return path.Join("/dev/disk/by-id/", f.Name())
+				klog.V(4).Infof("Found disk attached as %q; full devicepath: %s\n", f.Name(), path.Join(diskByIDDir, f.Name()))
+				return path.Join(diskByIDDir, f.Name())
 			}
 		}
 	}
 
+	// Other hypervisors and buses truncate or prefix the serial differently
+	if devicePath := getDevicePathFromDiskByID(volumeID); devicePath != "" {
+		return devicePath
+	}
+
 	klog.V(4).Infof("Failed to find device for the volumeID: %q by serial ID", volumeID)
 	return ""
 }
@@ -513,7 +518,8 @@ func (os *OpenStack) GetDevicePathBySerialID(volumeID string) string {
 func (os *OpenStack) getDevicePathFromInstanceMetadata(volumeID string) string {
 	// Nova Hyper-V hosts cannot override disk SCSI IDs. In order to locate
 	// volumes, we're querying the metadata service. Note that the Hyper-V
-	// driver will include device metadata for untagged volumes as well.
+	// driver will include device metadata for untagged volumes as well, and
+	// the libvirt driver for the volumes attached with a tag.
 	//
 	// We're avoiding using cached metadata (or the configdrive),
 	// relying on the metadata service.
@@ -532,15 +538,21 @@ func (os *OpenStack) getDevicePathFromInstanceMetadata(volumeID string) string {
 				"Found disk metadata for volumeID %q. Bus: %q, Address: %q",
 				volumeID, device.Bus, device.Address)
 
-			diskPattern := fmt.Sprintf(
-				"/dev/disk/by-path/*-%s-%s",
-				device.Bus, device.Address)
-			diskPaths, err := filepath.Glob(diskPattern)
-			if err != nil {
-				klog.Errorf(
-					"could not retrieve disk path for volumeID: %q. Error filepath.Glob(%q): %v",
-					volumeID, diskPattern, err)
-				return ""
+			// The by-path links of PCI disks start with the bus, those of
+			// SCSI disks with the path of their controller
+			var diskPaths []string
+			for _, diskPattern := range []string{
+				fmt.Sprintf("/dev/disk/by-path/%s-%s", device.Bus, device.Address),
+				fmt.Sprintf("/dev/disk/by-path/*-%s-%s", device.Bus, device.Address),
+			} {
+				matches, err := filepath.Glob(diskPattern)
+				if err != nil {
+					klog.Errorf(
+						"could not retrieve disk path for volumeID: %q. Error filepath.Glob(%q): %v",
+						volumeID, diskPattern, err)
+					return ""
+				}
+				diskPaths = append(diskPaths, matches...)
 			}
 
 			if len(diskPaths) == 1 {
@@ -568,7 +580,14 @@ func (os *OpenStack) GetDevicePath(volumeID string) (string, error) {
 	}
 
 	var devicePath string
+	attempt := 0
 	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		// The links of a disk still appearing may not be created yet
+		if attempt > 0 {
+			triggerUdev()
+		}
+		attempt++
+
 		devicePath = os.GetDevicePathBySerialID(volumeID)
 		if devicePath != "" {
 			return true, nil
@@ -577,6 +596,10 @@ func (os *OpenStack) GetDevicePath(volumeID string) (string, error) {
 		if devicePath != "" {
 			return true, nil
 		}
+		devicePath = getDevicePathFromSysfs(volumeID)
+		if devicePath != "" {
+			return true, nil
+		}
 		return false, nil
 	})
 
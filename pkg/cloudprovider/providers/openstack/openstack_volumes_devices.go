@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+
+	utilexec "k8s.io/utils/exec"
+
+	"k8s.io/klog"
+)
+
+// minSerialLength is the shortest disk serial identifying a volume. Nova
+// truncates the serial of virtio-blk disks to 20 characters, 17 of the volume
+// ID without its dashes.
+const minSerialLength = 16
+
+var (
+	// The device directories, replaced in the tests
+	diskByIDDir = "/dev/disk/by-id/"
+	sysBlockDir = "/sys/block/"
+	devDir      = "/dev/"
+
+	// triggerUdev asks udev to process the block devices again, so the links
+	// of a disk still appearing get created
+	triggerUdev = func() {
+		out, err := utilexec.New().Command("udevadm", "trigger", "--subsystem-match=block").CombinedOutput()
+		if err != nil {
+			klog.V(4).Infof("Failed to run udevadm trigger: %v: %s", err, out)
+		}
+	}
+)
+
+// compactSerial returns serial lower-cased and without dashes, as hypervisors
+// expose the volume ID in both forms.
+func compactSerial(serial string) string {
+	return strings.Replace(strings.ToLower(strings.TrimSpace(serial)), "-", "", -1)
+}
+
+// serialMatches returns whether a disk serial, possibly truncated, is the ID
+// of the volume volumeID.
+func serialMatches(serial, volumeID string) bool {
+	s := compactSerial(serial)
+	return len(s) >= minSerialLength && strings.HasPrefix(compactSerial(volumeID), s)
+}
+
+// byIDSerial returns the serial of a /dev/disk/by-id link name, e.g. the
+// volume ID part of virtio-<serial>, scsi-0QEMU_QEMU_HARDDISK_<serial> or
+// wwn-0x<serial>, "" for the links of partitions.
+func byIDSerial(name string) string {
+	if strings.Contains(name, "-part") {
+		return ""
+	}
+	i := strings.Index(name, "-")
+	if i < 0 {
+		return ""
+	}
+	serial := name[i+1:]
+	if j := strings.LastIndex(serial, "_"); j >= 0 {
+		serial = serial[j+1:]
+	}
+	return strings.TrimPrefix(serial, "0x")
+}
+
+// getDevicePathFromDiskByID returns the /dev/disk/by-id link of the disk whose
+// serial is volumeID, however the hypervisor truncated or prefixed it.
+func getDevicePathFromDiskByID(volumeID string) string {
+	files, _ := ioutil.ReadDir(diskByIDDir)
+	for _, f := range files {
+		if serialMatches(byIDSerial(f.Name()), volumeID) {
+			klog.V(4).Infof("Found disk attached as %q; full devicepath: %s", f.Name(), path.Join(diskByIDDir, f.Name()))
+			return path.Join(diskByIDDir, f.Name())
+		}
+	}
+	return ""
+}
+
+// getDevicePathFromSysfs returns the device of the disk whose serial in sysfs
+// is volumeID, for the disks without a /dev/disk/by-id link, e.g. when the
+// udev rules do not create one for their bus.
+func getDevicePathFromSysfs(volumeID string) string {
+	disks, _ := ioutil.ReadDir(sysBlockDir)
+	for _, d := range disks {
+		name := d.Name()
+		// Device mapper, loop and ram disks have no serial of their own
+		if strings.HasPrefix(name, "dm-") || strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		// virtio-blk serves serial, NVMe device/serial and SCSI device/wwid,
+		// the serial being the last word of the wwid
+		for _, file := range []string{"serial", "device/serial", "device/wwid"} {
+			data, err := ioutil.ReadFile(path.Join(sysBlockDir, name, file))
+			if err != nil {
+				continue
+			}
+			words := strings.Fields(strings.Replace(string(data), ".", " ", -1))
+			if len(words) > 0 && serialMatches(words[len(words)-1], volumeID) {
+				klog.V(4).Infof("Found disk %s with the serial of volume %s in sysfs", name, volumeID)
+				return path.Join(devDir, name)
+			}
+		}
+	}
+	return ""
+}
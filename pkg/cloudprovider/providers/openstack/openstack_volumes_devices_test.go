@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+const testVolumeID = "d3f1a2b4-1c2d-4e5f-8a9b-0c1d2e3f4a5b"
+
+func TestSerialMatches(t *testing.T) {
+	tests := []struct {
+		serial  string
+		matches bool
+	}{
+		{serial: testVolumeID, matches: true},
+		{serial: testVolumeID[:20], matches: true},
+		{serial: "d3f1a2b41c2d4e5f8a9b0c1d2e3f4a5b", matches: true},
+		{serial: "D3F1A2B41C2D4E5F8A9B\n", matches: true},
+		{serial: "d3f1a2b4", matches: false},
+		{serial: "", matches: false},
+		{serial: "e3f1a2b4-1c2d-4e5f-8a9b-0c1d2e3f4a5b", matches: false},
+	}
+	for _, test := range tests {
+		if serialMatches(test.serial, testVolumeID) != test.matches {
+			t.Errorf("expected serial %q to match %v", test.serial, test.matches)
+		}
+	}
+}
+
+func TestByIDSerial(t *testing.T) {
+	tests := map[string]string{
+		"virtio-" + testVolumeID[:20]:                   testVolumeID[:20],
+		"scsi-0QEMU_QEMU_HARDDISK_" + testVolumeID[:20]: testVolumeID[:20],
+		"wwn-0xd3f1a2b41c2d4e5f8a9b0c1d2e3f4a5b":        "d3f1a2b41c2d4e5f8a9b0c1d2e3f4a5b",
+		"nvme-QEMU_NVMe_Ctrl_" + testVolumeID:           testVolumeID,
+		"virtio-" + testVolumeID[:20] + "-part1":        "",
+		"no_bus":                                        "",
+	}
+	for name, serial := range tests {
+		if got := byIDSerial(name); got != serial {
+			t.Errorf("expected serial %q of %q, got %q", serial, name, got)
+		}
+	}
+}
+
+func TestGetDevicePathFallbacks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldByID, oldSysBlock, oldDev := diskByIDDir, sysBlockDir, devDir
+	defer func() { diskByIDDir, sysBlockDir, devDir = oldByID, oldSysBlock, oldDev }()
+	diskByIDDir = path.Join(dir, "by-id")
+	sysBlockDir = path.Join(dir, "block")
+	devDir = "/dev"
+
+	write := func(name, data string) {
+		if err := os.MkdirAll(path.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// No link nor serial
+	cloud := &OpenStack{}
+	if devicePath := cloud.GetDevicePathBySerialID(testVolumeID); devicePath != "" {
+		t.Errorf("expected no device, got %s", devicePath)
+	}
+	if devicePath := getDevicePathFromSysfs(testVolumeID); devicePath != "" {
+		t.Errorf("expected no device, got %s", devicePath)
+	}
+
+	// A link of a bus without a hard-coded prefix
+	write(path.Join(diskByIDDir, "nvme-QEMU_NVMe_Ctrl_"+testVolumeID), "")
+	write(path.Join(diskByIDDir, "nvme-QEMU_NVMe_Ctrl_"+testVolumeID+"-part1"), "")
+	if devicePath := cloud.GetDevicePathBySerialID(testVolumeID); devicePath != path.Join(diskByIDDir, "nvme-QEMU_NVMe_Ctrl_"+testVolumeID) {
+		t.Errorf("unexpected device %s", devicePath)
+	}
+
+	// The serials in sysfs of virtio-blk and SCSI disks
+	write(path.Join(sysBlockDir, "dm-0", "serial"), testVolumeID[:20])
+	write(path.Join(sysBlockDir, "vda", "serial"), "")
+	write(path.Join(sysBlockDir, "sdb", "device", "wwid"), "t10.ATA     QEMU HARDDISK                           "+testVolumeID[:20]+"\n")
+	if devicePath := getDevicePathFromSysfs(testVolumeID); devicePath != "/dev/sdb" {
+		t.Errorf("expected /dev/sdb, got %s", devicePath)
+	}
+	write(path.Join(sysBlockDir, "vdc", "serial"), testVolumeID[:20])
+	if devicePath := getDevicePathFromSysfs("e3f1a2b4-1c2d-4e5f-8a9b-0c1d2e3f4a5b"); devicePath != "" {
+		t.Errorf("expected no device of another volume, got %s", devicePath)
+	}
+}
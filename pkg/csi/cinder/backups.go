@@ -0,0 +1,212 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"strconv"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog"
+)
+
+const (
+	// snapshotTypeParameter is the VolumeSnapshotClass parameter choosing
+	// whether a VolumeSnapshot is a Cinder snapshot or backup
+	snapshotTypeParameter = "type"
+	snapshotTypeSnapshot  = "snapshot"
+	snapshotTypeBackup    = "backup"
+)
+
+// SetSnapshotBackups allows VolumeSnapshotClasses to take Cinder backups
+// instead of snapshots with the backup type parameter, for clouds without
+// snapshots or to keep the VolumeSnapshots outside of the volume backend.
+// The snapshot IDs CreateVolume, DeleteSnapshot and ListSnapshots do not find
+// among the snapshots are then looked for among the backups.
+func (d *CinderDriver) SetSnapshotBackups(allow bool) {
+	if allow {
+		klog.Infof("Allowing Cinder backups as VolumeSnapshots")
+	}
+	d.snapshotBackups = allow
+}
+
+// backupRequested returns whether the parameters of a CreateSnapshot request
+// ask for a backup.
+func (d *CinderDriver) backupRequested(params map[string]string) (bool, error) {
+	switch t := params[snapshotTypeParameter]; t {
+	case "", snapshotTypeSnapshot:
+		return false, nil
+	case snapshotTypeBackup:
+		if !d.snapshotBackups {
+			return false, status.Errorf(codes.InvalidArgument, "snapshot type %s is not allowed, see --snapshot-backups", t)
+		}
+		return true, nil
+	default:
+		return false, status.Errorf(codes.InvalidArgument, "invalid snapshot type %q, must be %s or %s", t, snapshotTypeSnapshot, snapshotTypeBackup)
+	}
+}
+
+// createBackup is CreateSnapshot of a backup. Backups take longer than
+// snapshots, so it does not wait for the backup to be available: the
+// external-snapshotter checks again until it is ready to use.
+func (cs *controllerServer) createBackup(cloud openstack.IOpenStack, name, volumeID string, metadata map[string]string) (*csi.CreateSnapshotResponse, error) {
+	backups, err := cloud.GetBackupsByNameAndVolumeID(name, volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to query for existing backups of volume %s: %v", volumeID, err)
+	}
+
+	var backup *openstack.Backup
+	if len(backups) == 1 {
+		backup = &backups[0]
+
+		klog.V(3).Infof("Found existing backup %s on %s", name, volumeID)
+	} else if len(backups) > 1 {
+		klog.V(3).Infof("found multiple existing backups with selected name (%s) during create", name)
+		return nil, status.Errorf(codes.Internal, "multiple backups reported by Cinder with same name %s", name)
+	} else {
+		if cs.Driver.encryption != nil {
+			metadata, err = cs.markEncryptedSnapshot(cloud, volumeID, metadata)
+			if err != nil {
+				klog.V(3).Infof("Failed to check the encryption of volume %s: %v", volumeID, err)
+				return nil, err
+			}
+		}
+
+		backup, err = cloud.CreateBackup(name, volumeID, &metadata)
+		if err != nil {
+			klog.V(3).Infof("Failed to Create backup: %v", err)
+			return nil, cloudError(err)
+		}
+
+		klog.V(3).Infof("CreateBackup %s on %s", name, volumeID)
+	}
+
+	if backup.Status == openstack.BackupErrorStatus {
+		return nil, status.Errorf(codes.Internal, "backup %s of volume %s failed, delete the VolumeSnapshot to retry", backup.ID, volumeID)
+	}
+	if region, ok := cs.volumeRegions.get(volumeID); ok {
+		cs.volumeRegions.set(backup.ID, region)
+	}
+	return &csi.CreateSnapshotResponse{Snapshot: newCSIBackup(backup)}, nil
+}
+
+// newCSIBackup returns the CSI snapshot of a Cinder backup, ready to use once
+// available.
+func newCSIBackup(backup *openstack.Backup) *csi.Snapshot {
+	ctime, err := ptypes.TimestampProto(backup.CreatedAt)
+	if err != nil {
+		klog.Errorf("Error to convert time to timestamp: %v", err)
+	}
+	return &csi.Snapshot{
+		SizeBytes:      int64(backup.Size * 1024 * 1024 * 1024),
+		SnapshotId:     backup.ID,
+		SourceVolumeId: backup.VolumeID,
+		CreationTime:   ctime,
+		ReadyToUse:     backup.Status == openstack.BackupAvailableStatus,
+	}
+}
+
+// sourceBackup returns the backup CreateVolume restores snapshotID from, nil
+// when snapshotID is a snapshot.
+func (cs *controllerServer) sourceBackup(cloud openstack.IOpenStack, snapshotID string) (*openstack.Backup, error) {
+	_, err := cloud.GetSnapshotByID(snapshotID)
+	if err == nil {
+		return nil, nil
+	}
+	if !cpoerrors.IsNotFound(err) {
+		return nil, status.Errorf(codes.Internal, "failed to get snapshot %s: %v", snapshotID, err)
+	}
+
+	backup, err := cloud.GetBackupByID(snapshotID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "CreateVolume source snapshot %s not found", snapshotID)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get backup %s: %v", snapshotID, err)
+	}
+	return backup, nil
+}
+
+// listBackup is ListSnapshots of the backup backupID, of the source volume
+// volumeID if not "".
+func (cs *controllerServer) listBackup(cloud openstack.IOpenStack, backupID, volumeID string) (*csi.ListSnapshotsResponse, error) {
+	backup, err := cloud.GetBackupByID(backupID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		return nil, status.Errorf(codes.Internal, "Failed to get backup %s: %v", backupID, err)
+	}
+	if volumeID != "" && volumeID != backup.VolumeID {
+		return &csi.ListSnapshotsResponse{}, nil
+	}
+	return &csi.ListSnapshotsResponse{
+		Entries: []*csi.ListSnapshotsResponse_Entry{{Snapshot: newCSIBackup(backup)}},
+	}, nil
+}
+
+// listSnapshotsAndBackups is ListSnapshots with the available backups listed
+// after the snapshots. The offset of a page counts both.
+func (cs *controllerServer) listSnapshotsAndBackups(cloud openstack.IOpenStack, limit, offset int, filters map[string]string) (*csi.ListSnapshotsResponse, error) {
+	snaps, err := cloud.ListSnapshots(0, 0, filters)
+	if err != nil {
+		klog.V(3).Infof("Failed to ListSnapshots: %v", err)
+		return nil, err
+	}
+	backups, err := cloud.ListBackups(filters["VolumeID"])
+	if err != nil {
+		klog.V(3).Infof("Failed to ListBackups: %v", err)
+		return nil, err
+	}
+
+	var entries []*csi.ListSnapshotsResponse_Entry
+	for i := range snaps {
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: newCSISnapshot(&snaps[i])})
+	}
+	for i := range backups {
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: newCSIBackup(&backups[i])})
+	}
+	if offset >= len(entries) {
+		return &csi.ListSnapshotsResponse{}, nil
+	}
+	entries = entries[offset:]
+	nextToken := ""
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+		nextToken = strconv.Itoa(offset + limit)
+	}
+	return &csi.ListSnapshotsResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
+}
+
+// snapshotMetadata returns the metadata of the snapshot or backup of a
+// CreateSnapshot request, its parameters but the type.
+func snapshotMetadata(params map[string]string) map[string]string {
+	metadata := map[string]string{}
+	for k, v := range params {
+		if k != snapshotTypeParameter {
+			metadata[k] = v
+		}
+	}
+	return metadata
+}
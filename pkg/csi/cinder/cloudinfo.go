@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/klog"
+)
+
+// The keys identifying the cloud in the GetPluginInfo manifest, the startup
+// banner and the metric labels.
+const (
+	cloudInfoCloud   = "cloud"
+	cloudInfoRegion  = "region"
+	cloudInfoProject = "project"
+	cloudInfoZone    = "zone"
+	// cloudInfoExtraRegions are the extra-region of the cloud config, comma
+	// separated
+	cloudInfoExtraRegions = "extra_regions"
+)
+
+// loadCloudInfo reads which cloud the driver talks to and logs it. The zone
+// is only known when running on an instance of the cloud.
+func (d *CinderDriver) loadCloudInfo() {
+	cloud, err := openstack.GetCloudInfo()
+	if err != nil {
+		klog.Warningf("Failed to read the cloud config: %v", err)
+	}
+	d.cloud = cloud
+
+	if d.runMode != RunModeExternal {
+		zone, err := getAvailabilityZoneMetadataService()
+		if err != nil {
+			klog.Warningf("Failed to get the availability zone from the metadata service: %v", err)
+		}
+		d.zone = zone
+	}
+
+	regions, err := openstack.GetExtraRegions()
+	if err != nil {
+		klog.Warningf("Failed to get the extra regions: %v", err)
+	}
+	d.extraRegions = regions
+
+	klog.Infof("Starting %s version=%s run-mode=%s cluster=%q %s=%q %s=%q %s=%q %s=%q %s=%q",
+		d.name, d.version, d.runMode, d.cluster,
+		cloudInfoCloud, d.cloud.AuthURLHost, cloudInfoRegion, d.cloud.Region,
+		cloudInfoProject, d.cloud.Project, cloudInfoZone, d.zone,
+		cloudInfoExtraRegions, strings.Join(d.extraRegions, ","))
+}
+
+// cloudLabels returns the labels identifying the cloud. All the keys are
+// always set so that every replica exports the same label names.
+func (d *CinderDriver) cloudLabels() map[string]string {
+	return map[string]string{
+		cloudInfoCloud:   d.cloud.AuthURLHost,
+		cloudInfoRegion:  d.cloud.Region,
+		cloudInfoProject: d.cloud.Project,
+		cloudInfoZone:    d.zone,
+
+		cloudInfoExtraRegions: strings.Join(d.extraRegions, ","),
+	}
+}
+
+// cloudManifest returns the GetPluginInfo manifest, leaving out what is not
+// known.
+func (d *CinderDriver) cloudManifest() map[string]string {
+	manifest := map[string]string{}
+	for k, v := range d.cloudLabels() {
+		if v != "" {
+			manifest[k] = v
+		}
+	}
+	if len(manifest) == 0 {
+		return nil
+	}
+	return manifest
+}
+
+// metricLabels returns the cloud labels added to the driver metrics.
+func (d *CinderDriver) metricLabels() prometheus.Labels {
+	return prometheus.Labels(d.cloudLabels())
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/klog"
+)
+
+const (
+	// cloudParametersTTL is how long the volume types and availability
+	// zones of a region are cached
+	cloudParametersTTL = 10 * time.Minute
+
+	// cloudParametersMinRefresh is how long a parameter missing from the
+	// cache waits for the lists to be refreshed, so that invalid
+	// StorageClasses do not list them on each retry
+	cloudParametersMinRefresh = time.Minute
+)
+
+// cloudParameters caches the volume types and availability zones of the
+// regions, to check the type and availability StorageClass parameters
+// against them.
+type cloudParameters struct {
+	mu sync.Mutex
+	// cached are the lists of each region, "" for the region of the cloud
+	// config
+	cached map[string]*cachedParameters
+	now    func() time.Time
+}
+
+type cachedParameters struct {
+	// volumeTypes and zones are nil when they could not be listed
+	volumeTypes []openstack.VolumeType
+	zones       []string
+	listed      time.Time
+}
+
+func newCloudParameters() *cloudParameters {
+	return &cloudParameters{cached: map[string]*cachedParameters{}, now: time.Now}
+}
+
+// SetValidateParameters makes CreateVolume check the type and availability
+// StorageClass parameters against the volume types and availability zones
+// of the cloud.
+func (d *CinderDriver) SetValidateParameters(validate bool) {
+	if validate {
+		klog.Infof("Validating the volume type and availability zone parameters")
+		d.cloudParameters = newCloudParameters()
+	} else {
+		d.cloudParameters = nil
+	}
+}
+
+// validate fails with InvalidArgument when volType is neither the name nor
+// the ID of a volume type of the region, or availability is not an
+// available zone. Empty parameters are not checked, nor are lists that
+// could not be fetched.
+func (p *cloudParameters) validate(cloud openstack.IOpenStack, region, volType, availability string) error {
+	if volType == "" && availability == "" {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c := p.cached[region]
+	if c == nil || !p.now().Before(c.listed.Add(cloudParametersTTL)) {
+		c = p.refresh(cloud, region)
+	}
+	if !c.valid(volType, availability) && !p.now().Before(c.listed.Add(cloudParametersMinRefresh)) {
+		// Created since the lists were cached
+		c = p.refresh(cloud, region)
+	}
+
+	if volType != "" && c.volumeTypes != nil && !c.hasVolumeType(volType) {
+		names := make([]string, 0, len(c.volumeTypes))
+		for _, t := range c.volumeTypes {
+			names = append(names, t.Name)
+		}
+		sort.Strings(names)
+		return status.Errorf(codes.InvalidArgument, "volume type %q does not exist, available: %s", volType, listOrNone(names))
+	}
+	if availability != "" && c.zones != nil && !c.hasZone(availability) {
+		zones := append([]string(nil), c.zones...)
+		sort.Strings(zones)
+		return status.Errorf(codes.InvalidArgument, "availability zone %q does not exist, available: %s", availability, listOrNone(zones))
+	}
+	return nil
+}
+
+// refresh lists the volume types and availability zones of region. A list
+// that fails is not checked until the next refresh.
+func (p *cloudParameters) refresh(cloud openstack.IOpenStack, region string) *cachedParameters {
+	c := &cachedParameters{listed: p.now()}
+
+	volumeTypes, err := cloud.ListVolumeTypes()
+	if err != nil {
+		klog.Warningf("Failed to list the volume types of region %q, not validating the type parameter: %v", region, err)
+	} else {
+		c.volumeTypes = volumeTypes
+	}
+
+	zones, err := cloud.ListAvailabilityZones()
+	if err != nil {
+		klog.Warningf("Failed to list the availability zones of region %q, not validating the availability parameter: %v", region, err)
+	} else {
+		c.zones = zones
+	}
+
+	p.cached[region] = c
+	return c
+}
+
+// valid returns whether the parameters are in the lists, or cannot be
+// checked.
+func (c *cachedParameters) valid(volType, availability string) bool {
+	return (volType == "" || c.volumeTypes == nil || c.hasVolumeType(volType)) &&
+		(availability == "" || c.zones == nil || c.hasZone(availability))
+}
+
+func (c *cachedParameters) hasVolumeType(volType string) bool {
+	for _, t := range c.volumeTypes {
+		if t.Name == volType || t.ID == volType {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cachedParameters) hasZone(availability string) bool {
+	for _, zone := range c.zones {
+		if zone == availability {
+			return true
+		}
+	}
+	return false
+}
+
+func listOrNone(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package connector connects the Cinder volumes exported over iSCSI or Fibre
+// Channel to the local host, as os-brick does for Nova, for the nodes whose
+// volumes are not attached by a hypervisor such as Ironic bare-metal nodes.
+package connector
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+const (
+	// ProtocolISCSI and ProtocolFibreChannel are the driver_volume_type of
+	// connection infos served by the connectors
+	ProtocolISCSI        = "iscsi"
+	ProtocolFibreChannel = "fibre_channel"
+)
+
+var (
+	// The host paths, replaced in the tests
+	byPathDir          = "/dev/disk/by-path"
+	devDir             = "/dev"
+	sysBlockDir        = "/sys/block"
+	sysClassDir        = "/sys/class"
+	iscsiInitiatorFile = "/etc/iscsi/initiatorname.iscsi"
+
+	// deviceBackoff is how long a connector waits for the devices of a
+	// volume to appear, about a minute
+	deviceBackoff = wait.Backoff{
+		Duration: 1 * time.Second,
+		Factor:   1.2,
+		Steps:    12,
+	}
+)
+
+// Connector connects the volumes of a protocol to the local host.
+type Connector interface {
+	// Connect returns the local device of the volume of the connection info
+	// data, connected to the host once it appears.
+	Connect(data map[string]interface{}) (string, error)
+	// Disconnect removes the local devices of the volume of data, and the
+	// sessions it was the last one using.
+	Disconnect(data map[string]interface{}) error
+}
+
+// New returns the connector of protocol, the driver_volume_type of the
+// connection info of a volume. multipath uses all the paths of the volume
+// served by the backend, through the multipath device.
+func New(protocol string, multipath bool) (Connector, error) {
+	exec := mount.NewOsExec()
+	switch protocol {
+	case ProtocolISCSI:
+		return &iscsiConnector{exec: exec, multipath: multipath}, nil
+	case ProtocolFibreChannel:
+		return &fcConnector{exec: exec, multipath: multipath}, nil
+	}
+	return nil, fmt.Errorf("unsupported volume connection protocol %q, must be %q or %q", protocol, ProtocolISCSI, ProtocolFibreChannel)
+}
+
+// Properties describe the local host to Cinder initialize_connection, for
+// the backend to export the volume to it.
+type Properties struct {
+	Host      string
+	IP        string
+	Initiator string
+	Wwpns     []string
+	Wwnns     []string
+	Multipath bool
+}
+
+// LocalProperties returns the properties of the local host: its iSCSI
+// initiator and the port and node names of its Fibre Channel HBAs, the ones
+// it lacks are left empty.
+func LocalProperties(multipath bool) (Properties, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return Properties{}, err
+	}
+	p := Properties{Host: host, Multipath: multipath}
+
+	if initiator, err := readInitiatorName(iscsiInitiatorFile); err == nil {
+		p.Initiator = initiator
+	} else if !os.IsNotExist(err) {
+		return Properties{}, err
+	}
+
+	hosts, _ := ioutil.ReadDir(filepath.Join(sysClassDir, "fc_host"))
+	for _, h := range hosts {
+		dir := filepath.Join(sysClassDir, "fc_host", h.Name())
+		if wwpn := readWWN(filepath.Join(dir, "port_name")); wwpn != "" {
+			p.Wwpns = append(p.Wwpns, wwpn)
+		}
+		if wwnn := readWWN(filepath.Join(dir, "node_name")); wwnn != "" {
+			p.Wwnns = append(p.Wwnns, wwnn)
+		}
+	}
+
+	if p.Initiator == "" && len(p.Wwpns) == 0 {
+		return Properties{}, fmt.Errorf("host %s has neither an iSCSI initiator in %s nor a Fibre Channel HBA", host, iscsiInitiatorFile)
+	}
+	return p, nil
+}
+
+// readInitiatorName returns the InitiatorName of an open-iscsi initiator
+// file.
+func readInitiatorName(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "InitiatorName=") {
+			return strings.TrimPrefix(line, "InitiatorName="), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no InitiatorName in %s", path)
+}
+
+// readWWN returns a world wide name of sysfs without its 0x prefix.
+func readWWN(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(data)), "0x")
+}
+
+// decode decodes the connection info data into properties.
+func decode(data map[string]interface{}, properties interface{}) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(buf, properties); err != nil {
+		return fmt.Errorf("invalid connection info: %v", err)
+	}
+	return nil
+}
+
+// stringList decodes a string or a list of strings, as Cinder serves either
+// for some connection info fields.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// waitForDevices waits for at least one of the device links of globs to
+// appear, running rescan before each new look, and returns the devices they
+// link to.
+func waitForDevices(globs []string, rescan func()) ([]string, error) {
+	var devices []string
+	err := wait.ExponentialBackoff(deviceBackoff, func() (bool, error) {
+		devices = findDevices(globs)
+		if len(devices) > 0 {
+			return true, nil
+		}
+		rescan()
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil, fmt.Errorf("no device of %v appeared", globs)
+	}
+	return devices, err
+}
+
+// findDevices returns the devices the links matching globs link to, e.g.
+// sdb.
+func findDevices(globs []string) []string {
+	seen := map[string]bool{}
+	var devices []string
+	for _, glob := range globs {
+		links, _ := filepath.Glob(glob)
+		for _, link := range links {
+			target, err := filepath.EvalSymlinks(link)
+			if err != nil {
+				continue
+			}
+			device := filepath.Base(target)
+			if !seen[device] {
+				seen[device] = true
+				devices = append(devices, device)
+			}
+		}
+	}
+	return devices
+}
+
+// multipathDevice returns the device mapper device holding the devices, ""
+// when multipathd has not created it.
+func multipathDevice(devices []string) string {
+	for _, device := range devices {
+		holders, _ := ioutil.ReadDir(filepath.Join(sysBlockDir, device, "holders"))
+		for _, h := range holders {
+			if strings.HasPrefix(h.Name(), "dm-") {
+				return h.Name()
+			}
+		}
+	}
+	return ""
+}
+
+// connectedDevice returns the device to use of the devices of a volume:
+// with multipath the device mapper device holding them once it appears, the
+// first one otherwise.
+func connectedDevice(devices []string, multipath bool) (string, error) {
+	if !multipath {
+		return filepath.Join(devDir, devices[0]), nil
+	}
+	var dm string
+	err := wait.ExponentialBackoff(deviceBackoff, func() (bool, error) {
+		dm = multipathDevice(devices)
+		return dm != "", nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return "", fmt.Errorf("no multipath device of %v appeared, is multipathd running?", devices)
+	}
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(devDir, dm), nil
+}
+
+// removeDevices flushes the multipath device holding the devices, if any,
+// and removes the devices from the host.
+func removeDevices(exec mount.Exec, devices []string) error {
+	if dm := multipathDevice(devices); dm != "" {
+		if out, err := exec.Run("multipath", "-f", dm); err != nil {
+			return fmt.Errorf("failed to flush multipath device %s: %v: %s", dm, err, out)
+		}
+	}
+	for _, device := range devices {
+		if out, err := exec.Run("blockdev", "--flushbufs", filepath.Join(devDir, device)); err != nil {
+			klog.Warningf("Failed to flush the buffers of %s: %v: %s", device, err, out)
+		}
+		if err := ioutil.WriteFile(filepath.Join(sysBlockDir, device, "device", "delete"), []byte("1"), 0200); err != nil {
+			return fmt.Errorf("failed to remove device %s: %v", device, err)
+		}
+		klog.V(4).Infof("Removed device %s", device)
+	}
+	return nil
+}
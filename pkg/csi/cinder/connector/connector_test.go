@@ -0,0 +1,220 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connector
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	fakeIQN    = "iqn.2010-10.org.openstack:volume-1"
+	fakePortal = "10.0.0.1:3260"
+	fakeWWN    = "500a0981891b8dc5"
+)
+
+// fakeExec records the commands run and answers them through a function.
+type fakeExec struct {
+	ran []string
+	run func(cmd string) ([]byte, error)
+}
+
+func (f *fakeExec) Run(cmd string, args ...string) ([]byte, error) {
+	line := strings.Join(append([]string{cmd}, args...), " ")
+	f.ran = append(f.ran, line)
+	if f.run != nil {
+		return f.run(line)
+	}
+	return nil, nil
+}
+
+// fakeHost replaces the host paths with a temporary directory, and returns a
+// function adding the device of a by-path link.
+func fakeHost(t *testing.T) (func(link, device string), func()) {
+	dir, err := ioutil.TempDir("", "connector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldByPath, oldDev, oldSysBlock, oldSysClass, oldInitiator, oldBackoff := byPathDir, devDir, sysBlockDir, sysClassDir, iscsiInitiatorFile, deviceBackoff
+	byPathDir = filepath.Join(dir, "dev/disk/by-path")
+	devDir = filepath.Join(dir, "dev")
+	sysBlockDir = filepath.Join(dir, "sys/block")
+	sysClassDir = filepath.Join(dir, "sys/class")
+	iscsiInitiatorFile = filepath.Join(dir, "etc/iscsi/initiatorname.iscsi")
+	deviceBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}
+	for _, d := range []string{byPathDir, sysBlockDir} {
+		assert.NoError(t, os.MkdirAll(d, 0755))
+	}
+
+	addDevice := func(link, device string) {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(devDir, device), nil, 0644))
+		assert.NoError(t, os.Symlink(filepath.Join(devDir, device), filepath.Join(byPathDir, link)))
+		assert.NoError(t, os.MkdirAll(filepath.Join(sysBlockDir, device, "device"), 0755))
+		assert.NoError(t, os.MkdirAll(filepath.Join(sysBlockDir, device, "holders"), 0755))
+	}
+	return addDevice, func() {
+		byPathDir, devDir, sysBlockDir, sysClassDir, iscsiInitiatorFile, deviceBackoff = oldByPath, oldDev, oldSysBlock, oldSysClass, oldInitiator, oldBackoff
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(ProtocolISCSI, false)
+	assert.NoError(t, err)
+	_, err = New(ProtocolFibreChannel, true)
+	assert.NoError(t, err)
+	_, err = New("rbd", false)
+	assert.Error(t, err)
+}
+
+func TestLocalProperties(t *testing.T) {
+	_, cleanup := fakeHost(t)
+	defer cleanup()
+
+	_, err := LocalProperties(false)
+	assert.Error(t, err, "a host without initiator nor HBA cannot be connected")
+
+	assert.NoError(t, os.MkdirAll(filepath.Dir(iscsiInitiatorFile), 0755))
+	assert.NoError(t, ioutil.WriteFile(iscsiInitiatorFile, []byte("## DO NOT EDIT\nInitiatorName=iqn.1993-08.org.debian:01:node-1\n"), 0644))
+	host := filepath.Join(sysClassDir, "fc_host", "host3")
+	assert.NoError(t, os.MkdirAll(host, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(host, "port_name"), []byte("0x10000090fa1b2c3d\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(host, "node_name"), []byte("0x20000090fa1b2c3d\n"), 0644))
+
+	p, err := LocalProperties(true)
+	assert.NoError(t, err)
+	assert.Equal(t, "iqn.1993-08.org.debian:01:node-1", p.Initiator)
+	assert.Equal(t, []string{"10000090fa1b2c3d"}, p.Wwpns)
+	assert.Equal(t, []string{"20000090fa1b2c3d"}, p.Wwnns)
+	assert.True(t, p.Multipath)
+}
+
+func TestISCSIConnect(t *testing.T) {
+	addDevice, cleanup := fakeHost(t)
+	defer cleanup()
+	addDevice("ip-"+fakePortal+"-iscsi-"+fakeIQN+"-lun-1", "sdb")
+
+	exec := &fakeExec{run: func(cmd string) ([]byte, error) {
+		if strings.HasSuffix(cmd, "--login") {
+			return []byte("iscsiadm: default: 1 session requested, but 1 already present."), errors.New("exit status 15")
+		}
+		return nil, nil
+	}}
+	c := &iscsiConnector{exec: exec}
+	device, err := c.Connect(map[string]interface{}{
+		"target_portal": fakePortal,
+		"target_iqn":    fakeIQN,
+		"target_lun":    float64(1),
+		"auth_method":   "CHAP",
+		"auth_username": "user",
+		"auth_password": "secret",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(devDir, "sdb"), device)
+
+	node := "iscsiadm -m node -T " + fakeIQN + " -p " + fakePortal
+	assert.Equal(t, []string{
+		node + " --op new",
+		node + " --op update -n node.session.auth.authmethod -v CHAP",
+		node + " --op update -n node.session.auth.username -v user",
+		node + " --op update -n node.session.auth.password -v secret",
+		node + " --login",
+		// The host is already logged in to the target of another LUN
+		node + " --rescan",
+	}, exec.ran)
+
+	_, err = c.Connect(map[string]interface{}{"target_lun": float64(1)})
+	assert.Error(t, err)
+}
+
+func TestISCSIMultipath(t *testing.T) {
+	addDevice, cleanup := fakeHost(t)
+	defer cleanup()
+	addDevice("ip-"+fakePortal+"-iscsi-"+fakeIQN+"-lun-1", "sdb")
+	addDevice("ip-10.0.0.2:3260-iscsi-"+fakeIQN+"-lun-1", "sdc")
+	// Another volume of the target of the first portal
+	addDevice("ip-"+fakePortal+"-iscsi-"+fakeIQN+"-lun-2", "sdd")
+	for _, device := range []string{"sdb", "sdc"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(sysBlockDir, device, "holders", "dm-0"), 0755))
+	}
+
+	data := map[string]interface{}{
+		"target_portal":  fakePortal,
+		"target_iqn":     fakeIQN,
+		"target_lun":     float64(1),
+		"target_portals": []interface{}{fakePortal, "10.0.0.2:3260"},
+		"target_iqns":    []interface{}{fakeIQN, fakeIQN},
+		"target_luns":    []interface{}{float64(1), float64(1)},
+	}
+	exec := &fakeExec{}
+	c := &iscsiConnector{exec: exec, multipath: true}
+	device, err := c.Connect(data)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(devDir, "dm-0"), device)
+
+	exec.ran = nil
+	assert.NoError(t, c.Disconnect(data))
+	assert.Equal(t, []string{
+		"multipath -f dm-0",
+		"blockdev --flushbufs " + filepath.Join(devDir, "sdb"),
+		"blockdev --flushbufs " + filepath.Join(devDir, "sdc"),
+		// The session of the first portal is still used by LUN 2
+		"iscsiadm -m node -T " + fakeIQN + " -p 10.0.0.2:3260 --logout",
+		"iscsiadm -m node -T " + fakeIQN + " -p 10.0.0.2:3260 --op delete",
+	}, exec.ran)
+	for _, device := range []string{"sdb", "sdc"} {
+		deleted, err := ioutil.ReadFile(filepath.Join(sysBlockDir, device, "device", "delete"))
+		assert.NoError(t, err)
+		assert.Equal(t, "1", string(deleted))
+	}
+}
+
+func TestFCConnect(t *testing.T) {
+	addDevice, cleanup := fakeHost(t)
+	defer cleanup()
+	host := filepath.Join(sysClassDir, "fc_host", "host3")
+	scsiHost := filepath.Join(sysClassDir, "scsi_host", "host3")
+	for _, d := range []string{host, scsiHost} {
+		assert.NoError(t, os.MkdirAll(d, 0755))
+	}
+	addDevice("pci-0000:05:00.0-fc-0x"+fakeWWN+"-lun-1", "sde")
+
+	exec := &fakeExec{}
+	c := &fcConnector{exec: exec}
+	// A single target is served as a string instead of a list
+	data := map[string]interface{}{"target_wwn": fakeWWN, "target_lun": float64(1)}
+	device, err := c.Connect(data)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(devDir, "sde"), device)
+	scanned, err := ioutil.ReadFile(filepath.Join(scsiHost, "scan"))
+	assert.NoError(t, err)
+	assert.Equal(t, "- - -", string(scanned))
+
+	assert.NoError(t, c.Disconnect(data))
+	assert.Equal(t, []string{"blockdev --flushbufs " + filepath.Join(devDir, "sde")}, exec.ran)
+
+	_, err = c.Connect(map[string]interface{}{"target_wwn": []interface{}{"500a0981891b8dc6"}, "target_lun": float64(1)})
+	assert.Error(t, err, "no device of the target appears")
+}
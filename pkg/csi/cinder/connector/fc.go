@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+// fcProperties are the connection info data of a Fibre Channel volume.
+type fcProperties struct {
+	TargetWWN stringList `json:"target_wwn"`
+	TargetLUN int        `json:"target_lun"`
+}
+
+// links returns the globs of the udev links of the devices of the volume,
+// one per target port: all of them with multipath, the first one otherwise.
+func (p *fcProperties) links(multipath bool) ([]string, error) {
+	if len(p.TargetWWN) == 0 {
+		return nil, fmt.Errorf("invalid connection info: no target WWN")
+	}
+	wwns := p.TargetWWN
+	if !multipath {
+		wwns = wwns[:1]
+	}
+	var links []string
+	for _, wwn := range wwns {
+		wwn = strings.ToLower(strings.TrimPrefix(wwn, "0x"))
+		links = append(links, filepath.Join(byPathDir, fmt.Sprintf("*-fc-0x%s-lun-%d", wwn, p.TargetLUN)))
+	}
+	return links, nil
+}
+
+type fcConnector struct {
+	exec      mount.Exec
+	multipath bool
+}
+
+// rescan scans the SCSI hosts of the HBAs for the LUNs exported to them.
+func (c *fcConnector) rescan() {
+	hosts, _ := ioutil.ReadDir(filepath.Join(sysClassDir, "fc_host"))
+	for _, h := range hosts {
+		scan := filepath.Join(sysClassDir, "scsi_host", h.Name(), "scan")
+		if err := ioutil.WriteFile(scan, []byte("- - -"), 0200); err != nil {
+			klog.V(4).Infof("Failed to scan %s: %v", h.Name(), err)
+		}
+	}
+}
+
+// Connect scans the HBAs for the volume and returns its device.
+func (c *fcConnector) Connect(data map[string]interface{}) (string, error) {
+	var p fcProperties
+	if err := decode(data, &p); err != nil {
+		return "", err
+	}
+	links, err := p.links(c.multipath)
+	if err != nil {
+		return "", err
+	}
+
+	c.rescan()
+	devices, err := waitForDevices(links, c.rescan)
+	if err != nil {
+		return "", err
+	}
+	return connectedDevice(devices, c.multipath && len(links) > 1)
+}
+
+// Disconnect removes the devices of the volume.
+func (c *fcConnector) Disconnect(data map[string]interface{}) error {
+	var p fcProperties
+	if err := decode(data, &p); err != nil {
+		return err
+	}
+	links, err := p.links(c.multipath)
+	if err != nil {
+		return err
+	}
+	return removeDevices(c.exec, findDevices(links))
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connector
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+// iscsiSessionExists is the iscsiadm exit status of a login to a target the
+// host is already logged in to
+const iscsiSessionExists = "exit status 15"
+
+// iscsiProperties are the connection info data of an iSCSI volume.
+// target_portals, target_iqns and target_luns list all the paths of the
+// volume when the backend serves several.
+type iscsiProperties struct {
+	TargetPortal  string   `json:"target_portal"`
+	TargetIQN     string   `json:"target_iqn"`
+	TargetLUN     int      `json:"target_lun"`
+	TargetPortals []string `json:"target_portals"`
+	TargetIQNs    []string `json:"target_iqns"`
+	TargetLUNs    []int    `json:"target_luns"`
+	AuthMethod    string   `json:"auth_method"`
+	AuthUsername  string   `json:"auth_username"`
+	AuthPassword  string   `json:"auth_password"`
+}
+
+// iscsiPath is a path of an iSCSI volume.
+type iscsiPath struct {
+	portal string
+	iqn    string
+	lun    int
+}
+
+// link returns the glob of the udev link of the device of the path, lun
+// being a number or * for all the LUNs of the target.
+func (p iscsiPath) link(lun string) string {
+	// The portals of IPv6 addresses are bracketed
+	portal := strings.NewReplacer("[", "\\[", "]", "\\]").Replace(p.portal)
+	return filepath.Join(byPathDir, fmt.Sprintf("ip-%s-iscsi-%s-lun-%s", portal, p.iqn, lun))
+}
+
+// paths returns the paths to connect: all those of the backend with
+// multipath, the main one otherwise.
+func (p *iscsiProperties) paths(multipath bool) ([]iscsiPath, error) {
+	if multipath && len(p.TargetPortals) > 0 {
+		if len(p.TargetIQNs) != len(p.TargetPortals) || len(p.TargetLUNs) != len(p.TargetPortals) {
+			return nil, fmt.Errorf("invalid connection info: %d target portals for %d IQNs and %d LUNs", len(p.TargetPortals), len(p.TargetIQNs), len(p.TargetLUNs))
+		}
+		var paths []iscsiPath
+		for i, portal := range p.TargetPortals {
+			paths = append(paths, iscsiPath{portal: portal, iqn: p.TargetIQNs[i], lun: p.TargetLUNs[i]})
+		}
+		return paths, nil
+	}
+	if p.TargetPortal == "" || p.TargetIQN == "" {
+		return nil, fmt.Errorf("invalid connection info: no target portal and IQN")
+	}
+	return []iscsiPath{{portal: p.TargetPortal, iqn: p.TargetIQN, lun: p.TargetLUN}}, nil
+}
+
+type iscsiConnector struct {
+	exec      mount.Exec
+	multipath bool
+}
+
+func (c *iscsiConnector) iscsiadm(path iscsiPath, args ...string) ([]byte, error) {
+	return c.exec.Run("iscsiadm", append([]string{"-m", "node", "-T", path.iqn, "-p", path.portal}, args...)...)
+}
+
+// login logs in to the target of path, with CHAP when the backend requires
+// it, or rescans the session to it to find a new LUN.
+func (c *iscsiConnector) login(path iscsiPath, p *iscsiProperties) error {
+	if out, err := c.iscsiadm(path, "--op", "new"); err != nil {
+		return fmt.Errorf("failed to create the iSCSI node of %s on %s: %v: %s", path.iqn, path.portal, err, out)
+	}
+	if p.AuthMethod != "" {
+		for _, setting := range [][2]string{
+			{"node.session.auth.authmethod", p.AuthMethod},
+			{"node.session.auth.username", p.AuthUsername},
+			{"node.session.auth.password", p.AuthPassword},
+		} {
+			if out, err := c.iscsiadm(path, "--op", "update", "-n", setting[0], "-v", setting[1]); err != nil {
+				return fmt.Errorf("failed to set %s of the iSCSI node of %s: %v: %s", setting[0], path.iqn, err, out)
+			}
+		}
+	}
+	out, err := c.iscsiadm(path, "--login")
+	if err != nil && strings.Contains(err.Error(), iscsiSessionExists) {
+		out, err = c.iscsiadm(path, "--rescan")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to log in to %s on %s: %v: %s", path.iqn, path.portal, err, out)
+	}
+	return nil
+}
+
+// Connect logs in to the targets of the volume and returns its device.
+func (c *iscsiConnector) Connect(data map[string]interface{}) (string, error) {
+	var p iscsiProperties
+	if err := decode(data, &p); err != nil {
+		return "", err
+	}
+	paths, err := p.paths(c.multipath)
+	if err != nil {
+		return "", err
+	}
+
+	// A multipath volume is usable with one path logged in
+	var links []string
+	var loginErr error
+	for _, path := range paths {
+		if err := c.login(path, &p); err != nil {
+			klog.Warningf("%v", err)
+			loginErr = err
+			continue
+		}
+		links = append(links, path.link(strconv.Itoa(path.lun)))
+	}
+	if len(links) == 0 {
+		return "", loginErr
+	}
+
+	devices, err := waitForDevices(links, func() {
+		for _, path := range paths {
+			c.iscsiadm(path, "--rescan")
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return connectedDevice(devices, c.multipath && len(paths) > 1)
+}
+
+// Disconnect removes the devices of the volume, and logs out of the targets
+// no other volume uses.
+func (c *iscsiConnector) Disconnect(data map[string]interface{}) error {
+	var p iscsiProperties
+	if err := decode(data, &p); err != nil {
+		return err
+	}
+	paths, err := p.paths(c.multipath)
+	if err != nil {
+		return err
+	}
+
+	var links []string
+	for _, path := range paths {
+		links = append(links, path.link(strconv.Itoa(path.lun)))
+	}
+	devices := findDevices(links)
+	if err := removeDevices(c.exec, devices); err != nil {
+		return err
+	}
+	removed := map[string]bool{}
+	for _, device := range devices {
+		removed[device] = true
+	}
+
+	for _, path := range paths {
+		// Backends sharing a target between volumes export them as LUNs
+		// of the same session, whose links udev may not have removed yet
+		inUse := false
+		for _, device := range findDevices([]string{path.link("*")}) {
+			inUse = inUse || !removed[device]
+		}
+		if inUse {
+			klog.V(4).Infof("Keeping the iSCSI session of %s on %s used by other volumes", path.iqn, path.portal)
+			continue
+		}
+		if out, err := c.iscsiadm(path, "--logout"); err != nil && !strings.Contains(string(out), "No matching sessions") {
+			return fmt.Errorf("failed to log out of %s on %s: %v: %s", path.iqn, path.portal, err, out)
+		}
+		if out, err := c.iscsiadm(path, "--op", "delete"); err != nil {
+			klog.Warningf("Failed to delete the iSCSI node of %s on %s: %v: %s", path.iqn, path.portal, err, out)
+		}
+	}
+	return nil
+}
@@ -18,6 +18,9 @@ package cinder
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/golang/protobuf/ptypes"
 
@@ -28,12 +31,24 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
 	"k8s.io/cloud-provider-openstack/pkg/volume/util"
 	"k8s.io/klog"
 )
 
 type controllerServer struct {
 	Driver *CinderDriver
+
+	// volumeLocks serializes DeleteVolume, CreateSnapshot,
+	// ControllerExpandVolume, ControllerPublishVolume and
+	// ControllerUnpublishVolume on a volume
+	volumeLocks *volumeLocks
+	// createLocks serializes CreateVolume by volume name, for the retries of
+	// a slow request not to create the volume twice
+	createLocks *volumeLocks
+	// volumeRegions are the regions of the volumes and snapshots found
+	// outside the region of the cloud config
+	volumeRegions *volumeRegions
 }
 
 func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
@@ -56,58 +71,239 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 
 	var volAvailability string
 	if req.GetAccessibilityRequirements() != nil {
-		volAvailability = getAZFromTopology(req.GetAccessibilityRequirements())
+		volAvailability = getAZFromTopology(req.GetAccessibilityRequirements(), cs.Driver.topology)
 	}
 
+	// availabilityParam is the availability parameter when it is used
+	var availabilityParam string
 	if len(volAvailability) == 0 {
 		// Volume Availability - Default is nova
 		volAvailability = req.GetParameters()["availability"]
+		availabilityParam = volAvailability
 	}
 
-	// Get OpenStack Provider
-	cloud, err := openstack.GetOpenStackProvider()
+	multiattach, err := multiattachRequested(req)
+	if err != nil {
+		logFor(ctx).V(3).Infof("Invalid multiattach request for volume %s: %v", volName, err)
+		return nil, err
+	}
+
+	if err := validateFsTypes(req.GetVolumeCapabilities()); err != nil {
+		logFor(ctx).V(3).Infof("Invalid filesystem for volume %s: %v", volName, err)
+		return nil, err
+	}
+
+	// Node-side encryption, passed to the nodes in the volume context
+	luks, err := luksContext(req.GetParameters())
+	if err != nil {
+		logFor(ctx).V(3).Infof("Invalid LUKS parameters for volume %s: %v", volName, err)
+		return nil, err
+	}
+
+	// Metadata hints passed through to Cinder
+	hints, err := cs.Driver.getMetadataHints(req.GetParameters())
 	if err != nil {
-		klog.V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		logFor(ctx).V(3).Infof("Invalid metadata hints for volume %s: %v", volName, err)
 		return nil, err
 	}
 
+	// Get OpenStack Provider of the region of the volume
+	cloud, region, regionFromTopology, err := cs.cloudForCreate(req)
+	if err != nil {
+		logFor(ctx).V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		return nil, err
+	}
+
+	if cs.Driver.cloudParameters != nil {
+		if err := cs.Driver.cloudParameters.validate(cloud, region, volType, availabilityParam); err != nil {
+			logFor(ctx).V(3).Infof("Invalid parameters for volume %s: %v", volName, err)
+			return nil, err
+		}
+	}
+
+	if err := cs.createLocks.acquire(volName, "CreateVolume"); err != nil {
+		logFor(ctx).V(3).Infof("Refused to CreateVolume %s: %v", volName, err)
+		return nil, err
+	}
+	defer cs.createLocks.release(volName)
+
 	// Verify a volume with the provided name doesn't already exist for this tenant
 	volumes, err := cloud.GetVolumesByName(volName)
 	if err != nil {
-		klog.V(3).Infof("Failed to query for existing Volume during CreateVolume: %v", err)
+		logFor(ctx).V(3).Infof("Failed to query for existing Volume during CreateVolume: %v", err)
+	}
+
+	if cs.Driver.creatingDeadline > 0 {
+		volumes, err = cs.checkCreatingVolumes(ctx, req, cloud, volumes)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	resID := ""
 	resAvailability := ""
 	resSize := 0
+	var resMetadata map[string]string
 	snapshotID := ""
+	sourceVolID := ""
+
+	content := req.GetVolumeContentSource()
+	if content != nil && content.GetSnapshot() != nil {
+		snapshotID = content.GetSnapshot().GetSnapshotId()
+	}
+	if content != nil && content.GetVolume() != nil {
+		sourceVolID = content.GetVolume().GetVolumeId()
+	}
+	params := volumeParameters{sizeGB: volSizeGB, volType: volType, availability: volAvailability, snapshotID: snapshotID, sourceVolID: sourceVolID}
 
 	if len(volumes) == 1 {
+		if err := cs.checkVolumeOwner(volName, volumes[0]); err != nil {
+			return nil, err
+		}
+		if multiattach && !volumes[0].Multiattach {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists and is not multiattach", volName)
+		}
+		if cs.Driver.strictIdempotency {
+			if err := cs.checkVolumeParameters(volName, volumes[0], params); err != nil {
+				return nil, err
+			}
+		}
+
 		resID = volumes[0].ID
 		resAvailability = volumes[0].AZ
 		resSize = volumes[0].Size
+		resMetadata = volumes[0].Metadata
 
-		klog.V(4).Infof("Volume %s already exists in Availability Zone: %s of size %d GiB", resID, resAvailability, resSize)
+		logFor(ctx).V(4).Infof("Volume %s already exists in Availability Zone: %s of size %d GiB", resID, resAvailability, resSize)
 	} else if len(volumes) > 1 {
-		klog.V(3).Infof("found multiple existing volumes with selected name (%s) during create", volName)
+		logFor(ctx).V(3).Infof("found multiple existing volumes with selected name (%s) during create", volName)
 		return nil, errors.New("multiple volumes reported by Cinder with same name")
 	} else {
 		// Volume Create
-		properties := map[string]string{"cinder.csi.openstack.org/cluster": cs.Driver.cluster}
-		content := req.GetVolumeContentSource()
+		properties := map[string]string{clusterMetadataKey: cs.Driver.cluster}
+		if cs.Driver.strictIdempotency {
+			properties[parametersHashMetadataKey] = params.hash()
+		}
+		for k, v := range hints {
+			properties[k] = v
+		}
+
+		// Let the placement webhook, if any, override type and AZ
+		if cs.Driver.placement != nil {
+			builtin := placementDecision{volType: volType, availability: volAvailability}
+			decision, err := cs.Driver.placement.decide(ctx, newPlacementRequest(req, volSizeGB, builtin, cs.Driver.topology), builtin)
+			if err != nil {
+				logFor(ctx).V(3).Infof("Placement webhook failed for volume %s: %v", volName, err)
+				return nil, err
+			}
+			volType = decision.volType
+			volAvailability = decision.availability
+			for k, v := range decision.metadata {
+				if _, exists := properties[k]; !exists {
+					properties[k] = v
+				}
+			}
+		}
 
-		if content != nil && content.GetSnapshot() != nil {
-			snapshotID = content.GetSnapshot().GetSnapshotId()
+		if multiattach {
+			if err := checkMultiattachType(cloud, volType); err != nil {
+				logFor(ctx).V(3).Infof("Refused to CreateVolume %s: %v", volName, err)
+				return nil, err
+			}
 		}
 
-		resID, resAvailability, resSize, err = cloud.CreateVolume(volName, volSizeGB, volType, volAvailability, snapshotID, &properties)
+		// A snapshot with --snapshot-backups may be a backup
+		var backup *openstack.Backup
+		if cs.Driver.snapshotBackups && snapshotID != "" {
+			backup, err = cs.sourceBackup(cloud, snapshotID)
+			if err != nil {
+				logFor(ctx).V(3).Infof("Failed to get the source snapshot of volume %s: %v", volName, err)
+				return nil, err
+			}
+		}
+
+		if cs.Driver.encryption != nil && backup != nil {
+			if err := cs.checkEncryptedRestore(cloud, volName, volType, snapshotID, backup.Metadata, req.GetParameters()); err != nil {
+				logFor(ctx).V(3).Infof("Refused to CreateVolume %s: %v", volName, err)
+				return nil, err
+			}
+		} else if cs.Driver.encryption != nil && snapshotID != "" {
+			if err := cs.checkEncryptionBoundary(cloud, volName, volType, snapshotID, req.GetParameters()); err != nil {
+				logFor(ctx).V(3).Infof("Refused to CreateVolume %s: %v", volName, err)
+				return nil, err
+			}
+		} else if cs.Driver.encryption != nil && sourceVolID != "" {
+			if err := cs.checkEncryptedClone(cloud, volName, volType, sourceVolID, req.GetParameters()); err != nil {
+				logFor(ctx).V(3).Infof("Refused to CreateVolume %s: %v", volName, err)
+				return nil, err
+			}
+		}
+
+		namespace := req.GetParameters()[pvcNamespaceParameter]
+		if cs.Driver.quota != nil && namespace != "" {
+			if err := cs.Driver.quota.reserve(cloud, cs.Driver.cluster, volName, namespace, volSizeGB); err != nil {
+				logFor(ctx).V(3).Infof("Refused to CreateVolume %s: %v", volName, err)
+				return nil, err
+			}
+			properties[namespaceMetadataKey] = namespace
+		}
+
+		createStart := time.Now()
+		if backup != nil {
+			resID, resAvailability, resSize, err = cloud.CreateVolumeFromBackup(volName, volSizeGB, volType, volAvailability, backup.ID, &properties)
+		} else {
+			resID, resAvailability, resSize, err = cloud.CreateVolume(volName, volSizeGB, volType, volAvailability, snapshotID, sourceVolID, &properties)
+		}
 		if err != nil {
-			klog.V(3).Infof("Failed to CreateVolume: %v", err)
-			return nil, err
+			if cs.Driver.quota != nil {
+				cs.Driver.quota.cancel(volName)
+			}
+			logFor(ctx).V(3).Infof("Failed to CreateVolume: %v", err)
+			if snapshotID != "" && cpoerrors.IsNotFound(err) {
+				return nil, status.Errorf(codes.NotFound, "CreateVolume source snapshot %s not found", snapshotID)
+			}
+			if sourceVolID != "" && cpoerrors.IsNotFound(err) {
+				return nil, status.Errorf(codes.NotFound, "CreateVolume source volume %s not found", sourceVolID)
+			}
+			return nil, cloudError(err)
+		}
+		if cs.Driver.quota != nil {
+			cs.Driver.quota.commit(volName, resID, resSize)
+		}
+
+		available := cs.Driver.volumeAvailableWait(createStart, volType, resAvailability)
+		if cs.Driver.creatingDeadline > 0 {
+			if _, err := cs.waitVolumeCreated(ctx, cloud, resID, cs.Driver.creatingDeadline, available); err != nil {
+				logFor(ctx).V(3).Infof("Volume %s not created yet: %v", resID, err)
+				return nil, err
+			}
+		} else {
+			// Without a deadline the volume is returned while still creating,
+			// it is only waited for to record its time to available
+			go cs.recordVolumeCreated(cloud, resID, available)
 		}
 
-		klog.V(4).Infof("Create volume %s in Availability Zone: %s of size %d GiB", resID, resAvailability, resSize)
+		logFor(ctx).V(4).Infof("Create volume %s in Availability Zone: %s of size %d GiB", resID, resAvailability, resSize)
+		resMetadata = properties
+
+	}
+
+	volumeContext := metadataHintsContext(hints, resMetadata)
+	for _, nodeContext := range []map[string]string{luks, mkfsOptionsContext(req.GetParameters())} {
+		for k, v := range nodeContext {
+			if volumeContext == nil {
+				volumeContext = map[string]string{}
+			}
+			volumeContext[k] = v
+		}
+	}
 
+	if region != "" {
+		cs.volumeRegions.set(resID, region)
+	}
+	segments := map[string]string{cs.Driver.topology.key: resAvailability}
+	if regionFromTopology {
+		segments[regionTopologyKey] = region
 	}
 
 	resp := &csi.CreateVolumeResponse{
@@ -116,9 +312,10 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 			CapacityBytes: int64(resSize * 1024 * 1024 * 1024),
 			AccessibleTopology: []*csi.Topology{
 				{
-					Segments: map[string]string{topologyKey: resAvailability},
+					Segments: segments,
 				},
 			},
+			VolumeContext: volumeContext,
 		},
 	}
 
@@ -132,63 +329,113 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		}
 		resp.Volume.ContentSource = src
 	}
+	if sourceVolID != "" {
+		resp.Volume.ContentSource = &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{
+					VolumeId: sourceVolID,
+				},
+			},
+		}
+	}
 	return resp, nil
 }
 
+// checkVolumeOwner verifies that an existing volume with the requested name
+// was provisioned for this cluster before CreateVolume returns it.
+func (cs *controllerServer) checkVolumeOwner(volName string, vol openstack.Volume) error {
+	owner, tagged := vol.Metadata[clusterMetadataKey]
+	if tagged && owner == cs.Driver.cluster {
+		return nil
+	}
+
+	if !tagged {
+		if cs.Driver.adoptUntagged {
+			klog.Warningf("Adopting volume %s with name %s without %s metadata for cluster %q", vol.ID, volName, clusterMetadataKey, cs.Driver.cluster)
+			return nil
+		}
+		owner = "<none>"
+	}
+
+	klog.V(3).Infof("Volume %s with name %s belongs to cluster %q, not to cluster %q", vol.ID, volName, owner, cs.Driver.cluster)
+	return status.Errorf(codes.AlreadyExists, "volume %s with name %s already exists for cluster %q, requested by cluster %q", vol.ID, volName, owner, cs.Driver.cluster)
+}
+
 func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 
-	// Get OpenStack Provider
-	cloud, err := openstack.GetOpenStackProvider()
+	// Get OpenStack Provider of the region of the volume
+	volID := req.GetVolumeId()
+	cloud, err := cs.cloudForVolume(volID)
 	if err != nil {
-		klog.V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		logFor(ctx).V(3).Infof("Failed to GetOpenStackProvider: %v", err)
 		return nil, err
 	}
 
 	// Volume Delete
-	volID := req.GetVolumeId()
+	if err := cs.volumeLocks.acquire(volID, "DeleteVolume"); err != nil {
+		logFor(ctx).V(3).Infof("DeleteVolume: %v", err)
+		return nil, err
+	}
+	defer cs.volumeLocks.release(volID)
+
 	err = cloud.DeleteVolume(volID)
 	if err != nil {
-		klog.V(3).Infof("Failed to DeleteVolume: %v", err)
+		logFor(ctx).V(3).Infof("Failed to DeleteVolume: %v", err)
 		return nil, err
 	}
+	if cs.Driver.quota != nil {
+		cs.Driver.quota.deleted(volID)
+	}
+	cs.volumeRegions.forget(volID)
 
-	klog.V(4).Infof("Delete volume %s", volID)
+	logFor(ctx).V(4).Infof("Delete volume %s", volID)
 
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
 func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
 
-	// Get OpenStack Provider
-	cloud, err := openstack.GetOpenStackProvider()
+	// Volume Attach
+	instanceID := req.GetNodeId()
+	volumeID := req.GetVolumeId()
+
+	// Get OpenStack Provider of the region of the volume
+	cloud, err := cs.cloudForVolume(volumeID)
 	if err != nil {
-		klog.V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		logFor(ctx).V(3).Infof("Failed to GetOpenStackProvider: %v", err)
 		return nil, err
 	}
 
-	// Volume Attach
-	instanceID := req.GetNodeId()
-	volumeID := req.GetVolumeId()
+	if err := cs.volumeLocks.acquire(volumeID, "ControllerPublishVolume to node "+instanceID); err != nil {
+		logFor(ctx).V(3).Infof("Refused to ControllerPublishVolume %s: %v", volumeID, err)
+		return nil, err
+	}
+	defer cs.volumeLocks.release(volumeID)
+
+	if _, err := waitVolumeSettled(ctx, cloud, volumeID, settleBackoff, nil); err != nil {
+		logFor(ctx).V(3).Infof("Failed to ControllerPublishVolume %s: %v", volumeID, err)
+		return nil, err
+	}
 
 	_, err = cloud.AttachVolume(instanceID, volumeID)
 	if err != nil {
-		klog.V(3).Infof("Failed to AttachVolume: %v", err)
-		return nil, err
+		logFor(ctx).V(3).Infof("Failed to AttachVolume: %v", err)
+		return nil, cloudError(err)
 	}
 
 	err = cloud.WaitDiskAttached(instanceID, volumeID)
 	if err != nil {
-		klog.V(3).Infof("Failed to WaitDiskAttached: %v", err)
-		return nil, err
+		logFor(ctx).V(3).Infof("Failed to WaitDiskAttached: %v", err)
+		return nil, cloudError(err)
 	}
 
 	devicePath, err := cloud.GetAttachmentDiskPath(instanceID, volumeID)
 	if err != nil {
-		klog.V(3).Infof("Failed to GetAttachmentDiskPath: %v", err)
+		logFor(ctx).V(3).Infof("Failed to GetAttachmentDiskPath: %v", err)
 		return nil, err
 	}
 
-	klog.V(4).Infof("ControllerPublishVolume %s on %s", volumeID, instanceID)
+	logFor(ctx).V(4).Infof("ControllerPublishVolume %s on %s", volumeID, instanceID)
 
 	// Publish Volume Info
 	pvInfo := map[string]string{}
@@ -201,30 +448,46 @@ func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 
 func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
 
-	// Get OpenStack Provider
-	cloud, err := openstack.GetOpenStackProvider()
+	// Volume Detach
+	instanceID := req.GetNodeId()
+	volumeID := req.GetVolumeId()
+
+	// Get OpenStack Provider of the region of the volume
+	cloud, err := cs.cloudForVolume(volumeID)
 	if err != nil {
-		klog.V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		logFor(ctx).V(3).Infof("Failed to GetOpenStackProvider: %v", err)
 		return nil, err
 	}
 
-	// Volume Detach
-	instanceID := req.GetNodeId()
-	volumeID := req.GetVolumeId()
+	if err := cs.volumeLocks.acquire(volumeID, "ControllerUnpublishVolume from node "+instanceID); err != nil {
+		logFor(ctx).V(3).Infof("Refused to ControllerUnpublishVolume %s: %v", volumeID, err)
+		return nil, err
+	}
+	defer cs.volumeLocks.release(volumeID)
+
+	if _, err := waitVolumeSettled(ctx, cloud, volumeID, settleBackoff, nil); err != nil {
+		if status.Code(err) == codes.NotFound {
+			// A deleted volume is detached from every node
+			logFor(ctx).V(4).Infof("Volume %s not found, nothing to ControllerUnpublishVolume", volumeID)
+			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		}
+		logFor(ctx).V(3).Infof("Failed to ControllerUnpublishVolume %s: %v", volumeID, err)
+		return nil, err
+	}
 
 	err = cloud.DetachVolume(instanceID, volumeID)
 	if err != nil {
-		klog.V(3).Infof("Failed to DetachVolume: %v", err)
-		return nil, err
+		logFor(ctx).V(3).Infof("Failed to DetachVolume: %v", err)
+		return nil, cloudError(err)
 	}
 
 	err = cloud.WaitDiskDetached(instanceID, volumeID)
 	if err != nil {
-		klog.V(3).Infof("Failed to WaitDiskDetached: %v", err)
-		return nil, err
+		logFor(ctx).V(3).Infof("Failed to WaitDiskDetached: %v", err)
+		return nil, cloudError(err)
 	}
 
-	klog.V(4).Infof("ControllerUnpublishVolume %s on %s", volumeID, instanceID)
+	logFor(ctx).V(4).Infof("ControllerUnpublishVolume %s on %s", volumeID, instanceID)
 
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
 }
@@ -233,13 +496,13 @@ func (cs *controllerServer) ListVolumes(ctx context.Context, req *csi.ListVolume
 	// Get OpenStack Provider
 	cloud, err := openstack.GetOpenStackProvider()
 	if err != nil {
-		klog.V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		logFor(ctx).V(3).Infof("Failed to GetOpenStackProvider: %v", err)
 		return nil, err
 	}
 
 	vlist, err := cloud.ListVolumes()
 	if err != nil {
-		klog.V(3).Infof("Failed to ListVolumes: %v", err)
+		logFor(ctx).V(3).Infof("Failed to ListVolumes: %v", err)
 		return nil, err
 	}
 
@@ -259,53 +522,82 @@ func (cs *controllerServer) ListVolumes(ctx context.Context, req *csi.ListVolume
 }
 
 func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	// Get OpenStack Provider
-	cloud, err := openstack.GetOpenStackProvider()
+	name := req.Name
+	volumeId := req.SourceVolumeId
+
+	backup, err := cs.Driver.backupRequested(req.Parameters)
 	if err != nil {
-		klog.V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		logFor(ctx).V(3).Infof("Invalid CreateSnapshot request for %s: %v", name, err)
+		return nil, err
+	}
+
+	// Get OpenStack Provider of the region of the source volume
+	cloud, err := cs.cloudForVolume(volumeId)
+	if err != nil {
+		logFor(ctx).V(3).Infof("Failed to GetOpenStackProvider: %v", err)
 		return nil, err
 	}
 
-	name := req.Name
-	volumeId := req.SourceVolumeId
 	// No description from csi.CreateSnapshotRequest now
 	description := ""
 
+	if err := cs.volumeLocks.acquire(volumeId, "CreateSnapshot"); err != nil {
+		logFor(ctx).V(3).Infof("CreateSnapshot: %v", err)
+		return nil, err
+	}
+	defer cs.volumeLocks.release(volumeId)
+
+	if backup {
+		return cs.createBackup(cloud, name, volumeId, snapshotMetadata(req.Parameters))
+	}
+
 	// Verify a snapshot with the provided name doesn't already exist for this tenant
 	snapshots, err := cloud.GetSnapshotByNameAndVolumeID(name, volumeId)
 	if err != nil {
-		klog.V(3).Infof("Failed to query for existing Snapshot during CreateSnapshot: %v", err)
+		logFor(ctx).V(3).Infof("Failed to query for existing Snapshot during CreateSnapshot: %v", err)
 	}
 	var snap *ossnapshots.Snapshot
 
 	if len(snapshots) == 1 {
 		snap = &snapshots[0]
 
-		klog.V(3).Infof("Found existing snapshot %s on %s", name, volumeId)
+		logFor(ctx).V(3).Infof("Found existing snapshot %s on %s", name, volumeId)
 	} else if len(snapshots) > 1 {
-		klog.V(3).Infof("found multiple existing snapshots with selected name (%s) during create", name)
+		logFor(ctx).V(3).Infof("found multiple existing snapshots with selected name (%s) during create", name)
 		return nil, errors.New("multiple snapshots reported by Cinder with same name")
 	} else {
+		metadata := snapshotMetadata(req.Parameters)
+		if cs.Driver.encryption != nil {
+			metadata, err = cs.markEncryptedSnapshot(cloud, volumeId, metadata)
+			if err != nil {
+				logFor(ctx).V(3).Infof("Failed to check the encryption of volume %s: %v", volumeId, err)
+				return nil, err
+			}
+		}
+
 		// TODO: Delegate the check to openstack itself and ignore the conflict
-		snap, err = cloud.CreateSnapshot(name, volumeId, description, &req.Parameters)
+		snap, err = cloud.CreateSnapshot(name, volumeId, description, &metadata)
 		if err != nil {
-			klog.V(3).Infof("Failed to Create snapshot: %v", err)
+			logFor(ctx).V(3).Infof("Failed to Create snapshot: %v", err)
 			return nil, err
 		}
 
-		klog.V(3).Infof("CreateSnapshot %s on %s", name, volumeId)
+		logFor(ctx).V(3).Infof("CreateSnapshot %s on %s", name, volumeId)
 	}
 
 	ctime, err := ptypes.TimestampProto(snap.CreatedAt)
 	if err != nil {
-		klog.Errorf("Error to convert time to timestamp: %v", err)
+		logFor(ctx).Errorf("Error to convert time to timestamp: %v", err)
 	}
 
 	err = cloud.WaitSnapshotReady(snap.ID)
 	if err != nil {
-		klog.V(3).Infof("Failed to WaitSnapshotReady: %v", err)
+		logFor(ctx).V(3).Infof("Failed to WaitSnapshotReady: %v", err)
 		return nil, err
 	}
+	if region, ok := cs.volumeRegions.get(volumeId); ok {
+		cs.volumeRegions.set(snap.ID, region)
+	}
 
 	return &csi.CreateSnapshotResponse{
 		Snapshot: &csi.Snapshot{
@@ -319,21 +611,25 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 }
 
 func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	// Get OpenStack Provider
-	cloud, err := openstack.GetOpenStackProvider()
+	id := req.SnapshotId
+
+	// Get OpenStack Provider of the region of the snapshot
+	cloud, err := cs.cloudForSnapshot(id)
 	if err != nil {
-		klog.V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		logFor(ctx).V(3).Infof("Failed to GetOpenStackProvider: %v", err)
 		return nil, err
 	}
 
-	id := req.SnapshotId
-
 	// Delegate the check to openstack itself
 	err = cloud.DeleteSnapshot(id)
+	if err != nil && cs.Driver.snapshotBackups && cpoerrors.IsNotFound(err) {
+		err = cloud.DeleteBackup(id)
+	}
 	if err != nil {
-		klog.V(3).Infof("Faled to Delete snapshot: %v", err)
+		logFor(ctx).V(3).Infof("Faled to Delete snapshot: %v", err)
 		return nil, err
 	}
+	cs.volumeRegions.forget(id)
 	return &csi.DeleteSnapshotResponse{}, nil
 }
 
@@ -341,45 +637,93 @@ func (cs *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnap
 	// Get OpenStack Provider
 	cloud, err := openstack.GetOpenStackProvider()
 	if err != nil {
-		klog.V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		logFor(ctx).V(3).Infof("Failed to GetOpenStackProvider: %v", err)
 		return nil, err
 	}
 
+	// A single snapshot, e.g. the external-snapshotter checking whether it
+	// is ready to use
+	if snapshotID := req.GetSnapshotId(); snapshotID != "" {
+		cloud, err := cs.cloudForSnapshot(snapshotID)
+		if err != nil {
+			logFor(ctx).V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+			return nil, err
+		}
+		snap, err := cloud.GetSnapshotByID(snapshotID)
+		if err != nil && cs.Driver.snapshotBackups && cpoerrors.IsNotFound(err) {
+			return cs.listBackup(cloud, snapshotID, req.GetSourceVolumeId())
+		}
+		if err != nil {
+			if cpoerrors.IsNotFound(err) {
+				return &csi.ListSnapshotsResponse{}, nil
+			}
+			return nil, status.Errorf(codes.Internal, "Failed to get snapshot %s: %v", snapshotID, err)
+		}
+		if volumeID := req.GetSourceVolumeId(); volumeID != "" && volumeID != snap.VolumeID {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		return &csi.ListSnapshotsResponse{
+			Entries: []*csi.ListSnapshotsResponse_Entry{{Snapshot: newCSISnapshot(snap)}},
+		}, nil
+	}
+
+	// The starting token is the offset of the page in the list
+	offset := 0
+	if token := req.GetStartingToken(); token != "" {
+		offset, err = strconv.Atoi(token)
+		if err != nil || offset < 0 {
+			return nil, status.Errorf(codes.Aborted, "ListSnapshots invalid starting token %q", token)
+		}
+	}
+
 	filters := map[string]string{}
-	// FIXME: honor the limit, offset and filters later
-	vlist, err := cloud.ListSnapshots(int(req.MaxEntries), 0, filters)
+	if volumeID := req.GetSourceVolumeId(); volumeID != "" {
+		filters["VolumeID"] = volumeID
+	}
+	if cs.Driver.snapshotBackups {
+		return cs.listSnapshotsAndBackups(cloud, int(req.MaxEntries), offset, filters)
+	}
+	vlist, err := cloud.ListSnapshots(int(req.MaxEntries), offset, filters)
 	if err != nil {
-		klog.V(3).Infof("Failed to ListSnapshots: %v", err)
+		logFor(ctx).V(3).Infof("Failed to ListSnapshots: %v", err)
 		return nil, err
 	}
 
 	var ventries []*csi.ListSnapshotsResponse_Entry
-	for _, v := range vlist {
-		ctime, err := ptypes.TimestampProto(v.CreatedAt)
-		if err != nil {
-			klog.Errorf("Error to convert time to timestamp: %v", err)
-		}
-		ventry := csi.ListSnapshotsResponse_Entry{
-			Snapshot: &csi.Snapshot{
-				SizeBytes:      int64(v.Size * 1024 * 1024 * 1024),
-				SnapshotId:     v.ID,
-				SourceVolumeId: v.VolumeID,
-				CreationTime:   ctime,
-				ReadyToUse:     true,
-			},
-		}
-		ventries = append(ventries, &ventry)
+	for i := range vlist {
+		ventries = append(ventries, &csi.ListSnapshotsResponse_Entry{Snapshot: newCSISnapshot(&vlist[i])})
+	}
+	nextToken := ""
+	if req.MaxEntries > 0 && len(vlist) == int(req.MaxEntries) {
+		nextToken = strconv.Itoa(offset + len(vlist))
 	}
 	return &csi.ListSnapshotsResponse{
-		Entries: ventries,
+		Entries:   ventries,
+		NextToken: nextToken,
 	}, nil
 
 }
 
+// newCSISnapshot returns the CSI snapshot of a Cinder snapshot, ready to use
+// once available.
+func newCSISnapshot(snap *ossnapshots.Snapshot) *csi.Snapshot {
+	ctime, err := ptypes.TimestampProto(snap.CreatedAt)
+	if err != nil {
+		klog.Errorf("Error to convert time to timestamp: %v", err)
+	}
+	return &csi.Snapshot{
+		SizeBytes:      int64(snap.Size * 1024 * 1024 * 1024),
+		SnapshotId:     snap.ID,
+		SourceVolumeId: snap.VolumeID,
+		CreationTime:   ctime,
+		ReadyToUse:     snap.Status == openstack.SnapshotReadyStatus,
+	}
+}
+
 // ControllerGetCapabilities implements the default GRPC callout.
 // Default supports all capabilities
 func (cs *controllerServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
-	klog.V(5).Infof("Using default ControllerGetCapabilities")
+	logFor(ctx).V(5).Infof("Using default ControllerGetCapabilities")
 
 	return &csi.ControllerGetCapabilitiesResponse{
 		Capabilities: cs.Driver.cscap,
@@ -387,23 +731,154 @@ func (cs *controllerServer) ControllerGetCapabilities(ctx context.Context, req *
 }
 
 func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ValidateVolumeCapabilities Volume ID must be provided")
+	}
+	volCaps := req.GetVolumeCapabilities()
+	if len(volCaps) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ValidateVolumeCapabilities Volume Capabilities must be provided")
+	}
+
+	// Get OpenStack Provider of the region of the volume
+	cloud, err := cs.cloudForVolume(volumeID)
+	if err != nil {
+		logFor(ctx).V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		return nil, err
+	}
+
+	vol, err := cloud.GetVolume(volumeID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "ValidateVolumeCapabilities Volume %s not found", volumeID)
+		}
+		return nil, status.Errorf(codes.Internal, "ValidateVolumeCapabilities failed to get volume %s: %v", volumeID, err)
+	}
+
+	for _, volCap := range volCaps {
+		if msg := cs.validateVolumeCapability(volCap, vol); msg != "" {
+			return &csi.ValidateVolumeCapabilitiesResponse{Message: msg}, nil
+		}
+	}
+
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: volCaps,
+			Parameters:         req.GetParameters(),
+		},
+	}, nil
+}
+
+// validateVolumeCapability returns why volCap is not supported by vol, or ""
+// if it is: both the mount and block access types are served, with the access
+// modes of the driver. The multi-node modes need a multiattach block volume.
+func (cs *controllerServer) validateVolumeCapability(volCap *csi.VolumeCapability, vol openstack.Volume) string {
+	if volCap.GetMount() == nil && volCap.GetBlock() == nil {
+		return "access type must be mount or block"
+	}
+	mode := volCap.GetAccessMode().GetMode()
+	for _, m := range cs.Driver.vcap {
+		if m.GetMode() != mode {
+			continue
+		}
+		if isMultiNode(volCap) && volCap.GetBlock() == nil {
+			return fmt.Sprintf("access mode %v is only supported for block volumes", mode)
+		}
+		if isMultiNode(volCap) && !vol.Multiattach {
+			return fmt.Sprintf("access mode %v is not supported, volume %s is not multiattach", mode, vol.ID)
+		}
+		return ""
+	}
+	return fmt.Sprintf("access mode %v is not supported", mode)
+}
+
+func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	logFor(ctx).V(4).Infof("ControllerExpandVolume: called with args %+v", *req)
+
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerExpandVolume Volume ID must be provided")
+	}
+	capRange := req.GetCapacityRange()
+	if capRange == nil {
+		return nil, status.Error(codes.InvalidArgument, "ControllerExpandVolume Capacity range must be provided")
+	}
+	volSizeGB := int(util.RoundUpSize(capRange.GetRequiredBytes(), 1024*1024*1024))
+	volSizeBytes := int64(volSizeGB) * 1024 * 1024 * 1024
+	if limit := capRange.GetLimitBytes(); limit > 0 && volSizeBytes > limit {
+		return nil, status.Errorf(codes.OutOfRange, "ControllerExpandVolume %d GiB exceeds the limit of %d bytes", volSizeGB, limit)
+	}
+
+	// Get OpenStack Provider of the region of the volume
+	cloud, err := cs.cloudForVolume(volumeID)
+	if err != nil {
+		logFor(ctx).V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		return nil, err
+	}
+
+	if err := cs.volumeLocks.acquire(volumeID, "ControllerExpandVolume"); err != nil {
+		logFor(ctx).V(3).Infof("ControllerExpandVolume: %v", err)
+		return nil, err
+	}
+	defer cs.volumeLocks.release(volumeID)
+
+	vol, err := cloud.GetVolume(volumeID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "ControllerExpandVolume Volume %s not found", volumeID)
+		}
+		return nil, status.Errorf(codes.Internal, "ControllerExpandVolume failed to get volume %s: %v", volumeID, err)
+	}
+
+	// Already extended, e.g. by a retry
+	if vol.Size >= volSizeGB {
+		logFor(ctx).V(4).Infof("ControllerExpandVolume: volume %s is already %d GiB", volumeID, vol.Size)
+		return &csi.ControllerExpandVolumeResponse{
+			CapacityBytes:         int64(vol.Size) * 1024 * 1024 * 1024,
+			NodeExpansionRequired: true,
+		}, nil
+	}
+	if vol.Status != openstack.VolumeAvailableStatus {
+		return nil, status.Errorf(codes.FailedPrecondition, "ControllerExpandVolume Volume %s is %s, only available volumes can be expanded", volumeID, vol.Status)
+	}
+
+	if cs.Driver.quota != nil {
+		if err := cs.Driver.quota.expand(cloud, cs.Driver.cluster, volumeID, volSizeGB); err != nil {
+			logFor(ctx).V(3).Infof("ControllerExpandVolume: %v", err)
+			return nil, err
+		}
+	}
+
+	if err := cloud.ExpandVolume(volumeID, volSizeGB); err != nil {
+		if cs.Driver.quota != nil {
+			cs.Driver.quota.expandFailed(volumeID, vol.Size)
+		}
+		logFor(ctx).V(3).Infof("Failed to ExpandVolume: %v", err)
+		return nil, status.Errorf(codes.Internal, "Failed to expand volume %s to %d GiB: %v", volumeID, volSizeGB, err)
+	}
+
+	logFor(ctx).V(4).Infof("ControllerExpandVolume: expanded volume %s from %d GiB to %d GiB", volumeID, vol.Size, volSizeGB)
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         volSizeBytes,
+		NodeExpansionRequired: true,
+	}, nil
 }
 
 func (cs *controllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
-func getAZFromTopology(requirement *csi.TopologyRequirement) string {
+func getAZFromTopology(requirement *csi.TopologyRequirement, keys topologyKeys) string {
 	for _, topology := range requirement.GetPreferred() {
-		zone, exists := topology.GetSegments()[topologyKey]
+		zone, exists := keys.zone(topology.GetSegments())
 		if exists {
 			return zone
 		}
 	}
 
 	for _, topology := range requirement.GetRequisite() {
-		zone, exists := topology.GetSegments()[topologyKey]
+		zone, exists := keys.zone(topology.GetSegments())
 		if exists {
 			return zone
 		}
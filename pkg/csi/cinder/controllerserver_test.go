@@ -17,12 +17,27 @@ limitations under the License.
 package cinder
 
 import (
+	"errors"
 	"flag"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud"
+	ossnapshots "github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
 )
 
@@ -46,7 +61,8 @@ func TestCreateVolume(t *testing.T) {
 	osmock := new(openstack.OpenStackMock)
 	properties := map[string]string{"cinder.csi.openstack.org/cluster": fakeCluster}
 	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, tags *map[string]string) (string, string, int, error)
-	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), fakeVolType, fakeAvailability, "", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), fakeVolType, fakeAvailability, "", "", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
 	openstack.OsInstance = osmock
 
 	// Init assert
@@ -86,7 +102,8 @@ func TestCreateVolumeFromSnapshot(t *testing.T) {
 	osmock := new(openstack.OpenStackMock)
 	properties := map[string]string{"cinder.csi.openstack.org/cluster": fakeCluster}
 	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, tags *map[string]string) (string, string, int, error)
-	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), fakeVolType, "", fakeSnapshotID, &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), fakeVolType, "", fakeSnapshotID, "", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
 	openstack.OsInstance = osmock
 
 	// Init assert
@@ -154,6 +171,797 @@ func TestCreateVolumeDuplicate(t *testing.T) {
 	assert.Equal("261a8b81-3660-43e5-bab8-6470b65ee4e9", actualRes.Volume.VolumeId)
 }
 
+// Test CreateVolume with a volume of the same name owned by another cluster
+func TestCreateVolumeDuplicateOtherCluster(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name:               "fake-duplicate-other-cluster",
+		VolumeCapabilities: nil,
+	}
+
+	// Invoke CreateVolume
+	_, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+
+	// Assert
+	assert.Equal(codes.AlreadyExists, status.Code(err))
+	assert.Contains(err.Error(), "other-cluster")
+	assert.Contains(err.Error(), fakeCluster)
+}
+
+// Test CreateVolume with a volume of the same name without cluster tag
+func TestCreateVolumeDuplicateUntagged(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name:               "fake-duplicate-untagged",
+		VolumeCapabilities: nil,
+	}
+
+	// Untagged volumes are not adopted by default
+	_, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+	assert.Equal(codes.AlreadyExists, status.Code(err))
+	assert.Contains(err.Error(), "<none>")
+
+	// Unless adoption is allowed
+	fakeCs.Driver.SetAdoptUntaggedVolumes(true)
+	defer fakeCs.Driver.SetAdoptUntaggedVolumes(false)
+
+	actualRes, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+	if err != nil {
+		t.Errorf("failed to CreateVolume: %v", err)
+	}
+	assert.Equal("261a8b81-3660-43e5-bab8-6470b65ee4e9", actualRes.Volume.VolumeId)
+}
+
+// Test CreateVolume cloning a volume
+func TestCreateVolumeFromVolume(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": fakeCluster}
+	osmock.On("CreateVolume", "fake-clone", 1, "", "", "", "fake-source", &properties).Return(fakeVolID, fakeAvailability, 1, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
+	osmock.On("CreateVolume", "fake-clone-missing", 1, "", "", "", "missing", &properties).Return("", "", 0, gophercloud.ErrDefault404{})
+	openstack.OsInstance = osmock
+
+	assert := assert.New(t)
+
+	request := func(name, sourceVolID string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name: name,
+			VolumeContentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Volume{
+					Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: sourceVolID},
+				},
+			},
+		}
+	}
+
+	actualRes, err := fakeCs.CreateVolume(fakeCtx, request("fake-clone", "fake-source"))
+	assert.NoError(err)
+	assert.Equal("fake-source", actualRes.Volume.ContentSource.GetVolume().GetVolumeId())
+
+	_, err = fakeCs.CreateVolume(fakeCtx, request("fake-clone-missing", "missing"))
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	// The hash of the parameters of other volumes is kept
+	params := volumeParameters{sizeGB: 1}
+	clone := volumeParameters{sizeGB: 1, sourceVolID: "fake-source"}
+	assert.NotEqual(params.hash(), clone.hash())
+	assert.Contains(clone.diff(openstack.Volume{Size: 1}), `source volume: requested "fake-source", volume has ""`)
+}
+
+func TestCreateVolumeMultiattach(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": fakeCluster}
+	osmock.On("VolumeTypeMultiattach", "multiattach").Return(true, nil)
+	osmock.On("VolumeTypeMultiattach", "plain").Return(false, nil)
+	osmock.On("CreateVolume", "fake-shared", 1, "multiattach", "", "", "", &properties).Return(fakeVolID, fakeAvailability, 1, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
+	openstack.OsInstance = osmock
+
+	assert := assert.New(t)
+
+	request := func(name, volType string, access *csi.VolumeCapability) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:       name,
+			Parameters: map[string]string{"type": volType},
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: access.AccessType,
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+			}},
+		}
+	}
+	block := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}
+	mount := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}}
+
+	_, err := fakeCs.CreateVolume(fakeCtx, request("fake-shared", "multiattach", block))
+	assert.NoError(err)
+
+	// Only block volumes are shared, of a multiattach type
+	_, err = fakeCs.CreateVolume(fakeCtx, request("fake-shared-mount", "multiattach", mount))
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = fakeCs.CreateVolume(fakeCtx, request("fake-shared-plain", "plain", block))
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	assert.Contains(err.Error(), "volume type plain is not multiattach")
+	_, err = fakeCs.CreateVolume(fakeCtx, request("fake-shared-untyped", "", block))
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+
+	// The parameter requests a multiattach volume for single node modes
+	_, err = fakeCs.CreateVolume(fakeCtx, &csi.CreateVolumeRequest{
+		Name:       "fake-shared-param",
+		Parameters: map[string]string{"type": "plain", "multiattach": "true"},
+	})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = fakeCs.CreateVolume(fakeCtx, &csi.CreateVolumeRequest{
+		Name:       "fake-shared-param",
+		Parameters: map[string]string{"multiattach": "yes please"},
+	})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+
+	// An existing volume of the name is only returned when multiattach
+	_, err = fakeCs.CreateVolume(fakeCtx, request("fake-duplicate", "multiattach", block))
+	assert.Equal(codes.AlreadyExists, status.Code(err))
+
+	osmock.AssertNumberOfCalls(t, "CreateVolume", 1)
+}
+
+// Test CreateVolume with strict idempotency and a volume of the same name
+// created with other parameters
+// Test CreateVolume passing the LUKS parameters to the nodes
+func TestCreateVolumeLUKS(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": fakeCluster}
+	osmock.On("CreateVolume", "fake-luks", 1, "", "", "", "", &properties).Return(fakeVolID, fakeAvailability, 1, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
+	openstack.OsInstance = osmock
+
+	assert := assert.New(t)
+
+	res, err := fakeCs.CreateVolume(fakeCtx, &csi.CreateVolumeRequest{
+		Name:       "fake-luks",
+		Parameters: map[string]string{"luks": "true", "luksBarbicanSecretID": "f5c6e4a6-5b4a-4c43-9b6e-0c1f2e0b7f11"},
+	})
+	assert.NoError(err)
+	assert.Equal(map[string]string{"luks": "true", "luksBarbicanSecretID": "f5c6e4a6-5b4a-4c43-9b6e-0c1f2e0b7f11"}, res.Volume.VolumeContext)
+
+	_, err = fakeCs.CreateVolume(fakeCtx, &csi.CreateVolumeRequest{
+		Name:       "fake-luks-invalid",
+		Parameters: map[string]string{"luks": "maybe"},
+	})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = fakeCs.CreateVolume(fakeCtx, &csi.CreateVolumeRequest{
+		Name:       "fake-luks-secret-only",
+		Parameters: map[string]string{"luksBarbicanSecretID": "f5c6e4a6-5b4a-4c43-9b6e-0c1f2e0b7f11"},
+	})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+
+	osmock.AssertNumberOfCalls(t, "CreateVolume", 1)
+}
+
+// Test CreateVolume checking the filesystem and passing the mkfs options to
+// the nodes
+func TestCreateVolumeFormat(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": fakeCluster}
+	osmock.On("CreateVolume", "fake-xfs", 1, "", "", "", "", &properties).Return(fakeVolID, fakeAvailability, 1, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
+	openstack.OsInstance = osmock
+
+	assert := assert.New(t)
+
+	request := func(name, fsType string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:       name,
+			Parameters: map[string]string{"mkfsOptions": " -i  size=512 "},
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fsType}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+		}
+	}
+	res, err := fakeCs.CreateVolume(fakeCtx, request("fake-xfs", "xfs"))
+	assert.NoError(err)
+	assert.Equal(map[string]string{"mkfsOptions": "-i size=512"}, res.Volume.VolumeContext)
+
+	_, err = fakeCs.CreateVolume(fakeCtx, request("fake-vfat", "vfat"))
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	osmock.AssertNumberOfCalls(t, "CreateVolume", 1)
+
+	// NTFS is formatted by Windows nodes
+	osmock.On("CreateVolume", "fake-ntfs", 1, "", "", "", "", &properties).Return(fakeVolID, fakeAvailability, 1, nil)
+	_, err = fakeCs.CreateVolume(fakeCtx, request("fake-ntfs", "NTFS"))
+	assert.NoError(err)
+}
+
+func TestCreateVolumeParameterDrift(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	fakeCs.Driver.SetStrictIdempotency(true)
+	defer fakeCs.Driver.SetStrictIdempotency(false)
+
+	// newReq returns the request the volume was created with
+	newReq := func() *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:          "fake-duplicate-parameters",
+			CapacityRange: &csi.CapacityRange{RequiredBytes: 1024 * 1024 * 1024},
+			Parameters:    map[string]string{"type": "fake-type", "availability": "nova"},
+			VolumeContentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Snapshot{
+					Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: fakeSnapshotID},
+				},
+			},
+		}
+	}
+
+	// Same parameters, metadata hints may differ
+	assert.NoError(fakeCs.Driver.SetMetadataHints([]string{"image_cache"}))
+	defer fakeCs.Driver.SetMetadataHints(nil)
+	req := newReq()
+	req.Parameters["cinder.csi.openstack.org/image_cache"] = "true"
+	actualRes, err := fakeCs.CreateVolume(fakeCtx, req)
+	if assert.NoError(err) {
+		assert.Equal("261a8b81-3660-43e5-bab8-6470b65ee4e9", actualRes.Volume.VolumeId)
+	}
+
+	tests := []struct {
+		name   string
+		change func(req *csi.CreateVolumeRequest)
+		diff   string
+	}{
+		{
+			name:   "size",
+			change: func(req *csi.CreateVolumeRequest) { req.CapacityRange.RequiredBytes = 2 * 1024 * 1024 * 1024 },
+			diff:   "size: requested 2 GiB, volume has 1 GiB",
+		},
+		{
+			name:   "type",
+			change: func(req *csi.CreateVolumeRequest) { req.Parameters["type"] = "other-type" },
+			diff:   `type: requested "other-type", volume has "fake-type"`,
+		},
+		{
+			name:   "availability",
+			change: func(req *csi.CreateVolumeRequest) { req.Parameters["availability"] = "other-zone" },
+			diff:   `availability: requested "other-zone", volume has "nova"`,
+		},
+		{
+			name:   "source",
+			change: func(req *csi.CreateVolumeRequest) { req.VolumeContentSource = nil },
+			diff:   `source snapshot: requested "", volume has "261a8b81-3660-43e5-bab8-6470b65ee4e8"`,
+		},
+	}
+	for _, test := range tests {
+		req := newReq()
+		test.change(req)
+		_, err := fakeCs.CreateVolume(fakeCtx, req)
+		assert.Equal(codes.AlreadyExists, status.Code(err), test.name)
+		if assert.Error(err, test.name) {
+			assert.Contains(err.Error(), test.diff, test.name)
+		}
+	}
+
+	// Not checked without strict idempotency
+	fakeCs.Driver.SetStrictIdempotency(false)
+	req = newReq()
+	req.Parameters["type"] = "other-type"
+	_, err = fakeCs.CreateVolume(fakeCtx, req)
+	assert.NoError(err)
+}
+
+// Test CreateVolume storing the hash of its parameters with strict idempotency
+func TestCreateVolumeParametersHash(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	properties := map[string]string{
+		"cinder.csi.openstack.org/cluster":         fakeCluster,
+		"cinder.csi.openstack.org/parameters-hash": volumeParameters{sizeGB: 1, volType: fakeVolType, availability: fakeAvailability}.hash(),
+	}
+	osmock.On("CreateVolume", fakeVolName, 1, fakeVolType, fakeAvailability, "", "", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
+
+	openstack.OsInstance = osmock
+
+	fakeCs.Driver.SetStrictIdempotency(true)
+	defer fakeCs.Driver.SetStrictIdempotency(false)
+
+	fakeReq := &csi.CreateVolumeRequest{
+		Name:       fakeVolName,
+		Parameters: map[string]string{"availability": fakeAvailability},
+	}
+	_, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+	assert.NoError(t, err)
+	osmock.AssertExpectations(t)
+}
+
+// Test CreateVolume with metadata hints in the parameters
+func TestCreateVolumeMetadataHints(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	properties := map[string]string{
+		"cinder.csi.openstack.org/cluster": fakeCluster,
+		"image_cache":                      "true",
+	}
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), fakeVolType, fakeAvailability, "", "", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	assert.NoError(fakeCs.Driver.SetMetadataHints([]string{"image_cache", "cacheable"}))
+	defer fakeCs.Driver.SetMetadataHints(nil)
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name:               fakeVolName,
+		VolumeCapabilities: nil,
+		Parameters: map[string]string{
+			"availability":                         fakeAvailability,
+			"cinder.csi.openstack.org/image_cache": "true",
+		},
+	}
+
+	// Invoke CreateVolume
+	actualRes, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+	if err != nil {
+		t.Fatalf("failed to CreateVolume: %v", err)
+	}
+
+	// Assert
+	osmock.AssertExpectations(t)
+	assert.Equal(map[string]string{"cinder.csi.openstack.org/image_cache": "true"}, actualRes.Volume.VolumeContext)
+
+	// Hints not in the allowlist are rejected
+	fakeReq.Parameters["cinder.csi.openstack.org/cluster"] = "other-cluster"
+	_, err = fakeCs.CreateVolume(fakeCtx, fakeReq)
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	assert.Contains(err.Error(), "cacheable, image_cache")
+	delete(fakeReq.Parameters, "cinder.csi.openstack.org/cluster")
+
+	// Longer than Cinder metadata
+	fakeReq.Parameters["cinder.csi.openstack.org/cacheable"] = strings.Repeat("x", 256)
+	_, err = fakeCs.CreateVolume(fakeCtx, fakeReq)
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+
+	assert.Error(fakeCs.Driver.SetMetadataHints([]string{"cinder.csi.openstack.org/cluster"}))
+}
+
+// Test CreateVolume with a volume of the same name stuck in creating
+func TestCreateVolumeStuckCreating(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": fakeCluster}
+	osmock.On("ResetVolumeStatus", "261a8b81-3660-43e5-bab8-6470b65ee4e9", openstack.VolumeErrorStatus).Return(errors.New("policy does not allow volume_extension:volume_admin_actions:reset_status"))
+	osmock.On("DeleteVolume", "261a8b81-3660-43e5-bab8-6470b65ee4e9").Return(nil)
+	osmock.On("CreateVolume", "fake-duplicate-stuck", mock.AnythingOfType("int"), "", "", "", "", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	pvc := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"}}
+	recorder := record.NewFakeRecorder(1)
+	fakeCs.Driver.events = &pvcEvents{client: fake.NewSimpleClientset(pvc), recorder: recorder}
+	fakeCs.Driver.SetCreatingDeadline(10 * time.Minute)
+	defer func() {
+		fakeCs.Driver.events = nil
+		fakeCs.Driver.SetCreatingDeadline(0)
+	}()
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name:               "fake-duplicate-stuck",
+		VolumeCapabilities: nil,
+		Parameters: map[string]string{
+			pvcNameParameter:      "data",
+			pvcNamespaceParameter: "default",
+		},
+	}
+
+	// Invoke CreateVolume
+	actualRes, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+	if err != nil {
+		t.Fatalf("failed to CreateVolume: %v", err)
+	}
+
+	// Assert
+	osmock.AssertCalled(t, "DeleteVolume", "261a8b81-3660-43e5-bab8-6470b65ee4e9")
+	assert.Equal(fakeVolID, actualRes.Volume.VolumeId)
+	select {
+	case event := <-recorder.Events:
+		assert.Contains(event, creatingDeadlineReason)
+		assert.Contains(event, "261a8b81-3660-43e5-bab8-6470b65ee4e9")
+	default:
+		t.Errorf("expected an event on the PVC")
+	}
+}
+
+// Test CreateVolume with a volume of the same name in creating
+func TestCreateVolumeCreating(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolume", "261a8b81-3660-43e5-bab8-6470b65ee4e9").Return(openstack.Volume{ID: "261a8b81-3660-43e5-bab8-6470b65ee4e9", Status: openstack.VolumeCreatingStatus}, nil).Once()
+	osmock.On("GetVolume", "261a8b81-3660-43e5-bab8-6470b65ee4e9").Return(openstack.Volume{ID: "261a8b81-3660-43e5-bab8-6470b65ee4e9", Status: openstack.VolumeAvailableStatus, AZ: "nova", Size: 1}, nil)
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	fakeCs.Driver.SetCreatingDeadline(10 * time.Minute)
+	defer fakeCs.Driver.SetCreatingDeadline(0)
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name:               "fake-duplicate-creating",
+		VolumeCapabilities: nil,
+	}
+
+	// The volume is waited for
+	actualRes, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+	if err != nil {
+		t.Fatalf("failed to CreateVolume: %v", err)
+	}
+	assert.Equal("261a8b81-3660-43e5-bab8-6470b65ee4e9", actualRes.Volume.VolumeId)
+	osmock.AssertNotCalled(t, "DeleteVolume", mock.Anything)
+
+	// Until the call times out, the volume is not deleted before the deadline
+	osmock = new(openstack.OpenStackMock)
+	osmock.On("GetVolume", "261a8b81-3660-43e5-bab8-6470b65ee4e9").Return(openstack.Volume{ID: "261a8b81-3660-43e5-bab8-6470b65ee4e9", Status: openstack.VolumeCreatingStatus}, nil)
+	openstack.OsInstance = osmock
+
+	ctx, cancel := context.WithTimeout(fakeCtx, 100*time.Millisecond)
+	defer cancel()
+	_, err = fakeCs.CreateVolume(ctx, fakeReq)
+	assert.Equal(codes.Unavailable, status.Code(err))
+	osmock.AssertNotCalled(t, "DeleteVolume", mock.Anything)
+}
+
+// Test CreateVolume with a volume of the same name of another cluster stuck in creating
+func TestCreateVolumeStuckCreatingOtherCluster(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	openstack.OsInstance = osmock
+
+	fakeCs.Driver.SetCreatingDeadline(10 * time.Minute)
+	defer fakeCs.Driver.SetCreatingDeadline(0)
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name:               "fake-duplicate-stuck-other-cluster",
+		VolumeCapabilities: nil,
+	}
+
+	// Invoke CreateVolume
+	_, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+
+	// Assert
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	osmock.AssertNotCalled(t, "ResetVolumeStatus", mock.Anything, mock.Anything)
+	osmock.AssertNotCalled(t, "DeleteVolume", mock.Anything)
+}
+
+// metricValue returns the count of a histogram or the value of a counter.
+func metricValue(t *testing.T, m prometheus.Metric) float64 {
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		t.Fatalf("failed to read metric: %v", err)
+	}
+	if h := pb.GetHistogram(); h != nil {
+		return float64(h.GetSampleCount())
+	}
+	return pb.GetCounter().GetValue()
+}
+
+// Test the time to available of CreateVolume, with and without a creating deadline
+func TestCreateVolumeTimeToAvailable(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": fakeCluster}
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), "fast", fakeAvailability, "", "", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeCreatingStatus}, nil).Once()
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus, AZ: fakeAvailability}, nil)
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	savedBackoff := creatingBackoff
+	creatingBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 1000}
+	fakeCs.Driver.SetCreatingDeadline(10 * time.Minute)
+	fakeCs.Driver.SetMetricsVolumeTypes([]string{"fast"})
+	defer func() {
+		creatingBackoff = savedBackoff
+		fakeCs.Driver.SetCreatingDeadline(0)
+		fakeCs.Driver.SetMetricsVolumeTypes(nil)
+	}()
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name:       fakeVolName,
+		Parameters: map[string]string{"type": "fast"},
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{{Segments: map[string]string{topologyKey: fakeAvailability}}},
+		},
+	}
+
+	histogram := volumeTimeToAvailable.WithLabelValues("fast", fakeAvailability).(prometheus.Metric)
+	before := metricValue(t, histogram)
+	_, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+	if err != nil {
+		t.Fatalf("failed to CreateVolume: %v", err)
+	}
+	assert.Equal(before+1, metricValue(t, histogram))
+
+	// It is recorded without a deadline too, once CreateVolume returned
+	fakeCs.Driver.SetCreatingDeadline(0)
+	_, err = fakeCs.CreateVolume(fakeCtx, fakeReq)
+	if err != nil {
+		t.Fatalf("failed to CreateVolume: %v", err)
+	}
+	err = wait.PollImmediate(time.Millisecond, time.Second, func() (bool, error) {
+		return metricValue(t, histogram) == before+2, nil
+	})
+	assert.NoError(err, "expected the time to available to be recorded without a deadline")
+
+	// Unknown types share a label
+	assert.Equal([]string{volumeTypeLabelOther, "nova"}, fakeCs.Driver.volumeAvailableWait(time.Now(), "slow", "nova").labels)
+	assert.Equal([]string{volumeTypeLabelDefault, "nova"}, fakeCs.Driver.volumeAvailableWait(time.Now(), "", "nova").labels)
+
+	// Only the deadline expiring counts, not the caller giving up
+	osmock = new(openstack.OpenStackMock)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeCreatingStatus}, nil)
+	counter := volumeAvailableDeadlineExceeded.WithLabelValues("fast", fakeAvailability)
+	before = metricValue(t, counter)
+	available := fakeCs.Driver.volumeAvailableWait(time.Now(), "fast", fakeAvailability)
+
+	_, err = fakeCs.waitVolumeCreated(fakeCtx, osmock, fakeVolID, 10*time.Millisecond, available)
+	assert.Equal(codes.Unavailable, status.Code(err))
+	assert.Equal(before+1, metricValue(t, counter))
+
+	ctx, cancel := context.WithCancel(fakeCtx)
+	cancel()
+	_, err = fakeCs.waitVolumeCreated(ctx, osmock, fakeVolID, time.Minute, available)
+	assert.Equal(codes.Unavailable, status.Code(err))
+	assert.Equal(before+1, metricValue(t, counter))
+}
+
+// Test CreateVolume from a snapshot of an encrypted volume
+func TestCreateVolumeEncryptionBoundary(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("VolumeTypeEncrypted", "plain").Return(false, nil).Once()
+	osmock.On("VolumeTypeEncrypted", "luks").Return(true, nil).Once()
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	fakeCs.Driver.SetEncryptionBoundary(true)
+	defer fakeCs.Driver.SetEncryptionBoundary(false)
+
+	snapshotID := "261a8b81-3660-43e5-bab8-6470b65ee4e7"
+	request := func(params map[string]string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:       fakeVolName,
+			Parameters: params,
+			VolumeContentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Snapshot{
+					Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshotID},
+				},
+			},
+		}
+	}
+
+	// Refused into an unencrypted type
+	_, err := fakeCs.CreateVolume(fakeCtx, request(map[string]string{"type": "plain"}))
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	osmock.AssertNotCalled(t, "CreateVolume", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// Unless overridden, the encryption of the type is cached
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": fakeCluster}
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), "plain", "", snapshotID, "", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
+	_, err = fakeCs.CreateVolume(fakeCtx, request(map[string]string{"type": "plain", "allowUnencryptedRestore": "true"}))
+	assert.NoError(err)
+
+	// Allowed into an encrypted type, or the type of the source volume
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), "luks", "", snapshotID, "", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	_, err = fakeCs.CreateVolume(fakeCtx, request(map[string]string{"type": "luks"}))
+	assert.NoError(err)
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), "", "", snapshotID, "", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	_, err = fakeCs.CreateVolume(fakeCtx, request(nil))
+	assert.NoError(err)
+	osmock.AssertNumberOfCalls(t, "VolumeTypeEncrypted", 2)
+}
+
+// Test CreateVolume cloning an encrypted volume
+func TestCreateVolumeCloneEncryptionBoundary(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolume", "encrypted").Return(openstack.Volume{ID: "encrypted", VolumeType: "luks"}, nil)
+	osmock.On("GetVolume", "unencrypted").Return(openstack.Volume{ID: "unencrypted", VolumeType: "plain"}, nil)
+	osmock.On("VolumeTypeEncrypted", "plain").Return(false, nil).Once()
+	osmock.On("VolumeTypeEncrypted", "luks").Return(true, nil).Once()
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	fakeCs.Driver.SetEncryptionBoundary(true)
+	defer fakeCs.Driver.SetEncryptionBoundary(false)
+
+	request := func(sourceVolID string, params map[string]string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:       fakeVolName,
+			Parameters: params,
+			VolumeContentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Volume{
+					Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: sourceVolID},
+				},
+			},
+		}
+	}
+
+	// Refused into an unencrypted type
+	_, err := fakeCs.CreateVolume(fakeCtx, request("encrypted", map[string]string{"type": "plain"}))
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	osmock.AssertNotCalled(t, "CreateVolume", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// Unless overridden, the encryption of the types is cached
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": fakeCluster}
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), "plain", "", "", "encrypted", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
+	_, err = fakeCs.CreateVolume(fakeCtx, request("encrypted", map[string]string{"type": "plain", "allowUnencryptedRestore": "true"}))
+	assert.NoError(err)
+
+	// Allowed from an unencrypted volume, or into the type of the source
+	// volume
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), "luks", "", "", "unencrypted", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	_, err = fakeCs.CreateVolume(fakeCtx, request("unencrypted", map[string]string{"type": "luks"}))
+	assert.NoError(err)
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), "", "", "", "encrypted", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	_, err = fakeCs.CreateVolume(fakeCtx, request("encrypted", nil))
+	assert.NoError(err)
+	osmock.AssertNumberOfCalls(t, "VolumeTypeEncrypted", 2)
+
+	// A missing source volume
+	osmock.On("GetVolume", "missing").Return(openstack.Volume{}, gophercloud.ErrDefault404{})
+	_, err = fakeCs.CreateVolume(fakeCtx, request("missing", map[string]string{"type": "plain"}))
+	assert.Equal(codes.NotFound, status.Code(err))
+}
+
+// Test CreateVolume with the parameters validated against the cloud
+func TestCreateVolumeValidateParameters(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("ListVolumeTypes").Return([]openstack.VolumeType{{ID: "a1b2", Name: "ssd"}, {ID: "c3d4", Name: "hdd"}}, nil)
+	osmock.On("ListAvailabilityZones").Return([]string{fakeAvailability}, nil)
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	fakeCs.Driver.SetValidateParameters(true)
+	defer fakeCs.Driver.SetValidateParameters(false)
+	now := time.Now()
+	fakeCs.Driver.cloudParameters.now = func() time.Time { return now }
+
+	request := func(params map[string]string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{Name: fakeVolName, Parameters: params}
+	}
+
+	// Unknown type or zone
+	_, err := fakeCs.CreateVolume(fakeCtx, request(map[string]string{"type": "nvme"}))
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	assert.Contains(err.Error(), `volume type "nvme" does not exist, available: hdd, ssd`)
+	_, err = fakeCs.CreateVolume(fakeCtx, request(map[string]string{"availability": "az-2"}))
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	assert.Contains(err.Error(), `availability zone "az-2" does not exist, available: nova`)
+	osmock.AssertNotCalled(t, "CreateVolume", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	// Listed once, the invalid parameters do not refresh the lists right away
+	osmock.AssertNumberOfCalls(t, "ListVolumeTypes", 1)
+
+	// A type by name or ID, in a known zone
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": fakeCluster}
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), "ssd", fakeAvailability, "", "", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), "c3d4", "", "", "", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	_, err = fakeCs.CreateVolume(fakeCtx, request(map[string]string{"type": "ssd", "availability": fakeAvailability}))
+	assert.NoError(err)
+	_, err = fakeCs.CreateVolume(fakeCtx, request(map[string]string{"type": "c3d4"}))
+	assert.NoError(err)
+	osmock.AssertNumberOfCalls(t, "ListVolumeTypes", 1)
+
+	// A missing type refreshes the lists after a while
+	now = now.Add(cloudParametersMinRefresh)
+	_, err = fakeCs.CreateVolume(fakeCtx, request(map[string]string{"type": "nvme"}))
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	osmock.AssertNumberOfCalls(t, "ListVolumeTypes", 2)
+}
+
+// Test the validation of parameters whose lists cannot be fetched
+func TestValidateParametersListFailure(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("ListVolumeTypes").Return(nil, errors.New("forbidden"))
+	osmock.On("ListAvailabilityZones").Return([]string{"az-1"}, nil)
+
+	params := newCloudParameters()
+	assert.NoError(t, params.validate(osmock, "", "nvme", ""))
+	assert.Equal(t, codes.InvalidArgument, status.Code(params.validate(osmock, "", "nvme", "az-2")))
+	// Empty parameters are not listed
+	assert.NoError(t, params.validate(osmock, "region-2", "", ""))
+	osmock.AssertNumberOfCalls(t, "ListAvailabilityZones", 1)
+}
+
+// Test the marker of the snapshots of encrypted volumes
+func TestMarkEncryptedSnapshot(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolume", "encrypted").Return(openstack.Volume{ID: "encrypted", VolumeType: "luks"}, nil)
+	osmock.On("GetVolume", "plain").Return(openstack.Volume{ID: "plain", VolumeType: "plain"}, nil)
+	osmock.On("VolumeTypeEncrypted", "luks").Return(true, nil)
+	osmock.On("VolumeTypeEncrypted", "plain").Return(false, nil)
+
+	// Init assert
+	assert := assert.New(t)
+
+	fakeCs.Driver.SetEncryptionBoundary(true)
+	defer fakeCs.Driver.SetEncryptionBoundary(false)
+
+	params := map[string]string{"foo": "bar"}
+	metadata, err := fakeCs.markEncryptedSnapshot(osmock, "encrypted", params)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"foo": "bar", encryptedMetadataKey: "true"}, metadata)
+	assert.Equal(map[string]string{"foo": "bar"}, params, "the parameters are not modified")
+
+	metadata, err = fakeCs.markEncryptedSnapshot(osmock, "plain", params)
+	assert.NoError(err)
+	assert.Equal(params, metadata)
+
+	// The marker of the parameters is replaced
+	params = map[string]string{"foo": "bar", encryptedMetadataKey: "false"}
+	metadata, err = fakeCs.markEncryptedSnapshot(osmock, "encrypted", params)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"foo": "bar", encryptedMetadataKey: "true"}, metadata)
+	params[encryptedMetadataKey] = "true"
+	metadata, err = fakeCs.markEncryptedSnapshot(osmock, "plain", params)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"foo": "bar"}, metadata)
+}
+
 // Test DeleteVolume
 func TestDeleteVolume(t *testing.T) {
 
@@ -189,6 +997,7 @@ func TestControllerPublishVolume(t *testing.T) {
 
 	// mock OpenStack
 	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
 	// AttachVolume(instanceID, volumeID string) (string, error)
 	osmock.On("AttachVolume", fakeNodeID, fakeVolID).Return(fakeVolID, nil)
 	// WaitDiskAttached(instanceID string, volumeID string) error
@@ -230,6 +1039,7 @@ func TestControllerUnpublishVolume(t *testing.T) {
 
 	// mock OpenStack
 	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeInUseStatus}, nil)
 	// DetachVolume(instanceID, volumeID string) error
 	osmock.On("DetachVolume", fakeNodeID, fakeVolID).Return(nil)
 	// WaitDiskDetached(instanceID string, volumeID string) error
@@ -258,6 +1068,182 @@ func TestControllerUnpublishVolume(t *testing.T) {
 	assert.Equal(expectedRes, actualRes)
 }
 
+// shortSettleBackoff makes waitVolumeSettled give up quickly, until the
+// returned function is called.
+func shortSettleBackoff() func() {
+	saved := settleBackoff
+	settleBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}
+	return func() { settleBackoff = saved }
+}
+
+// A volume still detaching from another node is waited for, and attached
+// once available.
+func TestControllerPublishVolumeWaitsForDetaching(t *testing.T) {
+	defer shortSettleBackoff()()
+
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeDetachingStatus}, nil).Once()
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
+	osmock.On("AttachVolume", fakeNodeID, fakeVolID).Return(fakeVolID, nil)
+	osmock.On("WaitDiskAttached", fakeNodeID, fakeVolID).Return(nil)
+	osmock.On("GetAttachmentDiskPath", fakeNodeID, fakeVolID).Return(fakeDevicePath, nil)
+	openstack.OsInstance = osmock
+
+	_, err := fakeCs.ControllerPublishVolume(fakeCtx, &csi.ControllerPublishVolumeRequest{VolumeId: fakeVolID, NodeId: fakeNodeID})
+	assert.NoError(t, err)
+	osmock.AssertNumberOfCalls(t, "GetVolume", 2)
+}
+
+// A volume that does not settle, or a cloud failing transiently, is
+// Unavailable, for the sidecar to retry without attaching.
+func TestControllerPublishVolumeUnavailable(t *testing.T) {
+	defer shortSettleBackoff()()
+
+	for _, getVolume := range []func(string) error{
+		func(string) error { return nil },
+		func(string) error { return gophercloud.ErrDefault503{} },
+	} {
+		osmock := new(openstack.OpenStackMock)
+		osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAttachingStatus}, getVolume)
+		openstack.OsInstance = osmock
+
+		_, err := fakeCs.ControllerPublishVolume(fakeCtx, &csi.ControllerPublishVolumeRequest{VolumeId: fakeVolID, NodeId: fakeNodeID})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		osmock.AssertNotCalled(t, "AttachVolume", fakeNodeID, fakeVolID)
+	}
+}
+
+// A transient failure of the attachment is Unavailable.
+func TestControllerPublishVolumeTransientError(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
+	osmock.On("AttachVolume", fakeNodeID, fakeVolID).Return("", gophercloud.ErrDefault500{})
+	openstack.OsInstance = osmock
+
+	_, err := fakeCs.ControllerPublishVolume(fakeCtx, &csi.ControllerPublishVolumeRequest{VolumeId: fakeVolID, NodeId: fakeNodeID})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+// A request on a volume with another operation in progress is aborted
+// without calling Cinder.
+func TestControllerPublishVolumeInProgress(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	openstack.OsInstance = osmock
+
+	assert.NoError(t, fakeCs.volumeLocks.acquire(fakeVolID, "ControllerUnpublishVolume from node other"))
+	defer fakeCs.volumeLocks.release(fakeVolID)
+
+	_, err := fakeCs.ControllerPublishVolume(fakeCtx, &csi.ControllerPublishVolumeRequest{VolumeId: fakeVolID, NodeId: fakeNodeID})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	osmock.AssertNotCalled(t, "GetVolume", fakeVolID)
+}
+
+// A volume gone is unpublished from every node.
+func TestControllerUnpublishVolumeNotFound(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolume", "missing").Return(openstack.Volume{}, gophercloud.ErrDefault404{})
+	openstack.OsInstance = osmock
+
+	_, err := fakeCs.ControllerUnpublishVolume(fakeCtx, &csi.ControllerUnpublishVolumeRequest{VolumeId: "missing", NodeId: fakeNodeID})
+	assert.NoError(t, err)
+	osmock.AssertNotCalled(t, "DetachVolume", fakeNodeID, "missing")
+}
+
+// A CreateVolume of a name being created is aborted, so that its retries do
+// not create the volume twice.
+func TestCreateVolumeInProgress(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	openstack.OsInstance = osmock
+
+	assert.NoError(t, fakeCs.createLocks.acquire("pvc-in-progress", "CreateVolume"))
+	defer fakeCs.createLocks.release("pvc-in-progress")
+
+	_, err := fakeCs.CreateVolume(fakeCtx, &csi.CreateVolumeRequest{Name: "pvc-in-progress"})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	osmock.AssertNotCalled(t, "GetVolumesByName", "pvc-in-progress")
+}
+
+// withExtraRegion sets the OpenStack instance of an extra region, and returns
+// a func removing it.
+func withExtraRegion(region string, cloud openstack.IOpenStack) func() {
+	openstack.OsRegionInstances = map[string]openstack.IOpenStack{region: cloud}
+	return func() {
+		openstack.OsRegionInstances = nil
+	}
+}
+
+// CreateVolume creates the volume in the region of the region parameter, or
+// of the topology, the volume being accessible from that region only then.
+func TestCreateVolumeExtraRegion(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	openstack.OsInstance = osmock
+	regionmock := new(openstack.OpenStackMock)
+	defer withExtraRegion("RegionTwo", regionmock)()
+
+	properties := map[string]string{clusterMetadataKey: fakeCluster}
+	regionmock.On("CreateVolume", "pvc-region-parameter", 1, "", "", "", "", &properties).Return("vol-parameter", fakeAvailability, 1, nil)
+	regionmock.On("GetVolume", "vol-parameter").Return(openstack.Volume{ID: "vol-parameter", Status: openstack.VolumeAvailableStatus}, nil)
+	regionmock.On("CreateVolume", "pvc-region-topology", 1, "", fakeAvailability, "", "", &properties).Return("vol-topology", fakeAvailability, 1, nil)
+	regionmock.On("GetVolume", "vol-topology").Return(openstack.Volume{ID: "vol-topology", Status: openstack.VolumeAvailableStatus}, nil)
+
+	res, err := fakeCs.CreateVolume(fakeCtx, &csi.CreateVolumeRequest{
+		Name:       "pvc-region-parameter",
+		Parameters: map[string]string{regionParameter: "RegionTwo"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{topologyKey: fakeAvailability}, res.Volume.AccessibleTopology[0].GetSegments())
+
+	segments := map[string]string{topologyKey: fakeAvailability, regionTopologyKey: "RegionTwo"}
+	res, err = fakeCs.CreateVolume(fakeCtx, &csi.CreateVolumeRequest{
+		Name: "pvc-region-topology",
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Preferred: []*csi.Topology{{Segments: segments}},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, segments, res.Volume.AccessibleTopology[0].GetSegments())
+	osmock.AssertNotCalled(t, "CreateVolume", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// The created volumes are deleted in their region without looking for
+	// them
+	regionmock.On("DeleteVolume", "vol-parameter").Return(nil)
+	_, err = fakeCs.DeleteVolume(fakeCtx, &csi.DeleteVolumeRequest{VolumeId: "vol-parameter"})
+	assert.NoError(t, err)
+	osmock.AssertNotCalled(t, "GetVolume", "vol-parameter")
+
+	_, err = fakeCs.CreateVolume(fakeCtx, &csi.CreateVolumeRequest{
+		Name:       "pvc-region-unknown",
+		Parameters: map[string]string{regionParameter: "RegionThree"},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// A volume not found in the region of the cloud config is looked for in the
+// extra regions.
+func TestControllerExpandVolumeExtraRegion(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolume", "vol-elsewhere").Return(openstack.Volume{}, gophercloud.ErrDefault404{})
+	openstack.OsInstance = osmock
+	regionmock := new(openstack.OpenStackMock)
+	regionmock.On("GetVolume", "vol-elsewhere").Return(openstack.Volume{ID: "vol-elsewhere", Size: 1, Status: openstack.VolumeAvailableStatus}, nil)
+	regionmock.On("ExpandVolume", "vol-elsewhere", 2).Return(nil)
+	defer withExtraRegion("RegionTwo", regionmock)()
+	defer fakeCs.volumeRegions.forget("vol-elsewhere")
+
+	res, err := fakeCs.ControllerExpandVolume(fakeCtx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      "vol-elsewhere",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * 1024 * 1024 * 1024},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2*1024*1024*1024), res.CapacityBytes)
+	regionmock.AssertCalled(t, "ExpandVolume", "vol-elsewhere", 2)
+
+	// The region is remembered
+	region, ok := fakeCs.volumeRegions.get("vol-elsewhere")
+	assert.True(t, ok)
+	assert.Equal(t, "RegionTwo", region)
+}
+
 func TestListVolumes(t *testing.T) {
 	// mock OpenStack
 	osmock := new(openstack.OpenStackMock)
@@ -284,6 +1270,108 @@ func TestListVolumes(t *testing.T) {
 	assert.Equal(expectedRes, actualRes)
 }
 
+// Test ControllerExpandVolume
+func TestControllerExpandVolume(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Size: 1, Status: openstack.VolumeAvailableStatus}, nil)
+	osmock.On("GetVolume", "vol-in-use").Return(openstack.Volume{ID: "vol-in-use", Size: 1, Status: openstack.VolumeInUseStatus}, nil)
+	osmock.On("ExpandVolume", fakeVolID, 5).Return(nil)
+	openstack.OsInstance = osmock
+
+	assert := assert.New(t)
+
+	request := func(volumeID string, sizeGB int64) *csi.ControllerExpandVolumeRequest {
+		return &csi.ControllerExpandVolumeRequest{
+			VolumeId:      volumeID,
+			CapacityRange: &csi.CapacityRange{RequiredBytes: sizeGB * 1024 * 1024 * 1024},
+		}
+	}
+
+	actualRes, err := fakeCs.ControllerExpandVolume(fakeCtx, request(fakeVolID, 5))
+	assert.NoError(err)
+	assert.Equal(&csi.ControllerExpandVolumeResponse{CapacityBytes: 5 * 1024 * 1024 * 1024, NodeExpansionRequired: true}, actualRes)
+
+	// A volume already as large is not extended again
+	actualRes, err = fakeCs.ControllerExpandVolume(fakeCtx, request(fakeVolID, 1))
+	assert.NoError(err)
+	assert.Equal(int64(1024*1024*1024), actualRes.GetCapacityBytes())
+	osmock.AssertNumberOfCalls(t, "ExpandVolume", 1)
+
+	_, err = fakeCs.ControllerExpandVolume(fakeCtx, request("vol-in-use", 5))
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+
+	_, err = fakeCs.ControllerExpandVolume(fakeCtx, &csi.ControllerExpandVolumeRequest{VolumeId: fakeVolID})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+// Test ValidateVolumeCapabilities
+func TestValidateVolumeCapabilities(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: "available"}, nil)
+	osmock.On("GetVolume", "missing").Return(openstack.Volume{}, gophercloud.ErrDefault404{})
+	osmock.On("GetVolume", "shared").Return(openstack.Volume{ID: "shared", Status: "available", Multiattach: true}, nil)
+	openstack.OsInstance = osmock
+
+	assert := assert.New(t)
+
+	mode := func(m csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability_AccessMode {
+		return &csi.VolumeCapability_AccessMode{Mode: m}
+	}
+	blockCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: mode(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+	}
+	mountCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: mode(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+	}
+	multiCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: mode(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+	}
+
+	res, err := fakeCs.ValidateVolumeCapabilities(fakeCtx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           fakeVolID,
+		VolumeCapabilities: []*csi.VolumeCapability{blockCap, mountCap},
+	})
+	assert.NoError(err)
+	assert.Equal([]*csi.VolumeCapability{blockCap, mountCap}, res.GetConfirmed().GetVolumeCapabilities())
+
+	res, err = fakeCs.ValidateVolumeCapabilities(fakeCtx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           fakeVolID,
+		VolumeCapabilities: []*csi.VolumeCapability{blockCap, multiCap},
+	})
+	assert.NoError(err)
+	assert.Nil(res.GetConfirmed())
+	assert.Contains(res.GetMessage(), "MULTI_NODE_MULTI_WRITER")
+
+	// Multiattach volumes are shared as block volumes only
+	res, err = fakeCs.ValidateVolumeCapabilities(fakeCtx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           "shared",
+		VolumeCapabilities: []*csi.VolumeCapability{blockCap, multiCap},
+	})
+	assert.NoError(err)
+	assert.NotNil(res.GetConfirmed())
+	res, err = fakeCs.ValidateVolumeCapabilities(fakeCtx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId: "shared",
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: mountCap.AccessType,
+			AccessMode: multiCap.AccessMode,
+		}},
+	})
+	assert.NoError(err)
+	assert.Contains(res.GetMessage(), "only supported for block volumes")
+
+	_, err = fakeCs.ValidateVolumeCapabilities(fakeCtx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           "missing",
+		VolumeCapabilities: []*csi.VolumeCapability{blockCap},
+	})
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	_, err = fakeCs.ValidateVolumeCapabilities(fakeCtx, &csi.ValidateVolumeCapabilitiesRequest{VolumeId: fakeVolID})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+}
+
 // Test CreateSnapshot
 func TestCreateSnapshot(t *testing.T) {
 	// mock OpenStack
@@ -314,6 +1402,91 @@ func TestCreateSnapshot(t *testing.T) {
 	assert.NotNil(fakeSnapshotID, actualRes.Snapshot.SnapshotId)
 }
 
+// Test CreateSnapshot and DeleteVolume running concurrently on a volume
+func TestCreateSnapshotDeleteVolumeConcurrent(t *testing.T) {
+	snapshotting := make(chan struct{})
+	release := make(chan struct{})
+
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("CreateSnapshot", fakeSnapshotName, fakeVolID, "", &map[string]string{}).Return(&fakeSnapshotRes, nil)
+	// The snapshot stays in creating until released
+	osmock.On("WaitSnapshotReady", fakeSnapshotID).Run(func(mock.Arguments) {
+		close(snapshotting)
+		<-release
+	}).Return(nil)
+	osmock.On("DeleteVolume", fakeVolID).Return(nil)
+	openstack.OsInstance = osmock
+
+	snapshotErr := make(chan error)
+	go func() {
+		_, err := fakeCs.CreateSnapshot(fakeCtx, &csi.CreateSnapshotRequest{
+			Name:           fakeSnapshotName,
+			SourceVolumeId: fakeVolID,
+			Parameters:     map[string]string{},
+		})
+		snapshotErr <- err
+	}()
+	<-snapshotting
+
+	// The loser is aborted without calling Cinder
+	deleteReq := &csi.DeleteVolumeRequest{VolumeId: fakeVolID}
+	_, err := fakeCs.DeleteVolume(fakeCtx, deleteReq)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	osmock.AssertNotCalled(t, "DeleteVolume", fakeVolID)
+
+	// The winner completes, then the retry succeeds
+	close(release)
+	assert.NoError(t, <-snapshotErr)
+	_, err = fakeCs.DeleteVolume(fakeCtx, deleteReq)
+	assert.NoError(t, err)
+	osmock.AssertCalled(t, "DeleteVolume", fakeVolID)
+}
+
+// Test ControllerExpandVolume and CreateSnapshot running concurrently on a volume
+func TestControllerExpandVolumeCreateSnapshotConcurrent(t *testing.T) {
+	expanding := make(chan struct{})
+	release := make(chan struct{})
+
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Size: 1, Status: openstack.VolumeAvailableStatus}, nil)
+	// The volume stays in extending until released
+	osmock.On("ExpandVolume", fakeVolID, 2).Run(func(mock.Arguments) {
+		close(expanding)
+		<-release
+	}).Return(nil)
+	osmock.On("CreateSnapshot", fakeSnapshotName, fakeVolID, "", &map[string]string{}).Return(&fakeSnapshotRes, nil)
+	osmock.On("WaitSnapshotReady", fakeSnapshotID).Return(nil)
+	openstack.OsInstance = osmock
+
+	expandErr := make(chan error)
+	go func() {
+		_, err := fakeCs.ControllerExpandVolume(fakeCtx, &csi.ControllerExpandVolumeRequest{
+			VolumeId:      fakeVolID,
+			CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * 1024 * 1024 * 1024},
+		})
+		expandErr <- err
+	}()
+	<-expanding
+
+	// The loser is aborted without calling Cinder
+	snapshotReq := &csi.CreateSnapshotRequest{
+		Name:           fakeSnapshotName,
+		SourceVolumeId: fakeVolID,
+		Parameters:     map[string]string{},
+	}
+	_, err := fakeCs.CreateSnapshot(fakeCtx, snapshotReq)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Contains(t, err.Error(), "ControllerExpandVolume")
+	osmock.AssertNotCalled(t, "CreateSnapshot", fakeSnapshotName, fakeVolID, "", &map[string]string{})
+
+	// The winner completes, then the retry succeeds
+	close(release)
+	assert.NoError(t, <-expandErr)
+	_, err = fakeCs.CreateSnapshot(fakeCtx, snapshotReq)
+	assert.NoError(t, err)
+	osmock.AssertCalled(t, "CreateSnapshot", fakeSnapshotName, fakeVolID, "", &map[string]string{})
+}
+
 // Test DeleteSnapshot
 func TestDeleteSnapshot(t *testing.T) {
 
@@ -368,3 +1541,163 @@ func TestListSnapshots(t *testing.T) {
 
 	assert.NotNil(fakeSnapshotID, actualRes.Entries[0].Snapshot.SnapshotId)
 }
+
+// Test ListSnapshots of one snapshot and paginated
+func TestListSnapshotsFiltered(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	creating := ossnapshots.Snapshot{ID: fakeSnapshotID, VolumeID: fakeVolID, Status: "creating"}
+	osmock.On("GetSnapshotByID", fakeSnapshotID).Return(&creating, nil)
+	osmock.On("GetSnapshotByID", "missing").Return(nil, gophercloud.ErrDefault404{})
+	page := []ossnapshots.Snapshot{
+		{ID: "snap-1", VolumeID: fakeVolID, Status: openstack.SnapshotReadyStatus},
+		{ID: "snap-2", VolumeID: fakeVolID, Status: openstack.SnapshotReadyStatus},
+	}
+	osmock.On("ListSnapshots", 2, 4, map[string]string{"VolumeID": fakeVolID}).Return(page, nil)
+	openstack.OsInstance = osmock
+
+	assert := assert.New(t)
+
+	res, err := fakeCs.ListSnapshots(fakeCtx, &csi.ListSnapshotsRequest{SnapshotId: fakeSnapshotID})
+	assert.NoError(err)
+	assert.Len(res.Entries, 1)
+	assert.False(res.Entries[0].Snapshot.ReadyToUse)
+
+	res, err = fakeCs.ListSnapshots(fakeCtx, &csi.ListSnapshotsRequest{SnapshotId: fakeSnapshotID, SourceVolumeId: "other"})
+	assert.NoError(err)
+	assert.Empty(res.Entries)
+
+	res, err = fakeCs.ListSnapshots(fakeCtx, &csi.ListSnapshotsRequest{SnapshotId: "missing"})
+	assert.NoError(err)
+	assert.Empty(res.Entries)
+
+	res, err = fakeCs.ListSnapshots(fakeCtx, &csi.ListSnapshotsRequest{SourceVolumeId: fakeVolID, MaxEntries: 2, StartingToken: "4"})
+	assert.NoError(err)
+	assert.Len(res.Entries, 2)
+	assert.True(res.Entries[1].Snapshot.ReadyToUse)
+	assert.Equal("6", res.NextToken)
+
+	_, err = fakeCs.ListSnapshots(fakeCtx, &csi.ListSnapshotsRequest{StartingToken: "page-2"})
+	assert.Equal(codes.Aborted, status.Code(err))
+}
+
+// Test CreateSnapshot and DeleteSnapshot of backups
+func TestCreateSnapshotBackup(t *testing.T) {
+	backupID := "261a8b81-3660-43e5-bab8-6470b65ee4e9"
+	creating := &openstack.Backup{ID: backupID, VolumeID: fakeVolID, Status: openstack.BackupCreatingStatus, Size: 1}
+
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetBackupsByNameAndVolumeID", fakeSnapshotName, fakeVolID).Return(nil, nil).Once()
+	osmock.On("CreateBackup", fakeSnapshotName, fakeVolID, &map[string]string{"tag": "tag1"}).Return(creating, nil)
+	osmock.On("DeleteSnapshot", backupID).Return(gophercloud.ErrDefault404{})
+	osmock.On("DeleteBackup", backupID).Return(nil)
+	openstack.OsInstance = osmock
+
+	assert := assert.New(t)
+
+	req := &csi.CreateSnapshotRequest{
+		Name:           fakeSnapshotName,
+		SourceVolumeId: fakeVolID,
+		Parameters:     map[string]string{"type": "backup", "tag": "tag1"},
+	}
+
+	// Not allowed by default
+	_, err := fakeCs.CreateSnapshot(fakeCtx, req)
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = fakeCs.CreateSnapshot(fakeCtx, &csi.CreateSnapshotRequest{Name: fakeSnapshotName, SourceVolumeId: fakeVolID, Parameters: map[string]string{"type": "clone"}})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+
+	fakeCs.Driver.SetSnapshotBackups(true)
+	defer fakeCs.Driver.SetSnapshotBackups(false)
+
+	// Not waited for
+	res, err := fakeCs.CreateSnapshot(fakeCtx, req)
+	assert.NoError(err)
+	assert.Equal(backupID, res.Snapshot.SnapshotId)
+	assert.False(res.Snapshot.ReadyToUse)
+	osmock.AssertNotCalled(t, "WaitSnapshotReady", mock.Anything)
+
+	// Ready to use once available
+	available := *creating
+	available.Status = openstack.BackupAvailableStatus
+	osmock.On("GetBackupsByNameAndVolumeID", fakeSnapshotName, fakeVolID).Return([]openstack.Backup{available}, nil).Once()
+	res, err = fakeCs.CreateSnapshot(fakeCtx, req)
+	assert.NoError(err)
+	assert.True(res.Snapshot.ReadyToUse)
+	osmock.AssertNumberOfCalls(t, "CreateBackup", 1)
+
+	// Failed
+	failed := *creating
+	failed.Status = openstack.BackupErrorStatus
+	osmock.On("GetBackupsByNameAndVolumeID", fakeSnapshotName, fakeVolID).Return([]openstack.Backup{failed}, nil).Once()
+	_, err = fakeCs.CreateSnapshot(fakeCtx, req)
+	assert.Equal(codes.Internal, status.Code(err))
+
+	// Not found among the snapshots
+	_, err = fakeCs.DeleteSnapshot(fakeCtx, &csi.DeleteSnapshotRequest{SnapshotId: backupID})
+	assert.NoError(err)
+	osmock.AssertCalled(t, "DeleteBackup", backupID)
+}
+
+// Test CreateVolume and ListSnapshots of backups
+func TestCreateVolumeFromBackup(t *testing.T) {
+	backupID := "261a8b81-3660-43e5-bab8-6470b65ee4e9"
+	backup := &openstack.Backup{ID: backupID, VolumeID: fakeVolID, Status: openstack.BackupAvailableStatus, Size: 1}
+
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetSnapshotByID", fakeSnapshotID).Return(&fakeSnapshotRes, nil)
+	osmock.On("GetSnapshotByID", backupID).Return(nil, gophercloud.ErrDefault404{})
+	osmock.On("GetSnapshotByID", "missing").Return(nil, gophercloud.ErrDefault404{})
+	osmock.On("GetBackupByID", backupID).Return(backup, nil)
+	osmock.On("GetBackupByID", "missing").Return(nil, gophercloud.ErrDefault404{})
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": fakeCluster}
+	osmock.On("CreateVolumeFromBackup", fakeVolName, mock.AnythingOfType("int"), "", "", backupID, &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: openstack.VolumeAvailableStatus}, nil)
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), "", "", fakeSnapshotID, "", &properties).Return(fakeVolID, fakeAvailability, fakeCapacityGiB, nil)
+	osmock.On("ListSnapshots", 0, 0, map[string]string{}).Return(fakeSnapshotsRes, nil)
+	osmock.On("ListBackups", "").Return([]openstack.Backup{*backup}, nil)
+	openstack.OsInstance = osmock
+
+	assert := assert.New(t)
+
+	fakeCs.Driver.SetSnapshotBackups(true)
+	defer fakeCs.Driver.SetSnapshotBackups(false)
+
+	request := func(snapshotID string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name: fakeVolName,
+			VolumeContentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Snapshot{
+					Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshotID},
+				},
+			},
+		}
+	}
+
+	// Restored from the backup, or the snapshot
+	res, err := fakeCs.CreateVolume(fakeCtx, request(backupID))
+	assert.NoError(err)
+	assert.Equal(backupID, res.Volume.ContentSource.GetSnapshot().GetSnapshotId())
+	_, err = fakeCs.CreateVolume(fakeCtx, request(fakeSnapshotID))
+	assert.NoError(err)
+	osmock.AssertNumberOfCalls(t, "CreateVolumeFromBackup", 1)
+	osmock.AssertNumberOfCalls(t, "CreateVolume", 1)
+
+	_, err = fakeCs.CreateVolume(fakeCtx, request("missing"))
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	// Listed after the snapshots
+	list, err := fakeCs.ListSnapshots(fakeCtx, &csi.ListSnapshotsRequest{})
+	assert.NoError(err)
+	if assert.Len(list.Entries, 2) {
+		assert.Equal(backupID, list.Entries[1].Snapshot.SnapshotId)
+	}
+	list, err = fakeCs.ListSnapshots(fakeCtx, &csi.ListSnapshotsRequest{MaxEntries: 1})
+	assert.NoError(err)
+	assert.Len(list.Entries, 1)
+	assert.Equal("1", list.NextToken)
+	list, err = fakeCs.ListSnapshots(fakeCtx, &csi.ListSnapshotsRequest{SnapshotId: backupID})
+	assert.NoError(err)
+	if assert.Len(list.Entries, 1) {
+		assert.True(list.Entries[0].Snapshot.ReadyToUse)
+	}
+}
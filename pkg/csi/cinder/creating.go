@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/klog"
+)
+
+const (
+	// creatingPollInterval is how often the volume of an ephemeral volume
+	// is checked for having left the creating state
+	creatingPollInterval = 1 * time.Second
+
+	creatingDeadlineReason = "VolumeCreatingDeadlineExceeded"
+)
+
+// creatingBackoff is how long one CreateVolume call waits for its volume to
+// leave the creating state, about 3 minutes at most, the retries wait again
+// until the deadline. Replaced in the tests.
+var creatingBackoff = wait.Backoff{Duration: time.Second, Factor: 1.5, Steps: 12}
+
+// checkCreatingVolumes is called by CreateVolume with the volumes of the
+// requested name. Volumes being deleted are left out. A volume of this
+// cluster in creating for longer than the deadline is deleted, so that a new
+// one gets created, otherwise it is waited for.
+func (cs *controllerServer) checkCreatingVolumes(ctx context.Context, req *csi.CreateVolumeRequest, cloud openstack.IOpenStack, volumes []openstack.Volume) ([]openstack.Volume, error) {
+	var existing []openstack.Volume
+	for _, vol := range volumes {
+		if vol.Status != openstack.VolumeDeletingStatus {
+			existing = append(existing, vol)
+		}
+	}
+	if len(existing) != 1 {
+		return existing, nil
+	}
+
+	vol := existing[0]
+	if vol.Status != openstack.VolumeCreatingStatus || vol.Metadata[clusterMetadataKey] != cs.Driver.cluster {
+		return existing, nil
+	}
+
+	age := time.Since(vol.CreatedAt)
+	if age <= cs.Driver.creatingDeadline {
+		available := cs.Driver.volumeAvailableWait(vol.CreatedAt, vol.VolumeType, vol.AZ)
+		vol, err := cs.waitVolumeCreated(ctx, cloud, vol.ID, cs.Driver.creatingDeadline-age, available)
+		if err != nil {
+			return nil, err
+		}
+		return []openstack.Volume{vol}, nil
+	}
+
+	if err := cs.deleteStuckVolume(req, cloud, vol, age); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// waitVolumeCreated waits with creatingBackoff for a volume to leave the
+// creating state, for at most timeout, the rest of the creating deadline. The
+// volume becoming available, or still creating once timeout expired, is
+// recorded with available.
+func (cs *controllerServer) waitVolumeCreated(ctx context.Context, cloud openstack.IOpenStack, volumeID string, timeout time.Duration, available *statusWait) (openstack.Volume, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	vol, err := waitVolumeSettled(waitCtx, cloud, volumeID, creatingBackoff, available)
+	// The caller giving up first says nothing about the deadline
+	if err != nil && waitCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		available.exceeded()
+	}
+	return vol, err
+}
+
+// recordVolumeCreated waits with creatingBackoff for a volume created
+// without a creating deadline, only to record it becoming available.
+func (cs *controllerServer) recordVolumeCreated(cloud openstack.IOpenStack, volumeID string, available *statusWait) {
+	if _, err := waitVolumeSettled(context.Background(), cloud, volumeID, creatingBackoff, available); err != nil {
+		klog.V(4).Infof("Stopped waiting for volume %s to be available: %v", volumeID, err)
+	}
+}
+
+// deleteStuckVolume deletes a volume in creating for longer than the
+// deadline. Cinder refuses to delete a volume in creating, so its status is
+// reset to error first, which only administrators may do by default.
+func (cs *controllerServer) deleteStuckVolume(req *csi.CreateVolumeRequest, cloud openstack.IOpenStack, vol openstack.Volume, age time.Duration) error {
+	age = age.Round(time.Second)
+	klog.Warningf("Volume %s with name %s is %s for %v, longer than %v, deleting it", vol.ID, vol.Name, openstack.VolumeCreatingStatus, age, cs.Driver.creatingDeadline)
+
+	if err := cloud.ResetVolumeStatus(vol.ID, openstack.VolumeErrorStatus); err != nil {
+		klog.Warningf("Failed to reset the status of volume %s, trying to delete it anyway: %v", vol.ID, err)
+	}
+	if err := cloud.DeleteVolume(vol.ID); err != nil {
+		cs.Driver.events.warn(req, creatingDeadlineReason, "Volume %s is %s for %v and could not be deleted: %v", vol.ID, openstack.VolumeCreatingStatus, age, err)
+		return status.Errorf(codes.Internal, "failed to delete volume %s %s for %v: %v", vol.ID, openstack.VolumeCreatingStatus, age, err)
+	}
+	if cs.Driver.quota != nil {
+		cs.Driver.quota.deleted(vol.ID)
+	}
+
+	klog.Infof("Deleted volume %s with name %s, creating a new one", vol.ID, vol.Name)
+	cs.Driver.events.warn(req, creatingDeadlineReason, "Deleted volume %s %s for %v, creating a new one", vol.ID, openstack.VolumeCreatingStatus, age)
+	return nil
+}
@@ -0,0 +1,418 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"context"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/grpc"
+)
+
+// The CSI v0 services are served on the v0 endpoint for the sidecars and
+// kubelets older than CSI 1.0, by the v1 servers. The messages of both
+// versions are wire compatible but for the ones converted below, which are
+// decoded into and encoded from the v0 messages of this file. The calls go
+// through the interceptors as the v1 calls they are bridged to.
+const (
+	v0IdentityService   = "csi.v0.Identity"
+	v0ControllerService = "csi.v0.Controller"
+	v0NodeService       = "csi.v0.Node"
+)
+
+// v0Method is a v0 method implemented by the v1 method of the same name.
+type v0Method struct {
+	name string
+	// request returns the message the v0 request is decoded into, the v1
+	// request unless toV1 converts it
+	request func() interface{}
+	toV1    func(req interface{}) interface{}
+	call    func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error)
+	// toV0 converts the v1 response when it is not wire compatible or has
+	// values v0 does not know
+	toV0 func(resp interface{}) interface{}
+}
+
+// v0ServiceDesc describes the v0 service v0Name implemented by the v1 server
+// of v1Name, e.g. csi.v1.Controller.
+func v0ServiceDesc(v0Name, v1Name string, handlerType interface{}, methods []v0Method) *grpc.ServiceDesc {
+	desc := &grpc.ServiceDesc{
+		ServiceName: v0Name,
+		HandlerType: handlerType,
+		Streams:     []grpc.StreamDesc{},
+	}
+	for _, m := range methods {
+		m := m
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: m.name,
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				var req interface{} = m.request()
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				if m.toV1 != nil {
+					req = m.toV1(req)
+				}
+				handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return m.call(srv, ctx, req)
+				}
+				var resp interface{}
+				var err error
+				if interceptor == nil {
+					resp, err = handler(ctx, req)
+				} else {
+					resp, err = interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + v1Name + "/" + m.name}, handler)
+				}
+				if err != nil || m.toV0 == nil {
+					return resp, err
+				}
+				return m.toV0(resp), nil
+			},
+		})
+	}
+	return desc
+}
+
+// registerV0Services registers the v0 services of the given v1 servers, the
+// nil ones are not served.
+func registerV0Services(server *grpc.Server, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+	if ids != nil {
+		server.RegisterService(v0IdentityServiceDesc, ids)
+	}
+	if cs != nil {
+		server.RegisterService(v0ControllerServiceDesc, cs)
+	}
+	if ns != nil {
+		server.RegisterService(v0NodeServiceDesc, ns)
+	}
+}
+
+var v0IdentityServiceDesc = v0ServiceDesc(v0IdentityService, "csi.v1.Identity", (*csi.IdentityServer)(nil), []v0Method{
+	{
+		name:    "GetPluginInfo",
+		request: func() interface{} { return &csi.GetPluginInfoRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.IdentityServer).GetPluginInfo(ctx, req.(*csi.GetPluginInfoRequest))
+		},
+	},
+	{
+		name:    "GetPluginCapabilities",
+		request: func() interface{} { return &csi.GetPluginCapabilitiesRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.IdentityServer).GetPluginCapabilities(ctx, req.(*csi.GetPluginCapabilitiesRequest))
+		},
+		toV0: func(resp interface{}) interface{} {
+			// v0 has neither volume expansion nor service types past
+			// VOLUME_ACCESSIBILITY_CONSTRAINTS
+			v0 := &csi.GetPluginCapabilitiesResponse{}
+			for _, c := range resp.(*csi.GetPluginCapabilitiesResponse).GetCapabilities() {
+				if s := c.GetService(); s != nil && s.GetType() <= csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS {
+					v0.Capabilities = append(v0.Capabilities, c)
+				}
+			}
+			return v0
+		},
+	},
+	{
+		name:    "Probe",
+		request: func() interface{} { return &csi.ProbeRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.IdentityServer).Probe(ctx, req.(*csi.ProbeRequest))
+		},
+	},
+})
+
+var v0ControllerServiceDesc = v0ServiceDesc(v0ControllerService, "csi.v1.Controller", (*csi.ControllerServer)(nil), []v0Method{
+	{
+		name:    "CreateVolume",
+		request: func() interface{} { return &csi.CreateVolumeRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.ControllerServer).CreateVolume(ctx, req.(*csi.CreateVolumeRequest))
+		},
+	},
+	{
+		name:    "DeleteVolume",
+		request: func() interface{} { return &csi.DeleteVolumeRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.ControllerServer).DeleteVolume(ctx, req.(*csi.DeleteVolumeRequest))
+		},
+	},
+	{
+		name:    "ControllerPublishVolume",
+		request: func() interface{} { return &csi.ControllerPublishVolumeRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.ControllerServer).ControllerPublishVolume(ctx, req.(*csi.ControllerPublishVolumeRequest))
+		},
+	},
+	{
+		name:    "ControllerUnpublishVolume",
+		request: func() interface{} { return &csi.ControllerUnpublishVolumeRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.ControllerServer).ControllerUnpublishVolume(ctx, req.(*csi.ControllerUnpublishVolumeRequest))
+		},
+	},
+	{
+		name:    "ValidateVolumeCapabilities",
+		request: func() interface{} { return &v0ValidateVolumeCapabilitiesRequest{} },
+		toV1: func(req interface{}) interface{} {
+			r := req.(*v0ValidateVolumeCapabilitiesRequest)
+			return &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId:           r.VolumeId,
+				VolumeCapabilities: r.VolumeCapabilities,
+				VolumeContext:      r.VolumeAttributes,
+			}
+		},
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.ControllerServer).ValidateVolumeCapabilities(ctx, req.(*csi.ValidateVolumeCapabilitiesRequest))
+		},
+		toV0: func(resp interface{}) interface{} {
+			r := resp.(*csi.ValidateVolumeCapabilitiesResponse)
+			return &v0ValidateVolumeCapabilitiesResponse{Supported: r.GetConfirmed() != nil, Message: r.GetMessage()}
+		},
+	},
+	{
+		name:    "ListVolumes",
+		request: func() interface{} { return &csi.ListVolumesRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.ControllerServer).ListVolumes(ctx, req.(*csi.ListVolumesRequest))
+		},
+	},
+	{
+		name:    "GetCapacity",
+		request: func() interface{} { return &csi.GetCapacityRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.ControllerServer).GetCapacity(ctx, req.(*csi.GetCapacityRequest))
+		},
+	},
+	{
+		name:    "ControllerGetCapabilities",
+		request: func() interface{} { return &csi.ControllerGetCapabilitiesRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.ControllerServer).ControllerGetCapabilities(ctx, req.(*csi.ControllerGetCapabilitiesRequest))
+		},
+		toV0: func(resp interface{}) interface{} {
+			// Cloning, expansion and read-only publishing came with v1
+			v0 := &csi.ControllerGetCapabilitiesResponse{}
+			for _, c := range resp.(*csi.ControllerGetCapabilitiesResponse).GetCapabilities() {
+				if rpc := c.GetRpc(); rpc != nil && rpc.GetType() <= csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS {
+					v0.Capabilities = append(v0.Capabilities, c)
+				}
+			}
+			return v0
+		},
+	},
+	{
+		name:    "CreateSnapshot",
+		request: func() interface{} { return &csi.CreateSnapshotRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.ControllerServer).CreateSnapshot(ctx, req.(*csi.CreateSnapshotRequest))
+		},
+		toV0: func(resp interface{}) interface{} {
+			return &v0CreateSnapshotResponse{Snapshot: v0SnapshotOf(resp.(*csi.CreateSnapshotResponse).GetSnapshot())}
+		},
+	},
+	{
+		name:    "DeleteSnapshot",
+		request: func() interface{} { return &csi.DeleteSnapshotRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.ControllerServer).DeleteSnapshot(ctx, req.(*csi.DeleteSnapshotRequest))
+		},
+	},
+	{
+		name:    "ListSnapshots",
+		request: func() interface{} { return &csi.ListSnapshotsRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.ControllerServer).ListSnapshots(ctx, req.(*csi.ListSnapshotsRequest))
+		},
+		toV0: func(resp interface{}) interface{} {
+			r := resp.(*csi.ListSnapshotsResponse)
+			v0 := &v0ListSnapshotsResponse{NextToken: r.GetNextToken()}
+			for _, e := range r.GetEntries() {
+				v0.Entries = append(v0.Entries, &v0ListSnapshotsEntry{Snapshot: v0SnapshotOf(e.GetSnapshot())})
+			}
+			return v0
+		},
+	},
+})
+
+var v0NodeServiceDesc = v0ServiceDesc(v0NodeService, "csi.v1.Node", (*csi.NodeServer)(nil), []v0Method{
+	{
+		name:    "NodeStageVolume",
+		request: func() interface{} { return &csi.NodeStageVolumeRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.NodeServer).NodeStageVolume(ctx, req.(*csi.NodeStageVolumeRequest))
+		},
+	},
+	{
+		name:    "NodeUnstageVolume",
+		request: func() interface{} { return &csi.NodeUnstageVolumeRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.NodeServer).NodeUnstageVolume(ctx, req.(*csi.NodeUnstageVolumeRequest))
+		},
+	},
+	{
+		name:    "NodePublishVolume",
+		request: func() interface{} { return &csi.NodePublishVolumeRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.NodeServer).NodePublishVolume(ctx, req.(*csi.NodePublishVolumeRequest))
+		},
+	},
+	{
+		name:    "NodeUnpublishVolume",
+		request: func() interface{} { return &csi.NodeUnpublishVolumeRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.NodeServer).NodeUnpublishVolume(ctx, req.(*csi.NodeUnpublishVolumeRequest))
+		},
+	},
+	{
+		// NodeGetId was replaced by NodeGetInfo, whose node_id is the
+		// only field of the NodeGetId response
+		name:    "NodeGetId",
+		request: func() interface{} { return &csi.NodeGetInfoRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.NodeServer).NodeGetInfo(ctx, req.(*csi.NodeGetInfoRequest))
+		},
+		toV0: func(resp interface{}) interface{} {
+			return &csi.NodeGetInfoResponse{NodeId: resp.(*csi.NodeGetInfoResponse).GetNodeId()}
+		},
+	},
+	{
+		name:    "NodeGetCapabilities",
+		request: func() interface{} { return &csi.NodeGetCapabilitiesRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.NodeServer).NodeGetCapabilities(ctx, req.(*csi.NodeGetCapabilitiesRequest))
+		},
+		toV0: func(resp interface{}) interface{} {
+			// Volume stats and expansion came with v1
+			v0 := &csi.NodeGetCapabilitiesResponse{}
+			for _, c := range resp.(*csi.NodeGetCapabilitiesResponse).GetCapabilities() {
+				if c.GetRpc().GetType() == csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME {
+					v0.Capabilities = append(v0.Capabilities, c)
+				}
+			}
+			return v0
+		},
+	},
+	{
+		name:    "NodeGetInfo",
+		request: func() interface{} { return &csi.NodeGetInfoRequest{} },
+		call: func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.(csi.NodeServer).NodeGetInfo(ctx, req.(*csi.NodeGetInfoRequest))
+		},
+	},
+})
+
+// v0ValidateVolumeCapabilitiesRequest is the v0 request, whose fields were
+// renumbered in v1.
+type v0ValidateVolumeCapabilitiesRequest struct {
+	VolumeId           string                  `protobuf:"bytes,1,opt,name=volume_id,json=volumeId,proto3"`
+	VolumeCapabilities []*csi.VolumeCapability `protobuf:"bytes,2,rep,name=volume_capabilities,json=volumeCapabilities,proto3"`
+	VolumeAttributes   map[string]string       `protobuf:"bytes,3,rep,name=volume_attributes,json=volumeAttributes,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	AccessibleTopology []*csi.Topology         `protobuf:"bytes,4,rep,name=accessible_topology,json=accessibleTopology,proto3"`
+}
+
+func (m *v0ValidateVolumeCapabilitiesRequest) Reset()         { *m = v0ValidateVolumeCapabilitiesRequest{} }
+func (m *v0ValidateVolumeCapabilitiesRequest) String() string { return proto.CompactTextString(m) }
+func (*v0ValidateVolumeCapabilitiesRequest) ProtoMessage()    {}
+
+// v0ValidateVolumeCapabilitiesResponse is the v0 response, which has a
+// supported flag instead of the confirmed capabilities.
+type v0ValidateVolumeCapabilitiesResponse struct {
+	Supported bool   `protobuf:"varint,1,opt,name=supported,proto3"`
+	Message   string `protobuf:"bytes,2,opt,name=message,proto3"`
+}
+
+func (m *v0ValidateVolumeCapabilitiesResponse) Reset()         { *m = v0ValidateVolumeCapabilitiesResponse{} }
+func (m *v0ValidateVolumeCapabilitiesResponse) String() string { return proto.CompactTextString(m) }
+func (*v0ValidateVolumeCapabilitiesResponse) ProtoMessage()    {}
+
+// The v0 snapshot status types.
+const (
+	v0SnapshotStatusReady     int32 = 1
+	v0SnapshotStatusUploading int32 = 2
+)
+
+// v0Snapshot is the v0 snapshot, created at a time in nanoseconds and with a
+// status instead of the ready flag.
+type v0Snapshot struct {
+	SizeBytes      int64             `protobuf:"varint,1,opt,name=size_bytes,json=sizeBytes,proto3"`
+	Id             string            `protobuf:"bytes,2,opt,name=id,proto3"`
+	SourceVolumeId string            `protobuf:"bytes,3,opt,name=source_volume_id,json=sourceVolumeId,proto3"`
+	CreatedAt      int64             `protobuf:"varint,4,opt,name=created_at,json=createdAt,proto3"`
+	Status         *v0SnapshotStatus `protobuf:"bytes,5,opt,name=status,proto3"`
+}
+
+func (m *v0Snapshot) Reset()         { *m = v0Snapshot{} }
+func (m *v0Snapshot) String() string { return proto.CompactTextString(m) }
+func (*v0Snapshot) ProtoMessage()    {}
+
+type v0SnapshotStatus struct {
+	Type    int32  `protobuf:"varint,1,opt,name=type,proto3"`
+	Details string `protobuf:"bytes,2,opt,name=details,proto3"`
+}
+
+func (m *v0SnapshotStatus) Reset()         { *m = v0SnapshotStatus{} }
+func (m *v0SnapshotStatus) String() string { return proto.CompactTextString(m) }
+func (*v0SnapshotStatus) ProtoMessage()    {}
+
+type v0CreateSnapshotResponse struct {
+	Snapshot *v0Snapshot `protobuf:"bytes,1,opt,name=snapshot,proto3"`
+}
+
+func (m *v0CreateSnapshotResponse) Reset()         { *m = v0CreateSnapshotResponse{} }
+func (m *v0CreateSnapshotResponse) String() string { return proto.CompactTextString(m) }
+func (*v0CreateSnapshotResponse) ProtoMessage()    {}
+
+type v0ListSnapshotsResponse struct {
+	Entries   []*v0ListSnapshotsEntry `protobuf:"bytes,1,rep,name=entries,proto3"`
+	NextToken string                  `protobuf:"bytes,2,opt,name=next_token,json=nextToken,proto3"`
+}
+
+func (m *v0ListSnapshotsResponse) Reset()         { *m = v0ListSnapshotsResponse{} }
+func (m *v0ListSnapshotsResponse) String() string { return proto.CompactTextString(m) }
+func (*v0ListSnapshotsResponse) ProtoMessage()    {}
+
+type v0ListSnapshotsEntry struct {
+	Snapshot *v0Snapshot `protobuf:"bytes,1,opt,name=snapshot,proto3"`
+}
+
+func (m *v0ListSnapshotsEntry) Reset()         { *m = v0ListSnapshotsEntry{} }
+func (m *v0ListSnapshotsEntry) String() string { return proto.CompactTextString(m) }
+func (*v0ListSnapshotsEntry) ProtoMessage()    {}
+
+// v0SnapshotOf converts a v1 snapshot, a snapshot not ready to use is still
+// uploading in v0.
+func v0SnapshotOf(s *csi.Snapshot) *v0Snapshot {
+	if s == nil {
+		return nil
+	}
+	v0 := &v0Snapshot{
+		SizeBytes:      s.GetSizeBytes(),
+		Id:             s.GetSnapshotId(),
+		SourceVolumeId: s.GetSourceVolumeId(),
+		Status:         &v0SnapshotStatus{Type: v0SnapshotStatusUploading},
+	}
+	if created, err := ptypes.Timestamp(s.GetCreationTime()); err == nil {
+		v0.CreatedAt = created.UnixNano()
+	}
+	if s.GetReadyToUse() {
+		v0.Status.Type = v0SnapshotStatusReady
+	}
+	return v0
+}
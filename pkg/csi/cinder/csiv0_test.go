@@ -0,0 +1,147 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// v0StubControllerServer answers the v1 calls of the v0 tests, the other
+// methods are not called.
+type v0StubControllerServer struct {
+	csi.ControllerServer
+	created time.Time
+}
+
+func (s *v0StubControllerServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	return &csi.ControllerGetCapabilitiesResponse{Capabilities: []*csi.ControllerServiceCapability{
+		NewControllerServiceCapability(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME),
+		NewControllerServiceCapability(csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS),
+		NewControllerServiceCapability(csi.ControllerServiceCapability_RPC_EXPAND_VOLUME),
+	}}, nil
+}
+
+func (s *v0StubControllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	if req.GetVolumeId() != "vol" || req.GetVolumeContext()["key"] != "value" || len(req.GetVolumeCapabilities()) != 1 {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: "unexpected request"}, nil
+	}
+	return &csi.ValidateVolumeCapabilitiesResponse{Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{VolumeCapabilities: req.GetVolumeCapabilities()}}, nil
+}
+
+func (s *v0StubControllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	created, _ := ptypes.TimestampProto(s.created)
+	return &csi.CreateSnapshotResponse{Snapshot: &csi.Snapshot{
+		SnapshotId:     "snap",
+		SourceVolumeId: req.GetSourceVolumeId(),
+		SizeBytes:      1 << 30,
+		CreationTime:   created,
+		ReadyToUse:     true,
+	}}, nil
+}
+
+type v0StubNodeServer struct {
+	csi.NodeServer
+}
+
+func (s *v0StubNodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{NodeId: fakeNodeID, MaxVolumesPerNode: 25}, nil
+}
+
+func (s *v0StubNodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{Capabilities: []*csi.NodeServiceCapability{
+		NewNodeServiceCapability(csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME),
+		NewNodeServiceCapability(csi.NodeServiceCapability_RPC_GET_VOLUME_STATS),
+	}}, nil
+}
+
+func TestV0Services(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	var mu sync.Mutex
+	var methods []string
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		mu.Lock()
+		methods = append(methods, info.FullMethod)
+		mu.Unlock()
+		return handler(ctx, req)
+	}))
+	created := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	registerV0Services(server, nil, &v0StubControllerServer{created: created}, &v0StubNodeServer{})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer conn.Close()
+	ctx := context.Background()
+
+	// The capabilities v0 does not know are left out
+	caps := &csi.ControllerGetCapabilitiesResponse{}
+	assert.NoError(t, conn.Invoke(ctx, "/csi.v0.Controller/ControllerGetCapabilities", &csi.ControllerGetCapabilitiesRequest{}, caps))
+	var rpcs []csi.ControllerServiceCapability_RPC_Type
+	for _, c := range caps.GetCapabilities() {
+		rpcs = append(rpcs, c.GetRpc().GetType())
+	}
+	assert.Equal(t, []csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME, csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS}, rpcs)
+
+	nodeCaps := &csi.NodeGetCapabilitiesResponse{}
+	assert.NoError(t, conn.Invoke(ctx, "/csi.v0.Node/NodeGetCapabilities", &csi.NodeGetCapabilitiesRequest{}, nodeCaps))
+	assert.Len(t, nodeCaps.GetCapabilities(), 1)
+
+	validated := &v0ValidateVolumeCapabilitiesResponse{}
+	assert.NoError(t, conn.Invoke(ctx, "/csi.v0.Controller/ValidateVolumeCapabilities", &v0ValidateVolumeCapabilitiesRequest{
+		VolumeId:           "vol",
+		VolumeCapabilities: []*csi.VolumeCapability{{AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER}}},
+		VolumeAttributes:   map[string]string{"key": "value"},
+	}, validated))
+	assert.True(t, validated.Supported, validated.Message)
+
+	snapshot := &v0CreateSnapshotResponse{}
+	assert.NoError(t, conn.Invoke(ctx, "/csi.v0.Controller/CreateSnapshot", &csi.CreateSnapshotRequest{Name: "snap", SourceVolumeId: "vol"}, snapshot))
+	assert.Equal(t, &v0Snapshot{
+		SizeBytes:      1 << 30,
+		Id:             "snap",
+		SourceVolumeId: "vol",
+		CreatedAt:      created.UnixNano(),
+		Status:         &v0SnapshotStatus{Type: v0SnapshotStatusReady},
+	}, snapshot.Snapshot)
+
+	// NodeGetId only has the node ID of NodeGetInfo
+	id := &csi.NodeGetInfoResponse{}
+	assert.NoError(t, conn.Invoke(ctx, "/csi.v0.Node/NodeGetId", &csi.NodeGetInfoRequest{}, id))
+	assert.Equal(t, &csi.NodeGetInfoResponse{NodeId: fakeNodeID}, id)
+
+	// The interceptors see the v1 calls
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"/csi.v1.Controller/ControllerGetCapabilities",
+		"/csi.v1.Node/NodeGetCapabilities",
+		"/csi.v1.Controller/ValidateVolumeCapabilities",
+		"/csi.v1.Controller/CreateSnapshot",
+		"/csi.v1.Node/NodeGetInfo",
+	}, methods)
+}
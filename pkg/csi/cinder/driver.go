@@ -17,18 +17,37 @@ limitations under the License.
 package cinder
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/cloud-provider-openstack/pkg/util/leaderelection"
+	"k8s.io/cloud-provider-openstack/pkg/util/supportbundle"
 	"k8s.io/klog"
 )
 
 const (
 	driverName  = "cinder.csi.openstack.org"
 	topologyKey = "topology." + driverName + "/zone"
+
+	// clusterMetadataKey is the volume metadata key holding the cluster
+	// the volume was provisioned for
+	clusterMetadataKey = driverName + "/cluster"
+
+	// RunModeAll serves both the controller and the node plugin, the driver
+	// has to run on an OpenStack instance.
+	RunModeAll = "all"
+	// RunModeExternal serves the controller plugin only. All the information
+	// comes from the cloud config and the OpenStack API, the local metadata
+	// service is never queried so the driver can run outside of the cloud.
+	RunModeExternal = "external"
 )
 
 var (
@@ -41,12 +60,104 @@ type CinderDriver struct {
 	version     string
 	endpoint    string
 	cloudconfig string
-	cluster     string
+	// osCloud is the cloud of clouds.yaml, see SetCloud
+	osCloud string
+	// v0Endpoint serves the CSI v0 services when set, see SetV0Endpoint
+	v0Endpoint string
+	// configReloadInterval is how often the cloud config is checked for
+	// changes, see SetConfigReloadInterval
+	configReloadInterval time.Duration
+	cluster              string
 
 	ids *identityServer
 	cs  *controllerServer
 	ns  *nodeServer
 
+	placement *placementWebhook
+	runMode   string
+	topology  topologyKeys
+	// topologyRegion adds the region to the topology of the nodes, see
+	// SetTopologyRegion
+	topologyRegion bool
+
+	// attachMode is how volumes get to the nodes, see SetAttachMode
+	attachMode string
+	multipath  bool
+
+	// adoptUntagged allows CreateVolume to reuse volumes without a cluster tag
+	adoptUntagged bool
+	// strictIdempotency makes CreateVolume check the parameters of existing
+	// volumes, see parametersHashMetadataKey
+	strictIdempotency bool
+
+	// creatingDeadline is how long a volume may stay in creating before
+	// CreateVolume deletes it to create a new one, 0 waits forever
+	creatingDeadline time.Duration
+	// staleAttachmentInterval is how often the volumes attached to deleted
+	// instances are detached, 0 never, see SetStaleAttachmentInterval
+	staleAttachmentInterval time.Duration
+	// ephemeralCleanupInterval is how often the ephemeral volumes of deleted
+	// instances are deleted, 0 never, see SetEphemeralCleanupInterval
+	ephemeralCleanupInterval time.Duration
+	// leaderElection elects the replica of the controller plugin running
+	// the background jobs, see SetLeaderElection
+	leaderElection       leaderelection.Config
+	leaderElectionClient kubernetes.Interface
+	// snapshotBackups allows VolumeSnapshots to be Cinder backups, see
+	// SetSnapshotBackups
+	snapshotBackups bool
+	// encryption is nil when the encryption boundary of snapshots is not
+	// enforced, see SetEncryptionBoundary
+	encryption *volumeTypeEncryption
+
+	// metricsVolumeTypes are the volume types the volume metrics are
+	// labelled with, see volumeAvailableWait
+	metricsVolumeTypes map[string]bool
+	// events is nil without a Kubernetes client
+	events *pvcEvents
+	// quota is nil when the capacity per namespace is not capped, see
+	// SetNamespaceQuota
+	quota *namespaceQuota
+
+	// metadataHints are the volume metadata keys StorageClass parameters
+	// may set, see metadataHintPrefix
+	metadataHints map[string]bool
+	// cloudParameters is nil when the type and availability parameters are
+	// not checked against the cloud, see SetValidateParameters
+	cloudParameters *cloudParameters
+
+	// growOnStage makes NodeStageVolume grow filesystems smaller than their
+	// device by more than growOnStageThreshold bytes
+	growOnStage          bool
+	growOnStageThreshold int64
+	// maxVolumesPerNode is reported by NodeGetInfo, see SetMaxVolumesPerNode
+	maxVolumesPerNode int64
+	// mountTimeout and deviceScanTimeout bound the waits for the mount and
+	// device operations of the node plugin, see SetNodeTimeouts
+	mountTimeout      time.Duration
+	deviceScanTimeout time.Duration
+
+	// registration is nil when a node-driver-registrar sidecar registers
+	// the node plugin with kubelet
+	registration              *kubeletRegistration
+	registrationHealthAddress string
+
+	// cloud, zone and extraRegions identify where the driver runs, see
+	// loadCloudInfo
+	cloud        openstack.CloudInfo
+	zone         string
+	extraRegions []string
+
+	// metricsAddress serves the metrics when set, see SetMetricsAddress
+	metricsAddress string
+	// httpEndpoint serves the health check when set, see SetHTTPEndpoint
+	httpEndpoint string
+
+	supportBundleAddress string
+	supportBundleLogs    *supportbundle.LineBuffer
+	// debugTLS is nil when the debug endpoints are only served on loopback
+	debugTLS *tls.Config
+
 	vcap  []*csi.VolumeCapability_AccessMode
 	cscap []*csi.ControllerServiceCapability
 	nscap []*csi.NodeServiceCapability
@@ -62,6 +173,9 @@ func NewDriver(nodeID, endpoint, cluster, cloudconfig string) *CinderDriver {
 	d.endpoint = endpoint
 	d.cloudconfig = cloudconfig
 	d.cluster = cluster
+	d.runMode = RunModeAll
+	d.attachMode = AttachModeNova
+	d.topology = newTopologyKeys()
 
 	d.AddControllerServiceCapabilities(
 		[]csi.ControllerServiceCapability_RPC_Type{
@@ -70,12 +184,19 @@ func NewDriver(nodeID, endpoint, cluster, cloudconfig string) *CinderDriver {
 			csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
 			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
 			csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+			csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+			csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
 		})
-	d.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER})
+	d.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+	})
 
 	d.AddNodeServiceCapabilities(
 		[]csi.NodeServiceCapability_RPC_Type{
 			csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+			csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+			csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
 		})
 
 	return d
@@ -131,7 +252,201 @@ func (d *CinderDriver) GetVolumeCapabilityAccessModes() []*csi.VolumeCapability_
 	return d.vcap
 }
 
+// SetRunMode selects which CSI services the driver serves, see RunModeAll
+// and RunModeExternal.
+func (d *CinderDriver) SetRunMode(mode string) error {
+	switch mode {
+	case RunModeAll:
+	case RunModeExternal:
+		// The node service needs the local instance, only keep the controller
+		d.nscap = nil
+	default:
+		return fmt.Errorf("unknown run mode %q, must be %q or %q", mode, RunModeAll, RunModeExternal)
+	}
+	klog.Infof("Using run mode: %s", mode)
+	d.runMode = mode
+	return nil
+}
+
+// SetAdoptUntaggedVolumes allows CreateVolume to return an existing volume
+// with the requested name but no cluster tag, as left by older releases.
+func (d *CinderDriver) SetAdoptUntaggedVolumes(adopt bool) {
+	if adopt {
+		klog.Warningf("Existing volumes without %s metadata will be adopted by CreateVolume", clusterMetadataKey)
+	}
+	d.adoptUntagged = adopt
+}
+
+// SetCreatingDeadline makes CreateVolume wait for its volume to leave the
+// creating state and, once it stayed there for longer than deadline, delete it
+// and create a new one. 0 disables it.
+func (d *CinderDriver) SetCreatingDeadline(deadline time.Duration) {
+	if deadline > 0 {
+		klog.Infof("Deleting volumes in %s for longer than %v", openstack.VolumeCreatingStatus, deadline)
+	}
+	d.creatingDeadline = deadline
+}
+
+// SetStaleAttachmentInterval makes the controller check every interval for
+// the volumes of the cluster attached to instances Nova no longer has, and
+// force-detach them. 0 disables it. Only the controller plugin of the
+// external run mode checks, see runControllerJobs.
+func (d *CinderDriver) SetStaleAttachmentInterval(interval time.Duration) {
+	d.staleAttachmentInterval = interval
+}
+
+// SetEphemeralCleanupInterval makes the controller check every interval for
+// the ephemeral volumes of the cluster created on instances Nova no longer
+// has, and delete them. 0 disables it. Only the controller plugin of the
+// external run mode checks, see runControllerJobs.
+func (d *CinderDriver) SetEphemeralCleanupInterval(interval time.Duration) {
+	d.ephemeralCleanupInterval = interval
+}
+
+// SetLeaderElection has the replicas of the controller plugin elect a leader
+// with a Lease, only the leader runs the jobs calling Cinder by themselves.
+func (d *CinderDriver) SetLeaderElection(c leaderelection.Config, client kubernetes.Interface) {
+	d.leaderElection = c
+	d.leaderElectionClient = client
+}
+
+// runControllerJobs runs the background jobs of the controller plugin while
+// this replica is the leader, right away without leader election.
+func (d *CinderDriver) runControllerJobs() {
+	if d.staleAttachmentInterval <= 0 && d.ephemeralCleanupInterval <= 0 {
+		return
+	}
+	err := leaderelection.Run(context.Background(), d.leaderElectionClient, driverName+"/controller", d.leaderElection, func(ctx context.Context) {
+		if d.staleAttachmentInterval > 0 {
+			go newStaleAttachments(d.cluster).run(d.staleAttachmentInterval, ctx.Done())
+		}
+		if d.ephemeralCleanupInterval > 0 {
+			go newEphemeralCleanup(d.cluster).run(d.ephemeralCleanupInterval, ctx.Done())
+		}
+		<-ctx.Done()
+	})
+	if err != nil {
+		klog.Fatalf("Failed to elect the leader of the controller plugin: %v", err)
+	}
+}
+
+// SetNodeTimeouts bounds how long the node plugin calls wait for the
+// formatting, mounts and unmounts of a volume and for its device to show up.
+// A call waiting longer fails with DeadlineExceeded, as does one whose
+// context is done first, and its retry waits for the operation still in
+// progress. 0 waits for as long as the context of the call allows.
+func (d *CinderDriver) SetNodeTimeouts(mount, deviceScan time.Duration) {
+	d.mountTimeout = mount
+	d.deviceScanTimeout = deviceScan
+}
+
+// SetOpenStackRequestTimeout fails the OpenStack API requests without a
+// response after timeout, 0 waits as long as the connection lasts.
+func (d *CinderDriver) SetOpenStackRequestTimeout(timeout time.Duration) {
+	openstack.SetRequestTimeout(timeout)
+}
+
+// SetGrowOnStage makes NodeStageVolume grow the filesystem of a volume when
+// its device is larger by more than threshold bytes, e.g. because the volume
+// was extended but NodeExpandVolume never ran.
+func (d *CinderDriver) SetGrowOnStage(threshold int64) {
+	klog.Infof("Growing filesystems smaller than their device by more than %d bytes on NodeStageVolume", threshold)
+	d.growOnStage = true
+	d.growOnStageThreshold = threshold
+}
+
+// SetKubeClient enables the events on the PVCs volumes are provisioned for.
+func (d *CinderDriver) SetKubeClient(client kubernetes.Interface) {
+	d.events = newPVCEvents(client)
+}
+
+// SetCloud reads the credentials missing in the cloud config from the cloud
+// name of clouds.yaml and secure.yaml, the files of the openstack CLI.
+func (d *CinderDriver) SetCloud(name string) {
+	d.osCloud = name
+}
+
+// SetMetadataSearchOrder makes the node plugin find the instance it runs on
+// from the sources of order, a comma separated list of configDrive,
+// metadataService and nova, instead of the search-order of the cloud config.
+// nova looks the instance named after the node up in Nova, for clouds without
+// a metadata service nor config drive.
+func (d *CinderDriver) SetMetadataSearchOrder(order string) error {
+	return openstack.SetMetadataSearchOrder(order)
+}
+
+// SetConfigReloadInterval reloads the cloud config when it changes, checking
+// every interval, so that rotated credentials are used without a restart. 0
+// disables it.
+func (d *CinderDriver) SetConfigReloadInterval(interval time.Duration) {
+	d.configReloadInterval = interval
+}
+
+// SetV0Endpoint serves the CSI v0 services on endpoint next to the v1 ones,
+// for the sidecars and kubelets still pinned to CSI 0.3 during their upgrade.
+func (d *CinderDriver) SetV0Endpoint(endpoint string) {
+	d.v0Endpoint = endpoint
+}
+
+// serve serves the CSI services on the endpoint, and on the v0 endpoint when
+// set, until the server stops.
+func (d *CinderDriver) serve(ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+	if d.v0Endpoint != "" {
+		klog.Warningf("Serving the deprecated CSI v0 services on %s, upgrade the sidecars to CSI 1.0 and stop setting it", d.v0Endpoint)
+		NewV0NonBlockingGRPCServer().Start(d.v0Endpoint, ids, cs, ns)
+	}
+	RunControllerandNodePublishServer(d.endpoint, ids, cs, ns)
+}
+
 func (d *CinderDriver) Run() {
+	openstack.InitCloud(d.osCloud)
+	openstack.SetNovaServerName(d.nodeID)
 	openstack.InitOpenStackProvider(d.cloudconfig)
-	RunControllerandNodePublishServer(d.endpoint, NewIdentityServer(d), NewControllerServer(d), NewNodeServer(d))
+	if d.configReloadInterval > 0 {
+		openstack.WatchConfig(d.configReloadInterval, wait.NeverStop)
+	}
+	d.loadCloudInfo()
+	RegisterMetrics(d.metricLabels())
+	if d.metricsAddress != "" {
+		serveMetrics(d.metricsAddress)
+	}
+	if d.httpEndpoint != "" {
+		d.serveHealth(d.httpEndpoint)
+	}
+
+	if d.supportBundleAddress != "" {
+		if err := d.serveDebug(); err != nil {
+			klog.Fatalf("Failed to serve the debug endpoints: %v", err)
+		}
+	}
+
+	if d.quota != nil {
+		cloud, err := openstack.GetOpenStackProvider()
+		if err == nil {
+			err = d.quota.rebuild(cloud, d.cluster)
+		}
+		if err != nil {
+			klog.Warningf("Failed to rebuild the namespace quota usage, retrying on the next CreateVolume: %v", err)
+		}
+	}
+
+	if d.runMode == RunModeExternal {
+		openstack.DisableMetadataProvider()
+		go d.runControllerJobs()
+		d.serve(NewIdentityServer(d), NewControllerServer(d), nil)
+		return
+	}
+	if d.staleAttachmentInterval > 0 {
+		klog.Warningf("Not checking for stale attachments, the node plugin never does: only set --stale-attachment-interval with --run-mode=%s", RunModeExternal)
+	}
+	if d.ephemeralCleanupInterval > 0 {
+		klog.Warningf("Not cleaning up ephemeral volumes, the node plugin never does: only set --ephemeral-cleanup-interval with --run-mode=%s", RunModeExternal)
+	}
+	if d.registration != nil {
+		if d.registrationHealthAddress != "" {
+			serveRegistrationHealth(d.registrationHealthAddress, d.registration)
+		}
+		go d.registration.run(wait.NeverStop)
+	}
+	d.serve(NewIdentityServer(d), NewControllerServer(d), NewNodeServer(d))
 }
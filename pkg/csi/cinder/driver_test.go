@@ -64,3 +64,33 @@ func TestValidateControllerServiceRequest(t *testing.T) {
 	err = d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS)
 	assert.NoError(t, err)
 }
+
+func TestSetRunMode(t *testing.T) {
+	d := NewFakeDriver()
+	assert.Equal(t, RunModeAll, d.runMode)
+	assert.NotEmpty(t, d.nscap)
+
+	// External mode drops the node service
+	assert.NoError(t, d.SetRunMode(RunModeExternal))
+	assert.Equal(t, RunModeExternal, d.runMode)
+	assert.Empty(t, d.nscap)
+
+	assert.Error(t, d.SetRunMode("remote"))
+	assert.Equal(t, RunModeExternal, d.runMode)
+}
+
+func TestSetAttachMode(t *testing.T) {
+	d := NewFakeDriver()
+	assert.Equal(t, AttachModeNova, d.attachMode)
+
+	// The nodes connect the volumes themselves, there is nothing to publish
+	assert.NoError(t, d.SetAttachMode(AttachModeConnector, true))
+	assert.Equal(t, AttachModeConnector, d.attachMode)
+	assert.True(t, d.multipath)
+	err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME)
+	assert.Error(t, err)
+	assert.NoError(t, d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME))
+
+	assert.Error(t, d.SetAttachMode("ironic", false))
+	assert.Equal(t, AttachModeConnector, d.attachMode)
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog"
+)
+
+const (
+	// encryptedMetadataKey marks the snapshots of volumes of an encrypted
+	// type
+	encryptedMetadataKey = driverName + "/encrypted"
+
+	// allowUnencryptedRestoreParameter lets CreateVolume restore a snapshot
+	// of an encrypted volume, or clone an encrypted volume, into an
+	// unencrypted type
+	allowUnencryptedRestoreParameter = "allowUnencryptedRestore"
+
+	// volumeTypeEncryptionTTL is how long the encryption of a volume type is
+	// cached
+	volumeTypeEncryptionTTL = 10 * time.Minute
+)
+
+// volumeTypeEncryption caches whether volume types are encrypted, which takes
+// extra API calls to find out.
+type volumeTypeEncryption struct {
+	mu     sync.Mutex
+	cached map[string]cachedEncryption
+	now    func() time.Time
+}
+
+type cachedEncryption struct {
+	encrypted bool
+	expires   time.Time
+}
+
+func newVolumeTypeEncryption() *volumeTypeEncryption {
+	return &volumeTypeEncryption{cached: map[string]cachedEncryption{}, now: time.Now}
+}
+
+// encrypted returns whether volumes of volType are encrypted.
+func (e *volumeTypeEncryption) encrypted(cloud openstack.IOpenStack, volType string) (bool, error) {
+	e.mu.Lock()
+	c, ok := e.cached[volType]
+	e.mu.Unlock()
+	if ok && e.now().Before(c.expires) {
+		return c.encrypted, nil
+	}
+
+	encrypted, err := cloud.VolumeTypeEncrypted(volType)
+	if err != nil {
+		return false, err
+	}
+	e.mu.Lock()
+	e.cached[volType] = cachedEncryption{encrypted: encrypted, expires: e.now().Add(volumeTypeEncryptionTTL)}
+	e.mu.Unlock()
+	return encrypted, nil
+}
+
+// SetEncryptionBoundary marks the snapshots of encrypted volumes, and makes
+// CreateVolume refuse to restore them, or to clone encrypted volumes, into an
+// unencrypted volume type unless the allowUnencryptedRestore parameter is
+// set.
+func (d *CinderDriver) SetEncryptionBoundary(enforce bool) {
+	if enforce {
+		klog.Infof("Enforcing the encryption boundary of snapshots")
+		d.encryption = newVolumeTypeEncryption()
+	} else {
+		d.encryption = nil
+	}
+}
+
+// markEncryptedSnapshot adds the encrypted marker to the metadata of a new
+// snapshot of volumeID when the type of the volume is encrypted. The marker
+// only ever reflects the source volume, one in metadata is replaced.
+func (cs *controllerServer) markEncryptedSnapshot(cloud openstack.IOpenStack, volumeID string, metadata map[string]string) (map[string]string, error) {
+	vol, err := cloud.GetVolume(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get the source volume %s of the snapshot: %v", volumeID, err)
+	}
+	encrypted := false
+	if vol.VolumeType != "" {
+		encrypted, err = cs.Driver.encryption.encrypted(cloud, vol.VolumeType)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get the encryption of volume type %s: %v", vol.VolumeType, err)
+		}
+	}
+	if _, set := metadata[encryptedMetadataKey]; !encrypted && !set {
+		return metadata, nil
+	}
+
+	marked := map[string]string{}
+	for k, v := range metadata {
+		if k != encryptedMetadataKey {
+			marked[k] = v
+		}
+	}
+	if encrypted {
+		marked[encryptedMetadataKey] = "true"
+	}
+	return marked, nil
+}
+
+// checkEncryptionBoundary refuses to restore a snapshot of an encrypted
+// volume into an unencrypted volume type. Without a type the volume gets the
+// type of the source volume, which keeps it encrypted.
+func (cs *controllerServer) checkEncryptionBoundary(cloud openstack.IOpenStack, volName, volType, snapshotID string, params map[string]string) error {
+	if volType == "" {
+		return nil
+	}
+	snap, err := cloud.GetSnapshotByID(snapshotID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return status.Errorf(codes.NotFound, "source snapshot %s not found", snapshotID)
+		}
+		return status.Errorf(codes.Internal, "failed to get snapshot %s: %v", snapshotID, err)
+	}
+	return cs.checkEncryptedRestore(cloud, volName, volType, snapshotID, snap.Metadata, params)
+}
+
+// checkEncryptedClone refuses to clone the volume sourceVolID of an encrypted
+// type into an unencrypted volume type. Without a type the clone gets the
+// type of the source volume, which keeps it encrypted.
+func (cs *controllerServer) checkEncryptedClone(cloud openstack.IOpenStack, volName, volType, sourceVolID string, params map[string]string) error {
+	if volType == "" {
+		return nil
+	}
+	source, err := cloud.GetVolume(sourceVolID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return status.Errorf(codes.NotFound, "source volume %s not found", sourceVolID)
+		}
+		return status.Errorf(codes.Internal, "failed to get volume %s: %v", sourceVolID, err)
+	}
+	if source.VolumeType == "" || source.VolumeType == volType {
+		return nil
+	}
+	sourceEncrypted, err := cs.Driver.encryption.encrypted(cloud, source.VolumeType)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get the encryption of volume type %s: %v", source.VolumeType, err)
+	}
+	if !sourceEncrypted {
+		return nil
+	}
+	encrypted, err := cs.Driver.encryption.encrypted(cloud, volType)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get the encryption of volume type %s: %v", volType, err)
+	}
+	if encrypted {
+		return nil
+	}
+
+	if params[allowUnencryptedRestoreParameter] == "true" {
+		klog.Warningf("ENCRYPTION BOUNDARY OVERRIDE: cloning volume %s of the encrypted type %s into volume %s of the unencrypted type %s, as allowed by the %s parameter", sourceVolID, source.VolumeType, volName, volType, allowUnencryptedRestoreParameter)
+		return nil
+	}
+	return status.Errorf(codes.FailedPrecondition, "volume %s is of the encrypted type %s and cannot be cloned into the unencrypted volume type %s, set the %s parameter to override", sourceVolID, source.VolumeType, volType, allowUnencryptedRestoreParameter)
+}
+
+// checkEncryptedRestore refuses to restore the snapshot or backup snapshotID
+// with the given metadata into an unencrypted volume type.
+func (cs *controllerServer) checkEncryptedRestore(cloud openstack.IOpenStack, volName, volType, snapshotID string, metadata, params map[string]string) error {
+	if volType == "" || metadata[encryptedMetadataKey] != "true" {
+		return nil
+	}
+	encrypted, err := cs.Driver.encryption.encrypted(cloud, volType)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get the encryption of volume type %s: %v", volType, err)
+	}
+	if encrypted {
+		return nil
+	}
+
+	if params[allowUnencryptedRestoreParameter] == "true" {
+		klog.Warningf("ENCRYPTION BOUNDARY OVERRIDE: restoring snapshot %s of an encrypted volume into volume %s of the unencrypted type %s, as allowed by the %s parameter", snapshotID, volName, volType, allowUnencryptedRestoreParameter)
+		return nil
+	}
+	return status.Errorf(codes.FailedPrecondition, "snapshot %s is of an encrypted volume and cannot be restored into the unencrypted volume type %s, set the %s parameter to override", snapshotID, volType, allowUnencryptedRestoreParameter)
+}
@@ -0,0 +1,338 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/mount"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/cloud-provider-openstack/pkg/volume/util"
+	"k8s.io/klog"
+)
+
+const (
+	// ephemeralContext is set by kubelet in the volume context of the CSI
+	// inline volumes of pods, which live as long as the pod
+	ephemeralContext = "csi.storage.k8s.io/ephemeral"
+	// ephemeralMetadataKey is the volume metadata key holding the kubelet
+	// volume ID of an ephemeral volume
+	ephemeralMetadataKey = driverName + "/ephemeral"
+	// ephemeralNodeMetadataKey is the volume metadata key holding the
+	// instance of the node an ephemeral volume was created on, for the
+	// controller to delete it once the instance is gone
+	ephemeralNodeMetadataKey = driverName + "/ephemeral-node"
+	// ephemeralNamePrefix prefixes the kubelet volume ID in the names of the
+	// Cinder volumes of ephemeral volumes
+	ephemeralNamePrefix = "ephemeral-"
+	// ephemeralFile holds the Cinder volume of an ephemeral volume, next to
+	// its target path, to delete it on NodeUnpublishVolume
+	ephemeralFile = "cinder-ephemeral.json"
+
+	// ephemeralCreateTimeout is how long NodePublishVolume waits for the
+	// Cinder volume of an ephemeral volume to become available
+	ephemeralCreateTimeout = 2 * time.Minute
+)
+
+// ephemeralVolume is the Cinder volume of an ephemeral volume.
+type ephemeralVolume struct {
+	VolumeID string `json:"volumeID"`
+}
+
+// isEphemeral returns whether the volume context is the one of a CSI inline
+// volume.
+func isEphemeral(volumeContext map[string]string) bool {
+	return volumeContext[ephemeralContext] == "true"
+}
+
+// ephemeralSizeGB returns the size of the Cinder volume of an ephemeral volume
+// of the given capacity attribute, 1 GiB by default.
+func ephemeralSizeGB(capacity string) (int, error) {
+	if capacity == "" {
+		return 1, nil
+	}
+	q, err := resource.ParseQuantity(capacity)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid capacity %q: %v", capacity, err)
+	}
+	if q.Sign() <= 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid capacity %q, must be positive", capacity)
+	}
+	return int(util.RoundUpSize(q.Value(), 1024*1024*1024)), nil
+}
+
+func ephemeralPath(targetPath string) string {
+	return filepath.Join(filepath.Dir(targetPath), ephemeralFile)
+}
+
+// readEphemeral returns the Cinder volume saved for the ephemeral volume
+// published at targetPath, nil when there is none.
+func readEphemeral(targetPath string) (*ephemeralVolume, error) {
+	data, err := ioutil.ReadFile(ephemeralPath(targetPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var v ephemeralVolume
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("invalid ephemeral volume file %s: %v", ephemeralPath(targetPath), err)
+	}
+	return &v, nil
+}
+
+// nodePublishEphemeralVolume creates the Cinder volume of an ephemeral volume,
+// attaches it to the node and mounts it on the target path. The volume
+// attributes of the pod select the capacity, type and availability of the
+// volume.
+func (ns *nodeServer) nodePublishEphemeralVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, m mount.IMount) (*csi.NodePublishVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	targetPath := req.GetTargetPath()
+	volumeCapability := req.GetVolumeCapability()
+	if volumeCapability.GetBlock() != nil {
+		return nil, status.Error(codes.InvalidArgument, "Ephemeral volumes are only served as filesystems")
+	}
+	fsType, mkfsOptions, options, err := volumeFormat(volumeCapability, req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+
+	cloud, err := openstack.GetOpenStackProvider()
+	if err != nil {
+		klog.V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	vol, err := readEphemeral(targetPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to read the ephemeral volume %s: %v", volumeID, err)
+	}
+	if vol == nil {
+		vol, err = ns.createEphemeralVolume(cloud, volumeID, req.GetVolumeContext())
+		if err != nil {
+			return nil, err
+		}
+		// Saved before anything else, for NodeUnpublishVolume to delete the
+		// volume whatever fails next
+		data, err := json.Marshal(vol)
+		if err == nil {
+			err = ioutil.WriteFile(ephemeralPath(targetPath), data, 0600)
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to save the ephemeral volume %s: %v", volumeID, err)
+		}
+	}
+
+	if err := ns.waitEphemeralVolume(ctx, cloud, vol.VolumeID); err != nil {
+		return nil, err
+	}
+	devicePath, err := ns.attachEphemeralVolume(cloud, vol.VolumeID, targetPath, m)
+	if err != nil {
+		return nil, err
+	}
+
+	notMnt, err := m.IsLikelyNotMountPointAttach(targetPath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if notMnt {
+		if req.GetReadonly() {
+			options = append(options, "ro")
+		}
+		if err := m.FormatAndMount(devicePath, targetPath, fsType, mkfsOptions, options); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	klog.V(4).Infof("Published ephemeral volume %s as Cinder volume %s", volumeID, vol.VolumeID)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// createEphemeralVolume creates the Cinder volume of the ephemeral volume
+// volumeID, unless an earlier NodePublishVolume already did.
+func (ns *nodeServer) createEphemeralVolume(cloud openstack.IOpenStack, volumeID string, attributes map[string]string) (*ephemeralVolume, error) {
+	name := ephemeralNamePrefix + volumeID
+	volumes, err := cloud.GetVolumesByName(name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to look for the Cinder volume of ephemeral volume %s: %v", volumeID, err)
+	}
+	if len(volumes) == 1 {
+		klog.V(4).Infof("Ephemeral volume %s already has Cinder volume %s", volumeID, volumes[0].ID)
+		return &ephemeralVolume{VolumeID: volumes[0].ID}, nil
+	}
+	if len(volumes) > 1 {
+		return nil, status.Errorf(codes.Internal, "Multiple Cinder volumes named %s", name)
+	}
+
+	sizeGB, err := ephemeralSizeGB(attributes["capacity"])
+	if err != nil {
+		return nil, err
+	}
+	availability := attributes["availability"]
+	if availability == "" {
+		// The volume must be attachable to the node
+		availability, err = getAvailabilityZoneMetadataService()
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to get the availability zone of the node: %v", err)
+		}
+	}
+
+	properties := map[string]string{
+		clusterMetadataKey:   ns.Driver.cluster,
+		ephemeralMetadataKey: volumeID,
+	}
+	if instanceID, err := getNodeID(); err == nil {
+		properties[ephemeralNodeMetadataKey] = instanceID
+	} else {
+		klog.V(3).Infof("Failed to get the instance of the node for ephemeral volume %s, it will not be cleaned up: %v", volumeID, err)
+	}
+	id, _, _, err := cloud.CreateVolume(name, sizeGB, attributes["type"], availability, "", "", &properties)
+	if err != nil {
+		klog.V(3).Infof("Failed to CreateVolume for ephemeral volume %s: %v", volumeID, err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	klog.V(4).Infof("Created Cinder volume %s of %d GiB for ephemeral volume %s", id, sizeGB, volumeID)
+	return &ephemeralVolume{VolumeID: id}, nil
+}
+
+// waitEphemeralVolume waits for a Cinder volume created for an ephemeral volume
+// to leave the creating state.
+func (ns *nodeServer) waitEphemeralVolume(ctx context.Context, cloud openstack.IOpenStack, cinderID string) error {
+	waitCtx, cancel := context.WithTimeout(ctx, ephemeralCreateTimeout)
+	defer cancel()
+
+	var vol openstack.Volume
+	err := wait.PollImmediateUntil(creatingPollInterval, func() (bool, error) {
+		var err error
+		vol, err = cloud.GetVolume(cinderID)
+		if err != nil {
+			return false, err
+		}
+		return vol.Status != openstack.VolumeCreatingStatus, nil
+	}, waitCtx.Done())
+	if err == wait.ErrWaitTimeout {
+		return status.Errorf(codes.Unavailable, "volume %s is still %s", cinderID, openstack.VolumeCreatingStatus)
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if vol.Status == openstack.VolumeErrorStatus {
+		return status.Errorf(codes.Internal, "volume %s failed to be created", cinderID)
+	}
+	return nil
+}
+
+// attachEphemeralVolume attaches the Cinder volume of an ephemeral volume to
+// the node, as the controller would for a persistent one, and returns its
+// device.
+func (ns *nodeServer) attachEphemeralVolume(cloud openstack.IOpenStack, cinderID, targetPath string, m mount.IMount) (string, error) {
+	if ns.Driver.attachMode == AttachModeConnector {
+		return ns.connectVolume(cinderID, targetPath)
+	}
+
+	instanceID, err := getNodeID()
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	if _, err := cloud.AttachVolume(instanceID, cinderID); err != nil {
+		klog.V(3).Infof("Failed to AttachVolume: %v", err)
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	if err := cloud.WaitDiskAttached(instanceID, cinderID); err != nil {
+		klog.V(3).Infof("Failed to WaitDiskAttached: %v", err)
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	devicePath, err := cloud.GetAttachmentDiskPath(instanceID, cinderID)
+	if err != nil {
+		klog.V(3).Infof("Failed to GetAttachmentDiskPath: %v", err)
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	devicePath = mount.VolumeDevicePath(cinderID, devicePath)
+	if err := m.ScanForAttach(devicePath); err != nil {
+		klog.V(3).Infof("Failed to ScanForAttach: %v", err)
+		return "", status.Errorf(codes.Internal, "Failed to ScanForAttach: %v", err)
+	}
+	return devicePath, nil
+}
+
+// nodeUnpublishEphemeralVolume unmounts an ephemeral volume, detaches its
+// Cinder volume from the node and deletes it.
+func (ns *nodeServer) nodeUnpublishEphemeralVolume(req *csi.NodeUnpublishVolumeRequest, vol *ephemeralVolume, m mount.IMount) (*csi.NodeUnpublishVolumeResponse, error) {
+	targetPath := req.GetTargetPath()
+
+	// An earlier call may have unmounted the volume and removed the target
+	// path without deleting the volume
+	if _, err := os.Stat(targetPath); err == nil {
+		notMnt, err := m.IsLikelyNotMountPointDetach(targetPath)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if !notMnt {
+			if err := m.UnmountPath(targetPath); err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
+	}
+
+	cloud, err := openstack.GetOpenStackProvider()
+	if err != nil {
+		klog.V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if ns.Driver.attachMode == AttachModeConnector {
+		if err := ns.disconnectVolume(vol.VolumeID, targetPath); err != nil {
+			return nil, err
+		}
+	} else {
+		instanceID, err := getNodeID()
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if err := cloud.DetachVolume(instanceID, vol.VolumeID); err != nil && !cpoerrors.IsNotFound(err) {
+			klog.V(3).Infof("Failed to DetachVolume: %v", err)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if err := cloud.WaitDiskDetached(instanceID, vol.VolumeID); err != nil && !cpoerrors.IsNotFound(err) {
+			klog.V(3).Infof("Failed to WaitDiskDetached: %v", err)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	if err := cloud.DeleteVolume(vol.VolumeID); err != nil && !cpoerrors.IsNotFound(err) {
+		klog.V(3).Infof("Failed to DeleteVolume: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := os.Remove(ephemeralPath(targetPath)); err != nil && !os.IsNotExist(err) {
+		return nil, status.Errorf(codes.Internal, "Failed to remove the ephemeral volume %s: %v", req.GetVolumeId(), err)
+	}
+
+	klog.V(4).Infof("Deleted Cinder volume %s of ephemeral volume %s", vol.VolumeID, req.GetVolumeId())
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
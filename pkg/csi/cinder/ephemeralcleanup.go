@@ -0,0 +1,152 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog"
+)
+
+const (
+	ephemeralCleanupResultDeleted  = "deleted"
+	ephemeralCleanupResultDetached = "detached"
+	ephemeralCleanupResultFailed   = "failed"
+)
+
+// ephemeralCleanup deletes the Cinder volumes of the ephemeral volumes of the
+// cluster whose node instance Nova no longer has. NodeUnpublishVolume never
+// runs for the pods of a deleted node, their volumes would be left behind.
+type ephemeralCleanup struct {
+	cluster string
+}
+
+func newEphemeralCleanup(cluster string) *ephemeralCleanup {
+	return &ephemeralCleanup{cluster: cluster}
+}
+
+// run checks the ephemeral volumes every interval until stop is closed.
+func (e *ephemeralCleanup) run(interval time.Duration, stop <-chan struct{}) {
+	klog.Infof("Deleting the ephemeral volumes of deleted instances every %v", interval)
+	wait.Until(func() {
+		cloud, err := openstack.GetOpenStackProvider()
+		if err == nil {
+			err = e.reconcile(cloud)
+		}
+		if err != nil {
+			klog.Warningf("Failed to check the ephemeral volumes: %v", err)
+		}
+	}, interval, stop)
+}
+
+// ephemeralInstances returns the instances of the node of an ephemeral
+// volume: those it is attached to, or the one it was created on when it is
+// not attached. There is none for a volume created by an older release or
+// attached with the connector attach mode.
+func ephemeralInstances(vol openstack.Volume) []string {
+	var instances []string
+	for _, a := range vol.Attachments {
+		if a.ServerID != "" {
+			instances = append(instances, a.ServerID)
+		}
+	}
+	if len(instances) == 0 && len(vol.Attachments) == 0 && vol.Metadata[ephemeralNodeMetadataKey] != "" {
+		instances = append(instances, vol.Metadata[ephemeralNodeMetadataKey])
+	}
+	return instances
+}
+
+// reconcile deletes the available ephemeral volumes of the cluster whose
+// instances Nova answers are not found, and force-detaches the attached ones
+// for them to be deleted on the next pass. A volume with an instance that
+// exists or cannot be looked up is kept.
+func (e *ephemeralCleanup) reconcile(cloud openstack.IOpenStack) error {
+	vols, err := cloud.ListVolumes()
+	if err != nil {
+		return fmt.Errorf("failed to list volumes: %v", err)
+	}
+
+	// Looked up once per pass, a node has many volumes
+	instances := map[string]bool{}
+	for _, vol := range vols {
+		if owner, tagged := vol.Metadata[clusterMetadataKey]; !tagged || owner != e.cluster {
+			continue
+		}
+		if vol.Metadata[ephemeralMetadataKey] == "" {
+			continue
+		}
+		if vol.Status != openstack.VolumeAvailableStatus && vol.Status != openstack.VolumeInUseStatus {
+			continue
+		}
+
+		ids := ephemeralInstances(vol)
+		if len(ids) == 0 {
+			continue
+		}
+		gone := true
+		for _, id := range ids {
+			exists, checked := instances[id]
+			if !checked {
+				exists, err = cloud.InstanceExists(id)
+				if err != nil {
+					klog.Warningf("Failed to look up instance %s of ephemeral volume %s: %v", id, vol.ID, err)
+					// Kept until the instance can be looked up
+					exists = true
+				} else {
+					instances[id] = exists
+				}
+			}
+			if exists {
+				gone = false
+			}
+		}
+		if !gone {
+			continue
+		}
+
+		if vol.Status == openstack.VolumeInUseStatus {
+			for _, a := range vol.Attachments {
+				if a.ID == "" {
+					klog.Warningf("Ephemeral volume %s is attached to deleted instance %s without an attachment ID, not detaching it", vol.ID, a.ServerID)
+					continue
+				}
+				klog.Warningf("Ephemeral volume %s is attached to deleted instance %s, force-detaching it", vol.ID, a.ServerID)
+				if err := cloud.ForceDetachVolume(vol.ID, a.ID); err != nil {
+					klog.Errorf("Failed to force-detach ephemeral volume %s from deleted instance %s: %v", vol.ID, a.ServerID, err)
+					ephemeralVolumesCleaned.WithLabelValues(ephemeralCleanupResultFailed).Inc()
+					continue
+				}
+				ephemeralVolumesCleaned.WithLabelValues(ephemeralCleanupResultDetached).Inc()
+			}
+			continue
+		}
+
+		klog.Warningf("Ephemeral volume %s %s of deleted instance %s is left behind, deleting it", vol.ID, vol.Metadata[ephemeralMetadataKey], ids[0])
+		if err := cloud.DeleteVolume(vol.ID); err != nil && !cpoerrors.IsNotFound(err) {
+			klog.Errorf("Failed to delete ephemeral volume %s: %v", vol.ID, err)
+			ephemeralVolumesCleaned.WithLabelValues(ephemeralCleanupResultFailed).Inc()
+			continue
+		}
+		ephemeralVolumesCleaned.WithLabelValues(ephemeralCleanupResultDeleted).Inc()
+		klog.Infof("Deleted ephemeral volume %s of deleted instance %s", vol.ID, ids[0])
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+)
+
+func TestEphemeralCleanup(t *testing.T) {
+	ephemeral := func(node string) map[string]string {
+		return map[string]string{clusterMetadataKey: fakeCluster, ephemeralMetadataKey: "csi-1", ephemeralNodeMetadataKey: node}
+	}
+	vols := []openstack.Volume{
+		{ID: "vol-orphan", Status: openstack.VolumeAvailableStatus, Metadata: ephemeral("server-deleted")},
+		{ID: "vol-attached", Status: openstack.VolumeInUseStatus, Metadata: ephemeral("server-deleted"), Attachments: []openstack.Attachment{{ID: "att-1", ServerID: "server-deleted"}}},
+		{ID: "vol-alive", Status: openstack.VolumeInUseStatus, Metadata: ephemeral("server-alive"), Attachments: []openstack.Attachment{{ID: "att-2", ServerID: "server-alive"}}},
+		{ID: "vol-publishing", Status: openstack.VolumeAvailableStatus, Metadata: ephemeral("server-alive")},
+		{ID: "vol-unknown", Status: openstack.VolumeAvailableStatus, Metadata: ephemeral("server-unknown")},
+		{ID: "vol-creating", Status: openstack.VolumeCreatingStatus, Metadata: ephemeral("server-deleted")},
+		// Created by an older release, without the instance of the node
+		{ID: "vol-legacy", Status: openstack.VolumeAvailableStatus, Metadata: ephemeral("")},
+		// Attached with the connector attach mode, to no Nova instance
+		{ID: "vol-bare-metal", Status: openstack.VolumeInUseStatus, Metadata: ephemeral("server-deleted"), Attachments: []openstack.Attachment{{ID: "att-3"}}},
+		{ID: "vol-other-cluster", Status: openstack.VolumeAvailableStatus, Metadata: map[string]string{clusterMetadataKey: "other", ephemeralMetadataKey: "csi-2", ephemeralNodeMetadataKey: "server-deleted"}},
+		{ID: "vol-persistent", Status: openstack.VolumeAvailableStatus, Metadata: map[string]string{clusterMetadataKey: fakeCluster}},
+	}
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("ListVolumes").Return(vols, nil)
+	osmock.On("InstanceExists", "server-deleted").Return(false, nil).Once()
+	osmock.On("InstanceExists", "server-alive").Return(true, nil).Once()
+	osmock.On("InstanceExists", "server-unknown").Return(false, errors.New("gateway timeout"))
+	osmock.On("DeleteVolume", "vol-orphan").Return(nil)
+	osmock.On("ForceDetachVolume", "vol-attached", "att-1").Return(nil)
+
+	assert.NoError(t, newEphemeralCleanup(fakeCluster).reconcile(osmock))
+	osmock.AssertExpectations(t)
+	osmock.AssertNumberOfCalls(t, "DeleteVolume", 1)
+	osmock.AssertNumberOfCalls(t, "ForceDetachVolume", 1)
+	osmock.AssertNotCalled(t, "InstanceExists", "")
+
+	// Deleted once detached
+	osmock = new(openstack.OpenStackMock)
+	osmock.On("ListVolumes").Return([]openstack.Volume{{ID: "vol-attached", Status: openstack.VolumeAvailableStatus, Metadata: ephemeral("server-deleted")}}, nil)
+	osmock.On("InstanceExists", "server-deleted").Return(false, nil)
+	osmock.On("DeleteVolume", "vol-attached").Return(nil)
+	assert.NoError(t, newEphemeralCleanup(fakeCluster).reconcile(osmock))
+	osmock.AssertExpectations(t)
+}
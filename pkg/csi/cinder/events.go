@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+const (
+	// The PVC of a CreateVolume request, passed by the external-provisioner
+	// with --extra-create-metadata
+	pvcNameParameter      = "csi.storage.k8s.io/pvc/name"
+	pvcNamespaceParameter = "csi.storage.k8s.io/pvc/namespace"
+
+	// pvNamePrefix is the prefix of the volume names chosen by the
+	// external-provisioner, followed by the PVC UID
+	pvNamePrefix = "pvc-"
+)
+
+// pvcEvents records events on the PVCs volumes are provisioned for.
+type pvcEvents struct {
+	client   kubernetes.Interface
+	recorder record.EventRecorder
+}
+
+func newPVCEvents(client kubernetes.Interface) *pvcEvents {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&v1core.EventSinkImpl{
+		Interface: client.CoreV1().Events(""),
+	})
+	return &pvcEvents{
+		client:   client,
+		recorder: broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: driverName}),
+	}
+}
+
+// findPVC returns the PVC of a CreateVolume request, from its parameters if
+// the external-provisioner passes them, from the UID in the volume name
+// otherwise.
+func (e *pvcEvents) findPVC(req *csi.CreateVolumeRequest) (*v1.PersistentVolumeClaim, error) {
+	params := req.GetParameters()
+	if name, namespace := params[pvcNameParameter], params[pvcNamespaceParameter]; name != "" && namespace != "" {
+		return e.client.CoreV1().PersistentVolumeClaims(namespace).Get(name, metav1.GetOptions{})
+	}
+
+	if !strings.HasPrefix(req.GetName(), pvNamePrefix) {
+		return nil, fmt.Errorf("volume name %s does not name a PVC", req.GetName())
+	}
+	uid := strings.TrimPrefix(req.GetName(), pvNamePrefix)
+	pvcs, err := e.client.CoreV1().PersistentVolumeClaims("").List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range pvcs.Items {
+		if string(pvcs.Items[i].UID) == uid {
+			return &pvcs.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no PVC with UID %s", uid)
+}
+
+// warn records a warning event on the PVC of a CreateVolume request. It does
+// nothing without a Kubernetes client.
+func (e *pvcEvents) warn(req *csi.CreateVolumeRequest, reason, messageFmt string, args ...interface{}) {
+	if e == nil {
+		return
+	}
+	pvc, err := e.findPVC(req)
+	if err != nil {
+		klog.V(3).Infof("Not recording %s event for volume %s: %v", reason, req.GetName(), err)
+		return
+	}
+	e.recorder.Eventf(pvc, v1.EventTypeWarning, reason, messageFmt, args...)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/mount"
+)
+
+// mkfsOptionsParameter holds the options, separated by spaces, passed to
+// mkfs when a node formats the volume, e.g. "-E lazy_itable_init=1"
+const mkfsOptionsParameter = "mkfsOptions"
+
+// mkfsOptionsContext returns the volume context passing the mkfs options of
+// the CreateVolume parameters to the nodes, nil without any.
+func mkfsOptionsContext(params map[string]string) map[string]string {
+	options := strings.Join(strings.Fields(params[mkfsOptionsParameter]), " ")
+	if options == "" {
+		return nil
+	}
+	return map[string]string{mkfsOptionsParameter: options}
+}
+
+// validateFsTypes verifies that the filesystems of the mount capabilities of
+// a CreateVolume request are supported.
+func validateFsTypes(volCaps []*csi.VolumeCapability) error {
+	for _, volCap := range volCaps {
+		if mnt := volCap.GetMount(); mnt != nil {
+			// The volume may be for a Windows node, whatever the controller
+			// runs on
+			if strings.EqualFold(mnt.GetFsType(), mount.WindowsFsType) {
+				continue
+			}
+			if _, err := mount.NormalizeFsType(mnt.GetFsType()); err != nil {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// volumeFormat returns the filesystem type, the mkfs options and the mount
+// flags of a volume staged with volCap and volumeContext.
+func volumeFormat(volCap *csi.VolumeCapability, volumeContext map[string]string) (string, []string, []string, error) {
+	mnt := volCap.GetMount()
+	fsType, err := mount.NormalizeFsType(mnt.GetFsType())
+	if err != nil {
+		return "", nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return fsType, strings.Fields(volumeContext[mkfsOptionsParameter]), mnt.GetMountFlags(), nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/klog"
+)
+
+// healthzPath is where the health check is served with --http-endpoint.
+const healthzPath = "/healthz"
+
+// healthCheckTimeout bounds each health check, so that a wedged client fails
+// the check instead of hanging the probe. Replaced in the tests.
+var healthCheckTimeout = 5 * time.Second
+
+// healthCheck checks one of the services the driver depends on.
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+// healthChecks returns the checks of the OpenStack services used by the
+// driver: Keystone and Cinder, through the client of the volume operations,
+// and the metadata service unless the driver runs outside of OpenStack.
+func (d *CinderDriver) healthChecks() []healthCheck {
+	checks := []healthCheck{{
+		name: "openstack",
+		check: func() error {
+			cloud, err := openstack.GetOpenStackProvider()
+			if err != nil {
+				return err
+			}
+			return cloud.CheckHealth()
+		},
+	}}
+	if d.runMode != RunModeExternal {
+		checks = append(checks, healthCheck{
+			name: "metadata",
+			check: func() error {
+				metadata, err := openstack.GetMetadataProvider()
+				if err != nil {
+					return err
+				}
+				_, err = metadata.GetInstanceID()
+				return err
+			},
+		})
+	}
+	return checks
+}
+
+// socketHealthCheck checks that the CSI endpoint accepts connections.
+func (d *CinderDriver) socketHealthCheck() healthCheck {
+	return healthCheck{
+		name: "socket",
+		check: func() error {
+			proto, addr, err := ParseEndpoint(d.endpoint)
+			if err != nil {
+				return err
+			}
+			conn, err := net.DialTimeout(proto, addr, healthCheckTimeout)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		},
+	}
+}
+
+// runHealthChecks runs checks concurrently, and returns an error naming all
+// the failed ones, nil when all pass.
+func runHealthChecks(ctx context.Context, checks []healthCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	// The checks are left running when they time out, the channels are
+	// buffered for them not to block
+	results := make([]chan error, len(checks))
+	for i, c := range checks {
+		results[i] = make(chan error, 1)
+		go func(c healthCheck, result chan<- error) {
+			result <- c.check()
+		}(c, results[i])
+	}
+
+	var failed []string
+	for i, c := range checks {
+		var err error
+		select {
+		case err = <-results[i]:
+		case <-ctx.Done():
+			err = fmt.Errorf("no answer within %v", healthCheckTimeout)
+		}
+		if err != nil {
+			klog.V(3).Infof("Health check %s failed: %v", c.name, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", c.name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("health checks failed: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// SetHTTPEndpoint serves the health check on address, on healthzPath, for
+// kubelet HTTP probes. An empty address disables it.
+func (d *CinderDriver) SetHTTPEndpoint(address string) {
+	d.httpEndpoint = address
+}
+
+// serveHealthz answers the health check with 200 when the CSI endpoint and the
+// services of the Probe checks are healthy, with 503 and the failed checks
+// otherwise.
+func (d *CinderDriver) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	checks := append([]healthCheck{d.socketHealthCheck()}, d.healthChecks()...)
+	if err := runHealthChecks(r.Context(), checks); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// serveHealth serves the health check on address in the background.
+func (d *CinderDriver) serveHealth(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(healthzPath, d.serveHealthz)
+
+	go func() {
+		klog.Infof("Serving the health check on %s%s", address, healthzPath)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			klog.Errorf("Failed to serve the health check on %s: %v", address, err)
+		}
+	}()
+}
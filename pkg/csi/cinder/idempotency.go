@@ -0,0 +1,112 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/klog"
+)
+
+// parametersHashMetadataKey is the volume metadata key holding the hash of
+// the CreateVolume parameters the volume was created with
+const parametersHashMetadataKey = driverName + "/parameters-hash"
+
+// volumeParameters are the normalized CreateVolume parameters a volume is
+// created with, as requested, before the placement webhook. Metadata hints
+// are left out, they may differ between retries.
+type volumeParameters struct {
+	sizeGB       int
+	volType      string
+	availability string
+	snapshotID   string
+	sourceVolID  string
+}
+
+// hash returns the hash of the parameters. Its format must not change, it is
+// compared with the hash stored by earlier releases, so the source volume is
+// only part of it for clones.
+func (p volumeParameters) hash() string {
+	s := fmt.Sprintf("size=%d\ntype=%s\navailability=%s\nsnapshot=%s", p.sizeGB, p.volType, p.availability, p.snapshotID)
+	if p.sourceVolID != "" {
+		s += fmt.Sprintf("\nsource=%s", p.sourceVolID)
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// diff describes how a volume differs from the parameters. Only the hash of
+// the requested parameters is stored, so the diff compares with the volume
+// itself, which may also differ because of the placement webhook.
+func (p volumeParameters) diff(vol openstack.Volume) string {
+	var diffs []string
+	if vol.Size != p.sizeGB {
+		diffs = append(diffs, fmt.Sprintf("size: requested %d GiB, volume has %d GiB", p.sizeGB, vol.Size))
+	}
+	if vol.VolumeType != p.volType && p.volType != "" {
+		diffs = append(diffs, fmt.Sprintf("type: requested %q, volume has %q", p.volType, vol.VolumeType))
+	}
+	if vol.AZ != p.availability && p.availability != "" {
+		diffs = append(diffs, fmt.Sprintf("availability: requested %q, volume has %q", p.availability, vol.AZ))
+	}
+	if vol.SnapshotID != p.snapshotID {
+		diffs = append(diffs, fmt.Sprintf("source snapshot: requested %q, volume has %q", p.snapshotID, vol.SnapshotID))
+	}
+	if vol.SourceVolID != p.sourceVolID {
+		diffs = append(diffs, fmt.Sprintf("source volume: requested %q, volume has %q", p.sourceVolID, vol.SourceVolID))
+	}
+	if len(diffs) == 0 {
+		return "the parameters differ"
+	}
+	return strings.Join(diffs, ", ")
+}
+
+// SetStrictIdempotency makes CreateVolume store the hash of its parameters
+// in the metadata of the volumes it creates, and fail with AlreadyExists when
+// a volume with the requested name was created with other parameters instead
+// of returning it.
+func (d *CinderDriver) SetStrictIdempotency(strict bool) {
+	if strict {
+		klog.Infof("Checking the parameters of existing volumes in CreateVolume")
+	}
+	d.strictIdempotency = strict
+}
+
+// checkVolumeParameters verifies that an existing volume with the requested
+// name was created with the requested parameters before CreateVolume returns
+// it. Volumes without a parameters hash, e.g. created by older releases, are
+// not checked.
+func (cs *controllerServer) checkVolumeParameters(volName string, vol openstack.Volume, params volumeParameters) error {
+	stored, ok := vol.Metadata[parametersHashMetadataKey]
+	if !ok {
+		klog.V(4).Infof("Volume %s with name %s has no %s metadata, its parameters are not checked", vol.ID, volName, parametersHashMetadataKey)
+		return nil
+	}
+	if stored == params.hash() {
+		return nil
+	}
+
+	diff := params.diff(vol)
+	klog.V(3).Infof("Volume %s with name %s was created with other parameters: %s", vol.ID, volName, diff)
+	return status.Errorf(codes.AlreadyExists, "volume %s with name %s already exists with other parameters: %s", vol.ID, volName, diff)
+}
@@ -18,10 +18,21 @@ package cinder
 
 import (
 	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
 )
 
 func TestGetPluginInfo(t *testing.T) {
@@ -35,3 +46,142 @@ func TestGetPluginInfo(t *testing.T) {
 	assert.Equal(t, resp.GetName(), driverName)
 	assert.Equal(t, resp.GetVendorVersion(), vendorVersion)
 }
+
+func TestGetPluginInfoManifest(t *testing.T) {
+	d := NewDriver(fakeNodeID, fakeEndpoint, fakeCluster, fakeConfig)
+	ids := NewIdentityServer(d)
+
+	// Nothing known about the cloud
+	resp, err := ids.GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.GetManifest())
+
+	// Outside of the cloud the zone is not known
+	d.cloud = openstack.CloudInfo{AuthURLHost: "keystone.example.com:5000", Region: "RegionOne", Project: "demo"}
+	resp, err = ids.GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"cloud":   "keystone.example.com:5000",
+		"region":  "RegionOne",
+		"project": "demo",
+	}, resp.GetManifest())
+
+	d.zone = "nova"
+	resp, err = ids.GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "nova", resp.GetManifest()["zone"])
+	assert.NotContains(t, resp.GetManifest(), "extra_regions")
+	assert.Len(t, d.metricLabels(), 5)
+}
+
+func TestLoadCloudInfoExtraRegions(t *testing.T) {
+	defer func(cloud openstack.IOpenStack, regions map[string]openstack.IOpenStack) {
+		openstack.OsInstance, openstack.OsRegionInstances = cloud, regions
+	}(openstack.OsInstance, openstack.OsRegionInstances)
+	openstack.OsInstance = new(openstack.OpenStackMock)
+	openstack.OsRegionInstances = map[string]openstack.IOpenStack{
+		"RegionTwo":   new(openstack.OpenStackMock),
+		"RegionThree": new(openstack.OpenStackMock),
+	}
+
+	d := NewDriver(fakeNodeID, fakeEndpoint, fakeCluster, fakeConfig)
+	d.runMode = RunModeExternal
+	d.loadCloudInfo()
+
+	resp, err := NewIdentityServer(d).GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "RegionThree,RegionTwo", resp.GetManifest()["extra_regions"])
+	assert.Equal(t, "RegionThree,RegionTwo", d.metricLabels()["extra_regions"])
+}
+
+// fakeMetadata is a metadata service failing with err.
+type fakeMetadata struct {
+	err error
+}
+
+func (m *fakeMetadata) GetInstanceID() (string, error) {
+	return "", m.err
+}
+
+func (m *fakeMetadata) GetAvailabilityZone() (string, error) {
+	return "", m.err
+}
+
+func TestProbe(t *testing.T) {
+	d := NewDriver(fakeNodeID, fakeEndpoint, fakeCluster, fakeConfig)
+	ids := NewIdentityServer(d)
+	defer func(cloud openstack.IOpenStack, metadata openstack.IMetadata) {
+		openstack.OsInstance, openstack.MetadataService = cloud, metadata
+	}(openstack.OsInstance, openstack.MetadataService)
+
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("CheckHealth").Return(nil).Once()
+	openstack.OsInstance = osmock
+	openstack.MetadataService = &fakeMetadata{}
+
+	_, err := ids.Probe(context.Background(), &csi.ProbeRequest{})
+	assert.NoError(t, err)
+
+	// Keystone or Cinder unreachable
+	osmock.On("CheckHealth").Return(errors.New("keystone is unreachable"))
+	_, err = ids.Probe(context.Background(), &csi.ProbeRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, err.Error(), "openstack: keystone is unreachable")
+
+	// The metadata service is not checked outside of OpenStack
+	osmock = new(openstack.OpenStackMock)
+	osmock.On("CheckHealth").Return(nil)
+	openstack.OsInstance = osmock
+	openstack.MetadataService = &fakeMetadata{err: errors.New("connection refused")}
+	_, err = ids.Probe(context.Background(), &csi.ProbeRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, err.Error(), "metadata: connection refused")
+
+	assert.NoError(t, d.SetRunMode(RunModeExternal))
+	_, err = ids.Probe(context.Background(), &csi.ProbeRequest{})
+	assert.NoError(t, err)
+}
+
+// A wedged client fails the check instead of hanging the probe.
+func TestRunHealthChecksTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { healthCheckTimeout = timeout }(healthCheckTimeout)
+	healthCheckTimeout = 10 * time.Millisecond
+
+	wedged := make(chan struct{})
+	defer close(wedged)
+	err := runHealthChecks(context.Background(), []healthCheck{
+		{name: "ok", check: func() error { return nil }},
+		{name: "wedged", check: func() error { <-wedged; return nil }},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "wedged: no answer")
+		assert.NotContains(t, err.Error(), "ok:")
+	}
+}
+
+func TestServeHealthz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cinder-csi-health")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "csi.sock")
+
+	d := NewDriver(fakeNodeID, "unix://"+socket, fakeCluster, fakeConfig)
+	defer func(cloud openstack.IOpenStack) { openstack.OsInstance = cloud }(openstack.OsInstance)
+	assert.NoError(t, d.SetRunMode(RunModeExternal))
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("CheckHealth").Return(nil)
+	openstack.OsInstance = osmock
+
+	// The CSI endpoint is not listening
+	w := httptest.NewRecorder()
+	d.serveHealthz(w, httptest.NewRequest("GET", healthzPath, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "socket:")
+
+	listener, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	defer listener.Close()
+	w = httptest.NewRecorder()
+	d.serveHealthz(w, httptest.NewRequest("GET", healthzPath, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+	"k8s.io/klog"
+)
+
+const (
+	// LogFormatText logs the RPC lines with klog, prefixed with their fields
+	LogFormatText = "text"
+	// LogFormatJSON writes the RPC lines to stderr as JSON objects, one per
+	// line, for log collectors to index their fields
+	LogFormatJSON = "json"
+
+	// requestIDMetadataKey is the gRPC metadata a caller can pass its own
+	// request ID in
+	requestIDMetadataKey = "x-request-id"
+)
+
+var (
+	logFormat = LogFormatText
+	// jsonLogLock keeps the JSON lines of concurrent RPCs whole
+	jsonLogLock sync.Mutex
+)
+
+// SetLogFormat sets how the lines of the RPCs are logged, LogFormatText or
+// LogFormatJSON.
+func SetLogFormat(format string) error {
+	switch format {
+	case LogFormatText, LogFormatJSON:
+		logFormat = format
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q, want %q or %q", format, LogFormatText, LogFormatJSON)
+}
+
+type rpcLoggerKey struct{}
+
+// rpcLogger logs the lines of an RPC with its method, request ID and the
+// volume, snapshot or name it is about, for all the lines of a call to be
+// found together.
+type rpcLogger struct {
+	method    string
+	requestID string
+	fields    [][2]string
+}
+
+// newRPCLogger returns the logger of a call of method with req. The request
+// ID is the x-request-id metadata of the call, a new one when there is none.
+func newRPCLogger(ctx context.Context, method string, req interface{}) *rpcLogger {
+	l := &rpcLogger{method: method}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDMetadataKey); len(ids) > 0 {
+			l.requestID = ids[0]
+		}
+	}
+	if l.requestID == "" {
+		l.requestID = newRequestID()
+	}
+	switch r := req.(type) {
+	case interface{ GetVolumeId() string }:
+		l.fields = append(l.fields, [2]string{"volume_id", r.GetVolumeId()})
+	case interface{ GetSnapshotId() string }:
+		l.fields = append(l.fields, [2]string{"snapshot_id", r.GetSnapshotId()})
+	case interface{ GetName() string }:
+		l.fields = append(l.fields, [2]string{"name", r.GetName()})
+	}
+	if r, ok := req.(*csi.CreateSnapshotRequest); ok {
+		l.fields = append(l.fields, [2]string{"volume_id", r.GetSourceVolumeId()})
+	}
+	return l
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// withRPCLogger returns ctx carrying l for logFor.
+func withRPCLogger(ctx context.Context, l *rpcLogger) context.Context {
+	return context.WithValue(ctx, rpcLoggerKey{}, l)
+}
+
+// logFor returns the logger of the RPC of ctx. The lines of a context of no
+// RPC, e.g. in tests, are logged without fields.
+func logFor(ctx context.Context) *rpcLogger {
+	if l, ok := ctx.Value(rpcLoggerKey{}).(*rpcLogger); ok {
+		return l
+	}
+	return &rpcLogger{}
+}
+
+// rpcVerbose logs at a verbosity level, like klog.Verbose.
+type rpcVerbose struct {
+	l       *rpcLogger
+	enabled bool
+}
+
+// V returns a logger of the lines of level, logged only at that verbosity.
+func (l *rpcLogger) V(level klog.Level) rpcVerbose {
+	return rpcVerbose{l: l, enabled: bool(klog.V(level))}
+}
+
+func (v rpcVerbose) Infof(format string, args ...interface{}) {
+	if v.enabled {
+		v.l.output("info", fmt.Sprintf(format, args...))
+	}
+}
+
+func (l *rpcLogger) Infof(format string, args ...interface{}) {
+	l.output("info", fmt.Sprintf(format, args...))
+}
+
+func (l *rpcLogger) Warningf(format string, args ...interface{}) {
+	l.output("warning", fmt.Sprintf(format, args...))
+}
+
+func (l *rpcLogger) Errorf(format string, args ...interface{}) {
+	l.output("error", fmt.Sprintf(format, args...))
+}
+
+// prefix returns the fields of l as logged in text, e.g.
+// "[method=/csi.v1.Controller/DeleteVolume request_id=1f2e volume_id=abc] ".
+func (l *rpcLogger) prefix() string {
+	if l.requestID == "" {
+		return ""
+	}
+	parts := []string{"method=" + l.method, "request_id=" + l.requestID}
+	for _, f := range l.fields {
+		if f[1] != "" {
+			parts = append(parts, f[0]+"="+f[1])
+		}
+	}
+	return "[" + strings.Join(parts, " ") + "] "
+}
+
+// jsonLine returns the JSON object line of the message msg at level.
+func (l *rpcLogger) jsonLine(now time.Time, level, msg string) []byte {
+	line := map[string]string{
+		"ts":    now.UTC().Format(time.RFC3339Nano),
+		"level": level,
+		"msg":   msg,
+	}
+	if l.requestID != "" {
+		line["method"] = l.method
+		line["request_id"] = l.requestID
+	}
+	for _, f := range l.fields {
+		if f[1] != "" {
+			line[f[0]] = f[1]
+		}
+	}
+	b, _ := json.Marshal(line)
+	return append(b, '\n')
+}
+
+func (l *rpcLogger) output(level, msg string) {
+	if logFormat == LogFormatJSON {
+		b := l.jsonLine(time.Now(), level, msg)
+		jsonLogLock.Lock()
+		os.Stderr.Write(b)
+		jsonLogLock.Unlock()
+		return
+	}
+	// Reported at the line of the caller of Infof, Warningf or Errorf
+	msg = l.prefix() + msg
+	switch level {
+	case "warning":
+		klog.WarningDepth(2, msg)
+	case "error":
+		klog.ErrorDepth(2, msg)
+	default:
+		klog.InfoDepth(2, msg)
+	}
+}
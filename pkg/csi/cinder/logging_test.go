@@ -0,0 +1,77 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRPCLoggerFields(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestIDMetadataKey, "req-1"))
+	l := newRPCLogger(ctx, "/csi.v1.Controller/DeleteVolume", &csi.DeleteVolumeRequest{VolumeId: "vol-1"})
+	assert.Equal(t, "[method=/csi.v1.Controller/DeleteVolume request_id=req-1 volume_id=vol-1] ", l.prefix())
+
+	now := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	var line map[string]string
+	assert.NoError(t, json.Unmarshal(l.jsonLine(now, "error", "failed"), &line))
+	assert.Equal(t, map[string]string{
+		"ts":         "2019-06-01T00:00:00Z",
+		"level":      "error",
+		"msg":        "failed",
+		"method":     "/csi.v1.Controller/DeleteVolume",
+		"request_id": "req-1",
+		"volume_id":  "vol-1",
+	}, line)
+
+	// Snapshots are logged with their source volume
+	l = newRPCLogger(context.Background(), "/csi.v1.Controller/CreateSnapshot", &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "vol-1"})
+	assert.NotEmpty(t, l.requestID)
+	assert.Contains(t, l.prefix(), "name=snap-1 volume_id=vol-1] ")
+
+	// A context of no RPC logs without fields
+	assert.Equal(t, "", logFor(context.Background()).prefix())
+}
+
+// The handlers of the RPCs log with the request ID of the call.
+func TestLogGRPCRequestID(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestIDMetadataKey, "req-2"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeGetInfo"}
+	var got *rpcLogger
+	_, err := logGRPC(ctx, &csi.NodeGetInfoRequest{}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		got = logFor(ctx)
+		return &csi.NodeGetInfoResponse{}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "req-2", got.requestID)
+	assert.Equal(t, "/csi.v1.Node/NodeGetInfo", got.method)
+}
+
+func TestSetLogFormat(t *testing.T) {
+	defer SetLogFormat(LogFormatText)
+	assert.NoError(t, SetLogFormat(LogFormatJSON))
+	assert.Equal(t, LogFormatJSON, logFormat)
+	assert.Error(t, SetLogFormat("xml"))
+	assert.Equal(t, LogFormatJSON, logFormat)
+}
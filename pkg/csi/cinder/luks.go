@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+)
+
+const (
+	// luksParameter requests the node to encrypt the volume with LUKS,
+	// independently of the encryption of the Cinder backend
+	luksParameter = "luks"
+	// luksBarbicanSecretParameter is the ID of the Barbican secret holding
+	// the LUKS key, instead of the node stage secret
+	luksBarbicanSecretParameter = "luksBarbicanSecretID"
+	// luksKeySecret is the key of the node stage secret holding the LUKS key
+	luksKeySecret = "luksKey"
+
+	// luksMapperPrefix prefixes the volume ID in the name of the opened LUKS
+	// volumes
+	luksMapperPrefix = "luks-"
+)
+
+// luksContext returns the volume context requesting the nodes to encrypt a
+// volume created with the given parameters, nil for an unencrypted volume.
+func luksContext(params map[string]string) (map[string]string, error) {
+	luks, err := luksRequested(params)
+	if err != nil {
+		return nil, err
+	}
+	secretID := params[luksBarbicanSecretParameter]
+	if !luks {
+		if secretID != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "parameter %s needs the %s parameter", luksBarbicanSecretParameter, luksParameter)
+		}
+		return nil, nil
+	}
+	ctx := map[string]string{luksParameter: "true"}
+	if secretID != "" {
+		ctx[luksBarbicanSecretParameter] = secretID
+	}
+	return ctx, nil
+}
+
+// luksRequested returns whether the volume of the volume context, or of the
+// CreateVolume parameters, is encrypted with LUKS.
+func luksRequested(volumeContext map[string]string) (bool, error) {
+	value, ok := volumeContext[luksParameter]
+	if !ok {
+		return false, nil
+	}
+	luks, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "invalid %s parameter %q, must be true or false", luksParameter, value)
+	}
+	return luks, nil
+}
+
+func luksMapperName(volumeID string) string {
+	return luksMapperPrefix + volumeID
+}
+
+// luksKey returns the LUKS key of volumeID: the payload of its Barbican
+// secret if it has one, the luksKey of the node stage secrets otherwise.
+func luksKey(volumeID string, volumeContext, secrets map[string]string) ([]byte, error) {
+	if secretID := volumeContext[luksBarbicanSecretParameter]; secretID != "" {
+		cloud, err := openstack.GetOpenStackProvider()
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		key, err := cloud.GetSecretPayload(secretID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to get the LUKS key of volume %s from Barbican secret %s: %v", volumeID, secretID, err)
+		}
+		if len(key) == 0 {
+			return nil, status.Errorf(codes.FailedPrecondition, "Barbican secret %s of volume %s is empty", secretID, volumeID)
+		}
+		return key, nil
+	}
+
+	key, ok := secrets[luksKeySecret]
+	if !ok || key == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s is encrypted but the node stage secret has no %s", volumeID, luksKeySecret)
+	}
+	return []byte(key), nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// sysBlockPath lists the block devices of the node. Replaced in the tests.
+var sysBlockPath = "/sys/block"
+
+// diskBuses are the disk buses Nova attaches volumes on, by the prefix of
+// their device names, with the number of disks they take. Their names run
+// out after vdz and xvdz.
+var diskBuses = []struct {
+	prefix string
+	limit  int64
+}{
+	{"vd", 26},
+	{"xvd", 26},
+}
+
+// cinderSerial matches the serial of an attached Cinder volume, its ID
+// truncated to the 20 characters of a virtio serial.
+var cinderSerial = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]`)
+
+// SetMaxVolumesPerNode sets the maximum number of volumes NodeGetInfo
+// reports the node can have attached. 0 detects it from the disk bus of the
+// node, a negative one reports no maximum.
+func (d *CinderDriver) SetMaxVolumesPerNode(max int64) {
+	if max > 0 {
+		klog.Infof("Reporting at most %d volumes per node", max)
+	}
+	d.maxVolumesPerNode = max
+}
+
+// getMaxVolumesPerNode returns the maximum number of volumes of the node,
+// 0 for no maximum.
+func (d *CinderDriver) getMaxVolumesPerNode() int64 {
+	if d.maxVolumesPerNode != 0 {
+		if d.maxVolumesPerNode < 0 {
+			return 0
+		}
+		return d.maxVolumesPerNode
+	}
+	max, err := detectMaxVolumesPerNode(sysBlockPath)
+	if err != nil {
+		klog.Warningf("Failed to detect the maximum number of volumes of the node, reporting none: %v", err)
+		return 0
+	}
+	return max
+}
+
+// detectMaxVolumesPerNode returns the number of disks the bus of the disks
+// in dir takes, less the disks other than Cinder volumes, e.g. the root disk
+// or a config drive, as the scheduler only counts volumes of the driver. 0 is
+// returned for no disks on a known bus, e.g. on a bare-metal node.
+func detectMaxVolumesPerNode(dir string) (int64, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	for _, bus := range diskBuses {
+		var disks, others int64
+		for _, entry := range entries {
+			name := entry.Name()
+			if !strings.HasPrefix(name, bus.prefix) {
+				continue
+			}
+			disks++
+			serial, err := ioutil.ReadFile(filepath.Join(dir, name, "serial"))
+			if err != nil || !cinderSerial.Match(serial) {
+				others++
+			}
+		}
+		if disks == 0 {
+			continue
+		}
+		max := bus.limit - others
+		if max < 1 {
+			max = 1
+		}
+		klog.V(4).Infof("Detected %d disks on the %s bus, %d other than volumes, allowing %d volumes", disks, bus.prefix, others, max)
+		return max, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+// metadataHintPrefix prefixes the StorageClass parameters passed to Cinder
+// as volume metadata, e.g. cinder.csi.openstack.org/image_cache becomes the
+// image_cache metadata.
+const metadataHintPrefix = driverName + "/"
+
+// metadataMaxLength is the longest metadata key or value Cinder accepts
+const metadataMaxLength = 255
+
+// SetMetadataHints allows the StorageClass parameters prefixed with
+// metadataHintPrefix for the given metadata keys, to pass backend specific
+// hints to Cinder. Any other parameter with the prefix is rejected.
+func (d *CinderDriver) SetMetadataHints(keys []string) error {
+	hints := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key == "" || strings.Contains(key, "/") || len(key) > metadataMaxLength {
+			return fmt.Errorf("invalid metadata hint %q", key)
+		}
+		hints[key] = true
+	}
+	if len(hints) > 0 {
+		klog.Infof("Allowing metadata hints: %s", strings.Join(sortedKeys(hints), ", "))
+	}
+	d.metadataHints = hints
+	return nil
+}
+
+// getMetadataHints returns the volume metadata requested by the parameters
+// of a CreateVolume request.
+func (d *CinderDriver) getMetadataHints(params map[string]string) (map[string]string, error) {
+	hints := map[string]string{}
+	for param, value := range params {
+		if !strings.HasPrefix(param, metadataHintPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(param, metadataHintPrefix)
+		if !d.metadataHints[key] {
+			allowed := "none"
+			if len(d.metadataHints) > 0 {
+				allowed = strings.Join(sortedKeys(d.metadataHints), ", ")
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "parameter %s is not an allowed metadata hint, allowed: %s", param, allowed)
+		}
+		if len(value) > metadataMaxLength {
+			return nil, status.Errorf(codes.InvalidArgument, "parameter %s is longer than the %d characters of Cinder metadata", param, metadataMaxLength)
+		}
+		hints[key] = value
+	}
+	return hints, nil
+}
+
+// metadataHintsContext returns the volume context recording the hints
+// applied to a volume with the given metadata.
+func metadataHintsContext(hints, metadata map[string]string) map[string]string {
+	var ctx map[string]string
+	for key := range hints {
+		value, ok := metadata[key]
+		if !ok {
+			continue
+		}
+		if ctx == nil {
+			ctx = map[string]string{}
+		}
+		ctx[metadataHintPrefix+key] = value
+	}
+	return ctx
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/klog"
+)
+
+const (
+	cinderCSISubsystem            = "cinder_csi"
+	placementWebhookDurationKey   = "placement_webhook_duration_seconds"
+	placementWebhookResultAllowed = "allowed"
+	placementWebhookResultDenied  = "denied"
+	placementWebhookResultError   = "error"
+
+	volumeTimeToAvailableKey   = "volume_time_to_available_seconds"
+	volumeAvailableDeadlineKey = "volume_available_deadline_exceeded_total"
+	volumeTypeLabelDefault     = "default"
+	volumeTypeLabelOther       = "other"
+
+	rpcDurationKey = "rpc_duration_seconds"
+	rpcErrorsKey   = "rpc_errors_total"
+
+	staleAttachmentsKey = "stale_attachments_reconciled_total"
+
+	ephemeralVolumesCleanedKey = "ephemeral_volumes_cleaned_total"
+
+	// metricsPath is where the metrics are served with --metrics-address
+	metricsPath = "/metrics"
+)
+
+var (
+	placementWebhookDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: cinderCSISubsystem,
+			Name:      placementWebhookDurationKey,
+			Help:      "Latency of placement webhook calls made during CreateVolume",
+		},
+		[]string{"result"},
+	)
+
+	// volumeTimeToAvailable and volumeAvailableDeadlineExceeded are only
+	// recorded when CreateVolume waits for its volumes, with a creating
+	// deadline
+	volumeTimeToAvailable = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: cinderCSISubsystem,
+			Name:      volumeTimeToAvailableKey,
+			Help:      "Time from the Cinder create call to the volume being available",
+			Buckets:   []float64{1, 2.5, 5, 10, 15, 20, 30, 45, 60, 90, 120, 180, 300, 600},
+		},
+		[]string{"volume_type", "availability_zone"},
+	)
+	volumeAvailableDeadlineExceeded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: cinderCSISubsystem,
+			Name:      volumeAvailableDeadlineKey,
+			Help:      "Number of created volumes not available within the creating deadline",
+		},
+		[]string{"volume_type", "availability_zone"},
+	)
+
+	// rpcDuration and rpcErrors are recorded for all the CSI calls, queued
+	// time included, rpcErrors by gRPC status code
+	rpcDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: cinderCSISubsystem,
+			Name:      rpcDurationKey,
+			Help:      "Latency of CSI calls, failed ones included",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		},
+		[]string{"method"},
+	)
+	rpcErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: cinderCSISubsystem,
+			Name:      rpcErrorsKey,
+			Help:      "Number of failed CSI calls, by gRPC status code",
+		},
+		[]string{"method", "code"},
+	)
+
+	// staleAttachmentsReconciled is only recorded with
+	// --stale-attachment-interval
+	staleAttachmentsReconciled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: cinderCSISubsystem,
+			Name:      staleAttachmentsKey,
+			Help:      "Number of volumes force-detached from deleted instances or reset from detaching, by result",
+		},
+		[]string{"result"},
+	)
+
+	// ephemeralVolumesCleaned is only recorded with
+	// --ephemeral-cleanup-interval
+	ephemeralVolumesCleaned = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: cinderCSISubsystem,
+			Name:      ephemeralVolumesCleanedKey,
+			Help:      "Number of ephemeral volumes of deleted instances deleted or force-detached, by result",
+		},
+		[]string{"result"},
+	)
+
+	registerMetricsOnce sync.Once
+)
+
+// RegisterMetrics registers the Cinder CSI driver metrics with the default
+// prometheus registry, with labels added to all of them. It is safe to call
+// more than once, only the labels of the first call are used.
+func RegisterMetrics(labels prometheus.Labels) {
+	registerMetricsOnce.Do(func() {
+		registerer := prometheus.WrapRegistererWith(labels, prometheus.DefaultRegisterer)
+		if err := registerer.Register(placementWebhookDuration); err != nil {
+			klog.V(5).Infof("unable to register for placement webhook metrics")
+		}
+		if err := registerer.Register(volumeTimeToAvailable); err != nil {
+			klog.V(5).Infof("unable to register for volume time to available metrics")
+		}
+		if err := registerer.Register(volumeAvailableDeadlineExceeded); err != nil {
+			klog.V(5).Infof("unable to register for volume available deadline metrics")
+		}
+		if err := registerer.Register(rpcDuration); err != nil {
+			klog.V(5).Infof("unable to register for CSI call latency metrics")
+		}
+		if err := registerer.Register(rpcErrors); err != nil {
+			klog.V(5).Infof("unable to register for CSI call error metrics")
+		}
+		if err := registerer.Register(staleAttachmentsReconciled); err != nil {
+			klog.V(5).Infof("unable to register for stale attachment metrics")
+		}
+		if err := registerer.Register(ephemeralVolumesCleaned); err != nil {
+			klog.V(5).Infof("unable to register for ephemeral cleanup metrics")
+		}
+		openstack.RegisterMetrics(registerer)
+	})
+}
+
+// statusWait records how long an OpenStack resource took to reach a status
+// since start, or that it did not reach it within the deadline of the wait.
+// A nil statusWait records nothing.
+type statusWait struct {
+	start  time.Time
+	labels []string
+
+	duration         *prometheus.HistogramVec
+	deadlineExceeded *prometheus.CounterVec
+}
+
+func (w *statusWait) reached() {
+	if w != nil {
+		w.duration.WithLabelValues(w.labels...).Observe(time.Since(w.start).Seconds())
+	}
+}
+
+func (w *statusWait) exceeded() {
+	if w != nil {
+		w.deadlineExceeded.WithLabelValues(w.labels...).Inc()
+	}
+}
+
+// volumeAvailableWait records the time a volume of volType created in zone at
+// start takes to be available. Types outside of the allowed metrics volume
+// types share a label to bound the cardinality.
+func (d *CinderDriver) volumeAvailableWait(start time.Time, volType, zone string) *statusWait {
+	switch {
+	case volType == "":
+		volType = volumeTypeLabelDefault
+	case !d.metricsVolumeTypes[volType]:
+		volType = volumeTypeLabelOther
+	}
+	return &statusWait{
+		start:            start,
+		labels:           []string{volType, zone},
+		duration:         volumeTimeToAvailable,
+		deadlineExceeded: volumeAvailableDeadlineExceeded,
+	}
+}
+
+// SetMetricsVolumeTypes sets the volume types the volume metrics are labelled
+// with, the others are labelled "other".
+func (d *CinderDriver) SetMetricsVolumeTypes(types []string) {
+	d.metricsVolumeTypes = make(map[string]bool, len(types))
+	for _, t := range types {
+		d.metricsVolumeTypes[t] = true
+	}
+}
+
+// observeRPC records a CSI call of fullMethod, e.g.
+// /csi.v1.Controller/CreateVolume, started at start and failed with err.
+func observeRPC(fullMethod string, start time.Time, err error) {
+	method := path.Base(fullMethod)
+	rpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		rpcErrors.WithLabelValues(method, status.Code(err).String()).Inc()
+	}
+}
+
+// SetMetricsAddress serves the metrics on address, on metricsPath. An empty
+// address disables it.
+func (d *CinderDriver) SetMetricsAddress(address string) {
+	d.metricsAddress = address
+}
+
+// serveMetrics serves the metrics of the default prometheus registry on
+// address in the background.
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.Handler())
+
+	go func() {
+		klog.Infof("Serving the metrics on %s%s", address, metricsPath)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			klog.Errorf("Failed to serve the metrics on %s: %v", address, err)
+		}
+	}()
+}
@@ -0,0 +1,153 @@
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/util/mount"
+	utilexec "k8s.io/utils/exec"
+)
+
+// DefaultFsType is the filesystem of the volumes requested without one.
+const DefaultFsType = "ext4"
+
+// mkfsDefaults are the options of mkfs for each supported filesystem, before
+// those of the volume: the ext filesystems reserve no blocks for root.
+var mkfsDefaults = map[string][]string{
+	"ext2":  {"-F", "-m0"},
+	"ext3":  {"-F", "-m0"},
+	"ext4":  {"-F", "-m0"},
+	"xfs":   nil,
+	"btrfs": nil,
+}
+
+// NormalizeFsType returns the filesystem type fsType stands for, DefaultFsType
+// when it is empty, and fails for unsupported filesystems.
+func NormalizeFsType(fsType string) (string, error) {
+	fsType = strings.ToLower(strings.TrimSpace(fsType))
+	if fsType == "" {
+		return DefaultFsType, nil
+	}
+	if _, ok := mkfsDefaults[fsType]; !ok {
+		return "", fmt.Errorf("unsupported filesystem type %q, must be one of ext2, ext3, ext4, xfs or btrfs", fsType)
+	}
+	return fsType, nil
+}
+
+// formatter formats devices and mounts their filesystems.
+type formatter struct {
+	exec  mount.Exec
+	mount func(source, target, fstype string, options []string) error
+}
+
+// FormatAndMount formats source with a filesystem of fstype, with the given
+// mkfs options, unless it already has one, and mounts it on target with
+// options. A source with another filesystem, or a partition table, is not
+// mounted.
+func (m *Mount) FormatAndMount(source string, target string, fstype string, mkfsOptions []string, options []string) error {
+	f := &formatter{exec: mount.NewOsExec(), mount: mount.New("").Mount}
+	return f.formatAndMount(source, target, fstype, mkfsOptions, options)
+}
+
+func (f *formatter) formatAndMount(source, target, fstype string, mkfsOptions, options []string) error {
+	fstype, err := NormalizeFsType(fstype)
+	if err != nil {
+		return err
+	}
+	existing, err := f.diskFormat(source)
+	if err != nil {
+		return err
+	}
+
+	switch existing {
+	case "":
+		args := append(append(append([]string{}, mkfsDefaults[fstype]...), mkfsOptions...), source)
+		klog.Infof("Formatting %s as %s with mkfs.%s %s", source, fstype, fstype, strings.Join(args, " "))
+		if out, err := f.exec.Run("mkfs."+fstype, args...); err != nil {
+			return fmt.Errorf("failed to format %s as %s: %v: %s", source, fstype, err, out)
+		}
+	case fstype:
+		if err := f.checkFilesystem(source, fstype); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%s already has a %s filesystem, not the requested %s", source, existing, fstype)
+	}
+
+	return f.mount(source, target, fstype, options)
+}
+
+// diskFormat returns the filesystem of a device, "" when it is blank. A
+// partition table is reported as such, to never be formatted over.
+func (f *formatter) diskFormat(source string) (string, error) {
+	out, err := f.exec.Run("blkid", "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", source)
+	if err != nil {
+		// blkid fails with exit status 2 when it finds nothing on the device
+		if exit, ok := err.(utilexec.ExitError); ok && exit.ExitStatus() == 2 {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to probe %s: %v: %s", source, err, out)
+	}
+
+	var fsType, ptType string
+	for _, line := range strings.Split(string(out), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "TYPE":
+			fsType = kv[1]
+		case "PTTYPE":
+			ptType = kv[1]
+		}
+	}
+	if ptType != "" {
+		return "", fmt.Errorf("%s has a %s partition table, it is not formatted nor mounted", source, ptType)
+	}
+	if fsType == "" {
+		return "", fmt.Errorf("blkid found neither a filesystem nor a partition table on %s: %s", source, out)
+	}
+	return fsType, nil
+}
+
+// checkFilesystem repairs the ext filesystem on source before mounting it,
+// other filesystems are checked by the kernel when mounted.
+func (f *formatter) checkFilesystem(source, fstype string) error {
+	if !strings.HasPrefix(fstype, "ext") {
+		return nil
+	}
+	out, err := f.exec.Run("fsck", "-a", source)
+	if err == nil {
+		return nil
+	}
+	exit, ok := err.(utilexec.ExitError)
+	switch {
+	case ok && exit.ExitStatus() == 1:
+		klog.Infof("Repaired the filesystem on %s: %s", source, out)
+		return nil
+	case ok && exit.ExitStatus() == 4:
+		return fmt.Errorf("the filesystem on %s has errors fsck could not repair: %s", source, out)
+	}
+	klog.Warningf("Failed to check the filesystem on %s: %v: %s", source, err, out)
+	return nil
+}
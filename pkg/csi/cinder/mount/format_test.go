@@ -0,0 +1,115 @@
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	formatDevicePath = "/dev/vdd"
+	formatMountPath  = "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-2/globalmount"
+)
+
+// fakeFormatter returns a formatter finding blkidOut on the device, nothing
+// when it is "", recording the commands run and the mounts.
+func fakeFormatter(blkidOut string, ran *[]string) *formatter {
+	return &formatter{
+		exec: fakeExec(func(cmd string, args []string) ([]byte, error) {
+			*ran = append(*ran, strings.Join(append([]string{cmd}, args...), " "))
+			if cmd == "blkid" {
+				if blkidOut == "" {
+					return nil, utilexec.CodeExitError{Err: errors.New("exit status 2"), Code: 2}
+				}
+				return []byte(blkidOut), nil
+			}
+			return nil, nil
+		}),
+		mount: func(source, target, fstype string, options []string) error {
+			*ran = append(*ran, strings.Join(append([]string{"mount", "-t", fstype, source, target}, options...), " "))
+			return nil
+		},
+	}
+}
+
+func TestNormalizeFsType(t *testing.T) {
+	for fsType, normalized := range map[string]string{"": "ext4", "ext4": "ext4", " XFS ": "xfs", "btrfs": "btrfs", "ext3": "ext3"} {
+		got, err := NormalizeFsType(fsType)
+		assert.NoError(t, err, fsType)
+		assert.Equal(t, normalized, got, fsType)
+	}
+	_, err := NormalizeFsType("ntfs")
+	assert.Error(t, err)
+}
+
+func TestFormatAndMountBlank(t *testing.T) {
+	tests := []struct {
+		fsType      string
+		mkfsOptions []string
+		mkfs        string
+	}{
+		{"", nil, "mkfs.ext4 -F -m0 " + formatDevicePath},
+		{"ext4", []string{"-m", "1", "-E", "lazy_itable_init=1"}, "mkfs.ext4 -F -m0 -m 1 -E lazy_itable_init=1 " + formatDevicePath},
+		{"xfs", []string{"-i", "size=512"}, "mkfs.xfs -i size=512 " + formatDevicePath},
+		{"btrfs", nil, "mkfs.btrfs " + formatDevicePath},
+	}
+	for _, test := range tests {
+		var ran []string
+		f := fakeFormatter("", &ran)
+		assert.NoError(t, f.formatAndMount(formatDevicePath, formatMountPath, test.fsType, test.mkfsOptions, []string{"noatime"}))
+		fsType, _ := NormalizeFsType(test.fsType)
+		assert.Equal(t, []string{
+			"blkid -p -s TYPE -s PTTYPE -o export " + formatDevicePath,
+			test.mkfs,
+			"mount -t " + fsType + " " + formatDevicePath + " " + formatMountPath + " noatime",
+		}, ran, test.fsType)
+	}
+}
+
+func TestFormatAndMountExisting(t *testing.T) {
+	var ran []string
+	f := fakeFormatter("DEVNAME=/dev/vdd\nTYPE=ext4\n", &ran)
+	assert.NoError(t, f.formatAndMount(formatDevicePath, formatMountPath, "ext4", []string{"-m", "1"}, nil))
+	assert.Equal(t, []string{
+		"blkid -p -s TYPE -s PTTYPE -o export " + formatDevicePath,
+		"fsck -a " + formatDevicePath,
+		"mount -t ext4 " + formatDevicePath + " " + formatMountPath,
+	}, ran, "an existing filesystem is mounted without formatting it")
+
+	ran = nil
+	f = fakeFormatter("DEVNAME=/dev/vdd\nTYPE=xfs\n", &ran)
+	err := f.formatAndMount(formatDevicePath, formatMountPath, "ext4", nil, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "already has a xfs filesystem, not the requested ext4")
+
+	ran = nil
+	f = fakeFormatter("DEVNAME=/dev/vdd\nPTTYPE=gpt\n", &ran)
+	assert.Error(t, f.formatAndMount(formatDevicePath, formatMountPath, "ext4", nil, nil))
+	assert.Len(t, ran, 1, "a partitioned device is neither formatted nor mounted")
+
+	ran = nil
+	f = fakeFormatter("", &ran)
+	assert.Error(t, f.formatAndMount(formatDevicePath, formatMountPath, "ntfs", nil, nil))
+	assert.Empty(t, ran)
+}
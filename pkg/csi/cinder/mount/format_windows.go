@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// DefaultFsType is the filesystem of the volumes requested without one,
+// the only one supported on Windows.
+const DefaultFsType = WindowsFsType
+
+// NormalizeFsType returns the filesystem type fsType stands for, DefaultFsType
+// when it is empty, and fails for other filesystems than NTFS.
+func NormalizeFsType(fsType string) (string, error) {
+	fsType = strings.ToLower(strings.TrimSpace(fsType))
+	if fsType == "" || fsType == WindowsFsType {
+		return DefaultFsType, nil
+	}
+	return "", fmt.Errorf("unsupported filesystem type %q, must be ntfs on Windows", fsType)
+}
+
+// FormatAndMount brings the disk with the serial source online, formats it
+// with a single NTFS partition unless it already has a partition table, and
+// links target to its NTFS volume. A disk with partitions but no NTFS
+// volume is not mounted. Neither mkfs options nor mount flags are supported.
+func (m *Mount) FormatAndMount(source string, target string, fstype string, mkfsOptions []string, options []string) error {
+	if _, err := NormalizeFsType(fstype); err != nil {
+		return err
+	}
+	if len(mkfsOptions) > 0 {
+		return fmt.Errorf("mkfs options %v are not supported on Windows", mkfsOptions)
+	}
+	if len(options) > 0 {
+		return fmt.Errorf("mount flags %v are not supported on Windows", options)
+	}
+
+	disk, err := findDisk(source)
+	if err != nil {
+		return err
+	}
+	if disk == "" {
+		return fmt.Errorf("disk %s not found", source)
+	}
+
+	klog.V(4).Infof("Formatting disk %s (%s) unless formatted", disk, source)
+	volume, err := powershell(`$disk = Get-Disk -Number $Env:disk
+if ($disk.IsOffline) { Set-Disk -Number $Env:disk -IsOffline $false }
+if ($disk.IsReadOnly) { Set-Disk -Number $Env:disk -IsReadOnly $false }
+if ($disk.PartitionStyle -eq 'RAW') {
+	Initialize-Disk -Number $Env:disk -PartitionStyle GPT -PassThru | New-Partition -UseMaximumSize | Format-Volume -FileSystem NTFS -Confirm:$false | Out-Null
+}
+Get-Partition -DiskNumber $Env:disk | Get-Volume | Where-Object FileSystem -eq 'NTFS' | Select-Object -First 1 -ExpandProperty Path`,
+		"disk="+disk)
+	if err != nil {
+		return fmt.Errorf("failed to format disk %s: %v", disk, err)
+	}
+	if volume == "" {
+		return fmt.Errorf("disk %s has partitions but no NTFS volume, it will not be formatted", disk)
+	}
+	return link(volume, target)
+}
@@ -0,0 +1,183 @@
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+var (
+	extBlockCountRe = regexp.MustCompile(`(?m)^Block count:\s+(\d+)`)
+	extBlockSizeRe  = regexp.MustCompile(`(?m)^Block size:\s+(\d+)`)
+	xfsDataRe       = regexp.MustCompile(`(?m)^data\s+=.*\sbsize=(\d+)\s+blocks=(\d+)`)
+	btrfsSizeRe     = regexp.MustCompile(`(?m)^\s*Device size:\s+(\d+)`)
+)
+
+// fsGrower grows the filesystem of a device that is larger than it, e.g.
+// when NodeExpandVolume was missed after the volume was extended.
+type fsGrower struct {
+	exec mount.Exec
+}
+
+// GrowFilesystemIfNeeded grows the filesystem on devicePath, mounted on
+// mountPath, when the device is larger than it by more than threshold bytes.
+// It returns whether the filesystem was grown. Filesystems other than ext,
+// xfs and btrfs are left alone.
+func (m *Mount) GrowFilesystemIfNeeded(devicePath, mountPath string, threshold int64) (bool, error) {
+	g := &fsGrower{exec: mount.NewOsExec()}
+	return g.growIfNeeded(devicePath, mountPath, threshold)
+}
+
+func (g *fsGrower) growIfNeeded(devicePath, mountPath string, threshold int64) (bool, error) {
+	fsType, err := g.fsType(devicePath)
+	if err != nil {
+		return false, err
+	}
+
+	var fsSize int64
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		fsSize, err = g.extSize(devicePath)
+	case "xfs":
+		fsSize, err = g.xfsSize(mountPath)
+	case "btrfs":
+		fsSize, err = g.btrfsSize(mountPath)
+	default:
+		klog.V(4).Infof("Not checking the size of the %q filesystem on %s", fsType, devicePath)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	deviceSize, err := g.deviceSize(devicePath)
+	if err != nil {
+		return false, err
+	}
+	if deviceSize-fsSize <= threshold {
+		klog.V(5).Infof("Filesystem on %s is %d bytes, the device %d bytes, not growing it", devicePath, fsSize, deviceSize)
+		return false, nil
+	}
+
+	klog.Infof("Device %s is %d bytes but its %s filesystem only %d bytes, growing it", devicePath, deviceSize, fsType, fsSize)
+	switch fsType {
+	case "xfs":
+		err = g.run("xfs_growfs", "-d", mountPath)
+	case "btrfs":
+		err = g.run("btrfs", "filesystem", "resize", "max", mountPath)
+	default:
+		err = g.run("resize2fs", devicePath)
+	}
+	if err != nil {
+		return false, err
+	}
+	klog.Infof("Grew the %s filesystem on %s", fsType, devicePath)
+	return true, nil
+}
+
+// fsType returns the filesystem type of a device, as reported by blkid.
+func (g *fsGrower) fsType(devicePath string) (string, error) {
+	out, err := g.exec.Run("blkid", "-p", "-s", "TYPE", "-o", "value", devicePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the filesystem type of %s: %v: %s", devicePath, err, out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// deviceSize returns the size of a block device in bytes.
+func (g *fsGrower) deviceSize(devicePath string) (int64, error) {
+	out, err := g.exec.Run("blockdev", "--getsize64", devicePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the size of %s: %v: %s", devicePath, err, out)
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse the size of %s: %v", devicePath, err)
+	}
+	return size, nil
+}
+
+// extSize returns the size of the ext filesystem on a device in bytes, read
+// from its superblock.
+func (g *fsGrower) extSize(devicePath string) (int64, error) {
+	out, err := g.exec.Run("dumpe2fs", "-h", devicePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the superblock of %s: %v: %s", devicePath, err, out)
+	}
+	count := extBlockCountRe.FindSubmatch(out)
+	size := extBlockSizeRe.FindSubmatch(out)
+	if count == nil || size == nil {
+		return 0, fmt.Errorf("no block count or block size in the superblock of %s", devicePath)
+	}
+	return blocksSize(string(count[1]), string(size[1]))
+}
+
+// xfsSize returns the size of the data section of the xfs filesystem mounted
+// on mountPath in bytes.
+func (g *fsGrower) xfsSize(mountPath string) (int64, error) {
+	out, err := g.exec.Run("xfs_info", mountPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the xfs geometry of %s: %v: %s", mountPath, err, out)
+	}
+	data := xfsDataRe.FindSubmatch(out)
+	if data == nil {
+		return 0, fmt.Errorf("no data section in the xfs geometry of %s", mountPath)
+	}
+	return blocksSize(string(data[2]), string(data[1]))
+}
+
+// btrfsSize returns the size of the btrfs filesystem mounted on mountPath in
+// bytes, on its single device.
+func (g *fsGrower) btrfsSize(mountPath string) (int64, error) {
+	out, err := g.exec.Run("btrfs", "filesystem", "usage", "-b", mountPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the btrfs usage of %s: %v: %s", mountPath, err, out)
+	}
+	size := btrfsSizeRe.FindSubmatch(out)
+	if size == nil {
+		return 0, fmt.Errorf("no device size in the btrfs usage of %s", mountPath)
+	}
+	return strconv.ParseInt(string(size[1]), 10, 64)
+}
+
+func (g *fsGrower) run(cmd string, args ...string) error {
+	out, err := g.exec.Run(cmd, args...)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v: %s", cmd, strings.Join(args, " "), err, out)
+	}
+	return nil
+}
+
+func blocksSize(count, size string) (int64, error) {
+	c, err := strconv.ParseInt(count, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	s, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return c * s, nil
+}
@@ -0,0 +1,203 @@
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+const (
+	growDevicePath = "/dev/vdb"
+	growMountPath  = "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-1/globalmount"
+
+	gib           = int64(1 << 30)
+	growThreshold = int64(64 << 20)
+)
+
+func dumpe2fsOutput(blocks int64) string {
+	return fmt.Sprintf(`Filesystem volume name:   <none>
+Filesystem UUID:          4b5c1d4e-3b0e-4a51-8c7a-8c3e2e0d9a11
+Block count:              %d
+Reserved block count:     13107
+Free blocks:              249189
+Block size:               4096
+Fragment size:            4096
+`, blocks)
+}
+
+func xfsInfoOutput(blocks int64) string {
+	return fmt.Sprintf(`meta-data=/dev/vdb               isize=512    agcount=4, agsize=65536 blks
+         =                       sectsz=512   attr=2, projid32bit=1
+         =                       crc=1        finobt=1, sparse=1, rmapbt=0
+data     =                       bsize=4096   blocks=%d, imaxpct=25
+         =                       sunit=0      swidth=0 blks
+naming   =version 2              bsize=4096   ascii-ci=0, ftype=1
+log      =internal log           bsize=4096   blocks=2560, version=2
+realtime =none                   extsz=4096   blocks=0, rtextents=0
+`, blocks)
+}
+
+func btrfsUsageOutput(size int64) string {
+	return fmt.Sprintf(`Overall:
+    Device size:		  %d
+    Device allocated:		   8388608
+    Device unallocated:		 %d
+    Used:			     131072
+`, size, size-8388608)
+}
+
+// fakeExec runs the commands of fsGrower through a function.
+type fakeExec func(cmd string, args []string) ([]byte, error)
+
+func (f fakeExec) Run(cmd string, args ...string) ([]byte, error) {
+	return f(cmd, args)
+}
+
+// fakeGrowExec returns the outputs of the commands run by fsGrower for a
+// device of deviceSize bytes, recording the commands run.
+func fakeGrowExec(fsType string, deviceSize, fsBlocks int64, ran *[]string) mount.Exec {
+	return fakeExec(func(cmd string, args []string) ([]byte, error) {
+		*ran = append(*ran, strings.Join(append([]string{cmd}, args...), " "))
+		switch cmd {
+		case "blkid":
+			return []byte(fsType + "\n"), nil
+		case "blockdev":
+			return []byte(fmt.Sprintf("%d\n", deviceSize)), nil
+		case "dumpe2fs":
+			return []byte(dumpe2fsOutput(fsBlocks)), nil
+		case "xfs_info":
+			return []byte(xfsInfoOutput(fsBlocks)), nil
+		case "btrfs":
+			if args[1] == "usage" {
+				return []byte(btrfsUsageOutput(fsBlocks * 4096)), nil
+			}
+			return nil, nil
+		case "resize2fs", "xfs_growfs":
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unexpected command %s", cmd)
+	})
+}
+
+func TestGrowIfNeeded(t *testing.T) {
+	tests := []struct {
+		name       string
+		fsType     string
+		deviceSize int64
+		fsBlocks   int64
+		grown      bool
+		grow       string
+	}{
+		{
+			name:       "ext4 smaller than the device",
+			fsType:     "ext4",
+			deviceSize: 2 * gib,
+			fsBlocks:   gib / 4096,
+			grown:      true,
+			grow:       "resize2fs " + growDevicePath,
+		},
+		{
+			name:       "ext4 the size of the device",
+			fsType:     "ext4",
+			deviceSize: gib,
+			fsBlocks:   gib / 4096,
+		},
+		{
+			name:       "ext4 within the threshold",
+			fsType:     "ext4",
+			deviceSize: gib + growThreshold,
+			fsBlocks:   gib / 4096,
+		},
+		{
+			name:       "xfs smaller than the device",
+			fsType:     "xfs",
+			deviceSize: 2 * gib,
+			fsBlocks:   gib / 4096,
+			grown:      true,
+			grow:       "xfs_growfs -d " + growMountPath,
+		},
+		{
+			name:       "xfs the size of the device",
+			fsType:     "xfs",
+			deviceSize: gib,
+			fsBlocks:   gib / 4096,
+		},
+		{
+			name:       "btrfs smaller than the device",
+			fsType:     "btrfs",
+			deviceSize: 2 * gib,
+			fsBlocks:   gib / 4096,
+			grown:      true,
+			grow:       "btrfs filesystem resize max " + growMountPath,
+		},
+		{
+			name:       "btrfs the size of the device",
+			fsType:     "btrfs",
+			deviceSize: gib,
+			fsBlocks:   gib / 4096,
+		},
+		{
+			name:       "other filesystems are left alone",
+			fsType:     "vfat",
+			deviceSize: 2 * gib,
+		},
+	}
+
+	for _, test := range tests {
+		var ran []string
+		g := &fsGrower{exec: fakeGrowExec(test.fsType, test.deviceSize, test.fsBlocks, &ran)}
+
+		grown, err := g.growIfNeeded(growDevicePath, growMountPath, growThreshold)
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.grown, grown, test.name)
+
+		last := ran[len(ran)-1]
+		if test.grow != "" {
+			assert.Equal(t, test.grow, last, test.name)
+		} else {
+			assert.NotContains(t, []string{"resize2fs", "xfs_growfs"}, strings.Fields(last)[0], test.name)
+			assert.NotContains(t, last, "resize", test.name)
+		}
+	}
+}
+
+func TestGrowIfNeededFailure(t *testing.T) {
+	exec := fakeExec(func(cmd string, args []string) ([]byte, error) {
+		switch cmd {
+		case "blkid":
+			return []byte("ext4\n"), nil
+		case "blockdev":
+			return []byte(fmt.Sprintf("%d\n", 2*gib)), nil
+		case "dumpe2fs":
+			return []byte(dumpe2fsOutput(gib / 4096)), nil
+		}
+		return []byte("resize2fs: Permission denied"), fmt.Errorf("exit status 1")
+	})
+	g := &fsGrower{exec: exec}
+
+	grown, err := g.growIfNeeded(growDevicePath, growMountPath, growThreshold)
+	assert.False(t, grown)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Permission denied")
+}
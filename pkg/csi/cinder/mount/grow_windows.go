@@ -0,0 +1,46 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// GrowFilesystemIfNeeded extends the partition, and its NTFS volume, that
+// mountPath links to over the whole disk, when the disk is larger by more
+// than threshold bytes. It returns whether it was extended.
+func (m *Mount) GrowFilesystemIfNeeded(devicePath, mountPath string, threshold int64) (bool, error) {
+	volume, err := resolveLink(mountPath)
+	if err != nil {
+		return false, err
+	}
+	if volume == "" {
+		return false, fmt.Errorf("no volume is mounted on %s", mountPath)
+	}
+	out, err := powershell(`Update-HostStorageCache
+$partition = Get-Volume -Path $Env:volume | Get-Partition
+$size = ($partition | Get-PartitionSupportedSize).SizeMax
+if ($size - $partition.Size -gt [int64]$Env:threshold) {
+	$partition | Resize-Partition -Size $size
+	'resized'
+}`, "volume="+volume, "threshold="+strconv.FormatInt(threshold, 10))
+	if err != nil {
+		return false, fmt.Errorf("failed to extend the volume on %s: %v", mountPath, err)
+	}
+	return out == "resized", nil
+}
@@ -0,0 +1,152 @@
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/util/mount"
+	utilexec "k8s.io/utils/exec"
+)
+
+// mapperDir holds the devices of the opened LUKS volumes, replaced in the
+// tests
+var mapperDir = "/dev/mapper"
+
+// EncryptedDevicePath returns the device of the LUKS volume opened as name.
+func EncryptedDevicePath(name string) string {
+	return filepath.Join(mapperDir, name)
+}
+
+// luksSetup sets up the LUKS encryption of devices with cryptsetup.
+type luksSetup struct {
+	exec mount.Exec
+	// runWithKey runs cryptsetup with the key on its standard input, for the
+	// key never to be written to a file nor passed as an argument
+	runWithKey func(key []byte, args ...string) ([]byte, error)
+}
+
+func newLuksSetup() *luksSetup {
+	return &luksSetup{
+		exec: mount.NewOsExec(),
+		runWithKey: func(key []byte, args ...string) ([]byte, error) {
+			cmd := utilexec.New().Command("cryptsetup", args...)
+			cmd.SetStdin(bytes.NewReader(key))
+			return cmd.CombinedOutput()
+		},
+	}
+}
+
+// OpenEncrypted opens the LUKS volume on devicePath as name with key, and
+// returns its device. A device without any filesystem nor partition table is
+// formatted as a LUKS volume first, any other device is refused.
+func (m *Mount) OpenEncrypted(devicePath, name string, key []byte) (string, error) {
+	return newLuksSetup().open(devicePath, name, key)
+}
+
+// CloseEncrypted closes the LUKS volume opened as name, if it is open.
+func (m *Mount) CloseEncrypted(name string) error {
+	return newLuksSetup().close(name)
+}
+
+// ResizeEncrypted grows the LUKS volume opened as name to the size of its
+// device, after the volume was extended.
+func (m *Mount) ResizeEncrypted(name string) error {
+	return newLuksSetup().resize(name)
+}
+
+func (l *luksSetup) open(devicePath, name string, key []byte) (string, error) {
+	mapped := EncryptedDevicePath(name)
+	if _, err := os.Stat(mapped); err == nil {
+		klog.V(4).Infof("LUKS volume on %s is already open as %s", devicePath, mapped)
+		return mapped, nil
+	}
+
+	isLuks, err := l.isLuks(devicePath)
+	if err != nil {
+		return "", err
+	}
+	if !isLuks {
+		if err := l.format(devicePath, key); err != nil {
+			return "", err
+		}
+	}
+
+	if out, err := l.runWithKey(key, "luksOpen", "--key-file", "-", devicePath, name); err != nil {
+		return "", fmt.Errorf("failed to open the LUKS volume on %s: %v: %s", devicePath, err, out)
+	}
+	klog.V(4).Infof("Opened the LUKS volume on %s as %s", devicePath, mapped)
+	return mapped, nil
+}
+
+// isLuks returns whether devicePath holds a LUKS volume.
+func (l *luksSetup) isLuks(devicePath string) (bool, error) {
+	out, err := l.exec.Run("cryptsetup", "isLuks", devicePath)
+	if err == nil {
+		return true, nil
+	}
+	// cryptsetup isLuks fails with exit status 1 on any other device
+	if exit, ok := err.(utilexec.ExitError); ok && exit.ExitStatus() == 1 {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check for a LUKS volume on %s: %v: %s", devicePath, err, out)
+}
+
+// format formats the blank devicePath as a LUKS volume. LUKS1 volumes can be
+// resized without their key, which NodeExpandVolume does not have.
+func (l *luksSetup) format(devicePath string, key []byte) error {
+	out, err := l.exec.Run("blkid", "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", devicePath)
+	if err == nil {
+		return fmt.Errorf("refusing to encrypt %s, it is not blank: %s", devicePath, strings.TrimSpace(string(out)))
+	}
+	// blkid fails with exit status 2 when it finds nothing on the device
+	if exit, ok := err.(utilexec.ExitError); !ok || exit.ExitStatus() != 2 {
+		return fmt.Errorf("failed to probe %s: %v: %s", devicePath, err, out)
+	}
+
+	klog.Infof("Formatting %s as a LUKS volume", devicePath)
+	if out, err := l.runWithKey(key, "luksFormat", "--batch-mode", "--type", "luks1", "--key-file", "-", devicePath); err != nil {
+		return fmt.Errorf("failed to format %s as a LUKS volume: %v: %s", devicePath, err, out)
+	}
+	return nil
+}
+
+func (l *luksSetup) close(name string) error {
+	if _, err := os.Stat(EncryptedDevicePath(name)); os.IsNotExist(err) {
+		klog.V(4).Infof("LUKS volume %s is not open", name)
+		return nil
+	}
+	if out, err := l.exec.Run("cryptsetup", "luksClose", name); err != nil {
+		return fmt.Errorf("failed to close LUKS volume %s: %v: %s", name, err, out)
+	}
+	klog.V(4).Infof("Closed LUKS volume %s", name)
+	return nil
+}
+
+func (l *luksSetup) resize(name string) error {
+	if out, err := l.exec.Run("cryptsetup", "resize", name); err != nil {
+		return fmt.Errorf("failed to resize LUKS volume %s: %v: %s", name, err, out)
+	}
+	return nil
+}
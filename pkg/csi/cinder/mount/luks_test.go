@@ -0,0 +1,145 @@
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	luksDevicePath = "/dev/vdc"
+	luksName       = "luks-vol-1"
+)
+
+// fakeLuks returns a luksSetup whose cryptsetup isLuks and blkid exit with
+// the given statuses, 0 for success, recording the commands run and the keys
+// passed.
+func fakeLuks(isLuksStatus, blkidStatus int, ran *[]string, keys *[]string) *luksSetup {
+	exitWith := func(status int, out string) ([]byte, error) {
+		if status == 0 {
+			return []byte(out), nil
+		}
+		return nil, utilexec.CodeExitError{Err: errors.New("exit"), Code: status}
+	}
+	return &luksSetup{
+		exec: fakeExec(func(cmd string, args []string) ([]byte, error) {
+			*ran = append(*ran, strings.Join(append([]string{cmd}, args...), " "))
+			switch {
+			case cmd == "cryptsetup" && args[0] == "isLuks":
+				return exitWith(isLuksStatus, "")
+			case cmd == "blkid":
+				return exitWith(blkidStatus, "DEVNAME=/dev/vdc\nTYPE=ext4\n")
+			}
+			return nil, nil
+		}),
+		runWithKey: func(key []byte, args ...string) ([]byte, error) {
+			*ran = append(*ran, strings.Join(append([]string{"cryptsetup"}, args...), " "))
+			*keys = append(*keys, string(key))
+			return nil, nil
+		},
+	}
+}
+
+func fakeMapperDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "mapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := mapperDir
+	mapperDir = dir
+	return func() {
+		mapperDir = old
+		os.RemoveAll(dir)
+	}
+}
+
+func TestOpenEncryptedBlank(t *testing.T) {
+	defer fakeMapperDir(t)()
+	var ran, keys []string
+	l := fakeLuks(1, 2, &ran, &keys)
+
+	mapped, err := l.open(luksDevicePath, luksName, []byte("secret"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(mapperDir, luksName), mapped)
+	assert.Equal(t, []string{
+		"cryptsetup isLuks " + luksDevicePath,
+		"blkid -p -s TYPE -s PTTYPE -o export " + luksDevicePath,
+		"cryptsetup luksFormat --batch-mode --type luks1 --key-file - " + luksDevicePath,
+		"cryptsetup luksOpen --key-file - " + luksDevicePath + " " + luksName,
+	}, ran)
+	assert.Equal(t, []string{"secret", "secret"}, keys)
+}
+
+func TestOpenEncryptedExisting(t *testing.T) {
+	defer fakeMapperDir(t)()
+	var ran, keys []string
+	l := fakeLuks(0, 0, &ran, &keys)
+
+	_, err := l.open(luksDevicePath, luksName, []byte("secret"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"cryptsetup isLuks " + luksDevicePath,
+		"cryptsetup luksOpen --key-file - " + luksDevicePath + " " + luksName,
+	}, ran)
+
+	// Already open
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(mapperDir, luksName), nil, 0644))
+	ran = nil
+	_, err = l.open(luksDevicePath, luksName, []byte("secret"))
+	assert.NoError(t, err)
+	assert.Empty(t, ran)
+}
+
+func TestOpenEncryptedRefusesData(t *testing.T) {
+	defer fakeMapperDir(t)()
+	var ran, keys []string
+	l := fakeLuks(1, 0, &ran, &keys)
+
+	_, err := l.open(luksDevicePath, luksName, []byte("secret"))
+	assert.Error(t, err, "an unencrypted filesystem must not be formatted")
+	assert.Empty(t, keys)
+
+	// blkid failing for another reason than a blank device
+	ran, keys = nil, nil
+	l = fakeLuks(1, 4, &ran, &keys)
+	_, err = l.open(luksDevicePath, luksName, []byte("secret"))
+	assert.Error(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestCloseEncrypted(t *testing.T) {
+	defer fakeMapperDir(t)()
+	var ran, keys []string
+	l := fakeLuks(0, 0, &ran, &keys)
+
+	assert.NoError(t, l.close(luksName))
+	assert.Empty(t, ran, "a volume that is not open is not closed")
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(mapperDir, luksName), nil, 0644))
+	assert.NoError(t, l.close(luksName))
+	assert.Equal(t, []string{"cryptsetup luksClose " + luksName}, ran)
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import "errors"
+
+var errEncryptionUnsupported = errors.New("LUKS encryption is not supported on Windows")
+
+// EncryptedDevicePath returns no device, volumes are never opened with LUKS
+// on Windows.
+func EncryptedDevicePath(name string) string {
+	return ""
+}
+
+// OpenEncrypted is not supported on Windows.
+func (m *Mount) OpenEncrypted(devicePath, name string, key []byte) (string, error) {
+	return "", errEncryptionUnsupported
+}
+
+// CloseEncrypted does nothing, as volumes are never opened with LUKS.
+func (m *Mount) CloseEncrypted(name string) error {
+	return nil
+}
+
+// ResizeEncrypted is not supported on Windows.
+func (m *Mount) ResizeEncrypted(name string) error {
+	return errEncryptionUnsupported
+}
@@ -20,11 +20,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"k8s.io/kubernetes/pkg/util/mount"
-	utilexec "k8s.io/utils/exec"
 
 	"k8s.io/klog"
 )
@@ -33,16 +33,28 @@ const (
 	probeVolumeDuration = 1 * time.Second
 	probeVolumeTimeout  = 60 * time.Second
 	instanceIDFile      = "/var/lib/cloud/data/instance-id"
+
+	// WindowsFsType is the filesystem of the volumes of Windows nodes
+	WindowsFsType = "ntfs"
 )
 
 type IMount interface {
 	ScanForAttach(devicePath string) error
 	IsLikelyNotMountPointAttach(targetpath string) (bool, error)
-	FormatAndMount(source string, target string, fstype string, options []string) error
+	FormatAndMount(source string, target string, fstype string, mkfsOptions []string, options []string) error
 	IsLikelyNotMountPointDetach(targetpath string) (bool, error)
 	Mount(source string, target string, fstype string, options []string) error
 	UnmountPath(mountPath string) error
+	MakeFile(pathname string) error
+	GetDeviceName(mountPath string) (string, error)
+	GetMountPoint(path string) (*MountPoint, error)
+	IsSameDevice(path1, path2 string) (bool, error)
+	GetVolumeStats(volumePath string) (*VolumeStats, error)
 	GetInstanceID() (string, error)
+	GrowFilesystemIfNeeded(devicePath, mountPath string, threshold int64) (bool, error)
+	OpenEncrypted(devicePath, name string, key []byte) (string, error)
+	CloseEncrypted(name string) error
+	ResizeEncrypted(name string) error
 }
 
 type Mount struct {
@@ -58,79 +70,6 @@ func GetMountProvider() (IMount, error) {
 	return MInstance, nil
 }
 
-// probeVolume probes volume in compute
-func probeVolume() error {
-	// rescan scsi bus
-	scsi_path := "/sys/class/scsi_host/"
-	if dirs, err := ioutil.ReadDir(scsi_path); err == nil {
-		for _, f := range dirs {
-			name := scsi_path + f.Name() + "/scan"
-			data := []byte("- - -")
-			ioutil.WriteFile(name, data, 0666)
-		}
-	}
-
-	executor := utilexec.New()
-	args := []string{"trigger"}
-	cmd := executor.Command("udevadm", args...)
-	_, err := cmd.CombinedOutput()
-	if err != nil {
-		klog.V(3).Infof("error running udevadm trigger %v\n", err)
-		return err
-	}
-	return nil
-}
-
-// ScanForAttach
-func (m *Mount) ScanForAttach(devicePath string) error {
-	ticker := time.NewTicker(probeVolumeDuration)
-	defer ticker.Stop()
-	timer := time.NewTimer(probeVolumeTimeout)
-	defer timer.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			klog.V(5).Infof("Checking Cinder disk %q is attached.", devicePath)
-			probeVolume()
-
-			exists, err := mount.PathExists(devicePath)
-			if exists && err == nil {
-				return nil
-			} else {
-				klog.V(3).Infof("Could not find attached Cinder disk %s", devicePath)
-			}
-		case <-timer.C:
-			return fmt.Errorf("Could not find attached Cinder disk %s. Timeout waiting for mount paths to be created.", devicePath)
-		}
-	}
-}
-
-// FormatAndMount
-func (m *Mount) FormatAndMount(source string, target string, fstype string, options []string) error {
-	diskMounter := &mount.SafeFormatAndMount{Interface: mount.New(""), Exec: mount.NewOsExec()}
-	return diskMounter.FormatAndMount(source, target, fstype, options)
-}
-
-func (m *Mount) Mount(source string, target string, fstype string, options []string) error {
-	diskMounter := &mount.SafeFormatAndMount{Interface: mount.New(""), Exec: mount.NewOsExec()}
-	return diskMounter.Mount(source, target, fstype, options)
-}
-
-// IsLikelyNotMountPointAttach
-func (m *Mount) IsLikelyNotMountPointAttach(targetpath string) (bool, error) {
-	notMnt, err := mount.New("").IsLikelyNotMountPoint(targetpath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			err = os.MkdirAll(targetpath, 0750)
-			if err == nil {
-				notMnt = true
-			}
-		}
-	}
-	return notMnt, err
-}
-
 // IsLikelyNotMountPointDetach
 func (m *Mount) IsLikelyNotMountPointDetach(targetpath string) (bool, error) {
 	notMnt, err := mount.New("").IsLikelyNotMountPoint(targetpath)
@@ -149,6 +88,19 @@ func (m *Mount) UnmountPath(mountPath string) error {
 	return mount.CleanupMountPoint(mountPath, mount.New(""), false /* extensiveMountPointCheck */)
 }
 
+// MakeFile creates an empty file, and its parent directories, to bind mount
+// a block device on
+func (m *Mount) MakeFile(pathname string) error {
+	if err := os.MkdirAll(filepath.Dir(pathname), 0750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(pathname, os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
 // GetInstanceID from file
 func (m *Mount) GetInstanceID() (string, error) {
 	// Try to find instance ID on the local filesystem (created by cloud-init)
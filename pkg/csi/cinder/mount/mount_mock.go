@@ -24,13 +24,13 @@ type MountMock struct {
 	mock.Mock
 }
 
-// FormatAndMount provides a mock function with given fields: source, target, fstype, options
-func (_m *MountMock) FormatAndMount(source string, target string, fstype string, options []string) error {
-	ret := _m.Called(source, target, fstype, options)
+// FormatAndMount provides a mock function with given fields: source, target, fstype, mkfsOptions, options
+func (_m *MountMock) FormatAndMount(source string, target string, fstype string, mkfsOptions []string, options []string) error {
+	ret := _m.Called(source, target, fstype, mkfsOptions, options)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, string, string, []string) error); ok {
-		r0 = rf(source, target, fstype, options)
+	if rf, ok := ret.Get(0).(func(string, string, string, []string, []string) error); ok {
+		r0 = rf(source, target, fstype, mkfsOptions, options)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -73,6 +73,115 @@ func (_m *MountMock) GetInstanceID() (string, error) {
 	return r0, r1
 }
 
+// GetDeviceName provides a mock function with given fields: mountPath
+func (_m *MountMock) GetDeviceName(mountPath string) (string, error) {
+	ret := _m.Called(mountPath)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(mountPath)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(mountPath)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetMountPoint provides a mock function with given fields: path
+func (_m *MountMock) GetMountPoint(path string) (*MountPoint, error) {
+	ret := _m.Called(path)
+
+	var r0 *MountPoint
+	if rf, ok := ret.Get(0).(func(string) *MountPoint); ok {
+		r0 = rf(path)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*MountPoint)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(path)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IsSameDevice provides a mock function with given fields: path1, path2
+func (_m *MountMock) IsSameDevice(path1 string, path2 string) (bool, error) {
+	ret := _m.Called(path1, path2)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string, string) bool); ok {
+		r0 = rf(path1, path2)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string) error); ok {
+		r1 = rf(path1, path2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetVolumeStats provides a mock function with given fields: volumePath
+func (_m *MountMock) GetVolumeStats(volumePath string) (*VolumeStats, error) {
+	ret := _m.Called(volumePath)
+
+	var r0 *VolumeStats
+	if rf, ok := ret.Get(0).(func(string) *VolumeStats); ok {
+		r0 = rf(volumePath)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*VolumeStats)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(volumePath)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GrowFilesystemIfNeeded provides a mock function with given fields: devicePath, mountPath, threshold
+func (_m *MountMock) GrowFilesystemIfNeeded(devicePath string, mountPath string, threshold int64) (bool, error) {
+	ret := _m.Called(devicePath, mountPath, threshold)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string, string, int64) bool); ok {
+		r0 = rf(devicePath, mountPath, threshold)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, int64) error); ok {
+		r1 = rf(devicePath, mountPath, threshold)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // IsLikelyNotMountPointAttach provides a mock function with given fields: targetpath
 func (_m *MountMock) IsLikelyNotMountPointAttach(targetpath string) (bool, error) {
 	ret := _m.Called(targetpath)
@@ -142,3 +251,66 @@ func (_m *MountMock) UnmountPath(mountPath string) error {
 
 	return r0
 }
+
+// MakeFile provides a mock function with given fields: pathname
+func (_m *MountMock) MakeFile(pathname string) error {
+	ret := _m.Called(pathname)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(pathname)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// OpenEncrypted provides a mock function with given fields: devicePath, name, key
+func (_m *MountMock) OpenEncrypted(devicePath string, name string, key []byte) (string, error) {
+	ret := _m.Called(devicePath, name, key)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, string, []byte) string); ok {
+		r0 = rf(devicePath, name, key)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, []byte) error); ok {
+		r1 = rf(devicePath, name, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CloseEncrypted provides a mock function with given fields: name
+func (_m *MountMock) CloseEncrypted(name string) error {
+	ret := _m.Called(name)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(name)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ResizeEncrypted provides a mock function with given fields: name
+func (_m *MountMock) ResizeEncrypted(name string) error {
+	ret := _m.Called(name)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(name)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
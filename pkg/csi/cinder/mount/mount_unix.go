@@ -0,0 +1,111 @@
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"k8s.io/kubernetes/pkg/util/mount"
+	utilexec "k8s.io/utils/exec"
+
+	"k8s.io/klog"
+)
+
+// VolumeDevicePath returns the path the node finds the volume volumeID,
+// attached by Nova, with: the devicePath of its publish context.
+func VolumeDevicePath(volumeID, devicePath string) string {
+	return devicePath
+}
+
+// probeVolume probes volume in compute
+func probeVolume() error {
+	// rescan scsi bus
+	scsi_path := "/sys/class/scsi_host/"
+	if dirs, err := ioutil.ReadDir(scsi_path); err == nil {
+		for _, f := range dirs {
+			name := scsi_path + f.Name() + "/scan"
+			data := []byte("- - -")
+			ioutil.WriteFile(name, data, 0666)
+		}
+	}
+
+	executor := utilexec.New()
+	args := []string{"trigger"}
+	cmd := executor.Command("udevadm", args...)
+	_, err := cmd.CombinedOutput()
+	if err != nil {
+		klog.V(3).Infof("error running udevadm trigger %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// ScanForAttach
+func (m *Mount) ScanForAttach(devicePath string) error {
+	ticker := time.NewTicker(probeVolumeDuration)
+	defer ticker.Stop()
+	timer := time.NewTimer(probeVolumeTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			klog.V(5).Infof("Checking Cinder disk %q is attached.", devicePath)
+			probeVolume()
+
+			exists, err := mount.PathExists(devicePath)
+			if exists && err == nil {
+				return nil
+			} else {
+				klog.V(3).Infof("Could not find attached Cinder disk %s", devicePath)
+			}
+		case <-timer.C:
+			return fmt.Errorf("Could not find attached Cinder disk %s. Timeout waiting for mount paths to be created.", devicePath)
+		}
+	}
+}
+
+func (m *Mount) Mount(source string, target string, fstype string, options []string) error {
+	diskMounter := &mount.SafeFormatAndMount{Interface: mount.New(""), Exec: mount.NewOsExec()}
+	return diskMounter.Mount(source, target, fstype, options)
+}
+
+// IsLikelyNotMountPointAttach
+func (m *Mount) IsLikelyNotMountPointAttach(targetpath string) (bool, error) {
+	notMnt, err := mount.New("").IsLikelyNotMountPoint(targetpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = os.MkdirAll(targetpath, 0750)
+			if err == nil {
+				notMnt = true
+			}
+		}
+	}
+	return notMnt, err
+}
+
+// GetDeviceName returns the device mounted on mountPath, "" when nothing is
+// mounted on it
+func (m *Mount) GetDeviceName(mountPath string) (string, error) {
+	devicePath, _, err := mount.GetDeviceNameFromMount(mount.New(""), mountPath)
+	return devicePath, err
+}
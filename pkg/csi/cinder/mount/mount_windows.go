@@ -0,0 +1,178 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"k8s.io/klog"
+)
+
+// On Windows the devices are the serial numbers of the disks: the device
+// names Nova reports are those of Linux guests, while the serials are the
+// volume IDs, truncated to 20 characters by virtio. The filesystems are
+// mounted as directory symlinks, to the volume of the disk on
+// NodeStageVolume and to the staging path on NodePublishVolume, the layout
+// csi-proxy makes.
+
+// diskSerialLength is the length of the serial virtio gives the disks
+const diskSerialLength = 20
+
+// maxLinks is the number of symlinks resolveLink follows at most
+const maxLinks = 8
+
+// VolumeDevicePath returns the path the node finds the volume volumeID,
+// attached by Nova, with: the serial of its disk.
+func VolumeDevicePath(volumeID, devicePath string) string {
+	if len(volumeID) > diskSerialLength {
+		return volumeID[:diskSerialLength]
+	}
+	return volumeID
+}
+
+// powershell runs command with PowerShell and returns its output. The values
+// of env are passed in the environment, never in the command, for PowerShell
+// not to interpret them.
+func powershell(command string, env ...string) (string, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", command)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%v, output: %q", err, string(out))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// findDisk returns the number of the disk with serial, "" when none has it.
+func findDisk(serial string) (string, error) {
+	return powershell(`Get-Disk | Where-Object { $_.SerialNumber -and $_.SerialNumber.Trim() -eq $Env:serial } | `+
+		`Select-Object -First 1 -ExpandProperty Number`, "serial="+serial)
+}
+
+// ScanForAttach waits for the disk with the serial devicePath to appear.
+func (m *Mount) ScanForAttach(devicePath string) error {
+	ticker := time.NewTicker(probeVolumeDuration)
+	defer ticker.Stop()
+	timer := time.NewTimer(probeVolumeTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			klog.V(5).Infof("Checking Cinder disk %q is attached.", devicePath)
+			if _, err := powershell("Update-HostStorageCache"); err != nil {
+				klog.V(3).Infof("error updating the storage cache %v", err)
+			}
+
+			disk, err := findDisk(devicePath)
+			if disk != "" && err == nil {
+				return nil
+			}
+			klog.V(3).Infof("Could not find attached Cinder disk %s", devicePath)
+		case <-timer.C:
+			return fmt.Errorf("Could not find attached Cinder disk %s. Timeout waiting for the disk to appear.", devicePath)
+		}
+	}
+}
+
+// Mount links target to the directory source, the staging path of a
+// volume. Read-only mounts and raw block volumes are not supported.
+func (m *Mount) Mount(source string, target string, fstype string, options []string) error {
+	for _, option := range options {
+		if option == "ro" {
+			return errors.New("read-only mounts are not supported on Windows")
+		}
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("raw block volumes are not supported on Windows")
+	}
+	return link(source, target)
+}
+
+// link makes target a directory symlink to source. An empty directory on
+// target, e.g. created by kubelet, is replaced.
+func link(source, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("target %s is not an empty directory: %v", target, err)
+	}
+	klog.V(4).Infof("Linking %s to %s", target, source)
+	if out, err := exec.Command("cmd", "/c", "mklink", "/D", target, source).CombinedOutput(); err != nil {
+		return fmt.Errorf("mklink failed: %v, output: %q", err, string(out))
+	}
+	return nil
+}
+
+// IsLikelyNotMountPointAttach returns whether targetpath is not a symlink
+// to a mounted volume. A missing targetpath is left for the link to create.
+func (m *Mount) IsLikelyNotMountPointAttach(targetpath string) (bool, error) {
+	notMnt, err := mount.New("").IsLikelyNotMountPoint(targetpath)
+	if err != nil && os.IsNotExist(err) {
+		return true, nil
+	}
+	return notMnt, err
+}
+
+// GetDeviceName returns the volume linked to by mountPath, "" when it is
+// not a link
+func (m *Mount) GetDeviceName(mountPath string) (string, error) {
+	return resolveLink(mountPath)
+}
+
+// resolveLink returns what the symlink path finally links to, following the
+// link of a published path to its staging path, "" when path is not a link.
+func resolveLink(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	target := path
+	for i := 0; info.Mode()&os.ModeSymlink != 0; i++ {
+		if i == maxLinks {
+			return "", fmt.Errorf("too many levels of symlinks from %s", path)
+		}
+		if target, err = os.Readlink(target); err != nil {
+			return "", err
+		}
+		// The links end on a volume path, e.g. \\?\Volume{...}\, which
+		// may not be stat
+		if info, err = os.Lstat(target); err != nil {
+			break
+		}
+	}
+	if target == path {
+		return "", nil
+	}
+	return target, nil
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import (
+	"path/filepath"
+
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+// MountPoint is what is mounted on a path. A bind mount has the device of
+// the mount it binds.
+type MountPoint struct {
+	Device   string
+	FsType   string
+	ReadOnly bool
+}
+
+// findMountPoint returns the last of mps mounted on path, the one hiding the
+// others.
+func findMountPoint(mps []mount.MountPoint, path string) *MountPoint {
+	path = filepath.Clean(path)
+	var found *MountPoint
+	for _, mp := range mps {
+		if filepath.Clean(mp.Path) != path {
+			continue
+		}
+		found = &MountPoint{Device: mp.Device, FsType: mp.Type}
+		for _, opt := range mp.Opts {
+			if opt == "ro" {
+				found.ReadOnly = true
+			}
+		}
+	}
+	return found
+}
+
+// IsCorruptedMountPoint returns whether err, from checking a mount point, is
+// from a corrupted mount, e.g. of a device gone away, which has to be
+// unmounted before mounting again.
+func IsCorruptedMountPoint(err error) bool {
+	return mount.IsCorruptedMnt(err)
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+func TestFindMountPoint(t *testing.T) {
+	staging := "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-1/globalmount"
+	target := "/var/lib/kubelet/pods/pod-1/volumes/kubernetes.io~csi/pvc-1/mount"
+	mps := []mount.MountPoint{
+		{Device: "/dev/vda1", Path: "/", Type: "ext4", Opts: []string{"rw", "relatime"}},
+		{Device: "/dev/vdb", Path: staging, Type: "xfs", Opts: []string{"rw"}},
+		{Device: "/dev/vdb", Path: target, Type: "xfs", Opts: []string{"rw"}},
+		// Mounted again over the first one
+		{Device: "/dev/vdb", Path: target, Type: "xfs", Opts: []string{"ro", "relatime"}},
+	}
+
+	assert.Equal(t, &MountPoint{Device: "/dev/vdb", FsType: "xfs"}, findMountPoint(mps, staging+"/"))
+	assert.Equal(t, &MountPoint{Device: "/dev/vdb", FsType: "xfs", ReadOnly: true}, findMountPoint(mps, target))
+	assert.Nil(t, findMountPoint(mps, "/var/lib/kubelet"))
+}
+
+func TestIsSameDevice(t *testing.T) {
+	m := &Mount{}
+	// Only block devices are compared
+	same, err := m.IsSameDevice("/dev/null", "/dev/null")
+	assert.NoError(t, err)
+	assert.False(t, same)
+
+	_, err = m.IsSameDevice("/dev/null", "/dev/missing")
+	assert.Error(t, err)
+}
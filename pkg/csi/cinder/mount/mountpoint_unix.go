@@ -0,0 +1,46 @@
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import (
+	"golang.org/x/sys/unix"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+// GetMountPoint returns what is mounted on path, nil when nothing is.
+func (m *Mount) GetMountPoint(path string) (*MountPoint, error) {
+	mps, err := mount.New("").List()
+	if err != nil {
+		return nil, err
+	}
+	return findMountPoint(mps, path), nil
+}
+
+// IsSameDevice returns whether the device files path1 and path2, e.g. a
+// device and the file it is bind mounted on, are the same device.
+func (m *Mount) IsSameDevice(path1, path2 string) (bool, error) {
+	var st1, st2 unix.Stat_t
+	if err := unix.Stat(path1, &st1); err != nil {
+		return false, err
+	}
+	if err := unix.Stat(path2, &st2); err != nil {
+		return false, err
+	}
+	return st1.Mode&unix.S_IFMT == unix.S_IFBLK && st1.Mode&unix.S_IFMT == st2.Mode&unix.S_IFMT && st1.Rdev == st2.Rdev, nil
+}
@@ -0,0 +1,34 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import "errors"
+
+// GetMountPoint returns the volume path links to, nil when it is not a
+// link. Only NTFS volumes are staged, and the links are never read-only.
+func (m *Mount) GetMountPoint(path string) (*MountPoint, error) {
+	device, err := resolveLink(path)
+	if err != nil || device == "" {
+		return nil, err
+	}
+	return &MountPoint{Device: device, FsType: DefaultFsType}, nil
+}
+
+// IsSameDevice is not supported on Windows, which has no raw block volumes.
+func (m *Mount) IsSameDevice(path1, path2 string) (bool, error) {
+	return false, errors.New("raw block volumes are not supported on Windows")
+}
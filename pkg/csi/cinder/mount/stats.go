@@ -0,0 +1,65 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import (
+	"io"
+	"os"
+)
+
+// VolumeStats is the usage of a published volume. Only TotalBytes is set
+// for raw block volumes.
+type VolumeStats struct {
+	Block bool
+
+	TotalBytes     int64
+	AvailableBytes int64
+	UsedBytes      int64
+
+	TotalInodes int64
+	FreeInodes  int64
+	UsedInodes  int64
+}
+
+// GetVolumeStats returns the usage of the filesystem mounted on volumePath,
+// or the size of the device when volumePath is the file a raw block volume
+// is bind mounted on.
+func (m *Mount) GetVolumeStats(volumePath string) (*VolumeStats, error) {
+	info, err := os.Stat(volumePath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return blockVolumeStats(volumePath)
+	}
+	return filesystemStats(volumePath)
+}
+
+// blockVolumeStats returns the size of the device bind mounted on
+// volumePath, seeking to its end.
+func blockVolumeStats(volumePath string) (*VolumeStats, error) {
+	f, err := os.Open(volumePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	return &VolumeStats{Block: true, TotalBytes: size}, nil
+}
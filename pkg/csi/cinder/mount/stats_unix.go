@@ -0,0 +1,38 @@
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mount
+
+import "golang.org/x/sys/unix"
+
+// filesystemStats returns the usage of the filesystem mounted on volumePath.
+func filesystemStats(volumePath string) (*VolumeStats, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(volumePath, &st); err != nil {
+		return nil, err
+	}
+	bsize := int64(st.Bsize)
+	return &VolumeStats{
+		TotalBytes:     int64(st.Blocks) * bsize,
+		AvailableBytes: int64(st.Bavail) * bsize,
+		UsedBytes:      (int64(st.Blocks) - int64(st.Bfree)) * bsize,
+		TotalInodes:    int64(st.Files),
+		FreeInodes:     int64(st.Ffree),
+		UsedInodes:     int64(st.Files) - int64(st.Ffree),
+	}, nil
+}
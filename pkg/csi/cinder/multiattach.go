@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"strconv"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+)
+
+// multiattachParameter requests a volume which can be attached to several
+// nodes at once, of a multiattach volume type
+const multiattachParameter = "multiattach"
+
+// isMultiNode returns whether volCap lets several nodes use the volume at
+// once.
+func isMultiNode(volCap *csi.VolumeCapability) bool {
+	switch volCap.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return true
+	}
+	return false
+}
+
+// multiattachRequested returns whether CreateVolume must provision a
+// multiattach volume, for the multiattach parameter or a multi-node
+// capability. Multi-node capabilities are only served for block volumes, no
+// filesystem the driver formats can be mounted on several nodes at once.
+func multiattachRequested(req *csi.CreateVolumeRequest) (bool, error) {
+	multiattach := false
+	if value, ok := req.GetParameters()[multiattachParameter]; ok {
+		var err error
+		multiattach, err = strconv.ParseBool(value)
+		if err != nil {
+			return false, status.Errorf(codes.InvalidArgument, "invalid %s parameter %q, must be true or false", multiattachParameter, value)
+		}
+	}
+	for _, volCap := range req.GetVolumeCapabilities() {
+		if !isMultiNode(volCap) {
+			continue
+		}
+		if volCap.GetBlock() == nil {
+			return false, status.Errorf(codes.InvalidArgument, "access mode %v is only supported for block volumes", volCap.GetAccessMode().GetMode())
+		}
+		multiattach = true
+	}
+	return multiattach, nil
+}
+
+// checkMultiattachType verifies that the volumes of volType can be attached
+// to several nodes. Cinder only creates multiattach volumes of the types with
+// the multiattach extra spec.
+func checkMultiattachType(cloud openstack.IOpenStack, volType string) error {
+	if volType == "" {
+		return status.Error(codes.InvalidArgument, "multiattach volumes need a volume type with the multiattach extra spec")
+	}
+	multiattach, err := cloud.VolumeTypeMultiattach(volType)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get the multiattach extra spec of volume type %s: %v", volType, err)
+	}
+	if !multiattach {
+		return status.Errorf(codes.InvalidArgument, "volume type %s is not multiattach, multiattach volumes need a volume type with the multiattach extra spec", volType)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/connector"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/klog"
+)
+
+const (
+	// AttachModeNova attaches the volumes to the node instances with Nova
+	// on ControllerPublishVolume.
+	AttachModeNova = "nova"
+	// AttachModeConnector connects the volumes to the nodes themselves over
+	// iSCSI or Fibre Channel on NodeStageVolume, for the nodes whose volumes
+	// Nova cannot attach, e.g. Ironic bare-metal nodes.
+	AttachModeConnector = "connector"
+
+	// connectionFile holds the connection of a volume connected to the node,
+	// next to its staging path, to disconnect it on NodeUnstageVolume
+	connectionFile = "cinder-connection.json"
+)
+
+var (
+	// The connectors, replaced in the tests
+	newConnector             = connector.New
+	localConnectorProperties = connector.LocalProperties
+)
+
+// nodeConnection is the connection of a volume connected to the node.
+type nodeConnection struct {
+	Protocol   string                 `json:"protocol"`
+	Data       map[string]interface{} `json:"data"`
+	DevicePath string                 `json:"devicePath"`
+}
+
+// SetAttachMode selects how volumes get to the nodes, see AttachModeNova and
+// AttachModeConnector. The controller and node plugins must use the same
+// mode. multipath connects the volumes through all the paths the backend
+// serves with the connector mode.
+func (d *CinderDriver) SetAttachMode(mode string, multipath bool) error {
+	switch mode {
+	case AttachModeNova:
+	case AttachModeConnector:
+		// The nodes connect the volumes themselves, there is nothing to
+		// publish
+		var cscap []*csi.ControllerServiceCapability
+		for _, c := range d.cscap {
+			if c.GetRpc().GetType() != csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME {
+				cscap = append(cscap, c)
+			}
+		}
+		d.cscap = cscap
+	default:
+		return fmt.Errorf("unknown attach mode %q, must be %q or %q", mode, AttachModeNova, AttachModeConnector)
+	}
+	klog.Infof("Using attach mode: %s, multipath: %v", mode, multipath)
+	d.attachMode = mode
+	d.multipath = multipath
+	return nil
+}
+
+func connectionPath(stagingPath string) string {
+	return filepath.Join(filepath.Dir(stagingPath), connectionFile)
+}
+
+// readConnection returns the connection saved for the volume staged at
+// stagingPath, nil when there is none.
+func readConnection(stagingPath string) (*nodeConnection, error) {
+	data, err := ioutil.ReadFile(connectionPath(stagingPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var c nodeConnection
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid connection file %s: %v", connectionPath(stagingPath), err)
+	}
+	return &c, nil
+}
+
+// connectorProperties returns the properties of the node for Cinder.
+func (ns *nodeServer) connectorProperties() (openstack.ConnectorProperties, error) {
+	p, err := localConnectorProperties(ns.Driver.multipath)
+	if err != nil {
+		return openstack.ConnectorProperties{}, err
+	}
+	return openstack.ConnectorProperties{
+		Host:      p.Host,
+		IP:        p.IP,
+		Initiator: p.Initiator,
+		Wwpns:     p.Wwpns,
+		Wwnns:     p.Wwnns,
+		Multipath: p.Multipath,
+	}, nil
+}
+
+// connectVolume connects the volume volumeID staged at stagingPath to the
+// node, unless it already is, and returns its device.
+func (ns *nodeServer) connectVolume(volumeID, stagingPath string) (string, error) {
+	saved, err := readConnection(stagingPath)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "Failed to read the connection of volume %s: %v", volumeID, err)
+	}
+	if saved != nil {
+		if _, err := os.Stat(saved.DevicePath); err == nil {
+			klog.V(4).Infof("Volume %s is already connected as %s", volumeID, saved.DevicePath)
+			return saved.DevicePath, nil
+		}
+	}
+
+	cloud, err := openstack.GetOpenStackProvider()
+	if err != nil {
+		klog.V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	props, err := ns.connectorProperties()
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "Failed to get the connector properties of the node: %v", err)
+	}
+	info, err := cloud.InitializeConnection(volumeID, props)
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	c, err := newConnector(info.DriverVolumeType, ns.Driver.multipath)
+	if err != nil {
+		cloud.TerminateConnection(volumeID, props)
+		return "", status.Errorf(codes.InvalidArgument, "Volume %s cannot be connected: %v", volumeID, err)
+	}
+	devicePath, err := c.Connect(info.Data)
+	if err != nil {
+		cloud.TerminateConnection(volumeID, props)
+		return "", status.Errorf(codes.Internal, "Failed to connect volume %s: %v", volumeID, err)
+	}
+
+	// The connection data may hold the CHAP credentials of the target
+	data, err := json.Marshal(nodeConnection{Protocol: info.DriverVolumeType, Data: info.Data, DevicePath: devicePath})
+	if err == nil {
+		err = ioutil.WriteFile(connectionPath(stagingPath), data, 0600)
+	}
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "Failed to save the connection of volume %s: %v", volumeID, err)
+	}
+	klog.V(4).Infof("Connected volume %s over %s as %s", volumeID, info.DriverVolumeType, devicePath)
+	return devicePath, nil
+}
+
+// disconnectVolume disconnects the volume volumeID staged at stagingPath
+// from the node, if it is connected.
+func (ns *nodeServer) disconnectVolume(volumeID, stagingPath string) error {
+	saved, err := readConnection(stagingPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to read the connection of volume %s: %v", volumeID, err)
+	}
+	if saved == nil {
+		klog.V(4).Infof("Volume %s is not connected", volumeID)
+		return nil
+	}
+
+	c, err := newConnector(saved.Protocol, ns.Driver.multipath)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if err := c.Disconnect(saved.Data); err != nil {
+		return status.Errorf(codes.Internal, "Failed to disconnect volume %s: %v", volumeID, err)
+	}
+
+	cloud, err := openstack.GetOpenStackProvider()
+	if err != nil {
+		klog.V(3).Infof("Failed to GetOpenStackProvider: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	props, err := ns.connectorProperties()
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to get the connector properties of the node: %v", err)
+	}
+	if err := cloud.TerminateConnection(volumeID, props); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if err := os.Remove(connectionPath(stagingPath)); err != nil && !os.IsNotExist(err) {
+		return status.Errorf(codes.Internal, "Failed to remove the connection of volume %s: %v", volumeID, err)
+	}
+	klog.V(4).Infof("Disconnected volume %s", volumeID)
+	return nil
+}
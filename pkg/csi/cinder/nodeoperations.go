@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// nodeOperations runs the device and mount operations of the node plugin,
+// which cannot be interrupted, apart from the calls waiting for them, so that
+// a call whose context is done returns instead of blocking the CSI worker of
+// kubelet. The operation goes on, and the retry of the call waits for it
+// instead of running it again alongside.
+type nodeOperations struct {
+	mu      sync.Mutex
+	running map[string]*nodeOperation
+}
+
+// nodeOperation is an operation in progress, result and err are set once
+// done is closed
+type nodeOperation struct {
+	done   chan struct{}
+	result string
+	err    error
+}
+
+func newNodeOperations() *nodeOperations {
+	return &nodeOperations{running: map[string]*nodeOperation{}}
+}
+
+// run runs f as the operation key, e.g. of a volume, and waits for it to
+// finish, for at most timeout when not 0, returning its result, e.g. a device
+// path. When an operation key is still in
+// progress, e.g. of the call a retry is of, it is waited for instead of
+// running f. The wait ending first fails with DeadlineExceeded, or Canceled
+// when ctx is cancelled, and leaves the operation running.
+func (o *nodeOperations) run(ctx context.Context, key string, timeout time.Duration, f func() (string, error)) (string, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	o.mu.Lock()
+	op, ok := o.running[key]
+	if !ok {
+		op = &nodeOperation{done: make(chan struct{})}
+		o.running[key] = op
+		go func() {
+			op.result, op.err = f()
+			o.mu.Lock()
+			delete(o.running, key)
+			o.mu.Unlock()
+			close(op.done)
+		}()
+	} else {
+		logFor(ctx).V(3).Infof("Waiting for %s still in progress", key)
+	}
+	o.mu.Unlock()
+
+	select {
+	case <-op.done:
+		return op.result, op.err
+	case <-ctx.Done():
+		if ctx.Err() == context.Canceled {
+			return "", status.Errorf(codes.Canceled, "%s cancelled, it goes on in the background", key)
+		}
+		return "", status.Errorf(codes.DeadlineExceeded, "%s timed out, it goes on in the background and is waited for by the next call", key)
+	}
+}
+
+// isTimeout returns whether err is the failure of nodeOperations.run to wait
+// for an operation.
+func isTimeout(err error) bool {
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Canceled:
+		return true
+	}
+	return false
+}
+
+// statusError returns err as a gRPC status, Internal unless it is one
+// already, e.g. of a timeout.
+func statusError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.Internal, err.Error())
+}
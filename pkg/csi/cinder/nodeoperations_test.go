@@ -0,0 +1,68 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNodeOperations(t *testing.T) {
+	o := newNodeOperations()
+	release := make(chan struct{})
+	runs := 0
+	scan := func() (string, error) {
+		runs++
+		<-release
+		return "/dev/vdb", nil
+	}
+
+	// The call gives up on the hanging scan
+	_, err := o.run(context.Background(), "scan", 10*time.Millisecond, scan)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = o.run(ctx, "scan", 0, scan)
+	assert.Equal(t, codes.Canceled, status.Code(err))
+
+	// The retry waits for the scan in progress instead of running another
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		path, err := o.run(context.Background(), "scan", 0, scan)
+		assert.NoError(t, err)
+		assert.Equal(t, "/dev/vdb", path)
+	}()
+	close(release)
+	<-done
+	assert.Equal(t, 1, runs)
+
+	// Once done, the operation runs again
+	_, err = o.run(context.Background(), "scan", 0, func() (string, error) {
+		return "", errors.New("no device")
+	})
+	assert.EqualError(t, err, "no device")
+	assert.True(t, isTimeout(status.Error(codes.DeadlineExceeded, "")))
+	assert.Equal(t, codes.Internal, status.Code(statusError(err)))
+}
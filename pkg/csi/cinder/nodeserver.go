@@ -17,7 +17,7 @@ limitations under the License.
 package cinder
 
 import (
-	"fmt"
+	"os"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"golang.org/x/net/context"
@@ -31,31 +31,81 @@ import (
 
 type nodeServer struct {
 	Driver *CinderDriver
+	// ops runs the operations that may hang, see nodeOperations
+	ops *nodeOperations
 }
 
 func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	klog.V(4).Infof("NodePublishVolume: called with args %+v", *req)
+	logFor(ctx).V(4).Infof("NodePublishVolume: called with args %+v", *req)
 
 	source := req.GetStagingTargetPath()
 	targetPath := req.GetTargetPath()
 	volumeCapability := req.GetVolumeCapability()
 
-	if len(source) == 0 || len(targetPath) == 0 || volumeCapability == nil {
+	// Ephemeral volumes are not staged
+	ephemeral := isEphemeral(req.GetVolumeContext())
+	if (len(source) == 0 && !ephemeral) || len(targetPath) == 0 || volumeCapability == nil {
 		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume missing required arguments")
 	}
 
 	// Get Mount Provider
 	m, err := mount.GetMountProvider()
 	if err != nil {
-		klog.V(3).Infof("Failed to GetMountProvider: %v", err)
+		logFor(ctx).V(3).Infof("Failed to GetMountProvider: %v", err)
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	// Verify whether mounted
-	notMnt, err := m.IsLikelyNotMountPointAttach(targetPath)
+	if ephemeral {
+		return ns.nodePublishEphemeralVolume(ctx, req, m)
+	}
+
+	if volumeCapability.GetBlock() != nil {
+		return ns.nodePublishBlockVolume(req, m)
+	}
+
+	// The filesystem is mounted once at the staging path by NodeStageVolume
+	// and bind mounted from there for every pod
+	notStaged, err := m.IsLikelyNotMountPointAttach(source)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	if notStaged {
+		return nil, status.Errorf(codes.FailedPrecondition, "Volume %s is not staged at %s", req.GetVolumeId(), source)
+	}
+
+	// Verify whether mounted
+	notMnt, err := targetNotMounted(m, targetPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The requested filesystem must be the staged one, and an existing mount
+	// the one this request would make
+	requestedFsType := volumeCapability.GetMount().GetFsType()
+	if requestedFsType != "" || !notMnt {
+		staged, err := m.GetMountPoint(source)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to get the mount of staging path %s: %v", source, err)
+		}
+		if staged == nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "Volume %s is not staged at %s", req.GetVolumeId(), source)
+		}
+		if requestedFsType != "" {
+			fsType, err := mount.NormalizeFsType(requestedFsType)
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			if fsType != staged.FsType {
+				return nil, status.Errorf(codes.InvalidArgument, "Volume %s is staged with a %s filesystem, not the requested %s", req.GetVolumeId(), staged.FsType, fsType)
+			}
+		}
+		if !notMnt {
+			// A read-only staged filesystem is read-only in every bind mount
+			if err := verifyPublished(m, req, staged.Device, req.GetReadonly() || staged.ReadOnly); err != nil {
+				return nil, err
+			}
+		}
+	}
 
 	// Volume Mount
 	if notMnt {
@@ -67,13 +117,81 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		} else {
 			options = append(options, "rw")
 		}
-		if mnt := volumeCapability.GetMount(); mnt != nil {
-			if mnt.FsType != "" {
-				fsType = mnt.FsType
-			}
+		if requestedFsType != "" {
+			fsType = requestedFsType
 		}
 		// Mount
-		err = m.Mount(source, targetPath, fsType, options)
+		_, err = ns.ops.run(ctx, "mount of "+targetPath, ns.Driver.mountTimeout, func() (string, error) {
+			return "", m.Mount(source, targetPath, fsType, options)
+		})
+		if err != nil {
+			return nil, statusError(err)
+		}
+	}
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// nodePublishBlockVolume bind mounts the device of a raw block volume on the
+// target path, created as a file, without formatting it.
+func (ns *nodeServer) nodePublishBlockVolume(req *csi.NodePublishVolumeRequest, m mount.IMount) (*csi.NodePublishVolumeResponse, error) {
+	targetPath := req.GetTargetPath()
+	devicePath, ok := req.GetPublishContext()["DevicePath"]
+	if ns.Driver.attachMode == AttachModeConnector {
+		// The device was connected on NodeStageVolume
+		c, err := readConnection(req.GetStagingTargetPath())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to read the connection of volume %s: %v", req.GetVolumeId(), err)
+		}
+		if c == nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "Volume %s is not staged at %s", req.GetVolumeId(), req.GetStagingTargetPath())
+		}
+		devicePath, ok = c.DevicePath, true
+	}
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "Device path not provided")
+	}
+	luks, err := luksRequested(req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+	if luks {
+		// The LUKS volume was opened on NodeStageVolume
+		devicePath = mount.EncryptedDevicePath(luksMapperName(req.GetVolumeId()))
+	}
+
+	if err := m.MakeFile(targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to create the target file %s: %v", targetPath, err)
+	}
+
+	// Verify whether mounted
+	notMnt, err := targetNotMounted(m, targetPath, m.MakeFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if !notMnt {
+		same, err := m.IsSameDevice(targetPath, devicePath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to compare the device on %s with %s: %v", targetPath, devicePath, err)
+		}
+		if !same {
+			return nil, status.Errorf(codes.AlreadyExists, "Target path %s of volume %s is already a mount of another device than %s", targetPath, req.GetVolumeId(), devicePath)
+		}
+		if err := verifyPublished(m, req, "", req.GetReadonly()); err != nil {
+			return nil, err
+		}
+	}
+
+	if notMnt {
+		options := []string{"bind"}
+		if req.GetReadonly() {
+			options = append(options, "ro")
+		} else {
+			options = append(options, "rw")
+		}
+		// No filesystem type, the device is bind mounted as is
+		err = m.Mount(devicePath, targetPath, "", options)
 		if err != nil {
 			return nil, status.Error(codes.Internal, err.Error())
 		}
@@ -82,8 +200,53 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+// targetNotMounted returns whether nothing is mounted on targetPath. A
+// corrupted mount, e.g. left by an earlier publish of a device gone away, is
+// unmounted first, and the target recreated with recreate when not nil.
+func targetNotMounted(m mount.IMount, targetPath string, recreate func(string) error) (bool, error) {
+	notMnt, err := m.IsLikelyNotMountPointAttach(targetPath)
+	if err != nil && mount.IsCorruptedMountPoint(err) {
+		klog.Warningf("Unmounting the corrupted mount on %s: %v", targetPath, err)
+		if err := m.UnmountPath(targetPath); err != nil {
+			return false, status.Errorf(codes.Internal, "Failed to unmount the corrupted mount on %s: %v", targetPath, err)
+		}
+		if recreate != nil {
+			if err := recreate(targetPath); err != nil {
+				return false, status.Errorf(codes.Internal, "Failed to create the target %s: %v", targetPath, err)
+			}
+		}
+		notMnt, err = m.IsLikelyNotMountPointAttach(targetPath)
+	}
+	if err != nil {
+		return false, status.Error(codes.Internal, err.Error())
+	}
+	return notMnt, nil
+}
+
+// verifyPublished checks that the mount already on the target path of req is
+// of device, unless empty, and readOnly as requested, for NodePublishVolume
+// to succeed again only when it would make the same mount.
+func verifyPublished(m mount.IMount, req *csi.NodePublishVolumeRequest, device string, readOnly bool) error {
+	targetPath := req.GetTargetPath()
+	published, err := m.GetMountPoint(targetPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to get the mount of target path %s: %v", targetPath, err)
+	}
+	if published == nil {
+		return status.Errorf(codes.Internal, "Target path %s is a mount point missing from the mount table", targetPath)
+	}
+	if device != "" && published.Device != device {
+		return status.Errorf(codes.AlreadyExists, "Target path %s of volume %s is already a mount of %s, not %s", targetPath, req.GetVolumeId(), published.Device, device)
+	}
+	if published.ReadOnly != readOnly {
+		return status.Errorf(codes.AlreadyExists, "Target path %s of volume %s is already mounted with read-only %t", targetPath, req.GetVolumeId(), published.ReadOnly)
+	}
+	klog.V(4).Infof("Volume %s is already published at %s", req.GetVolumeId(), targetPath)
+	return nil
+}
+
 func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
-	klog.V(4).Infof("NodeUnPublishVolume: called with args %+v", *req)
+	logFor(ctx).V(4).Infof("NodeUnPublishVolume: called with args %+v", *req)
 
 	targetPath := req.GetTargetPath()
 	if len(targetPath) == 0 {
@@ -93,10 +256,19 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 	// Get Mount Provider
 	m, err := mount.GetMountProvider()
 	if err != nil {
-		klog.V(3).Infof("Failed to GetMountProvider: %v", err)
+		logFor(ctx).V(3).Infof("Failed to GetMountProvider: %v", err)
 		return nil, err
 	}
 
+	// The Cinder volume of an ephemeral volume goes away with it
+	vol, err := readEphemeral(targetPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to read the ephemeral volume %s: %v", req.GetVolumeId(), err)
+	}
+	if vol != nil {
+		return ns.nodeUnpublishEphemeralVolume(req, vol, m)
+	}
+
 	notMnt, err := m.IsLikelyNotMountPointDetach(targetPath)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
@@ -105,16 +277,18 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, status.Error(codes.NotFound, "Volume not mounted")
 	}
 
-	err = m.UnmountPath(targetPath)
+	_, err = ns.ops.run(ctx, "unmount of "+targetPath, ns.Driver.mountTimeout, func() (string, error) {
+		return "", m.UnmountPath(targetPath)
+	})
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, statusError(err)
 	}
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
 func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
-	klog.V(4).Infof("NodeStageVolume: called with args %+v", *req)
+	logFor(ctx).V(4).Infof("NodeStageVolume: called with args %+v", *req)
 
 	stagingTarget := req.GetStagingTargetPath()
 	volumeCapability := req.GetVolumeCapability()
@@ -124,22 +298,72 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	if volumeCapability == nil {
 		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume Volume Capability must be provided")
 	}
+	// The filesystem is checked before anything is set up on the node
+	var fsType string
+	var mkfsOptions, mountFlags []string
+	if volumeCapability.GetBlock() == nil {
+		var err error
+		fsType, mkfsOptions, mountFlags, err = volumeFormat(volumeCapability, req.GetVolumeContext())
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	devicePath, ok := req.GetPublishContext()["DevicePath"]
-	if !ok {
+	if !ok && ns.Driver.attachMode != AttachModeConnector {
 		return nil, status.Error(codes.InvalidArgument, "Device path not provided")
 	}
 	// Get Mount Provider
 	m, err := mount.GetMountProvider()
 	if err != nil {
-		klog.V(3).Infof("Failed to GetMountProvider: %v", err)
+		logFor(ctx).V(3).Infof("Failed to GetMountProvider: %v", err)
 		return nil, status.Errorf(codes.Internal, "Failed to GetMountProvider: %v", err)
 	}
-	// Device Scan
-	err = m.ScanForAttach(devicePath)
+	if ns.Driver.attachMode == AttachModeConnector {
+		devicePath, err = ns.ops.run(ctx, "connection of volume "+req.GetVolumeId(), ns.Driver.deviceScanTimeout, func() (string, error) {
+			return ns.connectVolume(req.GetVolumeId(), stagingTarget)
+		})
+		if err != nil {
+			logFor(ctx).V(3).Infof("Failed to connect volume %s: %v", req.GetVolumeId(), err)
+			return nil, err
+		}
+	} else {
+		// Device Scan
+		devicePath = mount.VolumeDevicePath(req.GetVolumeId(), devicePath)
+		_, err = ns.ops.run(ctx, "device scan of volume "+req.GetVolumeId(), ns.Driver.deviceScanTimeout, func() (string, error) {
+			return "", m.ScanForAttach(devicePath)
+		})
+		if isTimeout(err) {
+			return nil, err
+		}
+		if err != nil {
+			logFor(ctx).V(3).Infof("Failed to ScanForAttach: %v", err)
+			return nil, status.Errorf(codes.Internal, "Failed to ScanForAttach: %v", err)
+		}
+	}
+
+	// An encrypted volume is staged and published through its opened LUKS
+	// volume
+	luks, err := luksRequested(req.GetVolumeContext())
 	if err != nil {
-		klog.V(3).Infof("Failed to ScanForAttach: %v", err)
-		return nil, status.Errorf(codes.Internal, "Failed to ScanForAttach: %v", err)
+		return nil, err
+	}
+	if luks {
+		key, err := luksKey(req.GetVolumeId(), req.GetVolumeContext(), req.GetSecrets())
+		if err != nil {
+			logFor(ctx).V(3).Infof("Failed to get the LUKS key of volume %s: %v", req.GetVolumeId(), err)
+			return nil, err
+		}
+		devicePath, err = m.OpenEncrypted(devicePath, luksMapperName(req.GetVolumeId()), key)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to open the LUKS volume of volume %s: %v", req.GetVolumeId(), err)
+		}
+	}
+
+	// A raw block volume has no filesystem to stage, its device is bind
+	// mounted on NodePublishVolume
+	if volumeCapability.GetBlock() != nil {
+		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
 	// Verify whether mounted
@@ -150,23 +374,20 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 
 	// Volume Mount
 	if notMnt {
-		// set default fstype is ext4
-		fsType := "ext4"
-		var options []string
-		if mnt := volumeCapability.GetMount(); mnt != nil {
-			if mnt.FsType != "" {
-				fsType = mnt.FsType
-			}
-			mountFlags := mnt.GetMountFlags()
-			options = append(options, mountFlags...)
-		} else if blk := volumeCapability.GetBlock(); blk != nil {
-			// TODO(#341): Block volume support
-			return nil, status.Errorf(codes.Unimplemented, "Block volume support is not yet implemented")
-		}
 		// Mount
-		err = m.FormatAndMount(devicePath, stagingTarget, fsType, options)
+		_, err = ns.ops.run(ctx, "mount of "+stagingTarget, ns.Driver.mountTimeout, func() (string, error) {
+			return "", m.FormatAndMount(devicePath, stagingTarget, fsType, mkfsOptions, mountFlags)
+		})
 		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
+			return nil, statusError(err)
+		}
+	}
+
+	// Grow the filesystem if the volume was extended without it, failing to
+	// do so leaves it usable so it does not fail the staging
+	if ns.Driver.growOnStage {
+		if _, err := m.GrowFilesystemIfNeeded(devicePath, stagingTarget, ns.Driver.growOnStageThreshold); err != nil {
+			logFor(ctx).Warningf("Failed to grow the filesystem of volume %s on %s: %v", req.GetVolumeId(), devicePath, err)
 		}
 	}
 
@@ -174,7 +395,7 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 }
 
 func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	klog.V(4).Infof("NodeUnstageVolume: called with args %+v", *req)
+	logFor(ctx).V(4).Infof("NodeUnstageVolume: called with args %+v", *req)
 
 	stagingTargetPath := req.GetStagingTargetPath()
 	if len(stagingTargetPath) == 0 {
@@ -183,7 +404,7 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 	// Get Mount Provider
 	m, err := mount.GetMountProvider()
 	if err != nil {
-		klog.V(3).Infof("Failed to GetMountProvider: %v", err)
+		logFor(ctx).V(3).Infof("Failed to GetMountProvider: %v", err)
 		return nil, err
 	}
 
@@ -192,12 +413,27 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	if notMnt {
-		return nil, status.Error(codes.NotFound, "Volume not mounted")
+		// Raw block volumes are staged without a mount
+		logFor(ctx).V(4).Infof("NodeUnstageVolume: %s is not mounted, nothing to unmount", stagingTargetPath)
+	} else {
+		_, err = ns.ops.run(ctx, "unmount of "+stagingTargetPath, ns.Driver.mountTimeout, func() (string, error) {
+			return "", m.UnmountPath(stagingTargetPath)
+		})
+		if err != nil {
+			return nil, statusError(err)
+		}
 	}
 
-	err = m.UnmountPath(stagingTargetPath)
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	// Nothing is closed for a volume that is not encrypted
+	if err := m.CloseEncrypted(luksMapperName(req.GetVolumeId())); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to close the LUKS volume of volume %s: %v", req.GetVolumeId(), err)
+	}
+
+	if ns.Driver.attachMode == AttachModeConnector {
+		if err := ns.disconnectVolume(req.GetVolumeId(), stagingTargetPath); err != nil {
+			logFor(ctx).V(3).Infof("Failed to disconnect volume %s: %v", req.GetVolumeId(), err)
+			return nil, err
+		}
 	}
 
 	return &csi.NodeUnstageVolumeResponse{}, nil
@@ -209,17 +445,29 @@ func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoReque
 	if err != nil {
 		return nil, err
 	}
+	// Without its zone the node would only be accessible to volumes without
+	// one, fail so that the registration is retried
 	zone, err := getAvailabilityZoneMetadataService()
-	topology := &csi.Topology{Segments: map[string]string{topologyKey: zone}}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to get the availability zone of node %s: %v", nodeID, err)
+	}
+	if zone == "" {
+		return nil, status.Errorf(codes.Internal, "The metadata service reported no availability zone for node %s", nodeID)
+	}
+	topology := &csi.Topology{Segments: ns.Driver.topology.nodeSegments(zone)}
+	if ns.Driver.topologyRegion && ns.Driver.cloud.Region != "" {
+		topology.Segments[regionTopologyKey] = ns.Driver.cloud.Region
+	}
 
 	return &csi.NodeGetInfoResponse{
 		NodeId:             nodeID,
 		AccessibleTopology: topology,
+		MaxVolumesPerNode:  ns.Driver.getMaxVolumesPerNode(),
 	}, nil
 }
 
 func (ns *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	klog.V(5).Infof("NodeGetCapabilities called with req: %#v", req)
+	logFor(ctx).V(5).Infof("NodeGetCapabilities called with req: %#v", req)
 
 	return &csi.NodeGetCapabilitiesResponse{
 		Capabilities: ns.Driver.nscap,
@@ -227,7 +475,78 @@ func (ns *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetC
 }
 
 func (ns *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	return nil, status.Error(codes.Unimplemented, fmt.Sprintf("NodeGetVolumeStats is not yet implemented"))
+	logFor(ctx).V(5).Infof("NodeGetVolumeStats: called with args %+v", *req)
+
+	volumePath := req.GetVolumePath()
+	if len(req.GetVolumeId()) == 0 || len(volumePath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats Volume ID and Volume Path must be provided")
+	}
+
+	// Get Mount Provider
+	m, err := mount.GetMountProvider()
+	if err != nil {
+		logFor(ctx).V(3).Infof("Failed to GetMountProvider: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	stats, err := m.GetVolumeStats(volumePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "Volume path %s not found", volumePath)
+		}
+		return nil, status.Errorf(codes.Internal, "Failed to get the stats of volume %s on %s: %v", req.GetVolumeId(), volumePath, err)
+	}
+
+	if stats.Block {
+		return &csi.NodeGetVolumeStatsResponse{
+			Usage: []*csi.VolumeUsage{
+				{Unit: csi.VolumeUsage_BYTES, Total: stats.TotalBytes},
+			},
+		}, nil
+	}
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{Unit: csi.VolumeUsage_BYTES, Total: stats.TotalBytes, Available: stats.AvailableBytes, Used: stats.UsedBytes},
+			{Unit: csi.VolumeUsage_INODES, Total: stats.TotalInodes, Available: stats.FreeInodes, Used: stats.UsedInodes},
+		},
+	}, nil
+}
+
+func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	logFor(ctx).V(4).Infof("NodeExpandVolume: called with args %+v", *req)
+
+	volumePath := req.GetVolumePath()
+	if len(req.GetVolumeId()) == 0 || len(volumePath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeExpandVolume Volume ID and Volume Path must be provided")
+	}
+
+	// Get Mount Provider
+	m, err := mount.GetMountProvider()
+	if err != nil {
+		logFor(ctx).V(3).Infof("Failed to GetMountProvider: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	devicePath, err := m.GetDeviceName(volumePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to get the device mounted on %s: %v", volumePath, err)
+	}
+	if devicePath == "" {
+		return nil, status.Errorf(codes.NotFound, "Volume %s is not mounted on %s", req.GetVolumeId(), volumePath)
+	}
+
+	// The device already has the size Cinder extended the volume to, grow
+	// the LUKS volume on it if any and the filesystem to all of it
+	if name := luksMapperName(req.GetVolumeId()); devicePath == mount.EncryptedDevicePath(name) {
+		if err := m.ResizeEncrypted(name); err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to resize the LUKS volume of volume %s: %v", req.GetVolumeId(), err)
+		}
+	}
+	if _, err := m.GrowFilesystemIfNeeded(devicePath, volumePath, 0); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to grow the filesystem of volume %s on %s: %v", req.GetVolumeId(), devicePath, err)
+	}
+
+	return &csi.NodeExpandVolumeResponse{}, nil
 }
 
 func getNodeIDMountProvider() (string, error) {
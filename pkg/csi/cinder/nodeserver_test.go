@@ -17,12 +17,20 @@ limitations under the License.
 package cinder
 
 import (
+	"errors"
 	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/connector"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/mount"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
 )
@@ -37,6 +45,9 @@ func init() {
 
 		d := NewDriver(fakeNodeID, fakeEndpoint, fakeCluster, fakeConfig)
 		fakeNs = NewNodeServer(d)
+
+		// Leave the disks of the machine running the tests out
+		sysBlockPath = "/nonexistent/sys/block"
 	}
 }
 
@@ -75,6 +86,154 @@ func TestNodeGetInfo(t *testing.T) {
 	assert.Equal(expectedRes, actualRes)
 }
 
+// Test NodeGetInfo without the availability zone of the node
+func TestNodeGetInfoNoZone(t *testing.T) {
+	mmock := new(mount.MountMock)
+	mmock.On("GetInstanceID").Return(fakeNodeID, nil)
+	mount.MInstance = mmock
+
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetAvailabilityZone").Return("", errors.New("connection refused"))
+	openstack.MetadataService = osmock
+
+	_, err := fakeNs.NodeGetInfo(fakeCtx, &csi.NodeGetInfoRequest{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Contains(t, err.Error(), "connection refused")
+}
+
+// Test NodeGetInfo with --max-volumes-per-node
+func TestNodeGetInfoMaxVolumes(t *testing.T) {
+	mmock := new(mount.MountMock)
+	mmock.On("GetInstanceID").Return(fakeNodeID, nil)
+	mount.MInstance = mmock
+
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetAvailabilityZone").Return(fakeAvailability, nil)
+	openstack.MetadataService = osmock
+
+	d := NewDriver(fakeNodeID, fakeEndpoint, fakeCluster, fakeConfig)
+	d.SetMaxVolumesPerNode(10)
+	res, err := NewNodeServer(d).NodeGetInfo(fakeCtx, &csi.NodeGetInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), res.MaxVolumesPerNode)
+}
+
+// Test NodeGetInfo with --topology-region
+func TestNodeGetInfoRegion(t *testing.T) {
+	mmock := new(mount.MountMock)
+	mmock.On("GetInstanceID").Return(fakeNodeID, nil)
+	mount.MInstance = mmock
+
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetAvailabilityZone").Return(fakeAvailability, nil)
+	openstack.MetadataService = osmock
+
+	d := NewDriver(fakeNodeID, fakeEndpoint, fakeCluster, fakeConfig)
+	d.SetTopologyRegion(true)
+	d.cloud.Region = "RegionTwo"
+	res, err := NewNodeServer(d).NodeGetInfo(fakeCtx, &csi.NodeGetInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{topologyKey: fakeAvailability, regionTopologyKey: "RegionTwo"}, res.AccessibleTopology.Segments)
+}
+
+// The disks of the node other than volumes are left out of the maximum of
+// their bus.
+func TestDetectMaxVolumesPerNode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sys-block")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	max, err := detectMaxVolumesPerNode(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), max)
+
+	disks := map[string]string{
+		// Root disk and config drive
+		"vda": "",
+		"sr0": "",
+		// Volume
+		"vdb":   "261a8b81-3660-43e5-b",
+		"loop0": "",
+	}
+	for name, serial := range disks {
+		assert.NoError(t, os.Mkdir(filepath.Join(dir, name), 0755))
+		if serial != "" {
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name, "serial"), []byte(serial), 0644))
+		}
+	}
+
+	max, err = detectMaxVolumesPerNode(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(25), max)
+}
+
+// Test NodePublishVolume and NodeUnpublishVolume of an ephemeral volume
+func TestNodePublishEphemeralVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephemeral")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	targetPath := filepath.Join(dir, "mount")
+
+	properties := map[string]string{
+		"cinder.csi.openstack.org/cluster":        fakeCluster,
+		"cinder.csi.openstack.org/ephemeral":      "csi-1",
+		"cinder.csi.openstack.org/ephemeral-node": fakeNodeID,
+	}
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetAvailabilityZone").Return(fakeAvailability, nil)
+	osmock.On("CreateVolume", "ephemeral-csi-1", 2, "fast", fakeAvailability, "", "", &properties).Return(fakeVolID, fakeAvailability, 2, nil)
+	osmock.On("GetVolume", fakeVolID).Return(openstack.Volume{ID: fakeVolID, Status: "available"}, nil)
+	osmock.On("AttachVolume", fakeNodeID, fakeVolID).Return(fakeVolID, nil)
+	osmock.On("WaitDiskAttached", fakeNodeID, fakeVolID).Return(nil)
+	osmock.On("GetAttachmentDiskPath", fakeNodeID, fakeVolID).Return(fakeDevicePath, nil)
+	osmock.On("DetachVolume", fakeNodeID, fakeVolID).Return(nil)
+	osmock.On("WaitDiskDetached", fakeNodeID, fakeVolID).Return(nil)
+	osmock.On("DeleteVolume", fakeVolID).Return(nil)
+	openstack.OsInstance = osmock
+	openstack.MetadataService = osmock
+	mmock := new(mount.MountMock)
+	mmock.On("GetInstanceID").Return(fakeNodeID, nil)
+	mmock.On("ScanForAttach", fakeDevicePath).Return(nil)
+	mmock.On("IsLikelyNotMountPointAttach", targetPath).Return(true, nil)
+	mmock.On("FormatAndMount", fakeDevicePath, targetPath, "ext4", []string(nil), []string(nil)).Return(nil)
+	mmock.On("IsLikelyNotMountPointDetach", targetPath).Return(false, nil)
+	mmock.On("UnmountPath", targetPath).Return(nil)
+	mount.MInstance = mmock
+
+	// No staging path
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:   "csi-1",
+		TargetPath: targetPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+		VolumeContext: map[string]string{"csi.storage.k8s.io/ephemeral": "true", "capacity": "1500Mi", "type": "fast"},
+	}
+	_, err = fakeNs.NodePublishVolume(fakeCtx, req)
+	assert.NoError(t, err)
+	v, err := readEphemeral(targetPath)
+	assert.NoError(t, err)
+	assert.Equal(t, &ephemeralVolume{VolumeID: fakeVolID}, v)
+
+	// Publishing again reuses the volume
+	_, err = fakeNs.NodePublishVolume(fakeCtx, req)
+	assert.NoError(t, err)
+	osmock.AssertNumberOfCalls(t, "CreateVolume", 1)
+
+	assert.NoError(t, os.MkdirAll(targetPath, 0750))
+	_, err = fakeNs.NodeUnpublishVolume(fakeCtx, &csi.NodeUnpublishVolumeRequest{VolumeId: "csi-1", TargetPath: targetPath})
+	assert.NoError(t, err)
+	osmock.AssertCalled(t, "DetachVolume", fakeNodeID, fakeVolID)
+	osmock.AssertCalled(t, "DeleteVolume", fakeVolID)
+	_, err = os.Stat(ephemeralPath(targetPath))
+	assert.True(t, os.IsNotExist(err))
+
+	req.VolumeContext["capacity"] = "lots"
+	_, err = fakeNs.NodePublishVolume(fakeCtx, req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
 // Test NodePublishVolume
 func TestNodePublishVolume(t *testing.T) {
 
@@ -83,6 +242,7 @@ func TestNodePublishVolume(t *testing.T) {
 	// ScanForAttach(devicePath string) error
 	mmock.On("ScanForAttach", fakeDevicePath).Return(nil)
 	// IsLikelyNotMountPointAttach(targetpath string) (bool, error)
+	mmock.On("IsLikelyNotMountPointAttach", fakeStagingTargetPath).Return(false, nil)
 	mmock.On("IsLikelyNotMountPointAttach", fakeTargetPath).Return(true, nil)
 	// Mount(source string, target string, fstype string, options []string) error
 	mmock.On("Mount", fakeStagingTargetPath, fakeTargetPath, mock.AnythingOfType("string"), []string{"bind", "rw"}).Return(nil)
@@ -121,6 +281,153 @@ func TestNodePublishVolume(t *testing.T) {
 	assert.Equal(expectedRes, actualRes)
 }
 
+// Test NodePublishVolume of a volume that was not staged
+func TestNodePublishVolumeNotStaged(t *testing.T) {
+	mmock := new(mount.MountMock)
+	mmock.On("IsLikelyNotMountPointAttach", fakeStagingTargetPath).Return(true, nil)
+	mount.MInstance = mmock
+
+	fakeReq := &csi.NodePublishVolumeRequest{
+		VolumeId:          fakeVolID,
+		PublishContext:    map[string]string{"DevicePath": fakeDevicePath},
+		TargetPath:        fakeTargetPath,
+		StagingTargetPath: fakeStagingTargetPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+	}
+
+	_, err := fakeNs.NodePublishVolume(fakeCtx, fakeReq)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	mmock.AssertNotCalled(t, "Mount", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// Test NodePublishVolume of a raw block volume
+func TestNodePublishVolumeBlock(t *testing.T) {
+	mmock := new(mount.MountMock)
+	mmock.On("ScanForAttach", fakeDevicePath).Return(nil)
+	mmock.On("MakeFile", fakeTargetPath).Return(nil)
+	mmock.On("IsLikelyNotMountPointAttach", fakeTargetPath).Return(true, nil)
+	// The device itself is bind mounted, without a filesystem
+	mmock.On("Mount", fakeDevicePath, fakeTargetPath, "", []string{"bind", "ro"}).Return(nil)
+	mount.MInstance = mmock
+
+	fakeReq := &csi.NodePublishVolumeRequest{
+		VolumeId:          fakeVolID,
+		PublishContext:    map[string]string{"DevicePath": fakeDevicePath},
+		TargetPath:        fakeTargetPath,
+		StagingTargetPath: fakeStagingTargetPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Block{
+				Block: &csi.VolumeCapability_BlockVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+		Readonly: true,
+	}
+
+	actualRes, err := fakeNs.NodePublishVolume(fakeCtx, fakeReq)
+	assert.NoError(t, err)
+	assert.Equal(t, &csi.NodePublishVolumeResponse{}, actualRes)
+	mmock.AssertNotCalled(t, "FormatAndMount", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// Test NodePublishVolume of a volume already published at the target path
+func TestNodePublishVolumeAlreadyPublished(t *testing.T) {
+	staged := &mount.MountPoint{Device: fakeDevicePath, FsType: "ext4"}
+	newReq := func(fsType string, readOnly bool) *csi.NodePublishVolumeRequest {
+		return &csi.NodePublishVolumeRequest{
+			VolumeId:          fakeVolID,
+			PublishContext:    map[string]string{"DevicePath": fakeDevicePath},
+			TargetPath:        fakeTargetPath,
+			StagingTargetPath: fakeStagingTargetPath,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{FsType: fsType},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+			Readonly: readOnly,
+		}
+	}
+	tests := []struct {
+		name      string
+		published *mount.MountPoint
+		req       *csi.NodePublishVolumeRequest
+		code      codes.Code
+	}{
+		{"same mount", &mount.MountPoint{Device: fakeDevicePath, FsType: "ext4"}, newReq("ext4", false), codes.OK},
+		{"other device", &mount.MountPoint{Device: "/dev/vdz", FsType: "ext4"}, newReq("", false), codes.AlreadyExists},
+		{"read-only mismatch", &mount.MountPoint{Device: fakeDevicePath, FsType: "ext4"}, newReq("", true), codes.AlreadyExists},
+		{"fsType mismatch", &mount.MountPoint{Device: fakeDevicePath, FsType: "ext4"}, newReq("xfs", false), codes.InvalidArgument},
+		{"unsupported fsType", &mount.MountPoint{Device: fakeDevicePath, FsType: "ext4"}, newReq("ntfs", false), codes.InvalidArgument},
+	}
+	for _, test := range tests {
+		mmock := new(mount.MountMock)
+		mmock.On("IsLikelyNotMountPointAttach", fakeStagingTargetPath).Return(false, nil)
+		mmock.On("IsLikelyNotMountPointAttach", fakeTargetPath).Return(false, nil)
+		mmock.On("GetMountPoint", fakeStagingTargetPath).Return(staged, nil)
+		mmock.On("GetMountPoint", fakeTargetPath).Return(test.published, nil)
+		mount.MInstance = mmock
+
+		_, err := fakeNs.NodePublishVolume(fakeCtx, test.req)
+		assert.Equal(t, test.code, status.Code(err), "%s: %v", test.name, err)
+		mmock.AssertNotCalled(t, "Mount", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	}
+
+	// A raw block volume is compared by device
+	mmock := new(mount.MountMock)
+	mmock.On("MakeFile", fakeTargetPath).Return(nil)
+	mmock.On("IsLikelyNotMountPointAttach", fakeTargetPath).Return(false, nil)
+	mmock.On("IsSameDevice", fakeTargetPath, fakeDevicePath).Return(false, nil)
+	mount.MInstance = mmock
+	blockReq := newReq("", false)
+	blockReq.VolumeCapability.AccessType = &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+	_, err := fakeNs.NodePublishVolume(fakeCtx, blockReq)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	mmock.AssertNotCalled(t, "Mount", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// Test NodePublishVolume over a corrupted mount, e.g. of a device gone away
+func TestNodePublishVolumeCorruptedMount(t *testing.T) {
+	corrupted := &os.PathError{Op: "stat", Path: fakeTargetPath, Err: syscall.ENOTCONN}
+	mmock := new(mount.MountMock)
+	mmock.On("IsLikelyNotMountPointAttach", fakeStagingTargetPath).Return(false, nil)
+	mmock.On("IsLikelyNotMountPointAttach", fakeTargetPath).Return(false, corrupted).Once()
+	mmock.On("UnmountPath", fakeTargetPath).Return(nil)
+	mmock.On("IsLikelyNotMountPointAttach", fakeTargetPath).Return(true, nil)
+	mmock.On("Mount", fakeStagingTargetPath, fakeTargetPath, "ext4", []string{"bind", "rw"}).Return(nil)
+	mount.MInstance = mmock
+
+	fakeReq := &csi.NodePublishVolumeRequest{
+		VolumeId:          fakeVolID,
+		TargetPath:        fakeTargetPath,
+		StagingTargetPath: fakeStagingTargetPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+	}
+
+	_, err := fakeNs.NodePublishVolume(fakeCtx, fakeReq)
+	assert.NoError(t, err)
+	mmock.AssertCalled(t, "UnmountPath", fakeTargetPath)
+	mmock.AssertCalled(t, "Mount", fakeStagingTargetPath, fakeTargetPath, "ext4", []string{"bind", "rw"})
+}
+
 // Test NodeStageVolume
 func TestNodeStageVolume(t *testing.T) {
 
@@ -130,8 +437,8 @@ func TestNodeStageVolume(t *testing.T) {
 	mmock.On("ScanForAttach", fakeDevicePath).Return(nil)
 	// IsLikelyNotMountPointAttach(targetpath string) (bool, error)
 	mmock.On("IsLikelyNotMountPointAttach", fakeStagingTargetPath).Return(true, nil)
-	// FormatAndMount(source string, target string, fstype string, options []string) error
-	mmock.On("FormatAndMount", fakeDevicePath, fakeStagingTargetPath, "ext4", []string(nil)).Return(nil)
+	// FormatAndMount(source string, target string, fstype string, mkfsOptions []string, options []string) error
+	mmock.On("FormatAndMount", fakeDevicePath, fakeStagingTargetPath, "ext4", []string(nil), []string(nil)).Return(nil)
 	mount.MInstance = mmock
 
 	// Init assert
@@ -166,6 +473,283 @@ func TestNodeStageVolume(t *testing.T) {
 	assert.Equal(expectedRes, actualRes)
 }
 
+// Test NodeStageVolume formatting with the mkfs options of the volume
+func TestNodeStageVolumeMkfsOptions(t *testing.T) {
+	mmock := new(mount.MountMock)
+	mmock.On("ScanForAttach", fakeDevicePath).Return(nil)
+	mmock.On("IsLikelyNotMountPointAttach", fakeStagingTargetPath).Return(true, nil)
+	mmock.On("FormatAndMount", fakeDevicePath, fakeStagingTargetPath, "xfs", []string{"-i", "size=512"}, []string{"noatime"}).Return(nil)
+	mount.MInstance = mmock
+
+	request := func(fsType string) *csi.NodeStageVolumeRequest {
+		return &csi.NodeStageVolumeRequest{
+			VolumeId:          fakeVolID,
+			PublishContext:    map[string]string{"DevicePath": fakeDevicePath},
+			StagingTargetPath: fakeStagingTargetPath,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fsType, MountFlags: []string{"noatime"}}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			},
+			VolumeContext: map[string]string{"mkfsOptions": "-i size=512"},
+		}
+	}
+	_, err := fakeNs.NodeStageVolume(fakeCtx, request("XFS"))
+	assert.NoError(t, err)
+
+	// Unsupported filesystems fail before the device is looked for
+	_, err = fakeNs.NodeStageVolume(fakeCtx, request("ntfs"))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	mmock.AssertNumberOfCalls(t, "ScanForAttach", 1)
+}
+
+// Test NodeStageVolume of a raw block volume, which is not formatted
+func TestNodeStageVolumeBlock(t *testing.T) {
+	mmock := new(mount.MountMock)
+	mmock.On("ScanForAttach", fakeDevicePath).Return(nil)
+	mount.MInstance = mmock
+
+	fakeReq := &csi.NodeStageVolumeRequest{
+		VolumeId:          fakeVolID,
+		PublishContext:    map[string]string{"DevicePath": fakeDevicePath},
+		StagingTargetPath: fakeStagingTargetPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Block{
+				Block: &csi.VolumeCapability_BlockVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+	}
+
+	actualRes, err := fakeNs.NodeStageVolume(fakeCtx, fakeReq)
+	assert.NoError(t, err)
+	assert.Equal(t, &csi.NodeStageVolumeResponse{}, actualRes)
+	mmock.AssertNotCalled(t, "FormatAndMount", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// Test NodeStageVolume opening the LUKS volume of an encrypted volume
+func TestNodeStageVolumeLUKS(t *testing.T) {
+	mapped := "/dev/mapper/luks-" + fakeVolID
+	mmock := new(mount.MountMock)
+	mmock.On("ScanForAttach", fakeDevicePath).Return(nil)
+	mmock.On("OpenEncrypted", fakeDevicePath, "luks-"+fakeVolID, []byte("secret")).Return(mapped, nil)
+	mmock.On("IsLikelyNotMountPointAttach", fakeStagingTargetPath).Return(true, nil)
+	mmock.On("FormatAndMount", mapped, fakeStagingTargetPath, "ext4", []string(nil), []string(nil)).Return(nil)
+	mount.MInstance = mmock
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetSecretPayload", "barbican-secret").Return([]byte("secret"), nil)
+	openstack.OsInstance = osmock
+
+	request := func(volumeContext, secrets map[string]string) *csi.NodeStageVolumeRequest {
+		return &csi.NodeStageVolumeRequest{
+			VolumeId:          fakeVolID,
+			PublishContext:    map[string]string{"DevicePath": fakeDevicePath},
+			StagingTargetPath: fakeStagingTargetPath,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			},
+			VolumeContext: volumeContext,
+			Secrets:       secrets,
+		}
+	}
+
+	// The key of the node stage secret
+	_, err := fakeNs.NodeStageVolume(fakeCtx, request(map[string]string{"luks": "true"}, map[string]string{"luksKey": "secret"}))
+	assert.NoError(t, err)
+	// The key of Barbican
+	_, err = fakeNs.NodeStageVolume(fakeCtx, request(map[string]string{"luks": "true", "luksBarbicanSecretID": "barbican-secret"}, nil))
+	assert.NoError(t, err)
+	mmock.AssertNumberOfCalls(t, "FormatAndMount", 2)
+
+	_, err = fakeNs.NodeStageVolume(fakeCtx, request(map[string]string{"luks": "true"}, nil))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	mmock.AssertNumberOfCalls(t, "OpenEncrypted", 2)
+}
+
+// fakeConnector connects the volumes of a connection info as a fixed device.
+type fakeConnector struct {
+	connected    []map[string]interface{}
+	disconnected []map[string]interface{}
+}
+
+func (c *fakeConnector) Connect(data map[string]interface{}) (string, error) {
+	c.connected = append(c.connected, data)
+	return fakeDevicePath, nil
+}
+
+func (c *fakeConnector) Disconnect(data map[string]interface{}) error {
+	c.disconnected = append(c.disconnected, data)
+	return nil
+}
+
+// Test NodeStageVolume and NodeUnstageVolume with the connector attach mode
+func TestNodeStageVolumeConnector(t *testing.T) {
+	fakeNs.Driver.attachMode = AttachModeConnector
+	defer func() { fakeNs.Driver.attachMode = AttachModeNova }()
+
+	dir, err := ioutil.TempDir("", "stage")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	stagingPath := filepath.Join(dir, "globalmount")
+
+	fc := &fakeConnector{}
+	oldConnector, oldProperties := newConnector, localConnectorProperties
+	defer func() { newConnector, localConnectorProperties = oldConnector, oldProperties }()
+	newConnector = func(protocol string, multipath bool) (connector.Connector, error) {
+		assert.Equal(t, connector.ProtocolISCSI, protocol)
+		return fc, nil
+	}
+	localConnectorProperties = func(multipath bool) (connector.Properties, error) {
+		return connector.Properties{Host: "node-1", Initiator: "iqn.1993-08.org.debian:01:node-1"}, nil
+	}
+	props := openstack.ConnectorProperties{Host: "node-1", Initiator: "iqn.1993-08.org.debian:01:node-1"}
+	data := map[string]interface{}{"target_iqn": "iqn.2010-10.org.openstack:volume-1"}
+
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("InitializeConnection", fakeVolID, props).Return(&openstack.ConnectionInfo{DriverVolumeType: connector.ProtocolISCSI, Data: data}, nil)
+	osmock.On("TerminateConnection", fakeVolID, props).Return(nil)
+	openstack.OsInstance = osmock
+	mmock := new(mount.MountMock)
+	mmock.On("IsLikelyNotMountPointAttach", stagingPath).Return(true, nil)
+	mmock.On("FormatAndMount", fakeDevicePath, stagingPath, "ext4", []string(nil), []string(nil)).Return(nil)
+	mmock.On("IsLikelyNotMountPointDetach", stagingPath).Return(false, nil)
+	mmock.On("UnmountPath", stagingPath).Return(nil)
+	mmock.On("CloseEncrypted", "luks-"+fakeVolID).Return(nil)
+	mount.MInstance = mmock
+
+	// No DevicePath is published
+	_, err = fakeNs.NodeStageVolume(fakeCtx, &csi.NodeStageVolumeRequest{
+		VolumeId:          fakeVolID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{data}, fc.connected)
+	mmock.AssertNotCalled(t, "ScanForAttach", mock.Anything)
+	c, err := readConnection(stagingPath)
+	assert.NoError(t, err)
+	assert.Equal(t, &nodeConnection{Protocol: connector.ProtocolISCSI, Data: data, DevicePath: fakeDevicePath}, c)
+
+	_, err = fakeNs.NodeUnstageVolume(fakeCtx, &csi.NodeUnstageVolumeRequest{VolumeId: fakeVolID, StagingTargetPath: stagingPath})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{data}, fc.disconnected)
+	osmock.AssertCalled(t, "TerminateConnection", fakeVolID, props)
+	_, err = os.Stat(connectionPath(stagingPath))
+	assert.True(t, os.IsNotExist(err))
+
+	// Unstaging again finds nothing to disconnect
+	_, err = fakeNs.NodeUnstageVolume(fakeCtx, &csi.NodeUnstageVolumeRequest{VolumeId: fakeVolID, StagingTargetPath: stagingPath})
+	assert.NoError(t, err)
+	osmock.AssertNumberOfCalls(t, "TerminateConnection", 1)
+}
+
+// Test NodeStageVolume growing the filesystem of an extended volume
+func TestNodeStageVolumeGrow(t *testing.T) {
+	fakeNs.Driver.SetGrowOnStage(64 << 20)
+	defer func() { fakeNs.Driver.growOnStage = false }()
+
+	mmock := new(mount.MountMock)
+	mmock.On("ScanForAttach", fakeDevicePath).Return(nil)
+	mmock.On("IsLikelyNotMountPointAttach", fakeStagingTargetPath).Return(true, nil)
+	mmock.On("FormatAndMount", fakeDevicePath, fakeStagingTargetPath, "xfs", []string(nil), []string(nil)).Return(nil)
+	// GrowFilesystemIfNeeded(devicePath, mountPath string, threshold int64) (bool, error)
+	mmock.On("GrowFilesystemIfNeeded", fakeDevicePath, fakeStagingTargetPath, int64(64<<20)).Return(true, nil)
+	mount.MInstance = mmock
+
+	fakeReq := &csi.NodeStageVolumeRequest{
+		VolumeId:          fakeVolID,
+		PublishContext:    map[string]string{"DevicePath": fakeDevicePath},
+		StagingTargetPath: fakeStagingTargetPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{FsType: "xfs"},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+	}
+
+	actualRes, err := fakeNs.NodeStageVolume(fakeCtx, fakeReq)
+	assert.NoError(t, err)
+	assert.Equal(t, &csi.NodeStageVolumeResponse{}, actualRes)
+	mmock.AssertCalled(t, "GrowFilesystemIfNeeded", fakeDevicePath, fakeStagingTargetPath, int64(64<<20))
+
+	// A failure to grow does not fail the staging
+	mmock = new(mount.MountMock)
+	mmock.On("ScanForAttach", fakeDevicePath).Return(nil)
+	mmock.On("IsLikelyNotMountPointAttach", fakeStagingTargetPath).Return(false, nil)
+	mmock.On("GrowFilesystemIfNeeded", fakeDevicePath, fakeStagingTargetPath, int64(64<<20)).Return(false, errors.New("resize2fs failed"))
+	mount.MInstance = mmock
+
+	actualRes, err = fakeNs.NodeStageVolume(fakeCtx, fakeReq)
+	assert.NoError(t, err)
+	assert.Equal(t, &csi.NodeStageVolumeResponse{}, actualRes)
+}
+
+// Test NodeExpandVolume
+func TestNodeExpandVolume(t *testing.T) {
+	mmock := new(mount.MountMock)
+	mmock.On("GetDeviceName", fakeTargetPath).Return(fakeDevicePath, nil)
+	mmock.On("GrowFilesystemIfNeeded", fakeDevicePath, fakeTargetPath, int64(0)).Return(true, nil)
+	mmock.On("GetDeviceName", "/mnt/unmounted").Return("", nil)
+	mount.MInstance = mmock
+
+	actualRes, err := fakeNs.NodeExpandVolume(fakeCtx, &csi.NodeExpandVolumeRequest{VolumeId: fakeVolID, VolumePath: fakeTargetPath})
+	assert.NoError(t, err)
+	assert.Equal(t, &csi.NodeExpandVolumeResponse{}, actualRes)
+
+	_, err = fakeNs.NodeExpandVolume(fakeCtx, &csi.NodeExpandVolumeRequest{VolumeId: fakeVolID, VolumePath: "/mnt/unmounted"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// Test NodeExpandVolume resizing the LUKS volume of an encrypted volume first
+func TestNodeExpandVolumeLUKS(t *testing.T) {
+	mapped := "/dev/mapper/luks-" + fakeVolID
+	mmock := new(mount.MountMock)
+	mmock.On("GetDeviceName", fakeTargetPath).Return(mapped, nil)
+	mmock.On("ResizeEncrypted", "luks-"+fakeVolID).Return(nil)
+	mmock.On("GrowFilesystemIfNeeded", mapped, fakeTargetPath, int64(0)).Return(true, nil)
+	mount.MInstance = mmock
+
+	_, err := fakeNs.NodeExpandVolume(fakeCtx, &csi.NodeExpandVolumeRequest{VolumeId: fakeVolID, VolumePath: fakeTargetPath})
+	assert.NoError(t, err)
+	mmock.AssertCalled(t, "ResizeEncrypted", "luks-"+fakeVolID)
+}
+
+// Test NodeGetVolumeStats of filesystem and raw block volumes
+func TestNodeGetVolumeStats(t *testing.T) {
+	mmock := new(mount.MountMock)
+	mmock.On("GetVolumeStats", fakeTargetPath).Return(&mount.VolumeStats{
+		TotalBytes: 1000, AvailableBytes: 600, UsedBytes: 400,
+		TotalInodes: 100, FreeInodes: 90, UsedInodes: 10,
+	}, nil)
+	mmock.On("GetVolumeStats", "/mnt/block").Return(&mount.VolumeStats{Block: true, TotalBytes: 1 << 30}, nil)
+	mmock.On("GetVolumeStats", "/mnt/missing").Return(nil, os.ErrNotExist)
+	mount.MInstance = mmock
+
+	assert := assert.New(t)
+
+	res, err := fakeNs.NodeGetVolumeStats(fakeCtx, &csi.NodeGetVolumeStatsRequest{VolumeId: fakeVolID, VolumePath: fakeTargetPath})
+	assert.NoError(err)
+	assert.Equal([]*csi.VolumeUsage{
+		{Unit: csi.VolumeUsage_BYTES, Total: 1000, Available: 600, Used: 400},
+		{Unit: csi.VolumeUsage_INODES, Total: 100, Available: 90, Used: 10},
+	}, res.Usage)
+
+	res, err = fakeNs.NodeGetVolumeStats(fakeCtx, &csi.NodeGetVolumeStatsRequest{VolumeId: fakeVolID, VolumePath: "/mnt/block"})
+	assert.NoError(err)
+	assert.Equal([]*csi.VolumeUsage{{Unit: csi.VolumeUsage_BYTES, Total: 1 << 30}}, res.Usage)
+
+	_, err = fakeNs.NodeGetVolumeStats(fakeCtx, &csi.NodeGetVolumeStatsRequest{VolumeId: fakeVolID, VolumePath: "/mnt/missing"})
+	assert.Equal(codes.NotFound, status.Code(err))
+}
+
 // Test NodeUnpublishVolume
 func TestNodeUnpublishVolume(t *testing.T) {
 
@@ -210,6 +794,8 @@ func TestNodeUnstageVolume(t *testing.T) {
 	mmock.On("IsLikelyNotMountPointDetach", fakeStagingTargetPath).Return(false, nil)
 	// UnmountPath(mountPath string) error
 	mmock.On("UnmountPath", fakeStagingTargetPath).Return(nil)
+	// CloseEncrypted(name string) error
+	mmock.On("CloseEncrypted", "luks-"+fakeVolID).Return(nil)
 	mount.MInstance = mmock
 
 	// Init assert
@@ -18,27 +18,48 @@ package openstack
 
 import (
 	"crypto/tls"
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/extensions/trusts"
+	tokens3 "github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/gophercloud/utils/openstack/clientconfig"
 	gcfg "gopkg.in/gcfg.v1"
 	netutil "k8s.io/apimachinery/pkg/util/net"
 	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/cloud-provider-openstack/pkg/util/ratelimit"
 	"k8s.io/klog"
 )
 
 type IOpenStack interface {
-	CreateVolume(name string, size int, vtype, availability string, snapshotID string, tags *map[string]string) (string, string, int, error)
+	CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, tags *map[string]string) (string, string, int, error)
 	DeleteVolume(volumeID string) error
+	ExpandVolume(volumeID string, size int) error
+	GetVolume(volumeID string) (Volume, error)
+	ResetVolumeStatus(volumeID, status string) error
+	VolumeTypeEncrypted(volumeType string) (bool, error)
+	VolumeTypeMultiattach(volumeType string) (bool, error)
+	ListVolumeTypes() ([]VolumeType, error)
+	ListAvailabilityZones() ([]string, error)
 	AttachVolume(instanceID, volumeID string) (string, error)
 	ListVolumes() ([]Volume, error)
 	WaitDiskAttached(instanceID string, volumeID string) error
 	DetachVolume(instanceID, volumeID string) error
+	ForceDetachVolume(volumeID, attachmentID string) error
+	InstanceExists(instanceID string) (bool, error)
 	WaitDiskDetached(instanceID string, volumeID string) error
 	GetAttachmentDiskPath(instanceID, volumeID string) (string, error)
+	InitializeConnection(volumeID string, connector ConnectorProperties) (*ConnectionInfo, error)
+	TerminateConnection(volumeID string, connector ConnectorProperties) error
+	GetSecretPayload(secretID string) ([]byte, error)
+	CheckHealth() error
 	GetVolumesByName(name string) ([]Volume, error)
 	CreateSnapshot(name, volID, description string, tags *map[string]string) (*snapshots.Snapshot, error)
 	ListSnapshots(limit, offset int, filters map[string]string) ([]snapshots.Snapshot, error)
@@ -46,11 +67,19 @@ type IOpenStack interface {
 	GetSnapshotByNameAndVolumeID(n string, volumeId string) ([]snapshots.Snapshot, error)
 	GetSnapshotByID(snapshotID string) (*snapshots.Snapshot, error)
 	WaitSnapshotReady(snapshotID string) error
+	CreateBackup(name, volID string, tags *map[string]string) (*Backup, error)
+	ListBackups(volID string) ([]Backup, error)
+	GetBackupsByNameAndVolumeID(n string, volumeID string) ([]Backup, error)
+	GetBackupByID(backupID string) (*Backup, error)
+	DeleteBackup(backupID string) error
+	CreateVolumeFromBackup(name string, size int, vtype, availability string, backupID string, tags *map[string]string) (string, string, int, error)
 }
 
 type OpenStack struct {
 	compute      *gophercloud.ServiceClient
 	blockstorage *gophercloud.ServiceClient
+	// keymanager is nil on clouds without Barbican
+	keymanager *gophercloud.ServiceClient
 }
 
 type Config struct {
@@ -65,11 +94,26 @@ type Config struct {
 		DomainName string `gcfg:"domain-name"`
 		Region     string
 		CAFile     string `gcfg:"ca-file"`
+		TrustId    string `gcfg:"trust-id"`
+		// ExtraRegion are the regions, besides Region, the controller
+		// creates volumes in when asked to, see GetOpenStackProviderForRegion
+		ExtraRegion []string `gcfg:"extra-region"`
+		// Keystone application credential, used instead of the password
+		ApplicationCredentialId     string `gcfg:"application-credential-id"`
+		ApplicationCredentialName   string `gcfg:"application-credential-name"`
+		ApplicationCredentialSecret string `gcfg:"application-credential-secret"`
+		// UseClouds reads the settings missing in the file from clouds.yaml
+		// and secure.yaml, see ReadClouds
+		UseClouds  bool   `gcfg:"use-clouds"`
+		CloudsFile string `gcfg:"clouds-file,omitempty"`
+		Cloud      string `gcfg:"cloud,omitempty"`
 	}
+	RateLimit ratelimit.Config
+	Metadata  MetadataOpts
 }
 
 func (cfg Config) toAuthOptions() gophercloud.AuthOptions {
-	return gophercloud.AuthOptions{
+	opts := gophercloud.AuthOptions{
 		IdentityEndpoint: cfg.Global.AuthUrl,
 		Username:         cfg.Global.Username,
 		UserID:           cfg.Global.UserId,
@@ -79,26 +123,86 @@ func (cfg Config) toAuthOptions() gophercloud.AuthOptions {
 		DomainID:         cfg.Global.DomainId,
 		DomainName:       cfg.Global.DomainName,
 
+		ApplicationCredentialID:     cfg.Global.ApplicationCredentialId,
+		ApplicationCredentialName:   cfg.Global.ApplicationCredentialName,
+		ApplicationCredentialSecret: cfg.Global.ApplicationCredentialSecret,
+
 		// Persistent service, so we need to be able to renew tokens.
 		AllowReauth: true,
 	}
+	return unscopedApplicationCredential(opts)
+}
+
+// unscopedApplicationCredential drops the project of opts authenticating with
+// an application credential: the credential has its own, and Keystone refuses
+// a scope along with it.
+func unscopedApplicationCredential(opts gophercloud.AuthOptions) gophercloud.AuthOptions {
+	if opts.ApplicationCredentialSecret != "" {
+		opts.TenantID = ""
+		opts.TenantName = ""
+	}
+	return opts
 }
 
-// GetConfigFromFile retrieves config options from file
+// authenticate authenticates provider with opts, with a token scoped to the
+// trust trustID when set.
+func authenticate(provider *gophercloud.ProviderClient, opts gophercloud.AuthOptions, trustID string) error {
+	if trustID == "" {
+		return openstack.Authenticate(provider, opts)
+	}
+	if opts.ApplicationCredentialSecret != "" {
+		return fmt.Errorf("trust-id cannot be used with an application credential")
+	}
+
+	// The trustee authenticates unscoped, the trust is the scope
+	opts3 := tokens3.AuthOptions{
+		IdentityEndpoint: opts.IdentityEndpoint,
+		Username:         opts.Username,
+		UserID:           opts.UserID,
+		Password:         opts.Password,
+		DomainID:         opts.DomainID,
+		DomainName:       opts.DomainName,
+		AllowReauth:      opts.AllowReauth,
+	}
+	return openstack.AuthenticateV3(provider, trusts.AuthOptsExt{
+		TrustID:            trustID,
+		AuthOptionsBuilder: &opts3,
+	}, gophercloud.EndpointOpts{})
+}
+
+// GetConfigFromFile retrieves config options from file, and from clouds.yaml
+// with use-clouds or a cloud set with InitCloud. The file may then be
+// missing.
 func GetConfigFromFile(configFilePath string) (Config, gophercloud.EndpointOpts, error) {
 	var epOpts gophercloud.EndpointOpts
 	var cfg Config
 	config, err := os.Open(configFilePath)
-	if err != nil {
+	if err != nil && !(cloudName != "" && os.IsNotExist(err)) {
 		klog.V(3).Infof("Failed to open OpenStack configuration file: %v", err)
 		return cfg, epOpts, err
 	}
-	defer config.Close()
+	if err == nil {
+		defer config.Close()
 
-	err = gcfg.FatalOnly(gcfg.ReadInto(&cfg, config))
-	if err != nil {
-		klog.V(3).Infof("Failed to read OpenStack configuration file: %v", err)
-		return cfg, epOpts, err
+		err = gcfg.FatalOnly(gcfg.ReadInto(&cfg, config))
+		if err != nil {
+			klog.V(3).Infof("Failed to read OpenStack configuration file: %v", err)
+			return cfg, epOpts, err
+		}
+	}
+
+	if cloudName != "" {
+		cfg.Global.UseClouds = true
+		cfg.Global.Cloud = cloudName
+	}
+	if cfg.Global.UseClouds {
+		if cfg.Global.CloudsFile != "" {
+			os.Setenv("OS_CLIENT_CONFIG_FILE", cfg.Global.CloudsFile)
+		}
+		if err := ReadClouds(&cfg); err != nil {
+			klog.V(3).Infof("Failed to read clouds.yaml: %v", err)
+			return cfg, epOpts, err
+		}
 	}
 
 	epOpts = gophercloud.EndpointOpts{
@@ -108,6 +212,47 @@ func GetConfigFromFile(configFilePath string) (Config, gophercloud.EndpointOpts,
 	return cfg, epOpts, nil
 }
 
+// ReadClouds fills the settings of cfg missing in the file from the cloud of
+// clouds.yaml, merged with secure.yaml, the same files as the openstack CLI:
+// the cloud of the config, OS_CLOUD otherwise, found in OS_CLIENT_CONFIG_FILE,
+// the working directory, ~/.config/openstack or /etc/openstack.
+func ReadClouds(cfg *Config) error {
+	co := new(clientconfig.ClientOpts)
+	if cfg.Global.Cloud != "" {
+		co.Cloud = cfg.Global.Cloud
+	}
+	cloud, err := clientconfig.GetCloudFromYAML(co)
+	if err != nil {
+		return err
+	}
+
+	if auth := cloud.AuthInfo; auth != nil {
+		cfg.Global.AuthUrl = replaceEmpty(cfg.Global.AuthUrl, auth.AuthURL)
+		cfg.Global.Username = replaceEmpty(cfg.Global.Username, auth.Username)
+		cfg.Global.UserId = replaceEmpty(cfg.Global.UserId, auth.UserID)
+		cfg.Global.Password = replaceEmpty(cfg.Global.Password, auth.Password)
+		cfg.Global.TenantId = replaceEmpty(cfg.Global.TenantId, auth.ProjectID)
+		cfg.Global.TenantName = replaceEmpty(cfg.Global.TenantName, auth.ProjectName)
+		cfg.Global.DomainId = replaceEmpty(cfg.Global.DomainId, replaceEmpty(auth.UserDomainID, auth.DomainID))
+		cfg.Global.DomainName = replaceEmpty(cfg.Global.DomainName, replaceEmpty(auth.UserDomainName, auth.DomainName))
+		cfg.Global.ApplicationCredentialId = replaceEmpty(cfg.Global.ApplicationCredentialId, auth.ApplicationCredentialID)
+		cfg.Global.ApplicationCredentialName = replaceEmpty(cfg.Global.ApplicationCredentialName, auth.ApplicationCredentialName)
+		cfg.Global.ApplicationCredentialSecret = replaceEmpty(cfg.Global.ApplicationCredentialSecret, auth.ApplicationCredentialSecret)
+	}
+	cfg.Global.Region = replaceEmpty(cfg.Global.Region, cloud.RegionName)
+	cfg.Global.CAFile = replaceEmpty(cfg.Global.CAFile, cloud.CACertFile)
+
+	return nil
+}
+
+// replaceEmpty returns a, b when a is empty.
+func replaceEmpty(a string, b string) string {
+	if a == "" {
+		return b
+	}
+	return a
+}
+
 // GetConfigFromEnv retrieves config options from env
 func GetConfigFromEnv() (gophercloud.AuthOptions, gophercloud.EndpointOpts, error) {
 	// Get config from env
@@ -117,6 +262,7 @@ func GetConfigFromEnv() (gophercloud.AuthOptions, gophercloud.EndpointOpts, erro
 		klog.V(3).Infof("Failed to read OpenStack configuration from env: %v", err)
 		return authOpts, epOpts, err
 	}
+	authOpts = unscopedApplicationCredential(authOpts)
 
 	epOpts = gophercloud.EndpointOpts{
 		Region: os.Getenv("OS_REGION_NAME"),
@@ -125,40 +271,128 @@ func GetConfigFromEnv() (gophercloud.AuthOptions, gophercloud.EndpointOpts, erro
 	return authOpts, epOpts, nil
 }
 
+// CloudInfo identifies the cloud the driver talks to, without credentials.
+type CloudInfo struct {
+	AuthURLHost string
+	Region      string
+	Project     string
+}
+
+// GetCloudInfo returns the CloudInfo of the configured cloud, from the config
+// file or from the environment like CreateOpenStackProvider.
+func GetCloudInfo() (CloudInfo, error) {
+	var authOpts gophercloud.AuthOptions
+	cfg, epOpts, err := GetConfigFromFile(configFile)
+	if err == nil {
+		authOpts = cfg.toAuthOptions()
+	} else if cloudName != "" {
+		return CloudInfo{}, err
+	} else {
+		authOpts, epOpts, err = GetConfigFromEnv()
+		if err != nil {
+			return CloudInfo{}, err
+		}
+	}
+
+	info := CloudInfo{
+		Region:  epOpts.Region,
+		Project: authOpts.TenantName,
+	}
+	if info.Project == "" {
+		info.Project = authOpts.TenantID
+	}
+	if u, err := url.Parse(authOpts.IdentityEndpoint); err == nil {
+		info.AuthURLHost = u.Host
+	}
+	return info, nil
+}
+
 var OsInstance IOpenStack = nil
+
+// osInstanceLock guards OsInstance, replaced when the config changes, see
+// WatchConfig
+var osInstanceLock sync.Mutex
 var configFile = "/etc/cloud.conf"
 
+// cloudName is the cloud of clouds.yaml set with InitCloud
+var cloudName string
+
+// requestTimeout bounds the OpenStack API requests, see SetRequestTimeout
+var requestTimeout time.Duration
+
+// SetRequestTimeout fails the OpenStack API requests of the clients created
+// next without a response after timeout, 0 does not bound them.
+func SetRequestTimeout(timeout time.Duration) {
+	requestTimeout = timeout
+}
+
 func InitOpenStackProvider(cfg string) {
 	configFile = cfg
 	klog.V(2).Infof("InitOpenStackProvider configFile: %s", configFile)
 }
 
-// CreateOpenStackProvider creates Openstack Instance
-func CreateOpenStackProvider() (IOpenStack, error) {
-	var authOpts gophercloud.AuthOptions
-	var authURL string
-	var caFile string
+// InitCloud reads the settings missing in the config file from the cloud
+// name of clouds.yaml, see ReadClouds. An empty name leaves it to use-clouds.
+func InitCloud(name string) {
+	cloudName = name
+	if name != "" {
+		klog.V(2).Infof("InitCloud cloud: %s", name)
+	}
+}
+
+// authConfig is what the client of the OpenStack instance authenticates
+// with, see loadAuthConfig.
+type authConfig struct {
+	opts    gophercloud.AuthOptions
+	epOpts  gophercloud.EndpointOpts
+	caFile  string
+	trustID string
+	// extraRegions are the regions served besides the one of epOpts
+	extraRegions []string
+	rateLimit    ratelimit.Config
+	metadataOpts MetadataOpts
+}
+
+// loadAuthConfig reads the authentication settings from the config file,
+// from the environment without one.
+func loadAuthConfig() (authConfig, error) {
+	var auth authConfig
 	// Get config from file
 	cfg, epOpts, err := GetConfigFromFile(configFile)
 	if err == nil {
-		authOpts = cfg.toAuthOptions()
-		authURL = authOpts.IdentityEndpoint
-		caFile = cfg.Global.CAFile
+		auth.opts = cfg.toAuthOptions()
+		auth.epOpts = epOpts
+		auth.caFile = cfg.Global.CAFile
+		auth.trustID = cfg.Global.TrustId
+		auth.extraRegions = cfg.Global.ExtraRegion
+		if err := cfg.RateLimit.Validate(); err != nil {
+			return auth, fmt.Errorf("invalid [RateLimit]: %v", err)
+		}
+		auth.rateLimit = cfg.RateLimit
+		auth.metadataOpts = cfg.Metadata
+	} else if cloudName != "" {
+		// The environment is no fallback for a cloud asked for
+		return auth, err
 	} else {
 		// Get config from env
-		authOpts, epOpts, err = GetConfigFromEnv()
+		auth.opts, auth.epOpts, err = GetConfigFromEnv()
 		if err != nil {
-			return nil, err
+			return auth, err
 		}
-		authURL = authOpts.IdentityEndpoint
+		auth.trustID = os.Getenv("OS_TRUST_ID")
 	}
+	return auth, nil
+}
 
-	provider, err := openstack.NewClient(authURL)
+// newOpenStack authenticates with auth and returns an OpenStack instance of
+// the clients of the services.
+func newOpenStack(auth authConfig) (*OpenStack, error) {
+	provider, err := openstack.NewClient(auth.opts.IdentityEndpoint)
 	if err != nil {
 		return nil, err
 	}
-	if caFile != "" {
-		roots, err := certutil.NewPool(caFile)
+	if auth.caFile != "" {
+		roots, err := certutil.NewPool(auth.caFile)
 		if err != nil {
 			return nil, err
 		}
@@ -166,11 +400,20 @@ func CreateOpenStackProvider() (IOpenStack, error) {
 		config.RootCAs = roots
 		provider.HTTPClient.Transport = netutil.SetOldTransportDefaults(&http.Transport{TLSClientConfig: config})
 	}
+	// Shared by the clients of every region
+	provider.HTTPClient.Transport = auth.rateLimit.RoundTripper(requestLogger(provider.HTTPClient.Transport))
+	provider.HTTPClient.Timeout = requestTimeout
 
-	err = openstack.Authenticate(provider, authOpts)
+	err = authenticate(provider, auth.opts, auth.trustID)
 	if err != nil {
 		return nil, err
 	}
+	return newServiceClients(provider, auth.epOpts)
+}
+
+// newServiceClients returns an OpenStack instance of the clients of the
+// services of provider in the region of epOpts.
+func newServiceClients(provider *gophercloud.ProviderClient, epOpts gophercloud.EndpointOpts) (*OpenStack, error) {
 	// Init Nova ServiceClient
 	computeclient, err := openstack.NewComputeV2(provider, epOpts)
 	if err != nil {
@@ -183,17 +426,51 @@ func CreateOpenStackProvider() (IOpenStack, error) {
 		return nil, err
 	}
 
-	// Init OpenStack
-	OsInstance = &OpenStack{
+	// Init Barbican ServiceClient, only needed for the LUKS keys kept in
+	// Barbican
+	keymanagerclient, err := openstack.NewKeyManagerV1(provider, epOpts)
+	if err != nil {
+		klog.V(4).Infof("No Barbican endpoint: %v", err)
+		keymanagerclient = nil
+	}
+
+	return &OpenStack{
 		compute:      computeclient,
 		blockstorage: blockstorageclient,
+		keymanager:   keymanagerclient,
+	}, nil
+}
+
+// CreateOpenStackProvider creates Openstack Instance
+func CreateOpenStackProvider() (IOpenStack, error) {
+	auth, err := loadAuthConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := SetMetadataOpts(auth.metadataOpts); err != nil {
+		return nil, fmt.Errorf("invalid [Metadata]: %v", err)
+	}
+	instance, err := newOpenStack(auth)
+	if err != nil {
+		return nil, err
 	}
+	regions, err := newRegionOpenStacks(instance, auth.extraRegions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Init OpenStack
+	OsInstance = instance
+	OsRegionInstances = regions
+	osRegion = auth.epOpts.Region
 
 	return OsInstance, nil
 }
 
 // GetOpenStackProvider returns Openstack Instance
 func GetOpenStackProvider() (IOpenStack, error) {
+	osInstanceLock.Lock()
+	defer osInstanceLock.Unlock()
 
 	if OsInstance != nil {
 		return OsInstance, nil
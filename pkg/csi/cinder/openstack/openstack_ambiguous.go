@@ -0,0 +1,83 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"net"
+
+	"github.com/gophercloud/gophercloud"
+	"k8s.io/klog"
+)
+
+// isAmbiguous reports whether a failed mutating call may still have taken
+// effect: the request reached the cloud but no definite answer came back, as
+// with a 5xx from a proxy in front of the API or a timeout.
+func isAmbiguous(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case gophercloud.ErrDefault408:
+		return true
+	case gophercloud.ErrDefault500:
+		return true
+	case gophercloud.ErrDefault503:
+		return true
+	case gophercloud.ErrUnexpectedResponseCode:
+		return e.Actual >= 500
+	case *gophercloud.ErrUnexpectedResponseCode:
+		return e.Actual >= 500
+	case net.Error:
+		return e.Timeout()
+	}
+	return err == context.DeadlineExceeded
+}
+
+// verifyAmbiguous checks whether an operation that failed with err took
+// effect anyway. verify queries the cloud and reports whether the outcome is
+// the one the operation was after. The error is cleared when it is, and
+// returned as is otherwise so the caller retries as before, including when
+// the verification itself fails.
+func verifyAmbiguous(operation, target string, err error, verify func() (bool, error)) error {
+	if !isAmbiguous(err) {
+		return err
+	}
+
+	klog.V(3).Infof("%s %s failed with an ambiguous outcome, verifying: %v", operation, target, err)
+	done, verr := verify()
+	if verr != nil {
+		klog.Warningf("Failed to verify %s %s after an ambiguous failure: %v", operation, target, verr)
+		return err
+	}
+	if !done {
+		klog.V(3).Infof("%s %s did not take effect", operation, target)
+		return err
+	}
+
+	klog.Warningf("%s %s took effect despite the failure: %v", operation, target, err)
+	return nil
+}
+
+// IsTransient reports whether a call failed for a reason expected to pass,
+// as an ambiguous failure or rate limiting, so that retrying it later may
+// succeed.
+func IsTransient(err error) bool {
+	if _, ok := err.(gophercloud.ErrDefault429); ok {
+		return true
+	}
+	return isAmbiguous(err)
+}
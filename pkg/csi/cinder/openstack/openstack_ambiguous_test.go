@@ -0,0 +1,678 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/stretchr/testify/assert"
+)
+
+const fakeServerID = "server-1"
+
+type fakeVolume struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Status      string            `json:"status"`
+	Size        int               `json:"size"`
+	AZ          string            `json:"availability_zone"`
+	Metadata    map[string]string `json:"metadata"`
+	Attached    []fakeAttachment  `json:"attachments"`
+	Multiattach bool              `json:"multiattach"`
+	BackupID    string            `json:"backup_id,omitempty"`
+}
+
+type fakeBackup struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	VolumeID string            `json:"volume_id"`
+	Status   string            `json:"status"`
+	Size     int               `json:"size"`
+	Metadata map[string]string `json:"metadata"`
+	Force    bool              `json:"force"`
+}
+
+type fakeAttachment struct {
+	ID       string `json:"attachment_id"`
+	ServerID string `json:"server_id"`
+	Device   string `json:"device"`
+}
+
+type fakeSnapshot struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	VolumeID string            `json:"volume_id"`
+	Status   string            `json:"status"`
+	Size     int               `json:"size"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// fakeCinder is an in-memory Cinder and Nova volume attachment API which
+// answers a chosen call with a 504, as a proxy timing out in front of the
+// API would, either before or after the call took effect.
+type fakeCinder struct {
+	mu        sync.Mutex
+	next      int
+	volumes   map[string]*fakeVolume
+	snapshots map[string]*fakeSnapshot
+	backups   map[string]*fakeBackup
+	// volumeTypes are the names of the volume types by ID
+	volumeTypes map[string]string
+	// zones are whether the availability zones are available
+	zones map[string]bool
+	// deletedServers are the instances Nova no longer has
+	deletedServers map[string]bool
+	// servers are the names of the instances listed by ID
+	servers map[string]string
+
+	// failCall is the call answered with a 504.
+	failCall string
+	// applied is whether the failed call takes effect anyway.
+	applied bool
+	// failQueries answers all the GET requests following the failed call
+	// with a 504, so the outcome cannot be verified.
+	failQueries bool
+	failed      bool
+
+	// attachments are the volumeAttachment objects of the AttachVolume
+	// requests.
+	attachments []map[string]interface{}
+
+	// apiVersion is the OpenStack-API-Version header of the last request.
+	apiVersion string
+
+	// maxLimit is the osapi_max_limit of lists, 0 for no limit.
+	maxLimit int
+	// pages are the number of list pages served.
+	pages int
+}
+
+func newFakeCinder() *fakeCinder {
+	return &fakeCinder{
+		volumes:   map[string]*fakeVolume{},
+		snapshots: map[string]*fakeSnapshot{},
+		backups:   map[string]*fakeBackup{},
+	}
+}
+
+func (f *fakeCinder) newID(prefix string) string {
+	f.next++
+	return fmt.Sprintf("%s-%d", prefix, f.next)
+}
+
+// fakeCall names the API call of a request.
+func fakeCall(r *http.Request) string {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case parts[0] == "volumes" && (len(parts) == 1 || parts[1] == "detail"):
+		if r.Method == http.MethodPost {
+			return "CreateVolume"
+		}
+		return "ListVolumes"
+	case parts[0] == "volumes" && len(parts) == 3 && parts[2] == "action":
+		return "VolumeAction"
+	case parts[0] == "volumes":
+		if r.Method == http.MethodDelete {
+			return "DeleteVolume"
+		}
+		return "GetVolume"
+	case parts[0] == "servers" && len(parts) == 2 && parts[1] == "detail":
+		return "ListServers"
+	case parts[0] == "servers" && len(parts) == 2:
+		return "GetServer"
+	case parts[0] == "servers" && len(parts) == 3:
+		return "AttachVolume"
+	case parts[0] == "servers":
+		return "DetachVolume"
+	case parts[0] == "snapshots" && (len(parts) == 1 || parts[1] == "detail"):
+		if r.Method == http.MethodPost {
+			return "CreateSnapshot"
+		}
+		return "ListSnapshots"
+	case parts[0] == "snapshots":
+		if r.Method == http.MethodDelete {
+			return "DeleteSnapshot"
+		}
+		return "GetSnapshot"
+	case parts[0] == "backups" && (len(parts) == 1 || parts[1] == "detail"):
+		if r.Method == http.MethodPost {
+			return "CreateBackup"
+		}
+		return "ListBackups"
+	case parts[0] == "backups":
+		if r.Method == http.MethodDelete {
+			return "DeleteBackup"
+		}
+		return "GetBackup"
+	case parts[0] == "types":
+		return "ListVolumeTypes"
+	case parts[0] == "os-availability-zone":
+		return "ListAvailabilityZones"
+	}
+	return ""
+}
+
+func (f *fakeCinder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := fakeCall(r)
+	f.apiVersion = r.Header.Get("OpenStack-API-Version")
+	fail := name == f.failCall && !f.failed
+	if fail {
+		f.failed = true
+		if !f.applied {
+			http.Error(w, "gateway timeout", http.StatusGatewayTimeout)
+			return
+		}
+	}
+	if r.Method == http.MethodGet && f.failed && f.failQueries {
+		http.Error(w, "gateway timeout", http.StatusGatewayTimeout)
+		return
+	}
+
+	status, body := f.handle(name, r)
+	if fail {
+		status, body = http.StatusGatewayTimeout, nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body != nil {
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func (f *fakeCinder) handle(name string, r *http.Request) (int, interface{}) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	switch name {
+	case "CreateVolume":
+		var req struct {
+			Volume fakeVolume `json:"volume"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		v := req.Volume
+		v.ID = f.newID("volume")
+		v.Status = VolumeAvailableStatus
+		v.AZ = "nova"
+		f.volumes[v.ID] = &v
+		return http.StatusAccepted, map[string]interface{}{"volume": v}
+	case "ListVolumes":
+		var ids []string
+		for id, v := range f.volumes {
+			if n := query.Get("name"); n == "" || n == v.Name {
+				ids = append(ids, id)
+			}
+		}
+		vols := []fakeVolume{}
+		ids, links := f.page(r, ids)
+		for _, id := range ids {
+			vols = append(vols, *f.volumes[id])
+		}
+		return http.StatusOK, map[string]interface{}{"volumes": vols, "volumes_links": links}
+	case "GetVolume":
+		v, ok := f.volumes[parts[1]]
+		if !ok {
+			return http.StatusNotFound, nil
+		}
+		return http.StatusOK, map[string]interface{}{"volume": v}
+	case "DeleteVolume":
+		delete(f.volumes, parts[1])
+		return http.StatusAccepted, nil
+	case "AttachVolume":
+		var req struct {
+			Attachment map[string]interface{} `json:"volumeAttachment"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		f.attachments = append(f.attachments, req.Attachment)
+		volumeID, _ := req.Attachment["volumeId"].(string)
+		v, ok := f.volumes[volumeID]
+		if !ok {
+			return http.StatusNotFound, nil
+		}
+		v.Status = VolumeInUseStatus
+		v.Attached = append(v.Attached, fakeAttachment{ID: "attachment-" + parts[1], ServerID: parts[1], Device: "/dev/vdb"})
+		return http.StatusOK, map[string]interface{}{"volumeAttachment": map[string]string{
+			"id":       v.ID,
+			"serverId": parts[1],
+			"volumeId": v.ID,
+			"device":   "/dev/vdb",
+		}}
+	case "DetachVolume":
+		v, ok := f.volumes[parts[3]]
+		if !ok {
+			return http.StatusNotFound, nil
+		}
+		var attached []fakeAttachment
+		for _, a := range v.Attached {
+			if a.ServerID != parts[1] {
+				attached = append(attached, a)
+			}
+		}
+		v.Attached = attached
+		if len(attached) == 0 {
+			v.Status = VolumeAvailableStatus
+		}
+		return http.StatusAccepted, nil
+	case "VolumeAction":
+		v, ok := f.volumes[parts[1]]
+		if !ok {
+			return http.StatusNotFound, nil
+		}
+		var req struct {
+			ResetStatus *struct {
+				Status string `json:"status"`
+			} `json:"os-reset_status"`
+			ForceDetach *struct {
+				AttachmentID string `json:"attachment_id"`
+			} `json:"os-force_detach"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case req.ResetStatus != nil:
+			v.Status = req.ResetStatus.Status
+		case req.ForceDetach != nil:
+			var attached []fakeAttachment
+			for _, a := range v.Attached {
+				if a.ID != req.ForceDetach.AttachmentID {
+					attached = append(attached, a)
+				}
+			}
+			v.Attached = attached
+			if len(attached) == 0 {
+				v.Status = VolumeAvailableStatus
+			}
+		default:
+			return http.StatusBadRequest, nil
+		}
+		return http.StatusAccepted, nil
+	case "GetServer":
+		if f.deletedServers[parts[1]] {
+			return http.StatusNotFound, nil
+		}
+		return http.StatusOK, map[string]interface{}{"server": map[string]string{"id": parts[1], "status": "ACTIVE"}}
+	case "ListServers":
+		name, err := regexp.Compile(query.Get("name"))
+		if err != nil {
+			return http.StatusBadRequest, nil
+		}
+		found := []map[string]string{}
+		for id, n := range f.servers {
+			if name.MatchString(n) {
+				found = append(found, map[string]string{"id": id, "name": n, "OS-EXT-AZ:availability_zone": "nova"})
+			}
+		}
+		return http.StatusOK, map[string]interface{}{"servers": found}
+	case "CreateSnapshot":
+		var req struct {
+			Snapshot fakeSnapshot `json:"snapshot"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		s := req.Snapshot
+		s.ID = f.newID("snapshot")
+		s.Status = SnapshotReadyStatus
+		f.snapshots[s.ID] = &s
+		return http.StatusAccepted, map[string]interface{}{"snapshot": s}
+	case "ListSnapshots":
+		var ids []string
+		for id, s := range f.snapshots {
+			if n := query.Get("name"); n != "" && n != s.Name {
+				continue
+			}
+			if id := query.Get("volume_id"); id != "" && id != s.VolumeID {
+				continue
+			}
+			ids = append(ids, id)
+		}
+		snaps := []fakeSnapshot{}
+		ids, links := f.page(r, ids)
+		for _, id := range ids {
+			snaps = append(snaps, *f.snapshots[id])
+		}
+		return http.StatusOK, map[string]interface{}{"snapshots": snaps, "snapshots_links": links}
+	case "GetSnapshot":
+		s, ok := f.snapshots[parts[1]]
+		if !ok {
+			return http.StatusNotFound, nil
+		}
+		return http.StatusOK, map[string]interface{}{"snapshot": s}
+	case "DeleteSnapshot":
+		delete(f.snapshots, parts[1])
+		return http.StatusAccepted, nil
+	case "CreateBackup":
+		var req struct {
+			Backup fakeBackup `json:"backup"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		b := req.Backup
+		b.ID = f.newID("backup")
+		b.Status = BackupAvailableStatus
+		if v, ok := f.volumes[b.VolumeID]; ok {
+			b.Size = v.Size
+		}
+		f.backups[b.ID] = &b
+		// Only the ID and name are returned
+		return http.StatusAccepted, map[string]interface{}{"backup": map[string]string{"id": b.ID, "name": b.Name}}
+	case "ListBackups":
+		var ids []string
+		for id, b := range f.backups {
+			if n := query.Get("name"); n != "" && n != b.Name {
+				continue
+			}
+			if id := query.Get("volume_id"); id != "" && id != b.VolumeID {
+				continue
+			}
+			if s := query.Get("status"); s != "" && s != b.Status {
+				continue
+			}
+			ids = append(ids, id)
+		}
+		backups := []fakeBackup{}
+		ids, links := f.page(r, ids)
+		for _, id := range ids {
+			backups = append(backups, *f.backups[id])
+		}
+		return http.StatusOK, map[string]interface{}{"backups": backups, "backups_links": links}
+	case "GetBackup":
+		b, ok := f.backups[parts[1]]
+		if !ok {
+			return http.StatusNotFound, nil
+		}
+		return http.StatusOK, map[string]interface{}{"backup": b}
+	case "DeleteBackup":
+		delete(f.backups, parts[1])
+		return http.StatusAccepted, nil
+	case "ListVolumeTypes":
+		var ids []string
+		for id := range f.volumeTypes {
+			ids = append(ids, id)
+		}
+		types := []map[string]string{}
+		ids, links := f.page(r, ids)
+		for _, id := range ids {
+			types = append(types, map[string]string{"id": id, "name": f.volumeTypes[id]})
+		}
+		return http.StatusOK, map[string]interface{}{"volume_types": types, "volume_types_links": links}
+	case "ListAvailabilityZones":
+		zones := []map[string]interface{}{}
+		for name, available := range f.zones {
+			zones = append(zones, map[string]interface{}{
+				"zoneName":  name,
+				"zoneState": map[string]bool{"available": available},
+			})
+		}
+		return http.StatusOK, map[string]interface{}{"availabilityZoneInfo": zones}
+	}
+	return http.StatusNotFound, nil
+}
+
+// page returns the IDs of the page of a list after the marker of the request,
+// limited to maxLimit, and the link to the next page when there are more, as
+// Cinder does.
+func (f *fakeCinder) page(r *http.Request, ids []string) ([]string, []map[string]string) {
+	f.pages++
+	query := r.URL.Query()
+	sort.Strings(ids)
+	if marker := query.Get("marker"); marker != "" {
+		i := sort.SearchStrings(ids, marker)
+		if i < len(ids) && ids[i] == marker {
+			i++
+		}
+		ids = ids[i:]
+	}
+	limit := f.maxLimit
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && (limit == 0 || l < limit) {
+		limit = l
+	}
+	if limit == 0 || len(ids) <= limit {
+		return ids, nil
+	}
+	ids = ids[:limit]
+	next := fmt.Sprintf("http://%s%s?marker=%s&limit=%d", r.Host, r.URL.Path, ids[limit-1], limit)
+	return ids, []map[string]string{{"rel": "next", "href": next}}
+}
+
+func newFakeOpenStack(f *fakeCinder) (*OpenStack, func()) {
+	srv := httptest.NewServer(f)
+	client := &gophercloud.ServiceClient{
+		ProviderClient: &gophercloud.ProviderClient{},
+		Endpoint:       srv.URL + "/",
+	}
+	return &OpenStack{compute: client, blockstorage: client}, srv.Close
+}
+
+func TestAmbiguousOutcomes(t *testing.T) {
+	tags := map[string]string{"cinder.csi.openstack.org/cluster": "kubernetes"}
+
+	// Each operation starts from the volumes and snapshots it expects.
+	operations := []struct {
+		name  string
+		setup func(f *fakeCinder)
+		run   func(os *OpenStack) error
+	}{
+		{
+			name: "CreateVolume",
+			run: func(os *OpenStack) error {
+				id, _, size, err := os.CreateVolume("pvc-1", 2, "", "", "", "", &tags)
+				if err == nil && (id == "" || size != 2) {
+					return fmt.Errorf("unexpected volume %q of size %d", id, size)
+				}
+				return err
+			},
+		},
+		{
+			name: "DeleteVolume",
+			setup: func(f *fakeCinder) {
+				f.volumes["volume-0"] = &fakeVolume{ID: "volume-0", Name: "pvc-0", Status: VolumeAvailableStatus, Size: 1}
+			},
+			run: func(os *OpenStack) error {
+				return os.DeleteVolume("volume-0")
+			},
+		},
+		{
+			name: "AttachVolume",
+			setup: func(f *fakeCinder) {
+				f.volumes["volume-0"] = &fakeVolume{ID: "volume-0", Name: "pvc-0", Status: VolumeAvailableStatus, Size: 1}
+			},
+			run: func(os *OpenStack) error {
+				_, err := os.AttachVolume(fakeServerID, "volume-0")
+				return err
+			},
+		},
+		{
+			name: "DetachVolume",
+			setup: func(f *fakeCinder) {
+				f.volumes["volume-0"] = &fakeVolume{
+					ID:       "volume-0",
+					Name:     "pvc-0",
+					Status:   VolumeInUseStatus,
+					Size:     1,
+					Attached: []fakeAttachment{{ServerID: fakeServerID, Device: "/dev/vdb"}},
+				}
+			},
+			run: func(os *OpenStack) error {
+				return os.DetachVolume(fakeServerID, "volume-0")
+			},
+		},
+		{
+			name: "CreateSnapshot",
+			setup: func(f *fakeCinder) {
+				f.volumes["volume-0"] = &fakeVolume{ID: "volume-0", Name: "pvc-0", Status: VolumeAvailableStatus, Size: 1}
+			},
+			run: func(os *OpenStack) error {
+				snap, err := os.CreateSnapshot("snapshot-1", "volume-0", "", &tags)
+				if err == nil && snap.ID == "" {
+					return errors.New("snapshot without ID")
+				}
+				return err
+			},
+		},
+		{
+			name: "DeleteSnapshot",
+			setup: func(f *fakeCinder) {
+				f.snapshots["snapshot-0"] = &fakeSnapshot{ID: "snapshot-0", Name: "snapshot-0", VolumeID: "volume-0", Status: SnapshotReadyStatus, Size: 1}
+			},
+			run: func(os *OpenStack) error {
+				return os.DeleteSnapshot("snapshot-0")
+			},
+		},
+		{
+			name: "CreateBackup",
+			setup: func(f *fakeCinder) {
+				f.volumes["volume-0"] = &fakeVolume{ID: "volume-0", Name: "pvc-0", Status: VolumeAvailableStatus, Size: 1}
+			},
+			run: func(os *OpenStack) error {
+				backup, err := os.CreateBackup("snapshot-1", "volume-0", &tags)
+				if err == nil && backup.ID == "" {
+					return errors.New("backup without ID")
+				}
+				return err
+			},
+		},
+		{
+			name: "DeleteBackup",
+			setup: func(f *fakeCinder) {
+				f.backups["backup-0"] = &fakeBackup{ID: "backup-0", Name: "snapshot-0", VolumeID: "volume-0", Status: BackupAvailableStatus, Size: 1}
+			},
+			run: func(os *OpenStack) error {
+				return os.DeleteBackup("backup-0")
+			},
+		},
+	}
+
+	outcomes := []struct {
+		name        string
+		applied     bool
+		failQueries bool
+		expectError bool
+	}{
+		{name: "took effect", applied: true},
+		{name: "did not take effect", applied: false, expectError: true},
+		{name: "cannot be verified", applied: true, failQueries: true, expectError: true},
+	}
+
+	for _, op := range operations {
+		for _, outcome := range outcomes {
+			t.Run(op.name+" "+outcome.name, func(t *testing.T) {
+				f := newFakeCinder()
+				if op.setup != nil {
+					op.setup(f)
+				}
+				f.failCall = op.name
+				f.applied = outcome.applied
+				f.failQueries = outcome.failQueries
+
+				os, stop := newFakeOpenStack(f)
+				defer stop()
+
+				err := op.run(os)
+				assert.True(t, f.failed, "the call should have failed")
+				if outcome.expectError {
+					assert.Error(t, err)
+				} else {
+					assert.NoError(t, err)
+				}
+			})
+		}
+	}
+}
+
+// An attach failing ambiguously does not take effect for a volume attaching
+// to another instance.
+func TestAttachVolumeAmbiguousAttaching(t *testing.T) {
+	f := newFakeCinder()
+	f.volumes["volume-0"] = &fakeVolume{ID: "volume-0", Name: "pvc-0", Status: VolumeAttachingStatus, Size: 1}
+	f.failCall = "AttachVolume"
+
+	os, stop := newFakeOpenStack(f)
+	defer stop()
+
+	_, err := os.AttachVolume(fakeServerID, "volume-0")
+	assert.True(t, f.failed, "the call should have failed")
+	assert.Error(t, err)
+}
+
+func TestCreateVolumeAmbiguousDuplicates(t *testing.T) {
+	// A volume of the same name that predates the call makes the created one
+	// impossible to tell apart, the error is kept.
+	f := newFakeCinder()
+	f.volumes["volume-0"] = &fakeVolume{ID: "volume-0", Name: "pvc-1", Status: VolumeAvailableStatus, Size: 1}
+	f.failCall = "CreateVolume"
+	f.applied = true
+
+	os, stop := newFakeOpenStack(f)
+	defer stop()
+
+	_, _, _, err := os.CreateVolume("pvc-1", 1, "", "", "", "", nil)
+	assert.Error(t, err)
+}
+
+type fakeNetError struct{ timeout bool }
+
+func (e fakeNetError) Error() string   { return "network error" }
+func (e fakeNetError) Timeout() bool   { return e.timeout }
+func (e fakeNetError) Temporary() bool { return false }
+
+func TestIsAmbiguous(t *testing.T) {
+	unexpected := func(code int) gophercloud.ErrUnexpectedResponseCode {
+		return gophercloud.ErrUnexpectedResponseCode{Actual: code}
+	}
+
+	tests := []struct {
+		err       error
+		ambiguous bool
+	}{
+		{nil, false},
+		{errors.New("failed"), false},
+		{gophercloud.ErrDefault400{ErrUnexpectedResponseCode: unexpected(400)}, false},
+		{gophercloud.ErrDefault404{ErrUnexpectedResponseCode: unexpected(404)}, false},
+		{gophercloud.ErrDefault408{ErrUnexpectedResponseCode: unexpected(408)}, true},
+		{gophercloud.ErrDefault409{ErrUnexpectedResponseCode: unexpected(409)}, false},
+		{gophercloud.ErrDefault500{ErrUnexpectedResponseCode: unexpected(500)}, true},
+		{gophercloud.ErrDefault503{ErrUnexpectedResponseCode: unexpected(503)}, true},
+		{unexpected(502), true},
+		{unexpected(504), true},
+		{unexpected(413), false},
+		{fakeNetError{timeout: true}, true},
+		{fakeNetError{timeout: false}, false},
+		{context.DeadlineExceeded, true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.ambiguous, isAmbiguous(test.err), "%v", test.err)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, IsTransient(gophercloud.ErrDefault429{ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Actual: 429}}))
+	assert.True(t, IsTransient(gophercloud.ErrDefault503{ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Actual: 503}}))
+	assert.False(t, IsTransient(gophercloud.ErrDefault404{ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Actual: 404}}))
+	assert.False(t, IsTransient(nil))
+}
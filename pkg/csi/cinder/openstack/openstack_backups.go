@@ -0,0 +1,272 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog"
+)
+
+const (
+	BackupAvailableStatus = "available"
+	BackupCreatingStatus  = "creating"
+	BackupErrorStatus     = "error"
+	BackupDeletingStatus  = "deleting"
+	// backupMicroversion is the Cinder microversion of the metadata of
+	// backups, 3.43, and of the volumes created from a backup, 3.47
+	backupMicroversion = "3.47"
+)
+
+// Backup is a Cinder backup, stored by cinder-backup outside of the backend
+// of its volume
+type Backup struct {
+	// Unique identifier for the backup.
+	ID string
+	// Human-readable display name for the backup.
+	Name string
+	// ID of the volume the backup is of
+	VolumeID string
+	// Current status of the backup.
+	Status string
+	// Size of the volume the backup is of in GB
+	Size int
+	// Metadata of the backup
+	Metadata map[string]string
+	// Time the backup was created at
+	CreatedAt time.Time
+}
+
+// backup is a backup in the responses of Cinder
+type backup struct {
+	ID        string                          `json:"id"`
+	Name      string                          `json:"name"`
+	VolumeID  string                          `json:"volume_id"`
+	Status    string                          `json:"status"`
+	Size      int                             `json:"size"`
+	Metadata  map[string]string               `json:"metadata"`
+	CreatedAt gophercloud.JSONRFC3339MilliNoZ `json:"created_at"`
+}
+
+func (b backup) toBackup() Backup {
+	return Backup{
+		ID:        b.ID,
+		Name:      b.Name,
+		VolumeID:  b.VolumeID,
+		Status:    b.Status,
+		Size:      b.Size,
+		Metadata:  b.Metadata,
+		CreatedAt: time.Time(b.CreatedAt),
+	}
+}
+
+// backupRequestOpts are the options of the backup requests, which need
+// backupMicroversion.
+func backupRequestOpts(okCodes ...int) *gophercloud.RequestOpts {
+	return &gophercloud.RequestOpts{
+		OkCodes:     okCodes,
+		MoreHeaders: map[string]string{"OpenStack-API-Version": "volume " + backupMicroversion},
+	}
+}
+
+// CreateBackup issues a request to back up the volume volID, attached or
+// not, and returns the backup being created.
+func (os *OpenStack) CreateBackup(name, volID string, tags *map[string]string) (*Backup, error) {
+	opts := map[string]interface{}{
+		"name":        name,
+		"volume_id":   volID,
+		"description": volumeDescription,
+		// In-use volumes are only backed up when forced
+		"force": true,
+	}
+	var metadata map[string]string
+	if tags != nil {
+		metadata = *tags
+		opts["metadata"] = metadata
+	}
+
+	var body struct {
+		Backup backup `json:"backup"`
+	}
+	mc := newRequestMetric("backup_create")
+	_, err := os.blockstorage.Post(os.blockstorage.ServiceURL("backups"), map[string]interface{}{"backup": opts}, &body, backupRequestOpts(202))
+	if mc.observe(err) != nil {
+		var created *Backup
+		err = verifyAmbiguous("CreateBackup", name, err, func() (bool, error) {
+			backups, err := os.GetBackupsByNameAndVolumeID(name, volID)
+			if err != nil {
+				return false, err
+			}
+			if len(backups) != 1 {
+				return false, nil
+			}
+			created = &backups[0]
+			return true, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return created, nil
+	}
+
+	// Cinder only returns the ID and name of the new backup
+	b := body.Backup.toBackup()
+	b.VolumeID = volID
+	b.Status = BackupCreatingStatus
+	b.Metadata = metadata
+	return &b, nil
+}
+
+// ListBackups returns the available backups, of the volume volID if not "".
+func (os *OpenStack) ListBackups(volID string) ([]Backup, error) {
+	filters := url.Values{"status": []string{BackupAvailableStatus}}
+	if volID != "" {
+		filters.Set("volume_id", volID)
+	}
+	backups, err := os.listAllBackups(filters)
+	if err != nil {
+		klog.V(3).Infof("Failed to retrieve backups from Cinder: %v", err)
+		return nil, err
+	}
+	return backups, nil
+}
+
+// GetBackupsByNameAndVolumeID returns the backups named n of the volume
+// volumeID, whatever their status.
+func (os *OpenStack) GetBackupsByNameAndVolumeID(n string, volumeID string) ([]Backup, error) {
+	backups, err := os.listAllBackups(url.Values{"name": []string{n}, "volume_id": []string{volumeID}})
+	if err != nil {
+		klog.V(3).Infof("Failed to retrieve backups from Cinder: %v", err)
+		return nil, err
+	}
+	return backups, nil
+}
+
+// GetBackupByID returns the backup backupID.
+func (os *OpenStack) GetBackupByID(backupID string) (*Backup, error) {
+	var body struct {
+		Backup backup `json:"backup"`
+	}
+	mc := newRequestMetric("backup_get")
+	_, err := os.blockstorage.Get(os.blockstorage.ServiceURL("backups", backupID), &body, backupRequestOpts())
+	if mc.observe(err) != nil {
+		klog.V(3).Infof("Failed to get backup: %v", err)
+		return nil, err
+	}
+	b := body.Backup.toBackup()
+	return &b, nil
+}
+
+// DeleteBackup issues a request to delete the backup backupID.
+func (os *OpenStack) DeleteBackup(backupID string) error {
+	mc := newRequestMetric("backup_delete")
+	_, err := os.blockstorage.Delete(os.blockstorage.ServiceURL("backups", backupID), backupRequestOpts(202))
+	err = verifyAmbiguous("DeleteBackup", backupID, mc.observe(err), func() (bool, error) {
+		b, err := os.GetBackupByID(backupID)
+		if err != nil {
+			if cpoerrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		return b.Status == BackupDeletingStatus, nil
+	})
+	if err != nil {
+		klog.V(3).Infof("Failed to delete backup: %v", err)
+	}
+	return err
+}
+
+// CreateVolumeFromBackup creates a volume restored from the backup backupID,
+// see CreateVolume.
+func (os *OpenStack) CreateVolumeFromBackup(name string, size int, vtype, availability string, backupID string, tags *map[string]string) (string, string, int, error) {
+	opts := map[string]interface{}{
+		"name":        name,
+		"size":        size,
+		"description": volumeDescription,
+		"backup_id":   backupID,
+	}
+	if vtype != "" {
+		opts["volume_type"] = vtype
+	}
+	if availability != "" {
+		opts["availability_zone"] = availability
+	}
+	var metadata map[string]string
+	if tags != nil {
+		metadata = *tags
+		opts["metadata"] = metadata
+	}
+
+	var body struct {
+		Volume struct {
+			ID   string `json:"id"`
+			AZ   string `json:"availability_zone"`
+			Size int    `json:"size"`
+		} `json:"volume"`
+	}
+	mc := newRequestMetric("volume_create")
+	_, err := os.blockstorage.Post(os.blockstorage.ServiceURL("volumes"), map[string]interface{}{"volume": opts}, &body, backupRequestOpts(202))
+	if mc.observe(err) != nil {
+		var created *Volume
+		err = verifyAmbiguous("CreateVolume", name, err, func() (bool, error) {
+			var verr error
+			created, verr = os.findCreatedVolume(name, size, metadata)
+			return created != nil, verr
+		})
+		if err != nil {
+			return "", "", 0, err
+		}
+		return created.ID, created.AZ, created.Size, nil
+	}
+
+	return body.Volume.ID, body.Volume.AZ, body.Volume.Size, nil
+}
+
+// listAllBackups lists the backups matching filters on all the pages.
+func (os *OpenStack) listAllBackups(filters url.Values) ([]Backup, error) {
+	var all []Backup
+	err := listAllPages("backups", func(marker string, limit int) (listPage, error) {
+		query, err := markerQuery(filters.Encode(), marker, limit)
+		if err != nil {
+			return listPage{}, err
+		}
+		var body struct {
+			Backups []backup           `json:"backups"`
+			Links   []gophercloud.Link `json:"backups_links"`
+		}
+		mc := newRequestMetric("backup_list")
+		if _, err := os.blockstorage.Get(os.blockstorage.ServiceURL("backups", "detail")+query, &body, backupRequestOpts()); mc.observe(err) != nil {
+			return listPage{}, err
+		}
+		for _, b := range body.Backups {
+			all = append(all, b.toBackup())
+		}
+		if len(body.Backups) == 0 {
+			return listPage{}, nil
+		}
+		hasNext := false
+		for _, link := range body.Links {
+			hasNext = hasNext || link.Rel == "next"
+		}
+		return listPage{lastID: body.Backups[len(body.Backups)-1].ID, count: len(body.Backups), hasNext: hasNext}, nil
+	})
+	return all, err
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+// Backups are taken of attached volumes as well, with their metadata, and
+// volumes are restored from them.
+func TestBackups(t *testing.T) {
+	f := newFakeCinder()
+	os, stop := newFakeOpenStack(f)
+	defer stop()
+
+	tags := map[string]string{"cinder.csi.openstack.org/cluster": "kubernetes"}
+	volumeID, _, _, err := os.CreateVolume("pvc-1", 2, "", "", "", "", &tags)
+	assert.NoError(t, err)
+
+	backup, err := os.CreateBackup("snapshot-1", volumeID, &tags)
+	assert.NoError(t, err)
+	assert.Equal(t, "volume "+backupMicroversion, f.apiVersion)
+	assert.Equal(t, volumeID, backup.VolumeID)
+	if assert.Contains(t, f.backups, backup.ID) {
+		assert.True(t, f.backups[backup.ID].Force, "in-use volumes must be forced")
+		assert.Equal(t, tags, f.backups[backup.ID].Metadata)
+	}
+
+	backups, err := os.GetBackupsByNameAndVolumeID("snapshot-1", volumeID)
+	assert.NoError(t, err)
+	assert.Len(t, backups, 1)
+	backups, err = os.ListBackups("other-volume")
+	assert.NoError(t, err)
+	assert.Empty(t, backups)
+
+	got, err := os.GetBackupByID(backup.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, BackupAvailableStatus, got.Status)
+	assert.Equal(t, 2, got.Size)
+
+	restoredID, _, size, err := os.CreateVolumeFromBackup("pvc-2", 2, "", "", backup.ID, &tags)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, size)
+	if assert.Contains(t, f.volumes, restoredID) {
+		assert.Equal(t, backup.ID, f.volumes[restoredID].BackupID)
+	}
+
+	assert.NoError(t, os.DeleteBackup(backup.ID))
+	_, err = os.GetBackupByID(backup.ID)
+	assert.True(t, cpoerrors.IsNotFound(err))
+}
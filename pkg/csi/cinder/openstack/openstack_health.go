@@ -0,0 +1,49 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// CheckHealth verifies that Keystone and Cinder answer the client the
+// volume operations go through, rather than a new one, so that a client
+// unable to reach them fails the check.
+func (os *OpenStack) CheckHealth() error {
+	provider := os.blockstorage.ProviderClient
+	if provider.IdentityBase != "" {
+		// The version document of Keystone needs no token, it answers with
+		// 300 Multiple Choices on the root
+		mc := newRequestMetric("identity_versions_get")
+		_, err := provider.Request("GET", provider.IdentityBase, &gophercloud.RequestOpts{
+			OkCodes: []int{200, 300},
+		})
+		if mc.observe(err) != nil {
+			return fmt.Errorf("keystone at %s is unreachable: %v", provider.IdentityBase, err)
+		}
+	}
+
+	// Listing a single volume needs a valid token
+	mc := newRequestMetric("volume_list")
+	_, err := os.blockstorage.Get(os.blockstorage.ServiceURL("volumes")+"?limit=1", nil, nil)
+	if mc.observe(err) != nil {
+		return fmt.Errorf("cinder at %s is unreachable: %v", os.blockstorage.Endpoint, err)
+	}
+	return nil
+}
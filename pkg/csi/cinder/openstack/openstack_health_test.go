@@ -0,0 +1,52 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckHealth(t *testing.T) {
+	keystone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMultipleChoices)
+	}))
+	defer keystone.Close()
+
+	f := newFakeCinder()
+	os, stop := newFakeOpenStack(f)
+	os.blockstorage.ProviderClient.IdentityBase = keystone.URL + "/"
+
+	assert.NoError(t, os.CheckHealth())
+
+	// Cinder unreachable
+	stop()
+	assert.Error(t, os.CheckHealth())
+
+	// Keystone unreachable
+	os, stop = newFakeOpenStack(f)
+	defer stop()
+	os.blockstorage.ProviderClient.IdentityBase = keystone.URL + "/"
+	keystone.Close()
+	err := os.CheckHealth()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "keystone")
+	}
+}
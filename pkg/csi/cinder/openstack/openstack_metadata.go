@@ -1,31 +1,204 @@
 package openstack
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/availabilityzones"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/pagination"
+	"k8s.io/klog"
+
+	utilmetadata "k8s.io/cloud-provider-openstack/pkg/util/metadata"
 )
 
-const (
-	defaultMetadataVersion = "latest"
-	metadataURLTemplate    = "http://169.254.169.254/openstack/%s/meta_data.json"
+// MetadataOpts is the [Metadata] section of the cloud config
+type MetadataOpts struct {
+	// SearchOrder is where the metadata of the instance is read from, a
+	// comma separated list of configDrive, metadataService and nova
+	SearchOrder string `gcfg:"search-order"`
+	// CacheTTL is how long the metadata is cached, e.g. 1h, forever when
+	// unset
+	CacheTTL string `gcfg:"cache-ttl"`
+}
+
+// defaultMetadataSearchOrder is the search order without a search-order.
+// Nova is never looked up unless the search order asks for it, since a
+// lookup by name can find another instance of the same name.
+const defaultMetadataSearchOrder = utilmetadata.ConfigDriveID + "," + utilmetadata.MetadataID
+
+var (
+	// metadataSearchOrder is the search order of the metadata provider, see
+	// SetMetadataOpts
+	metadataSearchOrder = defaultMetadataSearchOrder
+	// metadataSearchOrderOverride replaces the search-order of the cloud
+	// config when set, see SetMetadataSearchOrder
+	metadataSearchOrderOverride string
 )
 
+func init() {
+	utilmetadata.SetNovaSource(novaMetadata)
+}
+
+// validateSearchOrder checks the elements of a metadata search order.
+func validateSearchOrder(order string) error {
+	for _, id := range strings.Split(order, ",") {
+		switch strings.TrimSpace(id) {
+		case utilmetadata.ConfigDriveID, utilmetadata.MetadataID, utilmetadata.NovaID:
+		default:
+			return fmt.Errorf("invalid search-order element %q, supported elements are %q, %q and %q", id, utilmetadata.ConfigDriveID, utilmetadata.MetadataID, utilmetadata.NovaID)
+		}
+	}
+	return nil
+}
+
+// SetMetadataSearchOrder makes the metadata provider read the metadata from
+// the sources of order, whatever the search-order of the cloud config. An
+// empty order leaves it to the cloud config.
+func SetMetadataSearchOrder(order string) error {
+	if order != "" {
+		if err := validateSearchOrder(order); err != nil {
+			return err
+		}
+		metadataSearchOrder = order
+	}
+	metadataSearchOrderOverride = order
+	return nil
+}
+
+// SetMetadataOpts makes the metadata provider read the metadata from the
+// sources of the search order of opts, and cache it for its cache-ttl.
+func SetMetadataOpts(opts MetadataOpts) error {
+	order := defaultMetadataSearchOrder
+	if opts.SearchOrder != "" {
+		if err := validateSearchOrder(opts.SearchOrder); err != nil {
+			return err
+		}
+		order = opts.SearchOrder
+	}
+	if metadataSearchOrderOverride != "" {
+		order = metadataSearchOrderOverride
+	}
+	var ttl time.Duration
+	if opts.CacheTTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(opts.CacheTTL)
+		if err != nil || ttl < 0 {
+			return fmt.Errorf("invalid cache-ttl %q", opts.CacheTTL)
+		}
+	}
+
+	metadataSearchOrder = order
+	utilmetadata.SetCacheTTL(ttl)
+	return nil
+}
+
+// novaServerName is the name of the instance looked up in Nova, see
+// SetNovaServerName
+var (
+	novaServerNameLock sync.Mutex
+	novaServerName     string
+)
+
+// SetNovaServerName sets the name the nova search order element looks the
+// instance up with, e.g. the name of the node. The short host name is used
+// when empty.
+func SetNovaServerName(name string) {
+	novaServerNameLock.Lock()
+	defer novaServerNameLock.Unlock()
+	novaServerName = name
+}
+
+// novaMetadata returns the metadata of the local instance from Nova, the
+// server named after the node, for the nova search order element. It
+// authenticates with a client of its own: it is called with the metadata
+// cache locked, which CreateOpenStackProvider locks as well.
+func novaMetadata() (*utilmetadata.Metadata, error) {
+	novaServerNameLock.Lock()
+	name := novaServerName
+	novaServerNameLock.Unlock()
+	if name == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		name = strings.SplitN(host, ".", 2)[0]
+	}
+
+	auth, err := loadAuthConfig()
+	if err != nil {
+		return nil, err
+	}
+	cloud, err := newOpenStack(auth)
+	if err != nil {
+		return nil, err
+	}
+	srv, err := getServerByName(cloud.compute, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up instance %s in Nova: %v", name, err)
+	}
+	klog.V(4).Infof("Got the metadata of %s from Nova: %s", name, srv.ID)
+	return &utilmetadata.Metadata{
+		UUID:             srv.ID,
+		Name:             srv.Name,
+		AvailabilityZone: srv.AvailabilityZone,
+	}, nil
+}
+
+// novaServer is a Nova server with its availability zone
+type novaServer struct {
+	servers.Server
+	availabilityzones.ServerAvailabilityZoneExt
+}
+
+// getServerByName returns the only server named name.
+func getServerByName(client *gophercloud.ServiceClient, name string) (*novaServer, error) {
+	opts := servers.ListOpts{
+		Name: fmt.Sprintf("^%s$", regexp.QuoteMeta(name)),
+	}
+	var found []novaServer
+	mc := newRequestMetric("server_list")
+	err := servers.List(client, opts).EachPage(func(page pagination.Page) (bool, error) {
+		var s []novaServer
+		if err := servers.ExtractServersInto(page, &s); err != nil {
+			return false, err
+		}
+		found = append(found, s...)
+		return len(found) <= 1, nil
+	})
+	if mc.observe(err) != nil {
+		return nil, err
+	}
+	switch len(found) {
+	case 0:
+		return nil, errors.New("no instance of that name")
+	case 1:
+		return &found[0], nil
+	}
+	return nil, errors.New("several instances of that name")
+}
+
 // IMetadata implements GetInstanceID & GetAvailabilityZone
 type IMetadata interface {
 	GetInstanceID() (string, error)
 	GetAvailabilityZone() (string, error)
 }
 
-type metadata struct {
-	UUID             string
-	AvailabilityZone string "json:\"availability_zone\""
-}
+type metadata struct{}
 
 // MetadataService instance of IMetadata
 var MetadataService IMetadata
 
+// ErrMetadataDisabled is returned by the metadata provider when the driver
+// does not run on a Nova instance.
+var ErrMetadataDisabled = errors.New("metadata service is disabled when running outside of an OpenStack instance")
+
 // GetMetadataProvider retrieves instance of IMetadata
 func GetMetadataProvider() (IMetadata, error) {
 
@@ -35,37 +208,29 @@ func GetMetadataProvider() (IMetadata, error) {
 	return MetadataService, nil
 }
 
-func getMetadata(metadataURL string) ([]byte, error) {
-	resp, err := http.Get(metadataURL)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, err
-	}
+// getMetaDataInfo returns the metadata of the instance from the sources of
+// the search order, cached.
+func getMetaDataInfo() (*utilmetadata.Metadata, error) {
+	return utilmetadata.Get(metadataSearchOrder)
+}
 
-	md, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	return md, nil
+// DisableMetadataProvider replaces the metadata provider with one failing
+// immediately instead of trying to reach the local metadata service.
+func DisableMetadataProvider() {
+	MetadataService = &disabledMetadata{}
 }
 
-// getMetaDataInfo retrieves from metadata service and returns
-// info in metadata struct
-func getMetaDataInfo() (metadata, error) {
-	metadataURL := fmt.Sprintf(metadataURLTemplate, defaultMetadataVersion)
-	var m metadata
-	md, err := getMetadata(metadataURL)
-	if err != nil {
-		return m, err
-	}
-	err = json.Unmarshal(md, &m)
-	if err != nil {
-		return m, err
-	}
-	return m, nil
+// disabledMetadata is the metadata provider used in external run mode
+type disabledMetadata struct{}
+
+// GetInstanceID always fails with ErrMetadataDisabled
+func (m *disabledMetadata) GetInstanceID() (string, error) {
+	return "", ErrMetadataDisabled
+}
+
+// GetAvailabilityZone always fails with ErrMetadataDisabled
+func (m *disabledMetadata) GetAvailabilityZone() (string, error) {
+	return "", ErrMetadataDisabled
 }
 
 // GetInstanceID from metadata service
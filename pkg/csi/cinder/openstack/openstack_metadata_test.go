@@ -0,0 +1,68 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	utilmetadata "k8s.io/cloud-provider-openstack/pkg/util/metadata"
+)
+
+func TestSetMetadataOpts(t *testing.T) {
+	defer func(order string) {
+		metadataSearchOrder = order
+		utilmetadata.SetCacheTTL(0)
+	}(metadataSearchOrder)
+
+	assert.NoError(t, SetMetadataOpts(MetadataOpts{}))
+	assert.Equal(t, "configDrive,metadataService", metadataSearchOrder)
+
+	assert.NoError(t, SetMetadataOpts(MetadataOpts{SearchOrder: "metadataService", CacheTTL: "1h"}))
+	assert.Equal(t, "metadataService", metadataSearchOrder)
+
+	for _, opts := range []MetadataOpts{{SearchOrder: "neutron"}, {CacheTTL: "1 hour"}, {CacheTTL: "-1h"}} {
+		assert.Error(t, SetMetadataOpts(opts), "%+v", opts)
+	}
+	assert.Equal(t, "metadataService", metadataSearchOrder)
+
+	// The search order of the flag wins over the cloud config
+	defer SetMetadataSearchOrder("")
+	assert.Error(t, SetMetadataSearchOrder("configDrive,neutron"))
+	assert.NoError(t, SetMetadataSearchOrder("configDrive,nova"))
+	assert.NoError(t, SetMetadataOpts(MetadataOpts{SearchOrder: "metadataService"}))
+	assert.Equal(t, "configDrive,nova", metadataSearchOrder)
+}
+
+func TestGetServerByName(t *testing.T) {
+	f := newFakeCinder()
+	f.servers = map[string]string{"server-1": "node-1", "server-2": "node-10", "server-3": "node-2", "server-4": "node-2"}
+	os, stop := newFakeOpenStack(f)
+	defer stop()
+
+	srv, err := getServerByName(os.compute, "node-1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, srv) {
+		assert.Equal(t, "server-1", srv.ID)
+		assert.Equal(t, "nova", srv.AvailabilityZone)
+	}
+
+	_, err = getServerByName(os.compute, "node-2")
+	assert.Error(t, err, "duplicate names are ambiguous")
+	_, err = getServerByName(os.compute, "node-3")
+	assert.Error(t, err)
+}
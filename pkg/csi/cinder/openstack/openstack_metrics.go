@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog"
+)
+
+const (
+	metricsSubsystem      = "cinder_csi"
+	requestDurationKey    = "openstack_api_request_duration_seconds"
+	requestErrorsKey      = "openstack_api_request_errors_total"
+	requestErrorCodeOther = "other"
+)
+
+var (
+	openstackRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: metricsSubsystem,
+			Name:      requestDurationKey,
+			Help:      "Latency of OpenStack API requests, failed ones included",
+		},
+		[]string{"request"},
+	)
+	openstackRequestErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: metricsSubsystem,
+			Name:      requestErrorsKey,
+			Help:      "Number of failed OpenStack API requests, by HTTP status code",
+		},
+		[]string{"request", "code"},
+	)
+)
+
+// RegisterMetrics registers the OpenStack API metrics with registerer.
+func RegisterMetrics(registerer prometheus.Registerer) {
+	if err := registerer.Register(openstackRequestDuration); err != nil {
+		klog.V(5).Infof("unable to register for OpenStack API latency metrics")
+	}
+	if err := registerer.Register(openstackRequestErrors); err != nil {
+		klog.V(5).Infof("unable to register for OpenStack API error metrics")
+	}
+}
+
+// requestMetric records an OpenStack API request, see newRequestMetric.
+type requestMetric struct {
+	request string
+	start   time.Time
+}
+
+// newRequestMetric starts recording the request, e.g. volume_create, made
+// right after.
+func newRequestMetric(request string) *requestMetric {
+	return &requestMetric{request: request, start: time.Now()}
+}
+
+// observe records the duration of the request, and its failure with err. It
+// returns err for the call to be chained.
+func (m *requestMetric) observe(err error) error {
+	openstackRequestDuration.WithLabelValues(m.request).Observe(time.Since(m.start).Seconds())
+	if err != nil {
+		openstackRequestErrors.WithLabelValues(m.request, requestErrorCode(err)).Inc()
+	}
+	return err
+}
+
+// requestErrorCode returns the HTTP status code a request failed with, other
+// when it got no response, e.g. on a timeout.
+func requestErrorCode(err error) string {
+	var code int
+	switch e := err.(type) {
+	case gophercloud.ErrDefault400:
+		code = e.Actual
+	case gophercloud.ErrDefault401:
+		code = e.Actual
+	case gophercloud.ErrDefault403:
+		code = e.Actual
+	case gophercloud.ErrDefault404:
+		code = e.Actual
+	case gophercloud.ErrDefault405:
+		code = e.Actual
+	case gophercloud.ErrDefault408:
+		code = e.Actual
+	case gophercloud.ErrDefault429:
+		code = e.Actual
+	case gophercloud.ErrDefault500:
+		code = e.Actual
+	case gophercloud.ErrDefault503:
+		code = e.Actual
+	case gophercloud.ErrUnexpectedResponseCode:
+		code = e.Actual
+	case *gophercloud.ErrUnexpectedResponseCode:
+		code = e.Actual
+	}
+	if code == 0 {
+		return requestErrorCodeOther
+	}
+	return strconv.Itoa(code)
+}
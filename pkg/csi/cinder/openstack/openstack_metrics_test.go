@@ -0,0 +1,81 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// metricValue returns the count of a histogram or the value of a counter.
+func metricValue(t *testing.T, m prometheus.Metric) float64 {
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		t.Fatalf("failed to read metric: %v", err)
+	}
+	if h := pb.GetHistogram(); h != nil {
+		return float64(h.GetSampleCount())
+	}
+	return pb.GetCounter().GetValue()
+}
+
+// Each OpenStack API request is timed, and counted by status code when it
+// fails.
+func TestRequestMetrics(t *testing.T) {
+	f := newFakeCinder()
+	os, stop := newFakeOpenStack(f)
+	defer stop()
+
+	created := openstackRequestDuration.WithLabelValues("volume_create").(prometheus.Metric)
+	got := openstackRequestDuration.WithLabelValues("volume_get").(prometheus.Metric)
+	notFound := openstackRequestErrors.WithLabelValues("volume_get", "404")
+	createdBefore, gotBefore, notFoundBefore := metricValue(t, created), metricValue(t, got), metricValue(t, notFound)
+
+	volumeID, _, _, err := os.CreateVolume("pvc-1", 1, "", "", "", "", nil)
+	assert.NoError(t, err)
+	_, err = os.GetVolume(volumeID)
+	assert.NoError(t, err)
+	_, err = os.GetVolume("missing")
+	assert.Error(t, err)
+
+	assert.Equal(t, createdBefore+1, metricValue(t, created))
+	assert.Equal(t, gotBefore+2, metricValue(t, got))
+	assert.Equal(t, notFoundBefore+1, metricValue(t, notFound))
+}
+
+func TestRequestErrorCode(t *testing.T) {
+	unexpected := gophercloud.ErrUnexpectedResponseCode{Actual: 504}
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{gophercloud.ErrDefault404{ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Actual: 404}}, "404"},
+		{gophercloud.ErrDefault503{ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Actual: 503}}, "503"},
+		{unexpected, "504"},
+		{&unexpected, "504"},
+		{fakeNetError{timeout: true}, requestErrorCodeOther},
+		{errors.New("failed"), requestErrorCodeOther},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.code, requestErrorCode(test.err), "%v", test.err)
+	}
+}
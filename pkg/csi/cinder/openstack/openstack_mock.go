@@ -18,16 +18,18 @@ package openstack
 
 import (
 	"strings"
+	"time"
 
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
 	"github.com/stretchr/testify/mock"
 )
 
 var fakeVol1 = Volume{
-	ID:     "261a8b81-3660-43e5-bab8-6470b65ee4e9",
-	Name:   "fake-duplicate",
-	Status: "available",
-	AZ:     "nova",
+	ID:       "261a8b81-3660-43e5-bab8-6470b65ee4e9",
+	Name:     "fake-duplicate",
+	Status:   "available",
+	AZ:       "nova",
+	Metadata: map[string]string{"cinder.csi.openstack.org/cluster": "cluster"},
 }
 
 var fakeVol2 = Volume{
@@ -46,6 +48,15 @@ var fakeSnapshot = snapshots.Snapshot{
 	Metadata: make(map[string]string),
 }
 
+var fakeEncryptedSnapshot = snapshots.Snapshot{
+	ID:       "261a8b81-3660-43e5-bab8-6470b65ee4e7",
+	Name:     "fake-encrypted-snapshot",
+	Status:   "available",
+	Size:     1,
+	VolumeID: "CSIVolumeID",
+	Metadata: map[string]string{"cinder.csi.openstack.org/encrypted": "true"},
+}
+
 // OpenStackMock is an autogenerated mock type for the IOpenStack type
 // ORIGINALLY GENERATED BY mockery with hand edits
 type OpenStackMock struct {
@@ -73,34 +84,34 @@ func (_m *OpenStackMock) AttachVolume(instanceID string, volumeID string) (strin
 	return r0, r1
 }
 
-// CreateVolume provides a mock function with given fields: name, size, vtype, availability, tags
-func (_m *OpenStackMock) CreateVolume(name string, size int, vtype string, availability string, snapshotID string, tags *map[string]string) (string, string, int, error) {
-	ret := _m.Called(name, size, vtype, availability, snapshotID, tags)
+// CreateVolume provides a mock function with given fields: name, size, vtype, availability, snapshotID, sourceVolID, tags
+func (_m *OpenStackMock) CreateVolume(name string, size int, vtype string, availability string, snapshotID string, sourceVolID string, tags *map[string]string) (string, string, int, error) {
+	ret := _m.Called(name, size, vtype, availability, snapshotID, sourceVolID, tags)
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(string, int, string, string, string, *map[string]string) string); ok {
-		r0 = rf(name, size, vtype, availability, snapshotID, tags)
+	if rf, ok := ret.Get(0).(func(string, int, string, string, string, string, *map[string]string) string); ok {
+		r0 = rf(name, size, vtype, availability, snapshotID, sourceVolID, tags)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 string
-	if rf, ok := ret.Get(1).(func(string, int, string, string, string, *map[string]string) string); ok {
-		r1 = rf(name, size, vtype, availability, snapshotID, tags)
+	if rf, ok := ret.Get(1).(func(string, int, string, string, string, string, *map[string]string) string); ok {
+		r1 = rf(name, size, vtype, availability, snapshotID, sourceVolID, tags)
 	} else {
 		r1 = ret.Get(1).(string)
 	}
 
 	var r2 int
-	if rf, ok := ret.Get(2).(func(string, int, string, string, string, *map[string]string) int); ok {
-		r2 = rf(name, size, vtype, availability, snapshotID, tags)
+	if rf, ok := ret.Get(2).(func(string, int, string, string, string, string, *map[string]string) int); ok {
+		r2 = rf(name, size, vtype, availability, snapshotID, sourceVolID, tags)
 	} else {
 		r2 = ret.Get(2).(int)
 	}
 
 	var r3 error
-	if rf, ok := ret.Get(3).(func(string, int, string, string, string, *map[string]string) error); ok {
-		r3 = rf(name, size, vtype, availability, snapshotID, tags)
+	if rf, ok := ret.Get(3).(func(string, int, string, string, string, string, *map[string]string) error); ok {
+		r3 = rf(name, size, vtype, availability, snapshotID, sourceVolID, tags)
 	} else {
 		r3 = ret.Error(3)
 	}
@@ -122,6 +133,174 @@ func (_m *OpenStackMock) DeleteVolume(volumeID string) error {
 	return r0
 }
 
+// GetVolume provides a mock function with given fields: volumeID
+func (_m *OpenStackMock) GetVolume(volumeID string) (Volume, error) {
+	ret := _m.Called(volumeID)
+
+	var r0 Volume
+	if rf, ok := ret.Get(0).(func(string) Volume); ok {
+		r0 = rf(volumeID)
+	} else {
+		r0 = ret.Get(0).(Volume)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(volumeID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ExpandVolume provides a mock function with given fields: volumeID, size
+func (_m *OpenStackMock) ExpandVolume(volumeID string, size int) error {
+	ret := _m.Called(volumeID, size)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int) error); ok {
+		r0 = rf(volumeID, size)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ResetVolumeStatus provides a mock function with given fields: volumeID, status
+func (_m *OpenStackMock) ResetVolumeStatus(volumeID string, status string) error {
+	ret := _m.Called(volumeID, status)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(volumeID, status)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ForceDetachVolume provides a mock function with given fields: volumeID, attachmentID
+func (_m *OpenStackMock) ForceDetachVolume(volumeID string, attachmentID string) error {
+	ret := _m.Called(volumeID, attachmentID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(volumeID, attachmentID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InstanceExists provides a mock function with given fields: instanceID
+func (_m *OpenStackMock) InstanceExists(instanceID string) (bool, error) {
+	ret := _m.Called(instanceID)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string) bool); ok {
+		r0 = rf(instanceID)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(instanceID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// VolumeTypeEncrypted provides a mock function with given fields: volumeType
+func (_m *OpenStackMock) VolumeTypeEncrypted(volumeType string) (bool, error) {
+	ret := _m.Called(volumeType)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string) bool); ok {
+		r0 = rf(volumeType)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(volumeType)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// VolumeTypeMultiattach provides a mock function with given fields: volumeType
+func (_m *OpenStackMock) VolumeTypeMultiattach(volumeType string) (bool, error) {
+	ret := _m.Called(volumeType)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string) bool); ok {
+		r0 = rf(volumeType)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(volumeType)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListVolumeTypes provides a mock function with given fields:
+func (_m *OpenStackMock) ListVolumeTypes() ([]VolumeType, error) {
+	ret := _m.Called()
+
+	var r0 []VolumeType
+	if rf, ok := ret.Get(0).(func() []VolumeType); ok {
+		r0 = rf()
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]VolumeType)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListAvailabilityZones provides a mock function with given fields:
+func (_m *OpenStackMock) ListAvailabilityZones() ([]string, error) {
+	ret := _m.Called()
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func() []string); ok {
+		r0 = rf()
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // DetachVolume provides a mock function with given fields: instanceID, volumeID
 func (_m *OpenStackMock) DetachVolume(instanceID string, volumeID string) error {
 	ret := _m.Called(instanceID, volumeID)
@@ -157,6 +336,78 @@ func (_m *OpenStackMock) GetAttachmentDiskPath(instanceID string, volumeID strin
 	return r0, r1
 }
 
+// InitializeConnection provides a mock function with given fields: volumeID, connector
+func (_m *OpenStackMock) InitializeConnection(volumeID string, connector ConnectorProperties) (*ConnectionInfo, error) {
+	ret := _m.Called(volumeID, connector)
+
+	var r0 *ConnectionInfo
+	if rf, ok := ret.Get(0).(func(string, ConnectorProperties) *ConnectionInfo); ok {
+		r0 = rf(volumeID, connector)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*ConnectionInfo)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, ConnectorProperties) error); ok {
+		r1 = rf(volumeID, connector)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// TerminateConnection provides a mock function with given fields: volumeID, connector
+func (_m *OpenStackMock) TerminateConnection(volumeID string, connector ConnectorProperties) error {
+	ret := _m.Called(volumeID, connector)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, ConnectorProperties) error); ok {
+		r0 = rf(volumeID, connector)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CheckHealth provides a mock function with given fields:
+func (_m *OpenStackMock) CheckHealth() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetSecretPayload provides a mock function with given fields: secretID
+func (_m *OpenStackMock) GetSecretPayload(secretID string) ([]byte, error) {
+	ret := _m.Called(secretID)
+
+	var r0 []byte
+	if rf, ok := ret.Get(0).(func(string) []byte); ok {
+		r0 = rf(secretID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]byte)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(secretID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // WaitDiskAttached provides a mock function with given fields: instanceID, volumeID
 func (_m *OpenStackMock) WaitDiskAttached(instanceID string, volumeID string) error {
 	ret := _m.Called(instanceID, volumeID)
@@ -192,10 +443,34 @@ func (_m *OpenStackMock) GetVolumesByName(name string) ([]Volume, error) {
 		vlist = append(vlist, fakeVol1)
 	}
 
-	if name == "fake-duplicate2x" {
+	switch name {
+	case "fake-duplicate2x":
 		vlist[0].Name = "fake-duplicate2x"
 		vlist = append(vlist, fakeVol2)
 		vlist[1].Name = "fake-duplicate2x"
+	case "fake-duplicate-other-cluster":
+		vlist[0].Metadata = map[string]string{"cinder.csi.openstack.org/cluster": "other-cluster"}
+	case "fake-duplicate-untagged":
+		vlist[0].Metadata = nil
+	case "fake-duplicate-parameters":
+		vlist[0].Size = 1
+		vlist[0].VolumeType = "fake-type"
+		vlist[0].SnapshotID = "261a8b81-3660-43e5-bab8-6470b65ee4e8"
+		vlist[0].Metadata = map[string]string{
+			"cinder.csi.openstack.org/cluster": "cluster",
+			// 1 GiB of fake-type in nova from the snapshot
+			"cinder.csi.openstack.org/parameters-hash": "481be2cfa977427e9d1a0afd97f7691448a81f87a31f223f11fa7429c0976589",
+		}
+	case "fake-duplicate-creating":
+		vlist[0].Status = VolumeCreatingStatus
+		vlist[0].CreatedAt = time.Now()
+	case "fake-duplicate-stuck":
+		vlist[0].Status = VolumeCreatingStatus
+		vlist[0].CreatedAt = time.Now().Add(-time.Hour)
+	case "fake-duplicate-stuck-other-cluster":
+		vlist[0].Status = VolumeCreatingStatus
+		vlist[0].CreatedAt = time.Now().Add(-time.Hour)
+		vlist[0].Metadata = map[string]string{"cinder.csi.openstack.org/cluster": "other-cluster"}
 	}
 	return vlist, nil
 }
@@ -264,6 +539,10 @@ func (_m *OpenStackMock) DeleteSnapshot(snapID string) error {
 func (_m *OpenStackMock) ListVolumes() ([]Volume, error) {
 	ret := _m.Called()
 	var vlist []Volume
+	if len(ret) == 2 {
+		vlist, _ = ret.Get(0).([]Volume)
+		return vlist, ret.Error(1)
+	}
 
 	var r0 error
 	if rf, ok := ret.Get(0).(func() error); ok {
@@ -304,8 +583,22 @@ func (_m *OpenStackMock) GetInstanceID() (string, error) {
 	return "", nil
 }
 
+// GetSnapshotByID provides a mock function with given fields: snapshotID,
+// returning the fake snapshots without expectations
 func (_m *OpenStackMock) GetSnapshotByID(snapshotID string) (*snapshots.Snapshot, error) {
-
+	for _, call := range _m.ExpectedCalls {
+		if call.Method == "GetSnapshotByID" {
+			ret := _m.Called(snapshotID)
+			var r0 *snapshots.Snapshot
+			if ret.Get(0) != nil {
+				r0 = ret.Get(0).(*snapshots.Snapshot)
+			}
+			return r0, ret.Error(1)
+		}
+	}
+	if snapshotID == fakeEncryptedSnapshot.ID {
+		return &fakeEncryptedSnapshot, nil
+	}
 	return &fakeSnapshot, nil
 }
 
@@ -321,3 +614,136 @@ func (_m *OpenStackMock) WaitSnapshotReady(snapshotID string) error {
 
 	return r0
 }
+
+// CreateBackup provides a mock function with given fields: name, volID, tags
+func (_m *OpenStackMock) CreateBackup(name string, volID string, tags *map[string]string) (*Backup, error) {
+	ret := _m.Called(name, volID, tags)
+
+	var r0 *Backup
+	if rf, ok := ret.Get(0).(func(string, string, *map[string]string) *Backup); ok {
+		r0 = rf(name, volID, tags)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*Backup)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, *map[string]string) error); ok {
+		r1 = rf(name, volID, tags)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListBackups provides a mock function with given fields: volID
+func (_m *OpenStackMock) ListBackups(volID string) ([]Backup, error) {
+	ret := _m.Called(volID)
+
+	var r0 []Backup
+	if rf, ok := ret.Get(0).(func(string) []Backup); ok {
+		r0 = rf(volID)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]Backup)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(volID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetBackupsByNameAndVolumeID provides a mock function with given fields: n, volumeID
+func (_m *OpenStackMock) GetBackupsByNameAndVolumeID(n string, volumeID string) ([]Backup, error) {
+	ret := _m.Called(n, volumeID)
+
+	var r0 []Backup
+	if rf, ok := ret.Get(0).(func(string, string) []Backup); ok {
+		r0 = rf(n, volumeID)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]Backup)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string) error); ok {
+		r1 = rf(n, volumeID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetBackupByID provides a mock function with given fields: backupID
+func (_m *OpenStackMock) GetBackupByID(backupID string) (*Backup, error) {
+	ret := _m.Called(backupID)
+
+	var r0 *Backup
+	if rf, ok := ret.Get(0).(func(string) *Backup); ok {
+		r0 = rf(backupID)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).(*Backup)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(backupID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteBackup provides a mock function with given fields: backupID
+func (_m *OpenStackMock) DeleteBackup(backupID string) error {
+	ret := _m.Called(backupID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(backupID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateVolumeFromBackup provides a mock function with given fields: name, size, vtype, availability, backupID, tags
+func (_m *OpenStackMock) CreateVolumeFromBackup(name string, size int, vtype string, availability string, backupID string, tags *map[string]string) (string, string, int, error) {
+	ret := _m.Called(name, size, vtype, availability, backupID, tags)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, int, string, string, string, *map[string]string) string); ok {
+		r0 = rf(name, size, vtype, availability, backupID, tags)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(string, int, string, string, string, *map[string]string) string); ok {
+		r1 = rf(name, size, vtype, availability, backupID, tags)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 int
+	if rf, ok := ret.Get(2).(func(string, int, string, string, string, *map[string]string) int); ok {
+		r2 = rf(name, size, vtype, availability, backupID, tags)
+	} else {
+		r2 = ret.Get(2).(int)
+	}
+
+	var r3 error
+	if rf, ok := ret.Get(3).(func(string, int, string, string, string, *map[string]string) error); ok {
+		r3 = rf(name, size, vtype, availability, backupID, tags)
+	} else {
+		r3 = ret.Error(3)
+	}
+
+	return r0, r1, r2, r3
+}
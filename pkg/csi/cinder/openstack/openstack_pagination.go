@@ -0,0 +1,174 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/pagination"
+)
+
+const (
+	// listPageLimit is the number of items requested per page, Cinder
+	// returns at most its osapi_max_limit, 1000 by default
+	listPageLimit = 1000
+	// maxListPages is the safety cap on the number of pages of a list
+	maxListPages = 1000
+)
+
+// listPage is a page of a Cinder list.
+type listPage struct {
+	// lastID is the ID of the last item of the page, the marker of the next
+	// page
+	lastID string
+	count  int
+	// hasNext is whether Cinder linked a next page
+	hasNext bool
+}
+
+// listAllPages lists all the pages of a Cinder collection, requesting the
+// page after marker with fetch until a page is not full and has no next link.
+// Cinder only returns the first osapi_max_limit items of a list, and not
+// every page type of gophercloud follows the next links, so the pages are
+// requested by marker.
+func listAllPages(resource string, fetch func(marker string, limit int) (listPage, error)) error {
+	marker := ""
+	for i := 0; i < maxListPages; i++ {
+		page, err := fetch(marker, listPageLimit)
+		if err != nil {
+			return err
+		}
+		if page.count == 0 || (page.count < listPageLimit && !page.hasNext) {
+			return nil
+		}
+		marker = page.lastID
+	}
+	return fmt.Errorf("listing %s took more than %d pages", resource, maxListPages)
+}
+
+// firstPage returns the first page of pager, without following the next
+// links.
+func firstPage(pager pagination.Pager) (pagination.Page, error) {
+	var first pagination.Page
+	err := pager.EachPage(func(page pagination.Page) (bool, error) {
+		first = page
+		return false, nil
+	})
+	return first, err
+}
+
+// hasNextLink returns whether the body of a page links a next page in its
+// <collection>_links.
+func hasNextLink(page pagination.Page, collection string) bool {
+	body, ok := page.GetBody().(map[string]interface{})
+	if !ok {
+		return false
+	}
+	links, _ := body[collection+"_links"].([]interface{})
+	for _, link := range links {
+		if l, ok := link.(map[string]interface{}); ok && l["rel"] == "next" {
+			return true
+		}
+	}
+	return false
+}
+
+// markerQuery adds the limit and marker of a page to a list query.
+func markerQuery(query string, marker string, limit int) (string, error) {
+	values, err := url.ParseQuery(strings.TrimPrefix(query, "?"))
+	if err != nil {
+		return "", err
+	}
+	values.Set("limit", strconv.Itoa(limit))
+	if marker != "" {
+		values.Set("marker", marker)
+	}
+	return "?" + values.Encode(), nil
+}
+
+// volumeListPage lists a page of volumes after marker.
+type volumeListPage struct {
+	volumes.ListOpts
+	marker string
+	limit  int
+}
+
+func (opts volumeListPage) ToVolumeListQuery() (string, error) {
+	query, err := opts.ListOpts.ToVolumeListQuery()
+	if err != nil {
+		return "", err
+	}
+	return markerQuery(query, opts.marker, opts.limit)
+}
+
+// listAllVolumes lists the volumes matching opts on all the pages.
+func (os *OpenStack) listAllVolumes(opts volumes.ListOpts) ([]volumes.Volume, error) {
+	var all []volumes.Volume
+	err := listAllPages("volumes", func(marker string, limit int) (listPage, error) {
+		mc := newRequestMetric("volume_list")
+		page, err := firstPage(volumes.List(os.blockstorage, volumeListPage{ListOpts: opts, marker: marker, limit: limit}))
+		if mc.observe(err) != nil || page == nil {
+			return listPage{}, err
+		}
+		vols, err := volumes.ExtractVolumes(page)
+		if err != nil || len(vols) == 0 {
+			return listPage{}, err
+		}
+		all = append(all, vols...)
+		return listPage{lastID: vols[len(vols)-1].ID, count: len(vols), hasNext: hasNextLink(page, "volumes")}, nil
+	})
+	return all, err
+}
+
+// snapshotListPage lists a page of snapshots after marker.
+type snapshotListPage struct {
+	snapshots.ListOpts
+	marker string
+	limit  int
+}
+
+func (opts snapshotListPage) ToSnapshotListQuery() (string, error) {
+	query, err := opts.ListOpts.ToSnapshotListQuery()
+	if err != nil {
+		return "", err
+	}
+	return markerQuery(query, opts.marker, opts.limit)
+}
+
+// listAllSnapshots lists the snapshots matching opts on all the pages.
+func (os *OpenStack) listAllSnapshots(opts snapshots.ListOpts) ([]snapshots.Snapshot, error) {
+	var all []snapshots.Snapshot
+	err := listAllPages("snapshots", func(marker string, limit int) (listPage, error) {
+		mc := newRequestMetric("snapshot_list")
+		page, err := firstPage(snapshots.List(os.blockstorage, snapshotListPage{ListOpts: opts, marker: marker, limit: limit}))
+		if mc.observe(err) != nil || page == nil {
+			return listPage{}, err
+		}
+		snaps, err := snapshots.ExtractSnapshots(page)
+		if err != nil || len(snaps) == 0 {
+			return listPage{}, err
+		}
+		all = append(all, snaps...)
+		return listPage{lastID: snaps[len(snaps)-1].ID, count: len(snaps), hasNext: hasNextLink(page, "snapshots")}, nil
+	})
+	return all, err
+}
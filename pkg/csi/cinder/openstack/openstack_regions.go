@@ -0,0 +1,95 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// OsRegionInstances are the OpenStack instances of the extra regions of the
+// config, by region. They share the token of OsInstance, Keystone tokens are
+// valid in every region.
+var OsRegionInstances map[string]IOpenStack
+
+// osRegion is the region of OsInstance
+var osRegion string
+
+// ErrUnknownRegion is returned for a region the config does not serve.
+type ErrUnknownRegion struct {
+	Region string
+}
+
+func (e ErrUnknownRegion) Error() string {
+	return fmt.Sprintf("region %q is neither the region nor an extra-region of the OpenStack configuration", e.Region)
+}
+
+// newRegionOpenStacks returns the OpenStack instances of regions, with the
+// clients of the services of the provider of os in each.
+func newRegionOpenStacks(os *OpenStack, regions []string) (map[string]IOpenStack, error) {
+	instances := map[string]IOpenStack{}
+	for _, region := range regions {
+		instance, err := newServiceClients(os.blockstorage.ProviderClient, gophercloud.EndpointOpts{Region: region})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the clients of region %s: %v", region, err)
+		}
+		instances[region] = instance
+	}
+	return instances, nil
+}
+
+// GetOpenStackProviderForRegion returns the OpenStack instance of region,
+// the one of GetOpenStackProvider for the region of the config or an empty
+// one.
+func GetOpenStackProviderForRegion(region string) (IOpenStack, error) {
+	cloud, err := GetOpenStackProvider()
+	if err != nil {
+		return nil, err
+	}
+	if region == "" {
+		return cloud, nil
+	}
+
+	osInstanceLock.Lock()
+	defer osInstanceLock.Unlock()
+	if instance, ok := OsRegionInstances[region]; ok {
+		return instance, nil
+	}
+	if region == osRegion {
+		return cloud, nil
+	}
+	return nil, ErrUnknownRegion{Region: region}
+}
+
+// GetExtraRegions returns the extra regions of the config, sorted, none when
+// the driver serves a single region.
+func GetExtraRegions() ([]string, error) {
+	if _, err := GetOpenStackProvider(); err != nil {
+		return nil, err
+	}
+
+	osInstanceLock.Lock()
+	defer osInstanceLock.Unlock()
+	var regions []string
+	for region := range OsRegionInstances {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	return regions, nil
+}
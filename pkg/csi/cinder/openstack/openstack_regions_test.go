@@ -0,0 +1,70 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetConfigFromFileExtraRegions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloud-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "cloud.conf")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`
+[Global]
+auth-url=`+fakeAuthUrl+`
+region=`+fakeRegion+`
+extra-region=RegionTwo
+extra-region=RegionThree
+`), 0600))
+
+	cfg, epOpts, err := GetConfigFromFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, fakeRegion, epOpts.Region)
+	assert.Equal(t, []string{"RegionTwo", "RegionThree"}, cfg.Global.ExtraRegion)
+}
+
+func TestGetOpenStackProviderForRegion(t *testing.T) {
+	savedInstance, savedRegions, savedRegion := OsInstance, OsRegionInstances, osRegion
+	defer func() {
+		OsInstance, OsRegionInstances, osRegion = savedInstance, savedRegions, savedRegion
+	}()
+	one, two, three := new(OpenStackMock), new(OpenStackMock), new(OpenStackMock)
+	OsInstance = one
+	OsRegionInstances = map[string]IOpenStack{"RegionTwo": two, "RegionThree": three}
+	osRegion = fakeRegion
+
+	for region, expected := range map[string]IOpenStack{"": one, fakeRegion: one, "RegionTwo": two, "RegionThree": three} {
+		cloud, err := GetOpenStackProviderForRegion(region)
+		assert.NoError(t, err)
+		assert.True(t, cloud == expected, "region %q", region)
+	}
+
+	_, err := GetOpenStackProviderForRegion("RegionFour")
+	assert.Equal(t, ErrUnknownRegion{Region: "RegionFour"}, err)
+
+	regions, err := GetExtraRegions()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"RegionThree", "RegionTwo"}, regions)
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"os"
+	"time"
+
+	"k8s.io/cloud-provider-openstack/pkg/util/configwatch"
+	"k8s.io/klog"
+)
+
+// reloadOpenStack builds the OpenStack instance of a changed config.
+// Replaced in the tests.
+var reloadOpenStack = newOpenStack
+
+// WatchConfig checks every interval, until stop is closed, whether the
+// config file or the clouds.yaml and secure.yaml it reads changed, e.g. when
+// the Secret they are mounted from is rotated, and reloads the OpenStack
+// instance then, see ReloadOpenStackProvider.
+func WatchConfig(interval time.Duration, stop <-chan struct{}) {
+	paths := []string{configFile, os.Getenv("OS_CLIENT_CONFIG_FILE"), os.Getenv("OS_CLIENT_SECURE_FILE")}
+	configwatch.Watch(interval, stop, paths, ReloadOpenStackProvider)
+}
+
+// ReloadOpenStackProvider authenticates with the current config and replaces
+// the OpenStack instances, those of the extra regions included, with ones of
+// the new clients. The previous instances are kept when that fails. The
+// operations in progress keep the previous instances, whose client is
+// reauthenticated with the new config as well, so that they also go on with
+// a token of the new credentials, e.g. when changing the password revoked
+// the tokens of the previous one.
+func ReloadOpenStackProvider() error {
+	auth, err := loadAuthConfig()
+	if err != nil {
+		return err
+	}
+	next, err := reloadOpenStack(auth)
+	if err != nil {
+		return err
+	}
+	regions, err := newRegionOpenStacks(next, auth.extraRegions)
+	if err != nil {
+		return err
+	}
+
+	osInstanceLock.Lock()
+	prev := OsInstance
+	OsInstance = next
+	OsRegionInstances = regions
+	osRegion = auth.epOpts.Region
+	osInstanceLock.Unlock()
+	klog.Infof("Reloaded the OpenStack configuration of %s", auth.opts.IdentityEndpoint)
+
+	if prev, ok := prev.(*OpenStack); ok && prev != nil && prev.blockstorage != nil {
+		if err := authenticate(prev.blockstorage.ProviderClient, auth.opts, auth.trustID); err != nil {
+			klog.Warningf("Failed to reauthenticate the operations in progress with the new configuration: %v", err)
+		}
+	}
+	return nil
+}
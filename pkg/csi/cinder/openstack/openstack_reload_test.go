@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// A rotated config replaces the OpenStack instance, a config failing to
+// authenticate keeps the previous one until fixed.
+func TestWatchConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloud-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cloud.conf")
+	// Replaced at once like a mounted Secret
+	writeConfig := func(password string) {
+		content := "[Global]\nauth-url=" + fakeAuthUrl + "\nusername=" + fakeUserName + "\npassword=" + password + "\n"
+		assert.NoError(t, ioutil.WriteFile(file+".tmp", []byte(content), 0600))
+		assert.NoError(t, os.Rename(file+".tmp", file))
+	}
+	writeConfig("old")
+
+	savedFile, savedInstance, savedRegions, savedReload := configFile, OsInstance, OsRegionInstances, reloadOpenStack
+	defer func() {
+		configFile, OsInstance, OsRegionInstances, reloadOpenStack = savedFile, savedInstance, savedRegions, savedReload
+	}()
+	InitOpenStackProvider(file)
+	previous := new(OpenStackMock)
+	OsInstance = previous
+
+	// A failed reload is retried on every check
+	reloaded := make(chan string, 1)
+	reloadOpenStack = func(auth authConfig) (*OpenStack, error) {
+		select {
+		case reloaded <- auth.opts.Password:
+		default:
+		}
+		if auth.opts.Password == "wrong" {
+			return nil, errors.New("authentication failed")
+		}
+		return &OpenStack{}, nil
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	WatchConfig(time.Millisecond, stop)
+
+	// Nothing changed yet
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, reloaded, 0)
+
+	writeConfig("wrong")
+	assert.Equal(t, "wrong", <-reloaded)
+	cloud, err := GetOpenStackProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, previous, cloud)
+
+	writeConfig("new")
+	err = wait.PollImmediate(time.Millisecond, time.Second, func() (bool, error) {
+		cloud, err := GetOpenStackProvider()
+		return cloud != IOpenStack(previous), err
+	})
+	assert.NoError(t, err)
+	cloud, _ = GetOpenStackProvider()
+	assert.Equal(t, &OpenStack{}, cloud)
+}
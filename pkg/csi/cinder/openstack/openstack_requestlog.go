@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"net/http"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// requestIDHeaders are the response headers the OpenStack services return
+// the ID of a request in, the ID their own logs are tagged with
+var requestIDHeaders = []string{"X-Openstack-Request-Id", "X-Compute-Request-Id", "X-Request-Id"}
+
+// responseRequestID returns the OpenStack request ID of resp, "" when it has
+// none.
+func responseRequestID(resp *http.Response) string {
+	for _, h := range requestIDHeaders {
+		if id := resp.Header.Get(h); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// requestLogger wraps rt to log the OpenStack request ID of every response,
+// for the requests of an RPC to be found in the logs of the cloud. Failed
+// requests are logged at level 2, the others at level 4. A nil rt uses
+// http.DefaultTransport.
+func requestLogger(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &requestLogRoundTripper{rt: rt}
+}
+
+type requestLogRoundTripper struct {
+	rt http.RoundTripper
+}
+
+func (r *requestLogRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := r.rt.RoundTrip(req)
+	if err != nil {
+		klog.V(2).Infof("OpenStack request %s %s failed after %v: %v", req.Method, req.URL.Path, time.Since(start), err)
+		return resp, err
+	}
+	level := klog.Level(4)
+	if resp.StatusCode >= http.StatusBadRequest {
+		level = 2
+	}
+	klog.V(level).Infof("OpenStack request %s %s: %d in %v, request ID %s", req.Method, req.URL.Path, resp.StatusCode, time.Since(start), responseRequestID(resp))
+	return resp, nil
+}
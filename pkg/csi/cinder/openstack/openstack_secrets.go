@@ -0,0 +1,36 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/secrets"
+)
+
+// GetSecretPayload returns the payload of the Barbican secret secretID.
+func (os *OpenStack) GetSecretPayload(secretID string) ([]byte, error) {
+	if os.keymanager == nil {
+		return nil, fmt.Errorf("cannot get secret %s, the cloud has no key manager", secretID)
+	}
+	opts := secrets.GetPayloadOpts{
+		PayloadContentType: "application/octet-stream",
+	}
+	mc := newRequestMetric("secret_payload_get")
+	payload, err := secrets.GetPayload(os.keymanager, secretID, opts).Extract()
+	return payload, mc.observe(err)
+}
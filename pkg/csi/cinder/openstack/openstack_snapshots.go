@@ -24,14 +24,16 @@ import (
 
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
 	"k8s.io/apimachinery/pkg/util/wait"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
 	"k8s.io/klog"
 )
 
 const (
-	SnapshotReadyStatus = "available"
-	snapReadyDuration   = 1 * time.Second
-	snapReadyFactor     = 1.2
-	snapReadySteps      = 10
+	SnapshotReadyStatus    = "available"
+	SnapshotDeletingStatus = "deleting"
+	snapReadyDuration      = 1 * time.Second
+	snapReadyFactor        = 1.2
+	snapReadySteps         = 10
 )
 
 // CreateSnapshot issues a request to take a Snapshot of the specified Volume with the corresponding ID and
@@ -47,9 +49,23 @@ func (os *OpenStack) CreateSnapshot(name, volID, description string, tags *map[s
 	}
 	// TODO: Do some check before really call openstack API on the input
 
+	mc := newRequestMetric("snapshot_create")
 	snap, err := snapshots.Create(os.blockstorage, opts).Extract()
-	if err != nil {
-		return &snapshots.Snapshot{}, err
+	if mc.observe(err) != nil {
+		err = verifyAmbiguous("CreateSnapshot", name, err, func() (bool, error) {
+			snaps, err := os.GetSnapshotByNameAndVolumeID(name, volID)
+			if err != nil {
+				return false, err
+			}
+			if len(snaps) != 1 {
+				return false, nil
+			}
+			snap = &snaps[0]
+			return true, nil
+		})
+		if err != nil {
+			return &snapshots.Snapshot{}, err
+		}
 	}
 	// There's little value in rewrapping these gophercloud types into yet another abstraction/type, instead just
 	// return the gophercloud item
@@ -60,18 +76,23 @@ func (os *OpenStack) CreateSnapshot(name, volID, description string, tags *map[s
 // provide the ability to provide limit and offset to enable the consumer to provide accurate pagination.
 // In addition the filters argument provides a mechanism for passing in valid filter strings to the list
 // operation.  Valid filter keys are:  Name, Status, VolumeID (TenantID has no effect)
+// A limit of 0 returns all the snapshots from offset.
 func (os *OpenStack) ListSnapshots(limit, offset int, filters map[string]string) ([]snapshots.Snapshot, error) {
-	// FIXME: honor the limit, offset and filters later
-	opts := snapshots.ListOpts{Status: SnapshotReadyStatus}
-	pages, err := snapshots.List(os.blockstorage, opts).AllPages()
+	opts := snapshots.ListOpts{Status: SnapshotReadyStatus, Name: filters["Name"], VolumeID: filters["VolumeID"]}
+	if s, ok := filters["Status"]; ok {
+		opts.Status = s
+	}
+	snaps, err := os.listAllSnapshots(opts)
 	if err != nil {
 		klog.V(3).Infof("Failed to retrieve snapshots from Cinder: %v", err)
 		return nil, err
 	}
-	snaps, err := snapshots.ExtractSnapshots(pages)
-	if err != nil {
-		klog.V(3).Infof("Failed to extract snapshot pages from Cinder: %v", err)
-		return nil, err
+	if offset >= len(snaps) {
+		return nil, nil
+	}
+	snaps = snaps[offset:]
+	if limit > 0 && len(snaps) > limit {
+		snaps = snaps[:limit]
 	}
 	// There's little value in rewrapping these gophercloud types into yet another abstraction/type, instead just
 	// return the gophercloud item
@@ -83,16 +104,11 @@ func (os *OpenStack) ListSnapshots(limit, offset int, filters map[string]string)
 // Returns a list of Volume references with the specified name
 func (os *OpenStack) GetSnapshotByNameAndVolumeID(n string, volumeId string) ([]snapshots.Snapshot, error) {
 	opts := snapshots.ListOpts{Name: n, VolumeID: volumeId}
-	pages, err := snapshots.List(os.blockstorage, opts).AllPages()
+	snaps, err := os.listAllSnapshots(opts)
 	if err != nil {
 		klog.V(3).Infof("Failed to retrieve snapshots from Cinder: %v", err)
 		return nil, err
 	}
-	snaps, err := snapshots.ExtractSnapshots(pages)
-	if err != nil {
-		klog.V(3).Infof("Failed to extract snapshot pages from Cinder: %v", err)
-		return nil, err
-	}
 	// There's little value in rewrapping these gophercloud types into yet another abstraction/type, instead just
 	// return the gophercloud item
 	return snaps, nil
@@ -100,7 +116,19 @@ func (os *OpenStack) GetSnapshotByNameAndVolumeID(n string, volumeId string) ([]
 
 // DeleteSnapshot issues a request to delete the Snapshot with the specified ID from the Cinder backend
 func (os *OpenStack) DeleteSnapshot(snapID string) error {
-	err := snapshots.Delete(os.blockstorage, snapID).ExtractErr()
+	mc := newRequestMetric("snapshot_delete")
+	err := mc.observe(snapshots.Delete(os.blockstorage, snapID).ExtractErr())
+	err = verifyAmbiguous("DeleteSnapshot", snapID, err, func() (bool, error) {
+		mc := newRequestMetric("snapshot_get")
+		snap, err := snapshots.Get(os.blockstorage, snapID).Extract()
+		if mc.observe(err) != nil {
+			if cpoerrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		return snap.Status == SnapshotDeletingStatus, nil
+	})
 	if err != nil {
 		klog.V(3).Infof("Failed to delete snapshot: %v", err)
 	}
@@ -109,8 +137,9 @@ func (os *OpenStack) DeleteSnapshot(snapID string) error {
 
 //GetSnapshotByID returns snapshot details by id
 func (os *OpenStack) GetSnapshotByID(snapshotID string) (*snapshots.Snapshot, error) {
+	mc := newRequestMetric("snapshot_get")
 	s, err := snapshots.Get(os.blockstorage, snapshotID).Extract()
-	if err != nil {
+	if mc.observe(err) != nil {
 		klog.V(3).Infof("Failed to get snapshot: %v", err)
 		return nil, err
 	}
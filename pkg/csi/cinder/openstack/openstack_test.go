@@ -17,12 +17,15 @@ limitations under the License.
 package openstack
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/stretchr/testify/assert"
+	"k8s.io/cloud-provider-openstack/pkg/util/ratelimit"
 )
 
 var fakeFileName = "cloud.conf"
@@ -86,6 +89,69 @@ region=` + fakeRegion + `
 	assert.Equal(expectedEpOpts, actualEpOpts)
 }
 
+// Test GetConfigFromFile with an application credential and a trust
+func TestGetConfigFromFileApplicationCredential(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloud-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "cloud.conf")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`
+[Global]
+auth-url=`+fakeAuthUrl+`
+tenant-id=`+fakeTenantID+`
+trust-id=mytrust
+application-credential-id=appcred
+application-credential-secret=secret
+`), 0600))
+
+	cfg, _, err := GetConfigFromFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "mytrust", cfg.Global.TrustId)
+
+	opts := cfg.toAuthOptions()
+	assert.Equal(t, "appcred", opts.ApplicationCredentialID)
+	assert.Equal(t, "secret", opts.ApplicationCredentialSecret)
+	// The application credential is scoped already
+	assert.Equal(t, "", opts.TenantID)
+
+	// A trust cannot be consumed with an application credential
+	assert.Error(t, authenticate(nil, opts, cfg.Global.TrustId))
+}
+
+// Test loadAuthConfig with a [RateLimit] section
+func TestLoadAuthConfigRateLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloud-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	savedFile := configFile
+	defer func() {
+		configFile = savedFile
+	}()
+
+	configFile = filepath.Join(dir, "cloud.conf")
+	assert.NoError(t, ioutil.WriteFile(configFile, []byte(`
+[Global]
+auth-url=`+fakeAuthUrl+`
+[RateLimit]
+qps=2.5
+burst=5
+max-retries=3
+`), 0600))
+	auth, err := loadAuthConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, ratelimit.Config{QPS: 2.5, Burst: 5, MaxRetries: 3}, auth.rateLimit)
+
+	assert.NoError(t, ioutil.WriteFile(configFile, []byte(`
+[Global]
+auth-url=`+fakeAuthUrl+`
+[RateLimit]
+qps=-1
+`), 0600))
+	_, err = loadAuthConfig()
+	assert.Error(t, err)
+}
+
 // Test GetConfigFromEnv
 func TestGetConfigFromEnv(t *testing.T) {
 	env := clearEnviron(t)
@@ -124,6 +190,72 @@ func TestGetConfigFromEnv(t *testing.T) {
 	assert.Equal(expectedEpOpts, actualEpOpts)
 }
 
+// Test GetConfigFromFile with a cloud of clouds.yaml
+func TestGetConfigFromClouds(t *testing.T) {
+	env := clearEnviron(t)
+	defer resetEnviron(t, env)
+
+	dir, err := ioutil.TempDir("", "clouds")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	clouds := `
+clouds:
+  fake:
+    auth:
+      auth_url: ` + fakeAuthUrl + `
+      username: ` + fakeUserName + `
+      project_id: ` + fakeTenantID + `
+      user_domain_id: ` + fakeDomainID + `
+    region_name: ` + fakeRegion + `
+`
+	// The password is kept apart in secure.yaml
+	secure := `
+clouds:
+  fake:
+    auth:
+      password: ` + fakePassword + `
+`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "clouds.yaml"), []byte(clouds), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "secure.yaml"), []byte(secure), 0600))
+	os.Setenv("OS_CLIENT_CONFIG_FILE", filepath.Join(dir, "clouds.yaml"))
+	os.Setenv("OS_CLIENT_SECURE_FILE", filepath.Join(dir, "secure.yaml"))
+	defer os.Unsetenv("OS_CLIENT_CONFIG_FILE")
+	defer os.Unsetenv("OS_CLIENT_SECURE_FILE")
+
+	InitCloud("fake")
+	defer InitCloud("")
+
+	expected := Config{}
+	expected.Global.AuthUrl = fakeAuthUrl
+	expected.Global.Username = fakeUserName
+	expected.Global.Password = fakePassword
+	expected.Global.TenantId = fakeTenantID
+	expected.Global.DomainId = fakeDomainID
+	expected.Global.Region = fakeRegion
+	expected.Global.UseClouds = true
+	expected.Global.Cloud = "fake"
+
+	// Without a config file
+	cfg, epOpts, err := GetConfigFromFile(filepath.Join(dir, "missing.conf"))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, cfg)
+	assert.Equal(t, gophercloud.EndpointOpts{Region: fakeRegion}, epOpts)
+
+	// The settings of the config file take priority
+	file := filepath.Join(dir, "cloud.conf")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("[Global]\nusername=other\n"), 0600))
+	cfg, _, err = GetConfigFromFile(file)
+	assert.NoError(t, err)
+	expected.Global.Username = "other"
+	assert.Equal(t, expected, cfg)
+
+	// A cloud missing from clouds.yaml fails
+	InitCloud("missing")
+	_, _, err = GetConfigFromFile(file)
+	assert.Error(t, err)
+}
+
 func clearEnviron(t *testing.T) []string {
 	env := os.Environ()
 	for _, pair := range env {
@@ -20,8 +20,11 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/volumeactions"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/volumeattach"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"k8s.io/apimachinery/pkg/util/wait"
 	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
 
@@ -30,9 +33,15 @@ import (
 
 const (
 	VolumeAvailableStatus    = "available"
+	VolumeCreatingStatus     = "creating"
 	VolumeInUseStatus        = "in-use"
 	VolumeDeletedStatus      = "deleted"
 	VolumeErrorStatus        = "error"
+	VolumeDeletingStatus     = "deleting"
+	VolumeAttachingStatus    = "attaching"
+	VolumeDetachingStatus    = "detaching"
+	VolumeExtendingStatus    = "extending"
+	multiattachMicroversion  = "2.60"
 	operationFinishInitDelay = 1 * time.Second
 	operationFinishFactor    = 1.1
 	operationFinishSteps     = 10
@@ -60,10 +69,50 @@ type Volume struct {
 	Size int
 	// Availability Zone the volume belongs to
 	AZ string
+	// Volume type of the volume
+	VolumeType string
+	// ID of the snapshot the volume was created from, "" if none
+	SnapshotID string
+	// ID of the volume the volume was cloned from, "" if none
+	SourceVolID string
+	// Metadata of the volume, including the tag of the cluster owning it
+	Metadata map[string]string
+	// Time the volume was created at
+	CreatedAt time.Time
+	// Whether the volume can be attached to several instances at once
+	Multiattach bool
+	// Attachments of the volume, more than one only for multiattach volumes
+	Attachments []Attachment
+}
+
+// Attachment is the attachment of a volume to an instance
+type Attachment struct {
+	// ID of the attachment in Cinder
+	ID string
+	// ID of the instance
+	ServerID string
+	// Device file path
+	Device string
+}
+
+// VolumeType is a Cinder volume type
+type VolumeType struct {
+	ID   string
+	Name string
+}
+
+// attachment returns the attachment of the volume to instanceID, if any.
+func (v *Volume) attachment(instanceID string) (Attachment, bool) {
+	for _, a := range v.Attachments {
+		if a.ServerID == instanceID {
+			return a, true
+		}
+	}
+	return Attachment{}, false
 }
 
 // CreateVolume creates a volume of given size
-func (os *OpenStack) CreateVolume(name string, size int, vtype, availability string, snapshotID string, tags *map[string]string) (string, string, int, error) {
+func (os *OpenStack) CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, tags *map[string]string) (string, string, int, error) {
 	opts := &volumes.CreateOpts{
 		Name:             name,
 		Size:             size,
@@ -71,42 +120,75 @@ func (os *OpenStack) CreateVolume(name string, size int, vtype, availability str
 		AvailabilityZone: availability,
 		Description:      volumeDescription,
 		SnapshotID:       snapshotID,
+		SourceVolID:      sourceVolID,
 	}
 	if tags != nil {
 		opts.Metadata = *tags
 	}
 
+	mc := newRequestMetric("volume_create")
 	vol, err := volumes.Create(os.blockstorage, opts).Extract()
-	if err != nil {
-		return "", "", 0, err
+	if mc.observe(err) != nil {
+		var created *Volume
+		err = verifyAmbiguous("CreateVolume", name, err, func() (bool, error) {
+			var verr error
+			created, verr = os.findCreatedVolume(name, size, opts.Metadata)
+			return created != nil, verr
+		})
+		if err != nil {
+			return "", "", 0, err
+		}
+		return created.ID, created.AZ, created.Size, nil
 	}
 
 	return vol.ID, vol.AvailabilityZone, vol.Size, nil
 }
 
+// findCreatedVolume returns the volume created by an earlier CreateVolume
+// call with the given parameters, nil when there is none or it cannot be
+// told apart from other volumes.
+func (os *OpenStack) findCreatedVolume(name string, size int, metadata map[string]string) (*Volume, error) {
+	vols, err := os.GetVolumesByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Volume
+	for _, v := range vols {
+		if v.Size != size || v.Status == VolumeErrorStatus {
+			continue
+		}
+		matches := true
+		for k, val := range metadata {
+			if v.Metadata[k] != val {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			found = append(found, v)
+		}
+	}
+	if len(found) != 1 {
+		if len(found) > 1 {
+			klog.Warningf("Found %d volumes named %s, cannot tell which one was created", len(found), name)
+		}
+		return nil, nil
+	}
+	return &found[0], nil
+}
+
 // ListVolumes list all the volumes
 func (os *OpenStack) ListVolumes() ([]Volume, error) {
 
 	var vlist []Volume
-	opts := volumes.ListOpts{}
-	pages, err := volumes.List(os.blockstorage, opts).AllPages()
-	if err != nil {
-		return vlist, err
-	}
-	vols, err := volumes.ExtractVolumes(pages)
+	vols, err := os.listAllVolumes(volumes.ListOpts{})
 	if err != nil {
 		return vlist, err
 	}
 
-	for _, v := range vols {
-		volume := Volume{
-			ID:     v.ID,
-			Name:   v.Name,
-			Status: v.Status,
-			AZ:     v.AvailabilityZone,
-			Size:   v.Size,
-		}
-		vlist = append(vlist, volume)
+	for i := range vols {
+		vlist = append(vlist, newVolume(&vols[i]))
 	}
 	return vlist, nil
 }
@@ -115,24 +197,24 @@ func (os *OpenStack) ListVolumes() ([]Volume, error) {
 // Returns a list of Volume references with the specified name
 func (os *OpenStack) GetVolumesByName(n string) ([]Volume, error) {
 	var vlist []Volume
-	opts := volumes.ListOpts{Name: n}
-	pages, err := volumes.List(os.blockstorage, opts).AllPages()
-	if err != nil {
-		return vlist, err
-	}
-
-	vols, err := volumes.ExtractVolumes(pages)
+	vols, err := os.listAllVolumes(volumes.ListOpts{Name: n})
 	if err != nil {
 		return vlist, err
 	}
 
 	for _, v := range vols {
 		volume := Volume{
-			ID:     v.ID,
-			Name:   v.Name,
-			Status: v.Status,
-			Size:   v.Size,
-			AZ:     v.AvailabilityZone,
+			ID:          v.ID,
+			Name:        v.Name,
+			Status:      v.Status,
+			Size:        v.Size,
+			AZ:          v.AvailabilityZone,
+			VolumeType:  v.VolumeType,
+			SnapshotID:  v.SnapshotID,
+			SourceVolID: v.SourceVolID,
+			Metadata:    v.Metadata,
+			CreatedAt:   v.CreatedAt,
+			Multiattach: v.Multiattach,
 		}
 		vlist = append(vlist, volume)
 	}
@@ -149,50 +231,291 @@ func (os *OpenStack) DeleteVolume(volumeID string) error {
 		return fmt.Errorf("Cannot delete the volume %q, it's still attached to a node", volumeID)
 	}
 
-	err = volumes.Delete(os.blockstorage, volumeID, nil).ExtractErr()
-	return err
+	mc := newRequestMetric("volume_delete")
+	err = mc.observe(volumes.Delete(os.blockstorage, volumeID, nil).ExtractErr())
+	return verifyAmbiguous("DeleteVolume", volumeID, err, func() (bool, error) {
+		vol, err := os.GetVolume(volumeID)
+		if err != nil {
+			if cpoerrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		return vol.Status == VolumeDeletingStatus, nil
+	})
+}
+
+// ExpandVolume extends a volume to size GiB. Cinder only extends available
+// volumes without the microversion 3.42 of online extension.
+func (os *OpenStack) ExpandVolume(volumeID string, size int) error {
+	opts := volumeactions.ExtendSizeOpts{
+		NewSize: size,
+	}
+	mc := newRequestMetric("volume_extend")
+	err := mc.observe(volumeactions.ExtendSize(os.blockstorage, volumeID, opts).ExtractErr())
+	return verifyAmbiguous("ExpandVolume", volumeID, err, func() (bool, error) {
+		vol, err := os.GetVolume(volumeID)
+		if err != nil {
+			return false, err
+		}
+		return vol.Status == VolumeExtendingStatus || vol.Size >= size, nil
+	})
+}
+
+// ResetVolumeStatus sets the status of a volume without any check, e.g. to
+// error so a volume stuck in creating can be deleted. Cinder only allows it to
+// administrators by default.
+func (os *OpenStack) ResetVolumeStatus(volumeID, status string) error {
+	body := map[string]interface{}{
+		"os-reset_status": map[string]string{"status": status},
+	}
+	mc := newRequestMetric("volume_reset_status")
+	_, err := os.blockstorage.Post(os.blockstorage.ServiceURL("volumes", volumeID, "action"), body, nil, &gophercloud.RequestOpts{
+		OkCodes: []int{202},
+	})
+	return mc.observe(err)
+}
+
+// ForceDetachVolume has Cinder delete the attachment attachmentID of a
+// volume without Nova, for an instance that no longer exists. Cinder makes
+// the volume available once it has no attachments left. It is only allowed
+// to administrators by default.
+func (os *OpenStack) ForceDetachVolume(volumeID, attachmentID string) error {
+	body := map[string]interface{}{
+		"os-force_detach": map[string]string{"attachment_id": attachmentID},
+	}
+	mc := newRequestMetric("volume_force_detach")
+	_, err := os.blockstorage.Post(os.blockstorage.ServiceURL("volumes", volumeID, "action"), body, nil, &gophercloud.RequestOpts{
+		OkCodes: []int{202},
+	})
+	return mc.observe(err)
+}
+
+// InstanceExists returns whether Nova still has the instance instanceID. It
+// is only false when Nova answers the instance is not found.
+func (os *OpenStack) InstanceExists(instanceID string) (bool, error) {
+	mc := newRequestMetric("server_get")
+	_, err := servers.Get(os.compute, instanceID).Extract()
+	if mc.observe(err) != nil {
+		if cpoerrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// volumeTypeID returns the ID of a volume type given by name or ID.
+func (os *OpenStack) volumeTypeID(volumeType string) (string, error) {
+	typeID := ""
+	err := os.eachVolumeType(func(t VolumeType) bool {
+		if t.ID == volumeType || t.Name == volumeType {
+			typeID = t.ID
+			// Found, no need for the next pages
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+	if typeID == "" {
+		return "", fmt.Errorf("volume type %s not found", volumeType)
+	}
+	return typeID, nil
+}
+
+// ListVolumeTypes returns the volume types the project can create volumes
+// of.
+func (os *OpenStack) ListVolumeTypes() ([]VolumeType, error) {
+	var types []VolumeType
+	err := os.eachVolumeType(func(t VolumeType) bool {
+		types = append(types, t)
+		return true
+	})
+	return types, err
+}
+
+// eachVolumeType calls f with the volume types, page by page, until f
+// returns false.
+func (os *OpenStack) eachVolumeType(f func(VolumeType) bool) error {
+	return listAllPages("volume types", func(marker string, limit int) (listPage, error) {
+		query, err := markerQuery("", marker, limit)
+		if err != nil {
+			return listPage{}, err
+		}
+		var body struct {
+			VolumeTypes []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"volume_types"`
+			Links []gophercloud.Link `json:"volume_types_links"`
+		}
+		mc := newRequestMetric("volume_type_list")
+		if _, err := os.blockstorage.Get(os.blockstorage.ServiceURL("types")+query, &body, nil); mc.observe(err) != nil {
+			return listPage{}, err
+		}
+		for _, t := range body.VolumeTypes {
+			if !f(VolumeType{ID: t.ID, Name: t.Name}) {
+				return listPage{}, nil
+			}
+		}
+		if len(body.VolumeTypes) == 0 {
+			return listPage{}, nil
+		}
+		hasNext := false
+		for _, link := range body.Links {
+			hasNext = hasNext || link.Rel == "next"
+		}
+		return listPage{lastID: body.VolumeTypes[len(body.VolumeTypes)-1].ID, count: len(body.VolumeTypes), hasNext: hasNext}, nil
+	})
+}
+
+// ListAvailabilityZones returns the names of the available Cinder
+// availability zones.
+func (os *OpenStack) ListAvailabilityZones() ([]string, error) {
+	var body struct {
+		Zones []struct {
+			Name  string `json:"zoneName"`
+			State struct {
+				Available bool `json:"available"`
+			} `json:"zoneState"`
+		} `json:"availabilityZoneInfo"`
+	}
+	mc := newRequestMetric("availability_zone_list")
+	if _, err := os.blockstorage.Get(os.blockstorage.ServiceURL("os-availability-zone"), &body, nil); mc.observe(err) != nil {
+		return nil, err
+	}
+	var zones []string
+	for _, zone := range body.Zones {
+		if zone.State.Available {
+			zones = append(zones, zone.Name)
+		}
+	}
+	return zones, nil
+}
+
+// VolumeTypeEncrypted returns whether the volumes of a volume type, given by
+// name or ID, are encrypted. The encryption of a type is only served by ID.
+func (os *OpenStack) VolumeTypeEncrypted(volumeType string) (bool, error) {
+	typeID, err := os.volumeTypeID(volumeType)
+	if err != nil {
+		return false, err
+	}
+
+	// Unencrypted types have an empty encryption
+	var encryption struct {
+		EncryptionID string `json:"encryption_id"`
+		Provider     string `json:"provider"`
+	}
+	mc := newRequestMetric("volume_type_encryption_get")
+	_, err = os.blockstorage.Get(os.blockstorage.ServiceURL("types", typeID, "encryption"), &encryption, nil)
+	if mc.observe(err) != nil {
+		return false, err
+	}
+	return encryption.EncryptionID != "" || encryption.Provider != "", nil
+}
+
+// VolumeTypeMultiattach returns whether the volumes of a volume type, given by
+// name or ID, can be attached to several instances, which Cinder allows for
+// the types with the multiattach="<is> True" extra spec.
+func (os *OpenStack) VolumeTypeMultiattach(volumeType string) (bool, error) {
+	typeID, err := os.volumeTypeID(volumeType)
+	if err != nil {
+		return false, err
+	}
+
+	var body struct {
+		VolumeType struct {
+			ExtraSpecs map[string]string `json:"extra_specs"`
+		} `json:"volume_type"`
+	}
+	mc := newRequestMetric("volume_type_get")
+	_, err = os.blockstorage.Get(os.blockstorage.ServiceURL("types", typeID), &body, nil)
+	if mc.observe(err) != nil {
+		return false, err
+	}
+	return body.VolumeType.ExtraSpecs["multiattach"] == "<is> True", nil
 }
 
 // GetVolume retrieves Volume by its ID.
 func (os *OpenStack) GetVolume(volumeID string) (Volume, error) {
-
+	mc := newRequestMetric("volume_get")
 	vol, err := volumes.Get(os.blockstorage, volumeID).Extract()
-	if err != nil {
+	if mc.observe(err) != nil {
 		return Volume{}, err
 	}
 
+	return newVolume(vol), nil
+}
+
+// newVolume returns the Volume of the Cinder volume vol.
+func newVolume(vol *volumes.Volume) Volume {
 	volume := Volume{
-		ID:     vol.ID,
-		Name:   vol.Name,
-		Status: vol.Status,
+		ID:          vol.ID,
+		Name:        vol.Name,
+		Status:      vol.Status,
+		Size:        vol.Size,
+		AZ:          vol.AvailabilityZone,
+		VolumeType:  vol.VolumeType,
+		SnapshotID:  vol.SnapshotID,
+		SourceVolID: vol.SourceVolID,
+		Metadata:    vol.Metadata,
+		CreatedAt:   vol.CreatedAt,
+		Multiattach: vol.Multiattach,
 	}
 
+	for _, a := range vol.Attachments {
+		volume.Attachments = append(volume.Attachments, Attachment{ID: a.AttachmentID, ServerID: a.ServerID, Device: a.Device})
+	}
 	if len(vol.Attachments) > 0 {
 		volume.AttachedServerId = vol.Attachments[0].ServerID
 		volume.AttachedDevice = vol.Attachments[0].Device
 	}
 
-	return volume, nil
+	return volume
 }
 
 // AttachVolume attaches given cinder volume to the compute
+// The volumes back persistent volumes which must outlive the instance, the
+// attachment is never created with delete_on_termination. Only multiattach
+// volumes are attached to another instance than the one they are attached to.
 func (os *OpenStack) AttachVolume(instanceID, volumeID string) (string, error) {
 	volume, err := os.GetVolume(volumeID)
 	if err != nil {
 		return "", err
 	}
 
-	if volume.AttachedServerId != "" {
-		if instanceID == volume.AttachedServerId {
-			klog.V(4).Infof("Disk %s is already attached to instance %s", volumeID, instanceID)
-			return volume.ID, nil
-		}
+	if _, attached := volume.attachment(instanceID); attached {
+		klog.V(4).Infof("Disk %s is already attached to instance %s", volumeID, instanceID)
+		return volume.ID, nil
+	}
+	if volume.AttachedServerId != "" && !volume.Multiattach {
 		return "", fmt.Errorf("disk %s is attached to a different instance (%s)", volumeID, volume.AttachedServerId)
 	}
 
-	_, err = volumeattach.Create(os.compute, instanceID, &volumeattach.CreateOpts{
+	compute := os.compute
+	if volume.Multiattach {
+		// Nova attaches multiattach volumes from the microversion 2.60
+		c := *os.compute
+		c.Microversion = multiattachMicroversion
+		compute = &c
+	}
+	mc := newRequestMetric("server_volume_attach")
+	_, err = volumeattach.Create(compute, instanceID, &volumeattach.CreateOpts{
 		VolumeID: volume.ID,
 	}).Extract()
+	mc.observe(err)
+	err = verifyAmbiguous("AttachVolume", volumeID, err, func() (bool, error) {
+		vol, err := os.GetVolume(volumeID)
+		if err != nil {
+			return false, err
+		}
+		// A volume attaching may be attaching to another instance, only an
+		// attachment to instanceID tells the request took effect
+		_, attached := vol.attachment(instanceID)
+		return attached, nil
+	})
 
 	if err != nil {
 		return "", fmt.Errorf("failed to attach %s volume to %s compute: %v", volumeID, instanceID, err)
@@ -201,6 +524,85 @@ func (os *OpenStack) AttachVolume(instanceID, volumeID string) (string, error) {
 	return volume.ID, nil
 }
 
+// ConnectorProperties describe a host to Cinder initialize_connection, see
+// the connector package.
+type ConnectorProperties struct {
+	Host      string
+	IP        string
+	Initiator string
+	Wwpns     []string
+	Wwnns     []string
+	Multipath bool
+}
+
+// ConnectionInfo is how a backend exports a volume to a host, the
+// driver_volume_type, e.g. iscsi, and its data.
+type ConnectionInfo struct {
+	DriverVolumeType string                 `json:"driver_volume_type"`
+	Data             map[string]interface{} `json:"data"`
+}
+
+// InitializeConnection exports a volume to a host without Nova, e.g. an
+// Ironic bare-metal node, and marks it attached to the host.
+func (os *OpenStack) InitializeConnection(volumeID string, connector ConnectorProperties) (*ConnectionInfo, error) {
+	opts := volumeactions.InitializeConnectionOpts{
+		Host:      connector.Host,
+		IP:        connector.IP,
+		Initiator: connector.Initiator,
+		Wwpns:     connector.Wwpns,
+		Multipath: &connector.Multipath,
+	}
+	if len(connector.Wwnns) > 0 {
+		opts.Wwnns = connector.Wwnns[0]
+	}
+	mc := newRequestMetric("volume_initialize_connection")
+	connection, err := volumeactions.InitializeConnection(os.blockstorage, volumeID, opts).Extract()
+	if mc.observe(err) != nil {
+		return nil, fmt.Errorf("failed to initialize the connection of volume %s to host %s: %v", volumeID, connector.Host, err)
+	}
+	info := &ConnectionInfo{Data: map[string]interface{}{}}
+	info.DriverVolumeType, _ = connection["driver_volume_type"].(string)
+	if data, ok := connection["data"].(map[string]interface{}); ok {
+		info.Data = data
+	}
+
+	mc = newRequestMetric("volume_attach")
+	err = mc.observe(volumeactions.Attach(os.blockstorage, volumeID, volumeactions.AttachOpts{
+		HostName: connector.Host,
+		Mode:     volumeactions.ReadWrite,
+	}).ExtractErr())
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark volume %s attached to host %s: %v", volumeID, connector.Host, err)
+	}
+	klog.V(2).Infof("Initialized the %s connection of volume %s to host %s", info.DriverVolumeType, volumeID, connector.Host)
+	return info, nil
+}
+
+// TerminateConnection stops exporting a volume to a host connected with
+// InitializeConnection, and marks it detached.
+func (os *OpenStack) TerminateConnection(volumeID string, connector ConnectorProperties) error {
+	opts := volumeactions.TerminateConnectionOpts{
+		Host:      connector.Host,
+		IP:        connector.IP,
+		Initiator: connector.Initiator,
+		Wwpns:     connector.Wwpns,
+		Multipath: &connector.Multipath,
+	}
+	if len(connector.Wwnns) > 0 {
+		opts.Wwnns = connector.Wwnns[0]
+	}
+	mc := newRequestMetric("volume_terminate_connection")
+	if err := mc.observe(volumeactions.TerminateConnection(os.blockstorage, volumeID, opts).ExtractErr()); err != nil {
+		return fmt.Errorf("failed to terminate the connection of volume %s to host %s: %v", volumeID, connector.Host, err)
+	}
+	mc = newRequestMetric("volume_detach")
+	if err := mc.observe(volumeactions.Detach(os.blockstorage, volumeID, volumeactions.DetachOpts{}).ExtractErr()); err != nil {
+		return fmt.Errorf("failed to mark volume %s detached from host %s: %v", volumeID, connector.Host, err)
+	}
+	klog.V(2).Infof("Terminated the connection of volume %s to host %s", volumeID, connector.Host)
+	return nil
+}
+
 // WaitDiskAttached waits for attched
 func (os *OpenStack) WaitDiskAttached(instanceID string, volumeID string) error {
 	backoff := wait.Backoff{
@@ -241,10 +643,20 @@ func (os *OpenStack) DetachVolume(instanceID, volumeID string) error {
 		return fmt.Errorf("can not detach volume %s, its status is %s", volume.Name, volume.Status)
 	}
 
-	if volume.AttachedServerId != instanceID {
+	if _, attached := volume.attachment(instanceID); !attached {
 		return fmt.Errorf("disk: %s has no attachments or is not attached to compute: %s", volume.Name, instanceID)
 	} else {
-		err = volumeattach.Delete(os.compute, instanceID, volume.ID).ExtractErr()
+		mc := newRequestMetric("server_volume_detach")
+		err = mc.observe(volumeattach.Delete(os.compute, instanceID, volume.ID).ExtractErr())
+		err = verifyAmbiguous("DetachVolume", volumeID, err, func() (bool, error) {
+			vol, err := os.GetVolume(volumeID)
+			if err != nil {
+				return false, err
+			}
+			// A multiattach volume stays in-use while attached to other instances
+			_, attached := vol.attachment(instanceID)
+			return !attached || vol.Status == VolumeDetachingStatus, nil
+		})
 		if err != nil {
 			return fmt.Errorf("failed to delete volume %s from compute %s attached %v", volume.ID, instanceID, err)
 		}
@@ -286,12 +698,11 @@ func (os *OpenStack) GetAttachmentDiskPath(instanceID, volumeID string) (string,
 	if volume.Status != VolumeInUseStatus {
 		return "", fmt.Errorf("can not get device path of volume %s, its status is %s ", volume.Name, volume.Status)
 	}
+	if a, attached := volume.attachment(instanceID); attached {
+		return a.Device, nil
+	}
 	if volume.AttachedServerId != "" {
-		if instanceID == volume.AttachedServerId {
-			return volume.AttachedDevice, nil
-		} else {
-			return "", fmt.Errorf("disk %q is attached to a different compute: %q, should be detached before proceeding", volumeID, volume.AttachedServerId)
-		}
+		return "", fmt.Errorf("disk %q is attached to a different compute: %q, should be detached before proceeding", volumeID, volume.AttachedServerId)
 	}
 	return "", fmt.Errorf("volume %s has no ServerId", volumeID)
 }
@@ -303,7 +714,8 @@ func (os *OpenStack) diskIsAttached(instanceID, volumeID string) (bool, error) {
 		return false, err
 	}
 
-	return instanceID == volume.AttachedServerId, nil
+	_, attached := volume.attachment(instanceID)
+	return attached, nil
 }
 
 // diskIsUsed returns true a disk is attached to any node.
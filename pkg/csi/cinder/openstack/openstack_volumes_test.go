@@ -0,0 +1,173 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Nova deletes a volume attached with delete_on_termination together with
+// the instance, a PV must never be.
+func TestAttachVolumeNoDeleteOnTermination(t *testing.T) {
+	f := newFakeCinder()
+	os, stop := newFakeOpenStack(f)
+	defer stop()
+
+	tags := map[string]string{"cinder.csi.openstack.org/cluster": "kubernetes"}
+	volumeID, _, _, err := os.CreateVolume("pvc-1", 1, "", "", "", "", &tags)
+	assert.NoError(t, err)
+
+	_, err = os.AttachVolume(fakeServerID, volumeID)
+	assert.NoError(t, err)
+
+	if assert.Len(t, f.attachments, 1) {
+		assert.Equal(t, volumeID, f.attachments[0]["volumeId"])
+		assert.NotContains(t, f.attachments[0], "delete_on_termination")
+	}
+}
+
+// A volume attached to an instance Nova no longer has is detached by Cinder
+// alone.
+func TestForceDetachVolume(t *testing.T) {
+	f := newFakeCinder()
+	os, stop := newFakeOpenStack(f)
+	defer stop()
+
+	volumeID, _, _, err := os.CreateVolume("pvc-1", 1, "", "", "", "", nil)
+	assert.NoError(t, err)
+	_, err = os.AttachVolume(fakeServerID, volumeID)
+	assert.NoError(t, err)
+
+	exists, err := os.InstanceExists(fakeServerID)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	f.deletedServers = map[string]bool{fakeServerID: true}
+	exists, err = os.InstanceExists(fakeServerID)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	vols, err := os.ListVolumes()
+	assert.NoError(t, err)
+	if assert.Len(t, vols, 1) && assert.Len(t, vols[0].Attachments, 1) {
+		a := vols[0].Attachments[0]
+		assert.Equal(t, fakeServerID, a.ServerID)
+		assert.NoError(t, os.ForceDetachVolume(volumeID, a.ID))
+	}
+
+	vol, err := os.GetVolume(volumeID)
+	assert.NoError(t, err)
+	assert.Equal(t, VolumeAvailableStatus, vol.Status)
+	assert.Empty(t, vol.Attachments)
+}
+
+// Cinder returns at most osapi_max_limit items per list, the volumes and
+// snapshots past the first page must be found as well.
+func TestListPagination(t *testing.T) {
+	f := newFakeCinder()
+	os, stop := newFakeOpenStack(f)
+	defer stop()
+
+	for i := 0; i < 3; i++ {
+		volumeID, _, _, err := os.CreateVolume("pvc-1", 1, "", "", "", "", nil)
+		assert.NoError(t, err)
+		_, err = os.CreateSnapshot("snapshot-1", volumeID, "", nil)
+		assert.NoError(t, err)
+	}
+	f.maxLimit = 2
+
+	vols, err := os.GetVolumesByName("pvc-1")
+	assert.NoError(t, err)
+	assert.Len(t, vols, 3, "the duplicates on the second page are found")
+	assert.Equal(t, 2, f.pages)
+
+	f.pages = 0
+	vols, err = os.ListVolumes()
+	assert.NoError(t, err)
+	assert.Len(t, vols, 3)
+	assert.Equal(t, 2, f.pages)
+
+	snaps, err := os.ListSnapshots(0, 0, nil)
+	assert.NoError(t, err)
+	assert.Len(t, snaps, 3)
+
+	// A page shorter than requested without a next link is the last one
+	f.maxLimit = 3
+	f.pages = 0
+	vols, err = os.ListVolumes()
+	assert.NoError(t, err)
+	assert.Len(t, vols, 3)
+	assert.Equal(t, 1, f.pages)
+}
+
+// A multiattach volume is attached to several instances, and stays attached
+// to the others when detached from one.
+func TestAttachVolumeMultiattach(t *testing.T) {
+	f := newFakeCinder()
+	os, stop := newFakeOpenStack(f)
+	defer stop()
+
+	volumeID, _, _, err := os.CreateVolume("pvc-1", 1, "", "", "", "", nil)
+	assert.NoError(t, err)
+	_, err = os.AttachVolume(fakeServerID, volumeID)
+	assert.NoError(t, err)
+	_, err = os.AttachVolume("server-2", volumeID)
+	assert.Error(t, err, "a volume which is not multiattach is attached once")
+
+	f.volumes[volumeID].Multiattach = true
+	_, err = os.AttachVolume("server-2", volumeID)
+	assert.NoError(t, err)
+	assert.Len(t, f.attachments, 2)
+	for _, server := range []string{fakeServerID, "server-2"} {
+		attached, err := os.diskIsAttached(server, volumeID)
+		assert.NoError(t, err)
+		assert.True(t, attached)
+		device, err := os.GetAttachmentDiskPath(server, volumeID)
+		assert.NoError(t, err)
+		assert.Equal(t, "/dev/vdb", device)
+	}
+
+	assert.NoError(t, os.DetachVolume(fakeServerID, volumeID))
+	attached, err := os.diskIsAttached(fakeServerID, volumeID)
+	assert.NoError(t, err)
+	assert.False(t, attached)
+	vol, err := os.GetVolume(volumeID)
+	assert.NoError(t, err)
+	assert.Equal(t, VolumeInUseStatus, vol.Status)
+	assert.Equal(t, []Attachment{{ServerID: "server-2", Device: "/dev/vdb"}}, vol.Attachments)
+}
+
+// The volume types past the first page are listed, and only the available
+// zones.
+func TestListVolumeTypesAndZones(t *testing.T) {
+	f := newFakeCinder()
+	f.volumeTypes = map[string]string{"a1": "ssd", "b2": "hdd", "c3": "nvme"}
+	f.zones = map[string]bool{"nova": true, "az-2": true, "az-down": false}
+	f.maxLimit = 2
+	os, stop := newFakeOpenStack(f)
+	defer stop()
+
+	types, err := os.ListVolumeTypes()
+	assert.NoError(t, err)
+	assert.Equal(t, []VolumeType{{ID: "a1", Name: "ssd"}, {ID: "b2", Name: "hdd"}, {ID: "c3", Name: "nvme"}}, types)
+	assert.Equal(t, 2, f.pages)
+
+	zones, err := os.ListAvailabilityZones()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"nova", "az-2"}, zones)
+}
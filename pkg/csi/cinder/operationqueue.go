@@ -0,0 +1,211 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+const (
+	// operationsPath is the debug path listing the queued operations
+	operationsPath = "/debug/operations"
+	// operationsCancelPath is the debug path cancelling queued operations,
+	// POST it with the id query parameter
+	operationsCancelPath = operationsPath + "/cancel"
+
+	controllerMethodPrefix = "/csi.v1.Controller/"
+)
+
+// controllerQueue queues the controller operations over the maximum number of
+// concurrent ones, nil when operations are not limited.
+var controllerQueue *operationQueue
+
+// queuedOperation is an operation waiting for its turn.
+type queuedOperation struct {
+	ID     int    `json:"id"`
+	Method string `json:"method"`
+	// Target is the volume or snapshot ID, or the name of the volume or
+	// snapshot being created
+	Target  string        `json:"target,omitempty"`
+	Since   time.Time     `json:"since"`
+	Waiting time.Duration `json:"waiting"`
+
+	cancelled chan struct{}
+}
+
+// operationQueue runs a limited number of operations at a time. The others
+// wait in the queue, where they can be cancelled until they start.
+type operationQueue struct {
+	slots chan struct{}
+
+	mu     sync.Mutex
+	nextID int
+	queued map[int]*queuedOperation
+	now    func() time.Time
+}
+
+func newOperationQueue(max int) *operationQueue {
+	return &operationQueue{
+		slots:  make(chan struct{}, max),
+		queued: map[int]*queuedOperation{},
+		now:    time.Now,
+	}
+}
+
+// SetMaxConcurrentOperations limits the number of controller operations run
+// at a time, 0 does not limit them. The operations waiting for their turn are
+// listed on the debug endpoints, where they can be cancelled.
+func (d *CinderDriver) SetMaxConcurrentOperations(max int) {
+	if max <= 0 {
+		controllerQueue = nil
+		return
+	}
+	klog.Infof("Running at most %d controller operations at a time", max)
+	controllerQueue = newOperationQueue(max)
+}
+
+// wait waits for the turn of an operation and returns the function to call
+// when it is done. It fails with Aborted when the operation gets cancelled
+// while queued, and with the error of ctx when it ends first.
+func (q *operationQueue) wait(ctx context.Context, method, target string) (func(), error) {
+	release := func() { <-q.slots }
+
+	// Most of the time there is no queue
+	select {
+	case q.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	q.mu.Lock()
+	q.nextID++
+	op := &queuedOperation{ID: q.nextID, Method: method, Target: target, Since: q.now(), cancelled: make(chan struct{})}
+	q.queued[op.ID] = op
+	q.mu.Unlock()
+	klog.V(4).Infof("Queued %s %s as operation %d", method, target, op.ID)
+
+	select {
+	case q.slots <- struct{}{}:
+		// A cancel racing with the start is only honored when it came first
+		q.mu.Lock()
+		_, queued := q.queued[op.ID]
+		delete(q.queued, op.ID)
+		q.mu.Unlock()
+		if !queued {
+			release()
+			return nil, cancelledError(op)
+		}
+		return release, nil
+	case <-op.cancelled:
+		return nil, cancelledError(op)
+	case <-ctx.Done():
+		q.mu.Lock()
+		delete(q.queued, op.ID)
+		q.mu.Unlock()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, status.Errorf(codes.DeadlineExceeded, "%s %s timed out while queued", method, target)
+		}
+		return nil, status.Errorf(codes.Canceled, "%s %s was cancelled by the caller while queued", method, target)
+	}
+}
+
+func cancelledError(op *queuedOperation) error {
+	return status.Errorf(codes.Aborted, "%s %s was cancelled by an administrator while queued", op.Method, op.Target)
+}
+
+// cancel cancels a queued operation. It returns false when the operation is
+// not queued, e.g. because it already started, started operations are never
+// cancelled.
+func (q *operationQueue) cancel(id int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	op, ok := q.queued[id]
+	if !ok {
+		return false
+	}
+	delete(q.queued, id)
+	close(op.cancelled)
+	klog.Warningf("Cancelled queued operation %d: %s %s", id, op.Method, op.Target)
+	return true
+}
+
+// list returns the queued operations, oldest first.
+func (q *operationQueue) list() []queuedOperation {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	ops := make([]queuedOperation, 0, len(q.queued))
+	for _, op := range q.queued {
+		o := *op
+		o.Waiting = now.Sub(o.Since)
+		ops = append(ops, o)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].ID < ops[j].ID })
+	return ops
+}
+
+// ServeHTTP lists the queued operations as JSON.
+func (q *operationQueue) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(q.list())
+}
+
+// serveCancel cancels the queued operations given by the id query
+// parameters. It fails with Conflict when one of them is not queued.
+func (q *operationQueue) serveCancel(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var notQueued []string
+	for _, value := range req.URL.Query()["id"] {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "invalid id "+value, http.StatusBadRequest)
+			return
+		}
+		if !q.cancel(id) {
+			notQueued = append(notQueued, value)
+		}
+	}
+	if len(notQueued) > 0 {
+		http.Error(w, "not queued: "+strings.Join(notQueued, ", "), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// queueOperation makes a controller operation wait for its turn when
+// operations are limited.
+func queueOperation(ctx context.Context, method, target string) (func(), error) {
+	if controllerQueue == nil || !strings.HasPrefix(method, controllerMethodPrefix) || method == controllerMethodPrefix+"ControllerGetCapabilities" {
+		return func() {}, nil
+	}
+	return controllerQueue.wait(ctx, method, target)
+}
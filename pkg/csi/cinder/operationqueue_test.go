@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func cancelQueued(q *operationQueue, ids ...string) int {
+	url := operationsCancelPath + "?"
+	for _, id := range ids {
+		url += "id=" + id + "&"
+	}
+	w := httptest.NewRecorder()
+	q.serveCancel(w, httptest.NewRequest("POST", url, nil))
+	return w.Code
+}
+
+func TestOperationQueue(t *testing.T) {
+	q := newOperationQueue(1)
+
+	// The first operation starts right away
+	release, err := q.wait(context.Background(), "/csi.v1.Controller/DeleteVolume", "vol-1")
+	assert.NoError(t, err)
+
+	type result struct {
+		release func()
+		err     error
+	}
+	queue := func(target string) chan result {
+		done := make(chan result, 1)
+		go func() {
+			release, err := q.wait(context.Background(), "/csi.v1.Controller/CreateSnapshot", target)
+			done <- result{release, err}
+		}()
+		return done
+	}
+	waitQueued := func(n int) {
+		err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			return len(q.list()) == n, nil
+		})
+		assert.NoError(t, err)
+	}
+
+	second := queue("vol-2")
+	waitQueued(1)
+	third := queue("vol-3")
+	waitQueued(2)
+
+	ops := q.list()
+	assert.Equal(t, 1, ops[0].ID)
+	assert.Equal(t, "/csi.v1.Controller/CreateSnapshot", ops[0].Method)
+	assert.Equal(t, "vol-2", ops[0].Target)
+	assert.Equal(t, "vol-3", ops[1].Target)
+
+	// Cancelling a queued operation aborts it
+	assert.Equal(t, http.StatusNoContent, cancelQueued(q, "1"))
+	r := <-second
+	assert.Equal(t, codes.Aborted, status.Code(r.err))
+	waitQueued(1)
+
+	// The operation still queued starts once the first one is released
+	release()
+	r = <-third
+	assert.NoError(t, r.err)
+	assert.Empty(t, q.list())
+
+	// Started operations are never cancelled
+	assert.Equal(t, http.StatusConflict, cancelQueued(q, "2"))
+	assert.Equal(t, http.StatusBadRequest, cancelQueued(q, "vol-3"))
+	w := httptest.NewRecorder()
+	q.serveCancel(w, httptest.NewRequest("GET", operationsCancelPath+"?id=2", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	// An operation whose deadline expires while queued is removed from the
+	// queue
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = q.wait(ctx, "/csi.v1.Controller/DeleteVolume", "vol-4")
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Empty(t, q.list())
+	r.release()
+}
+
+func TestQueueOperation(t *testing.T) {
+	defer func() { controllerQueue = nil }()
+	d := NewDriver(fakeNodeID, fakeEndpoint, fakeCluster, fakeConfig)
+	d.SetMaxConcurrentOperations(1)
+
+	release, err := queueOperation(context.Background(), "/csi.v1.Controller/CreateVolume", "pvc-1")
+	assert.NoError(t, err)
+	defer release()
+
+	// Capabilities and node operations are never queued
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	for _, method := range []string{"/csi.v1.Controller/ControllerGetCapabilities", "/csi.v1.Node/NodeStageVolume"} {
+		releaseOther, err := queueOperation(ctx, method, "")
+		assert.NoError(t, err, method)
+		releaseOther()
+	}
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+const (
+	// PlacementAPIVersion is the version of the payload exchanged with the
+	// placement webhook. It is sent with every request and must be echoed
+	// back in the response.
+	PlacementAPIVersion = "placement.cinder.csi.openstack.org/v1"
+
+	defaultPlacementWebhookTimeout = 5 * time.Second
+)
+
+// PlacementRequest is the payload POSTed to the placement webhook.
+type PlacementRequest struct {
+	APIVersion string `json:"apiVersion"`
+	// Name of the volume to be created
+	Name string `json:"name"`
+	// Size of the volume in GiB
+	SizeGB int `json:"sizeGB"`
+	// StorageClass parameters of the CreateVolume request
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Zones from the requisite and preferred topology of the request
+	RequisiteZones []string `json:"requisiteZones,omitempty"`
+	PreferredZones []string `json:"preferredZones,omitempty"`
+	// Volume types and availability zones the driver would pick from
+	CandidateTypes []string `json:"candidateTypes,omitempty"`
+	CandidateZones []string `json:"candidateZones,omitempty"`
+}
+
+// PlacementResponse is the payload returned by the placement webhook.
+type PlacementResponse struct {
+	APIVersion string `json:"apiVersion"`
+	// Allowed is false when the webhook rejects the volume
+	Allowed bool `json:"allowed"`
+	// Reason is surfaced in the CreateVolume error when the volume is rejected
+	Reason string `json:"reason,omitempty"`
+	// VolumeType and AvailabilityZone override the built-in choice when set
+	VolumeType       string `json:"volumeType,omitempty"`
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+	// Metadata is added to the Cinder volume metadata
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// placementDecision is the outcome of the placement step in CreateVolume.
+type placementDecision struct {
+	volType      string
+	availability string
+	metadata     map[string]string
+}
+
+// placementWebhook calls an external service to decide on volume placement.
+type placementWebhook struct {
+	url      string
+	timeout  time.Duration
+	failOpen bool
+	client   *http.Client
+}
+
+func newPlacementWebhook(url string, timeout time.Duration, failOpen bool) *placementWebhook {
+	if timeout <= 0 {
+		timeout = defaultPlacementWebhookTimeout
+	}
+	return &placementWebhook{
+		url:      url,
+		timeout:  timeout,
+		failOpen: failOpen,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// SetPlacementWebhook configures an external placement webhook consulted by
+// CreateVolume. An empty url disables the webhook.
+func (d *CinderDriver) SetPlacementWebhook(url string, timeout time.Duration, failOpen bool) {
+	if url == "" {
+		d.placement = nil
+		return
+	}
+	klog.Infof("Using placement webhook %s (timeout: %v, fail-open: %v)", url, timeout, failOpen)
+	d.placement = newPlacementWebhook(url, timeout, failOpen)
+}
+
+// newPlacementRequest builds the webhook payload from a CreateVolume request
+// and the placement the driver would use on its own.
+func newPlacementRequest(req *csi.CreateVolumeRequest, sizeGB int, builtin placementDecision, keys topologyKeys) *PlacementRequest {
+	pr := &PlacementRequest{
+		APIVersion: PlacementAPIVersion,
+		Name:       req.GetName(),
+		SizeGB:     sizeGB,
+		Parameters: req.GetParameters(),
+	}
+
+	if ar := req.GetAccessibilityRequirements(); ar != nil {
+		pr.RequisiteZones = zonesFromTopologies(ar.GetRequisite(), keys)
+		pr.PreferredZones = zonesFromTopologies(ar.GetPreferred(), keys)
+	}
+
+	if builtin.volType != "" {
+		pr.CandidateTypes = []string{builtin.volType}
+	}
+	pr.CandidateZones = appendUnique(pr.CandidateZones, builtin.availability)
+	for _, zone := range pr.PreferredZones {
+		pr.CandidateZones = appendUnique(pr.CandidateZones, zone)
+	}
+	for _, zone := range pr.RequisiteZones {
+		pr.CandidateZones = appendUnique(pr.CandidateZones, zone)
+	}
+
+	return pr
+}
+
+// decide consults the webhook and merges its answer with the built-in
+// placement. Rejections and, when failing closed, webhook errors are returned
+// as gRPC errors.
+func (p *placementWebhook) decide(ctx context.Context, pr *PlacementRequest, builtin placementDecision) (placementDecision, error) {
+	start := time.Now()
+	resp, err := p.call(ctx, pr)
+	if err != nil {
+		placementWebhookDuration.WithLabelValues(placementWebhookResultError).Observe(time.Since(start).Seconds())
+		if p.failOpen {
+			klog.Warningf("Placement webhook call for volume %s failed, falling back to built-in placement: %v", pr.Name, err)
+			return builtin, nil
+		}
+		return placementDecision{}, status.Errorf(codes.Unavailable, "placement webhook call failed: %v", err)
+	}
+
+	if !resp.Allowed {
+		placementWebhookDuration.WithLabelValues(placementWebhookResultDenied).Observe(time.Since(start).Seconds())
+		return placementDecision{}, status.Errorf(codes.FailedPrecondition, "volume %s rejected by placement webhook: %s", pr.Name, resp.Reason)
+	}
+	placementWebhookDuration.WithLabelValues(placementWebhookResultAllowed).Observe(time.Since(start).Seconds())
+
+	decision := builtin
+	if resp.VolumeType != "" {
+		decision.volType = resp.VolumeType
+	}
+	if resp.AvailabilityZone != "" {
+		decision.availability = resp.AvailabilityZone
+	}
+	decision.metadata = resp.Metadata
+
+	klog.V(4).Infof("Placement webhook chose volume type %q in availability zone %q for volume %s", decision.volType, decision.availability, pr.Name)
+	return decision, nil
+}
+
+func (p *placementWebhook) call(ctx context.Context, pr *PlacementRequest) (*PlacementResponse, error) {
+	body, err := json.Marshal(pr)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from %s: %s", p.url, resp.Status)
+	}
+
+	var placementResp PlacementResponse
+	if err := json.NewDecoder(resp.Body).Decode(&placementResp); err != nil {
+		return nil, fmt.Errorf("failed to decode placement webhook response: %v", err)
+	}
+	if placementResp.APIVersion != PlacementAPIVersion {
+		return nil, fmt.Errorf("unsupported placement webhook response version %q, expected %q", placementResp.APIVersion, PlacementAPIVersion)
+	}
+
+	return &placementResp, nil
+}
+
+func zonesFromTopologies(topologies []*csi.Topology, keys topologyKeys) []string {
+	var zones []string
+	for _, topology := range topologies {
+		if zone, exists := keys.zone(topology.GetSegments()); exists {
+			zones = appendUnique(zones, zone)
+		}
+	}
+	return zones
+}
+
+func appendUnique(list []string, s string) []string {
+	if s == "" {
+		return list
+	}
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newFakePlacementServer(t *testing.T, delay time.Duration, resp PlacementResponse) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req PlacementRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode placement request: %v", err)
+		}
+		if req.APIVersion != PlacementAPIVersion {
+			t.Errorf("unexpected placement request version %q", req.APIVersion)
+		}
+		time.Sleep(delay)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestNewPlacementRequest(t *testing.T) {
+	req := &csi.CreateVolumeRequest{
+		Name:       fakeVolName,
+		Parameters: map[string]string{"type": "fast"},
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{
+				{Segments: map[string]string{topologyKey: "az1"}},
+				{Segments: map[string]string{topologyKey: "az2"}},
+			},
+			Preferred: []*csi.Topology{
+				{Segments: map[string]string{topologyKey: "az2"}},
+			},
+		},
+	}
+
+	pr := newPlacementRequest(req, 10, placementDecision{volType: "fast", availability: "az2"}, newTopologyKeys())
+
+	assert.Equal(t, PlacementAPIVersion, pr.APIVersion)
+	assert.Equal(t, 10, pr.SizeGB)
+	assert.Equal(t, []string{"fast"}, pr.CandidateTypes)
+	assert.Equal(t, []string{"az2", "az1"}, pr.CandidateZones)
+	assert.Equal(t, []string{"az1", "az2"}, pr.RequisiteZones)
+	assert.Equal(t, []string{"az2"}, pr.PreferredZones)
+}
+
+func TestPlacementWebhookDecide(t *testing.T) {
+	builtin := placementDecision{volType: "standard", availability: "nova"}
+	pr := &PlacementRequest{APIVersion: PlacementAPIVersion, Name: fakeVolName}
+
+	// Webhook overrides type, AZ and adds metadata
+	srv := newFakePlacementServer(t, 0, PlacementResponse{
+		APIVersion:       PlacementAPIVersion,
+		Allowed:          true,
+		VolumeType:       "fast",
+		AvailabilityZone: "az1",
+		Metadata:         map[string]string{"tier": "gold"},
+	})
+	defer srv.Close()
+
+	decision, err := newPlacementWebhook(srv.URL, time.Second, false).decide(fakeCtx, pr, builtin)
+	assert.NoError(t, err)
+	assert.Equal(t, "fast", decision.volType)
+	assert.Equal(t, "az1", decision.availability)
+	assert.Equal(t, map[string]string{"tier": "gold"}, decision.metadata)
+
+	// Webhook rejects the volume
+	rejectSrv := newFakePlacementServer(t, 0, PlacementResponse{
+		APIVersion: PlacementAPIVersion,
+		Allowed:    false,
+		Reason:     "tenant over budget",
+	})
+	defer rejectSrv.Close()
+
+	_, err = newPlacementWebhook(rejectSrv.URL, time.Second, true).decide(fakeCtx, pr, builtin)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, err.Error(), "tenant over budget")
+}
+
+func TestPlacementWebhookTimeout(t *testing.T) {
+	builtin := placementDecision{volType: "standard", availability: "nova"}
+	pr := &PlacementRequest{APIVersion: PlacementAPIVersion, Name: fakeVolName}
+
+	srv := newFakePlacementServer(t, 200*time.Millisecond, PlacementResponse{
+		APIVersion: PlacementAPIVersion,
+		Allowed:    true,
+		VolumeType: "fast",
+	})
+	defer srv.Close()
+
+	// Fail open falls back to the built-in placement
+	decision, err := newPlacementWebhook(srv.URL, 50*time.Millisecond, true).decide(fakeCtx, pr, builtin)
+	assert.NoError(t, err)
+	assert.Equal(t, builtin, decision)
+
+	// Fail closed surfaces the error
+	_, err = newPlacementWebhook(srv.URL, 50*time.Millisecond, false).decide(fakeCtx, pr, builtin)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestPlacementWebhookVersionMismatch(t *testing.T) {
+	builtin := placementDecision{volType: "standard", availability: "nova"}
+	pr := &PlacementRequest{APIVersion: PlacementAPIVersion, Name: fakeVolName}
+
+	srv := newFakePlacementServer(t, 0, PlacementResponse{
+		APIVersion: "placement.cinder.csi.openstack.org/v0",
+		Allowed:    true,
+	})
+	defer srv.Close()
+
+	_, err := newPlacementWebhook(srv.URL, time.Second, false).decide(fakeCtx, pr, builtin)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
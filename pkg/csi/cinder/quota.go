@@ -0,0 +1,264 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/klog"
+)
+
+// namespaceMetadataKey is the volume metadata key holding the namespace of
+// the PVC the volume was provisioned for, with the namespace quota enabled
+const namespaceMetadataKey = driverName + "/namespace"
+
+// namespaceQuota caps the capacity provisioned per namespace at the limits of
+// a ConfigMap, whose keys are namespaces and values limits in GiB. Namespaces
+// without a limit are not capped.
+type namespaceQuota struct {
+	client             kubernetes.Interface
+	configMapNamespace string
+	configMapName      string
+
+	mu sync.Mutex
+	// built is whether usage was rebuilt from the volumes in Cinder
+	built bool
+	// usage is the GiB provisioned per namespace, including the pending
+	// reservations
+	usage map[string]int
+	// volumes are the namespace and size of the volumes counted in usage
+	volumes map[string]quotaVolume
+	// pending are the reservations of the volumes being created, by name
+	pending map[string]quotaVolume
+}
+
+type quotaVolume struct {
+	namespace string
+	sizeGB    int
+}
+
+// SetNamespaceQuota caps the capacity provisioned per namespace at the limits
+// of the ConfigMap namespace/name. The namespace of a volume comes from the
+// PVC namespace parameter the external-provisioner passes with
+// --extra-create-metadata.
+func (d *CinderDriver) SetNamespaceQuota(client kubernetes.Interface, configMap string) error {
+	parts := strings.Split(configMap, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("the namespace quota ConfigMap %q must be <namespace>/<name>", configMap)
+	}
+	klog.Infof("Enforcing the namespace quota of ConfigMap %s", configMap)
+	d.quota = &namespaceQuota{
+		client:             client,
+		configMapNamespace: parts[0],
+		configMapName:      parts[1],
+		usage:              map[string]int{},
+		volumes:            map[string]quotaVolume{},
+		pending:            map[string]quotaVolume{},
+	}
+	return nil
+}
+
+// limits returns the limits of the ConfigMap, read on every use so that
+// changes apply right away.
+func (q *namespaceQuota) limits() (map[string]int, error) {
+	cm, err := q.client.CoreV1().ConfigMaps(q.configMapNamespace).Get(q.configMapName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the namespace quota ConfigMap %s/%s: %v", q.configMapNamespace, q.configMapName, err)
+	}
+	limits := make(map[string]int, len(cm.Data))
+	for namespace, value := range cm.Data {
+		limit, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid limit %q of namespace %s in ConfigMap %s/%s, must be a number of GiB", value, namespace, q.configMapNamespace, q.configMapName)
+		}
+		limits[namespace] = limit
+	}
+	return limits, nil
+}
+
+// rebuild recomputes the usage from the namespace metadata of the volumes of
+// the cluster in Cinder. The pending reservations are kept.
+func (q *namespaceQuota) rebuild(cloud openstack.IOpenStack, cluster string) error {
+	vols, err := cloud.ListVolumes()
+	if err != nil {
+		return fmt.Errorf("failed to list volumes: %v", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.usage = map[string]int{}
+	q.volumes = map[string]quotaVolume{}
+	for _, vol := range vols {
+		namespace := vol.Metadata[namespaceMetadataKey]
+		if namespace == "" || vol.Metadata[clusterMetadataKey] != cluster || vol.Status == openstack.VolumeDeletingStatus {
+			continue
+		}
+		q.volumes[vol.ID] = quotaVolume{namespace: namespace, sizeGB: vol.Size}
+		q.usage[namespace] += vol.Size
+	}
+	for _, p := range q.pending {
+		q.usage[p.namespace] += p.sizeGB
+	}
+	q.built = true
+	klog.V(4).Infof("Rebuilt the namespace quota usage from %d volumes: %v", len(q.volumes), q.usage)
+	return nil
+}
+
+// reserve reserves sizeGB in namespace for the volume volName, unless it
+// would exceed the limit of the namespace.
+func (q *namespaceQuota) reserve(cloud openstack.IOpenStack, cluster, volName, namespace string, sizeGB int) error {
+	limits, err := q.limits()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	q.mu.Lock()
+	built := q.built
+	q.mu.Unlock()
+	if !built {
+		if err := q.rebuild(cloud, cluster); err != nil {
+			return status.Errorf(codes.Internal, "failed to rebuild the namespace quota usage: %v", err)
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, exists := q.pending[volName]; exists {
+		return status.Errorf(codes.Aborted, "volume %s is already being created", volName)
+	}
+	limit, limited := limits[namespace]
+	if limited && q.usage[namespace]+sizeGB > limit {
+		return status.Errorf(codes.ResourceExhausted, "namespace %s has %d GiB provisioned of its %d GiB limit, cannot provision %d GiB more", namespace, q.usage[namespace], limit, sizeGB)
+	}
+	q.pending[volName] = quotaVolume{namespace: namespace, sizeGB: sizeGB}
+	q.usage[namespace] += sizeGB
+	return nil
+}
+
+// commit counts the reservation of volName as the volume volumeID once it
+// was created, with its actual size.
+func (q *namespaceQuota) commit(volName, volumeID string, sizeGB int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p, exists := q.pending[volName]
+	if !exists {
+		return
+	}
+	delete(q.pending, volName)
+	q.usage[p.namespace] += sizeGB - p.sizeGB
+	q.volumes[volumeID] = quotaVolume{namespace: p.namespace, sizeGB: sizeGB}
+}
+
+// cancel releases the reservation of volName when its volume was not
+// created.
+func (q *namespaceQuota) cancel(volName string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p, exists := q.pending[volName]
+	if !exists {
+		return
+	}
+	delete(q.pending, volName)
+	q.release(p)
+}
+
+// expand counts volumeID at sizeGB once extended, unless the growth would
+// exceed the limit of its namespace. Volumes without a namespace are not
+// counted.
+func (q *namespaceQuota) expand(cloud openstack.IOpenStack, cluster, volumeID string, sizeGB int) error {
+	limits, err := q.limits()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	q.mu.Lock()
+	built := q.built
+	q.mu.Unlock()
+	if !built {
+		if err := q.rebuild(cloud, cluster); err != nil {
+			return status.Errorf(codes.Internal, "failed to rebuild the namespace quota usage: %v", err)
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	v, exists := q.volumes[volumeID]
+	if !exists || sizeGB <= v.sizeGB {
+		return nil
+	}
+	limit, limited := limits[v.namespace]
+	if limited && q.usage[v.namespace]+sizeGB-v.sizeGB > limit {
+		return status.Errorf(codes.ResourceExhausted, "namespace %s has %d GiB provisioned of its %d GiB limit, cannot expand volume %s by %d GiB", v.namespace, q.usage[v.namespace], limit, volumeID, sizeGB-v.sizeGB)
+	}
+	q.resize(volumeID, sizeGB)
+	return nil
+}
+
+// resize counts volumeID at sizeGB, with mu held.
+func (q *namespaceQuota) resize(volumeID string, sizeGB int) {
+	v, exists := q.volumes[volumeID]
+	if !exists {
+		return
+	}
+	q.usage[v.namespace] += sizeGB - v.sizeGB
+	q.volumes[volumeID] = quotaVolume{namespace: v.namespace, sizeGB: sizeGB}
+}
+
+// expandFailed counts volumeID back at sizeGB when extending it failed.
+func (q *namespaceQuota) expandFailed(volumeID string, sizeGB int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.resize(volumeID, sizeGB)
+}
+
+// deleted releases the capacity of a deleted volume.
+func (q *namespaceQuota) deleted(volumeID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	v, exists := q.volumes[volumeID]
+	if !exists {
+		return
+	}
+	delete(q.volumes, volumeID)
+	q.release(v)
+}
+
+func (q *namespaceQuota) release(v quotaVolume) {
+	q.usage[v.namespace] -= v.sizeGB
+	if q.usage[v.namespace] <= 0 {
+		delete(q.usage, v.namespace)
+	}
+}
+
+// snapshot returns a copy of the usage per namespace.
+func (q *namespaceQuota) snapshot() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	usage := make(map[string]int, len(q.usage))
+	for namespace, sizeGB := range q.usage {
+		usage[namespace] = sizeGB
+	}
+	return usage
+}
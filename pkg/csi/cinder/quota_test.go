@@ -0,0 +1,127 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+)
+
+func TestNamespaceQuota(t *testing.T) {
+	// mock OpenStack, team-a already has 8 GiB
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("ListVolumes").Return([]openstack.Volume{
+		{ID: "vol-a", Size: 8, Metadata: map[string]string{clusterMetadataKey: fakeCluster, namespaceMetadataKey: "team-a"}},
+		{ID: "vol-other-cluster", Size: 8, Metadata: map[string]string{clusterMetadataKey: "other", namespaceMetadataKey: "team-a"}},
+		{ID: "vol-deleting", Size: 8, Status: openstack.VolumeDeletingStatus, Metadata: map[string]string{clusterMetadataKey: fakeCluster, namespaceMetadataKey: "team-a"}},
+		{ID: "vol-untagged", Size: 8, Metadata: map[string]string{clusterMetadataKey: fakeCluster}},
+	}, nil)
+	properties := map[string]string{clusterMetadataKey: fakeCluster, namespaceMetadataKey: "team-a"}
+	osmock.On("CreateVolume", "pvc-small", 2, "", "", "", "", &properties).Return("vol-small", fakeAvailability, 2, nil)
+	osmock.On("GetVolume", "vol-small").Return(openstack.Volume{ID: "vol-small", Status: openstack.VolumeAvailableStatus}, nil)
+	osmock.On("CreateVolume", "pvc-failing", 1, "", "", "", "", &properties).Return("", "", 0, errors.New("quota exceeded for gigabytes"))
+	osmock.On("DeleteVolume", "vol-a").Return(nil)
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "cinder-csi-quota"},
+		Data:       map[string]string{"team-a": "10"},
+	}
+	assert.Error(fakeCs.Driver.SetNamespaceQuota(fake.NewSimpleClientset(configMap), "cinder-csi-quota"))
+	assert.NoError(fakeCs.Driver.SetNamespaceQuota(fake.NewSimpleClientset(configMap), "kube-system/cinder-csi-quota"))
+	defer func() { fakeCs.Driver.quota = nil }()
+
+	request := func(name string, sizeGB int64) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:          name,
+			CapacityRange: &csi.CapacityRange{RequiredBytes: sizeGB * 1024 * 1024 * 1024},
+			Parameters:    map[string]string{pvcNamespaceParameter: "team-a"},
+		}
+	}
+
+	// The usage is rebuilt from the volumes of the cluster on first use
+	_, err := fakeCs.CreateVolume(fakeCtx, request("pvc-small", 2))
+	assert.NoError(err)
+	assert.Equal(map[string]int{"team-a": 10}, fakeCs.Driver.quota.snapshot())
+
+	_, err = fakeCs.CreateVolume(fakeCtx, request("pvc-large", 1))
+	assert.Equal(codes.ResourceExhausted, status.Code(err))
+	assert.Contains(err.Error(), "namespace team-a has 10 GiB provisioned of its 10 GiB limit")
+	osmock.AssertNotCalled(t, "CreateVolume", "pvc-large", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// Deleting a volume frees its capacity, and a failed create does not
+	// keep its reservation
+	_, err = fakeCs.DeleteVolume(fakeCtx, &csi.DeleteVolumeRequest{VolumeId: "vol-a"})
+	assert.NoError(err)
+	assert.Equal(map[string]int{"team-a": 2}, fakeCs.Driver.quota.snapshot())
+	_, err = fakeCs.CreateVolume(fakeCtx, request("pvc-failing", 1))
+	assert.Error(err)
+	assert.Equal(map[string]int{"team-a": 2}, fakeCs.Driver.quota.snapshot())
+
+	// Namespaces without a limit are counted but not capped
+	assert.NoError(fakeCs.Driver.quota.reserve(osmock, fakeCluster, "pvc-unlimited", "team-b", 100))
+	assert.Equal(map[string]int{"team-a": 2, "team-b": 100}, fakeCs.Driver.quota.snapshot())
+}
+
+func TestNamespaceQuotaExpand(t *testing.T) {
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("ListVolumes").Return([]openstack.Volume{
+		{ID: "vol-a", Size: 4, Metadata: map[string]string{clusterMetadataKey: fakeCluster, namespaceMetadataKey: "team-a"}},
+	}, nil)
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "cinder-csi-quota"},
+		Data:       map[string]string{"team-a": "10"},
+	}
+	d := NewDriver(fakeNodeID, fakeEndpoint, fakeCluster, fakeConfig)
+	assert.NoError(t, d.SetNamespaceQuota(fake.NewSimpleClientset(configMap), "kube-system/cinder-csi-quota"))
+
+	assert.NoError(t, d.quota.expand(osmock, fakeCluster, "vol-a", 8))
+	assert.Equal(t, map[string]int{"team-a": 8}, d.quota.snapshot())
+
+	err := d.quota.expand(osmock, fakeCluster, "vol-a", 12)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "cannot expand volume vol-a by 4 GiB")
+
+	d.quota.expandFailed("vol-a", 4)
+	assert.Equal(t, map[string]int{"team-a": 4}, d.quota.snapshot())
+}
+
+func TestNamespaceQuotaInvalidLimit(t *testing.T) {
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "cinder-csi-quota"},
+		Data:       map[string]string{"team-a": "10Gi"},
+	}
+	d := NewDriver(fakeNodeID, fakeEndpoint, fakeCluster, fakeConfig)
+	assert.NoError(t, d.SetNamespaceQuota(fake.NewSimpleClientset(configMap), "kube-system/cinder-csi-quota"))
+
+	err := d.quota.reserve(new(openstack.OpenStackMock), fakeCluster, "pvc", "team-a", 1)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Contains(t, err.Error(), `invalid limit "10Gi" of namespace team-a`)
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog"
+)
+
+const (
+	// regionParameter is the StorageClass parameter of the region to create
+	// the volumes in, one of the extra-region of the cloud config
+	regionParameter = "region"
+	// regionTopologyKey is the topology key nodes report the region of
+	// their cloud config with, see SetTopologyRegion
+	regionTopologyKey = "topology." + driverName + "/region"
+)
+
+// SetTopologyRegion makes nodes report the region of their cloud config in
+// their topology, for CreateVolume to create the volumes of a cluster
+// spanning regions in the region of the node they are for.
+func (d *CinderDriver) SetTopologyRegion(enabled bool) {
+	d.topologyRegion = enabled
+}
+
+// getRegionFromTopology returns the region of the first topology of
+// requirement with one, preferred first.
+func getRegionFromTopology(requirement *csi.TopologyRequirement) string {
+	for _, topology := range requirement.GetPreferred() {
+		if region, exists := topology.GetSegments()[regionTopologyKey]; exists {
+			return region
+		}
+	}
+	for _, topology := range requirement.GetRequisite() {
+		if region, exists := topology.GetSegments()[regionTopologyKey]; exists {
+			return region
+		}
+	}
+	return ""
+}
+
+// volumeRegions remembers the regions of the volumes and snapshots outside
+// the region of the cloud config, not to look for them in every region on
+// each operation.
+type volumeRegions struct {
+	mu sync.Mutex
+	// regions are the regions by volume or snapshot ID
+	regions map[string]string
+}
+
+func newVolumeRegions() *volumeRegions {
+	return &volumeRegions{regions: map[string]string{}}
+}
+
+func (r *volumeRegions) get(id string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	region, ok := r.regions[id]
+	return region, ok
+}
+
+func (r *volumeRegions) set(id, region string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.regions[id] = region
+}
+
+func (r *volumeRegions) forget(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.regions, id)
+}
+
+// cloudForCreate returns the cloud of the region CreateVolume creates req in,
+// and the region: the one of the region parameter, or of the topology
+// requirement, that of the cloud config without either. fromTopology is
+// whether the region comes from the topology, for the volume to be accessible
+// from it only.
+func (cs *controllerServer) cloudForCreate(req *csi.CreateVolumeRequest) (cloud openstack.IOpenStack, region string, fromTopology bool, err error) {
+	region = req.GetParameters()[regionParameter]
+	if region == "" {
+		region = getRegionFromTopology(req.GetAccessibilityRequirements())
+		fromTopology = region != ""
+	}
+
+	cloud, err = openstack.GetOpenStackProviderForRegion(region)
+	if err != nil {
+		if _, ok := err.(openstack.ErrUnknownRegion); ok {
+			return nil, "", false, status.Errorf(codes.InvalidArgument, "CreateVolume %v", err)
+		}
+		return nil, "", false, err
+	}
+	return cloud, region, fromTopology, nil
+}
+
+// cloudForVolume returns the cloud of the region volumeID is in. With extra
+// regions, a volume not found in the region of the cloud config is looked
+// for in each of them. A volume found nowhere is left to the region of the
+// cloud config to report.
+func (cs *controllerServer) cloudForVolume(volumeID string) (openstack.IOpenStack, error) {
+	return cs.cloudForResource(volumeID, func(cloud openstack.IOpenStack) error {
+		_, err := cloud.GetVolume(volumeID)
+		return err
+	})
+}
+
+// cloudForSnapshot returns the cloud of the region snapshotID is in, see
+// cloudForVolume. With --snapshot-backups, snapshotID may be a backup.
+func (cs *controllerServer) cloudForSnapshot(snapshotID string) (openstack.IOpenStack, error) {
+	return cs.cloudForResource(snapshotID, func(cloud openstack.IOpenStack) error {
+		_, err := cloud.GetSnapshotByID(snapshotID)
+		if err != nil && cs.Driver.snapshotBackups && cpoerrors.IsNotFound(err) {
+			_, err = cloud.GetBackupByID(snapshotID)
+		}
+		return err
+	})
+}
+
+// cloudForResource returns the cloud of the region get finds the volume or
+// snapshot id in, see cloudForVolume.
+func (cs *controllerServer) cloudForResource(id string, get func(openstack.IOpenStack) error) (openstack.IOpenStack, error) {
+	cloud, err := openstack.GetOpenStackProvider()
+	if err != nil {
+		return nil, err
+	}
+	regions, err := openstack.GetExtraRegions()
+	if err != nil || len(regions) == 0 {
+		return cloud, err
+	}
+
+	if region, ok := cs.volumeRegions.get(id); ok {
+		if regionCloud, err := openstack.GetOpenStackProviderForRegion(region); err == nil {
+			return regionCloud, nil
+		}
+		// The region was removed from the config
+		cs.volumeRegions.forget(id)
+	}
+
+	// Other errors are left to the operation to report
+	if err := get(cloud); err == nil || !cpoerrors.IsNotFound(err) {
+		return cloud, nil
+	}
+	for _, region := range regions {
+		regionCloud, err := openstack.GetOpenStackProviderForRegion(region)
+		if err != nil {
+			return nil, err
+		}
+		if err := get(regionCloud); err == nil {
+			klog.V(4).Infof("Found %s in region %s", id, region)
+			cs.volumeRegions.set(id, region)
+			return regionCloud, nil
+		}
+	}
+	return cloud, nil
+}
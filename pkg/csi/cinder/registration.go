@@ -0,0 +1,213 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	registerapi "k8s.io/kubernetes/pkg/kubelet/apis/pluginregistration/v1"
+)
+
+const (
+	// registrationCheckInterval is how often the registration socket is
+	// checked for
+	registrationCheckInterval = 5 * time.Second
+
+	// registrationGracePeriod is how long the plugin may wait for kubelet to
+	// register it before the health check fails
+	registrationGracePeriod = 2 * time.Minute
+
+	// registrationHealthPath is the path the registration health check is
+	// served on
+	registrationHealthPath = "/healthz"
+)
+
+// kubeletRegistration registers the plugin with kubelet through a socket in
+// the kubelet plugin registration directory, and registers it again when the
+// socket disappears, e.g. because kubelet restarted and wiped the directory.
+type kubeletRegistration struct {
+	socketPath    string
+	endpoint      string
+	checkInterval time.Duration
+
+	mu         sync.Mutex
+	server     *grpc.Server
+	registered bool
+	// since is when the socket was created or registered was last changed
+	since time.Time
+}
+
+func newKubeletRegistration(registrationDir, endpoint string) *kubeletRegistration {
+	return &kubeletRegistration{
+		socketPath:    filepath.Join(registrationDir, driverName+"-reg.sock"),
+		endpoint:      endpoint,
+		checkInterval: registrationCheckInterval,
+		since:         time.Now(),
+	}
+}
+
+// SetKubeletRegistration makes the node plugin register itself with kubelet
+// through registrationDir, the kubelet plugin registration directory, instead
+// of relying on a node-driver-registrar sidecar. endpoint is the path of the
+// CSI socket on the node, as kubelet sees it. When healthAddress is not empty,
+// a health check failing once the plugin has not been registered for
+// registrationGracePeriod is served on it.
+func (d *CinderDriver) SetKubeletRegistration(registrationDir, endpoint, healthAddress string) {
+	d.registration = newKubeletRegistration(registrationDir, endpoint)
+	d.registrationHealthAddress = healthAddress
+}
+
+// GetInfo is called by kubelet when it finds the registration socket.
+func (r *kubeletRegistration) GetInfo(ctx context.Context, req *registerapi.InfoRequest) (*registerapi.PluginInfo, error) {
+	klog.V(4).Infof("Kubelet requested the plugin info on %s", r.socketPath)
+	return &registerapi.PluginInfo{
+		Type:              registerapi.CSIPlugin,
+		Name:              driverName,
+		Endpoint:          r.endpoint,
+		SupportedVersions: []string{"1.0.0"},
+	}, nil
+}
+
+// NotifyRegistrationStatus is called by kubelet with the outcome of the
+// registration.
+func (r *kubeletRegistration) NotifyRegistrationStatus(ctx context.Context, status *registerapi.RegistrationStatus) (*registerapi.RegistrationStatusResponse, error) {
+	if status.PluginRegistered {
+		klog.Infof("Registered with kubelet")
+	} else {
+		klog.Errorf("Kubelet failed to register the plugin: %s", status.Error)
+	}
+	r.setRegistered(status.PluginRegistered)
+	return &registerapi.RegistrationStatusResponse{}, nil
+}
+
+func (r *kubeletRegistration) setRegistered(registered bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.registered != registered {
+		r.registered = registered
+		r.since = time.Now()
+	}
+}
+
+// status returns whether the plugin is registered and since when.
+func (r *kubeletRegistration) status() (bool, time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.registered, r.since
+}
+
+// run serves the registration socket until stop is closed, creating it again
+// whenever it disappears.
+func (r *kubeletRegistration) run(stop <-chan struct{}) {
+	wait.Until(func() {
+		if _, err := os.Stat(r.socketPath); err == nil {
+			return
+		} else if !os.IsNotExist(err) {
+			klog.Warningf("Failed to check the registration socket %s: %v", r.socketPath, err)
+			return
+		}
+
+		r.mu.Lock()
+		reregister := r.server != nil
+		r.mu.Unlock()
+		if reregister {
+			klog.Warningf("Registration socket %s is gone, registering with kubelet again", r.socketPath)
+		}
+		if err := r.serve(); err != nil {
+			klog.Errorf("Failed to serve the registration socket %s: %v", r.socketPath, err)
+		}
+	}, r.checkInterval, stop)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.server != nil {
+		r.server.Stop()
+	}
+}
+
+// serve creates the registration socket, replacing the previous one. Kubelet
+// calls GetInfo once it notices the socket.
+func (r *kubeletRegistration) serve() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Stopping the previous server removes its socket, it has to be done
+	// before the new one is created at the same path
+	if r.server != nil {
+		r.server.Stop()
+		r.server = nil
+	}
+	r.registered = false
+	r.since = time.Now()
+
+	if err := os.MkdirAll(filepath.Dir(r.socketPath), 0750); err != nil {
+		return err
+	}
+	if err := os.Remove(r.socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", r.socketPath)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer()
+	registerapi.RegisterRegistrationServer(server, r)
+	r.server = server
+	go server.Serve(listener)
+
+	klog.Infof("Serving the registration socket %s for kubelet", r.socketPath)
+	return nil
+}
+
+// ServeHTTP fails once the plugin has not been registered with kubelet for
+// registrationGracePeriod.
+func (r *kubeletRegistration) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	registered, since := r.status()
+	if !registered {
+		unregistered := time.Since(since)
+		if unregistered > registrationGracePeriod {
+			http.Error(w, fmt.Sprintf("not registered with kubelet for %v", unregistered.Round(time.Second)), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// serveRegistrationHealth serves the registration health check on address in
+// the background.
+func serveRegistrationHealth(address string, r *kubeletRegistration) {
+	mux := http.NewServeMux()
+	mux.Handle(registrationHealthPath, r)
+
+	go func() {
+		klog.Infof("Serving the registration health check on %s%s", address, registrationHealthPath)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			klog.Errorf("Failed to serve the registration health check on %s: %v", address, err)
+		}
+	}()
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/util/wait"
+	registerapi "k8s.io/kubernetes/pkg/kubelet/apis/pluginregistration/v1"
+)
+
+const fakeKubeletEndpoint = "/var/lib/kubelet/plugins/cinder.csi.openstack.org/csi.sock"
+
+// kubeletRegister does the registration handshake of kubelet on the
+// registration socket.
+func kubeletRegister(t *testing.T, socketPath string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, socketPath, grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	client := registerapi.NewRegistrationClient(conn)
+	info, err := client.GetInfo(ctx, &registerapi.InfoRequest{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, registerapi.CSIPlugin, info.Type)
+	assert.Equal(t, driverName, info.Name)
+	assert.Equal(t, fakeKubeletEndpoint, info.Endpoint)
+
+	_, err = client.NotifyRegistrationStatus(ctx, &registerapi.RegistrationStatus{PluginRegistered: true})
+	assert.NoError(t, err)
+}
+
+func registrationHealth(r *kubeletRegistration) int {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", registrationHealthPath, nil))
+	return w.Code
+}
+
+func TestKubeletRegistration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registration")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := newKubeletRegistration(dir, fakeKubeletEndpoint)
+	r.checkInterval = 10 * time.Millisecond
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		r.run(stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// waitServed waits for the registration socket to be served, and not
+	// registered yet
+	waitServed := func() {
+		err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			registered, _ := r.status()
+			_, err := os.Stat(r.socketPath)
+			return !registered && err == nil, nil
+		})
+		assert.NoError(t, err)
+	}
+
+	waitServed()
+	assert.Equal(t, http.StatusOK, registrationHealth(r), "healthy during the grace period")
+	kubeletRegister(t, r.socketPath)
+	registered, _ := r.status()
+	assert.True(t, registered)
+
+	// kubelet wiping the registration directory
+	assert.NoError(t, os.Remove(r.socketPath))
+	waitServed()
+	kubeletRegister(t, r.socketPath)
+	registered, _ = r.status()
+	assert.True(t, registered, "registered again")
+	assert.Equal(t, http.StatusOK, registrationHealth(r))
+
+	// Not registered for longer than the grace period
+	r.setRegistered(false)
+	r.mu.Lock()
+	r.since = time.Now().Add(-registrationGracePeriod - time.Second)
+	r.mu.Unlock()
+	assert.Equal(t, http.StatusServiceUnavailable, registrationHealth(r))
+}
@@ -43,10 +43,18 @@ func NewNonBlockingGRPCServer() NonBlockingGRPCServer {
 	return &nonBlockingGRPCServer{}
 }
 
+// NewV0NonBlockingGRPCServer serves the CSI v0 services of the given v1
+// servers, for the sidecars older than CSI 1.0.
+func NewV0NonBlockingGRPCServer() NonBlockingGRPCServer {
+	return &nonBlockingGRPCServer{v0: true}
+}
+
 // NonBlocking server
 type nonBlockingGRPCServer struct {
 	wg     sync.WaitGroup
 	server *grpc.Server
+	// v0 serves the CSI v0 services instead of the v1 ones
+	v0 bool
 }
 
 func (s *nonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
@@ -95,6 +103,13 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 	server := grpc.NewServer(opts...)
 	s.server = server
 
+	if s.v0 {
+		registerV0Services(server, ids, cs, ns)
+		klog.Infof("Listening for CSI v0 connections on address: %#v", listener.Addr())
+		server.Serve(listener)
+		return
+	}
+
 	if ids != nil {
 		csi.RegisterIdentityServer(server, ids)
 	}
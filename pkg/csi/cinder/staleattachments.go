@@ -0,0 +1,134 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/klog"
+)
+
+const (
+	staleAttachmentResultDetached = "detached"
+	staleAttachmentResultReset    = "reset"
+	staleAttachmentResultFailed   = "failed"
+)
+
+// staleAttachments detaches the volumes of the cluster from the instances
+// Nova no longer has, e.g. of a node deleted while its volumes were attached
+// or detaching. Such volumes stay in-use and can never be attached again.
+type staleAttachments struct {
+	cluster string
+	// detaching are the volumes found detaching without attachments on the
+	// previous pass, reset when still so on the next one
+	detaching map[string]bool
+}
+
+func newStaleAttachments(cluster string) *staleAttachments {
+	return &staleAttachments{cluster: cluster, detaching: map[string]bool{}}
+}
+
+// run checks the attachments of the volumes every interval until stop is
+// closed.
+func (s *staleAttachments) run(interval time.Duration, stop <-chan struct{}) {
+	klog.Infof("Detaching volumes from deleted instances every %v", interval)
+	wait.Until(func() {
+		cloud, err := openstack.GetOpenStackProvider()
+		if err == nil {
+			err = s.reconcile(cloud)
+		}
+		if err != nil {
+			klog.Warningf("Failed to check the attachments of the volumes: %v", err)
+		}
+	}, interval, stop)
+}
+
+// reconcile force-detaches the volumes of the cluster from the instances Nova
+// answers are not found, and resets the status of the volumes left detaching
+// without attachments since the previous pass. An instance that cannot be
+// looked up is kept.
+func (s *staleAttachments) reconcile(cloud openstack.IOpenStack) error {
+	vols, err := cloud.ListVolumes()
+	if err != nil {
+		return fmt.Errorf("failed to list volumes: %v", err)
+	}
+
+	// Looked up once per pass, a node has many volumes
+	instances := map[string]bool{}
+	detaching := map[string]bool{}
+	for _, vol := range vols {
+		if owner, tagged := vol.Metadata[clusterMetadataKey]; !tagged || owner != s.cluster {
+			continue
+		}
+		if vol.Status != openstack.VolumeInUseStatus && vol.Status != openstack.VolumeDetachingStatus {
+			continue
+		}
+
+		if len(vol.Attachments) == 0 && vol.Status == openstack.VolumeDetachingStatus {
+			if !s.detaching[vol.ID] {
+				detaching[vol.ID] = true
+				continue
+			}
+			klog.Warningf("Volume %s is %s without attachments since the previous check, resetting it to %s", vol.ID, vol.Status, openstack.VolumeAvailableStatus)
+			if err := cloud.ResetVolumeStatus(vol.ID, openstack.VolumeAvailableStatus); err != nil {
+				klog.Errorf("Failed to reset the status of volume %s: %v", vol.ID, err)
+				staleAttachmentsReconciled.WithLabelValues(staleAttachmentResultFailed).Inc()
+				continue
+			}
+			staleAttachmentsReconciled.WithLabelValues(staleAttachmentResultReset).Inc()
+			continue
+		}
+
+		for _, a := range vol.Attachments {
+			// Attachments of the connector attach mode, e.g. of bare metal
+			// nodes, are not to a Nova instance
+			if a.ServerID == "" {
+				continue
+			}
+			exists, checked := instances[a.ServerID]
+			if !checked {
+				exists, err = cloud.InstanceExists(a.ServerID)
+				if err != nil {
+					klog.Warningf("Failed to look up instance %s volume %s is attached to: %v", a.ServerID, vol.ID, err)
+					continue
+				}
+				instances[a.ServerID] = exists
+			}
+			if exists {
+				continue
+			}
+			if a.ID == "" {
+				klog.Warningf("Volume %s is attached to deleted instance %s without an attachment ID, not detaching it", vol.ID, a.ServerID)
+				continue
+			}
+
+			klog.Warningf("Volume %s is attached to deleted instance %s, force-detaching it", vol.ID, a.ServerID)
+			if err := cloud.ForceDetachVolume(vol.ID, a.ID); err != nil {
+				klog.Errorf("Failed to force-detach volume %s from deleted instance %s: %v", vol.ID, a.ServerID, err)
+				staleAttachmentsReconciled.WithLabelValues(staleAttachmentResultFailed).Inc()
+				continue
+			}
+			staleAttachmentsReconciled.WithLabelValues(staleAttachmentResultDetached).Inc()
+			klog.Infof("Force-detached volume %s from deleted instance %s", vol.ID, a.ServerID)
+		}
+	}
+	s.detaching = detaching
+	return nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+)
+
+func TestStaleAttachments(t *testing.T) {
+	owned := map[string]string{clusterMetadataKey: fakeCluster}
+	vols := []openstack.Volume{
+		{ID: "vol-ghost", Status: openstack.VolumeInUseStatus, Metadata: owned, Attachments: []openstack.Attachment{{ID: "att-1", ServerID: "server-deleted"}}},
+		{ID: "vol-ghost-2", Status: openstack.VolumeDetachingStatus, Metadata: owned, Attachments: []openstack.Attachment{{ID: "att-2", ServerID: "server-deleted"}}},
+		{ID: "vol-alive", Status: openstack.VolumeInUseStatus, Metadata: owned, Attachments: []openstack.Attachment{{ID: "att-3", ServerID: "server-alive"}}},
+		{ID: "vol-unknown", Status: openstack.VolumeInUseStatus, Metadata: owned, Attachments: []openstack.Attachment{{ID: "att-4", ServerID: "server-unknown"}}},
+		{ID: "vol-other-cluster", Status: openstack.VolumeInUseStatus, Metadata: map[string]string{clusterMetadataKey: "other"}, Attachments: []openstack.Attachment{{ID: "att-5", ServerID: "server-deleted"}}},
+		{ID: "vol-untagged", Status: openstack.VolumeInUseStatus, Attachments: []openstack.Attachment{{ID: "att-6", ServerID: "server-deleted"}}},
+		// Attached with the connector attach mode, to no Nova instance
+		{ID: "vol-bare-metal", Status: openstack.VolumeInUseStatus, Metadata: owned, Attachments: []openstack.Attachment{{ID: "att-7"}}},
+		{ID: "vol-detaching", Status: openstack.VolumeDetachingStatus, Metadata: owned},
+	}
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("ListVolumes").Return(vols, nil)
+	osmock.On("InstanceExists", "server-deleted").Return(false, nil).Once()
+	osmock.On("InstanceExists", "server-alive").Return(true, nil)
+	osmock.On("InstanceExists", "server-unknown").Return(false, errors.New("gateway timeout"))
+	osmock.On("ForceDetachVolume", "vol-ghost", "att-1").Return(nil)
+	osmock.On("ForceDetachVolume", "vol-ghost-2", "att-2").Return(nil)
+
+	s := newStaleAttachments(fakeCluster)
+	assert.NoError(t, s.reconcile(osmock))
+	osmock.AssertNumberOfCalls(t, "ForceDetachVolume", 2)
+	osmock.AssertNotCalled(t, "InstanceExists", "")
+	// Only reset when still detaching on the next pass
+	osmock.AssertNotCalled(t, "ResetVolumeStatus", "vol-detaching", openstack.VolumeAvailableStatus)
+
+	osmock = new(openstack.OpenStackMock)
+	osmock.On("ListVolumes").Return(vols[len(vols)-1:], nil)
+	osmock.On("ResetVolumeStatus", "vol-detaching", openstack.VolumeAvailableStatus).Return(nil)
+	assert.NoError(t, s.reconcile(osmock))
+	osmock.AssertExpectations(t)
+}
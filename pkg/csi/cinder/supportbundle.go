@@ -0,0 +1,186 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/cloud-provider-openstack/pkg/util/supportbundle"
+)
+
+// operationHistoryLength is the number of finished CSI calls kept for the
+// support bundle.
+const operationHistoryLength = 200
+
+// operationHistory records the CSI calls served by the driver.
+var operationHistory = supportbundle.NewHistory(operationHistoryLength)
+
+// supportBundleFeatures are the driver settings reported in the support bundle.
+type supportBundleFeatures struct {
+	Version                  string        `json:"version"`
+	RunMode                  string        `json:"runMode"`
+	Cluster                  string        `json:"cluster"`
+	Cloud                    string        `json:"cloud,omitempty"`
+	Region                   string        `json:"region,omitempty"`
+	Project                  string        `json:"project,omitempty"`
+	Zone                     string        `json:"zone,omitempty"`
+	TopologyKeys             []string      `json:"topologyKeys"`
+	AdoptUntaggedVolumes     bool          `json:"adoptUntaggedVolumes"`
+	StrictIdempotency        bool          `json:"strictIdempotency"`
+	EncryptionBoundary       bool          `json:"encryptionBoundary"`
+	ValidateParameters       bool          `json:"validateParameters"`
+	SnapshotBackups          bool          `json:"snapshotBackups"`
+	CreatingDeadline         time.Duration `json:"creatingDeadline,omitempty"`
+	MetricsVolumeTypes       []string      `json:"metricsVolumeTypes,omitempty"`
+	MetadataHints            []string      `json:"metadataHints,omitempty"`
+	GrowOnStage              bool          `json:"growOnStage"`
+	GrowOnStageThreshold     int64         `json:"growOnStageThreshold,omitempty"`
+	MaxConcurrentOperations  int           `json:"maxConcurrentOperations,omitempty"`
+	NamespaceQuota           string        `json:"namespaceQuota,omitempty"`
+	KubeletRegistration      string        `json:"kubeletRegistration,omitempty"`
+	KubeletRegistered        bool          `json:"kubeletRegistered,omitempty"`
+	PlacementWebhookURL      string        `json:"placementWebhookURL,omitempty"`
+	PlacementWebhookTimeout  time.Duration `json:"placementWebhookTimeout,omitempty"`
+	PlacementWebhookFailOpen bool          `json:"placementWebhookFailOpen,omitempty"`
+}
+
+// supportBundleCapabilities are the CSI capabilities enabled in the driver.
+type supportBundleCapabilities struct {
+	Controller  []string `json:"controller"`
+	Node        []string `json:"node"`
+	AccessModes []string `json:"accessModes"`
+}
+
+// SetSupportBundle serves the support bundle on address, including the
+// lines kept in logs. An empty address disables it.
+func (d *CinderDriver) SetSupportBundle(address string, logs *supportbundle.LineBuffer) {
+	d.supportBundleAddress = address
+	d.supportBundleLogs = logs
+}
+
+// SetDebugTLS serves the support bundle and the other debug endpoints with
+// mutual TLS, which allows serving them on other than a loopback address.
+func (d *CinderDriver) SetDebugTLS(certFile, keyFile, clientCAFile string) error {
+	tlsConfig, err := supportbundle.MutualTLSConfig(certFile, keyFile, clientCAFile)
+	if err != nil {
+		return err
+	}
+	d.debugTLS = tlsConfig
+	return nil
+}
+
+// serveDebug serves the support bundle, and the operation queue when
+// operations are limited.
+func (d *CinderDriver) serveDebug() error {
+	mux := http.NewServeMux()
+	mux.Handle(supportbundle.Path, supportbundle.Handler(nil, d.collectSupportBundle))
+	if controllerQueue != nil {
+		mux.Handle(operationsPath, controllerQueue)
+		mux.HandleFunc(operationsCancelPath, controllerQueue.serveCancel)
+	}
+	return supportbundle.ServeDebug(d.supportBundleAddress, mux, d.debugTLS)
+}
+
+// collectSupportBundle adds the driver state to the bundle.
+func (d *CinderDriver) collectSupportBundle(b *supportbundle.Bundle) {
+	// The raw file as well as the parsed settings, both go through the
+	// redactor. The parsed secrets are registered so they are also masked
+	// wherever else they appear, e.g. in logs.
+	if data, err := ioutil.ReadFile(d.cloudconfig); err != nil {
+		b.Add("config/cloud.conf", []byte(fmt.Sprintf("failed to read %s: %v\n", d.cloudconfig, err)))
+	} else {
+		b.Add("config/cloud.conf", data)
+	}
+	if cfg, _, err := openstack.GetConfigFromFile(d.cloudconfig); err == nil {
+		b.AddSecret(cfg.Global.Password)
+		b.AddSecret(cfg.Global.ApplicationCredentialSecret)
+		b.AddJSON("config/effective.json", cfg)
+	}
+	b.AddSecret(os.Getenv("OS_PASSWORD"))
+	b.AddSecret(os.Getenv("OS_TOKEN"))
+	b.AddSecret(os.Getenv("OS_APPLICATION_CREDENTIAL_SECRET"))
+
+	features := supportBundleFeatures{
+		Version:              d.version,
+		RunMode:              d.runMode,
+		Cluster:              d.cluster,
+		Cloud:                d.cloud.AuthURLHost,
+		Region:               d.cloud.Region,
+		Project:              d.cloud.Project,
+		Zone:                 d.zone,
+		AdoptUntaggedVolumes: d.adoptUntagged,
+		StrictIdempotency:    d.strictIdempotency,
+		EncryptionBoundary:   d.encryption != nil,
+		ValidateParameters:   d.cloudParameters != nil,
+		SnapshotBackups:      d.snapshotBackups,
+		CreatingDeadline:     d.creatingDeadline,
+		MetricsVolumeTypes:   sortedKeys(d.metricsVolumeTypes),
+		MetadataHints:        sortedKeys(d.metadataHints),
+		GrowOnStage:          d.growOnStage,
+		GrowOnStageThreshold: d.growOnStageThreshold,
+	}
+	features.TopologyKeys = []string{d.topology.key}
+	if d.topology.dualReporting() {
+		features.TopologyKeys = append(features.TopologyKeys, d.topology.legacy)
+	}
+	if controllerQueue != nil {
+		features.MaxConcurrentOperations = cap(controllerQueue.slots)
+	}
+	if d.quota != nil {
+		features.NamespaceQuota = d.quota.configMapNamespace + "/" + d.quota.configMapName
+	}
+	if d.registration != nil {
+		features.KubeletRegistration = d.registration.socketPath
+		features.KubeletRegistered, _ = d.registration.status()
+	}
+	if d.placement != nil {
+		features.PlacementWebhookURL = d.placement.url
+		features.PlacementWebhookTimeout = d.placement.timeout
+		features.PlacementWebhookFailOpen = d.placement.failOpen
+	}
+	b.AddJSON("features.json", features)
+
+	var caps supportBundleCapabilities
+	for _, c := range d.cscap {
+		caps.Controller = append(caps.Controller, c.GetRpc().GetType().String())
+	}
+	for _, c := range d.nscap {
+		caps.Node = append(caps.Node, c.GetRpc().GetType().String())
+	}
+	for _, m := range d.vcap {
+		caps.AccessModes = append(caps.AccessModes, m.GetMode().String())
+	}
+	b.AddJSON("capabilities.json", caps)
+
+	b.AddJSON("operations.json", operationHistory.Operations())
+	b.AddJSON("inflight.json", operationHistory.InFlight())
+	if controllerQueue != nil {
+		b.AddJSON("queue.json", controllerQueue.list())
+	}
+	if d.quota != nil {
+		b.AddJSON("quota.json", d.quota.snapshot())
+	}
+
+	if d.supportBundleLogs != nil {
+		b.AddLines("logs.txt", d.supportBundleLogs.Lines())
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// DefaultTopologyKey is the topology key the availability zone is reported
+// with unless changed with SetTopologyKeys.
+const DefaultTopologyKey = topologyKey
+
+// topologyKeys are the topology keys the availability zone is reported with.
+// While migrating to a new key, nodes report the legacy key as well until
+// legacyUntil, so that the PersistentVolumes pinned to it stay schedulable.
+type topologyKeys struct {
+	key string
+	// legacy is empty when there is no migration in progress
+	legacy string
+	// legacyUntil is zero when the legacy key is reported until it is unset
+	legacyUntil time.Time
+	now         func() time.Time
+}
+
+func newTopologyKeys() topologyKeys {
+	return topologyKeys{key: topologyKey, now: time.Now}
+}
+
+// SetTopologyKeys changes the topology key to key. Nodes report legacy as
+// well until the until time, or for as long as it is set when until is zero.
+func (d *CinderDriver) SetTopologyKeys(key, legacy string, until time.Time) error {
+	if key == "" {
+		return fmt.Errorf("the topology key cannot be empty")
+	}
+	if legacy == key {
+		return fmt.Errorf("the legacy topology key %q must differ from the topology key", legacy)
+	}
+	d.topology.key = key
+	d.topology.legacy = legacy
+	d.topology.legacyUntil = until
+	if legacy != "" {
+		if until.IsZero() {
+			klog.Infof("Reporting the topology keys %s and %s", key, legacy)
+		} else {
+			klog.Infof("Reporting the topology keys %s and %s until %s", key, legacy, until.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// dualReporting returns whether nodes report the legacy key as well.
+func (k topologyKeys) dualReporting() bool {
+	return k.legacy != "" && (k.legacyUntil.IsZero() || k.now().Before(k.legacyUntil))
+}
+
+// nodeSegments returns the topology segments of a node in zone.
+func (k topologyKeys) nodeSegments(zone string) map[string]string {
+	segments := map[string]string{k.key: zone}
+	if k.dualReporting() {
+		segments[k.legacy] = zone
+	}
+	return segments
+}
+
+// zone returns the zone of topology segments, with the legacy key when the
+// segments come from nodes not reporting the new key yet.
+func (k topologyKeys) zone(segments map[string]string) (string, bool) {
+	if zone, exists := segments[k.key]; exists {
+		return zone, true
+	}
+	if k.legacy == "" {
+		return "", false
+	}
+	zone, exists := segments[k.legacy]
+	return zone, exists
+}
+
+// PersistentVolumesWithTopologyKey returns the names of the PersistentVolumes
+// of the driver whose node affinity uses key. Once there is none left for a
+// legacy key, nodes do not need to report it anymore.
+func PersistentVolumesWithTopologyKey(client kubernetes.Interface, key string) ([]string, error) {
+	pvs, err := client.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PersistentVolumes: %v", err)
+	}
+
+	var names []string
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != driverName {
+			continue
+		}
+		if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+			continue
+		}
+	terms:
+		for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+			for _, expr := range term.MatchExpressions {
+				if expr.Key == key {
+					names = append(names, pv.Name)
+					break terms
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
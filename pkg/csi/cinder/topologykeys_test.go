@@ -0,0 +1,86 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const fakeNewTopologyKey = "topology.cinder.csi.openstack.org/az"
+
+func TestTopologyKeys(t *testing.T) {
+	d := NewDriver(fakeNodeID, fakeEndpoint, fakeCluster, fakeConfig)
+	assert.Error(t, d.SetTopologyKeys("", topologyKey, time.Time{}))
+	assert.Error(t, d.SetTopologyKeys(topologyKey, topologyKey, time.Time{}))
+
+	now := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, d.SetTopologyKeys(fakeNewTopologyKey, topologyKey, now.Add(time.Hour)))
+	d.topology.now = func() time.Time { return now }
+
+	// Nodes report both keys until the deadline
+	assert.Equal(t, map[string]string{fakeNewTopologyKey: "nova", topologyKey: "nova"}, d.topology.nodeSegments("nova"))
+	now = now.Add(2 * time.Hour)
+	assert.Equal(t, map[string]string{fakeNewTopologyKey: "nova"}, d.topology.nodeSegments("nova"))
+
+	// Requirements from nodes reporting either key are honored, the new one
+	// first
+	requirement := &csi.TopologyRequirement{
+		Preferred: []*csi.Topology{{Segments: map[string]string{topologyKey: "az1"}}},
+	}
+	assert.Equal(t, "az1", getAZFromTopology(requirement, d.topology))
+	requirement.Preferred[0].Segments[fakeNewTopologyKey] = "az2"
+	assert.Equal(t, "az2", getAZFromTopology(requirement, d.topology))
+
+	// Without a migration in progress only the topology key is used
+	assert.NoError(t, d.SetTopologyKeys(fakeNewTopologyKey, "", time.Time{}))
+	_, exists := d.topology.zone(map[string]string{topologyKey: "az1"})
+	assert.False(t, exists)
+}
+
+func TestPersistentVolumesWithTopologyKey(t *testing.T) {
+	pv := func(name, driver, key string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{Driver: driver}},
+				NodeAffinity: &v1.VolumeNodeAffinity{Required: &v1.NodeSelector{
+					NodeSelectorTerms: []v1.NodeSelectorTerm{{
+						MatchExpressions: []v1.NodeSelectorRequirement{{Key: key, Operator: v1.NodeSelectorOpIn, Values: []string{"nova"}}},
+					}},
+				}},
+			},
+		}
+	}
+	client := fake.NewSimpleClientset(
+		pv("pv-b", driverName, topologyKey),
+		pv("pv-a", driverName, topologyKey),
+		pv("pv-new", driverName, fakeNewTopologyKey),
+		pv("pv-other", "other.csi.example.com", topologyKey),
+		&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-no-affinity"}},
+	)
+
+	names, err := PersistentVolumesWithTopologyKey(client, topologyKey)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pv-a", "pv-b"}, names)
+}
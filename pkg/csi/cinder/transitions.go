@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog"
+)
+
+// settleBackoff is how long ControllerPublishVolume and
+// ControllerUnpublishVolume wait for a volume to leave a transitional status,
+// about a minute in total. Replaced in the tests.
+var settleBackoff = wait.Backoff{Duration: time.Second, Factor: 1.5, Steps: 9}
+
+// transitionalStatuses are the statuses Cinder moves a volume out of by
+// itself, and in which it refuses to attach or detach it.
+var transitionalStatuses = map[string]bool{
+	openstack.VolumeCreatingStatus:  true,
+	openstack.VolumeAttachingStatus: true,
+	openstack.VolumeDetachingStatus: true,
+	openstack.VolumeExtendingStatus: true,
+	"reserved":                      true,
+	"downloading":                   true,
+	"backing-up":                    true,
+	"restoring-backup":              true,
+}
+
+// waitVolumeSettled waits with backoff for a volume to leave the
+// transitional statuses, e.g. for the detachment from the previous node of a
+// volume moving to another one. Transient failures of the cloud are retried
+// on the way. Once the backoff is exhausted the error is Unavailable, for the
+// caller to retry the request. The volume settling as available is recorded
+// with available.
+func waitVolumeSettled(ctx context.Context, cloud openstack.IOpenStack, volumeID string, backoff wait.Backoff, available *statusWait) (openstack.Volume, error) {
+	var vol openstack.Volume
+	var lastErr error
+	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		var err error
+		vol, err = cloud.GetVolume(volumeID)
+		if err != nil {
+			if openstack.IsTransient(err) {
+				klog.V(4).Infof("Failed to get volume %s, retrying: %v", volumeID, err)
+				lastErr = err
+				return false, nil
+			}
+			return false, err
+		}
+		lastErr = nil
+		if transitionalStatuses[vol.Status] {
+			klog.V(4).Infof("Volume %s is %s, waiting", volumeID, vol.Status)
+			return false, nil
+		}
+		return true, nil
+	})
+
+	switch {
+	case err == nil:
+		if vol.Status == openstack.VolumeAvailableStatus {
+			available.reached()
+		}
+		return vol, nil
+	case err == wait.ErrWaitTimeout && lastErr != nil:
+		return vol, status.Errorf(codes.Unavailable, "failed to get volume %s: %v", volumeID, lastErr)
+	case err == wait.ErrWaitTimeout:
+		return vol, status.Errorf(codes.Unavailable, "volume %s is still %s", volumeID, vol.Status)
+	case err == context.Canceled || err == context.DeadlineExceeded:
+		return vol, status.Errorf(codes.Unavailable, "gave up waiting for volume %s: %v", volumeID, err)
+	case cpoerrors.IsNotFound(err):
+		return vol, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
+	}
+	return vol, status.Errorf(codes.Internal, "failed to get volume %s: %v", volumeID, err)
+}
+
+// cloudError returns err of a failed call to the cloud as Unavailable when it
+// is transient, for the sidecar to retry it, and as is otherwise.
+func cloudError(err error) error {
+	if openstack.IsTransient(err) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return err
+}
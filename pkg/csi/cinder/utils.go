@@ -3,11 +3,11 @@ package cinder
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
-	"k8s.io/klog"
 )
 
 func NewControllerServiceCapability(cap csi.ControllerServiceCapability_RPC_Type) *csi.ControllerServiceCapability {
@@ -36,7 +36,10 @@ func NewVolumeCapabilityAccessMode(mode csi.VolumeCapability_AccessMode_Mode) *c
 
 func NewControllerServer(d *CinderDriver) *controllerServer {
 	return &controllerServer{
-		Driver: d,
+		Driver:        d,
+		volumeLocks:   newVolumeLocks(),
+		createLocks:   newVolumeLocks(),
+		volumeRegions: newVolumeRegions(),
 	}
 }
 
@@ -49,6 +52,7 @@ func NewIdentityServer(d *CinderDriver) *identityServer {
 func NewNodeServer(d *CinderDriver) *nodeServer {
 	return &nodeServer{
 		Driver: d,
+		ops:    newNodeOperations(),
 	}
 }
 
@@ -69,14 +73,42 @@ func ParseEndpoint(ep string) (string, string, error) {
 	return "", "", fmt.Errorf("Invalid endpoint: %v", ep)
 }
 
+// requestTarget returns the volume or snapshot a CSI request is about.
+func requestTarget(req interface{}) string {
+	switch r := req.(type) {
+	case interface{ GetVolumeId() string }:
+		return r.GetVolumeId()
+	case interface{ GetSnapshotId() string }:
+		return r.GetSnapshotId()
+	case interface{ GetName() string }:
+		return r.GetName()
+	}
+	return ""
+}
+
 func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	klog.V(3).Infof("GRPC call: %s", info.FullMethod)
-	klog.V(5).Infof("GRPC request: %+v", req)
+	log := newRPCLogger(ctx, info.FullMethod, req)
+	ctx = withRPCLogger(ctx, log)
+	log.V(3).Infof("GRPC call: %s", info.FullMethod)
+	log.V(5).Infof("GRPC request: %+v", req)
+	start := time.Now()
+	target := requestTarget(req)
+	end := operationHistory.Begin(info.FullMethod, target)
+	release, err := queueOperation(ctx, info.FullMethod, target)
+	if err != nil {
+		end(err)
+		observeRPC(info.FullMethod, start, err)
+		log.Errorf("GRPC error: %v", err)
+		return nil, err
+	}
 	resp, err := handler(ctx, req)
+	release()
+	end(err)
+	observeRPC(info.FullMethod, start, err)
 	if err != nil {
-		klog.Errorf("GRPC error: %v", err)
+		log.Errorf("GRPC error: %v", err)
 	} else {
-		klog.V(5).Infof("GRPC response: %+v", resp)
+		log.V(5).Infof("GRPC response: %+v", resp)
 	}
 	return resp, err
 }
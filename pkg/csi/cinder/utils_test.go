@@ -19,7 +19,13 @@ package cinder
 import (
 	"testing"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestParseEndpoint(t *testing.T) {
@@ -74,3 +80,25 @@ func TestParseEndpoint(t *testing.T) {
 	_, _, err = ParseEndpoint("")
 	assert.NotNil(t, err)
 }
+
+// Every CSI call is timed, and counted by status code when it fails.
+func TestLogGRPCMetrics(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeStageVolume"}
+	req := &csi.NodeStageVolumeRequest{VolumeId: fakeVolID}
+
+	duration := rpcDuration.WithLabelValues("NodeStageVolume").(prometheus.Metric)
+	notFound := rpcErrors.WithLabelValues("NodeStageVolume", codes.NotFound.String())
+	durationBefore, notFoundBefore := metricValue(t, duration), metricValue(t, notFound)
+
+	_, err := logGRPC(fakeCtx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &csi.NodeStageVolumeResponse{}, nil
+	})
+	assert.NoError(t, err)
+	_, err = logGRPC(fakeCtx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "volume not found")
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	assert.Equal(t, durationBefore+2, metricValue(t, duration))
+	assert.Equal(t, notFoundBefore+1, metricValue(t, notFound))
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// volumeLocks allows one controller operation at a time on a volume. Cinder
+// rejects most changes to a volume while another one is in progress, e.g.
+// deleting a volume while a snapshot of it is being created, and the
+// sidecars issuing them would otherwise keep failing each other.
+type volumeLocks struct {
+	mu sync.Mutex
+	// operations are the operations in progress, by volume ID
+	operations map[string]string
+}
+
+func newVolumeLocks() *volumeLocks {
+	return &volumeLocks{operations: map[string]string{}}
+}
+
+// acquire locks a volume for an operation. When another operation holds the
+// lock, it returns an Aborted error so that the caller retries later.
+func (l *volumeLocks) acquire(volumeID, operation string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if current, ok := l.operations[volumeID]; ok {
+		return status.Errorf(codes.Aborted, "%s is in progress on volume %s, retry %s later", current, volumeID, operation)
+	}
+	l.operations[volumeID] = operation
+	return nil
+}
+
+// release unlocks a volume locked by acquire.
+func (l *volumeLocks) release(volumeID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.operations, volumeID)
+}
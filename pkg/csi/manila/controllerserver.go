@@ -0,0 +1,438 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	manilashare "k8s.io/cloud-provider-openstack/pkg/share/manila"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/cloud-provider-openstack/pkg/volume/util"
+	"k8s.io/klog"
+)
+
+const (
+	protocolNFS    = "NFS"
+	protocolCephFS = "CEPHFS"
+
+	// The keys of the volume context, from the StorageClass parameters and
+	// the share CreateVolume made
+	shareProtocolKey  = "shareProtocol"
+	exportLocationKey = "exportLocation"
+	nfsShareClientKey = "nfsShareClient"
+
+	// accessRuleIDKey is the key of the publish context holding the access
+	// rule ControllerPublishVolume granted
+	accessRuleIDKey = "accessRuleID"
+
+	defaultShareType      = "default"
+	defaultNFSShareClient = "0.0.0.0"
+
+	shareAvailableStatus = "available"
+	shareErrorStatus     = "error"
+	accessActiveState    = "active"
+	accessErrorState     = "error"
+)
+
+var (
+	// statusPollInterval is how often the status of shares and access
+	// rules is checked while waiting for them
+	statusPollInterval = 2 * time.Second
+	// statusTimeout is how long a request waits for a share or access rule,
+	// the request is retried by the sidecars past it
+	statusTimeout = 2 * time.Minute
+)
+
+type controllerServer struct {
+	Driver *ManilaDriver
+}
+
+func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	name := req.GetName()
+	if len(name) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolume Name must be provided")
+	}
+	if err := validateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return nil, err
+	}
+
+	// Share Size - Default is 1 GiB
+	sizeBytes := int64(1 * 1024 * 1024 * 1024)
+	if req.GetCapacityRange() != nil {
+		sizeBytes = int64(req.GetCapacityRange().GetRequiredBytes())
+	}
+	sizeGB := int(util.RoundUpSize(sizeBytes, 1024*1024*1024))
+
+	params := req.GetParameters()
+	protocol, err := shareProtocol(params["protocol"])
+	if err != nil {
+		return nil, err
+	}
+	shareType := params["type"]
+	if shareType == "" {
+		shareType = defaultShareType
+	}
+
+	client, err := cs.Driver.newClient(req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "failed to authenticate to Manila: %v", err)
+	}
+
+	share, err := client.GetShareByName(name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to query for existing share %s: %v", name, err)
+	}
+	if share != nil {
+		if share.Size != sizeGB || !strings.EqualFold(share.ShareProto, protocol) {
+			return nil, status.Errorf(codes.AlreadyExists, "share %s already exists with size %d GiB and protocol %s", name, share.Size, share.ShareProto)
+		}
+		klog.V(4).Infof("Share %s already exists as %s", name, share.ID)
+	} else {
+		share, err = client.CreateShare(shares.CreateOpts{
+			Name:             name,
+			Size:             sizeGB,
+			ShareProto:       protocol,
+			ShareType:        shareType,
+			ShareNetworkID:   params["shareNetworkID"],
+			AvailabilityZone: params["availability"],
+			Description:      "Created by Manila CSI driver",
+		})
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to create share %s: %v", name, err)
+		}
+		klog.V(4).Infof("Share %s created as %s", name, share.ID)
+	}
+
+	share, err = waitForShare(client, share.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	locs, err := client.GetExportLocations(share.ID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get the export locations of share %s: %v", share.ID, err)
+	}
+	loc, err := manilashare.ChooseExportLocation(locs)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "share %s: %v", share.ID, err)
+	}
+
+	volCtx := map[string]string{
+		shareProtocolKey:  protocol,
+		exportLocationKey: loc.Path,
+	}
+	if protocol == protocolNFS {
+		volCtx[nfsShareClientKey] = params["nfsShareClient"]
+		if volCtx[nfsShareClientKey] == "" {
+			volCtx[nfsShareClientKey] = defaultNFSShareClient
+		}
+	}
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      share.ID,
+			CapacityBytes: int64(share.Size) * 1024 * 1024 * 1024,
+			VolumeContext: volCtx,
+		},
+	}, nil
+}
+
+func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	shareID := req.GetVolumeId()
+	if len(shareID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "DeleteVolume Volume ID must be provided")
+	}
+
+	client, err := cs.Driver.newClient(req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "failed to authenticate to Manila: %v", err)
+	}
+
+	// The access rules of the share are deleted along with it
+	if err := client.DeleteShare(shareID); err != nil {
+		if cpoerrors.IsNotFound(err) {
+			klog.V(3).Infof("Share %s is already deleted", shareID)
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+		return nil, status.Errorf(codes.Internal, "failed to delete share %s: %v", shareID, err)
+	}
+
+	klog.V(4).Infof("Share %s deleted", shareID)
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// ControllerPublishVolume grants the access rule the nodes mount the share
+// with. The rules are of the share rather than of the nodes: every node
+// mounts with the same one, which lives as long as the share.
+func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+	shareID := req.GetVolumeId()
+	if len(shareID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume Volume ID must be provided")
+	}
+	if len(req.GetNodeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume Node ID must be provided")
+	}
+	if err := validateVolumeCapabilities([]*csi.VolumeCapability{req.GetVolumeCapability()}); err != nil {
+		return nil, err
+	}
+
+	volCtx := req.GetVolumeContext()
+	protocol, err := shareProtocol(volCtx[shareProtocolKey])
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := cs.Driver.newClient(req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "failed to authenticate to Manila: %v", err)
+	}
+
+	share, err := client.GetShareByID(shareID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "ControllerPublishVolume share %s not found", shareID)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get share %s: %v", shareID, err)
+	}
+
+	opts := shares.GrantAccessOpts{AccessLevel: "rw"}
+	switch protocol {
+	case protocolNFS:
+		opts.AccessType = "ip"
+		opts.AccessTo = volCtx[nfsShareClientKey]
+		if opts.AccessTo == "" {
+			opts.AccessTo = defaultNFSShareClient
+		}
+	case protocolCephFS:
+		opts.AccessType = "cephx"
+		opts.AccessTo = share.Name
+	}
+
+	rule, err := grantAccess(client, shareID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csi.ControllerPublishVolumeResponse{
+		PublishContext: map[string]string{accessRuleIDKey: rule.ID},
+	}, nil
+}
+
+// ControllerUnpublishVolume does nothing: the other nodes may still mount
+// the share with its access rule.
+func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerUnpublishVolume Volume ID must be provided")
+	}
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
+
+func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	shareID := req.GetVolumeId()
+	if len(shareID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ValidateVolumeCapabilities Volume ID must be provided")
+	}
+	volCaps := req.GetVolumeCapabilities()
+	if len(volCaps) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ValidateVolumeCapabilities Volume Capabilities must be provided")
+	}
+
+	client, err := cs.Driver.newClient(req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "failed to authenticate to Manila: %v", err)
+	}
+	if _, err := client.GetShareByID(shareID); err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "ValidateVolumeCapabilities share %s not found", shareID)
+		}
+		return nil, status.Errorf(codes.Internal, "ValidateVolumeCapabilities failed to get share %s: %v", shareID, err)
+	}
+
+	if msg := unsupportedCapabilities(volCaps); msg != "" {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: msg}, nil
+	}
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: volCaps,
+			Parameters:         req.GetParameters(),
+		},
+	}, nil
+}
+
+func (cs *controllerServer) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (cs *controllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (cs *controllerServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	klog.V(5).Infof("Using default ControllerGetCapabilities")
+
+	return &csi.ControllerGetCapabilitiesResponse{
+		Capabilities: cs.Driver.cscap,
+	}, nil
+}
+
+func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (cs *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+// shareProtocol returns the Manila share protocol of the protocol parameter,
+// NFS by default.
+func shareProtocol(param string) (string, error) {
+	switch p := strings.ToUpper(param); p {
+	case "", protocolNFS:
+		return protocolNFS, nil
+	case protocolCephFS:
+		return protocolCephFS, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "unsupported share protocol %q, must be %s or %s", param, protocolNFS, protocolCephFS)
+	}
+}
+
+// validateVolumeCapabilities fails for block volumes, shares are mounted
+// filesystems.
+func validateVolumeCapabilities(volCaps []*csi.VolumeCapability) error {
+	if len(volCaps) == 0 || volCaps[0] == nil {
+		return status.Error(codes.InvalidArgument, "Volume Capabilities must be provided")
+	}
+	if msg := unsupportedCapabilities(volCaps); msg != "" {
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return nil
+}
+
+// unsupportedCapabilities returns why volCaps are not supported, "" when
+// they are.
+func unsupportedCapabilities(volCaps []*csi.VolumeCapability) string {
+	for _, volCap := range volCaps {
+		if volCap.GetBlock() != nil {
+			return "block access type is not supported by shares"
+		}
+	}
+	return ""
+}
+
+// waitForShare waits for the share shareID to be available. A share in error
+// is deleted so that CreateVolume creates it again when retried.
+func waitForShare(client manilaClient, shareID string) (*shares.Share, error) {
+	var share *shares.Share
+	err := wait.PollImmediate(statusPollInterval, statusTimeout, func() (bool, error) {
+		var err error
+		share, err = client.GetShareByID(shareID)
+		if err != nil {
+			return false, err
+		}
+		return share.Status == shareAvailableStatus || share.Status == shareErrorStatus, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil, status.Errorf(codes.DeadlineExceeded, "share %s is not available yet", shareID)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get share %s: %v", shareID, err)
+	}
+
+	if share.Status == shareErrorStatus {
+		if err := client.DeleteShare(shareID); err != nil {
+			klog.Warningf("Failed to delete share %s in error: %v", shareID, err)
+		}
+		return nil, status.Errorf(codes.Internal, "share %s failed to be created", shareID)
+	}
+	return share, nil
+}
+
+// grantAccess returns the access rule of the share shareID matching opts,
+// once active, and grants it first if there is none. The access keys of
+// cephx rules are generated by the backend after the rule is granted.
+func grantAccess(client manilaClient, shareID string, opts shares.GrantAccessOpts) (*shares.AccessRight, error) {
+	rules, err := client.ListAccessRights(shareID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list the access rules of share %s: %v", shareID, err)
+	}
+
+	var ruleID string
+	for _, r := range rules {
+		if r.AccessType == opts.AccessType && r.AccessTo == opts.AccessTo && r.AccessLevel == opts.AccessLevel && r.State != accessErrorState {
+			ruleID = r.ID
+			break
+		}
+	}
+	if ruleID == "" {
+		rule, err := client.GrantAccess(shareID, opts)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to grant %s access to %s on share %s: %v", opts.AccessType, opts.AccessTo, shareID, err)
+		}
+		klog.V(4).Infof("Granted %s access to %s on share %s", opts.AccessType, opts.AccessTo, shareID)
+		ruleID = rule.ID
+	}
+
+	var rule *shares.AccessRight
+	err = wait.PollImmediate(statusPollInterval, statusTimeout, func() (bool, error) {
+		rule, err = findAccessRule(client, shareID, ruleID)
+		if err != nil {
+			return false, err
+		}
+		ready := rule.State == accessActiveState && (rule.AccessType != "cephx" || rule.AccessKey != "")
+		return ready || rule.State == accessErrorState, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil, status.Errorf(codes.DeadlineExceeded, "access rule %s of share %s is not active yet", ruleID, shareID)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get access rule %s of share %s: %v", ruleID, shareID, err)
+	}
+	if rule.State == accessErrorState {
+		return nil, status.Errorf(codes.Internal, "access rule %s of share %s failed to be applied", ruleID, shareID)
+	}
+	return rule, nil
+}
+
+// findAccessRule returns the access rule ruleID of the share shareID.
+func findAccessRule(client manilaClient, shareID, ruleID string) (*shares.AccessRight, error) {
+	rules, err := client.ListAccessRights(shareID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		if rules[i].ID == ruleID {
+			return &rules[i], nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "access rule %s of share %s not found", ruleID, shareID)
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+const (
+	fakeNodeID   = "CSINodeID"
+	fakeEndpoint = "tcp://127.0.0.1:10000"
+	fakeShare    = "pvc-1"
+	fakeKey      = "AQBfakekey=="
+)
+
+// fakeManila is a Manila whose shares are available and access rules active
+// as soon as they are created.
+type fakeManila struct {
+	shares  map[string]*shares.Share
+	rules   map[string][]shares.AccessRight
+	locs    []shares.ExportLocation
+	granted int
+	// secrets are those of the last request
+	secrets map[string]string
+}
+
+func newFakeManila() *fakeManila {
+	return &fakeManila{
+		shares: map[string]*shares.Share{},
+		rules:  map[string][]shares.AccessRight{},
+		locs: []shares.ExportLocation{
+			{Path: "10.0.0.1:/admin", IsAdminOnly: true},
+			{Path: "10.0.0.2:6789,10.0.0.3:6789:/volumes/_nogroup/1", Preferred: true},
+		},
+	}
+}
+
+func (f *fakeManila) newDriver() *ManilaDriver {
+	d := NewDriver(fakeNodeID, fakeEndpoint)
+	d.newClient = func(secrets map[string]string) (manilaClient, error) {
+		f.secrets = secrets
+		return f, nil
+	}
+	d.mounter = &mount.FakeMounter{}
+	return d
+}
+
+func (f *fakeManila) GetShareByName(name string) (*shares.Share, error) {
+	for _, s := range f.shares {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeManila) GetShareByID(shareID string) (*shares.Share, error) {
+	s, ok := f.shares[shareID]
+	if !ok {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	return s, nil
+}
+
+func (f *fakeManila) CreateShare(opts shares.CreateOpts) (*shares.Share, error) {
+	s := &shares.Share{
+		ID:               fmt.Sprintf("share-%d", len(f.shares)+1),
+		Name:             opts.Name,
+		Size:             opts.Size,
+		ShareProto:       opts.ShareProto,
+		ShareType:        opts.ShareType,
+		ShareNetworkID:   opts.ShareNetworkID,
+		AvailabilityZone: opts.AvailabilityZone,
+		Status:           shareAvailableStatus,
+	}
+	f.shares[s.ID] = s
+	return s, nil
+}
+
+func (f *fakeManila) DeleteShare(shareID string) error {
+	if _, ok := f.shares[shareID]; !ok {
+		return gophercloud.ErrDefault404{}
+	}
+	delete(f.shares, shareID)
+	delete(f.rules, shareID)
+	return nil
+}
+
+func (f *fakeManila) GetExportLocations(shareID string) ([]shares.ExportLocation, error) {
+	return f.locs, nil
+}
+
+func (f *fakeManila) ListAccessRights(shareID string) ([]shares.AccessRight, error) {
+	return f.rules[shareID], nil
+}
+
+func (f *fakeManila) GrantAccess(shareID string, opts shares.GrantAccessOpts) (*shares.AccessRight, error) {
+	f.granted++
+	r := shares.AccessRight{
+		ID:          fmt.Sprintf("rule-%d", f.granted),
+		ShareID:     shareID,
+		AccessType:  opts.AccessType,
+		AccessTo:    opts.AccessTo,
+		AccessLevel: opts.AccessLevel,
+		State:       accessActiveState,
+	}
+	if opts.AccessType == "cephx" {
+		r.AccessKey = fakeKey
+	}
+	f.rules[shareID] = append(f.rules[shareID], r)
+	return &r, nil
+}
+
+func mountCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+}
+
+func TestCreateVolume(t *testing.T) {
+	f := newFakeManila()
+	cs := NewControllerServer(f.newDriver())
+	secrets := map[string]string{"os-authURL": "https://keystone.example.com/v3"}
+
+	req := &csi.CreateVolumeRequest{
+		Name:               fakeShare,
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1536 * 1024 * 1024},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters:         map[string]string{"protocol": "cephfs", "shareNetworkID": "net-1"},
+		Secrets:            secrets,
+	}
+	resp, err := cs.CreateVolume(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, secrets, f.secrets)
+	assert.Equal(t, int64(2*1024*1024*1024), resp.GetVolume().GetCapacityBytes())
+	assert.Equal(t, map[string]string{
+		shareProtocolKey:  protocolCephFS,
+		exportLocationKey: "10.0.0.2:6789,10.0.0.3:6789:/volumes/_nogroup/1",
+	}, resp.GetVolume().GetVolumeContext())
+	if assert.Contains(t, f.shares, resp.GetVolume().GetVolumeId()) {
+		share := f.shares[resp.GetVolume().GetVolumeId()]
+		assert.Equal(t, defaultShareType, share.ShareType)
+		assert.Equal(t, "net-1", share.ShareNetworkID)
+	}
+
+	// Idempotent
+	again, err := cs.CreateVolume(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, resp.GetVolume().GetVolumeId(), again.GetVolume().GetVolumeId())
+	assert.Len(t, f.shares, 1)
+
+	// Not with another size
+	req.CapacityRange.RequiredBytes = 10 * 1024 * 1024 * 1024
+	_, err = cs.CreateVolume(context.Background(), req)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+func TestCreateVolumeInvalid(t *testing.T) {
+	f := newFakeManila()
+	cs := NewControllerServer(f.newDriver())
+
+	_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               fakeShare,
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters:         map[string]string{"protocol": "CIFS"},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name: fakeShare,
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Empty(t, f.shares)
+}
+
+func TestDeleteVolume(t *testing.T) {
+	f := newFakeManila()
+	cs := NewControllerServer(f.newDriver())
+	share, _ := f.CreateShare(shares.CreateOpts{Name: fakeShare, Size: 1, ShareProto: protocolNFS})
+
+	_, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: share.ID})
+	assert.NoError(t, err)
+	assert.Empty(t, f.shares)
+
+	// Already deleted
+	_, err = cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: share.ID})
+	assert.NoError(t, err)
+}
+
+func TestControllerPublishVolume(t *testing.T) {
+	f := newFakeManila()
+	cs := NewControllerServer(f.newDriver())
+	nfs, _ := f.CreateShare(shares.CreateOpts{Name: "pvc-nfs", Size: 1, ShareProto: protocolNFS})
+	cephfs, _ := f.CreateShare(shares.CreateOpts{Name: "pvc-cephfs", Size: 1, ShareProto: protocolCephFS})
+
+	publish := func(shareID string, volCtx map[string]string) (*csi.ControllerPublishVolumeResponse, error) {
+		return cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+			VolumeId:         shareID,
+			NodeId:           fakeNodeID,
+			VolumeCapability: mountCapability(),
+			VolumeContext:    volCtx,
+		})
+	}
+
+	resp, err := publish(nfs.ID, map[string]string{shareProtocolKey: protocolNFS, nfsShareClientKey: "10.0.0.0/24"})
+	assert.NoError(t, err)
+	if assert.Len(t, f.rules[nfs.ID], 1) {
+		rule := f.rules[nfs.ID][0]
+		assert.Equal(t, "ip", rule.AccessType)
+		assert.Equal(t, "10.0.0.0/24", rule.AccessTo)
+		assert.Equal(t, map[string]string{accessRuleIDKey: rule.ID}, resp.GetPublishContext())
+	}
+
+	// The nodes share the rule
+	again, err := publish(nfs.ID, map[string]string{shareProtocolKey: protocolNFS, nfsShareClientKey: "10.0.0.0/24"})
+	assert.NoError(t, err)
+	assert.Equal(t, resp.GetPublishContext(), again.GetPublishContext())
+	assert.Len(t, f.rules[nfs.ID], 1)
+
+	_, err = publish(cephfs.ID, map[string]string{shareProtocolKey: protocolCephFS})
+	assert.NoError(t, err)
+	if assert.Len(t, f.rules[cephfs.ID], 1) {
+		assert.Equal(t, "cephx", f.rules[cephfs.ID][0].AccessType)
+		assert.Equal(t, "pvc-cephfs", f.rules[cephfs.ID][0].AccessTo)
+	}
+
+	_, err = publish("missing", map[string]string{shareProtocolKey: protocolNFS})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestControllerPublishVolumeRuleError(t *testing.T) {
+	f := newFakeManila()
+	cs := NewControllerServer(f.newDriver())
+	share, _ := f.CreateShare(shares.CreateOpts{Name: fakeShare, Size: 1, ShareProto: protocolNFS})
+	f.rules[share.ID] = []shares.AccessRight{{ID: "rule-0", AccessType: "ip", AccessTo: defaultNFSShareClient, AccessLevel: "rw", State: accessErrorState}}
+
+	// A rule in error is not reused
+	resp, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         share.ID,
+		NodeId:           fakeNodeID,
+		VolumeCapability: mountCapability(),
+		VolumeContext:    map[string]string{shareProtocolKey: protocolNFS},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "rule-1", resp.GetPublishContext()[accessRuleIDKey])
+}
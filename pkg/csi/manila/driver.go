@@ -0,0 +1,160 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+const (
+	driverName = "manila.csi.openstack.org"
+
+	// CephFSMounterKernel mounts CephFS shares with the kernel client
+	CephFSMounterKernel = "kernel"
+	// CephFSMounterFuse mounts CephFS shares with ceph-fuse
+	CephFSMounterFuse = "fuse"
+)
+
+var (
+	version = "1.0.0"
+)
+
+type ManilaDriver struct {
+	name     string
+	nodeID   string
+	version  string
+	endpoint string
+
+	// cephfsMounter is how the node plugin mounts CephFS shares, see
+	// SetCephFSMounter
+	cephfsMounter string
+
+	// newClient authenticates to Manila with the secrets of a request
+	newClient manilaClientBuilder
+	// mounter mounts the shares on the node
+	mounter mount.Interface
+
+	ids *identityServer
+	cs  *controllerServer
+	ns  *nodeServer
+
+	vcap  []*csi.VolumeCapability_AccessMode
+	cscap []*csi.ControllerServiceCapability
+	nscap []*csi.NodeServiceCapability
+}
+
+func NewDriver(nodeID, endpoint string) *ManilaDriver {
+	klog.Infof("Driver: %v version: %v", driverName, version)
+
+	d := &ManilaDriver{}
+	d.name = driverName
+	d.nodeID = nodeID
+	d.version = version
+	d.endpoint = endpoint
+	d.cephfsMounter = CephFSMounterKernel
+	d.newClient = newManilaV2Client
+	d.mounter = mount.New("")
+
+	d.AddControllerServiceCapabilities(
+		[]csi.ControllerServiceCapability_RPC_Type{
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+			csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+		})
+	d.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+	})
+
+	return d
+}
+
+// SetCephFSMounter sets how the node plugin mounts CephFS shares, with the
+// kernel client or ceph-fuse.
+func (d *ManilaDriver) SetCephFSMounter(mounter string) error {
+	switch mounter {
+	case CephFSMounterKernel, CephFSMounterFuse:
+	default:
+		return fmt.Errorf("invalid CephFS mounter %q, must be %s or %s", mounter, CephFSMounterKernel, CephFSMounterFuse)
+	}
+	klog.Infof("Mounting CephFS shares with the %s client", mounter)
+	d.cephfsMounter = mounter
+	return nil
+}
+
+func (d *ManilaDriver) AddControllerServiceCapabilities(cl []csi.ControllerServiceCapability_RPC_Type) {
+	var csc []*csi.ControllerServiceCapability
+
+	for _, c := range cl {
+		klog.Infof("Enabling controller service capability: %v", c.String())
+		csc = append(csc, NewControllerServiceCapability(c))
+	}
+
+	d.cscap = csc
+}
+
+func (d *ManilaDriver) AddVolumeCapabilityAccessModes(vc []csi.VolumeCapability_AccessMode_Mode) []*csi.VolumeCapability_AccessMode {
+	var vca []*csi.VolumeCapability_AccessMode
+	for _, c := range vc {
+		klog.Infof("Enabling volume access mode: %v", c.String())
+		vca = append(vca, NewVolumeCapabilityAccessMode(c))
+	}
+	d.vcap = vca
+	return vca
+}
+
+func (d *ManilaDriver) AddNodeServiceCapabilities(nl []csi.NodeServiceCapability_RPC_Type) {
+	var nsc []*csi.NodeServiceCapability
+	for _, n := range nl {
+		klog.Infof("Enabling node service capability: %v", n.String())
+		nsc = append(nsc, NewNodeServiceCapability(n))
+	}
+	d.nscap = nsc
+}
+
+func (d *ManilaDriver) ValidateControllerServiceRequest(c csi.ControllerServiceCapability_RPC_Type) error {
+	if c == csi.ControllerServiceCapability_RPC_UNKNOWN {
+		return nil
+	}
+
+	for _, cap := range d.cscap {
+		if c == cap.GetRpc().GetType() {
+			return nil
+		}
+	}
+	return status.Error(codes.InvalidArgument, fmt.Sprintf("%s", c))
+}
+
+func (d *ManilaDriver) GetVolumeCapabilityAccessModes() []*csi.VolumeCapability_AccessMode {
+	return d.vcap
+}
+
+func (d *ManilaDriver) Run() {
+	d.ids = NewIdentityServer(d)
+	d.cs = NewControllerServer(d)
+	d.ns = NewNodeServer(d)
+
+	RunControllerandNodePublishServer(d.endpoint, d.ids, d.cs, d.ns)
+}
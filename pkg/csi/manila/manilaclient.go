@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	manilashare "k8s.io/cloud-provider-openstack/pkg/share/manila"
+	"k8s.io/cloud-provider-openstack/pkg/share/manila/shareoptions"
+)
+
+// manilaClient is the part of the Manila v2 API the driver uses
+type manilaClient interface {
+	// GetShareByName returns nil when there is no share named name
+	GetShareByName(name string) (*shares.Share, error)
+	GetShareByID(shareID string) (*shares.Share, error)
+	CreateShare(opts shares.CreateOpts) (*shares.Share, error)
+	DeleteShare(shareID string) error
+	GetExportLocations(shareID string) ([]shares.ExportLocation, error)
+	ListAccessRights(shareID string) ([]shares.AccessRight, error)
+	GrantAccess(shareID string, opts shares.GrantAccessOpts) (*shares.AccessRight, error)
+}
+
+// manilaClientBuilder authenticates to Manila with the OpenStack
+// credentials in the secrets of a CSI request
+type manilaClientBuilder func(secrets map[string]string) (manilaClient, error)
+
+func newManilaV2Client(secrets map[string]string) (manilaClient, error) {
+	opts, err := shareoptions.NewOpenStackOptionsFromMap(secrets)
+	if err != nil {
+		return nil, err
+	}
+	c, err := manilashare.NewManilaV2Client(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &manilaV2Client{c: c}, nil
+}
+
+type manilaV2Client struct {
+	c *gophercloud.ServiceClient
+}
+
+func (m *manilaV2Client) GetShareByName(name string) (*shares.Share, error) {
+	shareID, err := shares.IDFromName(m.c, name)
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrResourceNotFound); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return m.GetShareByID(shareID)
+}
+
+func (m *manilaV2Client) GetShareByID(shareID string) (*shares.Share, error) {
+	return shares.Get(m.c, shareID).Extract()
+}
+
+func (m *manilaV2Client) CreateShare(opts shares.CreateOpts) (*shares.Share, error) {
+	return shares.Create(m.c, opts).Extract()
+}
+
+func (m *manilaV2Client) DeleteShare(shareID string) error {
+	return shares.Delete(m.c, shareID).ExtractErr()
+}
+
+func (m *manilaV2Client) GetExportLocations(shareID string) ([]shares.ExportLocation, error) {
+	return shares.GetExportLocations(m.c, shareID).Extract()
+}
+
+func (m *manilaV2Client) ListAccessRights(shareID string) ([]shares.AccessRight, error) {
+	return shares.ListAccessRights(m.c, shareID).Extract()
+}
+
+func (m *manilaV2Client) GrantAccess(shareID string, opts shares.GrantAccessOpts) (*shares.AccessRight, error) {
+	return shares.GrantAccess(m.c, shareID, opts).Extract()
+}
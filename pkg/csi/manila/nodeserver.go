@@ -0,0 +1,211 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/cloud-provider-openstack/pkg/share/manila/sharebackends"
+	"k8s.io/klog"
+)
+
+// runCommand runs the mount helpers that are not mount(8) types
+var runCommand = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+type nodeServer struct {
+	Driver *ManilaDriver
+}
+
+func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	shareID := req.GetVolumeId()
+	targetPath := req.GetTargetPath()
+	if len(shareID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume Volume ID must be provided")
+	}
+	if len(targetPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume Target Path must be provided")
+	}
+	if err := validateVolumeCapabilities([]*csi.VolumeCapability{req.GetVolumeCapability()}); err != nil {
+		return nil, err
+	}
+
+	volCtx := req.GetVolumeContext()
+	protocol, err := shareProtocol(volCtx[shareProtocolKey])
+	if err != nil {
+		return nil, err
+	}
+	monitors, path, err := sharebackends.SplitExportLocation(&shares.ExportLocation{Path: volCtx[exportLocationKey]})
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "NodePublishVolume share %s: %v", shareID, err)
+	}
+
+	m := ns.Driver.mounter
+	notMnt, err := m.IsLikelyNotMountPoint(targetPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if err := os.MkdirAll(targetPath, 0750); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		notMnt = true
+	}
+	if !notMnt {
+		klog.V(4).Infof("NodePublishVolume: share %s is already mounted on %s", shareID, targetPath)
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	options := append([]string(nil), req.GetVolumeCapability().GetMount().GetMountFlags()...)
+	if req.GetReadonly() {
+		options = append(options, "ro")
+	}
+
+	switch protocol {
+	case protocolNFS:
+		err = m.Mount(volCtx[exportLocationKey], targetPath, "nfs", options)
+	case protocolCephFS:
+		err = ns.mountCephFS(req, monitors, path, options)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	klog.V(4).Infof("NodePublishVolume: mounted share %s on %s", shareID, targetPath)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// mountCephFS mounts the CephFS share of req with the cephx access rule
+// ControllerPublishVolume granted, whose key is looked up with the node
+// publish secrets. The key is only on disk while mounting.
+func (ns *nodeServer) mountCephFS(req *csi.NodePublishVolumeRequest, monitors, path string, options []string) error {
+	shareID := req.GetVolumeId()
+	ruleID := req.GetPublishContext()[accessRuleIDKey]
+	if ruleID == "" {
+		return status.Errorf(codes.InvalidArgument, "NodePublishVolume share %s has no access rule, was it published?", shareID)
+	}
+
+	client, err := ns.Driver.newClient(req.GetSecrets())
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, "failed to authenticate to Manila: %v", err)
+	}
+	rule, err := findAccessRule(client, shareID, ruleID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get access rule %s of share %s: %v", ruleID, shareID, err)
+	}
+	if rule.AccessKey == "" {
+		return status.Errorf(codes.Unavailable, "access rule %s of share %s has no key yet", ruleID, shareID)
+	}
+
+	keyFile, err := ioutil.TempFile("", "manila-csi-")
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer os.Remove(keyFile.Name())
+	_, err = keyFile.WriteString(rule.AccessKey)
+	if cerr := keyFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to write the key of share %s: %v", shareID, err)
+	}
+
+	targetPath := req.GetTargetPath()
+	switch ns.Driver.cephfsMounter {
+	case CephFSMounterFuse:
+		args := []string{targetPath, "-m", monitors, "-r", path, "--id", rule.AccessTo, "--keyfile", keyFile.Name()}
+		if req.GetReadonly() {
+			args = append(args, "-o", "ro")
+		}
+		if out, err := runCommand("ceph-fuse", args...); err != nil {
+			return status.Errorf(codes.Internal, "ceph-fuse failed to mount share %s: %v: %s", shareID, err, out)
+		}
+	default:
+		options = append(options, "name="+rule.AccessTo, "secretfile="+keyFile.Name())
+		if err := ns.Driver.mounter.Mount(monitors+":"+path, targetPath, "ceph", options); err != nil {
+			return status.Errorf(codes.Internal, "failed to mount share %s: %v", shareID, err)
+		}
+	}
+	return nil
+}
+
+func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	targetPath := req.GetTargetPath()
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeUnpublishVolume Volume ID must be provided")
+	}
+	if len(targetPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeUnpublishVolume Target Path must be provided")
+	}
+
+	m := ns.Driver.mounter
+	notMnt, err := m.IsLikelyNotMountPoint(targetPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &csi.NodeUnpublishVolumeResponse{}, nil
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !notMnt {
+		if err := m.Unmount(targetPath); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to unmount %s: %v", targetPath, err)
+		}
+	}
+	if err := os.Remove(targetPath); err != nil && !os.IsNotExist(err) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{
+		NodeId: ns.Driver.nodeID,
+	}, nil
+}
+
+func (ns *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	klog.V(5).Infof("NodeGetCapabilities called with req: %#v", req)
+
+	return &csi.NodeGetCapabilitiesResponse{
+		Capabilities: ns.Driver.nscap,
+	}, nil
+}
+
+func (ns *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
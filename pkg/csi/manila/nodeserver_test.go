@@ -0,0 +1,143 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+// publishShare returns the NodePublishVolume request of a share of protocol
+// published by ControllerPublishVolume.
+func publishShare(t *testing.T, f *fakeManila, d *ManilaDriver, protocol string) *csi.NodePublishVolumeRequest {
+	share, _ := f.CreateShare(shares.CreateOpts{Name: fakeShare, Size: 1, ShareProto: protocol})
+	volCtx := map[string]string{shareProtocolKey: protocol, exportLocationKey: f.locs[1].Path}
+	resp, err := NewControllerServer(d).ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         share.ID,
+		NodeId:           fakeNodeID,
+		VolumeCapability: mountCapability(),
+		VolumeContext:    volCtx,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "manila-csi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &csi.NodePublishVolumeRequest{
+		VolumeId:         share.ID,
+		TargetPath:       filepath.Join(dir, "mount"),
+		VolumeCapability: mountCapability(),
+		VolumeContext:    volCtx,
+		PublishContext:   resp.GetPublishContext(),
+	}
+}
+
+func TestNodePublishVolumeNFS(t *testing.T) {
+	f := newFakeManila()
+	d := f.newDriver()
+	ns := NewNodeServer(d)
+	req := publishShare(t, f, d, protocolNFS)
+	defer os.RemoveAll(filepath.Dir(req.TargetPath))
+	req.Readonly = true
+
+	_, err := ns.NodePublishVolume(context.Background(), req)
+	assert.NoError(t, err)
+	m := d.mounter.(*mount.FakeMounter)
+	if assert.Len(t, m.MountPoints, 1) {
+		assert.Equal(t, mount.MountPoint{Device: f.locs[1].Path, Path: req.TargetPath, Type: "nfs", Opts: []string{"ro"}}, m.MountPoints[0])
+	}
+
+	// Already mounted
+	_, err = ns.NodePublishVolume(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Len(t, m.MountPoints, 1)
+
+	_, err = ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: req.VolumeId, TargetPath: req.TargetPath})
+	assert.NoError(t, err)
+	assert.Empty(t, m.MountPoints)
+	_, err = os.Stat(req.TargetPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestNodePublishVolumeCephFSKernel(t *testing.T) {
+	f := newFakeManila()
+	d := f.newDriver()
+	ns := NewNodeServer(d)
+	req := publishShare(t, f, d, protocolCephFS)
+	defer os.RemoveAll(filepath.Dir(req.TargetPath))
+
+	_, err := ns.NodePublishVolume(context.Background(), req)
+	assert.NoError(t, err)
+	m := d.mounter.(*mount.FakeMounter)
+	if assert.Len(t, m.MountPoints, 1) {
+		mp := m.MountPoints[0]
+		assert.Equal(t, "10.0.0.2:6789,10.0.0.3:6789:/volumes/_nogroup/1", mp.Device)
+		assert.Equal(t, "ceph", mp.Type)
+		if assert.Len(t, mp.Opts, 2) {
+			assert.Equal(t, "name="+fakeShare, mp.Opts[0])
+			// The key is removed once mounted
+			_, err = os.Stat(mp.Opts[1][len("secretfile="):])
+			assert.True(t, os.IsNotExist(err))
+		}
+	}
+}
+
+func TestNodePublishVolumeCephFSFuse(t *testing.T) {
+	f := newFakeManila()
+	d := f.newDriver()
+	assert.NoError(t, d.SetCephFSMounter(CephFSMounterFuse))
+	ns := NewNodeServer(d)
+	req := publishShare(t, f, d, protocolCephFS)
+	defer os.RemoveAll(filepath.Dir(req.TargetPath))
+
+	var ran []string
+	defer func(run func(string, ...string) ([]byte, error)) { runCommand = run }(runCommand)
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		ran = append([]string{name}, args...)
+		key, err := ioutil.ReadFile(args[len(args)-1])
+		assert.NoError(t, err)
+		assert.Equal(t, fakeKey, string(key))
+		return nil, nil
+	}
+
+	_, err := ns.NodePublishVolume(context.Background(), req)
+	assert.NoError(t, err)
+	if assert.Len(t, ran, 10) {
+		assert.Equal(t, []string{"ceph-fuse", req.TargetPath, "-m", "10.0.0.2:6789,10.0.0.3:6789", "-r", "/volumes/_nogroup/1", "--id", fakeShare}, ran[:8])
+	}
+
+	// Not published
+	req.PublishContext = nil
+	req.TargetPath = filepath.Join(filepath.Dir(req.TargetPath), "other")
+	_, err = ns.NodePublishVolume(context.Background(), req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	assert.Error(t, d.SetCephFSMounter("nfs"))
+}
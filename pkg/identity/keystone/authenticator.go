@@ -17,13 +17,18 @@ limitations under the License.
 package keystone
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"time"
 
 	"github.com/gophercloud/gophercloud"
+	"k8s.io/cloud-provider-openstack/pkg/util/skew"
 	"k8s.io/klog"
 
+	"k8s.io/apimachinery/pkg/util/cache"
 	"k8s.io/apiserver/pkg/authentication/user"
 )
 
@@ -32,6 +37,32 @@ import (
 type Authenticator struct {
 	authURL string
 	client  *gophercloud.ServiceClient
+	// clockSkew is updated by the responses of client, the token expiry
+	// is compared against the Keystone clock
+	clockSkew *skew.Tracker
+
+	// cache keeps the users of the valid tokens for cacheTTL, at most until
+	// the tokens expire. A nil cache disables caching.
+	cache    *cache.LRUExpireCache
+	cacheTTL time.Duration
+	// validations bounds the number of concurrent Keystone token validation
+	// calls when not nil
+	validations chan struct{}
+}
+
+// newAuthenticator returns an Authenticator caching up to cacheSize tokens
+// for cacheTTL and validating at most maxValidations tokens at once. A zero
+// cacheSize or cacheTTL disables the cache, a zero maxValidations the bound.
+func newAuthenticator(authURL string, client *gophercloud.ServiceClient, clockSkew *skew.Tracker, cacheSize int, cacheTTL time.Duration, maxValidations int) *Authenticator {
+	a := &Authenticator{authURL: authURL, client: client, clockSkew: clockSkew}
+	if cacheSize > 0 && cacheTTL > 0 {
+		a.cache = cache.NewLRUExpireCache(cacheSize)
+		a.cacheTTL = cacheTTL
+	}
+	if maxValidations > 0 {
+		a.validations = make(chan struct{}, maxValidations)
+	}
+	return a
 }
 
 type keystoneResponse struct {
@@ -51,11 +82,63 @@ type keystoneResponse struct {
 		Roles []struct {
 			Name string `json:"name"`
 		} `json:"roles"`
+		ExpiresAt time.Time `json:"expires_at"`
 	} `json:"token"`
 }
 
-// AuthenticateToken checks the token via Keystone call
+// tokenCacheKey returns the key of token in the cache, so that the cache
+// doesn't keep the tokens themselves.
+func tokenCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuthenticateToken checks the token via Keystone call, or the cache when the
+// token was validated recently
 func (a *Authenticator) AuthenticateToken(token string) (user.Info, bool, error) {
+	var key string
+	if a.cache != nil {
+		key = tokenCacheKey(token)
+		if u, ok := a.cache.Get(key); ok {
+			tokenCacheRequests.WithLabelValues(tokenCacheResultHit).Inc()
+			return u.(user.Info), true, nil
+		}
+		tokenCacheRequests.WithLabelValues(tokenCacheResultMiss).Inc()
+	}
+
+	if a.validations != nil {
+		a.validations <- struct{}{}
+		defer func() { <-a.validations }()
+	}
+
+	start := time.Now()
+	u, expiresAt, err := a.validateToken(token)
+	if err != nil {
+		tokenValidationDuration.WithLabelValues(tokenValidationFailure).Observe(time.Since(start).Seconds())
+		return nil, false, err
+	}
+	tokenValidationDuration.WithLabelValues(tokenValidationSuccess).Observe(time.Since(start).Seconds())
+
+	if a.cache != nil {
+		// Never keep a token past its expiry, converted from the Keystone
+		// clock to the local clock of the cache
+		ttl := a.cacheTTL
+		if !expiresAt.IsZero() {
+			if a.clockSkew.Expired(expiresAt, 0) {
+				ttl = 0
+			} else if untilExpiry := time.Until(a.clockSkew.ToLocal(expiresAt)); untilExpiry < ttl {
+				ttl = untilExpiry
+			}
+		}
+		if ttl > 0 {
+			a.cache.Add(key, u, ttl)
+		}
+	}
+	return u, true, nil
+}
+
+// validateToken returns the user of token and when the token expires
+func (a *Authenticator) validateToken(token string) (user.Info, time.Time, error) {
 	// We can use the Keystone GET /v3/auth/tokens API to validate the token
 	// and get information about the user as well
 	// http://git.openstack.org/cgit/openstack/keystone/tree/api-ref/source/v3/authenticate-v3.inc#n437
@@ -70,14 +153,14 @@ func (a *Authenticator) AuthenticateToken(token string) (user.Info, bool, error)
 	response, err := a.client.Request("GET", url, &requestOpts)
 	if err != nil {
 		klog.Warningf("Failed: bad response from API call: %v", err)
-		return nil, false, errors.New("Failed to authenticate")
+		return nil, time.Time{}, errors.New("Failed to authenticate")
 	}
 
 	defer response.Body.Close()
 	bodyBytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		klog.Warningf("Cannot get HTTP response body from keystone token validate: %v", err)
-		return nil, false, errors.New("Failed to authenticate")
+		return nil, time.Time{}, errors.New("Failed to authenticate")
 	}
 
 	var obj keystoneResponse
@@ -85,7 +168,7 @@ func (a *Authenticator) AuthenticateToken(token string) (user.Info, bool, error)
 	err = json.Unmarshal(bodyBytes, &obj)
 	if err != nil {
 		klog.Warningf("Cannot unmarshal response: %v", err)
-		return nil, false, errors.New("Failed to authenticate")
+		return nil, time.Time{}, errors.New("Failed to authenticate")
 	}
 
 	var roles []string
@@ -113,5 +196,5 @@ func (a *Authenticator) AuthenticateToken(token string) (user.Info, bool, error)
 		Extra:  extra,
 	}
 
-	return authenticatedUser, true, nil
+	return authenticatedUser, obj.Token.ExpiresAt, nil
 }
@@ -17,10 +17,12 @@ import (
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
 	th "github.com/gophercloud/gophercloud/testhelper"
+	"k8s.io/cloud-provider-openstack/pkg/util/skew"
 )
 
 func TestAuthenticateToken(t *testing.T) {
@@ -123,3 +125,73 @@ func TestAuthenticateToken(t *testing.T) {
 	th.AssertEquals(t, (err != nil), true)
 	th.CheckEquals(t, ok, false)
 }
+
+func TestAuthenticateTokenCache(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	validations := 0
+	expiresAt := time.Now().Add(time.Hour)
+	th.Mux.HandleFunc("/auth/tokens", func(w http.ResponseWriter, r *http.Request) {
+		validations++
+		switch r.Header.Get("X-Auth-Token") {
+		case "GoodToken":
+			fmt.Fprintf(w, `{"token": {"expires_at": "%s", "user": {"id": "u1", "name": "admin"}}}`, expiresAt.UTC().Format(time.RFC3339))
+		case "SkewedToken":
+			fmt.Fprintf(w, `{"token": {"expires_at": "%s", "user": {"id": "u1", "name": "admin"}}}`, time.Now().Add(30*time.Minute).UTC().Format(time.RFC3339))
+		case "ExpiringToken":
+			fmt.Fprintf(w, `{"token": {"expires_at": "%s", "user": {"id": "u1", "name": "admin"}}}`, time.Now().Add(-time.Second).UTC().Format(time.RFC3339))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+
+	provider, _ := openstack.NewClient(th.Endpoint())
+	cli := &gophercloud.ServiceClient{
+		ProviderClient: provider,
+		Endpoint:       th.Endpoint(),
+	}
+
+	a := newAuthenticator(th.Endpoint(), cli, skew.NewTracker(0, nil), 10, time.Minute, 2)
+
+	for i := 0; i < 3; i++ {
+		user, ok, err := a.AuthenticateToken("GoodToken")
+		th.AssertNoErr(t, err)
+		th.CheckEquals(t, ok, true)
+		th.AssertEquals(t, "admin", user.GetName())
+	}
+	th.AssertEquals(t, 1, validations)
+
+	// Expired and invalid tokens are not cached
+	validations = 0
+	for i := 0; i < 2; i++ {
+		a.AuthenticateToken("ExpiringToken")
+		_, ok, _ := a.AuthenticateToken("WrongToken")
+		th.CheckEquals(t, ok, false)
+	}
+	th.AssertEquals(t, 4, validations)
+
+	// A token valid according to the local clock but expired according to
+	// the Keystone clock, an hour ahead, is not cached
+	clockSkew := skew.NewTracker(0, nil)
+	clockSkew.Observe(time.Now(), &http.Response{Header: http.Header{"Date": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}})
+	a = newAuthenticator(th.Endpoint(), cli, clockSkew, 10, time.Minute, 2)
+	validations = 0
+	a.AuthenticateToken("SkewedToken")
+	a.AuthenticateToken("SkewedToken")
+	th.AssertEquals(t, 2, validations)
+
+	// The same token is cached when the Keystone clock is an hour behind
+	clockSkew.Observe(time.Now(), &http.Response{Header: http.Header{"Date": {time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}}})
+	validations = 0
+	a.AuthenticateToken("SkewedToken")
+	a.AuthenticateToken("SkewedToken")
+	th.AssertEquals(t, 1, validations)
+
+	// No cache
+	a = newAuthenticator(th.Endpoint(), cli, skew.NewTracker(0, nil), 0, time.Minute, 0)
+	validations = 0
+	a.AuthenticateToken("GoodToken")
+	a.AuthenticateToken("GoodToken")
+	th.AssertEquals(t, 2, validations)
+}
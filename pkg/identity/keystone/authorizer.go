@@ -46,6 +46,19 @@ func findString(a string, list []string) bool {
 	return false
 }
 
+// findExtra returns whether one of the values of the given keys of the extra
+// user info is in list.
+func findExtra(extra map[string][]string, list []string, keys ...string) bool {
+	for _, key := range keys {
+		for _, item := range extra[key] {
+			if findString(item, list) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func resourceMatches(p policy, a authorizer.Attributes) bool {
 	if *p.ResourceSpec.APIGroup != "*" && *p.ResourceSpec.APIGroup != a.GetAPIGroup() {
 		return false
@@ -101,7 +114,7 @@ func nonResourceMatches(p policy, a authorizer.Attributes) bool {
 func match(match []policyMatch, attributes authorizer.Attributes) bool {
 	user := attributes.GetUser()
 	var find = false
-	types := []string{TypeGroup, TypeProject, TypeRole, TypeUser}
+	types := []string{TypeGroup, TypeProject, TypeRole, TypeUser, TypeDomain}
 
 	for _, m := range match {
 		if !findString(m.Type, types) {
@@ -166,6 +179,10 @@ func match(match []policyMatch, attributes authorizer.Attributes) bool {
 				}
 			}
 			return false
+		} else if m.Type == TypeDomain {
+			if !findExtra(user.GetExtra(), m.Values, "alpha.kubernetes.io/identity/user/domain/id", "alpha.kubernetes.io/identity/user/domain/name") {
+				return false
+			}
 		} else {
 			klog.Infof("unknown type %s. skipping.", m.Type)
 		}
@@ -174,6 +191,13 @@ func match(match []policyMatch, attributes authorizer.Attributes) bool {
 	return true
 }
 
+// setPolicies replaces the policies the requests are authorized with.
+func (a *Authorizer) setPolicies(pl policyList) {
+	a.mu.Lock()
+	a.pl = pl
+	a.mu.Unlock()
+}
+
 // Authorize checks whether the user can perform an operation
 func (a *Authorizer) Authorize(attributes authorizer.Attributes) (authorized authorizer.Decision, reason string, err error) {
 	a.mu.Lock()
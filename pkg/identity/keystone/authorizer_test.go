@@ -17,6 +17,7 @@ limitations under the License.
 package keystone
 
 import (
+	"io/ioutil"
 	"os"
 	"testing"
 
@@ -40,7 +41,9 @@ func TestAuthorizer(t *testing.T) {
 	path, err := os.Getwd()
 	th.AssertNoErr(t, err)
 	path += "/authorizer_test_policy.json"
-	policy, err := newFromFile(path)
+	data, err := ioutil.ReadFile(path)
+	th.AssertNoErr(t, err)
+	policy, err := parsePolicies(data)
 	th.AssertNoErr(t, err)
 
 	a := &Authorizer{authURL: "127.0.0.1", client: client, pl: policy}
@@ -19,6 +19,7 @@ package keystone
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/pflag"
 	"k8s.io/klog"
@@ -36,6 +37,16 @@ type Config struct {
 	SyncConfigFile      string
 	SyncConfigMapName   string
 	Kubeconfig          string
+
+	// TokenCacheSize and TokenCacheTTL configure the cache of the validated
+	// tokens, a zero value disables it
+	TokenCacheSize int
+	TokenCacheTTL  time.Duration
+	// MaxConcurrentValidations bounds the number of tokens validated with
+	// Keystone at once, unbounded when zero
+	MaxConcurrentValidations int
+	// MetricsAddress serves the metrics when not empty
+	MetricsAddress string
 }
 
 // NewConfig returns a Config
@@ -51,6 +62,10 @@ func NewConfig() *Config {
 		SyncConfigFile:      os.Getenv("KEYSTONE_SYNC_CONFIG_FILE"),
 		SyncConfigMapName:   os.Getenv("KEYSTONE_SYNC_CONFIGMAP_NAME"),
 		Kubeconfig:          os.Getenv("KEYSTONE_KUBECONFIG_FILE"),
+
+		TokenCacheSize:           1000,
+		TokenCacheTTL:            2 * time.Minute,
+		MaxConcurrentValidations: 50,
 	}
 }
 
@@ -69,10 +84,22 @@ func (c *Config) ValidateFlags() error {
 	if c.PolicyFile == "" && c.PolicyConfigMapName == "" {
 		klog.Warning("Argument --keystone-policy-file or --policy-configmap-name missing. Only keystone authentication will work. Use RBAC for authorization.")
 	}
+	if c.PolicyFile != "" && c.PolicyConfigMapName != "" {
+		klog.Warningf("Both --keystone-policy-file and --policy-configmap-name are set, the policy is read from %s and the configmap %s is ignored.", c.PolicyFile, c.PolicyConfigMapName)
+	}
 	if c.SyncConfigFile == "" && c.SyncConfigMapName == "" {
 		klog.Warning("Argument --sync-config-file or --sync-configmap-name missing. Data synchronization between Keystone and Kubernetes is disabled.")
 	}
 
+	if c.TokenCacheSize < 0 || c.TokenCacheTTL < 0 {
+		errorsFound = true
+		klog.Errorf("--token-cache-size and --token-cache-ttl can't be negative.")
+	}
+	if c.MaxConcurrentValidations < 0 {
+		errorsFound = true
+		klog.Errorf("--max-concurrent-token-validations can't be negative.")
+	}
+
 	if errorsFound {
 		return fmt.Errorf("failed to validate the input parameters")
 	}
@@ -86,9 +113,13 @@ func (c *Config) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&c.KeyFile, "tls-private-key-file", c.KeyFile, "File containing the default x509 private key matching --tls-cert-file.")
 	fs.StringVar(&c.KeystoneURL, "keystone-url", c.KeystoneURL, "URL for the OpenStack Keystone API")
 	fs.StringVar(&c.KeystoneCA, "keystone-ca-file", c.KeystoneCA, "File containing the certificate authority for Keystone Service.")
-	fs.StringVar(&c.PolicyFile, "keystone-policy-file", c.PolicyFile, "File containing the policy, if provided, it takes precedence over the policy configmap.")
+	fs.StringVar(&c.PolicyFile, "keystone-policy-file", c.PolicyFile, "File containing the policy in JSON or YAML, if provided, it takes precedence over the policy configmap and is reloaded when it changes.")
 	fs.StringVar(&c.PolicyConfigMapName, "policy-configmap-name", c.PolicyConfigMapName, "ConfigMap in kube-system namespace containing the policy configuration, the ConfigMap data must contain the key 'policies'")
 	fs.StringVar(&c.SyncConfigFile, "sync-config-file", c.SyncConfigFile, "File containing config values for data synchronization beetween Keystone and Kubernetes.")
 	fs.StringVar(&c.SyncConfigMapName, "sync-configmap-name", "", "ConfigMap in kube-system namespace containing config values for data synchronization beetween Keystone and Kubernetes.")
 	fs.StringVar(&c.Kubeconfig, "kubeconfig", c.Kubeconfig, "Kubeconfig file used to connect to Kubernetes API to get policy configmap. If the service is running inside the pod, this option is not necessary, will use in-cluster config instead.")
+	fs.IntVar(&c.TokenCacheSize, "token-cache-size", c.TokenCacheSize, "Maximum number of validated tokens cached, 0 disables the token cache.")
+	fs.DurationVar(&c.TokenCacheTTL, "token-cache-ttl", c.TokenCacheTTL, "How long a validated token is cached, at most until the token expires. 0 disables the token cache.")
+	fs.IntVar(&c.MaxConcurrentValidations, "max-concurrent-token-validations", c.MaxConcurrentValidations, "Maximum number of tokens validated with Keystone at once, 0 for no limit.")
+	fs.StringVar(&c.MetricsAddress, "metrics-address", c.MetricsAddress, "Address to serve the Prometheus metrics on /metrics, e.g. :9090. Disabled when empty")
 }
@@ -17,9 +17,11 @@ limitations under the License.
 package keystone
 
 import (
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"time"
 
@@ -43,12 +45,17 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	certutil "k8s.io/client-go/util/cert"
 	"k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-openstack/pkg/util/skew"
 	"k8s.io/klog"
 )
 
 const (
 	maxRetries  = 5
 	cmNamespace = "kube-system"
+
+	// policyFileReloadInterval is how often the policy file is checked for
+	// changes
+	policyFileReloadInterval = 30 * time.Second
 )
 
 type userInfo struct {
@@ -75,6 +82,8 @@ type KeystoneAuth struct {
 	informer       informers.SharedInformerFactory
 	cmLister       corelisters.ConfigMapLister
 	cmListerSynced cache.InformerSynced
+	// policyFileData is the content of the policy file last loaded
+	policyFileData []byte
 }
 
 // Run starts the keystone webhook server.
@@ -95,6 +104,15 @@ func (k *KeystoneAuth) Run() {
 		go wait.Until(k.runWorker, time.Second, k.stopCh)
 	}
 
+	if k.config.PolicyFile != "" {
+		go wait.Until(k.reloadPolicyFile, policyFileReloadInterval, k.stopCh)
+	}
+
+	registerMetrics()
+	if k.config.MetricsAddress != "" {
+		serveMetrics(k.config.MetricsAddress)
+	}
+
 	r := mux.NewRouter()
 	r.HandleFunc("/webhook", k.Handler)
 
@@ -115,7 +133,7 @@ func (k *KeystoneAuth) enqueueConfigMap(obj interface{}) {
 		return
 	}
 
-	if namespace == cmNamespace && (name == k.config.PolicyConfigMapName || name == k.config.SyncConfigMapName) {
+	if namespace == cmNamespace && ((name == k.config.PolicyConfigMapName && k.config.PolicyFile == "") || name == k.config.SyncConfigMapName) {
 		k.queue.Add(key)
 	}
 }
@@ -154,23 +172,43 @@ func (k *KeystoneAuth) processNextItem() bool {
 func (k *KeystoneAuth) updatePolicies(cm *apiv1.ConfigMap, key string) {
 	klog.Info("ConfigMap created or updated, will update the authorization policy.")
 
-	var policy policyList
-	if err := json.Unmarshal([]byte(cm.Data["policies"]), &policy); err != nil {
-		runtimeutil.HandleError(fmt.Errorf("failed to parse policies defined in the configmap %s: %v", key, err))
-	}
-	if len(policy) > 0 {
-		if _, err := json.MarshalIndent(policy, "", "  "); err != nil {
-			runtimeutil.HandleError(fmt.Errorf("failed to parse policies defined in the configmap %s: %v", key, err))
-		}
+	// An invalid policy keeps the one in place rather than denying everything
+	policy, err := parsePolicies([]byte(cm.Data["policies"]))
+	if err != nil {
+		runtimeutil.HandleError(fmt.Errorf("failed to parse policies defined in the configmap %s, keeping the current policy: %v", key, err))
+		return
 	}
 
-	k.authz.mu.Lock()
-	k.authz.pl = policy
-	k.authz.mu.Unlock()
+	k.authz.setPolicies(policy)
 
 	klog.Infof("Authorization policy updated.")
 }
 
+// reloadPolicyFile replaces the authorization policy with the one of the
+// policy file when the file changed. An invalid policy file keeps the policy
+// in place.
+func (k *KeystoneAuth) reloadPolicyFile() {
+	data, err := ioutil.ReadFile(k.config.PolicyFile)
+	if err != nil {
+		runtimeutil.HandleError(fmt.Errorf("failed to read policy file %s: %v", k.config.PolicyFile, err))
+		return
+	}
+	if bytes.Equal(data, k.policyFileData) {
+		return
+	}
+	// Only report an invalid policy file once per change
+	k.policyFileData = data
+
+	policy, err := parsePolicies(data)
+	if err != nil {
+		runtimeutil.HandleError(fmt.Errorf("failed to parse policy file %s, keeping the current policy: %v", k.config.PolicyFile, err))
+		return
+	}
+	k.authz.setPolicies(policy)
+
+	klog.Infof("Authorization policy reloaded from %s.", k.config.PolicyFile)
+}
+
 func (k *KeystoneAuth) updateSyncConfig(cm *apiv1.ConfigMap, key string) {
 	klog.Info("ConfigMap created or updated, will update the sync configuration.")
 
@@ -199,9 +237,7 @@ func (k *KeystoneAuth) processItem(key string) error {
 	case errors.IsNotFound(err):
 		if name == k.config.PolicyConfigMapName {
 			klog.Infof("PolicyConfigmap %v has been deleted.", k.config.PolicyConfigMapName)
-			k.authz.mu.Lock()
-			k.authz.pl = make([]*policy, 0)
-			k.authz.mu.Unlock()
+			k.authz.setPolicies(make([]*policy, 0))
 		}
 		if name == k.config.SyncConfigMapName {
 			klog.Infof("SyncConfigmap %v has been deleted.", k.config.SyncConfigMapName)
@@ -387,6 +423,8 @@ func NewKeystoneAuth(c *Config) (*KeystoneAuth, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize keystone client: %v", err)
 	}
+	clockSkew := skew.NewTracker(skew.DefaultThreshold, keystoneClockSkew)
+	keystoneClient.HTTPClient.Transport = clockSkew.RoundTripper(keystoneClient.HTTPClient.Transport)
 
 	var k8sClient *kubernetes.Clientset
 	if c.PolicyConfigMapName != "" || c.SyncConfigMapName != "" || c.SyncConfigFile != "" {
@@ -396,26 +434,30 @@ func NewKeystoneAuth(c *Config) (*KeystoneAuth, error) {
 		}
 	}
 
-	// Get policy definition either from a policy file or the policy configmap. Policy file takes precedence
-	// over the configmap, but the policy definition will be refreshed based on the configmap change on-the-fly. It
-	// is possible that both are not provided, in this case, the keytone webhook authorization will always return deny.
+	// Get policy definition either from a policy file or the policy configmap, in JSON or YAML. Policy file takes
+	// precedence over the configmap. In both cases the policy definition is refreshed on-the-fly, the policy file
+	// is checked for changes every policyFileReloadInterval. It is possible that both are not provided, in this
+	// case, the keytone webhook authorization will always return deny.
 	var policy policyList
-	if c.PolicyConfigMapName != "" {
+	var policyFileData []byte
+	if c.PolicyFile != "" {
+		policyFileData, err = ioutil.ReadFile(c.PolicyFile)
+		if err == nil {
+			policy, err = parsePolicies(policyFileData)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract policy from policy file %s: %v", c.PolicyFile, err)
+		}
+	} else if c.PolicyConfigMapName != "" {
 		cm, err := k8sClient.CoreV1().ConfigMaps(cmNamespace).Get(c.PolicyConfigMapName, metav1.GetOptions{})
 		if err != nil {
 			return nil, fmt.Errorf("failed to get configmap %s: %v", c.PolicyConfigMapName, err)
 		}
 
-		if err := json.Unmarshal([]byte(cm.Data["policies"]), &policy); err != nil {
+		if policy, err = parsePolicies([]byte(cm.Data["policies"])); err != nil {
 			return nil, fmt.Errorf("failed to parse policies defined in the configmap %s: %v", c.PolicyConfigMapName, err)
 		}
 	}
-	if c.PolicyFile != "" {
-		policy, err = newFromFile(c.PolicyFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to extract policy from policy file %s: %v", c.PolicyFile, err)
-		}
-	}
 
 	if len(policy) > 0 {
 		output, err := json.MarshalIndent(policy, "", "  ")
@@ -456,12 +498,14 @@ func NewKeystoneAuth(c *Config) (*KeystoneAuth, error) {
 	}
 
 	keystoneAuth := &KeystoneAuth{
-		authn:     &Authenticator{authURL: c.KeystoneURL, client: keystoneClient},
+		authn:     newAuthenticator(c.KeystoneURL, keystoneClient, clockSkew, c.TokenCacheSize, c.TokenCacheTTL, c.MaxConcurrentValidations),
 		authz:     &Authorizer{authURL: c.KeystoneURL, client: keystoneClient, pl: policy},
 		syncer:    &Syncer{k8sClient: k8sClient, syncConfig: sc},
 		k8sClient: k8sClient,
 		config:    c,
 		stopCh:    make(chan struct{}),
+
+		policyFileData: policyFileData,
 	}
 
 	if k8sClient != nil {
@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog"
+)
+
+const (
+	keystoneAuthSubsystem = "keystone_auth"
+
+	tokenCacheRequestsKey      = "token_cache_requests_total"
+	tokenCacheResultHit        = "hit"
+	tokenCacheResultMiss       = "miss"
+	tokenValidationDurationKey = "token_validation_duration_seconds"
+	tokenValidationSuccess     = "success"
+	tokenValidationFailure     = "failure"
+	keystoneClockSkewKey       = "clock_skew_seconds"
+
+	// metricsPath is where the metrics are served with --metrics-address
+	metricsPath = "/metrics"
+)
+
+var (
+	// tokenCacheRequests is only recorded when the token cache is enabled
+	tokenCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: keystoneAuthSubsystem,
+			Name:      tokenCacheRequestsKey,
+			Help:      "Number of token cache lookups, by hit or miss",
+		},
+		[]string{"result"},
+	)
+	// tokenValidationDuration is the latency of the Keystone token
+	// validation calls, without the time waiting for a free slot of
+	// --max-concurrent-token-validations
+	tokenValidationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: keystoneAuthSubsystem,
+			Name:      tokenValidationDurationKey,
+			Help:      "Latency of Keystone token validation calls",
+		},
+		[]string{"result"},
+	)
+	keystoneClockSkew = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: keystoneAuthSubsystem,
+			Name:      keystoneClockSkewKey,
+			Help:      "Difference between the local clock and the Keystone clock, positive when the local clock is ahead",
+		},
+	)
+
+	registerMetricsOnce sync.Once
+)
+
+// registerMetrics registers the webhook metrics with the default prometheus
+// registry. It is safe to call more than once.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		if err := prometheus.Register(tokenCacheRequests); err != nil {
+			klog.V(5).Infof("unable to register for token cache metrics")
+		}
+		if err := prometheus.Register(tokenValidationDuration); err != nil {
+			klog.V(5).Infof("unable to register for token validation metrics")
+		}
+		if err := prometheus.Register(keystoneClockSkew); err != nil {
+			klog.V(5).Infof("unable to register for clock skew metrics")
+		}
+	})
+}
+
+// serveMetrics serves the metrics of the default prometheus registry on
+// address in the background.
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.Handler())
+
+	go func() {
+		klog.Infof("Serving the metrics on %s%s", address, metricsPath)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			klog.Errorf("Failed to serve the metrics on %s: %v", address, err)
+		}
+	}()
+}
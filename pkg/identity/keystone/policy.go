@@ -17,9 +17,10 @@ limitations under the License.
 package keystone
 
 import (
-	"bufio"
-	"encoding/json"
-	"os"
+	"bytes"
+	"fmt"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 )
 
 type policy struct {
@@ -36,6 +37,7 @@ const (
 	TypeGroup   string = "group"
 	TypeProject string = "project"
 	TypeRole    string = "role"
+	TypeDomain  string = "domain"
 )
 
 type policyMatch struct {
@@ -75,21 +77,29 @@ type nonResourcePolicySpec struct {
 
 type policyList []*policy
 
-// newFromFile loads a list of policies from a file
-func newFromFile(path string) (policyList, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// parsePolicies parses a list of policies in JSON or YAML, and checks that
+// their resource and nonresource sections can be evaluated.
+func parsePolicies(data []byte) (policyList, error) {
+	var pl policyList
+	if len(bytes.TrimSpace(data)) == 0 {
+		return pl, nil
 	}
-	defer file.Close()
-
-	var data policyList
-
-	reader := bufio.NewReader(file)
-	decoder := json.NewDecoder(reader)
-	err = decoder.Decode(&data)
-	if err != nil {
+	if err := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), len(data)).Decode(&pl); err != nil {
 		return nil, err
 	}
-	return data, nil
+	for i, p := range pl {
+		if p == nil {
+			return nil, fmt.Errorf("policy %d is empty", i)
+		}
+		if p.ResourceSpec != nil && p.NonResourceSpec != nil {
+			return nil, fmt.Errorf("policy %d has both resource and nonresource sections", i)
+		}
+		if p.ResourceSpec != nil && (p.ResourceSpec.APIGroup == nil || p.ResourceSpec.Namespace == nil) {
+			return nil, fmt.Errorf("policy %d: version and namespace of the resource section should be set", i)
+		}
+		if p.NonResourceSpec != nil && p.NonResourceSpec.NonResourcePath == nil {
+			return nil, fmt.Errorf("policy %d: path of the nonresource section should be set", i)
+		}
+	}
+	return pl, nil
 }
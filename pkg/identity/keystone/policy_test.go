@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+const domainPolicy = `
+- resource:
+    verbs: ["get", "list"]
+    resources: ["pods"]
+    version: "*"
+    namespace: "default"
+  match:
+  - type: domain
+    values: ["domain1"]
+`
+
+func TestParsePoliciesYAML(t *testing.T) {
+	policy, err := parsePolicies([]byte(domainPolicy))
+	th.AssertNoErr(t, err)
+	a := &Authorizer{pl: policy}
+
+	user1 := &user.DefaultInfo{
+		Name: "user1",
+		Extra: map[string][]string{
+			"alpha.kubernetes.io/identity/user/domain/id":   {"a8c3f2"},
+			"alpha.kubernetes.io/identity/user/domain/name": {"domain1"},
+		},
+	}
+	user2 := &user.DefaultInfo{
+		Name: "user2",
+		Extra: map[string][]string{
+			"alpha.kubernetes.io/identity/user/domain/id":   {"default"},
+			"alpha.kubernetes.io/identity/user/domain/name": {"Default"},
+		},
+	}
+
+	attrs := authorizer.AttributesRecord{User: user1, ResourceRequest: true, Verb: "get", Resource: "pods", Namespace: "default"}
+	decision, _, _ := a.Authorize(attrs)
+	th.AssertEquals(t, authorizer.DecisionAllow, decision)
+
+	attrs = authorizer.AttributesRecord{User: user1, ResourceRequest: true, Verb: "delete", Resource: "pods", Namespace: "default"}
+	decision, _, _ = a.Authorize(attrs)
+	th.AssertEquals(t, authorizer.DecisionDeny, decision)
+
+	attrs = authorizer.AttributesRecord{User: user2, ResourceRequest: true, Verb: "get", Resource: "pods", Namespace: "default"}
+	decision, _, _ = a.Authorize(attrs)
+	th.AssertEquals(t, authorizer.DecisionDeny, decision)
+
+	// The JSON policies of the example file are still supported
+	path, err := os.Getwd()
+	th.AssertNoErr(t, err)
+	data, err := ioutil.ReadFile(path + "/authorizer_test_policy.json")
+	th.AssertNoErr(t, err)
+	_, err = parsePolicies(data)
+	th.AssertNoErr(t, err)
+
+	// No policy at all
+	policy, err = parsePolicies(nil)
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, 0, len(policy))
+}
+
+func TestParsePoliciesInvalid(t *testing.T) {
+	invalid := map[string]string{
+		"syntax":            `[{"resource": {`,
+		"empty policy":      `[null]`,
+		"both sections":     `[{"resource": {"verbs": ["get"], "resources": ["pods"], "version": "*", "namespace": "*"}, "nonresource": {"verbs": ["get"], "path": "*"}, "match": []}]`,
+		"no namespace":      `[{"resource": {"verbs": ["get"], "resources": ["pods"], "version": "*"}, "match": []}]`,
+		"no version":        `[{"resource": {"verbs": ["get"], "resources": ["pods"], "namespace": "*"}, "match": []}]`,
+		"no path":           `[{"nonresource": {"verbs": ["get"]}, "match": []}]`,
+		"not a policy list": `{"resource": {"verbs": ["get"]}}`,
+	}
+	for name, data := range invalid {
+		if _, err := parsePolicies([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error parsing %s", name, data)
+		}
+	}
+}
+
+func TestReloadPolicyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystone-policy")
+	th.AssertNoErr(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.yaml")
+
+	th.AssertNoErr(t, ioutil.WriteFile(path, []byte(domainPolicy), 0644))
+	k := &KeystoneAuth{authz: &Authorizer{}, config: &Config{PolicyFile: path}}
+	k.reloadPolicyFile()
+	th.AssertEquals(t, 1, len(k.authz.pl))
+
+	// An invalid policy file keeps the current policy
+	th.AssertNoErr(t, ioutil.WriteFile(path, []byte(`[null]`), 0644))
+	k.reloadPolicyFile()
+	th.AssertEquals(t, 1, len(k.authz.pl))
+
+	th.AssertNoErr(t, ioutil.WriteFile(path, []byte(`[]`), 0644))
+	k.reloadPolicyFile()
+	th.AssertEquals(t, 0, len(k.authz.pl))
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// TokenCache keeps the Keystone tokens of client-keystone-auth on disk, so
+// that the users don't authenticate again for every kubectl command. The
+// tokens are only readable by the user.
+type TokenCache struct {
+	// Dir is the directory of the cached tokens
+	Dir string
+	// RefreshBefore is how long before its expiry a cached token is no
+	// longer used, so that a new one is requested in time
+	RefreshBefore time.Duration
+}
+
+type cachedToken struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TokenCacheKey returns the key of the tokens of the user, project and
+// application credential of opts in a TokenCache. The secrets of opts are
+// not part of it.
+func TokenCacheKey(opts gophercloud.AuthOptions) string {
+	identity := strings.Join([]string{
+		opts.IdentityEndpoint,
+		opts.DomainID, opts.DomainName,
+		opts.UserID, opts.Username,
+		opts.TenantID, opts.TenantName,
+		opts.ApplicationCredentialID, opts.ApplicationCredentialName,
+	}, "\n")
+	sum := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *TokenCache) path(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+// Get returns the cached token of key and its expiry, in local time, unless
+// the token expires within RefreshBefore.
+func (c *TokenCache) Get(key string) (string, time.Time, bool) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	var token cachedToken
+	if err := json.Unmarshal(data, &token); err != nil || token.ID == "" {
+		return "", time.Time{}, false
+	}
+	if time.Until(token.ExpiresAt) <= c.RefreshBefore {
+		return "", time.Time{}, false
+	}
+	return token.ID, token.ExpiresAt, true
+}
+
+// Set caches the token id of key expiring at expiresAt, in local time.
+func (c *TokenCache) Set(key, id string, expiresAt time.Time) error {
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(cachedToken{ID: id, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+
+	// Replace the cached token at once, kubectl may run several plugins
+	tmp, err := ioutil.TempFile(c.Dir, key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(key))
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	th "github.com/gophercloud/gophercloud/testhelper"
+)
+
+func TestTokenCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "client-keystone-auth")
+	th.AssertNoErr(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &TokenCache{Dir: dir + "/cache", RefreshBefore: 5 * time.Minute}
+	key := TokenCacheKey(gophercloud.AuthOptions{IdentityEndpoint: "https://keystone", Username: "user1", DomainName: "default"})
+
+	_, _, ok := c.Get(key)
+	th.AssertEquals(t, false, ok)
+
+	expiresAt := time.Now().Add(time.Hour).Round(time.Second)
+	th.AssertNoErr(t, c.Set(key, "token1", expiresAt))
+	id, gotExpiresAt, ok := c.Get(key)
+	th.AssertEquals(t, true, ok)
+	th.AssertEquals(t, "token1", id)
+	th.AssertEquals(t, true, gotExpiresAt.Equal(expiresAt))
+
+	info, err := os.Stat(c.path(key))
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, os.FileMode(0600), info.Mode().Perm())
+
+	// Other users don't share the token
+	otherKey := TokenCacheKey(gophercloud.AuthOptions{IdentityEndpoint: "https://keystone", Username: "user2", DomainName: "default"})
+	_, _, ok = c.Get(otherKey)
+	th.AssertEquals(t, false, ok)
+
+	// Tokens about to expire are refreshed
+	th.AssertNoErr(t, c.Set(key, "token2", time.Now().Add(time.Minute)))
+	_, _, ok = c.Get(key)
+	th.AssertEquals(t, false, ok)
+}
@@ -24,12 +24,53 @@ import (
 	tokens3 "github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
 	"io/ioutil"
 	"net/http"
+
+	"k8s.io/cloud-provider-openstack/pkg/util/skew"
 )
 
 type Options struct {
 	AuthOptions    gophercloud.AuthOptions
 	ClientCertPath string
 	ClientKeyPath  string
+	// ClockSkew, if set, records the clock skew to Keystone so that the
+	// token expiry can be converted to local time.
+	ClockSkew *skew.Tracker
+	// Passcode, if set, is the TOTP passcode the user authenticates with
+	// along with the password.
+	Passcode string
+}
+
+// totpAuthOptions authenticates with both the password and a TOTP passcode,
+// as Keystone requires for the users with multi-factor authentication rules.
+type totpAuthOptions struct {
+	gophercloud.AuthOptions
+	passcode string
+}
+
+func (opts *totpAuthOptions) ToTokenV3CreateMap(scope map[string]interface{}) (map[string]interface{}, error) {
+	b, err := opts.AuthOptions.ToTokenV3CreateMap(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, _ := b["auth"].(map[string]interface{})
+	identity, _ := auth["identity"].(map[string]interface{})
+	password, _ := identity["password"].(map[string]interface{})
+	passwordUser, _ := password["user"].(map[string]interface{})
+	if passwordUser == nil {
+		return nil, fmt.Errorf("a TOTP passcode can only be used with a user name or ID and a password")
+	}
+
+	// The TOTP method identifies the user the same way as the password one
+	user := map[string]interface{}{"passcode": opts.passcode}
+	for k, v := range passwordUser {
+		if k != "password" {
+			user[k] = v
+		}
+	}
+	identity["methods"] = []string{"password", "totp"}
+	identity["totp"] = map[string]interface{}{"user": user}
+	return b, nil
 }
 
 // GetToken creates a token by authenticate with keystone.
@@ -69,6 +110,10 @@ func GetToken(options Options) (*tokens3.Token, error) {
 		client.HTTPClient.Transport = transport
 	}
 
+	if options.ClockSkew != nil {
+		client.HTTPClient.Transport = options.ClockSkew.RoundTripper(client.HTTPClient.Transport)
+	}
+
 	v3Client, err := openstack.NewIdentityV3(client, gophercloud.EndpointOpts{})
 	if err != nil {
 		msg := fmt.Errorf("failed: Initializing openstack authentication client: %v", err)
@@ -76,7 +121,11 @@ func GetToken(options Options) (*tokens3.Token, error) {
 	}
 
 	// Issue new unscoped token
-	result := tokens3.Create(v3Client, &options.AuthOptions)
+	var authOptions tokens3.AuthOptionsBuilder = &options.AuthOptions
+	if options.Passcode != "" {
+		authOptions = &totpAuthOptions{AuthOptions: options.AuthOptions, passcode: options.Passcode}
+	}
+	result := tokens3.Create(v3Client, authOptions)
 	if result.Err != nil {
 		return token, result.Err
 	}
@@ -108,3 +108,50 @@ func TestTokenGetter(t *testing.T) {
 	token, err = GetToken(options)
 	th.AssertEquals(t, "You must provide a password to authenticate", err.Error())
 }
+
+func TestTokenGetterTOTP(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/v3/auth/tokens", func(w http.ResponseWriter, r *http.Request) {
+		th.TestJSONRequest(t, r, `{
+			"auth": {
+				"identity": {
+					"methods": ["password", "totp"],
+					"password": {
+						"user": {"domain": {"name": "default"}, "name": "testuser", "password": "testpw"}
+					},
+					"totp": {
+						"user": {"domain": {"name": "default"}, "name": "testuser", "passcode": "123456"}
+					}
+				}
+			}
+		}`)
+		w.Header().Add("X-Subject-Token", "0123456789")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": {"methods": ["password", "totp"], "expires_at": "2015-11-09T01:42:57.527363Z"}}`)
+	})
+
+	options := Options{
+		AuthOptions: gophercloud.AuthOptions{
+			IdentityEndpoint: th.Endpoint(),
+			Username:         "testuser",
+			Password:         "testpw",
+			DomainName:       "default",
+		},
+		Passcode: "123456",
+	}
+
+	token, err := GetToken(options)
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, "0123456789", token.ID)
+
+	// TOTP identifies the user of the password method
+	options.AuthOptions.Password = ""
+	options.AuthOptions.ApplicationCredentialID = "app-cred-id"
+	options.AuthOptions.ApplicationCredentialSecret = "secret"
+	_, err = GetToken(options)
+	if err == nil {
+		t.Errorf("expected an error authenticating with an application credential and a passcode")
+	}
+}
@@ -4,89 +4,64 @@ import (
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
 	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/secrets"
-	"k8s.io/klog"
+	openstack_provider "k8s.io/cloud-provider-openstack/pkg/cloudprovider/providers/openstack"
 )
 
 type BarbicanService interface {
-	GetSecret(cfg Config) ([]byte, error)
+	GetSecret(keyID string) ([]byte, error)
 }
 
 type KMSOpts struct {
 	KeyID string `gcfg:"key-id"`
+	// OldKeyIDs are the keys replaced by key-id, oldest first. They only
+	// decrypt the DEKs they encrypted.
+	OldKeyIDs []string `gcfg:"old-key-id"`
 }
 
 //Config to read config options
 type Config struct {
-	Global struct {
-		AuthURL    string `gcfg:"auth-url"`
-		Username   string
-		UserID     string `gcfg:"user-id"`
-		Password   string
-		TenantID   string `gcfg:"tenant-id"`
-		TenantName string `gcfg:"tenant-name"`
-		DomainID   string `gcfg:"domain-id"`
-		DomainName string `gcfg:"domain-name"`
-		Region     string
-	}
+	openstack_provider.Config
 	KeyManager KMSOpts
 }
 
 // Barbican is gophercloud service client
 type Barbican struct {
+	client *gophercloud.ServiceClient
 }
 
-func (cfg Config) toAuthOptions() gophercloud.AuthOptions {
-	return gophercloud.AuthOptions{
-		IdentityEndpoint: cfg.Global.AuthURL,
-		Username:         cfg.Global.Username,
-		UserID:           cfg.Global.UserID,
-		Password:         cfg.Global.Password,
-		TenantID:         cfg.Global.TenantID,
-		TenantName:       cfg.Global.TenantName,
-		DomainID:         cfg.Global.DomainID,
-		DomainName:       cfg.Global.DomainName,
-
-		// Persistent service, so we need to be able to renew tokens.
-		AllowReauth: true,
-	}
-}
-
-// NewBarbicanClient creates new BarbicanClient
-func newBarbicanClient(cfg Config) (client *gophercloud.ServiceClient, err error) {
-
-	provider, err := openstack.AuthenticatedClient(cfg.toAuthOptions())
+// NewBarbicanClient authenticates to Barbican with the [Global] section of cfg
+func NewBarbicanClient(cfg Config) (*Barbican, error) {
 
+	provider, err := openstack_provider.NewProviderClient(cfg.Config)
 	if err != nil {
 		return nil, err
 	}
 
-	client, err = openstack.NewKeyManagerV1(provider, gophercloud.EndpointOpts{
+	client, err := openstack.NewKeyManagerV1(provider, gophercloud.EndpointOpts{
 		Region: cfg.Global.Region,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return client, nil
+	return &Barbican{client: client}, nil
 }
 
-// GetSecret gets unencrypted secret
-func (barbican *Barbican) GetSecret(cfg Config) ([]byte, error) {
-
-	client, err := newBarbicanClient(cfg)
-
-	keyID := cfg.KeyManager.KeyID
+// Reauthenticate authenticates the client with the credentials of the
+// [Global] section of cfg, e.g. after the cloud config was rotated. The
+// requests in progress go on with a token of the new credentials.
+func (barbican *Barbican) Reauthenticate(cfg Config) error {
+	return openstack_provider.AuthenticateProvider(barbican.client.ProviderClient, cfg.Config)
+}
 
-	if err != nil {
-		klog.V(4).Infof("Failed to get Barbican client %v: ", err)
-		return nil, err
-	}
+// GetSecret gets unencrypted secret
+func (barbican *Barbican) GetSecret(keyID string) ([]byte, error) {
 
 	opts := secrets.GetPayloadOpts{
 		PayloadContentType: "application/octet-stream",
 	}
 
-	key, err := secrets.GetPayload(client, keyID, opts).Extract()
+	key, err := secrets.GetPayload(barbican.client, keyID, opts).Extract()
 	if err != nil {
 		return nil, err
 	}
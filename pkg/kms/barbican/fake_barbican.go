@@ -1,11 +1,23 @@
 package barbican
 
-import "encoding/hex"
+import (
+	"encoding/hex"
+	"fmt"
+)
 
+// FakeBarbican serves the keys of Keys, or the same key for any ID when Keys
+// is nil
 type FakeBarbican struct {
+	Keys map[string][]byte
 }
 
-func (client *FakeBarbican) GetSecret(cfg Config) ([]byte, error) {
-	return hex.DecodeString("6368616e676520746869732070617373")
-
+func (client *FakeBarbican) GetSecret(keyID string) ([]byte, error) {
+	if client.Keys == nil {
+		return hex.DecodeString("6368616e676520746869732070617373")
+	}
+	key, ok := client.Keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found", keyID)
+	}
+	return key, nil
 }
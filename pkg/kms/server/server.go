@@ -1,9 +1,12 @@
 package server
 
 import (
+	"bytes"
 	"fmt"
 	"net"
 	"os"
+	"sync"
+	"time"
 
 	"golang.org/x/net/context"
 	"golang.org/x/sys/unix"
@@ -12,6 +15,7 @@ import (
 	pb "k8s.io/apiserver/pkg/storage/value/encrypt/envelope/v1beta1"
 	"k8s.io/cloud-provider-openstack/pkg/kms/barbican"
 	"k8s.io/cloud-provider-openstack/pkg/kms/encryption/aescbc"
+	"k8s.io/cloud-provider-openstack/pkg/util/configwatch"
 	"k8s.io/klog"
 )
 
@@ -20,12 +24,21 @@ const (
 	version        = "v1beta1"
 	runtimename    = "barbican"
 	runtimeversion = "0.0.1"
+
+	// cipherPrefix starts the ciphers naming the key that encrypted them,
+	// barbican:<key-id>:<cipher>. The ciphers without it predate key
+	// rotation and were encrypted with the oldest key.
+	cipherPrefix = "barbican:"
 )
 
 // KMSserver struct
 type KMSserver struct {
 	cfg      barbican.Config
 	barbican barbican.BarbicanService
+
+	// keys caches the keys by ID, Barbican secrets never change
+	keysMutex sync.Mutex
+	keys      map[string][]byte
 }
 
 func initConfig(configFilePath string, cfg *barbican.Config) error {
@@ -42,17 +55,45 @@ func initConfig(configFilePath string, cfg *barbican.Config) error {
 	return nil
 }
 
-// Run Grpc server for barbican KMS
-func Run(configFilePath string, socketpath string, sigchan <-chan os.Signal) (err error) {
+// Run Grpc server for barbican KMS. The credentials of the config file are
+// reloaded when it changes, checking every configReloadInterval, 0 disables
+// it.
+func Run(configFilePath string, socketpath string, configReloadInterval time.Duration, sigchan <-chan os.Signal) (err error) {
 
 	klog.Infof("Barbican KMS Plugin Starting Version: %s, RunTimeVersion: %s", version, runtimeversion)
 	s := new(KMSserver)
 	err = initConfig(configFilePath, &s.cfg)
-	s.barbican = &barbican.Barbican{}
 	if err != nil {
 		klog.V(4).Infof("Error in Getting Config File: %v", err)
 		return err
 	}
+	if s.cfg.KeyManager.KeyID == "" {
+		return fmt.Errorf("key-id not set in [KeyManager]")
+	}
+
+	client, err := barbican.NewBarbicanClient(s.cfg)
+	if err != nil {
+		klog.V(4).Infof("Failed to get Barbican client: %v", err)
+		return err
+	}
+	s.barbican = client
+
+	if configReloadInterval > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		configwatch.Watch(configReloadInterval, stop, []string{configFilePath}, func() error {
+			// Only the credentials are reloaded, the keys are read at startup
+			var cfg barbican.Config
+			if err := initConfig(configFilePath, &cfg); err != nil {
+				return err
+			}
+			if err := client.Reauthenticate(cfg); err != nil {
+				return err
+			}
+			klog.Infof("Reloaded the OpenStack credentials of %s", configFilePath)
+			return nil
+		})
+	}
 
 	// unlink the unix socket
 	if err = unix.Unlink(socketpath); err != nil {
@@ -99,13 +140,18 @@ func (s *KMSserver) Decrypt(ctx context.Context, req *pb.DecryptRequest) (*pb.De
 
 	klog.V(4).Infof("Decrypt Request by Kubernetes api server")
 
-	key, err := s.barbican.GetSecret(s.cfg)
+	keyID, cipher := s.splitCipher(req.Cipher)
+	if !s.isKnownKey(keyID) {
+		return nil, fmt.Errorf("cipher encrypted with key %s, not in [KeyManager]", keyID)
+	}
+
+	key, err := s.getKey(keyID)
 	if err != nil {
 		klog.V(4).Infof("Failed to get key %v: ", err)
 		return nil, err
 	}
 
-	plain, err := aescbc.Decrypt(req.Cipher, key)
+	plain, err := aescbc.Decrypt(cipher, key)
 	if err != nil {
 		klog.V(4).Infof("Failed to decrypt data %v: ", err)
 		return nil, err
@@ -119,7 +165,8 @@ func (s *KMSserver) Encrypt(ctx context.Context, req *pb.EncryptRequest) (*pb.En
 
 	klog.V(4).Infof("Encrypt Request by Kubernetes api server")
 
-	key, err := s.barbican.GetSecret(s.cfg)
+	keyID := s.cfg.KeyManager.KeyID
+	key, err := s.getKey(keyID)
 
 	if err != nil {
 		klog.V(4).Infof("Failed to get key %v: ", err)
@@ -132,5 +179,53 @@ func (s *KMSserver) Encrypt(ctx context.Context, req *pb.EncryptRequest) (*pb.En
 		klog.V(4).Infof("Failed to encrypt data %v: ", err)
 		return nil, err
 	}
+	cipher = append([]byte(cipherPrefix+keyID+":"), cipher...)
 	return &pb.EncryptResponse{Cipher: cipher}, nil
 }
+
+// getKey gets the key keyID from Barbican the first time it is used
+func (s *KMSserver) getKey(keyID string) ([]byte, error) {
+	s.keysMutex.Lock()
+	defer s.keysMutex.Unlock()
+
+	if key, ok := s.keys[keyID]; ok {
+		return key, nil
+	}
+	key, err := s.barbican.GetSecret(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if s.keys == nil {
+		s.keys = make(map[string][]byte)
+	}
+	s.keys[keyID] = key
+	return key, nil
+}
+
+// splitCipher returns the ID of the key that encrypted data, and the cipher
+// to decrypt with it.
+func (s *KMSserver) splitCipher(data []byte) (string, []byte) {
+	if bytes.HasPrefix(data, []byte(cipherPrefix)) {
+		rest := data[len(cipherPrefix):]
+		if i := bytes.IndexByte(rest, ':'); i >= 0 {
+			return string(rest[:i]), rest[i+1:]
+		}
+	}
+	if len(s.cfg.KeyManager.OldKeyIDs) > 0 {
+		return s.cfg.KeyManager.OldKeyIDs[0], data
+	}
+	return s.cfg.KeyManager.KeyID, data
+}
+
+// isKnownKey returns whether keyID is the key or one of the old keys
+func (s *KMSserver) isKnownKey(keyID string) bool {
+	if keyID == s.cfg.KeyManager.KeyID {
+		return true
+	}
+	for _, id := range s.cfg.KeyManager.OldKeyIDs {
+		if keyID == id {
+			return true
+		}
+	}
+	return false
+}
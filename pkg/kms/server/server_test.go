@@ -7,6 +7,7 @@ import (
 	"golang.org/x/net/context"
 	pb "k8s.io/apiserver/pkg/storage/value/encrypt/envelope/v1beta1"
 	"k8s.io/cloud-provider-openstack/pkg/kms/barbican"
+	"k8s.io/cloud-provider-openstack/pkg/kms/encryption/aescbc"
 )
 
 var s = new(KMSserver)
@@ -40,3 +41,49 @@ func TestEncryptDecrypt(t *testing.T) {
 	}
 
 }
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := []byte("0123456789abcdef0123456789abcdef")
+	s := &KMSserver{barbican: &barbican.FakeBarbican{Keys: map[string][]byte{
+		"old": oldKey,
+		"new": []byte("fedcba9876543210fedcba9876543210"),
+	}}}
+	s.cfg.KeyManager.KeyID = "old"
+	fakeData := []byte("fakedata")
+
+	encresp, err := s.Encrypt(context.TODO(), &pb.EncryptRequest{Version: "v1beta1", Plain: fakeData})
+	if err != nil {
+		t.Fatalf("Failed to encrypt with the old key: %v", err)
+	}
+	if !bytes.HasPrefix(encresp.Cipher, []byte("barbican:old:")) {
+		t.Errorf("Cipher %q does not name the old key", encresp.Cipher)
+	}
+	// Encrypted before the ciphers named their key
+	legacy, err := aescbc.Encrypt(fakeData, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.cfg.KeyManager.KeyID = "new"
+	s.cfg.KeyManager.OldKeyIDs = []string{"old"}
+	for _, cipher := range [][]byte{encresp.Cipher, legacy} {
+		decresp, err := s.Decrypt(context.TODO(), &pb.DecryptRequest{Version: "v1beta1", Cipher: cipher})
+		if err != nil || !bytes.Equal(decresp.Plain, fakeData) {
+			t.Errorf("Failed to decrypt %q after the rotation: %v", cipher, err)
+		}
+	}
+
+	newresp, err := s.Encrypt(context.TODO(), &pb.EncryptRequest{Version: "v1beta1", Plain: fakeData})
+	if err != nil {
+		t.Fatalf("Failed to encrypt with the new key: %v", err)
+	}
+	if !bytes.HasPrefix(newresp.Cipher, []byte("barbican:new:")) {
+		t.Errorf("Cipher %q does not name the new key", newresp.Cipher)
+	}
+
+	// The old key is dropped once everything is encrypted with the new one
+	s.cfg.KeyManager.OldKeyIDs = nil
+	if _, err := s.Decrypt(context.TODO(), &pb.DecryptRequest{Version: "v1beta1", Cipher: encresp.Cipher}); err == nil {
+		t.Errorf("Decrypted a cipher of a dropped key")
+	}
+}
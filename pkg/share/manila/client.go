@@ -122,6 +122,9 @@ func authenticateClient(o *shareoptions.OpenStackOptions) (*gophercloud.Provider
 		if o.OSTrustID != "" {
 			return nil, fmt.Errorf("Keystone %s does not support trustee authentication", v2)
 		}
+		if o.OSApplicationCredentialSecret != "" {
+			return nil, fmt.Errorf("Keystone %s does not support application credentials", v2)
+		}
 
 		err = openstack.AuthenticateV2(provider, *o.ToAuthOptions(), gophercloud.EndpointOpts{})
 	case v3:
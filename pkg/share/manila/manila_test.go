@@ -125,10 +125,10 @@ func TestChooseExportLocation(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		if got, err := chooseExportLocation(tt.locs); err != nil {
-			t.Errorf("%q chooseExportLocation(%v) = (%v, %q) want (%v, nil)", tt.testCaseName, tt.locs, got, err.Error(), tt.want)
+		if got, err := ChooseExportLocation(tt.locs); err != nil {
+			t.Errorf("%q ChooseExportLocation(%v) = (%v, %q) want (%v, nil)", tt.testCaseName, tt.locs, got, err.Error(), tt.want)
 		} else if !reflect.DeepEqual(tt.want, got) {
-			t.Errorf("%q chooseExportLocation(%v) = (%v, nil) want (%v, nil)", tt.testCaseName, tt.locs, got, tt.want)
+			t.Errorf("%q ChooseExportLocation(%v) = (%v, nil) want (%v, nil)", tt.testCaseName, tt.locs, got, tt.want)
 		}
 	}
 }
@@ -114,7 +114,7 @@ func (p *Provisioner) Provision(volOptions controller.VolumeOptions) (*v1.Persis
 		return nil, fmt.Errorf("failed to get export locations for share %s: %v", share.ID, err)
 	}
 
-	chosenExportLocation, err := chooseExportLocation(availableExportLocations)
+	chosenExportLocation, err := ChooseExportLocation(availableExportLocations)
 	if err != nil {
 		return nil, fmt.Errorf("failed to choose an export location for share %s: %v", share.ID, err)
 	}
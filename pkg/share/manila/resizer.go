@@ -0,0 +1,308 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-openstack/pkg/share/manila/shareoptions"
+	"k8s.io/klog"
+)
+
+const (
+	// annStorageProvisioner is the annotation of the claims naming the
+	// provisioner of their volume
+	annStorageProvisioner = "volume.beta.kubernetes.io/storage-provisioner"
+
+	shareExtendingErrorStatus = "extending_error"
+)
+
+// errShareBusy is returned when a share cannot be resized until Manila is
+// done with another operation on it
+var errShareBusy = errors.New("share is busy")
+
+// Resizer extends the shares of the claims requesting more storage than
+// their volume has, and updates the capacity of the volume and claim once
+// the share is extended. Kubernetes only resizes claims of StorageClasses
+// with allowVolumeExpansion, and never shrinks them.
+type Resizer struct {
+	clientset       clientset.Interface
+	provisionerName string
+
+	queue     workqueue.RateLimitingInterface
+	pvcLister corelisters.PersistentVolumeClaimLister
+	pvLister  corelisters.PersistentVolumeLister
+	synced    []cache.InformerSynced
+	recorder  record.EventRecorder
+
+	// newClient authenticates to Manila, replaced in tests
+	newClient func(*shareoptions.OpenStackOptions) (*gophercloud.ServiceClient, error)
+}
+
+// NewResizer creates a Resizer of the claims provisioned by provisionerName.
+func NewResizer(c clientset.Interface, provisionerName string, factory informers.SharedInformerFactory) *Resizer {
+	pvcInformer := factory.Core().V1().PersistentVolumeClaims()
+	pvInformer := factory.Core().V1().PersistentVolumes()
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(klog.Infof)
+	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: c.CoreV1().Events("")})
+
+	r := &Resizer{
+		clientset:       c,
+		provisionerName: provisionerName,
+		queue:           workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "manila-resizer"),
+		pvcLister:       pvcInformer.Lister(),
+		pvLister:        pvInformer.Lister(),
+		synced:          []cache.InformerSynced{pvcInformer.Informer().HasSynced, pvInformer.Informer().HasSynced},
+		recorder:        eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: provisionerName}),
+		newClient:       NewManilaV2Client,
+	}
+
+	pvcInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    r.enqueue,
+		UpdateFunc: func(old, new interface{}) { r.enqueue(new) },
+	})
+
+	return r
+}
+
+func (r *Resizer) enqueue(obj interface{}) {
+	pvc, ok := obj.(*v1.PersistentVolumeClaim)
+	if !ok || !r.needsResize(pvc) {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(pvc)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	r.queue.Add(key)
+}
+
+// needsResize returns whether pvc is a bound claim of the provisioner
+// requesting more storage than its volume has.
+func (r *Resizer) needsResize(pvc *v1.PersistentVolumeClaim) bool {
+	if pvc.Status.Phase != v1.ClaimBound || pvc.Annotations[annStorageProvisioner] != r.provisionerName {
+		return false
+	}
+	requested := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	capacity := pvc.Status.Capacity[v1.ResourceStorage]
+	return requested.Cmp(capacity) > 0
+}
+
+// Run resizes the claims with workers goroutines until stopCh is closed.
+func (r *Resizer) Run(workers int, stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	defer r.queue.ShutDown()
+
+	klog.Infof("Starting the Manila share resizer")
+	if !cache.WaitForCacheSync(stopCh, r.synced...) {
+		utilruntime.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
+		return
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.Until(r.runWorker, time.Second, stopCh)
+	}
+	<-stopCh
+}
+
+func (r *Resizer) runWorker() {
+	for r.processNextItem() {
+		// continue looping
+	}
+}
+
+func (r *Resizer) processNextItem() bool {
+	key, quit := r.queue.Get()
+	if quit {
+		return false
+	}
+	defer r.queue.Done(key)
+
+	if err := r.sync(key.(string)); err != nil {
+		// Busy shares are resized once Manila is done with them
+		klog.Errorf("Failed to resize the share of claim %s (will retry): %v", key, err)
+		r.queue.AddRateLimited(key)
+		return true
+	}
+	r.queue.Forget(key)
+	return true
+}
+
+// sync resizes the share of the claim key if it still needs to be.
+func (r *Resizer) sync(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	pvc, err := r.pvcLister.PersistentVolumeClaims(namespace).Get(name)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !r.needsResize(pvc) {
+		return nil
+	}
+	pv, err := r.pvLister.Get(pvc.Spec.VolumeName)
+	if err != nil {
+		return err
+	}
+
+	shareID, err := getShareIDfromPV(pv)
+	if err != nil {
+		// Not provisioned by the provisioner
+		klog.V(4).Infof("Not resizing claim %s: %v", key, err)
+		return nil
+	}
+	newSize, err := getStorageSizeInGiga(pvc)
+	if err != nil {
+		return err
+	}
+
+	osSecretRef, err := getOSSecretRefFromPV(pv)
+	if err != nil {
+		return fmt.Errorf("failed to get OpenStack secret reference from PV for share %s: %v", shareID, err)
+	}
+	osOptions, err := shareoptions.NewOpenStackOptionsFromSecret(r.clientset, osSecretRef)
+	if err != nil {
+		return fmt.Errorf("failed to create OpenStack options for share %s: %v", shareID, err)
+	}
+	client, err := r.newClient(osOptions)
+	if err != nil {
+		return fmt.Errorf("failed to create Manila v2 client for share %s: %v", shareID, err)
+	}
+
+	share, err := extendShare(client, shareID, newSize)
+	if err != nil {
+		if err == errShareBusy {
+			r.recorder.Eventf(pvc, v1.EventTypeNormal, "ShareBusy", "Waiting for share %s to be available to resize it", shareID)
+		} else {
+			r.recorder.Eventf(pvc, v1.EventTypeWarning, "ResizeFailed", "Failed to resize share %s to %dG: %v", shareID, newSize, err)
+		}
+		return err
+	}
+
+	capacity := resource.MustParse(fmt.Sprintf("%dG", share.Size))
+	if err := r.updateCapacity(pvc, pv, capacity); err != nil {
+		return err
+	}
+	r.recorder.Eventf(pvc, v1.EventTypeNormal, "Resized", "Share %s resized to %dG", shareID, share.Size)
+	klog.Infof("successfully resized share %s to %dG", shareID, share.Size)
+	return nil
+}
+
+// updateCapacity sets the capacity of the volume and claim to capacity.
+func (r *Resizer) updateCapacity(pvc *v1.PersistentVolumeClaim, pv *v1.PersistentVolume, capacity resource.Quantity) error {
+	if current := pv.Spec.Capacity[v1.ResourceStorage]; current.Cmp(capacity) != 0 {
+		pv = pv.DeepCopy()
+		pv.Spec.Capacity[v1.ResourceStorage] = capacity
+		if _, err := r.clientset.CoreV1().PersistentVolumes().Update(pv); err != nil {
+			return fmt.Errorf("failed to update the capacity of volume %s: %v", pv.Name, err)
+		}
+	}
+
+	pvc = pvc.DeepCopy()
+	if pvc.Status.Capacity == nil {
+		pvc.Status.Capacity = v1.ResourceList{}
+	}
+	pvc.Status.Capacity[v1.ResourceStorage] = capacity
+	var conditions []v1.PersistentVolumeClaimCondition
+	for _, c := range pvc.Status.Conditions {
+		if c.Type != v1.PersistentVolumeClaimResizing && c.Type != v1.PersistentVolumeClaimFileSystemResizePending {
+			conditions = append(conditions, c)
+		}
+	}
+	pvc.Status.Conditions = conditions
+	if _, err := r.clientset.CoreV1().PersistentVolumeClaims(pvc.Namespace).UpdateStatus(pvc); err != nil {
+		return fmt.Errorf("failed to update the capacity of claim %s/%s: %v", pvc.Namespace, pvc.Name, err)
+	}
+	return nil
+}
+
+// extendShare extends the share shareID to newSize GB, online, and waits for
+// it to be available again. A share already as large is left as it is. It
+// returns errShareBusy when Manila is doing something else with the share.
+func extendShare(client *gophercloud.ServiceClient, shareID string, newSize int) (*shares.Share, error) {
+	share, err := shares.Get(client, shareID).Extract()
+	if err != nil {
+		return nil, err
+	}
+	if share.Size >= newSize {
+		return share, nil
+	}
+	if share.Status != "available" {
+		return nil, errShareBusy
+	}
+
+	body := map[string]interface{}{"extend": map[string]interface{}{"new_size": newSize}}
+	_, err = client.Post(client.ServiceURL("shares", shareID, "action"), body, nil, &gophercloud.RequestOpts{OkCodes: []int{202}})
+	if err != nil {
+		if isShareBusy(err) {
+			return nil, errShareBusy
+		}
+		return nil, fmt.Errorf("failed to extend share %s to %dG: %v", shareID, newSize, err)
+	}
+
+	err = gophercloud.WaitFor(shareAvailabilityTimeout, func() (bool, error) {
+		share, err = shares.Get(client, shareID).Extract()
+		if err != nil {
+			return false, err
+		}
+		if share.Status == shareExtendingErrorStatus {
+			return false, fmt.Errorf("share %s failed to be extended", shareID)
+		}
+		return share.Status == "available", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// isShareBusy returns whether Manila refused a share action because of the
+// current status of the share.
+func isShareBusy(err error) bool {
+	switch e := err.(type) {
+	case gophercloud.ErrDefault409:
+		return true
+	case gophercloud.ErrDefault400:
+		return strings.Contains(string(e.Body), "status must be")
+	}
+	return false
+}
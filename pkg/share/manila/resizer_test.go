@@ -0,0 +1,201 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/cloud-provider-openstack/pkg/share/manila/shareoptions"
+)
+
+const testShareID = "011d21e2-fbc3-4e4a-9993-9ea223f73264"
+
+// fakeShareActions serves a share of size GB in status, extended to the
+// size of the extend actions.
+type fakeShareActions struct {
+	size    int
+	status  string
+	extends int
+	// actionStatus and actionBody are the response to the actions when
+	// actionBody is not ""
+	actionStatus int
+	actionBody   string
+}
+
+func (f *fakeShareActions) handle(t *testing.T) {
+	th.Mux.HandleFunc("/shares/"+testShareID, func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "GET")
+		w.Header().Add("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"share": {"id": "%s", "size": %d, "status": "%s"}}`, testShareID, f.size, f.status)
+	})
+	th.Mux.HandleFunc("/shares/"+testShareID+"/action", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, "POST")
+		if f.actionBody != "" {
+			w.WriteHeader(f.actionStatus)
+			fmt.Fprint(w, f.actionBody)
+			return
+		}
+		th.TestJSONRequest(t, r, `{"extend": {"new_size": 3}}`)
+		f.extends++
+		f.size = 3
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+func TestExtendShare(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	f := &fakeShareActions{size: 1, status: "available"}
+	f.handle(t)
+
+	share, err := extendShare(fakeclient.ServiceClient(), testShareID, 3)
+	if err != nil {
+		t.Fatalf("failed to extend share: %v", err)
+	}
+	if share.Size != 3 || f.extends != 1 {
+		t.Errorf("share extended %d times to %dG, want once to 3G", f.extends, share.Size)
+	}
+
+	// Already extended
+	if _, err := extendShare(fakeclient.ServiceClient(), testShareID, 2); err != nil || f.extends != 1 {
+		t.Errorf("share extended again: %v", err)
+	}
+}
+
+func TestExtendShareBusy(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	f := &fakeShareActions{size: 1, status: "extending"}
+	f.handle(t)
+
+	if _, err := extendShare(fakeclient.ServiceClient(), testShareID, 3); err != errShareBusy {
+		t.Errorf("extendShare of an extending share = %v, want %v", err, errShareBusy)
+	}
+
+	// Manila refuses to extend the share when its status changed since
+	f.status = "available"
+	f.actionStatus = http.StatusBadRequest
+	f.actionBody = `{"badRequest": {"message": "Invalid share: Share status must be available.", "code": 400}}`
+	if _, err := extendShare(fakeclient.ServiceClient(), testShareID, 3); err != errShareBusy {
+		t.Errorf("extendShare refused by Manila = %v, want %v", err, errShareBusy)
+	}
+
+	f.actionBody = `{"forbidden": {"message": "Quota exceeded", "code": 403}}`
+	f.actionStatus = http.StatusForbidden
+	if _, err := extendShare(fakeclient.ServiceClient(), testShareID, 3); err == nil || err == errShareBusy {
+		t.Errorf("extendShare over quota = %v, want an error", err)
+	}
+}
+
+func TestResizerSync(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	f := &fakeShareActions{size: 1, status: "available"}
+	f.handle(t)
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "manila-credentials", Namespace: "default"},
+		Data: map[string][]byte{
+			"os-authURL":     []byte("https://keystone.example.com/v3"),
+			"os-region":      []byte("RegionOne"),
+			"os-userName":    []byte("demo"),
+			"os-password":    []byte("secret"),
+			"os-projectName": []byte("demo"),
+			"os-domainName":  []byte("Default"),
+		},
+	}
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pvc-1",
+			Annotations: map[string]string{
+				manilaAnnotationID:                testShareID,
+				manilaAnnotationOSSecretName:      "manila-credentials",
+				manilaAnnotationOSSecretNamespace: "default",
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1G")},
+		},
+	}
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "data",
+			Namespace:   "default",
+			Annotations: map[string]string{annStorageProvisioner: "manila-provisioner"},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			VolumeName: "pvc-1",
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("3G")},
+			},
+		},
+		Status: v1.PersistentVolumeClaimStatus{
+			Phase:      v1.ClaimBound,
+			Capacity:   v1.ResourceList{v1.ResourceStorage: resource.MustParse("1G")},
+			Conditions: []v1.PersistentVolumeClaimCondition{{Type: v1.PersistentVolumeClaimResizing}},
+		},
+	}
+
+	c := fakeclientset.NewSimpleClientset(secret, pv, pvc)
+	factory := informers.NewSharedInformerFactory(c, 0)
+	r := NewResizer(c, "manila-provisioner", factory)
+	r.newClient = func(*shareoptions.OpenStackOptions) (*gophercloud.ServiceClient, error) {
+		return fakeclient.ServiceClient(), nil
+	}
+	factory.Core().V1().PersistentVolumes().Informer().GetIndexer().Add(pv)
+	factory.Core().V1().PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+
+	if err := r.sync("default/data"); err != nil {
+		t.Fatalf("failed to resize claim: %v", err)
+	}
+	if f.extends != 1 {
+		t.Errorf("share extended %d times, want once", f.extends)
+	}
+
+	want := resource.MustParse("3G")
+	gotPV, _ := c.CoreV1().PersistentVolumes().Get("pvc-1", metav1.GetOptions{})
+	if got := gotPV.Spec.Capacity[v1.ResourceStorage]; got.Cmp(want) != 0 {
+		t.Errorf("volume capacity = %s, want %s", got.String(), want.String())
+	}
+	gotPVC, _ := c.CoreV1().PersistentVolumeClaims("default").Get("data", metav1.GetOptions{})
+	if got := gotPVC.Status.Capacity[v1.ResourceStorage]; got.Cmp(want) != 0 {
+		t.Errorf("claim capacity = %s, want %s", got.String(), want.String())
+	}
+	if len(gotPVC.Status.Conditions) != 0 {
+		t.Errorf("claim still has conditions %v", gotPVC.Status.Conditions)
+	}
+
+	// Claims of other provisioners are left alone
+	other := pvc.DeepCopy()
+	other.Annotations[annStorageProvisioner] = "kubernetes.io/cinder"
+	if r.needsResize(other) {
+		t.Errorf("claim of another provisioner needs resize")
+	}
+}
@@ -33,7 +33,7 @@ func (CephFS) Name() string { return "cephfs" }
 
 // BuildSource builds PersistentVolumeSource for k8s CephFS
 func (CephFS) BuildSource(args *BuildSourceArgs) (*v1.PersistentVolumeSource, error) {
-	monitorsStr, path, err := splitExportLocation(args.Location)
+	monitorsStr, path, err := SplitExportLocation(args.Location)
 	if err != nil {
 		return nil, err
 	}
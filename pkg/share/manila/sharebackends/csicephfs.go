@@ -31,7 +31,7 @@ func (CSICephFS) Name() string { return "csi-cephfs" }
 
 // BuildSource builds PersistentVolumeSource for CSI CephFS driver
 func (CSICephFS) BuildSource(args *BuildSourceArgs) (*v1.PersistentVolumeSource, error) {
-	monitors, rootPath, err := splitExportLocation(args.Location)
+	monitors, rootPath, err := SplitExportLocation(args.Location)
 	if err != nil {
 		return nil, err
 	}
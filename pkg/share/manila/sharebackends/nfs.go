@@ -31,7 +31,7 @@ func (NFS) Name() string { return "nfs" }
 
 // BuildSource builds PersistentVolumeSource for k8s NFS
 func (NFS) BuildSource(args *BuildSourceArgs) (*v1.PersistentVolumeSource, error) {
-	server, path, err := splitExportLocation(args.Location)
+	server, path, err := SplitExportLocation(args.Location)
 	if err != nil {
 		return nil, err
 	}
@@ -39,7 +39,7 @@ func TestSplitExportLocation(t *testing.T) {
 	)
 
 	expLocation.Path = "addr1:port1,addr2:port2:/some-path"
-	if address, location, err = splitExportLocation(&expLocation); err != nil {
+	if address, location, err = SplitExportLocation(&expLocation); err != nil {
 		t.Fatalf("failed to split export location: %v", err)
 	}
 
@@ -26,10 +26,10 @@ import (
 	clientset "k8s.io/client-go/kubernetes"
 )
 
-// Splits ExportLocation path "addr1:port,addr2:port,...:/location" into its address
+// SplitExportLocation splits ExportLocation path "addr1:port,addr2:port,...:/location" into its address
 // and location parts. The last occurrence of ':' is considered as the delimiter
 // between those two parts.
-func splitExportLocation(loc *shares.ExportLocation) (address, location string, err error) {
+func SplitExportLocation(loc *shares.ExportLocation) (address, location string, err error) {
 	delimPos := strings.LastIndexByte(loc.Path, ':')
 	if delimPos <= 0 {
 		err = fmt.Errorf("failed to parse address and location from export location '%s'", loc.Path)
@@ -28,7 +28,7 @@ import (
 type OpenStackOptions struct {
 	// Common options
 
-	OSAuthURL    string `name:"os-authURL" dependsOn:"os-password|os-trustID"`
+	OSAuthURL    string `name:"os-authURL" dependsOn:"os-password|os-trustID|os-applicationCredentialSecret"`
 	OSRegionName string `name:"os-region"`
 
 	OSCertAuthority string `name:"os-certAuthority" value:"optional"`
@@ -37,11 +37,11 @@ type OpenStackOptions struct {
 	// User authentication
 
 	OSPassword string `name:"os-password" value:"optional" dependsOn:"os-domainID|os-domainName,os-projectID|os-projectName,os-userID|os-userName"`
-	OSUserID   string `name:"os-userID" value:"optional" dependsOn:"os-password"`
-	OSUsername string `name:"os-userName" value:"optional" dependsOn:"os-password"`
+	OSUserID   string `name:"os-userID" value:"optional" dependsOn:"os-password|os-applicationCredentialName"`
+	OSUsername string `name:"os-userName" value:"optional" dependsOn:"os-password|os-applicationCredentialName"`
 
-	OSDomainID   string `name:"os-domainID" value:"optional" dependsOn:"os-password"`
-	OSDomainName string `name:"os-domainName" value:"optional" dependsOn:"os-password"`
+	OSDomainID   string `name:"os-domainID" value:"optional" dependsOn:"os-password|os-applicationCredentialName"`
+	OSDomainName string `name:"os-domainName" value:"optional" dependsOn:"os-password|os-applicationCredentialName"`
 
 	OSProjectID   string `name:"os-projectID" value:"optional" dependsOn:"os-password"`
 	OSProjectName string `name:"os-projectName" value:"optional" dependsOn:"os-password"`
@@ -51,6 +51,13 @@ type OpenStackOptions struct {
 	OSTrustID         string `name:"os-trustID" value:"optional" dependsOn:"os-trusteeID,os-trusteePassword"`
 	OSTrusteeID       string `name:"os-trusteeID" value:"optional" dependsOn:"os-trustID"`
 	OSTrusteePassword string `name:"os-trusteePassword" value:"optional" dependsOn:"os-trustID"`
+
+	// Application credential authentication, a name is looked up among the
+	// credentials of the user
+
+	OSApplicationCredentialID     string `name:"os-applicationCredentialID" value:"optional" dependsOn:"os-applicationCredentialSecret"`
+	OSApplicationCredentialName   string `name:"os-applicationCredentialName" value:"optional" dependsOn:"os-applicationCredentialSecret,os-userID|os-userName"`
+	OSApplicationCredentialSecret string `name:"os-applicationCredentialSecret" value:"optional" dependsOn:"os-applicationCredentialID|os-applicationCredentialName"`
 }
 
 var (
@@ -92,6 +99,16 @@ func (o *OpenStackOptions) ToAuthOptions() *gophercloud.AuthOptions {
 		authOpts.Password = o.OSTrusteePassword
 	}
 
+	if o.OSApplicationCredentialSecret != "" {
+		// The project is the one of the application credential, Keystone
+		// refuses a scope along with it
+		authOpts.ApplicationCredentialID = o.OSApplicationCredentialID
+		authOpts.ApplicationCredentialName = o.OSApplicationCredentialName
+		authOpts.ApplicationCredentialSecret = o.OSApplicationCredentialSecret
+		authOpts.TenantID = ""
+		authOpts.TenantName = ""
+	}
+
 	return authOpts
 }
 
@@ -52,3 +52,35 @@ func TestOpenStackOptionsToAuthOptions(t *testing.T) {
 		t.Error("bad conversion from OpenStackOptions to gophercloud.AuthOptions")
 	}
 }
+
+func TestOpenStackOptionsApplicationCredential(t *testing.T) {
+	osOptions, err := NewOpenStackOptionsFromMap(map[string]string{
+		"os-authURL":                     "OSAuthURL",
+		"os-region":                      "OSRegion",
+		"os-applicationCredentialID":     "OSApplicationCredentialID",
+		"os-applicationCredentialSecret": "OSApplicationCredentialSecret",
+	})
+	if err != nil {
+		t.Fatalf("an application credential ID and secret should be enough: %v", err)
+	}
+
+	eq := reflect.DeepEqual(osOptions.ToAuthOptions(), &gophercloud.AuthOptions{
+		IdentityEndpoint:            "OSAuthURL",
+		ApplicationCredentialID:     "OSApplicationCredentialID",
+		ApplicationCredentialSecret: "OSApplicationCredentialSecret",
+	})
+	if !eq {
+		t.Error("bad conversion from OpenStackOptions to gophercloud.AuthOptions")
+	}
+
+	// A name is looked up among the credentials of a user
+	_, err = NewOpenStackOptionsFromMap(map[string]string{
+		"os-authURL":                     "OSAuthURL",
+		"os-region":                      "OSRegion",
+		"os-applicationCredentialName":   "OSApplicationCredentialName",
+		"os-applicationCredentialSecret": "OSApplicationCredentialSecret",
+	})
+	if err == nil {
+		t.Error("an application credential name without a user should be refused")
+	}
+}
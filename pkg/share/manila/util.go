@@ -66,12 +66,12 @@ func getStorageSizeInGiga(pvc *v1.PersistentVolumeClaim) (int, error) {
 	return strconv.Atoi(string(storageSizeAsByteSlice))
 }
 
-// Chooses one ExportLocation according to the below rules:
+// ChooseExportLocation chooses one ExportLocation according to the below rules:
 // 1. Path is not empty
 // 2. IsAdminOnly == false
 // 3. Preferred == true are preferred over Preferred == false
 // 4. Locations with lower slice index are preferred over locations with higher slice index
-func chooseExportLocation(locs []shares.ExportLocation) (shares.ExportLocation, error) {
+func ChooseExportLocation(locs []shares.ExportLocation) (shares.ExportLocation, error) {
 	if len(locs) == 0 {
 		return shares.ExportLocation{}, fmt.Errorf("export locations list is empty")
 	}
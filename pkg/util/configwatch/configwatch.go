@@ -0,0 +1,71 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package configwatch reloads the OpenStack credentials of a component when
+// the files they are read from change, e.g. a rotated Secret, without a
+// restart.
+package configwatch
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+// Watch checks every interval, until stop is closed, whether the files at
+// paths changed, and calls reload then. The files are compared by content, as
+// a mounted Secret is updated by swapping symlinks. A reload failing is
+// retried on the next check, the files may be half updated. Empty paths are
+// skipped.
+func Watch(interval time.Duration, stop <-chan struct{}, paths []string, reload func() error) {
+	last := Fingerprint(paths...)
+	klog.V(2).Infof("Checking %v for changes every %v", paths, interval)
+
+	go wait.Until(func() {
+		current := Fingerprint(paths...)
+		if current == last {
+			return
+		}
+		klog.Infof("OpenStack configuration %v changed, reloading it", paths)
+		if err := reload(); err != nil {
+			klog.Warningf("Failed to reload the OpenStack configuration, keeping the previous one: %v", err)
+			return
+		}
+		last = current
+	}, interval, stop)
+}
+
+// Fingerprint returns a hash of the content of the files at paths.
+func Fingerprint(paths ...string) string {
+	h := sha256.New()
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(h, "%s: %v\n", path, err)
+			continue
+		}
+		fmt.Fprintf(h, "%s: %d\n", path, len(data))
+		h.Write(data)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configwatch
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cloud.conf")
+
+	missing := Fingerprint(file)
+	if err := ioutil.WriteFile(file, []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	first := Fingerprint(file, "")
+	if first == missing {
+		t.Errorf("expected a created file to change the fingerprint")
+	}
+	if Fingerprint(file) != first {
+		t.Errorf("expected the same content to keep the fingerprint")
+	}
+	if err := ioutil.WriteFile(file, []byte("b"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if Fingerprint(file) == first {
+		t.Errorf("expected a changed file to change the fingerprint")
+	}
+}
+
+// A change is reloaded, a failed reload is retried until it succeeds.
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cloud.conf")
+	if err := ioutil.WriteFile(file, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := make(chan string, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	Watch(time.Millisecond, stop, []string{file}, func() error {
+		data, _ := ioutil.ReadFile(file)
+		reloads <- string(data)
+		if string(data) == "wrong" {
+			return errors.New("authentication failed")
+		}
+		return nil
+	})
+
+	time.Sleep(20 * time.Millisecond)
+	if len(reloads) != 0 {
+		t.Fatalf("expected no reload without a change")
+	}
+
+	if err := ioutil.WriteFile(file, []byte("wrong"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Retried on every check
+	for i := 0; i < 2; i++ {
+		if got := <-reloads; got != "wrong" {
+			t.Fatalf("expected the wrong config to be reloaded, got %q", got)
+		}
+	}
+
+	if err := ioutil.WriteFile(file, []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for got := range reloads {
+		if got == "new" {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if len(reloads) != 0 {
+		t.Errorf("expected no reload once the new config loaded, got %q", <-reloads)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package httpcontext binds HTTP requests to a context for clients, like
+// gophercloud, that do not take a context per request.
+package httpcontext
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// RoundTripper wraps rt so that every request is cancelled when ctx is done,
+// in addition to the context of the request itself. A nil rt uses
+// http.DefaultTransport.
+func RoundTripper(ctx context.Context, rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &roundTripper{ctx: ctx, rt: rt}
+}
+
+// CancelOnStop wraps rt so that outstanding and later requests are cancelled
+// once stop is closed.
+func CancelOnStop(stop <-chan struct{}, rt http.RoundTripper) http.RoundTripper {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	return RoundTripper(ctx, rt)
+}
+
+type roundTripper struct {
+	ctx context.Context
+	rt  http.RoundTripper
+}
+
+func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	go func() {
+		select {
+		case <-r.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	resp, err := r.rt.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		if r.ctx.Err() != nil {
+			return nil, r.ctx.Err()
+		}
+		return nil, err
+	}
+
+	// The body is read after RoundTrip returns, keep the request alive until
+	// it is closed.
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpcontext
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slowServer returns a server that only answers once release is closed
+func slowServer(release <-chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestRoundTripperCancel(t *testing.T) {
+	release := make(chan struct{})
+	srv := slowServer(release)
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &http.Client{Transport: RoundTripper(ctx, nil)}
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := client.Get(srv.URL)
+	if err == nil {
+		t.Fatalf("expected cancelled request to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled request took %v to abort", elapsed)
+	}
+
+	// Requests on an already cancelled context fail right away
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Errorf("expected request on cancelled context to fail")
+	}
+}
+
+func TestCancelOnStop(t *testing.T) {
+	release := make(chan struct{})
+	srv := slowServer(release)
+	defer srv.Close()
+	defer close(release)
+
+	stop := make(chan struct{})
+	client := &http.Client{Transport: CancelOnStop(stop, nil)}
+
+	time.AfterFunc(100*time.Millisecond, func() { close(stop) })
+	start := time.Now()
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatalf("expected request to fail on stop")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request took %v to abort on stop", elapsed)
+	}
+}
+
+func TestRoundTripperBodyReadable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: RoundTripper(context.Background(), nil)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "ok" {
+		t.Errorf("unexpected body %q, %v", body, err)
+	}
+}
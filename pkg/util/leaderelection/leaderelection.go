@@ -0,0 +1,161 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package leaderelection elects a leader among the replicas of a controller
+// component with a Lease, so that only one of them acts on the cluster and
+// OpenStack at a time while the others stand by.
+package leaderelection
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog"
+)
+
+// serviceAccountNamespaceFile is where the namespace of the pod is mounted,
+// replaced in the tests
+var serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// Config configures the leader election. The zero value disables it.
+type Config struct {
+	// Enabled runs the component only while it is the leader
+	Enabled bool
+	// Namespace is the namespace of the Lease, the one of the pod when
+	// empty
+	Namespace string
+	// LeaseDuration, RenewDeadline and RetryPeriod are the timings of
+	// leaderelection.LeaderElectionConfig
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// NewConfig returns a Config with the timings of the Kubernetes controllers,
+// disabled.
+func NewConfig() Config {
+	return Config{
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+	}
+}
+
+// AddFlags adds the flags of the leader election to fs.
+func (c *Config) AddFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Enabled, "leader-elect", c.Enabled, "Elect a leader among the replicas with a Lease before running, for high availability")
+	fs.StringVar(&c.Namespace, "leader-elect-namespace", c.Namespace, "Namespace of the leader election Lease, the namespace of the pod when empty")
+	fs.DurationVar(&c.LeaseDuration, "leader-elect-lease-duration", c.LeaseDuration, "How long the replicas wait before taking the leadership of a leader not renewing it")
+	fs.DurationVar(&c.RenewDeadline, "leader-elect-renew-deadline", c.RenewDeadline, "How long the leader retries to renew its leadership before giving it up, less than --leader-elect-lease-duration")
+	fs.DurationVar(&c.RetryPeriod, "leader-elect-retry-period", c.RetryPeriod, "How long the replicas wait between two attempts to acquire or renew the leadership")
+}
+
+// Validate checks the timings of c when the leader election is enabled.
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.LeaseDuration <= c.RenewDeadline {
+		return fmt.Errorf("leader election lease duration %v must be greater than the renew deadline %v", c.LeaseDuration, c.RenewDeadline)
+	}
+	if c.RetryPeriod <= 0 || c.RenewDeadline <= c.RetryPeriod {
+		return fmt.Errorf("leader election renew deadline %v must be greater than the retry period %v", c.RenewDeadline, c.RetryPeriod)
+	}
+	return nil
+}
+
+// namespace returns the namespace of the Lease.
+func (c Config) namespace() string {
+	if c.Namespace != "" {
+		return c.Namespace
+	}
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	if data, err := ioutil.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if ns := strings.TrimSpace(string(data)); ns != "" {
+			return ns
+		}
+	}
+	return "default"
+}
+
+// leaseName returns the name of the Lease of the component name, e.g. a
+// provisioner name like openstack.org/standalone-cinder.
+func leaseName(name string) string {
+	return strings.ToLower(strings.NewReplacer("/", "-", "_", "-", ":", "-").Replace(name))
+}
+
+// Run runs run right away when the leader election of c is disabled, or once
+// this replica of the component name is the leader otherwise. It returns
+// when run does, or when ctx is done. A replica losing the leadership exits,
+// since run may still be acting on the stale state of a leader.
+func Run(ctx context.Context, client kubernetes.Interface, name string, c Config, run func(ctx context.Context)) error {
+	if !c.Enabled {
+		run(ctx)
+		return nil
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to get the hostname: %v", err)
+	}
+	id := hostname + "_" + string(uuid.NewUUID())
+
+	ns := c.namespace()
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, ns, leaseName(name),
+		client.CoreV1(), client.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: id})
+	if err != nil {
+		return fmt.Errorf("failed to create the leader election lock: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	klog.Infof("Waiting to be the leader of %s with Lease %s/%s as %s", name, ns, leaseName(name), id)
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: c.LeaseDuration,
+		RenewDeadline: c.RenewDeadline,
+		RetryPeriod:   c.RetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.Infof("Elected leader of %s", name)
+				run(ctx)
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() == nil {
+					klog.Fatalf("Lost the leadership of %s, exiting", name)
+				}
+				klog.Infof("Stopped leading %s", name)
+			},
+		},
+		Name: name,
+	})
+	return nil
+}
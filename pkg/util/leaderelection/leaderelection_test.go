@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leaderelection
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	c := NewConfig()
+
+	// Disabled
+	ran := false
+	if err := Run(context.Background(), client, "openstack.org/standalone-cinder", c, func(context.Context) { ran = true }); err != nil || !ran {
+		t.Fatalf("Run without leader election = %v, ran %v", err, ran)
+	}
+
+	c.Enabled = true
+	c.Namespace = "kube-system"
+	c.LeaseDuration, c.RenewDeadline, c.RetryPeriod = 3*time.Second, 2*time.Second, 100*time.Millisecond
+	ran = false
+	err := Run(context.Background(), client, "openstack.org/standalone-cinder", c, func(ctx context.Context) {
+		lease, err := client.CoordinationV1().Leases("kube-system").Get("openstack.org-standalone-cinder", metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("failed to get the lease of the leader: %v", err)
+		} else if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+			t.Errorf("lease of the leader has no holder")
+		}
+		ran = true
+	})
+	if err != nil || !ran {
+		t.Errorf("Run with leader election = %v, ran %v", err, ran)
+	}
+
+	c.RenewDeadline = c.LeaseDuration
+	if err := Run(context.Background(), client, "manila", c, func(context.Context) {}); err == nil {
+		t.Errorf("expected an error with a renew deadline as long as the lease duration")
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	serviceAccountNamespaceFile = "/nonexistent"
+	if ns := (Config{}).namespace(); ns != "default" {
+		t.Errorf("namespace = %q, want default", ns)
+	}
+	if ns := (Config{Namespace: "kube-system"}).namespace(); ns != "kube-system" {
+		t.Errorf("namespace = %q, want kube-system", ns)
+	}
+}
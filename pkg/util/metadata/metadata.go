@@ -26,6 +26,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"k8s.io/klog"
 
@@ -52,6 +54,10 @@ const (
 
 	// configDriveID is used as an identifier on the metadata search order configuration.
 	ConfigDriveID = "configDrive"
+
+	// NovaID is used as an identifier on the metadata search order
+	// configuration for the Nova lookup, see SetNovaSource.
+	NovaID = "nova"
 )
 
 // ErrBadMetadata is used to indicate a problem parsing data from metadata server
@@ -165,43 +171,112 @@ func GetFromMetadataService(metadataVersion string) (*Metadata, error) {
 	return parseMetadata(resp.Body)
 }
 
-// Metadata is fixed for the current host, so cache the value process-wide
-var metadataCache *Metadata
+// The sources Get reads the metadata from, replaced in the tests
+var (
+	getFromConfigDrive     = GetFromConfigDrive
+	getFromMetadataService = GetFromMetadataService
+)
+
+// novaSource looks the instance up in Nova, see SetNovaSource
+var novaSource func() (*Metadata, error)
+
+// SetNovaSource sets how Get looks the instance up in Nova with the NovaID
+// search order element, e.g. by the host name, for when it runs without a
+// config drive nor a reachable metadata service.
+func SetNovaSource(source func() (*Metadata, error)) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	novaSource = source
+}
+
+// Metadata is fixed for the current host, so cache the value process-wide,
+// refreshed after cacheTTL when set
+var (
+	cacheLock     sync.Mutex
+	metadataCache *Metadata
+	cachedAt      time.Time
+	cacheTTL      time.Duration
+)
+
+// SetCacheTTL makes Get read the metadata again once cached for longer than
+// ttl. A zero ttl caches it until Clear.
+func SetCacheTTL(ttl time.Duration) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	cacheTTL = ttl
+}
 
 func Set(value *Metadata) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
 	metadataCache = value
+	cachedAt = time.Now()
 }
 
+// Clear invalidates the cached metadata, the next Get reads it again.
 func Clear() {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
 	metadataCache = nil
 }
 
+// Get returns the metadata of the host from the first source of order that
+// has it, a comma separated list of ConfigDriveID, MetadataID and NovaID, or
+// the cached one. When refreshing the cache fails, the previous metadata is
+// returned until a refresh succeeds.
 func Get(order string) (*Metadata, error) {
-	if metadataCache == nil {
-		var md *Metadata
-		var err error
-
-		elements := strings.Split(order, ",")
-		for _, id := range elements {
-			id = strings.TrimSpace(id)
-			switch id {
-			case ConfigDriveID:
-				md, err = GetFromConfigDrive(defaultMetadataVersion)
-			case MetadataID:
-				md, err = GetFromMetadataService(defaultMetadataVersion)
-			default:
-				err = fmt.Errorf("%s is not a valid metadata search order option. Supported options are %s and %s", id, ConfigDriveID, MetadataID)
-			}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
 
-			if err == nil {
-				break
+	expired := metadataCache != nil && cacheTTL > 0 && time.Since(cachedAt) > cacheTTL
+	if metadataCache != nil && !expired {
+		return metadataCache, nil
+	}
+
+	md, err := getFromSources(order)
+	if err != nil {
+		if expired {
+			klog.Warningf("Failed to refresh the instance metadata, keeping the cached one: %v", err)
+			return metadataCache, nil
+		}
+		return nil, err
+	}
+	metadataCache = md
+	cachedAt = time.Now()
+	return metadataCache, nil
+}
+
+// getFromSources returns the metadata from the first source of order that
+// has it.
+func getFromSources(order string) (*Metadata, error) {
+	var md *Metadata
+	var err error
+
+	elements := strings.Split(order, ",")
+	for _, id := range elements {
+		id = strings.TrimSpace(id)
+		switch id {
+		case ConfigDriveID:
+			md, err = getFromConfigDrive(defaultMetadataVersion)
+		case MetadataID:
+			md, err = getFromMetadataService(defaultMetadataVersion)
+		case NovaID:
+			if novaSource == nil {
+				err = fmt.Errorf("%s is not available", NovaID)
+			} else {
+				md, err = novaSource()
 			}
+		default:
+			err = fmt.Errorf("%s is not a valid metadata search order option. Supported options are %s, %s and %s", id, ConfigDriveID, MetadataID, NovaID)
 		}
 
-		if err != nil {
-			return nil, err
+		if err == nil {
+			break
 		}
-		metadataCache = md
 	}
-	return metadataCache, nil
+
+	if err != nil {
+		return nil, err
+	}
+	return md, nil
 }
@@ -17,8 +17,10 @@ limitations under the License.
 package metadata
 
 import (
+	"errors"
 	"strings"
 	"testing"
+	"time"
 )
 
 var FakeMetadata = Metadata{
@@ -105,3 +107,73 @@ func TestParseMetadata(t *testing.T) {
 		t.Errorf("incorrect device serial: %s", md.Devices[0].Serial)
 	}
 }
+
+// fakeSources replaces the config drive and the metadata service with err
+// and md, counting the reads of the metadata service, and returns a func
+// restoring them.
+func fakeSources(md **Metadata, err *error, reads *int) func() {
+	savedDrive, savedService := getFromConfigDrive, getFromMetadataService
+	getFromConfigDrive = func(string) (*Metadata, error) {
+		return nil, errors.New("no config drive")
+	}
+	getFromMetadataService = func(string) (*Metadata, error) {
+		*reads++
+		return *md, *err
+	}
+	return func() {
+		getFromConfigDrive, getFromMetadataService = savedDrive, savedService
+		Clear()
+		SetCacheTTL(0)
+		SetNovaSource(nil)
+	}
+}
+
+func TestGetCache(t *testing.T) {
+	md, err, reads := &FakeMetadata, error(nil), 0
+	defer fakeSources(&md, &err, &reads)()
+	order := ConfigDriveID + "," + MetadataID
+
+	for i := 0; i < 2; i++ {
+		got, getErr := Get(order)
+		if getErr != nil || got.UUID != FakeMetadata.UUID {
+			t.Fatalf("expected the metadata, got %v, %v", got, getErr)
+		}
+	}
+	if reads != 1 {
+		t.Errorf("expected the metadata service to be read once, got %d", reads)
+	}
+
+	// Refreshed after the TTL, the cached metadata outliving a failed refresh
+	SetCacheTTL(time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	err = errors.New("connection refused")
+	if got, getErr := Get(order); getErr != nil || got.UUID != FakeMetadata.UUID {
+		t.Errorf("expected the cached metadata, got %v, %v", got, getErr)
+	}
+	if reads != 2 {
+		t.Errorf("expected the metadata to be refreshed, got %d reads", reads)
+	}
+
+	// Nothing to fall back to once cleared
+	Clear()
+	if _, getErr := Get(order); getErr == nil {
+		t.Errorf("expected the cleared metadata not to be returned")
+	}
+}
+
+func TestGetNova(t *testing.T) {
+	md, err, reads := (*Metadata)(nil), errors.New("connection refused"), 0
+	defer fakeSources(&md, &err, &reads)()
+	order := ConfigDriveID + "," + MetadataID + "," + NovaID
+
+	if _, getErr := Get(order); getErr == nil {
+		t.Errorf("expected a failure without a Nova lookup")
+	}
+
+	SetNovaSource(func() (*Metadata, error) {
+		return &FakeMetadata, nil
+	})
+	if got, getErr := Get(order); getErr != nil || got.UUID != FakeMetadata.UUID {
+		t.Errorf("expected the metadata from Nova, got %v, %v", got, getErr)
+	}
+}
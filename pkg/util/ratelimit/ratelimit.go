@@ -0,0 +1,186 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ratelimit throttles the requests of the OpenStack clients on the
+// client side, and retries the requests the APIs refuse as over their rate
+// limit, so that bursts of calls, like the attachments of a rebooted node,
+// do not make each other fail.
+package ratelimit
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/klog"
+)
+
+// The backoff between the retries of a request over the rate limit, doubled
+// on each retry. Replaced in the tests.
+var (
+	initialBackoff = time.Second
+	maxBackoff     = 30 * time.Second
+)
+
+// Config is the [RateLimit] section of the cloud config. The zero value
+// neither throttles nor retries the requests.
+type Config struct {
+	// QPS is the number of requests per second sent at most, 0 for no limit
+	QPS float64 `gcfg:"qps"`
+	// Burst is the number of requests sent at once above QPS, 1 when unset
+	Burst int `gcfg:"burst"`
+	// MaxRetries is the number of times a request refused as over the rate
+	// limit of the API is retried
+	MaxRetries int `gcfg:"max-retries"`
+}
+
+// Validate checks the values of c.
+func (c Config) Validate() error {
+	if c.QPS < 0 {
+		return fmt.Errorf("qps cannot be negative, got %v", c.QPS)
+	}
+	if c.Burst < 0 {
+		return fmt.Errorf("burst cannot be negative, got %d", c.Burst)
+	}
+	if c.MaxRetries < 0 {
+		return fmt.Errorf("max-retries cannot be negative, got %d", c.MaxRetries)
+	}
+	return nil
+}
+
+// RoundTripper wraps rt so that the requests are sent at the QPS of c, and
+// retried up to MaxRetries times with an exponential backoff when over the
+// rate limit of the API: answered with 429 Too Many Requests, or with 413
+// and a Retry-After header, the over limit of the compute API. Retry-After
+// is used as the backoff when set. A nil rt uses http.DefaultTransport.
+//
+// The requests share the limit of the returned RoundTripper, it has to wrap
+// the transport of every client sharing the limit.
+func (c Config) RoundTripper(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if c.QPS <= 0 && c.MaxRetries <= 0 {
+		return rt
+	}
+
+	r := &roundTripper{rt: rt, maxRetries: c.MaxRetries}
+	if c.QPS > 0 {
+		burst := c.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		r.limiter = rate.NewLimiter(rate.Limit(c.QPS), burst)
+	}
+	return r
+}
+
+type roundTripper struct {
+	rt http.RoundTripper
+	// limiter is nil when the requests are not throttled
+	limiter    *rate.Limiter
+	maxRetries int
+}
+
+func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if r.limiter != nil {
+			if err := r.limiter.Wait(req.Context()); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := r.rt.RoundTrip(req)
+		if err != nil || attempt >= r.maxRetries || !overLimit(resp) {
+			return resp, err
+		}
+
+		// The body is sent again, a request whose body cannot be read again
+		// is left to the caller to retry
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return resp, nil
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+			req = req.WithContext(req.Context())
+			req.Body = body
+		}
+
+		delay := retryDelay(resp, attempt)
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		klog.V(4).Infof("%s %s is over the rate limit of %s, retrying in %v", req.Method, req.URL.Path, req.URL.Host, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// overLimit returns whether resp refuses its request as over the rate limit.
+func overLimit(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusRequestEntityTooLarge:
+		// Also answered to requests larger than allowed, without a
+		// Retry-After
+		return resp.Header.Get("Retry-After") != ""
+	}
+	return false
+}
+
+// retryDelay returns how long to wait before retrying the request of resp,
+// over the rate limit, for the attempt-th time: its Retry-After, doubling
+// initialBackoff otherwise, up to maxBackoff. A share of the delay is random
+// for the clients refused at once not to retry at once.
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	delay := initialBackoff << uint(attempt)
+	if after := retryAfter(resp); after > 0 {
+		delay = after
+	}
+	if delay <= 0 || delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/10+1))
+}
+
+// retryAfter returns the Retry-After of resp, in seconds or as a date, 0
+// without a valid one.
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// overLimitServer returns a server refusing the first refused requests with
+// code, and the bodies of the requests it got.
+func overLimitServer(refused int, code int, header http.Header) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		n := len(bodies)
+		mu.Unlock()
+		if n <= refused {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(code)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), bodies...)
+	}
+}
+
+func shortBackoff() func() {
+	savedInitial, savedMax := initialBackoff, maxBackoff
+	initialBackoff, maxBackoff = time.Millisecond, 10*time.Millisecond
+	return func() {
+		initialBackoff, maxBackoff = savedInitial, savedMax
+	}
+}
+
+func TestRoundTripperRetries(t *testing.T) {
+	defer shortBackoff()()
+	srv, bodies := overLimitServer(2, http.StatusTooManyRequests, nil)
+	defer srv.Close()
+
+	client := &http.Client{Transport: Config{MaxRetries: 2}.RoundTripper(nil)}
+	resp, err := client.Post(srv.URL, "application/json", strings.NewReader(`{"volume":{}}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 after the retries, got %d", resp.StatusCode)
+	}
+	// The body is sent again on every retry
+	got := bodies()
+	if len(got) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(got))
+	}
+	for i, body := range got {
+		if body != `{"volume":{}}` {
+			t.Errorf("request %d has body %q", i, body)
+		}
+	}
+}
+
+func TestRoundTripperRetriesExhausted(t *testing.T) {
+	defer shortBackoff()()
+	srv, bodies := overLimitServer(5, http.StatusTooManyRequests, nil)
+	defer srv.Close()
+
+	client := &http.Client{Transport: Config{MaxRetries: 2}.RoundTripper(nil)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected the last 429, got %d", resp.StatusCode)
+	}
+	if n := len(bodies()); n != 3 {
+		t.Errorf("expected 3 requests, got %d", n)
+	}
+}
+
+// A 413 is only over the rate limit with a Retry-After.
+func TestRoundTripperRequestTooLarge(t *testing.T) {
+	defer shortBackoff()()
+	for _, test := range []struct {
+		header   http.Header
+		requests int
+	}{
+		{nil, 1},
+		{http.Header{"Retry-After": []string{"0"}}, 2},
+	} {
+		srv, bodies := overLimitServer(1, http.StatusRequestEntityTooLarge, test.header)
+		client := &http.Client{Transport: Config{MaxRetries: 1}.RoundTripper(nil)}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if n := len(bodies()); n != test.requests {
+			t.Errorf("with %v expected %d requests, got %d", test.header, test.requests, n)
+		}
+		srv.Close()
+	}
+}
+
+func TestRoundTripperQPS(t *testing.T) {
+	srv, _ := overLimitServer(0, http.StatusOK, nil)
+	defer srv.Close()
+
+	client := &http.Client{Transport: Config{QPS: 50, Burst: 1}.RoundTripper(nil)}
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+	// The first request goes at once, the others every 20ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("6 requests at 50 QPS took %v", elapsed)
+	}
+}
+
+// A request whose context is done stops waiting for its retry.
+func TestRoundTripperCancel(t *testing.T) {
+	srv, _ := overLimitServer(5, http.StatusTooManyRequests, http.Header{"Retry-After": []string{"10"}})
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	client := &http.Client{Transport: Config{MaxRetries: 3}.RoundTripper(nil)}
+	start := time.Now()
+	if _, err := client.Do(req.WithContext(ctx)); err == nil {
+		t.Errorf("expected the cancelled request to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled request took %v to abort", elapsed)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	defer shortBackoff()()
+	now := time.Now().UTC()
+	tests := []struct {
+		header  string
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{"", 0, time.Millisecond, 2 * time.Millisecond},
+		{"", 2, 4 * time.Millisecond, 5 * time.Millisecond},
+		{"", 10, 10 * time.Millisecond, 11 * time.Millisecond},
+		{"1", 0, 10 * time.Millisecond, 11 * time.Millisecond},
+		{now.Add(time.Hour).Format(http.TimeFormat), 0, 10 * time.Millisecond, 11 * time.Millisecond},
+		{"invalid", 1, 2 * time.Millisecond, 3 * time.Millisecond},
+	}
+	for _, test := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if test.header != "" {
+			resp.Header.Set("Retry-After", test.header)
+		}
+		delay := retryDelay(resp, test.attempt)
+		if delay < test.min || delay > test.max {
+			t.Errorf("Retry-After %q attempt %d: expected a delay between %v and %v, got %v", test.header, test.attempt, test.min, test.max, delay)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, c := range []Config{{QPS: -1}, {Burst: -1}, {MaxRetries: -1}} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", c)
+		}
+	}
+	if err := (Config{QPS: 10, Burst: 20, MaxRetries: 3}).Validate(); err != nil {
+		t.Errorf("expected a valid config, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package skew measures the clock difference between the local host and an
+// OpenStack API server using the Date header of the server responses.
+package skew
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog"
+)
+
+// DefaultThreshold is the skew above which a warning is logged.
+const DefaultThreshold = 30 * time.Second
+
+// Tracker records the clock skew observed in HTTP responses. A positive skew
+// means the local clock is ahead of the server clock.
+type Tracker struct {
+	threshold time.Duration
+	gauge     prometheus.Gauge
+	now       func() time.Time
+
+	mu     sync.RWMutex
+	skew   time.Duration
+	warned bool
+}
+
+// NewTracker returns a Tracker logging a warning when the skew exceeds
+// threshold. gauge is optional and set to the skew in seconds.
+func NewTracker(threshold time.Duration, gauge prometheus.Gauge) *Tracker {
+	if threshold <= 0 {
+		threshold = DefaultThreshold
+	}
+	return &Tracker{
+		threshold: threshold,
+		gauge:     gauge,
+		now:       time.Now,
+	}
+}
+
+// Observe updates the skew from a response received for a request sent at
+// start. Responses without a valid Date header are ignored.
+func (t *Tracker) Observe(start time.Time, resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	// The server generated the Date header somewhere between sending the
+	// request and receiving the response, assume the middle.
+	end := t.now()
+	local := start.Add(end.Sub(start) / 2)
+	skew := local.Sub(serverTime)
+
+	// The Date header has a resolution of one second
+	if skew > -time.Second && skew < time.Second {
+		skew = 0
+	}
+
+	t.mu.Lock()
+	t.skew = skew
+	exceeded := skew > t.threshold || -skew > t.threshold
+	warn := exceeded && !t.warned
+	t.warned = exceeded
+	t.mu.Unlock()
+
+	if t.gauge != nil {
+		t.gauge.Set(skew.Seconds())
+	}
+
+	host := "server"
+	if resp.Request != nil && resp.Request.URL != nil {
+		host = resp.Request.URL.Host
+	}
+	if warn {
+		klog.Warningf("Local clock differs from %s by %v, token expiry will be corrected using the server time", host, skew)
+	} else if !exceeded {
+		klog.V(5).Infof("Clock skew to %s is %v", host, skew)
+	}
+}
+
+// Skew returns the last observed skew, zero if nothing was observed yet.
+func (t *Tracker) Skew() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.skew
+}
+
+// ServerNow returns the current time according to the server clock.
+func (t *Tracker) ServerNow() time.Time {
+	return t.now().Add(-t.Skew())
+}
+
+// ToLocal converts a timestamp issued by the server, like a token expiry,
+// into local clock time.
+func (t *Tracker) ToLocal(serverTime time.Time) time.Time {
+	return serverTime.Add(t.Skew())
+}
+
+// Expired reports whether a server issued expiry has passed, or will within
+// margin, according to the server clock.
+func (t *Tracker) Expired(expiresAt time.Time, margin time.Duration) bool {
+	return !t.ServerNow().Add(margin).Before(expiresAt)
+}
+
+// RoundTripper wraps rt so that every response updates the tracker. A nil rt
+// uses http.DefaultTransport.
+func (t *Tracker) RoundTripper(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &roundTripper{tracker: t, rt: rt}
+}
+
+type roundTripper struct {
+	tracker *Tracker
+	rt      http.RoundTripper
+}
+
+func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := r.tracker.now()
+	resp, err := r.rt.RoundTrip(req)
+	if err == nil {
+		r.tracker.Observe(start, resp)
+	}
+	return resp, err
+}
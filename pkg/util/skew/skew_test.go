@@ -0,0 +1,126 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package skew
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// skewedServer returns a server whose Date header is offset from the local clock
+func skewedServer(offset time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	var m dto.Metric
+	if err := g.Write(&m); err != nil {
+		t.Fatalf("failed to read gauge: %v", err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestTrackerSkew(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset time.Duration
+	}{
+		{name: "server ahead", offset: 10 * time.Minute},
+		{name: "server behind", offset: -10 * time.Minute},
+		{name: "in sync", offset: 0},
+	}
+
+	for _, test := range tests {
+		srv := skewedServer(test.offset)
+
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_clock_skew_seconds"})
+		tracker := NewTracker(time.Minute, gauge)
+		client := &http.Client{Transport: tracker.RoundTripper(nil)}
+
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", test.name, err)
+		}
+		resp.Body.Close()
+		srv.Close()
+
+		// Local clock is ahead when the server is behind, hence the sign flip
+		expected := -test.offset
+		if diff := tracker.Skew() - expected; diff > 2*time.Second || diff < -2*time.Second {
+			t.Errorf("%s: expected skew around %v, got %v", test.name, expected, tracker.Skew())
+		}
+		if diff := gaugeValue(t, gauge) - expected.Seconds(); diff > 2 || diff < -2 {
+			t.Errorf("%s: expected gauge around %v, got %v", test.name, expected.Seconds(), gaugeValue(t, gauge))
+		}
+	}
+}
+
+func TestTrackerExpired(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewTracker(time.Minute, nil)
+	tracker.now = func() time.Time { return now }
+
+	observe := func(serverTime time.Time) {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("Date", serverTime.Format(http.TimeFormat))
+		tracker.Observe(now, resp)
+	}
+
+	// Local clock is 10 minutes ahead: a token expiring in 5 minutes server
+	// time looks expired locally but is still valid.
+	observe(now.Add(-10 * time.Minute))
+	expiresAt := now.Add(-5 * time.Minute)
+	if tracker.Expired(expiresAt, 0) {
+		t.Errorf("token should be valid when the local clock is ahead")
+	}
+	if !tracker.ToLocal(expiresAt).Equal(now.Add(5 * time.Minute)) {
+		t.Errorf("unexpected local expiry %v", tracker.ToLocal(expiresAt))
+	}
+
+	// Local clock is 10 minutes behind: a token that looks valid for another
+	// 5 minutes locally has already expired on the server.
+	observe(now.Add(10 * time.Minute))
+	expiresAt = now.Add(5 * time.Minute)
+	if !tracker.Expired(expiresAt, 0) {
+		t.Errorf("token should be expired when the local clock is behind")
+	}
+
+	// Margin is applied on top of the corrected time
+	observe(now)
+	if !tracker.Expired(now.Add(30*time.Second), time.Minute) {
+		t.Errorf("token expiring within the margin should be treated as expired")
+	}
+	if tracker.Expired(now.Add(2*time.Minute), time.Minute) {
+		t.Errorf("token expiring after the margin should be valid")
+	}
+}
+
+func TestTrackerIgnoresMissingDate(t *testing.T) {
+	tracker := NewTracker(time.Minute, nil)
+	tracker.Observe(time.Now(), &http.Response{Header: http.Header{}})
+	if tracker.Skew() != 0 {
+		t.Errorf("expected no skew without Date header, got %v", tracker.Skew())
+	}
+}
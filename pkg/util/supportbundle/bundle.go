@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package supportbundle collects the state of a running component into a
+// single tar.gz for bug reports, with all secret material masked.
+package supportbundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// Path is the HTTP path the bundle is served on.
+const Path = "/debug/support-bundle"
+
+type file struct {
+	name string
+	data []byte
+}
+
+// Bundle is a set of files written as a tar.gz. Every file goes through the
+// Redactor when the bundle is written.
+type Bundle struct {
+	redactor *Redactor
+	files    []file
+	now      func() time.Time
+}
+
+// New returns an empty Bundle masking secrets with r.
+func New(r *Redactor) *Bundle {
+	if r == nil {
+		r = NewRedactor()
+	}
+	return &Bundle{redactor: r, now: time.Now}
+}
+
+// AddSecret registers a value to be masked in every file of the bundle.
+func (b *Bundle) AddSecret(secret string) {
+	b.redactor.AddSecret(secret)
+}
+
+// Add adds a file to the bundle.
+func (b *Bundle) Add(name string, data []byte) {
+	b.files = append(b.files, file{name: name, data: data})
+}
+
+// AddJSON adds v as an indented JSON file, or the marshalling error.
+func (b *Bundle) AddJSON(name string, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		data = []byte(fmt.Sprintf("failed to marshal %s: %v\n", name, err))
+	}
+	b.Add(name, data)
+}
+
+// AddLines adds lines as a text file.
+func (b *Bundle) AddLines(name string, lines []string) {
+	data := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		data += "\n"
+	}
+	b.Add(name, []byte(data))
+}
+
+// Write writes the bundle as a tar.gz to w.
+func (b *Bundle) Write(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	modTime := b.now()
+	for _, f := range b.files {
+		data := b.redactor.Redact(f.data)
+		hdr := &tar.Header{
+			Name:    f.name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: modTime,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Handler serves a bundle filled by collect.
+func Handler(r *Redactor, collect func(b *Bundle)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b := New(r)
+		collect(b)
+
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="support-bundle.tar.gz"`)
+		if err := b.Write(w); err != nil {
+			klog.Errorf("Failed to write support bundle: %v", err)
+		}
+	})
+}
+
+// Download fetches the bundle served on address and writes it to output.
+func Download(address, output string) error {
+	url := address
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "http://" + url
+	}
+	url = strings.TrimSuffix(url, "/") + Path
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch support bundle from %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch support bundle from %s: %s", url, resp.Status)
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write support bundle to %s: %v", output, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package supportbundle
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"k8s.io/klog"
+)
+
+// MutualTLSConfig returns the TLS config of a debug server presenting the
+// certificate in certFile and keyFile and requiring client certificates
+// signed by the CA in clientCAFile.
+func MutualTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the debug server certificate: %v", err)
+	}
+	data, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the debug client CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in the debug client CA %s", clientCAFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// IsLoopback returns whether address, a host:port, only listens on the
+// loopback interface.
+func IsLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ServeDebug serves the debug endpoints of mux on address in the background.
+// They include administrative actions, so without tlsConfig requiring client
+// certificates the address must be a loopback one.
+func ServeDebug(address string, mux *http.ServeMux, tlsConfig *tls.Config) error {
+	if tlsConfig == nil && !IsLoopback(address) {
+		return fmt.Errorf("the debug endpoints are only served on a loopback address without mutual TLS, got %s", address)
+	}
+
+	server := &http.Server{Addr: address, Handler: mux, TLSConfig: tlsConfig}
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			klog.Infof("Serving the debug endpoints with mutual TLS on %s", address)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			klog.Infof("Serving the debug endpoints on %s", address)
+			err = server.ListenAndServe()
+		}
+		if err != nil {
+			klog.Errorf("Failed to serve the debug endpoints on %s: %v", address, err)
+		}
+	}()
+	return nil
+}
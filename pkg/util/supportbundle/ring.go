@@ -0,0 +1,179 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package supportbundle
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LineBuffer keeps the last lines written to it.
+type LineBuffer struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	full    bool
+	partial []byte
+}
+
+// NewLineBuffer returns a LineBuffer keeping the last size lines.
+func NewLineBuffer(size int) *LineBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &LineBuffer{lines: make([]string, size)}
+}
+
+// Write implements io.Writer, incomplete lines are kept until their end is
+// written.
+func (b *LineBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data := append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		b.lines[b.next] = string(data[:i])
+		b.next = (b.next + 1) % len(b.lines)
+		if b.next == 0 {
+			b.full = true
+		}
+		data = data[i+1:]
+	}
+	b.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+// Lines returns the buffered lines, oldest first.
+func (b *LineBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.full {
+		return append([]string(nil), b.lines[:b.next]...)
+	}
+	return append(append([]string(nil), b.lines[b.next:]...), b.lines[:b.next]...)
+}
+
+// CaptureStderr copies everything written to the process standard error
+// into b, in addition to the original standard error. This catches the
+// klog output when logging to stderr.
+func CaptureStderr(b *LineBuffer) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	go io.Copy(io.MultiWriter(stderr, b), r)
+	return nil
+}
+
+// Operation is a call recorded in a History.
+type Operation struct {
+	Name     string        `json:"name"`
+	Target   string        `json:"target,omitempty"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// History keeps the last finished operations and the ones in progress,
+// including operations blocked on a lock or waiting in a backoff loop.
+type History struct {
+	mu       sync.Mutex
+	done     []Operation
+	next     int
+	full     bool
+	inflight map[int]*Operation
+	id       int
+	now      func() time.Time
+}
+
+// NewHistory returns a History keeping the last size finished operations.
+func NewHistory(size int) *History {
+	if size < 1 {
+		size = 1
+	}
+	return &History{
+		done:     make([]Operation, size),
+		inflight: map[int]*Operation{},
+		now:      time.Now,
+	}
+}
+
+// Begin records the start of an operation, the returned function records
+// its end.
+func (h *History) Begin(name, target string) func(err error) {
+	h.mu.Lock()
+	h.id++
+	id := h.id
+	op := &Operation{Name: name, Target: target, Start: h.now()}
+	h.inflight[id] = op
+	h.mu.Unlock()
+
+	return func(err error) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		delete(h.inflight, id)
+		op.Duration = h.now().Sub(op.Start)
+		if err != nil {
+			op.Error = err.Error()
+		}
+		h.done[h.next] = *op
+		h.next = (h.next + 1) % len(h.done)
+		if h.next == 0 {
+			h.full = true
+		}
+	}
+}
+
+// Operations returns the finished operations, oldest first.
+func (h *History) Operations() []Operation {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.full {
+		return append([]Operation(nil), h.done[:h.next]...)
+	}
+	return append(append([]Operation(nil), h.done[h.next:]...), h.done[:h.next]...)
+}
+
+// InFlight returns the operations in progress, oldest first, with the time
+// spent so far as duration.
+func (h *History) InFlight() []Operation {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	ops := make([]Operation, 0, len(h.inflight))
+	for _, op := range h.inflight {
+		o := *op
+		o.Duration = now.Sub(o.Start)
+		ops = append(ops, o)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Start.Before(ops[j].Start) })
+	return ops
+}
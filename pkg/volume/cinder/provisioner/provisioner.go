@@ -19,11 +19,15 @@ package provisioner
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/cloud-provider-openstack/pkg/volume/cinder/volumeservice"
 	"k8s.io/klog"
@@ -50,6 +54,13 @@ const (
 
 	// SmartCloneEnabled is a provisioner parameter to enable smart clone mode for a storage class
 	SmartCloneEnabled = "smartclone"
+
+	// AdoptVolumeIDAnn is an annotation to request that the PVC be bound to the existing cinder volume of the
+	// given ID rather than a new one
+	AdoptVolumeIDAnn = "adoptCinderVolumeId"
+
+	// AdoptionEnabled is a provisioner parameter to allow the PVCs of a storage class to adopt existing volumes
+	AdoptionEnabled = "adoption"
 )
 
 type cinderProvisioner struct {
@@ -69,12 +80,15 @@ type cinderProvisioner struct {
 
 // NewCinderProvisioner returns a Provisioner that creates volumes using a
 // standalone cinder instance and produces PersistentVolumes that use native
-// kubernetes PersistentVolumeSources.
-func NewCinderProvisioner(client kubernetes.Interface, id, configFilePath string) (controller.Provisioner, error) {
+// kubernetes PersistentVolumeSources. The credentials of the config file are
+// reloaded when it changes, checking every configReloadInterval, 0 disables
+// it.
+func NewCinderProvisioner(client kubernetes.Interface, id, configFilePath string, configReloadInterval time.Duration) (controller.Provisioner, error) {
 	volumeService, err := volumeservice.GetVolumeService(configFilePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get volume service: %v", err)
 	}
+	volumeservice.WatchConfig(configFilePath, volumeService, configReloadInterval, wait.NeverStop)
 
 	return &cinderProvisioner{
 		VolumeService: volumeService,
@@ -105,32 +119,39 @@ func (p *cinderProvisioner) getCreateOptions(options controller.VolumeOptions) (
 			availability = v
 		case SmartCloneEnabled:
 			cloneEnabled = true
+		case AdoptionEnabled:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return volumes_v2.CreateOpts{}, fmt.Errorf("invalid value %q of option %q", v, k)
+			}
 		default:
 			return volumes_v2.CreateOpts{}, fmt.Errorf("invalid option %q", k)
 		}
 	}
 
 	sourceVolID := ""
-	if cloneEnabled {
-		if sourcePVCRef, ok := options.PVC.Annotations[CloneRequestAnn]; ok {
-			var ns string
-			parts := strings.SplitN(sourcePVCRef, "/", 2)
-			if len(parts) < 2 {
-				ns = options.PVC.Namespace
-			} else {
-				ns = parts[0]
-			}
-			sourcePVCName := parts[len(parts)-1]
-			sourcePVC, err := p.cb.getPVC(p, ns, sourcePVCName)
-			if err != nil {
-				return volumes_v2.CreateOpts{}, fmt.Errorf("Unable to get PVC %s/%s", ns, sourcePVCName)
-			}
-			if sourceVolID, ok = sourcePVC.Annotations[CinderVolumeIDAnn]; ok {
-				klog.Infof("Requesting clone of cinder volumeID %s", sourceVolID)
-			} else {
-				return volumes_v2.CreateOpts{}, fmt.Errorf("PVC %s/%s missing %s annotation",
-					ns, sourcePVCName, CinderVolumeIDAnn)
-			}
+	sourcePVCRef, err := getSourcePVCRef(options.PVC, cloneEnabled)
+	if err != nil {
+		return volumes_v2.CreateOpts{}, err
+	}
+	if sourcePVCRef != "" {
+		var ns string
+		parts := strings.SplitN(sourcePVCRef, "/", 2)
+		if len(parts) < 2 {
+			ns = options.PVC.Namespace
+		} else {
+			ns = parts[0]
+		}
+		sourcePVCName := parts[len(parts)-1]
+		sourcePVC, err := p.cb.getPVC(p, ns, sourcePVCName)
+		if err != nil {
+			return volumes_v2.CreateOpts{}, fmt.Errorf("Unable to get PVC %s/%s", ns, sourcePVCName)
+		}
+		var ok bool
+		if sourceVolID, ok = sourcePVC.Annotations[CinderVolumeIDAnn]; ok {
+			klog.Infof("Requesting clone of cinder volumeID %s", sourceVolID)
+		} else {
+			return volumes_v2.CreateOpts{}, fmt.Errorf("PVC %s/%s missing %s annotation",
+				ns, sourcePVCName, CinderVolumeIDAnn)
 		}
 	}
 
@@ -143,12 +164,68 @@ func (p *cinderProvisioner) getCreateOptions(options controller.VolumeOptions) (
 	}, nil
 }
 
+// getSourcePVCRef returns the reference of the PVC pvc should be a clone of,
+// either the PVC of its data source or the one of its clone request when
+// cloneEnabled, or "" when pvc is not a clone. The data source PVC is always in
+// the namespace of pvc.
+func getSourcePVCRef(pvc *v1.PersistentVolumeClaim, cloneEnabled bool) (string, error) {
+	if ds := pvc.Spec.DataSource; ds != nil {
+		if ds.Kind != "PersistentVolumeClaim" || (ds.APIGroup != nil && *ds.APIGroup != "") {
+			return "", fmt.Errorf("unsupported data source %s of PVC %s/%s", ds.Kind, pvc.Namespace, pvc.Name)
+		}
+		return ds.Name, nil
+	}
+	if cloneEnabled {
+		return pvc.Annotations[CloneRequestAnn], nil
+	}
+	return "", nil
+}
+
+// adoptionEnabled returns whether the storage class of options allows its
+// PVCs to adopt existing volumes. Only an admin sets the parameters of a
+// storage class, any user creating a PVC can set its annotations.
+func adoptionEnabled(options controller.VolumeOptions) bool {
+	for k, v := range options.Parameters {
+		if strings.ToLower(k) == AdoptionEnabled {
+			enabled, _ := strconv.ParseBool(v)
+			return enabled
+		}
+	}
+	return false
+}
+
+// getAdoptedVolume returns the existing cinder volume volumeID the PVC of
+// options should be bound to, when it is available and large enough.
+func (p *cinderProvisioner) getAdoptedVolume(options controller.VolumeOptions, volumeID string) (*volumes_v2.Volume, error) {
+	if !adoptionEnabled(options) {
+		return nil, fmt.Errorf("storage class of PVC %s/%s does not allow adopting volume %s, set its %q parameter to enable it",
+			options.PVC.Namespace, options.PVC.Name, volumeID, AdoptionEnabled)
+	}
+	volume, err := p.vsb.getCinderVolume(p.VolumeService, volumeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get volume %s to adopt: %v", volumeID, err)
+	}
+	if volume.Status != "available" {
+		return nil, fmt.Errorf("volume %s to adopt is %s, not available", volumeID, volume.Status)
+	}
+	requested := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	capacity := resource.MustParse(fmt.Sprintf("%dGi", volume.Size))
+	if capacity.Cmp(requested) < 0 {
+		return nil, fmt.Errorf("volume %s to adopt is smaller than the %s requested", volumeID, requested.String())
+	}
+	return volume, nil
+}
+
 func (p *cinderProvisioner) annotatePVC(cinderVolID string, pvc *v1.PersistentVolumeClaim, createOptions volumes_v2.CreateOpts) error {
 	annotations := make(map[string]string, 2)
 	annotations[CinderVolumeIDAnn] = cinderVolID
 
 	// Add clone annotation if this is a cloned volume
-	if sourcePVCName, ok := pvc.Annotations[CloneRequestAnn]; ok {
+	if pvc.Spec.DataSource != nil && createOptions.SourceVolID != "" {
+		klog.Infof("Annotating PVC %s/%s as a clone of PVC %s/%s",
+			pvc.Namespace, pvc.Name, pvc.Namespace, pvc.Spec.DataSource.Name)
+		annotations[CloneOfAnn] = pvc.Spec.DataSource.Name
+	} else if sourcePVCName, ok := pvc.Annotations[CloneRequestAnn]; ok {
 		if createOptions.SourceVolID != "" {
 			klog.Infof("Annotating PVC %s/%s as a clone of PVC %s/%s",
 				pvc.Namespace, pvc.Name, pvc.Namespace, sourcePVCName)
@@ -163,6 +240,7 @@ func (p *cinderProvisioner) annotatePVC(cinderVolID string, pvc *v1.PersistentVo
 func (p *cinderProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
 	var (
 		volumeID   string
+		adopted    *volumes_v2.Volume
 		connection volumeservice.VolumeConnection
 		mapper     volumeMapper
 		pv         *v1.PersistentVolume
@@ -179,16 +257,32 @@ func (p *cinderProvisioner) Provision(options controller.VolumeOptions) (*v1.Per
 		klog.Error(err)
 		goto ERROR
 	}
-	volumeID, err = p.vsb.createCinderVolume(p.VolumeService, createOptions)
-	if err != nil {
-		klog.Errorf("Failed to create volume")
-		goto ERROR
-	}
+	if adoptID, ok := options.PVC.Annotations[AdoptVolumeIDAnn]; ok {
+		// The adopted volume is left as it is when the provisioning fails
+		if createOptions.SourceVolID != "" {
+			err = fmt.Errorf("PVC %s/%s can't both adopt volume %s and be a clone", options.PVC.Namespace, options.PVC.Name, adoptID)
+			klog.Error(err)
+			goto ERROR
+		}
+		adopted, err = p.getAdoptedVolume(options, adoptID)
+		if err != nil {
+			klog.Error(err)
+			goto ERROR
+		}
+		volumeID = adopted.ID
+		klog.Infof("Adopting cinder volume %s", volumeID)
+	} else {
+		volumeID, err = p.vsb.createCinderVolume(p.VolumeService, createOptions)
+		if err != nil {
+			klog.Errorf("Failed to create volume")
+			goto ERROR
+		}
 
-	err = p.vsb.waitForAvailableCinderVolume(p.VolumeService, volumeID)
-	if err != nil {
-		klog.Errorf("Volume %s did not become available", volumeID)
-		goto ERROR_DELETE
+		err = p.vsb.waitForAvailableCinderVolume(p.VolumeService, volumeID)
+		if err != nil {
+			klog.Errorf("Volume %s did not become available", volumeID)
+			goto ERROR_DELETE
+		}
 	}
 
 	err = p.vsb.reserveCinderVolume(p.VolumeService, volumeID)
@@ -226,6 +320,13 @@ func (p *cinderProvisioner) Provision(options controller.VolumeOptions) (*v1.Per
 		klog.Errorf("Failed to build PV: %v", err)
 		goto ERROR_DETACH
 	}
+	if adopted != nil {
+		pv.Spec.Capacity = v1.ResourceList{
+			v1.ResourceName(v1.ResourceStorage): resource.MustParse(fmt.Sprintf("%dGi", adopted.Size)),
+		}
+		// The volume is owned by whoever created it, deleting the PVC must not delete it
+		pv.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimRetain
+	}
 
 	err = p.annotatePVC(volumeID, options.PVC, createOptions)
 	if err != nil {
@@ -253,11 +354,13 @@ ERROR_UNRESERVE:
 	}
 	klog.V(3).Infof("Volume %s unreserved", volumeID)
 ERROR_DELETE:
-	cleanupErr = p.vsb.deleteCinderVolume(p.VolumeService, volumeID)
-	if cleanupErr != nil {
-		klog.Errorf("Failed to delete volume %s: %v", volumeID, cleanupErr)
+	if adopted == nil {
+		cleanupErr = p.vsb.deleteCinderVolume(p.VolumeService, volumeID)
+		if cleanupErr != nil {
+			klog.Errorf("Failed to delete volume %s: %v", volumeID, cleanupErr)
+		}
+		klog.V(3).Infof("Volume %s deleted", volumeID)
 	}
-	klog.V(3).Infof("Volume %s deleted", volumeID)
 ERROR:
 	return nil, err // Return the original error
 }
@@ -64,6 +64,19 @@ var _ = Describe("Provisioner", func() {
 			})
 		})
 
+		Context("when the adoption option is not a boolean", func() {
+			BeforeEach(func() {
+				options.Parameters = map[string]string{
+					AdoptionEnabled: "maybe",
+				}
+			})
+
+			It("should fail", func() {
+				Expect(createOptions).To(Equal(volumes_v2.CreateOpts{}))
+				Expect(err).ToNot(BeNil())
+			})
+		})
+
 		Context("when recognized options are used", func() {
 			BeforeEach(func() {
 				options.Parameters = map[string]string{
@@ -140,6 +153,28 @@ var _ = Describe("Provisioner", func() {
 				})
 			})
 		})
+
+		Context("when the PVC has a PVC data source", func() {
+			BeforeEach(func() {
+				options.PVC.Spec.DataSource = &v1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: "srcPVC"}
+				sourcePVC.Annotations[CinderVolumeIDAnn] = sourceVolID
+				cb.srcPVC = sourcePVC
+			})
+			It("should add the source volume to the create options", func() {
+				Expect(err).To(BeNil())
+				Expect(createOptions.SourceVolID).To(Equal(sourceVolID))
+			})
+		})
+
+		Context("when the PVC has a snapshot data source", func() {
+			BeforeEach(func() {
+				apiGroup := "snapshot.storage.k8s.io"
+				options.PVC.Spec.DataSource = &v1.TypedLocalObjectReference{APIGroup: &apiGroup, Kind: "VolumeSnapshot", Name: "snapshot"}
+			})
+			It("should fail", func() {
+				Expect(err).NotTo(BeNil())
+			})
+		})
 	})
 
 	Describe("A provision operation", func() {
@@ -308,6 +343,80 @@ var _ = Describe("Provisioner", func() {
 			})
 		})
 
+		Context("when adopting an existing volume", func() {
+			BeforeEach(func() {
+				options.PVC.Annotations[AdoptVolumeIDAnn] = "existingVolumeID"
+				options.Parameters[AdoptionEnabled] = "true"
+				vsb.volume = &volumes_v2.Volume{ID: "existingVolumeID", Status: "available", Size: 2}
+			})
+
+			It("should return a persistent volume of the existing volume", func() {
+				Expect(err).To(BeNil())
+				Expect(vsb.mightFail.operationLog.String()).To(Equal(""))
+				Expect(options.PVC.Annotations[CinderVolumeIDAnn]).To(Equal("existingVolumeID"))
+				capacity := pv.Spec.Capacity[v1.ResourceStorage]
+				Expect(capacity.String()).To(Equal("2Gi"))
+			})
+
+			It("should retain the volume whatever the reclaim policy of the storage class", func() {
+				Expect(err).To(BeNil())
+				Expect(pv.Spec.PersistentVolumeReclaimPolicy).To(Equal(v1.PersistentVolumeReclaimRetain))
+			})
+
+			Context("when the storage class does not enable adoption", func() {
+				BeforeEach(func() {
+					delete(options.Parameters, AdoptionEnabled)
+				})
+				It("should fail without touching the volume", func() {
+					Expect(pv).To(BeNil())
+					Expect(err).To(Not(BeNil()))
+					Expect(vsb.mightFail.operationLog.String()).To(Equal(""))
+				})
+			})
+
+			Context("when the storage class disables adoption", func() {
+				BeforeEach(func() {
+					options.Parameters[AdoptionEnabled] = "false"
+				})
+				It("should fail", func() {
+					Expect(pv).To(BeNil())
+					Expect(err).To(Not(BeNil()))
+				})
+			})
+
+			Context("when the volume is not available", func() {
+				BeforeEach(func() {
+					vsb.volume.Status = "in-use"
+				})
+				It("should fail", func() {
+					Expect(pv).To(BeNil())
+					Expect(err).To(Not(BeNil()))
+				})
+			})
+
+			Context("when the volume is smaller than requested", func() {
+				BeforeEach(func() {
+					vsb.volume.Size = 0
+				})
+				It("should fail", func() {
+					Expect(pv).To(BeNil())
+					Expect(err).To(Not(BeNil()))
+				})
+			})
+
+			Context("when attaching the volume fails", func() {
+				BeforeEach(func() {
+					vsb.mightFail.set("attachCinderVolume")
+					cleanup = "disconnectCinderVolume.unreserveCinderVolume."
+				})
+				It("should fail and the volume should be disconnected and unreserved but not deleted", func() {
+					Expect(pv).To(BeNil())
+					Expect(err).To(Not(BeNil()))
+					Expect(vsb.mightFail.operationLog.String()).To(Equal(cleanup))
+				})
+			})
+		})
+
 		Context("when a clone is requested", func() {
 			BeforeEach(func() {
 				options.PVC.Annotations[CloneRequestAnn] = "srcPVC"
@@ -445,6 +554,8 @@ var _ = Describe("Provisioner", func() {
 type fakeVolumeServiceBroker struct {
 	mightFail failureInjector
 	volumeServiceBroker
+	// volume is the volume returned by getCinderVolume
+	volume *volumes_v2.Volume
 }
 
 func (vsb *fakeVolumeServiceBroker) createCinderVolume(vs *gophercloud.ServiceClient, options volumes_v2.CreateOpts) (string, error) {
@@ -489,6 +600,13 @@ func (vsb *fakeVolumeServiceBroker) deleteCinderVolume(vs *gophercloud.ServiceCl
 	return vsb.mightFail.logRet("deleteCinderVolume")
 }
 
+func (vsb *fakeVolumeServiceBroker) getCinderVolume(vs *gophercloud.ServiceClient, volumeID string) (*volumes_v2.Volume, error) {
+	if vsb.volume == nil || vsb.volume.ID != volumeID {
+		return nil, errors.New("volume not found")
+	}
+	return vsb.volume, nil
+}
+
 type fakeMapperBroker struct {
 	mightFail        failureInjector
 	FakeVolumeMapper *fakeMapper
@@ -23,6 +23,7 @@ import (
 	"net/http"
 	"os"
 	"reflect"
+	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
@@ -32,6 +33,7 @@ import (
 	"gopkg.in/gcfg.v1"
 
 	openstack_provider "k8s.io/cloud-provider-openstack/pkg/cloudprovider/providers/openstack"
+	"k8s.io/cloud-provider-openstack/pkg/util/configwatch"
 
 	netutil "k8s.io/apimachinery/pkg/util/net"
 	certutil "k8s.io/client-go/util/cert"
@@ -46,7 +48,7 @@ type cinderConfig struct {
 }
 
 func (cfg cinderConfig) toAuthOptions() gophercloud.AuthOptions {
-	return gophercloud.AuthOptions{
+	opts := gophercloud.AuthOptions{
 		IdentityEndpoint: cfg.Global.AuthURL,
 		Username:         cfg.Global.Username,
 		UserID:           cfg.Global.UserID,
@@ -56,9 +58,19 @@ func (cfg cinderConfig) toAuthOptions() gophercloud.AuthOptions {
 		DomainID:         cfg.Global.DomainID,
 		DomainName:       cfg.Global.DomainName,
 
+		ApplicationCredentialID:     cfg.Global.ApplicationCredentialID,
+		ApplicationCredentialName:   cfg.Global.ApplicationCredentialName,
+		ApplicationCredentialSecret: cfg.Global.ApplicationCredentialSecret,
+
 		// Persistent service, so we need to be able to renew tokens.
 		AllowReauth: true,
 	}
+	if cfg.Global.ApplicationCredentialSecret != "" {
+		// Keystone refuses a scope along with an application credential
+		opts.TenantID = ""
+		opts.TenantName = ""
+	}
+	return opts
 }
 
 func (cfg cinderConfig) toAuth3Options() tokens3.AuthOptions {
@@ -82,6 +94,9 @@ func getConfigFromEnv() cinderConfig {
 	cfg.Global.Region = os.Getenv("OS_REGION_NAME")
 	cfg.Global.UserID = os.Getenv("OS_USER_ID")
 	cfg.Global.TrustID = os.Getenv("OS_TRUST_ID")
+	cfg.Global.ApplicationCredentialID = os.Getenv("OS_APPLICATION_CREDENTIAL_ID")
+	cfg.Global.ApplicationCredentialName = os.Getenv("OS_APPLICATION_CREDENTIAL_NAME")
+	cfg.Global.ApplicationCredentialSecret = os.Getenv("OS_APPLICATION_CREDENTIAL_SECRET")
 
 	cfg.Global.TenantID = os.Getenv("OS_TENANT_ID")
 	if cfg.Global.TenantID == "" {
@@ -148,18 +163,7 @@ func getKeystoneVolumeService(cfg cinderConfig) (*gophercloud.ServiceClient, err
 		provider.HTTPClient.Transport = netutil.SetOldTransportDefaults(&http.Transport{TLSClientConfig: config})
 
 	}
-	if cfg.Global.TrustID != "" {
-		opts := cfg.toAuth3Options()
-		authOptsExt := trusts.AuthOptsExt{
-			TrustID:            cfg.Global.TrustID,
-			AuthOptionsBuilder: &opts,
-		}
-		err = openstack.AuthenticateV3(provider, authOptsExt, gophercloud.EndpointOpts{})
-	} else {
-		err = openstack.Authenticate(provider, cfg.toAuthOptions())
-	}
-
-	if err != nil {
+	if err := authenticate(provider, cfg); err != nil {
 		return nil, err
 	}
 
@@ -173,6 +177,23 @@ func getKeystoneVolumeService(cfg cinderConfig) (*gophercloud.ServiceClient, err
 	return volumeService, nil
 }
 
+// authenticate authenticates provider with the credentials of the [Global]
+// section of cfg.
+func authenticate(provider *gophercloud.ProviderClient, cfg cinderConfig) error {
+	if cfg.Global.TrustID != "" && cfg.Global.ApplicationCredentialSecret != "" {
+		return fmt.Errorf("trust-id cannot be used with an application credential")
+	}
+	if cfg.Global.TrustID != "" {
+		opts := cfg.toAuth3Options()
+		authOptsExt := trusts.AuthOptsExt{
+			TrustID:            cfg.Global.TrustID,
+			AuthOptionsBuilder: &opts,
+		}
+		return openstack.AuthenticateV3(provider, authOptsExt, gophercloud.EndpointOpts{})
+	}
+	return openstack.Authenticate(provider, cfg.toAuthOptions())
+}
+
 func getNoAuthVolumeService(cfg cinderConfig) (*gophercloud.ServiceClient, error) {
 	provider, err := noauth.NewClient(gophercloud.AuthOptions{
 		Username:   cfg.Global.Username,
@@ -205,3 +226,25 @@ func GetVolumeService(configFilePath string) (*gophercloud.ServiceClient, error)
 	}
 	return getKeystoneVolumeService(config)
 }
+
+// WatchConfig checks the config file at configFilePath for changes every
+// interval, until stop is closed, and reauthenticates volumeService with the
+// new credentials then, e.g. when the Secret it is mounted from is rotated.
+// The other settings are only read at startup. Nothing is watched for a
+// config from the environment or without Keystone.
+func WatchConfig(configFilePath string, volumeService *gophercloud.ServiceClient, interval time.Duration, stop <-chan struct{}) {
+	if configFilePath == "" || interval <= 0 || volumeService.ProviderClient.IdentityEndpoint == "" {
+		return
+	}
+	configwatch.Watch(interval, stop, []string{configFilePath}, func() error {
+		config, err := getConfig(configFilePath)
+		if err != nil {
+			return err
+		}
+		if err := authenticate(volumeService.ProviderClient, config); err != nil {
+			return err
+		}
+		klog.Infof("Reloaded the OpenStack credentials of %s", configFilePath)
+		return nil
+	})
+}
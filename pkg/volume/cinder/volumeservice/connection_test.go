@@ -59,6 +59,30 @@ func TestGetConfigFromEnv(t *testing.T) {
 	assert.Equal(cfg.Global.Region, fakeRegion)
 }
 
+// Test an application credential from env
+func TestGetConfigFromEnvApplicationCredential(t *testing.T) {
+	env := clearEnviron(t)
+	defer resetEnviron(t, env)
+
+	os.Setenv("OS_AUTH_URL", fakeAuthUrl)
+	os.Setenv("OS_TENANT_ID", fakeTenantID)
+	os.Setenv("OS_APPLICATION_CREDENTIAL_ID", "appcred")
+	os.Setenv("OS_APPLICATION_CREDENTIAL_SECRET", "secret")
+	defer os.Unsetenv("OS_APPLICATION_CREDENTIAL_ID")
+	defer os.Unsetenv("OS_APPLICATION_CREDENTIAL_SECRET")
+
+	assert := assert.New(t)
+
+	cfg, err := getConfig("")
+	assert.Nil(err)
+
+	opts := cfg.toAuthOptions()
+	assert.Equal("appcred", opts.ApplicationCredentialID)
+	assert.Equal("secret", opts.ApplicationCredentialSecret)
+	// The application credential is scoped already
+	assert.Equal("", opts.TenantID)
+}
+
 func clearEnviron(t *testing.T) []string {
 	env := os.Environ()
 	for _, pair := range env {